@@ -1,169 +1,297 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"crypto/x509"
 	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
 	"time"
 
+	"github.com/getgrowly/vault-utils/pkg/backup"
+	"github.com/getgrowly/vault-utils/pkg/cli"
 	"github.com/getgrowly/vault-utils/pkg/config"
+	"github.com/getgrowly/vault-utils/pkg/controller"
+	"github.com/getgrowly/vault-utils/pkg/hooks"
+	"github.com/getgrowly/vault-utils/pkg/httplog"
+	"github.com/getgrowly/vault-utils/pkg/keyprovider"
 	"github.com/getgrowly/vault-utils/pkg/kubernetes"
+	"github.com/getgrowly/vault-utils/pkg/metrics"
+	"github.com/getgrowly/vault-utils/pkg/notify"
+	"github.com/getgrowly/vault-utils/pkg/render"
 	"github.com/getgrowly/vault-utils/pkg/server"
+	"github.com/getgrowly/vault-utils/pkg/state"
 	"github.com/getgrowly/vault-utils/pkg/vault"
-	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"github.com/getgrowly/vault-utils/pkg/workload"
 )
 
+// notifyFlushTimeout bounds the wait for pending notifications on shutdown, well within the
+// default termination grace period of a pod
+const notifyFlushTimeout = 10 * time.Second
+
 func init() {
 	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
 }
 
-func initializeVault(vaultClient *vault.Client, kubeClient *kubernetes.Client, config *config.Config) error {
-	resp, err := vaultClient.Initialize()
-	if err != nil {
-		return fmt.Errorf("error initializing Vault: %v", err)
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), shutdownSignals...)
+	defer stop()
+
+	// Any argument other than "controller" selects a CLI command
+	if len(os.Args) > 1 && os.Args[1] != "controller" {
+		code := cli.Run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+		stop()
+		os.Exit(code)
 	}
 
-	rootTokenSecret := &corev1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      vault.RootTokenSecret,
-			Namespace: config.VaultNamespace,
-		},
-		Data: map[string][]byte{
-			"token": []byte(resp.RootToken),
-		},
-	}
+	runController(ctx)
+}
 
-	// Try to update existing secret first, if it fails create a new one
-	if err := kubeClient.UpdateSecret(rootTokenSecret); err != nil {
-		if err := kubeClient.CreateSecret(rootTokenSecret); err != nil {
-			return fmt.Errorf("error storing root token: %v", err)
-		}
+func runController(ctx context.Context) {
+	cfg := config.LoadConfig()
+	httplog.SetEnabled(cfg.Debug())
+	log.Printf("Starting Vault auto-unseal controller with config: namespace=%s, port=%s, interval=%v, preset=%s, profile=%s",
+		cfg.VaultNamespace, cfg.VaultPort, cfg.CheckInterval, cfg.DiscoveryPreset, cfg.Profile)
+
+	warnings, err := cfg.Validate()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
 	}
+	for _, warning := range warnings {
+		log.Printf("Warning: %s", warning)
+	}
+
+	metrics.SetConstLabels(map[string]string{"cluster": cfg.MetricsCluster, "namespace": cfg.VaultNamespace})
 
-	unsealKeys := make(map[string][]byte)
-	for i, key := range resp.Keys {
-		unsealKeys[fmt.Sprintf("key%d", i+1)] = []byte(key)
+	k8sClient, err := kubernetes.NewClient()
+	if err != nil {
+		log.Fatalf("Error creating Kubernetes client: %v", err)
 	}
+	k8sClient.SetPodSelectors(cfg.PodSelectors()...)
 
-	unsealKeysSecret := &corev1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      vault.UnsealKeysSecret,
-			Namespace: config.VaultNamespace,
-		},
-		Data: unsealKeys,
+	notifier, err := notify.New(cfg.NotifyWebhookURL, cfg.NotifyExec)
+	if err != nil {
+		log.Fatalf("Error configuring notifications: %v", err)
 	}
+	ctrl := controller.New(k8sClient, cfg, notifier)
 
-	// Try to update existing secret first, if it fails create a new one
-	if err := kubeClient.UpdateSecret(unsealKeysSecret); err != nil {
-		if err := kubeClient.CreateSecret(unsealKeysSecret); err != nil {
-			return fmt.Errorf("error storing unseal keys: %v", err)
+	if cfg.VaultExternalURL != "" {
+		externalClient, err := newExternalClient(cfg, k8sClient, ctrl.ClientCertificate())
+		if err != nil {
+			log.Fatalf("Error creating Vault client for %s: %v", cfg.VaultExternalURL, err)
 		}
+		ctrl.SetExternalClient(externalClient)
+		log.Printf("Reaching Vault through %s instead of pod IPs", cfg.VaultExternalURL)
+	}
+	if cfg.VaultClientBackend == config.ClientBackendOfficial {
+		log.Printf("Sending the requests to Vault through the official Vault API client")
 	}
 
-	log.Printf("Successfully initialized Vault and stored secrets")
-
-	return nil
-}
-
-func unsealVault(vaultClient *vault.Client, kubeClient *kubernetes.Client, config *config.Config) error {
-	unsealSecret, err := kubeClient.GetSecret(config.VaultNamespace, vault.UnsealKeysSecret)
+	actionHooks, err := hooks.Parse(map[hooks.Phase]string{
+		hooks.PreInit:    cfg.HookPreInit,
+		hooks.PostInit:   cfg.HookPostInit,
+		hooks.PreUnseal:  cfg.HookPreUnseal,
+		hooks.PostUnseal: cfg.HookPostUnseal,
+	}, k8sClient)
 	if err != nil {
-		return fmt.Errorf("error getting unseal keys secret: %v", err)
+		log.Fatalf("Error parsing action hooks: %v", err)
 	}
+	ctrl.SetHooks(actionHooks)
 
-	// Sort keys to ensure consistent order
-	var keys []string
-	for i := 1; i <= len(unsealSecret.Data); i++ {
-		key := fmt.Sprintf("key%d", i)
-		if keyData, exists := unsealSecret.Data[key]; exists {
-			keys = append(keys, string(keyData))
+	if cfg.WorkloadIdentityTokenFile != "" {
+		claims, err := workload.ReadToken(cfg.WorkloadIdentityTokenFile)
+		if err != nil {
+			log.Fatalf("Error reading workload identity token: %v", err)
 		}
+		exported, err := workload.Export(cfg.WorkloadIdentityTokenFile)
+		if err != nil {
+			log.Fatalf("Error exporting workload identity token: %v", err)
+		}
+		ctrl.SetIdentityToken(cfg.WorkloadIdentityTokenFile)
+		log.Printf("Using workload identity %s through %s", claims, strings.Join(exported, ", "))
 	}
 
-	if len(keys) == 0 {
-		return fmt.Errorf("no unseal keys found in secret")
+	if cfg.KeyProvider != "" {
+		provider, err := keyprovider.Parse(cfg.KeyProvider)
+		if err != nil {
+			log.Fatalf("Error loading key provider: %v", err)
+		}
+		ctrl.SetKeyProvider(provider)
+		log.Printf("Storing unseal keys with %s", provider)
 	}
 
-	// Try unsealing with each key
-	for _, key := range keys {
-		if unsealErr := vaultClient.UnsealWithKey(key); unsealErr != nil {
-			log.Printf("Warning: Failed to unseal with key: %v", unsealErr)
-			continue
+	// The store also serves restores through the admin API, with or without scheduled backups
+	if cfg.BackupS3Bucket != "" {
+		credentials, err := backup.DefaultCredentials(cfg.BackupS3Region)
+		if err != nil {
+			log.Fatalf("Error configuring raft snapshot backups: %v", err)
+		}
+		store := &backup.S3{
+			Bucket:      cfg.BackupS3Bucket,
+			Region:      cfg.BackupS3Region,
+			Endpoint:    cfg.BackupS3Endpoint,
+			PathStyle:   cfg.BackupS3PathStyle,
+			SSE:         cfg.BackupS3SSE,
+			SSEKMSKeyID: cfg.BackupS3SSEKMSKeyID,
+			Credentials: credentials,
+		}
+		ctrl.SetBackupStore(store)
+		if cfg.BackupEncryptionKMSKeyID != "" {
+			keys := &backup.KMS{KeyID: cfg.BackupEncryptionKMSKeyID, Region: cfg.BackupS3Region, Credentials: credentials}
+			ctrl.SetBackupEncryption(keys)
+			log.Printf("Encrypting raft snapshots with %s", keys)
+		}
+		if cfg.BackupInterval > 0 {
+			log.Printf("Backing up raft snapshots to %s every %v", store, cfg.BackupInterval)
 		}
 	}
 
-	// Check final status
-	status, err := vaultClient.CheckStatus()
-	if err != nil {
-		return fmt.Errorf("error checking final status: %v", err)
+	if len(cfg.InitPGPKeys) > 0 || cfg.InitRootTokenPGPKey != "" {
+		pgpKeys := make([]string, 0, len(cfg.InitPGPKeys))
+		for _, path := range cfg.InitPGPKeys {
+			key, err := vault.ReadPGPKey(path)
+			if err != nil {
+				log.Fatalf("Error loading init PGP keys: %v", err)
+			}
+			pgpKeys = append(pgpKeys, key)
+		}
+		var rootTokenKey string
+		if cfg.InitRootTokenPGPKey != "" {
+			key, err := vault.ReadPGPKey(cfg.InitRootTokenPGPKey)
+			if err != nil {
+				log.Fatalf("Error loading root token PGP key: %v", err)
+			}
+			rootTokenKey = key
+		}
+		ctrl.SetInitPGPKeys(pgpKeys, rootTokenKey)
+		log.Printf("Encrypting the keys handed out at init to %d PGP keys, and the root token: %t", len(pgpKeys), rootTokenKey != "")
 	}
 
-	if status.Sealed {
-		return fmt.Errorf("vault is still sealed after attempting to unseal")
+	if cfg.StateFile != "" {
+		stateFile, err := state.Open(cfg.StateFile, cfg.StateKeyFile)
+		if err != nil {
+			log.Fatalf("Error opening state file: %v", err)
+		}
+		if err := ctrl.SetStateFile(stateFile); err != nil {
+			log.Fatalf("Error loading state file: %v", err)
+		}
+		log.Printf("Keeping controller state in %s", cfg.StateFile)
 	}
 
-	return nil
-}
+	if cfg.KeyCacheFile != "" {
+		keyCache, err := state.Open(cfg.KeyCacheFile, cfg.KeyCacheKeyFile)
+		if err != nil {
+			log.Fatalf("Error opening key cache: %v", err)
+		}
+		if err := ctrl.SetKeyCache(keyCache); err != nil {
+			log.Fatalf("Error loading key cache: %v", err)
+		}
+		log.Printf("Caching the unseal keys in %s", cfg.KeyCacheFile)
+	}
 
-func main() {
-	cfg := config.LoadConfig()
-	log.Printf("Starting Vault auto-unseal controller with config: namespace=%s, port=%s, interval=%v",
-		cfg.VaultNamespace, cfg.VaultPort, cfg.CheckInterval)
+	if cfg.RenderTemplatesDir != "" {
+		renderer, err := render.Load(cfg.RenderTemplatesDir)
+		if err != nil {
+			log.Fatalf("Error loading render templates: %v", err)
+		}
+		ctrl.SetRenderer(renderer)
+	}
 
-	k8sClient, err := kubernetes.NewClient()
-	if err != nil {
-		log.Fatalf("Error creating Kubernetes client: %v", err)
+	if cfg.AuditLogListen != "" {
+		listener, err := net.Listen("tcp", cfg.AuditLogListen)
+		if err != nil {
+			log.Fatalf("Error listening for the audit log: %v", err)
+		}
+		ctrl.SetAuditLogListener(listener)
+		log.Printf("Receiving the audit log of socket audit devices on %s", cfg.AuditLogListen)
+	}
+	if cfg.AuditLogFile != "" {
+		log.Printf("Following the audit log %s", cfg.AuditLogFile)
 	}
 
-	srv := server.NewServer(k8sClient, "8080")
+	srv := server.NewServer("8080", notifier, ctrl.Status())
+	srv.SetReadinessCheck(ctrl.CheckReady)
+	srv.SetReadyCacheTTL(cfg.ReadyCacheTTL)
+	srv.SetTimeouts(server.Timeouts{Ready: cfg.ReadyTimeout, Status: cfg.StatusTimeout, Restore: cfg.BackupTimeout})
+	srv.SetAccessLog(server.AccessLog{SamplePercent: cfg.AccessLogSamplePercent, Probes: cfg.AccessLogProbes})
+	if cfg.ReadyFromReconcile {
+		srv.SetReadyFromStatus(cfg.ReadyMaxStaleness)
+		log.Printf("Answering /ready from the reconcile loop, trusting passes up to %v old", cfg.ReadyMaxStaleness)
+	}
+	if cfg.BackupS3Bucket != "" {
+		srv.SetBackupCatalog(ctrl.BackupCatalog)
+	}
+	if cfg.EventReceiver {
+		var token string
+		if cfg.EventReceiverTokenFile != "" {
+			tokenBytes, err := os.ReadFile(cfg.EventReceiverTokenFile)
+			if err != nil {
+				log.Fatalf("Error reading event receiver token: %v", err)
+			}
+			token = strings.TrimSpace(string(tokenBytes))
+		}
+		srv.SetEventReceiver(ctrl.ReportEvent, token)
+		log.Printf("Receiving Vault pod events on /events")
+	}
+	if cfg.AdminAPI {
+		tokenBytes, err := os.ReadFile(cfg.AdminAPITokenFile)
+		if err != nil {
+			log.Fatalf("Error reading admin API token: %v", err)
+		}
+		token := strings.TrimSpace(string(tokenBytes))
+		if token == "" {
+			log.Fatalf("Admin API token file %s is empty", cfg.AdminAPITokenFile)
+		}
+		srv.SetAdminAPI(ctrl, token)
+		srv.SetAdminLimits(server.AdminLimits{
+			RatePerMinute: cfg.AdminRateLimit,
+			Burst:         cfg.AdminRateBurst,
+			MaxConcurrent: cfg.AdminMaxConcurrent,
+		})
+		log.Printf("Serving the admin API on /admin")
+	}
 	go func() {
 		if err := srv.Start(); err != nil {
 			log.Fatalf("Failed to start HTTP server: %v", err)
 		}
 	}()
 
-	for {
-		pods, err := k8sClient.GetVaultPods(cfg.VaultNamespace)
-		if err != nil {
-			log.Printf("Error getting Vault pods: %v", err)
-
-			continue
-		}
+	ctrl.Run(ctx)
 
-		if len(pods) == 0 {
-			log.Printf("No Vault pods found")
-
-			continue
+	// Give notifications sent on the way out, such as the shutdown report, a chance to be delivered
+	if queue, ok := notifier.(*notify.Queue); ok {
+		flushCtx, cancel := context.WithTimeout(context.Background(), notifyFlushTimeout)
+		defer cancel()
+		if err := queue.Flush(flushCtx); err != nil {
+			log.Printf("Warning: exiting before every notification was delivered: %v", err)
 		}
+	}
+}
 
-		for _, pod := range pods {
-			vaultAddr := fmt.Sprintf("http://%s:%s", pod, cfg.VaultPort)
-			vaultClient := vault.NewClient(vaultAddr)
-
-			status, err := vaultClient.CheckStatus()
-			if err != nil {
-				log.Printf("Error checking Vault status for pod %s: %v", pod, err)
-
-				continue
-			}
-
-			if !status.Initialized {
-				if err := initializeVault(vaultClient, k8sClient, cfg); err != nil {
-					log.Printf("Error initializing Vault for pod %s: %v", pod, err)
-
-					continue
-				}
-			}
-
-			if status.Sealed {
-				if err := unsealVault(vaultClient, k8sClient, cfg); err != nil {
-					log.Printf("Error unsealing Vault for pod %s: %v", pod, err)
+// newExternalClient creates the client for VAULT_EXTERNAL_URL, trusting the CA bundle from
+// VAULT_CACERT or VAULT_CACERT_FROM when set and presenting clientCert when not nil
+func newExternalClient(cfg *config.Config, k8sClient *kubernetes.Client, clientCert *vault.ClientCertificate) (*vault.Client, error) {
+	caCert, err := controller.ReadVaultCA(k8sClient, cfg)
+	if err != nil {
+		return nil, err
+	}
 
-					continue
-				}
-			}
+	var roots *x509.CertPool
+	if caCert != nil {
+		certs, err := vault.ParseCABundle(caCert)
+		if err != nil {
+			return nil, err
 		}
+		roots = vault.NewCertPool(certs)
+	}
 
-		time.Sleep(cfg.CheckInterval)
+	client, err := vault.NewExternalClient(cfg.VaultExternalURL, vault.NewTLSConfig(roots, cfg.VaultTLSServerName, clientCert), controller.TransportOptions(cfg))
+	if err != nil {
+		return nil, err
 	}
+	client.SetRetryPolicy(controller.RetryPolicy(cfg))
+
+	return client, nil
 }
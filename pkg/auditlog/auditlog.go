@@ -0,0 +1,248 @@
+// Package auditlog reads Vault audit logs, as sent by a socket audit device or written by a file
+// audit device, to spot seals and step-downs as they happen rather than on the next check
+package auditlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"time"
+)
+
+// Kinds of the events found in audit logs
+const (
+	Sealed      = "sealed"
+	SteppedDown = "stepped_down"
+)
+
+// maxEntrySize bounds an audit entry kept in memory. Longer entries, such as responses listing
+// many keys, are skipped, since seals and step-downs are short.
+const maxEntrySize = 1 << 20
+
+// eventPaths are the API paths whose successful requests are events
+var eventPaths = map[string]string{
+	"sys/seal":      Sealed,
+	"sys/step-down": SteppedDown,
+}
+
+// Event is a seal or step-down recorded in an audit log
+type Event struct {
+	Kind string
+	// Path is the API path requested, such as sys/seal
+	Path string
+	Time time.Time
+}
+
+// Parse returns the event an audit entry records, if it is the response to a successful seal or
+// step-down. Requests are left out, since they are logged before Vault acts and may fail.
+func Parse(entry []byte) (Event, bool) {
+	var parsed struct {
+		Type    string    `json:"type"`
+		Time    time.Time `json:"time"`
+		Error   string    `json:"error"`
+		Request struct {
+			Operation string `json:"operation"`
+			Path      string `json:"path"`
+		} `json:"request"`
+	}
+	if err := json.Unmarshal(entry, &parsed); err != nil {
+		return Event{}, false
+	}
+	kind, ok := eventPaths[parsed.Request.Path]
+	if !ok || parsed.Type != "response" || parsed.Error != "" || parsed.Request.Operation != "update" {
+		return Event{}, false
+	}
+
+	return Event{Kind: kind, Path: parsed.Request.Path, Time: parsed.Time}, true
+}
+
+// Serve accepts the connections of socket audit devices on listener until ctx is cancelled, and
+// calls handle with the host of the sender of every event. Vault keeps a connection open and
+// sends an entry per line. handle is called from one goroutine per connection.
+func Serve(ctx context.Context, listener net.Listener, handle func(host string, event Event)) {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("Warning: failed to accept an audit log connection: %v", err)
+			time.Sleep(time.Second)
+
+			continue
+		}
+
+		go func() {
+			// Closed on cancellation too, to stop the read
+			stop := context.AfterFunc(ctx, func() { conn.Close() })
+			defer stop()
+			defer conn.Close()
+
+			host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+			if err != nil {
+				host = conn.RemoteAddr().String()
+			}
+			err = Read(conn, func(event Event) { handle(host, event) })
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Warning: audit log connection from %s failed: %v", host, err)
+			}
+		}()
+	}
+}
+
+// Read calls handle with the events of the audit entries read from r, one per line, until r
+// ends
+func Read(r io.Reader, handle func(Event)) error {
+	var lines lineSplitter
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := r.Read(buf)
+		lines.feed(buf[:n], func(line []byte) {
+			if event, ok := Parse(line); ok {
+				handle(event)
+			}
+		})
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Tail follows the audit log file at path from its current end until ctx is cancelled, as
+// tail -F does, and calls handle with the events of the entries appended. It looks for new
+// entries every poll. A file that was rotated or truncated is read again from its start, and one
+// that does not exist yet is waited for.
+func Tail(ctx context.Context, path string, poll time.Duration, handle func(Event)) {
+	var file *os.File
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
+	var lines lineSplitter
+	emit := func(line []byte) {
+		if event, ok := Parse(line); ok {
+			handle(event)
+		}
+	}
+	buf := make([]byte, 64<<10)
+	fromEnd := true
+	missing := false
+	for {
+		if file == nil {
+			opened, err := os.Open(path)
+			switch {
+			case err != nil:
+				if !missing {
+					log.Printf("Warning: waiting for the audit log %s: %v", path, err)
+					missing = true
+				}
+			case fromEnd:
+				// Entries written before the controller started are old news
+				if _, err := opened.Seek(0, io.SeekEnd); err != nil {
+					opened.Close()
+					log.Printf("Warning: failed to seek to the end of the audit log %s: %v", path, err)
+				} else {
+					file = opened
+				}
+			default:
+				file = opened
+			}
+			if file != nil {
+				missing = false
+				lines = lineSplitter{}
+			}
+			fromEnd = false
+		}
+
+		if file != nil {
+			for {
+				n, err := file.Read(buf)
+				lines.feed(buf[:n], emit)
+				if err != nil || n == 0 {
+					break
+				}
+			}
+			if reopen, truncated := rotated(file, path); reopen {
+				file.Close()
+				file = nil
+			} else if truncated {
+				if _, err := file.Seek(0, io.SeekStart); err == nil {
+					lines = lineSplitter{}
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(poll):
+		}
+	}
+}
+
+// rotated reports whether path is no longer the file being read, or was truncated below the
+// position it is read from
+func rotated(file *os.File, path string) (reopen, truncated bool) {
+	current, err := os.Stat(path)
+	if err != nil {
+		// Removed, and maybe about to be created again
+		return true, false
+	}
+	open, err := file.Stat()
+	if err != nil || !os.SameFile(open, current) {
+		return true, false
+	}
+	offset, err := file.Seek(0, io.SeekCurrent)
+
+	return false, err == nil && current.Size() < offset
+}
+
+// lineSplitter collects the lines of a stream fed in pieces, skipping those longer than
+// maxEntrySize
+type lineSplitter struct {
+	pending  []byte
+	skipping bool
+}
+
+// feed adds data to the stream and calls emit with every line it completes
+func (s *lineSplitter) feed(data []byte, emit func(line []byte)) {
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			if !s.skipping {
+				s.pending = append(s.pending, data...)
+				if len(s.pending) > maxEntrySize {
+					s.pending = nil
+					s.skipping = true
+				}
+			}
+
+			return
+		}
+
+		if !s.skipping {
+			line := append(s.pending, data[:end]...)
+			if len(line) <= maxEntrySize {
+				emit(line)
+			}
+		}
+		s.pending = s.pending[:0]
+		s.skipping = false
+		data = data[end+1:]
+	}
+}
@@ -0,0 +1,158 @@
+package auditlog
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// entry returns an audit entry of type for a request to path, failed with errMessage when set
+func entry(entryType, path, errMessage string) string {
+	return fmt.Sprintf(`{"time":"2024-01-01T00:00:00Z","type":%q,"auth":{"display_name":"root"},"request":{"id":"1","operation":"update","path":%q},"error":%q}`,
+		entryType, path, errMessage) + "\n"
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		entry    string
+		expected string
+	}{
+		{name: "seal", entry: entry("response", "sys/seal", ""), expected: Sealed},
+		{name: "step-down", entry: entry("response", "sys/step-down", ""), expected: SteppedDown},
+		{name: "request before the seal", entry: entry("request", "sys/seal", "")},
+		{name: "failed seal", entry: entry("response", "sys/seal", "permission denied")},
+		{name: "other path", entry: entry("response", "secret/data/app", "")},
+		{name: "read of the seal status", entry: `{"type":"response","request":{"operation":"read","path":"sys/seal"}}`},
+		{name: "not JSON", entry: "<entry/>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, ok := Parse([]byte(tt.entry))
+			if ok != (tt.expected != "") || event.Kind != tt.expected {
+				t.Errorf("expected event %q, got %+v (%v)", tt.expected, event, ok)
+			}
+			if ok && !event.Time.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+				t.Errorf("expected the time of the entry, got %v", event.Time)
+			}
+		})
+	}
+}
+
+// recorder collects events, from any goroutine
+type recorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recorder) record(source string, event Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = append(r.events, source+" "+event.Kind)
+}
+
+func (r *recorder) recorded() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string(nil), r.events...)
+}
+
+func waitFor(t *testing.T, r *recorder, expected ...string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for strings.Join(r.recorded(), ",") != strings.Join(expected, ",") {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected events %v, got %v", expected, r.recorded())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServe(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := &recorder{}
+	go Serve(ctx, listener, events.record)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	// An entry too long to keep is skipped, and one split across writes is put back together
+	seal := entry("response", "sys/seal", "")
+	fmt.Fprintf(conn, `{"type":"response","request":{"path":"sys/seal"},"data":"%s"}`+"\n", strings.Repeat("x", maxEntrySize))
+	fmt.Fprint(conn, entry("request", "sys/seal", ""))
+	fmt.Fprint(conn, seal[:20])
+	time.Sleep(10 * time.Millisecond)
+	fmt.Fprint(conn, seal[20:])
+	fmt.Fprint(conn, entry("response", "sys/step-down", ""))
+
+	waitFor(t, events, "127.0.0.1 sealed", "127.0.0.1 stepped_down")
+}
+
+func TestTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	if err := os.WriteFile(path, []byte(entry("response", "sys/seal", "")), 0o600); err != nil {
+		t.Fatalf("failed to write the log: %v", err)
+	}
+	appendEntry := func(e string) {
+		t.Helper()
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			t.Fatalf("failed to open the log: %v", err)
+		}
+		defer file.Close()
+		if _, err := file.WriteString(e); err != nil {
+			t.Fatalf("failed to append to the log: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := &recorder{}
+	tailing := make(chan struct{})
+	go func() {
+		Tail(ctx, path, 10*time.Millisecond, func(event Event) { events.record("file", event) })
+		close(tailing)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// The seal logged before the tail started is not reported
+	appendEntry(entry("response", "sys/step-down", ""))
+	waitFor(t, events, "file stepped_down")
+
+	// A rotated log is read from its start
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatalf("failed to rotate the log: %v", err)
+	}
+	appendEntry(entry("response", "sys/seal", ""))
+	waitFor(t, events, "file stepped_down", "file sealed")
+
+	// So is a truncated one
+	appendEntry(entry("request", "sys/seal", ""))
+	time.Sleep(50 * time.Millisecond)
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatalf("failed to truncate the log: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	appendEntry(entry("response", "sys/step-down", ""))
+	waitFor(t, events, "file stepped_down", "file sealed", "file stepped_down")
+
+	cancel()
+	<-tailing
+}
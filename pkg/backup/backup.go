@@ -0,0 +1,605 @@
+// Package backup uploads raft snapshots of Vault to object storage, records them in a catalog,
+// prunes the ones a retention policy no longer keeps, and downloads them again to restore one.
+// Snapshots can be encrypted before they leave the controller. S3 and S3-compatible stores are
+// supported, with requests signed by AWS Signature Version 4 and no AWS SDK.
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Store keeps uploaded snapshots
+type Store interface {
+	// Put uploads body under key as it is read, and returns the number of bytes uploaded. An
+	// error reading body fails the upload, and leaves nothing under key.
+	Put(ctx context.Context, key string, body io.Reader) (int64, error)
+	// Get downloads what is stored under key. The caller closes it.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// List returns the objects whose key starts with prefix
+	List(ctx context.Context, prefix string) ([]Object, error)
+	// Delete removes what is stored under key
+	Delete(ctx context.Context, key string) error
+	String() string
+}
+
+// Object is an object of a Store
+type Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// defaultPartSize makes multipart uploads of up to 78 GiB
+const defaultPartSize = 8 << 20
+
+// maxParts is the most parts S3 accepts in a multipart upload
+const maxParts = 10000
+
+// maxAttempts is how often a request is sent before a transfer fails: a part, or a whole small
+// snapshot, is uploaded again from memory, and a download is resumed where it stopped
+const maxAttempts = 3
+
+// defaultRetryDelay is the wait before the first retry, doubled for every further one
+const defaultRetryDelay = time.Second
+
+// Server-side encryption modes of S3
+const (
+	SSES3  = "AES256"
+	SSEKMS = "aws:kms"
+)
+
+// S3 is a Store uploading to an S3 bucket
+type S3 struct {
+	// Bucket is the name of the bucket, and Region where it lives
+	Bucket string
+	Region string
+	// Endpoint is the base URL of the S3 API, the regional AWS endpoint when empty. Set it for
+	// S3-compatible stores such as MinIO.
+	Endpoint string
+	// PathStyle addresses the bucket in the path rather than the host name, which most
+	// S3-compatible stores need
+	PathStyle bool
+	// SSE is the server-side encryption asked for, SSES3 or SSEKMS, or the bucket's default
+	// when empty. SSEKMSKeyID is the KMS key of SSEKMS, the AWS managed key when empty.
+	SSE         string
+	SSEKMSKeyID string
+	// PartSize is the size of the parts of multipart uploads, which is also the most Put holds
+	// in memory, defaultPartSize when 0. S3 wants parts of 5 MiB at least, and 10,000 at most.
+	PartSize    int
+	Credentials CredentialsSource
+	HTTPClient  *http.Client
+	// now returns the signing time, and retryDelay replaces defaultRetryDelay, for tests
+	now        func() time.Time
+	retryDelay time.Duration
+}
+
+// String implements Store
+func (s *S3) String() string {
+	return "s3://" + s.Bucket
+}
+
+// Put implements Store. The body is read a part at a time, so snapshots need neither a
+// temporary file nor their whole size in memory: a body that fits in one part is sent in a single
+// request, and a larger one as a multipart upload, aborted when it fails.
+func (s *S3) Put(ctx context.Context, key string, body io.Reader) (int64, error) {
+	part := make([]byte, s.partSize())
+	n, err := io.ReadFull(body, part)
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		return int64(n), s.putObject(ctx, key, part[:n])
+	case err != nil:
+		return 0, fmt.Errorf("failed to read what to upload to s3://%s/%s: %v", s.Bucket, key, err)
+	}
+
+	return s.putMultipart(ctx, key, part, body)
+}
+
+// putObject uploads data in a single request
+func (s *S3) putObject(ctx context.Context, key string, data []byte) error {
+	payloadHash := hashHex(data)
+
+	return s.retry(ctx, func() (bool, error) {
+		req, err := s.newRequest(ctx, http.MethodPut, key, "", data)
+		if err != nil {
+			return false, err
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		s.setSSE(req)
+
+		resp, err := s.send(req, payloadHash)
+		if err != nil {
+			return true, fmt.Errorf("failed to upload s3://%s/%s: %v", s.Bucket, key, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			message, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+			return retryableStatus(resp.StatusCode), fmt.Errorf("failed to upload s3://%s/%s: S3 answered %d: %s", s.Bucket, key, resp.StatusCode, awsErrorMessage(message))
+		}
+
+		return false, nil
+	})
+}
+
+// putMultipart uploads first, a full part, and the rest of body as a multipart upload
+func (s *S3) putMultipart(ctx context.Context, key string, first []byte, body io.Reader) (int64, error) {
+	uploadID, err := s.createMultipartUpload(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	var parts []completedPart
+	var size int64
+	buf, part := first, first
+	for number := 1; ; number++ {
+		if number > maxParts {
+			return size, s.abortMultipartUpload(ctx, key, uploadID,
+				fmt.Errorf("failed to upload s3://%s/%s: larger than %d parts of %d bytes", s.Bucket, key, maxParts, len(buf)))
+		}
+		etag, err := s.uploadPart(ctx, key, uploadID, number, part)
+		if err != nil {
+			return size, s.abortMultipartUpload(ctx, key, uploadID, err)
+		}
+		parts = append(parts, completedPart{PartNumber: number, ETag: etag})
+		size += int64(len(part))
+
+		n, err := io.ReadFull(body, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return size, s.abortMultipartUpload(ctx, key, uploadID,
+				fmt.Errorf("failed to read what to upload to s3://%s/%s: %v", s.Bucket, key, err))
+		}
+		part = buf[:n]
+	}
+
+	if err := s.completeMultipartUpload(ctx, key, uploadID, parts); err != nil {
+		return size, s.abortMultipartUpload(ctx, key, uploadID, err)
+	}
+
+	return size, nil
+}
+
+// completedPart is a part of a multipart upload, as CompleteMultipartUpload lists it
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+func (s *S3) createMultipartUpload(ctx context.Context, key string) (string, error) {
+	req, err := s.newRequest(ctx, http.MethodPost, key, "uploads=", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	s.setSSE(req)
+
+	answer, err := s.call(req, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to start the upload of s3://%s/%s: %v", s.Bucket, key, err)
+	}
+	var upload struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(answer, &upload); err != nil || upload.UploadID == "" {
+		return "", fmt.Errorf("failed to start the upload of s3://%s/%s: no upload ID in %q", s.Bucket, key, answer)
+	}
+
+	return upload.UploadID, nil
+}
+
+// uploadPart uploads the part numbered number, and returns its ETag. A failed part is sent
+// again, which makes the upload resume from the part rather than start over.
+func (s *S3) uploadPart(ctx context.Context, key, uploadID string, number int, data []byte) (string, error) {
+	query := fmt.Sprintf("partNumber=%d&uploadId=%s", number, uriEncode(uploadID, true))
+	payloadHash := hashHex(data)
+
+	var etag string
+	err := s.retry(ctx, func() (bool, error) {
+		req, err := s.newRequest(ctx, http.MethodPut, key, query, data)
+		if err != nil {
+			return false, err
+		}
+
+		resp, err := s.send(req, payloadHash)
+		if err != nil {
+			return true, fmt.Errorf("failed to upload part %d of s3://%s/%s: %v", number, s.Bucket, key, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			message, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+			return retryableStatus(resp.StatusCode), fmt.Errorf("failed to upload part %d of s3://%s/%s: S3 answered %d: %s",
+				number, s.Bucket, key, resp.StatusCode, awsErrorMessage(message))
+		}
+		etag = resp.Header.Get("ETag")
+
+		return false, nil
+	})
+
+	return etag, err
+}
+
+func (s *S3) completeMultipartUpload(ctx context.Context, key, uploadID string, parts []completedPart) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	req, err := s.newRequest(ctx, http.MethodPost, key, "uploadId="+uriEncode(uploadID, true), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/xml")
+
+	answer, err := s.call(req, body)
+	if err != nil {
+		return fmt.Errorf("failed to complete the upload of s3://%s/%s: %v", s.Bucket, key, err)
+	}
+	// A failure found after S3 answered 200 comes as an error document
+	if bytes.Contains(answer, []byte("<Error>")) {
+		return fmt.Errorf("failed to complete the upload of s3://%s/%s: %s", s.Bucket, key, awsErrorMessage(answer))
+	}
+
+	return nil
+}
+
+// abortMultipartUpload drops the parts uploaded so far, which S3 keeps and bills for otherwise,
+// and returns cause. The abort goes through when ctx is what was cancelled.
+func (s *S3) abortMultipartUpload(ctx context.Context, key, uploadID string, cause error) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+
+	req, err := s.newRequest(ctx, http.MethodDelete, key, "uploadId="+uriEncode(uploadID, true), nil)
+	if err == nil {
+		_, err = s.call(req, nil)
+	}
+	if err != nil {
+		return fmt.Errorf("%v; aborting the upload also failed, its parts are left in the bucket: %v", cause, err)
+	}
+
+	return cause
+}
+
+// Get implements Store. A download that breaks off is resumed where it stopped, with a ranged
+// request for the same version of the object, so a dropped connection does not fail the restore
+// of a large snapshot.
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.get(ctx, key, 0, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &resumingBody{s: s, ctx: ctx, key: key, etag: resp.Header.Get("ETag"), body: resp.Body}, nil
+}
+
+// get requests the object stored under key from offset on, the version with etag when it is set
+func (s *S3) get(ctx context.Context, key string, offset int64, etag string) (*http.Response, error) {
+	req, err := s.newRequest(ctx, http.MethodGet, key, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	expected := http.StatusOK
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		expected = http.StatusPartialContent
+	}
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+
+	resp, err := s.send(req, hashHex(nil))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download s3://%s/%s: %v", s.Bucket, key, err)
+	}
+	if resp.StatusCode != expected {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+		return nil, fmt.Errorf("failed to download s3://%s/%s: S3 answered %d: %s", s.Bucket, key, resp.StatusCode, awsErrorMessage(message))
+	}
+
+	return resp, nil
+}
+
+// resumingBody reads an object, and requests the rest of it when reading breaks off
+type resumingBody struct {
+	s       *S3
+	ctx     context.Context
+	key     string
+	etag    string
+	body    io.ReadCloser
+	offset  int64
+	resumes int
+	err     error
+}
+
+func (b *resumingBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	n, err := b.body.Read(p)
+	b.offset += int64(n)
+	if err == nil || err == io.EOF || b.ctx.Err() != nil || b.resumes == maxAttempts-1 {
+		return n, err
+	}
+
+	b.resumes++
+	b.body.Close()
+	var resp *http.Response
+	resumeErr := b.s.wait(b.ctx, b.resumes)
+	if resumeErr == nil {
+		resp, resumeErr = b.s.get(b.ctx, b.key, b.offset, b.etag)
+	}
+	if resumeErr != nil {
+		b.err = fmt.Errorf("failed to download s3://%s/%s: %v, and resuming at byte %d failed: %v", b.s.Bucket, b.key, err, b.offset, resumeErr)
+
+		return n, b.err
+	}
+	b.body = resp.Body
+	if n > 0 {
+		return n, nil
+	}
+
+	return b.Read(p)
+}
+
+func (b *resumingBody) Close() error {
+	return b.body.Close()
+}
+
+// List implements Store, with ListObjectsV2 pages of up to 1000 objects
+func (s *S3) List(ctx context.Context, prefix string) ([]Object, error) {
+	target, err := s.bucketURL()
+	if err != nil {
+		return nil, err
+	}
+	target.Path += "/"
+	target.RawPath = uriEncode(target.Path, false)
+
+	var objects []Object
+	continuation := ""
+	for {
+		// Encoded as Signature Version 4 expects, which url.Values does not
+		query := "list-type=2&prefix=" + uriEncode(prefix, true)
+		if continuation != "" {
+			query = "continuation-token=" + uriEncode(continuation, true) + "&" + query
+		}
+		target.RawQuery = query
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %v", err)
+		}
+		resp, err := s.send(req, hashHex(nil))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list s3://%s/%s: %v", s.Bucket, prefix, err)
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list s3://%s/%s: %v", s.Bucket, prefix, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to list s3://%s/%s: S3 answered %d: %s", s.Bucket, prefix, resp.StatusCode, awsErrorMessage(body))
+		}
+
+		var page struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		if err := xml.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("failed to decode the listing of s3://%s/%s: %v", s.Bucket, prefix, err)
+		}
+		for _, object := range page.Contents {
+			objects = append(objects, Object{Key: object.Key, Size: object.Size, LastModified: object.LastModified})
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, nil
+		}
+		continuation = page.NextContinuationToken
+	}
+}
+
+// Delete implements Store
+func (s *S3) Delete(ctx context.Context, key string) error {
+	target, err := s.objectURL(key)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, target.String(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := s.send(req, hashHex(nil))
+	if err != nil {
+		return fmt.Errorf("failed to delete s3://%s/%s: %v", s.Bucket, key, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+		return fmt.Errorf("failed to delete s3://%s/%s: S3 answered %d: %s", s.Bucket, key, resp.StatusCode, awsErrorMessage(message))
+	}
+
+	return nil
+}
+
+// newRequest returns a request to the object stored under key, with the query and body given
+func (s *S3) newRequest(ctx context.Context, method, key, query string, body []byte) (*http.Request, error) {
+	target, err := s.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+	target.RawQuery = query
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	return req, nil
+}
+
+// setSSE asks for the server-side encryption of s on an upload
+func (s *S3) setSSE(req *http.Request) {
+	switch s.SSE {
+	case "":
+	case SSEKMS:
+		req.Header.Set("X-Amz-Server-Side-Encryption", SSEKMS)
+		if s.SSEKMSKeyID != "" {
+			req.Header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", s.SSEKMSKeyID)
+		}
+	default:
+		req.Header.Set("X-Amz-Server-Side-Encryption", s.SSE)
+	}
+}
+
+// call sends req with body, and returns the answer, or an error unless S3 answered with a 2xx
+func (s *S3) call(req *http.Request, body []byte) ([]byte, error) {
+	resp, err := s.send(req, hashHex(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	answer, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("S3 answered %d: %s", resp.StatusCode, awsErrorMessage(answer))
+	}
+
+	return answer, nil
+}
+
+// retry calls send until it succeeds, fails in a way it reports not worth retrying, or was
+// called maxAttempts times
+func (s *S3) retry(ctx context.Context, send func() (retryable bool, err error)) error {
+	for attempt := 1; ; attempt++ {
+		retryable, err := send()
+		if err == nil || !retryable || attempt == maxAttempts {
+			return err
+		}
+		if s.wait(ctx, attempt) != nil {
+			return err
+		}
+	}
+}
+
+// wait waits before the given retry, counting from 1, unless ctx is done first
+func (s *S3) wait(ctx context.Context, retry int) error {
+	delay := s.retryDelay
+	if delay == 0 {
+		delay = defaultRetryDelay
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay << (retry - 1)):
+		return nil
+	}
+}
+
+// retryableStatus reports whether an S3 answer of status may go away when asked again
+func retryableStatus(status int) bool {
+	return status >= 500 || status == http.StatusTooManyRequests
+}
+
+func (s *S3) partSize() int {
+	if s.PartSize > 0 {
+		return s.PartSize
+	}
+
+	return defaultPartSize
+}
+
+// send signs req, whose body has the hex SHA-256 payloadHash, and sends it
+func (s *S3) send(req *http.Request, payloadHash string) (*http.Response, error) {
+	creds, err := s.Credentials(req.Context())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get AWS credentials: %v", err)
+	}
+	signV4(req, creds, s.region(), "s3", payloadHash, s.signingTime())
+
+	return s.client().Do(req)
+}
+
+func (s *S3) signingTime() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+
+	return time.Now()
+}
+
+func (s *S3) client() *http.Client {
+	if s.HTTPClient != nil {
+		return s.HTTPClient
+	}
+
+	return http.DefaultClient
+}
+
+// objectURL returns the URL of the object stored under key
+func (s *S3) objectURL(key string) (*url.URL, error) {
+	target, err := s.bucketURL()
+	if err != nil {
+		return nil, err
+	}
+	target.Path += "/" + strings.TrimPrefix(key, "/")
+	target.RawPath = uriEncode(target.Path, false)
+
+	return target, nil
+}
+
+// bucketURL returns the URL of the bucket, without a trailing slash
+func (s *S3) bucketURL() (*url.URL, error) {
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", s.region())
+	}
+	base, err := url.Parse(endpoint)
+	if err != nil || base.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", endpoint)
+	}
+
+	base.Path = strings.TrimSuffix(base.Path, "/")
+	if s.PathStyle {
+		base.Path += "/" + s.Bucket
+	} else {
+		base.Host = s.Bucket + "." + base.Host
+	}
+
+	return base, nil
+}
+
+func (s *S3) region() string {
+	if s.Region == "" {
+		return "us-east-1"
+	}
+
+	return s.Region
+}
@@ -0,0 +1,163 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CatalogName is the name of the catalog object, beside the snapshots it describes. It has no
+// snapshot suffix, so pruning leaves it alone.
+const CatalogName = "catalog.json"
+
+// maxCatalogSize bounds a catalog read, which holds thousands of entries well below it
+const maxCatalogSize = 16 << 20
+
+// Entry describes a snapshot in the catalog. A snapshot the catalog did not record, such as one
+// taken before it existed, only has its key, size, time and whether it is encrypted.
+type Entry struct {
+	Key string `json:"key"`
+	// TakenAt is when the backup started, or when the snapshot was uploaded for one not recorded
+	TakenAt time.Time `json:"taken_at"`
+	// Size is the size of the object, which encryption makes larger than the snapshot
+	Size int64 `json:"size"`
+	// Duration is how long taking and uploading the snapshot took
+	Duration string `json:"duration,omitempty"`
+	// Pod is the pod the snapshot was taken from, and VaultVersion the version it ran
+	Pod          string `json:"pod,omitempty"`
+	VaultVersion string `json:"vault_version,omitempty"`
+	Encrypted    bool   `json:"encrypted"`
+	// SHA256 is the hex SHA-256 of the object as stored, so a download can be checked without
+	// decrypting it
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// catalog is the JSON of the catalog object
+type catalog struct {
+	Snapshots []Entry `json:"snapshots"`
+}
+
+// ReadCatalog returns the snapshots directly under prefix, newest first, as the catalog under
+// prefix describes them. Snapshots the catalog does not record are listed with what the store
+// knows of them, and entries of snapshots that are gone, such as deleted by hand, are left out.
+func ReadCatalog(ctx context.Context, store Store, prefix string) ([]Entry, error) {
+	objects, err := store.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	recorded := map[string]Entry{}
+	for _, object := range objects {
+		if object.Key != prefix+CatalogName {
+			continue
+		}
+		entries, err := getCatalog(ctx, store, object.Key)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			recorded[entry.Key] = entry
+		}
+	}
+
+	var entries []Entry
+	for _, object := range objects {
+		if !isSnapshot(prefix, object.Key) {
+			continue
+		}
+		entry, ok := recorded[object.Key]
+		if !ok {
+			entry = Entry{
+				Key:       object.Key,
+				TakenAt:   object.LastModified.UTC(),
+				Encrypted: strings.HasSuffix(object.Key, EncryptedSuffix),
+			}
+		}
+		entry.Size = object.Size
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].TakenAt.Equal(entries[j].TakenAt) {
+			return entries[i].TakenAt.After(entries[j].TakenAt)
+		}
+
+		return entries[i].Key > entries[j].Key
+	})
+
+	return entries, nil
+}
+
+// RecordSnapshot adds entry to the catalog under prefix, replacing the one of the same key, and
+// rewrites the catalog with the snapshots that exist. Only one writer is expected, the controller
+// taking backups, so the catalog is rewritten as a whole.
+func RecordSnapshot(ctx context.Context, store Store, prefix string, entry Entry) error {
+	entries, err := ReadCatalog(ctx, store, prefix)
+	if err != nil {
+		return err
+	}
+	for i := range entries {
+		if entries[i].Key == entry.Key {
+			// The listing knows the size stored best
+			entry.Size = entries[i].Size
+			entries[i] = entry
+		}
+	}
+
+	data, err := json.MarshalIndent(catalog{Snapshots: entries}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := store.Put(ctx, prefix+CatalogName, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("failed to write the backup catalog: %v", err)
+	}
+
+	return nil
+}
+
+// FindSnapshot returns the catalog entry of the snapshot stored under key
+func FindSnapshot(ctx context.Context, store Store, key string) (Entry, error) {
+	prefix := key[:strings.LastIndex(key, "/")+1]
+	entries, err := ReadCatalog(ctx, store, prefix)
+	if err != nil {
+		return Entry{}, err
+	}
+	for _, entry := range entries {
+		if entry.Key == key {
+			return entry, nil
+		}
+	}
+
+	return Entry{}, fmt.Errorf("no snapshot %s in %s", key, store)
+}
+
+// getCatalog downloads and decodes the catalog under key
+func getCatalog(ctx context.Context, store Store, key string) ([]Entry, error) {
+	body, err := store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(body, maxCatalogSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the backup catalog: %v", err)
+	}
+	var decoded catalog
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, fmt.Errorf("failed to decode the backup catalog %s: %v", key, err)
+	}
+
+	return decoded.Snapshots, nil
+}
+
+// isSnapshot reports whether key is a snapshot directly under prefix
+func isSnapshot(prefix, key string) bool {
+	name, ok := strings.CutPrefix(key, prefix)
+
+	return ok && !strings.Contains(name, "/") && (strings.HasSuffix(name, SnapshotSuffix) || strings.HasSuffix(name, EncryptedSuffix))
+}
@@ -0,0 +1,171 @@
+package backup
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Credentials are the AWS credentials requests are signed with
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is set for temporary credentials
+	SessionToken string
+	// Expires is when temporary credentials expire, zero for long-lived ones
+	Expires time.Time
+}
+
+// CredentialsSource returns the credentials to sign the next request with
+type CredentialsSource func(ctx context.Context) (Credentials, error)
+
+// credentialsRefreshMargin is how long before they expire temporary credentials are replaced,
+// so an upload started with them does not outlive them
+const credentialsRefreshMargin = 5 * time.Minute
+
+// DefaultCredentials picks the credentials the AWS SDKs would from the environment: a web
+// identity token exchanged for a role through STS when AWS_ROLE_ARN and
+// AWS_WEB_IDENTITY_TOKEN_FILE are set, as with IAM roles for service accounts, or else
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+func DefaultCredentials(region string) (CredentialsSource, error) {
+	roleARN, tokenFile := os.Getenv("AWS_ROLE_ARN"), os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	if roleARN != "" && tokenFile != "" {
+		endpoint := "https://sts.amazonaws.com"
+		if region != "" {
+			endpoint = fmt.Sprintf("https://sts.%s.amazonaws.com", region)
+		}
+
+		return WebIdentityCredentials(endpoint, roleARN, tokenFile, http.DefaultClient), nil
+	}
+
+	creds := Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, fmt.Errorf("no AWS credentials, set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, or AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE")
+	}
+
+	return StaticCredentials(creds), nil
+}
+
+// StaticCredentials always returns creds
+func StaticCredentials(creds Credentials) CredentialsSource {
+	return func(context.Context) (Credentials, error) {
+		return creds, nil
+	}
+}
+
+// WebIdentityCredentials exchanges the token in tokenFile for temporary credentials of roleARN
+// through the STS endpoint, and reuses them until shortly before they expire. The token file is
+// read on every exchange, since the kubelet rotates it.
+func WebIdentityCredentials(endpoint, roleARN, tokenFile string, client *http.Client) CredentialsSource {
+	var mu sync.Mutex
+	var cached Credentials
+
+	return func(ctx context.Context) (Credentials, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if cached.AccessKeyID != "" && time.Until(cached.Expires) > credentialsRefreshMargin {
+			return cached, nil
+		}
+
+		creds, err := assumeRoleWithWebIdentity(ctx, client, endpoint, roleARN, tokenFile)
+		if err != nil {
+			return Credentials{}, err
+		}
+		cached = creds
+
+		return creds, nil
+	}
+}
+
+// assumeRoleWithWebIdentity calls STS AssumeRoleWithWebIdentity, which needs no credentials
+func assumeRoleWithWebIdentity(ctx context.Context, client *http.Client, endpoint, roleARN, tokenFile string) (Credentials, error) {
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to read the web identity token: %v", err)
+	}
+
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {roleARN},
+		"RoleSessionName":  {"vault-utils"},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to create STS request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to assume role %s: %v", roleARN, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to read STS response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Credentials{}, fmt.Errorf("failed to assume role %s: STS answered %d: %s", roleARN, resp.StatusCode, awsErrorMessage(body))
+	}
+
+	var result struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.Unmarshal(body, &result); err != nil {
+		return Credentials{}, fmt.Errorf("failed to decode STS response: %v", err)
+	}
+	if result.Credentials.AccessKeyID == "" {
+		return Credentials{}, fmt.Errorf("STS returned no credentials for role %s", roleARN)
+	}
+
+	return Credentials{
+		AccessKeyID:     result.Credentials.AccessKeyID,
+		SecretAccessKey: result.Credentials.SecretAccessKey,
+		SessionToken:    result.Credentials.SessionToken,
+		Expires:         result.Credentials.Expiration,
+	}, nil
+}
+
+// awsErrorMessage extracts the code and message of an AWS XML error response, or returns the
+// body as it is
+func awsErrorMessage(body []byte) string {
+	var awsErr struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	// STS nests the error in an ErrorResponse, S3 does not
+	var wrapped struct {
+		Error *struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		} `xml:"Error"`
+	}
+	if xml.Unmarshal(body, &wrapped) == nil && wrapped.Error != nil && wrapped.Error.Code != "" {
+		return wrapped.Error.Code + ": " + wrapped.Error.Message
+	}
+	if xml.Unmarshal(body, &awsErr) == nil && awsErr.Code != "" {
+		return awsErr.Code + ": " + awsErr.Message
+	}
+
+	return strings.TrimSpace(string(body))
+}
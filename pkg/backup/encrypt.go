@@ -0,0 +1,264 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// DataKeys hands out the keys snapshots are encrypted with, each wrapped by a key that never
+// leaves the service holding it, such as a KMS key
+type DataKeys interface {
+	// GenerateDataKey returns a new 256-bit key, and the same key wrapped to be stored beside
+	// what it encrypts
+	GenerateDataKey(ctx context.Context) (key, wrapped []byte, err error)
+	// Unwrap returns the key GenerateDataKey wrapped
+	Unwrap(ctx context.Context, wrapped []byte) ([]byte, error)
+	String() string
+}
+
+// An encrypted snapshot starts with encryptionHeader and the length and bytes of its wrapped
+// data key, followed by the snapshot sealed with AES-256-GCM in chunks of encryptionChunkSize.
+// Each chunk has its own nonce, the chunk's number with a flag set on the last one, so chunks
+// cannot be reordered, dropped or truncated unnoticed. The data key is new for every snapshot,
+// which makes counting nonces safe.
+const (
+	encryptionHeader    = "vault-utils encrypted snapshot v1\n"
+	encryptionChunkSize = 64 << 10
+)
+
+// Encrypted reports whether data is a snapshot encrypted by Encrypt
+func Encrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptionHeader))
+}
+
+// Encrypt returns a writer encrypting what is written to it with a new data key from keys, and
+// writing the result to w. Close writes the last chunk, without which the snapshot does not
+// decrypt, and does not close w.
+func Encrypt(ctx context.Context, keys DataKeys, w io.Writer) (io.WriteCloser, error) {
+	key, wrapped, err := keys.GenerateDataKey(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate a data key with %s: %v", keys, err)
+	}
+	if len(wrapped) > math.MaxUint16 {
+		return nil, fmt.Errorf("the wrapped data key of %d bytes is too long", len(wrapped))
+	}
+	aead, err := newChunkCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, len(encryptionHeader)+2+len(wrapped))
+	header = append(header, encryptionHeader...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(wrapped)))
+	header = append(header, wrapped...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &encrypter{
+		w:     w,
+		aead:  aead,
+		chunk: make([]byte, 0, encryptionChunkSize),
+		out:   make([]byte, 0, encryptionChunkSize+aead.Overhead()),
+	}, nil
+}
+
+// Decrypt returns a reader of the snapshot read from r, decrypted with the data key keys
+// unwraps, or as it is when it was not encrypted. Chunks are decrypted as they are read, so the
+// snapshot is never held in memory. An altered or truncated snapshot fails the read reaching it,
+// at the latest the one before the end, so what it is streamed to never sees it complete.
+// Closing the reader closes r.
+func Decrypt(ctx context.Context, keys DataKeys, r io.ReadCloser) (io.ReadCloser, error) {
+	buffered := bufio.NewReaderSize(r, encryptionChunkSize)
+	header, err := buffered.Peek(len(encryptionHeader))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !Encrypted(header) {
+		return readCloser{Reader: buffered, Closer: r}, nil
+	}
+	if keys == nil {
+		return nil, fmt.Errorf("the snapshot is encrypted, set BACKUP_ENCRYPTION_KMS_KEY_ID to the KMS key it was encrypted with")
+	}
+
+	var length [2]byte
+	buffered.Discard(len(encryptionHeader))
+	if _, err := io.ReadFull(buffered, length[:]); err != nil {
+		return nil, fmt.Errorf("the encrypted snapshot is truncated")
+	}
+	wrapped := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(buffered, wrapped); err != nil {
+		return nil, fmt.Errorf("the encrypted snapshot is truncated")
+	}
+
+	key, err := keys.Unwrap(ctx, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap the data key of the snapshot with %s: %v", keys, err)
+	}
+	aead, err := newChunkCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &decrypter{
+		r:      buffered,
+		closer: r,
+		aead:   aead,
+		sealed: make([]byte, encryptionChunkSize+aead.Overhead()),
+	}, nil
+}
+
+// OpenSnapshot returns a reader of the snapshot stored under key in store, decrypted with keys
+// when it was encrypted
+func OpenSnapshot(ctx context.Context, store Store, keys DataKeys, key string) (io.ReadCloser, error) {
+	body, err := store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := Decrypt(ctx, keys, body)
+	if err != nil {
+		body.Close()
+
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// readCloser reads from Reader and closes Closer
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// decrypter opens what is read through it chunk by chunk
+type decrypter struct {
+	r       *bufio.Reader
+	closer  io.Closer
+	aead    cipher.AEAD
+	sealed  []byte
+	plain   []byte
+	counter uint64
+	done    bool
+	err     error
+}
+
+func (d *decrypter) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.done {
+			return 0, io.EOF
+		}
+		d.err = d.open()
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+
+	return n, nil
+}
+
+func (d *decrypter) Close() error {
+	return d.closer.Close()
+}
+
+// open reads and opens the next chunk. A chunk is the last when nothing follows it.
+func (d *decrypter) open() error {
+	n, err := io.ReadFull(d.r, d.sealed)
+	final := err == io.EOF || err == io.ErrUnexpectedEOF
+	if err != nil && !final {
+		return err
+	}
+	if !final {
+		if _, err := d.r.Peek(1); err == io.EOF {
+			final = true
+		} else if err != nil {
+			return err
+		}
+	}
+	if n < d.aead.Overhead() {
+		return fmt.Errorf("the encrypted snapshot is truncated")
+	}
+
+	// Chunks shrink by the tag as they are opened, so each lands in place
+	plain, err := d.aead.Open(d.sealed[:0], chunkNonce(d.counter, final), d.sealed[:n], nil)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt the snapshot, it was altered or truncated")
+	}
+	d.counter++
+	d.plain = plain
+	d.done = final
+
+	return nil
+}
+
+// encrypter seals what is written to it chunk by chunk
+type encrypter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	chunk   []byte
+	out     []byte
+	counter uint64
+}
+
+func (e *encrypter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// A full chunk is only sealed once more follows, since the last one is flagged
+		if len(e.chunk) == encryptionChunkSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.chunk[len(e.chunk):encryptionChunkSize], p)
+		e.chunk = e.chunk[:len(e.chunk)+n]
+		p = p[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+func (e *encrypter) Close() error {
+	return e.seal(true)
+}
+
+func (e *encrypter) seal(final bool) error {
+	e.out = e.aead.Seal(e.out[:0], chunkNonce(e.counter, final), e.chunk, nil)
+	e.counter++
+	e.chunk = e.chunk[:0]
+	_, err := e.w.Write(e.out)
+
+	return err
+}
+
+func newChunkCipher(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("expected a data key of 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce returns the nonce of the chunk numbered counter
+func chunkNonce(counter uint64, final bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if final {
+		nonce[11] = 1
+	}
+
+	return nonce
+}
@@ -0,0 +1,161 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// kmsEncryptionContext is bound to every data key, so KMS only unwraps it for a snapshot, and
+// CloudTrail records what it was for
+var kmsEncryptionContext = map[string]string{"vault-utils": "raft-snapshot"}
+
+// KMS is a DataKeys wrapping data keys with an AWS KMS key
+type KMS struct {
+	// KeyID is the ID, ARN or alias of the key. Unwrapping does not need it, since KMS finds the
+	// key from the wrapped data key, but checks it when it is set.
+	KeyID string
+	// Region is where the key lives. The region of KeyID wins when it is an ARN.
+	Region string
+	// Endpoint is the base URL of the KMS API, the regional AWS endpoint when empty
+	Endpoint    string
+	Credentials CredentialsSource
+	HTTPClient  *http.Client
+	// now returns the signing time, for tests
+	now func() time.Time
+}
+
+// String implements DataKeys
+func (k *KMS) String() string {
+	if k.KeyID == "" {
+		return "AWS KMS"
+	}
+
+	return "AWS KMS key " + k.KeyID
+}
+
+// GenerateDataKey implements DataKeys
+func (k *KMS) GenerateDataKey(ctx context.Context) ([]byte, []byte, error) {
+	var out struct {
+		CiphertextBlob []byte
+		Plaintext      []byte
+	}
+	err := k.call(ctx, "GenerateDataKey", map[string]any{
+		"KeyId":             k.KeyID,
+		"KeySpec":           "AES_256",
+		"EncryptionContext": kmsEncryptionContext,
+	}, &out)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return out.Plaintext, out.CiphertextBlob, nil
+}
+
+// Unwrap implements DataKeys
+func (k *KMS) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	in := map[string]any{
+		"CiphertextBlob":    wrapped,
+		"EncryptionContext": kmsEncryptionContext,
+	}
+	if k.KeyID != "" {
+		in["KeyId"] = k.KeyID
+	}
+
+	var out struct {
+		Plaintext []byte
+	}
+	if err := k.call(ctx, "Decrypt", in, &out); err != nil {
+		return nil, err
+	}
+
+	return out.Plaintext, nil
+}
+
+// call sends action with the JSON of in to KMS and decodes the answer into out
+func (k *KMS) call(ctx context.Context, action string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	endpoint := k.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://kms.%s.amazonaws.com/", k.region())
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create KMS request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+
+	creds, err := k.Credentials(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get AWS credentials: %v", err)
+	}
+	now := time.Now()
+	if k.now != nil {
+		now = k.now()
+	}
+	signV4(req, creds, k.region(), "kms", hashHex(body), now)
+
+	client := k.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("KMS %s failed: %v", action, err)
+	}
+	defer resp.Body.Close()
+
+	answer, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read the KMS answer: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("KMS %s failed: KMS answered %d: %s", action, resp.StatusCode, kmsErrorMessage(answer))
+	}
+	if err := json.Unmarshal(answer, out); err != nil {
+		return fmt.Errorf("failed to decode the KMS answer: %v", err)
+	}
+
+	return nil
+}
+
+func (k *KMS) region() string {
+	// arn:aws:kms:<region>:<account>:key/<id>
+	if parts := strings.Split(k.KeyID, ":"); len(parts) > 3 && parts[0] == "arn" && parts[3] != "" {
+		return parts[3]
+	}
+	if k.Region == "" {
+		return "us-east-1"
+	}
+
+	return k.Region
+}
+
+// kmsErrorMessage extracts the type and message of a KMS JSON error, or returns the body as it is
+func kmsErrorMessage(body []byte) string {
+	var kmsErr struct {
+		Type         string `json:"__type"`
+		Message      string `json:"message"`
+		MessageUpper string `json:"Message"`
+	}
+	if json.Unmarshal(body, &kmsErr) != nil || kmsErr.Type == "" {
+		return strings.TrimSpace(string(body))
+	}
+	// Types may be qualified, as in com.amazonaws.kms#NotFoundException
+	kind := kmsErr.Type[strings.LastIndex(kmsErr.Type, "#")+1:]
+	if kmsErr.Message == "" {
+		kmsErr.Message = kmsErr.MessageUpper
+	}
+
+	return kind + ": " + kmsErr.Message
+}
@@ -0,0 +1,65 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// ProgressReader counts the bytes of a snapshot transfer read through it, and reports the count
+// every interval, so a transfer of several GiB shows it is still moving
+type ProgressReader struct {
+	r        io.Reader
+	interval time.Duration
+	report   func(transferred int64)
+	n        int64
+	last     time.Time
+	// now returns the current time, for tests
+	now func() time.Time
+}
+
+// NewProgressReader returns a reader of r calling report with the bytes read so far, at most
+// once every interval. The count is reported from the goroutine reading.
+func NewProgressReader(r io.Reader, interval time.Duration, report func(transferred int64)) *ProgressReader {
+	return &ProgressReader{r: r, interval: interval, report: report, last: time.Now(), now: time.Now}
+}
+
+func (p *ProgressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.n += int64(n)
+	if now := p.now(); now.Sub(p.last) >= p.interval {
+		p.last = now
+		p.report(p.n)
+	}
+
+	return n, err
+}
+
+// Close closes the reader read from, when it is an io.Closer
+func (p *ProgressReader) Close() error {
+	if closer, ok := p.r.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// Transferred returns the bytes read so far
+func (p *ProgressReader) Transferred() int64 {
+	return p.n
+}
+
+// FormatSize returns a size in bytes in the largest binary unit it reaches, such as 1.5 GiB
+func FormatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit && exp < 5; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
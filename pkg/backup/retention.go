@@ -0,0 +1,76 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+)
+
+// Suffixes of the snapshot object keys, which pruning is limited to
+const (
+	SnapshotSuffix  = ".snap"
+	EncryptedSuffix = ".snap.enc"
+)
+
+// Retention is which snapshots are kept. A snapshot is pruned once it is not among the newest
+// Count, or is older than MaxAge; either is ignored when 0. The newest snapshot is always kept,
+// so a retention never leaves none.
+type Retention struct {
+	Count  int
+	MaxAge time.Duration
+}
+
+// Enabled reports whether r prunes anything
+func (r Retention) Enabled() bool {
+	return r.Count > 0 || r.MaxAge > 0
+}
+
+// Prune deletes the snapshots directly under prefix that r does not keep at now, and returns
+// their keys. Other objects and anything deeper under prefix are left alone. Deleting goes on
+// past a failure, and the keys returned are those deleted.
+func Prune(ctx context.Context, store Store, prefix string, r Retention, now time.Time) ([]string, error) {
+	if !r.Enabled() {
+		return nil, nil
+	}
+
+	objects, err := store.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []Object
+	for _, object := range objects {
+		if !isSnapshot(prefix, object.Key) {
+			continue
+		}
+		snapshots = append(snapshots, object)
+	}
+	// Newest first. Keys name the time the snapshot was taken, which breaks ties.
+	sort.Slice(snapshots, func(i, j int) bool {
+		if !snapshots[i].LastModified.Equal(snapshots[j].LastModified) {
+			return snapshots[i].LastModified.After(snapshots[j].LastModified)
+		}
+
+		return snapshots[i].Key > snapshots[j].Key
+	})
+
+	var pruned []string
+	var errs []error
+	for i, snapshot := range snapshots {
+		if i == 0 {
+			continue
+		}
+		if (r.Count <= 0 || i < r.Count) && (r.MaxAge <= 0 || now.Sub(snapshot.LastModified) <= r.MaxAge) {
+			continue
+		}
+		if err := store.Delete(ctx, snapshot.Key); err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+		pruned = append(pruned, snapshot.Key)
+	}
+
+	return pruned, errors.Join(errs...)
+}
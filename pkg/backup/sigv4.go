@@ -0,0 +1,121 @@
+package backup
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// amzDateFormat is the timestamp format of the X-Amz-Date header
+	amzDateFormat = "20060102T150405Z"
+	// signingAlgorithm is the only algorithm AWS Signature Version 4 defines
+	signingAlgorithm = "AWS4-HMAC-SHA256"
+)
+
+// signV4 signs req for service in region with AWS Signature Version 4, setting the X-Amz-Date,
+// X-Amz-Content-Sha256, X-Amz-Security-Token and Authorization headers. payloadHash is the hex
+// SHA-256 of the body. Every header already set on req is signed, along with Host.
+func signV4(req *http.Request, creds Credentials, region, service, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format(amzDateFormat)
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	if req.Host != "" {
+		headers["host"] = req.Host
+	}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.Join(strings.Fields(headers[name]), " ") + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonicalQuery(req),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", date, region, service)
+	stringToSign := strings.Join([]string{signingAlgorithm, amzDate, scope, hashHex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		signingAlgorithm, creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// canonicalQuery returns the query of req sorted and encoded as Signature Version 4 expects
+func canonicalQuery(req *http.Request) string {
+	query := req.URL.Query()
+	pairs := make([]string, 0, len(query))
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent-encodes s as Signature Version 4 expects, leaving unreserved characters as
+// they are, and slashes too unless encodeSlash is set
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case 'A' <= ch && ch <= 'Z', 'a' <= ch && ch <= 'z', '0' <= ch && ch <= '9',
+			ch == '-', ch == '_', ch == '.', ch == '~':
+			b.WriteByte(ch)
+		case ch == '/' && !encodeSlash:
+			b.WriteByte(ch)
+		default:
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,153 @@
+package cli
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"text/tabwriter"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/backup"
+)
+
+func runBackups(ctx context.Context, args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected list or inspect, as in vault-utils backups list")
+	}
+
+	switch args[0] {
+	case "list":
+		return runBackupsList(ctx, args[1:], stdout)
+	case "inspect":
+		return runBackupsInspect(ctx, args[1:], stdout)
+	}
+
+	return fmt.Errorf("unknown backups command %q, expected list or inspect", args[0])
+}
+
+func runBackupsList(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("backups list", flag.ContinueOnError)
+	prefix := fs.String("prefix", os.Getenv("BACKUP_S3_PREFIX"), "prefix of the snapshot keys in the bucket (default $BACKUP_S3_PREFIX)")
+	namespace := fs.String("namespace", "vault", "namespace Vault runs in, under which its snapshots are stored")
+	bucket := registerBucketFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if bucket.bucket == "" {
+		return fmt.Errorf("-bucket is required")
+	}
+
+	store, err := bucket.store()
+	if err != nil {
+		return err
+	}
+
+	return listBackups(ctx, store, path.Join(*prefix, *namespace)+"/", stdout)
+}
+
+// listBackups prints the snapshots under prefix, newest first, with what the catalog recorded
+// of them
+func listBackups(ctx context.Context, store backup.Store, prefix string, stdout io.Writer) error {
+	entries, err := backup.ReadCatalog(ctx, store, prefix)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Fprintf(stdout, "No snapshots under %s/%s\n", store, prefix)
+
+		return nil
+	}
+
+	table := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	defer table.Flush()
+
+	fmt.Fprintln(table, "KEY\tTAKEN\tSIZE\tDURATION\tVAULT\tENCRYPTED")
+	for _, entry := range entries {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%t\n", entry.Key, entry.TakenAt.Format(time.RFC3339), backup.FormatSize(entry.Size),
+			orDash(entry.Duration), orDash(entry.VaultVersion), entry.Encrypted)
+	}
+
+	return nil
+}
+
+func runBackupsInspect(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("backups inspect", flag.ContinueOnError)
+	key := fs.String("key", "", "object key of the snapshot in the bucket, such as vault/20240101T000000Z.snap")
+	verify := fs.Bool("verify", false, "download the snapshot and check it against the checksum recorded in the catalog")
+	bucket := registerBucketFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *key == "" || bucket.bucket == "" {
+		return fmt.Errorf("-key and -bucket are required")
+	}
+
+	store, err := bucket.store()
+	if err != nil {
+		return err
+	}
+
+	return inspectBackup(ctx, store, *key, *verify, stdout)
+}
+
+// inspectBackup prints what the catalog recorded of the snapshot stored under key. With verify
+// the snapshot is downloaded and its SHA-256 compared with the recorded one, which needs no
+// decryption key since it is the checksum of the object as stored.
+func inspectBackup(ctx context.Context, store backup.Store, key string, verify bool, stdout io.Writer) error {
+	entry, err := backup.FindSnapshot(ctx, store, key)
+	if err != nil {
+		return err
+	}
+
+	table := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(table, "Location:\t%s/%s\n", store, entry.Key)
+	fmt.Fprintf(table, "Taken at:\t%s\n", entry.TakenAt.Format(time.RFC3339))
+	fmt.Fprintf(table, "Size:\t%s (%d bytes)\n", backup.FormatSize(entry.Size), entry.Size)
+	fmt.Fprintf(table, "Duration:\t%s\n", orDash(entry.Duration))
+	fmt.Fprintf(table, "Pod:\t%s\n", orDash(entry.Pod))
+	fmt.Fprintf(table, "Vault version:\t%s\n", orDash(entry.VaultVersion))
+	fmt.Fprintf(table, "Encrypted:\t%t\n", entry.Encrypted)
+	fmt.Fprintf(table, "SHA-256:\t%s\n", orDash(entry.SHA256))
+	table.Flush()
+
+	if entry.Duration == "" {
+		fmt.Fprintf(stdout, "The catalog did not record this snapshot, only what the bucket knows of it is shown\n")
+	}
+	if !verify {
+		return nil
+	}
+	if entry.SHA256 == "" {
+		return fmt.Errorf("no checksum of %s was recorded to verify it against", key)
+	}
+
+	body, err := store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, body); err != nil {
+		return fmt.Errorf("failed to download %s/%s: %w", store, key, err)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != entry.SHA256 {
+		return fmt.Errorf("the snapshot does not match its checksum, it has SHA-256 %s", sum)
+	}
+	fmt.Fprintf(stdout, "The snapshot matches its checksum\n")
+
+	return nil
+}
+
+// orDash returns value, or - when it is empty
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+
+	return value
+}
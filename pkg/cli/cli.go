@@ -0,0 +1,327 @@
+// Package cli implements the vault-utils subcommands operators run from their workstations.
+// The commands only talk to the Kubernetes API server (or to an explicit Vault address), so
+// they work outside the cluster network on Linux, macOS and Windows alike.
+package cli
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"sort"
+	"strings"
+
+	"github.com/getgrowly/vault-utils/pkg/backup"
+	"github.com/getgrowly/vault-utils/pkg/config"
+	"github.com/getgrowly/vault-utils/pkg/kubernetes"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+)
+
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+// command is a single vault-utils subcommand
+type command struct {
+	summary string
+	run     func(ctx context.Context, args []string, stdout io.Writer) error
+}
+
+var commands = map[string]command{
+	"status": {
+		summary: "show the seal status of every Vault pod",
+		run:     runStatus,
+	},
+	"backups": {
+		summary: "list the raft snapshots in the backup bucket, or inspect one",
+		run:     runBackups,
+	},
+	"dev": {
+		summary: "run a local Vault container, initialized and unsealed",
+		run:     runDev,
+	},
+	"generate-root": {
+		summary: "generate a new root token from the stored unseal or recovery keys",
+		run:     runGenerateRoot,
+	},
+	"issue-token": {
+		summary: "mint a short-lived Vault token without reading the root token",
+		run:     runIssueToken,
+	},
+	"migrate": {
+		summary: "take over unseal keys from bank-vaults or vault-init",
+		run:     runMigrate,
+	},
+	"rekey-recovery": {
+		summary: "rotate the recovery keys of an auto-unseal Vault",
+		run:     runRekeyRecovery,
+	},
+	"restore": {
+		summary: "restore a raft snapshot from the backup bucket",
+		run:     runRestoreSnapshot,
+	},
+	"step-down": {
+		summary: "have the active Vault node hand leadership to a standby",
+		run:     runStepDown,
+	},
+	"seal-keys": {
+		summary: "export the unseal keys Secret as a Bitnami SealedSecret",
+		run:     runSealKeys,
+	},
+	"verify-keys": {
+		summary: "check stored unseal keys for completeness and consistency",
+		run:     runVerifyKeys,
+	},
+}
+
+// Run executes the subcommand named by args[0] and returns the process exit code
+func Run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage(stderr)
+		return exitUsage
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n\n", args[0])
+		usage(stderr)
+		return exitUsage
+	}
+
+	if err := cmd.run(ctx, args[1:], stdout); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitUsage
+		}
+
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return exitFailure
+	}
+
+	return exitOK
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: vault-utils [command] [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Without a command the auto-unseal controller is started.")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-14s %s\n", name, commands[name].summary)
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'vault-utils <command> -h' for the flags of a command.")
+}
+
+// kubeFlags holds the flags shared by commands that talk to the Kubernetes API, and the TLS
+// settings of the Vault listener they reach through it or at -address
+type kubeFlags struct {
+	kubeconfig string
+	context    string
+	namespace  string
+	selector   string
+	// scheme is the scheme of the Vault listener on the pods, empty for the default
+	scheme string
+	// caCert and tlsServerName verify the certificate of the Vault at -address
+	caCert        string
+	tlsServerName string
+}
+
+func registerKubeFlags(fs *flag.FlagSet) *kubeFlags {
+	f := &kubeFlags{}
+	fs.StringVar(&f.kubeconfig, "kubeconfig", "", "path to the kubeconfig file (default $KUBECONFIG or ~/.kube/config)")
+	fs.StringVar(&f.context, "context", "", "kubeconfig context to use (default the current context)")
+	fs.StringVar(&f.namespace, "namespace", "vault", "namespace Vault runs in")
+	fs.StringVar(&f.selector, "selector", os.Getenv("VAULT_POD_SELECTOR"),
+		"label selector of the Vault pods, or several separated by ; (default $VAULT_POD_SELECTOR, or the labels of the hashicorp/vault chart)")
+	fs.StringVar(&f.scheme, "scheme", "", "scheme of the Vault listener on the pods, http or https (default https when $VAULT_TLS is true or -ca-cert is set)")
+	fs.StringVar(&f.caCert, "ca-cert", os.Getenv("VAULT_CACERT"), "PEM encoded CA bundle to verify the certificate of -address with (default $VAULT_CACERT, or the system roots)")
+	fs.StringVar(&f.tlsServerName, "tls-server-name", os.Getenv("VAULT_TLS_SERVER_NAME"), "name to verify the certificate of -address against (default $VAULT_TLS_SERVER_NAME, or the host of -address)")
+
+	return f
+}
+
+// vaultScheme returns the scheme of the Vault listener on the pods
+func (f *kubeFlags) vaultScheme() (string, error) {
+	switch f.scheme {
+	case "http", "https":
+		return f.scheme, nil
+	case "":
+		if os.Getenv("VAULT_TLS") == "true" || f.caCert != "" {
+			return "https", nil
+		}
+
+		return "http", nil
+	}
+
+	return "", fmt.Errorf("invalid -scheme %q, expected http or https", f.scheme)
+}
+
+// podClient returns a client to the Vault listener on port of pod through the API server. The
+// API server does not verify the certificate of a pod, so only the scheme applies.
+func (f *kubeFlags) podClient(client *kubernetes.Client, pod, port string) (*vault.Client, error) {
+	scheme, err := f.vaultScheme()
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL, httpClient, err := client.PodProxy(f.namespace, pod, scheme, port)
+	if err != nil {
+		return nil, err
+	}
+
+	return vault.NewClientWithHTTPClient(baseURL, httpClient), nil
+}
+
+// addressClient returns a client to the Vault at address, verifying an https:// address against
+// -ca-cert and -tls-server-name
+func (f *kubeFlags) addressClient(address string) (*vault.Client, error) {
+	var roots *x509.CertPool
+	if f.caCert != "" {
+		data, err := os.ReadFile(f.caCert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read -ca-cert: %v", err)
+		}
+		certs, err := vault.ParseCABundle(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse -ca-cert %s: %v", f.caCert, err)
+		}
+		roots = vault.NewCertPool(certs)
+	}
+
+	return vault.NewClientWithOptions(address, vault.NewTLSConfig(roots, f.tlsServerName, nil), vault.TransportOptions{}), nil
+}
+
+func (f *kubeFlags) client() (*kubernetes.Client, error) {
+	client, err := kubernetes.NewClientFromKubeconfig(f.kubeconfig, f.context)
+	if err != nil {
+		return nil, err
+	}
+	if selectors := config.SplitSelectors(f.selector); len(selectors) > 0 {
+		client.SetPodSelectors(selectors...)
+	}
+
+	return client, nil
+}
+
+// bucketFlags holds the flags of the commands reading the backup bucket, defaulting to the
+// BACKUP_S3_* variables the controller is configured with
+type bucketFlags struct {
+	bucket    string
+	region    string
+	endpoint  string
+	pathStyle bool
+}
+
+func registerBucketFlags(fs *flag.FlagSet) *bucketFlags {
+	f := &bucketFlags{}
+	fs.StringVar(&f.bucket, "bucket", os.Getenv("BACKUP_S3_BUCKET"), "S3 bucket the snapshots are stored in (default $BACKUP_S3_BUCKET)")
+	fs.StringVar(&f.region, "region", os.Getenv("BACKUP_S3_REGION"), "region of the bucket (default $BACKUP_S3_REGION)")
+	fs.StringVar(&f.endpoint, "endpoint", os.Getenv("BACKUP_S3_ENDPOINT"), "S3 API to use instead of the region's, such as MinIO (default $BACKUP_S3_ENDPOINT)")
+	fs.BoolVar(&f.pathStyle, "path-style", os.Getenv("BACKUP_S3_PATH_STYLE") == "true", "address the bucket in the path rather than the host name (default $BACKUP_S3_PATH_STYLE)")
+
+	return f
+}
+
+func (f *bucketFlags) store() (*backup.S3, error) {
+	credentials, err := backup.DefaultCredentials(f.region)
+	if err != nil {
+		return nil, err
+	}
+
+	return &backup.S3{Bucket: f.bucket, Region: f.region, Endpoint: f.endpoint, PathStyle: f.pathStyle, Credentials: credentials}, nil
+}
+
+// vaultClientFor returns a client to the Vault at address, or to an unsealed Vault pod of kube
+// through the API server when address is empty. The active node is preferred, though standbys
+// forward requests to it, since a sealed, uninitialized or unreachable pod cannot act.
+func vaultClientFor(ctx context.Context, client *kubernetes.Client, kube *kubeFlags, address, port string) (*vault.Client, error) {
+	if address != "" {
+		return kube.addressClient(address)
+	}
+
+	pods, err := client.GetVaultPodNames(kube.namespace)
+	if err != nil {
+		return nil, err
+	}
+	if len(pods) == 0 {
+		return nil, fmt.Errorf("no Vault pods found in namespace %s", kube.namespace)
+	}
+
+	var standby *vault.Client
+	var unusable []string
+	for _, pod := range pods {
+		vaultClient, err := kube.podClient(client, pod, port)
+		if err != nil {
+			return nil, err
+		}
+
+		health, err := vaultClient.Health(ctx)
+		switch {
+		case err != nil:
+			unusable = append(unusable, fmt.Sprintf("%s: %v", pod, err))
+		case !health.Initialized:
+			unusable = append(unusable, pod+" is not initialized")
+		case health.Sealed:
+			unusable = append(unusable, pod+" is sealed")
+		case !health.Standby:
+			if standby != nil {
+				standby.Close()
+			}
+
+			return vaultClient, nil
+		case standby == nil:
+			standby = vaultClient
+
+			continue
+		}
+		vaultClient.Close()
+	}
+	if standby != nil {
+		return standby, nil
+	}
+
+	return nil, fmt.Errorf("no Vault pod in namespace %s is unsealed: %s", kube.namespace, strings.Join(unusable, ", "))
+}
+
+// storedRootToken reads the root token stored in namespace
+func storedRootToken(client *kubernetes.Client, namespace string) (string, error) {
+	secret, err := client.GetSecret(namespace, vault.RootTokenSecret)
+	if err != nil {
+		return "", err
+	}
+	rootToken := string(secret.Data["token"])
+	if rootToken == "" {
+		return "", fmt.Errorf("secret %s/%s holds no root token", namespace, vault.RootTokenSecret)
+	}
+
+	return rootToken, nil
+}
+
+// cliIdentity names this CLI run as the holder of the action lock
+func cliIdentity() string {
+	name := "unknown"
+	if current, err := user.Current(); err == nil {
+		name = current.Username
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	return fmt.Sprintf("vault-utils-cli/%s@%s", name, hostname)
+}
@@ -0,0 +1,1011 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/backup"
+	"github.com/getgrowly/vault-utils/pkg/kubernetes"
+	"github.com/getgrowly/vault-utils/pkg/sealedsecrets"
+	"github.com/getgrowly/vault-utils/pkg/shamir"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func splitKeys(t *testing.T, shares, threshold int) []string {
+	t.Helper()
+
+	parts, err := shamir.Split([]byte("0123456789abcdef0123456789abcdef"), shares, threshold)
+	if err != nil {
+		t.Fatalf("failed to split secret: %v", err)
+	}
+
+	keys := make([]string, len(parts))
+	for i, part := range parts {
+		keys[i] = hex.EncodeToString(part)
+	}
+
+	return keys
+}
+
+func TestRunUsage(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected int
+	}{
+		{name: "no arguments", args: nil, expected: exitUsage},
+		{name: "help", args: []string{"help"}, expected: exitUsage},
+		{name: "unknown command", args: []string{"unseal-everything"}, expected: exitUsage},
+		{name: "command help", args: []string{"status", "-h"}, expected: exitUsage},
+		{name: "unknown flag", args: []string{"verify-keys", "-bogus"}, expected: exitFailure},
+		{name: "restore without confirmation", args: []string{"restore", "-bucket", "backups", "-key", "vault/latest.snap"}, expected: exitFailure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := Run(context.Background(), tt.args, &stdout, &stderr); code != tt.expected {
+				t.Errorf("expected exit code %d, got %d (stderr: %s)", tt.expected, code, stderr.String())
+			}
+		})
+	}
+}
+
+func TestVerifyKeyShares(t *testing.T) {
+	keys := splitKeys(t, 5, 3)
+	foreign := splitKeys(t, 5, 3)
+	base64Key := func(key string) string {
+		raw, err := hex.DecodeString(key)
+		if err != nil {
+			t.Fatalf("failed to decode key: %v", err)
+		}
+
+		return base64.StdEncoding.EncodeToString(raw)
+	}
+
+	tests := []struct {
+		name      string
+		keys      []string
+		threshold int
+		expected  string
+	}{
+		{name: "consistent", keys: keys, threshold: 3},
+		{name: "exactly the threshold", keys: keys[:3], threshold: 3},
+		{name: "unknown threshold", keys: keys, threshold: 0},
+		{name: "no keys", keys: nil, threshold: 3, expected: "no unseal keys"},
+		{name: "below threshold", keys: keys[:2], threshold: 3, expected: "only 2 keys stored"},
+		{name: "not hex", keys: []string{keys[0], "not-hex"}, threshold: 2, expected: "key 2 is neither hex nor base64"},
+		{name: "base64 and hex", keys: []string{keys[0], base64Key(keys[1]), base64Key(keys[2])}, threshold: 3},
+		{name: "duplicate", keys: []string{keys[0], keys[1], keys[0]}, threshold: 2, expected: "key 3 duplicates key 1"},
+		{name: "duplicate in base64", keys: []string{keys[0], keys[1], base64Key(keys[1])}, threshold: 2, expected: "key 3 duplicates key 2"},
+		{name: "length mismatch", keys: []string{keys[0], keys[1][2:]}, threshold: 2, expected: "key 2 has length"},
+		{
+			name:      "foreign key after the threshold",
+			keys:      []string{keys[0], keys[1], keys[2], keys[3], foreign[4]},
+			threshold: 3,
+			expected:  "keys [5] do not belong",
+		},
+		{
+			name:      "foreign key within the threshold",
+			keys:      []string{foreign[0], keys[1], keys[2], keys[3], keys[4]},
+			threshold: 3,
+			expected:  "one of keys 1-3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := verifyKeyShares(tt.keys, tt.threshold, &out)
+
+			if tt.expected == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("expected error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestVerifyKeysFromDir(t *testing.T) {
+	dir := t.TempDir()
+	for i, key := range splitKeys(t, 5, 3) {
+		// Trailing newlines, as left by editors and shell redirection, are ignored
+		path := filepath.Join(dir, fmt.Sprintf("key%d", i+1))
+		if err := os.WriteFile(path, []byte(key+"\n"), 0o600); err != nil {
+			t.Fatalf("failed to write key: %v", err)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	code := Run(context.Background(), []string{"verify-keys", "-keys-dir", dir, "-threshold", "3"}, &stdout, &stderr)
+	if code != exitOK {
+		t.Fatalf("expected exit code %d, got %d (stderr: %s)", exitOK, code, stderr.String())
+	}
+
+	if !strings.Contains(stdout.String(), "consistent with a 3-of-5 split") {
+		t.Errorf("unexpected output: %s", stdout.String())
+	}
+}
+
+func TestStatusWithAddress(t *testing.T) {
+	fake := vaulttest.NewInitializedServer(5, 3)
+	defer fake.Close()
+
+	var stdout, stderr bytes.Buffer
+	code := Run(context.Background(), []string{"status", "-address", fake.URL}, &stdout, &stderr)
+	if code != exitOK {
+		t.Fatalf("expected exit code %d, got %d (stderr: %s)", exitOK, code, stderr.String())
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected a header and one row, got %q", stdout.String())
+	}
+
+	fields := strings.Fields(lines[1])
+	expected := []string{fake.URL, "true", "true", "0/3"}
+	if strings.Join(fields, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected row %v, got %v", expected, fields)
+	}
+
+	// A Vault serving TLS with a certificate of a private CA is verified against -ca-cert
+	tlsVault := vaulttest.NewTLSServer()
+	defer tlsVault.Close()
+	caCert := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caCert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsVault.Certificate().Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	stdout.Reset()
+	if code := Run(context.Background(), []string{"status", "-address", tlsVault.URL}, &stdout, &stderr); code != exitFailure {
+		t.Errorf("expected a certificate from a private CA to be refused, got exit code %d", code)
+	}
+	stderr.Reset()
+	args := []string{"status", "-address", tlsVault.URL, "-ca-cert", caCert, "-tls-server-name", "example.com"}
+	if code := Run(context.Background(), args, &stdout, &stderr); code != exitOK {
+		t.Errorf("expected exit code %d with -ca-cert, got %d (stderr: %s)", exitOK, code, stderr.String())
+	}
+}
+
+func TestVaultTLSFlags(t *testing.T) {
+	fakeVault := vaulttest.NewTLSServer()
+	defer fakeVault.Close()
+
+	caCert := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: fakeVault.Certificate().Raw})
+	if err := os.WriteFile(caCert, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The system roots do not trust the fake
+	for _, test := range []struct {
+		kube    kubeFlags
+		trusted bool
+	}{
+		{kube: kubeFlags{}},
+		{kube: kubeFlags{caCert: caCert, tlsServerName: "example.com"}, trusted: true},
+	} {
+		vaultClient, err := vaultClientFor(context.Background(), nil, &test.kube, fakeVault.URL, "8200")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, err = vaultClient.CheckStatus(context.Background())
+		vaultClient.Close()
+		if test.trusted && err != nil {
+			t.Errorf("expected the fake to be trusted with -ca-cert, got %v", err)
+		}
+		if !test.trusted && err == nil {
+			t.Errorf("expected a certificate from a private CA to be refused")
+		}
+	}
+
+	// Vault's listener on the pods is reached with https when it serves TLS
+	t.Setenv("VAULT_TLS", "")
+	for _, test := range []struct {
+		kube     kubeFlags
+		vaultTLS string
+		expected string
+	}{
+		{expected: "http"},
+		{vaultTLS: "true", expected: "https"},
+		{kube: kubeFlags{caCert: caCert}, expected: "https"},
+		{kube: kubeFlags{scheme: "http"}, vaultTLS: "true", expected: "http"},
+	} {
+		t.Setenv("VAULT_TLS", test.vaultTLS)
+		if scheme, err := test.kube.vaultScheme(); err != nil || scheme != test.expected {
+			t.Errorf("expected %s with %+v and VAULT_TLS=%q, got %s (%v)", test.expected, test.kube, test.vaultTLS, scheme, err)
+		}
+	}
+	if _, err := (&kubeFlags{scheme: "ftp"}).vaultScheme(); err == nil {
+		t.Errorf("expected an unknown scheme to be refused")
+	}
+}
+
+func TestFromBankVaults(t *testing.T) {
+	keys := splitKeys(t, 3, 2)
+	data := map[string][]byte{
+		"vault-unseal-1": []byte(keys[1]),
+		"vault-unseal-0": []byte(keys[0] + "\n"),
+		"vault-unseal-2": []byte(keys[2]),
+		"vault-root":     []byte("s.root"),
+		"vault-test":     []byte("vault-test"),
+	}
+
+	migrated, err := fromBankVaults(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(migrated.keys, ",") != strings.Join(keys, ",") || migrated.rootToken != "s.root" {
+		t.Errorf("unexpected migrated keys %v and root token %q", migrated.keys, migrated.rootToken)
+	}
+
+	if _, err := fromBankVaults(map[string][]byte{"key1": []byte(keys[0])}); err == nil {
+		t.Errorf("expected an error for a Secret without bank-vaults keys")
+	}
+	if _, err := fromBankVaults(map[string][]byte{"vault-unseal-01": []byte(keys[0])}); err == nil {
+		t.Errorf("expected an error for a non-canonical key number")
+	}
+}
+
+func TestFromVaultInit(t *testing.T) {
+	keys := splitKeys(t, 3, 2)
+	keysBase64 := make([]string, len(keys))
+	for i, key := range keys {
+		raw, _ := hex.DecodeString(key)
+		keysBase64[i] = base64.StdEncoding.EncodeToString(raw)
+	}
+
+	tests := []struct {
+		name              string
+		initJSON          string
+		rootToken         string
+		expectedRootToken string
+		expectedError     string
+	}{
+		{
+			name:              "hex keys",
+			initJSON:          fmt.Sprintf(`{"keys":["%s"],"root_token":"s.root"}`, strings.Join(keys, `","`)),
+			expectedRootToken: "s.root",
+		},
+		{
+			name:              "base64 keys and separate root token",
+			initJSON:          fmt.Sprintf(`{"keys_base64":["%s"]}`, strings.Join(keysBase64, `","`)),
+			rootToken:         "s.separate\n",
+			expectedRootToken: "s.separate",
+		},
+		{
+			name:          "still encrypted",
+			initJSON:      "CiQA...",
+			expectedError: "is it decrypted",
+		},
+		{
+			name:          "no keys",
+			initJSON:      `{"root_token":"s.root"}`,
+			expectedError: "no keys found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrated, err := fromVaultInit([]byte(tt.initJSON), []byte(tt.rootToken))
+			if tt.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+					t.Fatalf("expected error containing %q, got %v", tt.expectedError, err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if strings.Join(migrated.keys, ",") != strings.Join(keys, ",") || migrated.rootToken != tt.expectedRootToken {
+				t.Errorf("unexpected migrated keys %v and root token %q", migrated.keys, migrated.rootToken)
+			}
+		})
+	}
+}
+
+func TestMigrateVerifiesBeforeWriting(t *testing.T) {
+	keys := splitKeys(t, 5, 3)
+	dir := t.TempDir()
+	initFile := filepath.Join(dir, "unseal-keys.json")
+	initJSON := fmt.Sprintf(`{"keys":["%s"],"root_token":"s.root"}`, strings.Join(keys, `","`))
+	if err := os.WriteFile(initFile, []byte(initJSON), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	code := Run(context.Background(), []string{"migrate", "-from", "vault-init", "-init-file", initFile, "-threshold", "3", "-dry-run"}, &stdout, &stderr)
+	if code != exitOK {
+		t.Fatalf("expected exit code %d, got %d (stderr: %s)", exitOK, code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "consistent with a 3-of-5 split") || !strings.Contains(stdout.String(), "no Secrets written") {
+		t.Errorf("unexpected output: %s", stdout.String())
+	}
+
+	// Keys that fail verification are never written, so no Kubernetes access is attempted
+	stdout.Reset()
+	stderr.Reset()
+	code = Run(context.Background(), []string{"migrate", "-from", "vault-init", "-init-file", initFile, "-threshold", "6"}, &stdout, &stderr)
+	if code != exitFailure || !strings.Contains(stderr.String(), "not migrating") {
+		t.Errorf("expected the migration to be refused, got exit code %d (stderr: %s)", code, stderr.String())
+	}
+}
+
+func TestWriteMigrated(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	client := kubernetes.NewClientWithInterface(clientset)
+	migrated := &migratedKeys{keys: splitKeys(t, 3, 2), rootToken: "s.root"}
+
+	var stdout bytes.Buffer
+	if err := writeMigrated(client, "vault", migrated, "bank-vaults", 2, false, &stdout); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	secret, err := client.GetSecret("vault", vault.UnsealKeysSecret)
+	if err != nil {
+		t.Fatalf("expected the unseal keys Secret: %v", err)
+	}
+	stored, _ := kubernetes.UnsealKeysFromSecret(secret.Data)
+	if strings.Join(stored, ",") != strings.Join(migrated.keys, ",") {
+		t.Errorf("expected the keys to be stored in order, got %v", stored)
+	}
+	if secret.Annotations[vault.ThresholdAnnotation] != "2" || secret.Annotations[vault.SharesAnnotation] != "3" || secret.Annotations[vault.MigratedFromAnnotation] != "bank-vaults" {
+		t.Errorf("unexpected annotations %v", secret.Annotations)
+	}
+	rootToken, err := client.GetSecret("vault", vault.RootTokenSecret)
+	if err != nil || string(rootToken.Data["token"]) != "s.root" {
+		t.Errorf("expected the root token Secret, got %v", err)
+	}
+
+	// Existing Secrets are only replaced with -force
+	if err := writeMigrated(client, "vault", migrated, "bank-vaults", 2, false, &stdout); err == nil || !strings.Contains(err.Error(), "-force") {
+		t.Errorf("expected existing Secrets to be kept, got %v", err)
+	}
+	if err := writeMigrated(client, "vault", migrated, "bank-vaults", 2, true, &stdout); err != nil {
+		t.Errorf("expected -force to replace the Secrets, got %v", err)
+	}
+}
+
+func TestRekeyRecovery(t *testing.T) {
+	fakeVault := vaulttest.NewAutoUnsealServer(5, 3)
+	defer fakeVault.Close()
+
+	clientset := fake.NewSimpleClientset()
+	client := kubernetes.NewClientWithInterface(clientset)
+	store := &recoveryKeysSecret{client: client}
+	if err := store.Store(context.Background(), "vault", fakeVault.Keys()); err != nil {
+		t.Fatalf("failed to store recovery keys: %v", err)
+	}
+
+	var stdout bytes.Buffer
+	if err := rekeyRecovery(context.Background(), vault.NewClient(fakeVault.URL), client, store, "vault", "", 0, 2, &stdout); err != nil {
+		t.Fatalf("unexpected error: %v (output: %s)", err, stdout.String())
+	}
+
+	stored, err := store.Keys(context.Background(), "vault")
+	if err != nil {
+		t.Fatalf("failed to read recovery keys: %v", err)
+	}
+	if strings.Join(stored, ",") != strings.Join(fakeVault.Keys(), ",") || len(stored) != 5 {
+		t.Errorf("expected the 5 new recovery keys to be stored, got %v", stored)
+	}
+	if !strings.Contains(stdout.String(), "5 shares, threshold 2") {
+		t.Errorf("unexpected output: %s", stdout.String())
+	}
+	if _, err := clientset.CoordinationV1().Leases("vault").Get(context.Background(), kubernetes.ActionLockName, metav1.GetOptions{}); err == nil {
+		t.Errorf("expected the action lock to be released")
+	}
+
+	// A Shamir Vault has no recovery keys
+	shamirVault := vaulttest.NewInitializedServer(5, 3)
+	defer shamirVault.Close()
+	err = rekeyRecovery(context.Background(), vault.NewClient(shamirVault.URL), client, store, "vault", "", 0, 0, &stdout)
+	if err == nil || !strings.Contains(err.Error(), "only exist with auto-unseal") {
+		t.Errorf("expected a Shamir Vault to be refused, got %v", err)
+	}
+}
+
+func TestGenerateRoot(t *testing.T) {
+	shamirVault := vaulttest.NewInitializedServer(5, 3)
+	defer shamirVault.Close()
+	if err := vault.NewClient(shamirVault.URL).UnsealWithKeysFromDir(context.Background(), shamirVault.Keys()); err != nil {
+		t.Fatalf("failed to unseal: %v", err)
+	}
+	autoUnsealVault := vaulttest.NewAutoUnsealServer(5, 3)
+	defer autoUnsealVault.Close()
+
+	tests := []struct {
+		name      string
+		fakeVault *vaulttest.Server
+		secret    string
+	}{
+		{name: "unseal keys", fakeVault: shamirVault, secret: vault.UnsealKeysSecret},
+		{name: "recovery keys", fakeVault: autoUnsealVault, secret: vault.RecoveryKeysSecret},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := make(map[string][]byte)
+			for i, key := range tt.fakeVault.Keys() {
+				data[fmt.Sprintf("key%d", i+1)] = []byte(key)
+			}
+			clientset := fake.NewSimpleClientset(&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: tt.secret, Namespace: "vault"},
+				Data:       data,
+			})
+			client := kubernetes.NewClientWithInterface(clientset)
+
+			var stdout bytes.Buffer
+			if err := generateRoot(context.Background(), vault.NewClient(tt.fakeVault.URL), client, nil, "vault", &stdout); err != nil {
+				t.Fatalf("unexpected error: %v (output: %s)", err, stdout.String())
+			}
+			if !strings.Contains(stdout.String(), "Root token: "+tt.fakeVault.RootToken()) {
+				t.Errorf("expected the new root token to be printed, got: %s", stdout.String())
+			}
+			if !strings.Contains(stdout.String(), "secret "+tt.secret) {
+				t.Errorf("expected the keys to be read from secret %s, got: %s", tt.secret, stdout.String())
+			}
+			if _, err := clientset.CoordinationV1().Leases("vault").Get(context.Background(), kubernetes.ActionLockName, metav1.GetOptions{}); err == nil {
+				t.Errorf("expected the action lock to be released")
+			}
+		})
+	}
+
+	// Without enough keys nothing is submitted
+	clientset := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: vault.RecoveryKeysSecret, Namespace: "vault"},
+		Data:       map[string][]byte{"key1": []byte(autoUnsealVault.Keys()[0])},
+	})
+	var stdout bytes.Buffer
+	err := generateRoot(context.Background(), vault.NewClient(autoUnsealVault.URL), kubernetes.NewClientWithInterface(clientset), nil, "vault", &stdout)
+	if err == nil || !strings.Contains(err.Error(), "3 keys are needed") {
+		t.Errorf("expected too few keys to be refused, got %v", err)
+	}
+}
+
+func TestIssueToken(t *testing.T) {
+	fakeVault := vaulttest.NewAutoUnsealServer(5, 3)
+	defer fakeVault.Close()
+	vaultClient := vault.NewClient(fakeVault.URL)
+
+	clientset := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: vault.RootTokenSecret, Namespace: "vault"},
+		Data:       map[string][]byte{"token": []byte(fakeVault.RootToken())},
+	})
+	client := kubernetes.NewClientWithInterface(clientset)
+
+	var stdout bytes.Buffer
+	opts := tokenOptions{policies: []string{"admin"}, ttl: time.Hour, numUses: 10}
+	if err := issueToken(context.Background(), vaultClient, client, "vault", opts, &stdout); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "Token:") || !strings.Contains(stdout.String(), "TTL:      1h0m0s") {
+		t.Errorf("unexpected output: %s", stdout.String())
+	}
+
+	stdout.Reset()
+	opts.wrapTTL = 5 * time.Minute
+	if err := issueToken(context.Background(), vaultClient, client, "vault", opts, &stdout); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "Wrapping token:") || strings.Contains(stdout.String(), "Accessor:") {
+		t.Errorf("expected only the wrapping token to be printed, got: %s", stdout.String())
+	}
+
+	tokens := fakeVault.Tokens()
+	if len(tokens) != 2 {
+		t.Fatalf("expected 2 tokens to be created, got %d", len(tokens))
+	}
+	var wrapped int
+	for _, token := range tokens {
+		if strings.Join(token.Policies, ",") != "admin" || token.TTL != "3600s" || token.NumUses != 10 || token.Meta["requested_by"] == "" {
+			t.Errorf("unexpected token: %+v", token)
+		}
+		if token.Wrapped {
+			wrapped++
+		}
+	}
+	if wrapped != 1 {
+		t.Errorf("expected 1 wrapped token, got %d", wrapped)
+	}
+
+	// Root tokens and tokens without policies are refused before Vault is asked
+	for _, opts := range []tokenOptions{
+		{policies: []string{"admin", "root"}, ttl: time.Hour},
+		{ttl: time.Hour},
+	} {
+		if err := issueToken(context.Background(), vaultClient, client, "vault", opts, &stdout); err == nil {
+			t.Errorf("expected %v to be refused", opts.policies)
+		}
+	}
+	if len(fakeVault.Tokens()) != 2 {
+		t.Errorf("expected refused requests not to reach Vault")
+	}
+
+	// Without the root token Secret there is nothing to mint with
+	err := issueToken(context.Background(), vaultClient, kubernetes.NewClientWithInterface(fake.NewSimpleClientset()), "vault", tokenOptions{policies: []string{"admin"}, ttl: time.Hour}, &stdout)
+	if err == nil {
+		t.Errorf("expected an error without the root token Secret")
+	}
+}
+
+// proxyAPIServer is a fake API server listing Vault pods in the vault namespace and proxying
+// requests to them, recording the pod and scheme of the last one
+type proxyAPIServer struct {
+	*httptest.Server
+	vaults map[string]*vaulttest.Server
+
+	mu      sync.Mutex
+	lastPod string
+	schemes map[string]bool
+}
+
+func newProxyAPIServer(t *testing.T, vaults map[string]*vaulttest.Server) (*proxyAPIServer, *kubernetes.Client) {
+	t.Helper()
+
+	s := &proxyAPIServer{vaults: vaults, schemes: map[string]bool{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+
+	kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+	data := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: %s
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+users:
+- name: test
+  user: {}
+current-context: test
+`, s.URL)
+	if err := os.WriteFile(kubeconfig, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	client, err := kubernetes.NewClientFromKubeconfig(kubeconfig, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s, client
+}
+
+func (s *proxyAPIServer) serve(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/api/v1/namespaces/vault/pods" {
+		list := corev1.PodList{TypeMeta: metav1.TypeMeta{Kind: "PodList", APIVersion: "v1"}}
+		for name := range s.vaults {
+			list.Items = append(list.Items, corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "vault"}})
+		}
+		sort.Slice(list.Items, func(i, j int) bool { return list.Items[i].Name < list.Items[j].Name })
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+
+		return
+	}
+
+	target, path, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/v1/namespaces/vault/pods/"), "/proxy")
+	scheme, rest, _ := strings.Cut(target, ":")
+	pod, _, _ := strings.Cut(rest, ":")
+	fakeVault, ok := s.vaults[pod]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	s.mu.Lock()
+	s.lastPod = pod
+	s.schemes[scheme] = true
+	s.mu.Unlock()
+
+	req, _ := http.NewRequestWithContext(r.Context(), r.Method, fakeVault.URL+path, r.Body)
+	req.Header = r.Header.Clone()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+func TestVaultClientForPicksUnsealedPod(t *testing.T) {
+	sealed := vaulttest.NewInitializedServer(5, 3)
+	defer sealed.Close()
+	standby := vaulttest.NewAutoUnsealServer(5, 3)
+	defer standby.Close()
+	standby.SetHA(true)
+	active := vaulttest.NewAutoUnsealServer(5, 3)
+	defer active.Close()
+	active.SetHA(false)
+	uninitialized := vaulttest.NewServer()
+	defer uninitialized.Close()
+
+	for _, test := range []struct {
+		name     string
+		vaults   map[string]*vaulttest.Server
+		expected string
+	}{
+		{
+			name:     "active node preferred",
+			vaults:   map[string]*vaulttest.Server{"vault-0": sealed, "vault-1": standby, "vault-2": active},
+			expected: "vault-2",
+		},
+		{
+			name:     "standby without an active node",
+			vaults:   map[string]*vaulttest.Server{"vault-0": sealed, "vault-1": standby},
+			expected: "vault-1",
+		},
+		{
+			name:   "every pod sealed",
+			vaults: map[string]*vaulttest.Server{"vault-0": sealed, "vault-1": uninitialized},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			apiServer, client := newProxyAPIServer(t, test.vaults)
+			kube := &kubeFlags{namespace: "vault", scheme: "https"}
+
+			vaultClient, err := vaultClientFor(context.Background(), client, kube, "", "8200")
+			if test.expected == "" {
+				if err == nil || !strings.Contains(err.Error(), "vault-0 is sealed, vault-1 is not initialized") {
+					t.Errorf("expected an error naming the sealed pods, got %v", err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer vaultClient.Close()
+
+			if _, err := vaultClient.CheckStatus(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if apiServer.lastPod != test.expected {
+				t.Errorf("expected %s to be picked, got %s", test.expected, apiServer.lastPod)
+			}
+			// The pods are reached with the scheme of their listener
+			if len(apiServer.schemes) != 1 || !apiServer.schemes["https"] {
+				t.Errorf("expected the pods to be reached with https, got %v", apiServer.schemes)
+			}
+		})
+	}
+}
+
+func TestStepDown(t *testing.T) {
+	fakeVault := vaulttest.NewAutoUnsealServer(5, 3)
+	defer fakeVault.Close()
+	vaultClient := vault.NewClient(fakeVault.URL)
+
+	client := kubernetes.NewClientWithInterface(fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: vault.RootTokenSecret, Namespace: "vault"},
+		Data:       map[string][]byte{"token": []byte(fakeVault.RootToken())},
+	}))
+
+	// Without HA there is no standby to take over
+	var stdout bytes.Buffer
+	if err := stepDown(context.Background(), vaultClient, client, "vault", &stdout); err == nil {
+		t.Errorf("expected an error without HA")
+	}
+	if fakeVault.StepDowns() != 0 {
+		t.Errorf("expected Vault not to be asked to step down without HA")
+	}
+
+	fakeVault.SetHA(false)
+	if err := stepDown(context.Background(), vaultClient, client, "vault", &stdout); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fakeVault.StepDowns() != 1 || !strings.Contains(stdout.String(), "stepped down") {
+		t.Errorf("expected the active node to step down, got %d requests and output: %s", fakeVault.StepDowns(), stdout.String())
+	}
+}
+
+// memoryStore is a backup store holding snapshots in memory
+type memoryStore map[string][]byte
+
+func (s memoryStore) Put(_ context.Context, key string, body io.Reader) (int64, error) {
+	data, err := io.ReadAll(body)
+	s[key] = data
+
+	return int64(len(data)), err
+}
+
+func (s memoryStore) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	data, ok := s[key]
+	if !ok {
+		return nil, fmt.Errorf("no object %s", key)
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s memoryStore) List(_ context.Context, prefix string) ([]backup.Object, error) {
+	var objects []backup.Object
+	for key, data := range s {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, backup.Object{Key: key, Size: int64(len(data))})
+		}
+	}
+
+	return objects, nil
+}
+
+func (s memoryStore) Delete(_ context.Context, key string) error {
+	delete(s, key)
+
+	return nil
+}
+
+func (s memoryStore) String() string {
+	return "memory://backups"
+}
+
+func TestRestoreSnapshot(t *testing.T) {
+	cluster := vaulttest.NewRaftCluster(1)
+	defer cluster[0].Close()
+	vaultClient := vault.NewClient(cluster[0].URL)
+	resp, err := vaultClient.Initialize(context.Background())
+	if err != nil {
+		t.Fatalf("failed to initialize: %v", err)
+	}
+	if err := vaultClient.UnsealWithKeysFromDir(context.Background(), resp.Keys); err != nil {
+		t.Fatalf("failed to unseal: %v", err)
+	}
+
+	client := kubernetes.NewClientWithInterface(fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: vault.RootTokenSecret, Namespace: "vault"},
+		Data:       map[string][]byte{"token": []byte(resp.RootToken)},
+	}))
+	store := memoryStore{"vault/a.snap": vaulttest.Snapshot, "staging/a.snap": []byte("snapshot of another cluster")}
+
+	var stdout bytes.Buffer
+	if err := restoreSnapshot(context.Background(), vaultClient, client, store, nil, "vault", "vault/a.snap", "", false, &stdout); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if restored, _ := cluster[0].Restored(); !bytes.Equal(restored, vaulttest.Snapshot) || !strings.Contains(stdout.String(), "memory://backups/vault/a.snap") {
+		t.Errorf("expected the snapshot to be restored with the stored root token, got %q and output: %s", restored, stdout.String())
+	}
+
+	if err := restoreSnapshot(context.Background(), vaultClient, client, store, nil, "vault", "staging/a.snap", "", false, &stdout); err == nil {
+		t.Errorf("expected a snapshot of another cluster to need force")
+	}
+	stdout.Reset()
+	if err := restoreSnapshot(context.Background(), vaultClient, client, store, nil, "vault", "staging/a.snap", resp.RootToken, true, &stdout); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, forced := cluster[0].Restored(); !forced || !strings.Contains(stdout.String(), "unseal keys of the cluster") {
+		t.Errorf("expected a forced restore with a warning about the keys, got output: %s", stdout.String())
+	}
+}
+
+func TestBackups(t *testing.T) {
+	store := memoryStore{"vault/20240101T000000Z.snap": []byte("old snapshot"), "vault/20240102T000000Z.snap.enc": []byte("new snapshot")}
+	err := backup.RecordSnapshot(context.Background(), store, "vault/", backup.Entry{
+		Key:          "vault/20240102T000000Z.snap.enc",
+		TakenAt:      time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		Duration:     "3s",
+		Pod:          "10.0.0.1",
+		VaultVersion: "1.15.0",
+		Encrypted:    true,
+		SHA256:       fmt.Sprintf("%x", sha256.Sum256([]byte("new snapshot"))),
+	})
+	if err != nil {
+		t.Fatalf("failed to record the snapshot: %v", err)
+	}
+
+	var stdout bytes.Buffer
+	if err := listBackups(context.Background(), store, "vault/", &stdout); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[1], "vault/20240102T000000Z.snap.enc") || !strings.Contains(lines[1], "1.15.0") ||
+		!strings.Contains(lines[2], "vault/20240101T000000Z.snap") || strings.Contains(lines[2], "1.15.0") {
+		t.Errorf("expected the recorded snapshot first and the other one without details, got:\n%s", stdout.String())
+	}
+
+	stdout.Reset()
+	if err := inspectBackup(context.Background(), store, "vault/20240102T000000Z.snap.enc", true, &stdout); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "10.0.0.1") || !strings.Contains(stdout.String(), "matches its checksum") {
+		t.Errorf("expected the details of the snapshot and its checksum verified, got:\n%s", stdout.String())
+	}
+
+	store["vault/20240102T000000Z.snap.enc"] = []byte("altered snapshot")
+	if err := inspectBackup(context.Background(), store, "vault/20240102T000000Z.snap.enc", true, io.Discard); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("expected an altered snapshot to fail verification, got %v", err)
+	}
+	if err := inspectBackup(context.Background(), store, "vault/20240101T000000Z.snap", true, io.Discard); err == nil || !strings.Contains(err.Error(), "no checksum") {
+		t.Errorf("expected a snapshot the catalog did not record to have nothing to verify against, got %v", err)
+	}
+	if err := inspectBackup(context.Background(), store, "vault/missing.snap", false, io.Discard); err == nil {
+		t.Errorf("expected a missing snapshot to fail")
+	}
+}
+
+func TestDevVault(t *testing.T) {
+	fakeVault := vaulttest.NewServer()
+	defer fakeVault.Close()
+	vaultClient := vault.NewClient(fakeVault.URL)
+	keysDir := filepath.Join(t.TempDir(), "dev")
+
+	// A new Vault is initialized with a single key, which is kept and unseals it
+	var stdout bytes.Buffer
+	rootToken, err := devVault(context.Background(), vaultClient, keysDir, time.Second, &stdout)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !fakeVault.Initialized() || fakeVault.Sealed() || len(fakeVault.Keys()) != 1 {
+		t.Fatalf("expected Vault to be initialized with one key and unsealed, got initialized %t sealed %t with %d keys", fakeVault.Initialized(), fakeVault.Sealed(), len(fakeVault.Keys()))
+	}
+	if rootToken == "" || rootToken != fakeVault.RootToken() {
+		t.Errorf("expected the root token %q, got %q", fakeVault.RootToken(), rootToken)
+	}
+	info, err := os.Stat(filepath.Join(keysDir, "key1"))
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("expected the key kept readable by the user only, got %v", err)
+	}
+
+	// A restarted Vault is unsealed with the kept key
+	fakeVault.Seal()
+	if _, err := devVault(context.Background(), vaultClient, keysDir, time.Second, &stdout); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fakeVault.Sealed() {
+		t.Errorf("expected Vault to be unsealed with the kept key")
+	}
+
+	// Without its keys an initialized Vault cannot be unsealed
+	fakeVault.Seal()
+	if _, err := devVault(context.Background(), vaultClient, t.TempDir()+"/missing", time.Second, &stdout); err == nil {
+		t.Errorf("expected an error without the keys")
+	}
+}
+
+func TestDevContainer(t *testing.T) {
+	defer func(original func(context.Context, string, ...string) (string, error)) { runDocker = original }(runDocker)
+
+	tests := []struct {
+		name     string
+		inspect  string
+		missing  bool
+		expected string
+	}{
+		{name: "missing", missing: true, expected: "run --detach --name vault-utils-dev --cap-add IPC_LOCK --publish 127.0.0.1:8300:8200"},
+		{name: "stopped", inspect: "false", expected: "start vault-utils-dev"},
+		{name: "running", inspect: "true"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			runDocker = func(_ context.Context, docker string, args ...string) (string, error) {
+				calls = append(calls, strings.Join(args, " "))
+				if args[0] == "inspect" && tt.missing {
+					return "", fmt.Errorf("no such object: %s", args[len(args)-1])
+				}
+
+				return tt.inspect, nil
+			}
+
+			container := devContainer{docker: "docker", name: "vault-utils-dev", image: "hashicorp/vault:1.15", port: "8300"}
+			if err := container.ensure(context.Background(), io.Discard); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.expected == "" {
+				if len(calls) != 1 {
+					t.Errorf("expected only an inspect, got %v", calls)
+				}
+				return
+			}
+			if len(calls) != 2 || !strings.HasPrefix(calls[1], tt.expected) {
+				t.Errorf("expected %q after the inspect, got %v", tt.expected, calls)
+			}
+		})
+	}
+}
+
+func TestSealSecret(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	keys := splitKeys(t, 3, 2)
+	clientset := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: vault.UnsealKeysSecret, Namespace: "vault"},
+		Data:       map[string][]byte{"key1": []byte(keys[0]), "key2": []byte(keys[1]), "key3": []byte(keys[2])},
+	}, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "empty", Namespace: "vault"},
+	})
+	client := kubernetes.NewClientWithInterface(clientset)
+
+	manifest, err := sealSecret(client, "vault", vault.UnsealKeysSecret, certPEM, sealedsecrets.Strict)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, expected := range []string{"kind: SealedSecret", "apiVersion: bitnami.com/v1alpha1", "namespace: vault", "key3: "} {
+		if !strings.Contains(string(manifest), expected) {
+			t.Errorf("expected %q in the manifest:\n%s", expected, manifest)
+		}
+	}
+	for _, key := range keys {
+		if strings.Contains(string(manifest), key) {
+			t.Errorf("expected no plaintext key in the manifest")
+		}
+	}
+
+	if _, err := sealSecret(client, "vault", "empty", certPEM, sealedsecrets.Strict); err == nil {
+		t.Errorf("expected a Secret without data to be refused")
+	}
+	if _, err := sealSecret(client, "vault", vault.UnsealKeysSecret, []byte("not a certificate"), sealedsecrets.Strict); err == nil {
+		t.Errorf("expected an invalid certificate to be refused")
+	}
+}
+
+func FuzzVerifyKeyShares(f *testing.F) {
+	parts, err := shamir.Split([]byte("seed secret"), 3, 2)
+	if err != nil {
+		f.Fatalf("failed to split secret: %v", err)
+	}
+	var seed []string
+	for _, part := range parts {
+		seed = append(seed, hex.EncodeToString(part))
+	}
+
+	f.Add(strings.Join(seed, "\n"), 2)
+	f.Add(strings.Join(seed, "\n"), 0)
+	f.Add("00\n00", 2)
+	f.Add("zz\n0102", 1)
+	f.Add("0101\n0201\n0301", 300)
+
+	f.Fuzz(func(t *testing.T, keyFile string, threshold int) {
+		var keys []string
+		if keyFile != "" {
+			keys = strings.Split(keyFile, "\n")
+		}
+
+		// Only a panic is a failure; any input must be rejected or accepted cleanly
+		_ = verifyKeyShares(keys, threshold, io.Discard)
+	})
+}
@@ -0,0 +1,199 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/kubernetes"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+)
+
+// devRootTokenFile is the file of the keys directory holding the root token, next to the key<N>
+// files of the unseal key, so the directory reads like the Secrets the controller stores
+const devRootTokenFile = "root-token"
+
+// devVaultConfig runs Vault with file storage and a plain HTTP listener, rather than in
+// Vault's own dev mode, so it starts sealed and uninitialized like a real one
+const devVaultConfig = `{"storage":{"file":{"path":"/vault/file"}},"listener":[{"tcp":{"address":"0.0.0.0:8200","tls_disable":true}}],"disable_mlock":true,"ui":true}`
+
+// runDocker runs the docker CLI and returns its trimmed output. It is a variable so tests can
+// stand in for docker.
+var runDocker = func(ctx context.Context, docker string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, docker, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s %s: %w: %s", docker, args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// devContainer describes the local Vault container
+type devContainer struct {
+	docker string
+	name   string
+	image  string
+	port   string
+}
+
+func runDev(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("dev", flag.ContinueOnError)
+	container := devContainer{}
+	fs.StringVar(&container.docker, "docker", "docker", "docker CLI to run, such as podman")
+	fs.StringVar(&container.name, "name", "vault-utils-dev", "name of the Vault container, attached to when it exists")
+	fs.StringVar(&container.image, "image", "hashicorp/vault:1.15", "Vault image the container is started from")
+	fs.StringVar(&container.port, "port", "8200", "port Vault is published on at 127.0.0.1")
+	keysDir := fs.String("keys-dir", "", "directory the unseal key and root token are kept in (default ~/.vault-utils/<name>)")
+	timeout := fs.Duration("timeout", time.Minute, "how long to wait for Vault to answer")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *keysDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return fmt.Errorf("no home directory for the keys, pass -keys-dir: %w", err)
+		}
+		*keysDir = filepath.Join(home, ".vault-utils", container.name)
+	}
+
+	if err := container.ensure(ctx, stdout); err != nil {
+		return err
+	}
+
+	address := "http://127.0.0.1:" + container.port
+	vaultClient := vault.NewClient(address)
+	defer vaultClient.Close()
+
+	rootToken, err := devVault(ctx, vaultClient, *keysDir, *timeout, stdout)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(stdout)
+	fmt.Fprintf(stdout, "Vault is unsealed at %s, keys are kept in %s\n", address, *keysDir)
+	fmt.Fprintln(stdout)
+	fmt.Fprintf(stdout, "  export VAULT_ADDR=%s\n", address)
+	fmt.Fprintf(stdout, "  export VAULT_TOKEN=%s\n", rootToken)
+	fmt.Fprintln(stdout)
+	fmt.Fprintf(stdout, "Stop it with '%s stop %s', run 'vault-utils dev' again to bring it back.\n", container.docker, container.name)
+
+	return nil
+}
+
+// ensure starts the container, or attaches to it when it already exists, starting it again
+// when it was stopped. Its storage outlives a stop, so the keys kept from the first run still
+// unseal it.
+func (d devContainer) ensure(ctx context.Context, stdout io.Writer) error {
+	running, err := runDocker(ctx, d.docker, "inspect", "--format", "{{.State.Running}}", d.name)
+	if err != nil {
+		// docker inspect fails the same way for a missing container and a missing daemon, and
+		// docker run reports the latter
+		fmt.Fprintf(stdout, "Starting Vault container %s from %s\n", d.name, d.image)
+		_, err := runDocker(ctx, d.docker, "run", "--detach",
+			"--name", d.name,
+			"--cap-add", "IPC_LOCK",
+			"--publish", "127.0.0.1:"+d.port+":8200",
+			"--env", "VAULT_LOCAL_CONFIG="+devVaultConfig,
+			d.image, "server")
+
+		return err
+	}
+
+	if running != "true" {
+		fmt.Fprintf(stdout, "Starting the stopped Vault container %s\n", d.name)
+		_, err := runDocker(ctx, d.docker, "start", d.name)
+
+		return err
+	}
+
+	fmt.Fprintf(stdout, "Attaching to the running Vault container %s\n", d.name)
+
+	return nil
+}
+
+// devVault waits for the Vault behind vaultClient to answer, initializes it with a single key
+// when it is new, keeping the key and root token in keysDir, and unseals it with the kept key.
+// It returns the root token.
+func devVault(ctx context.Context, vaultClient *vault.Client, keysDir string, timeout time.Duration, stdout io.Writer) (string, error) {
+	status, err := waitForDevVault(ctx, vaultClient, timeout)
+	if err != nil {
+		return "", err
+	}
+
+	if !status.Initialized {
+		fmt.Fprintf(stdout, "Initializing Vault with a single unseal key\n")
+		initResp, err := vaultClient.InitializeWithRequest(ctx, vault.InitRequest{SecretShares: 1, SecretThreshold: 1})
+		if err != nil {
+			return "", err
+		}
+		if err := writeDevKeys(keysDir, initResp); err != nil {
+			return "", fmt.Errorf("vault was initialized but its keys could not be kept, remove the container and start over: %w", err)
+		}
+		status.Sealed = true
+	}
+
+	data, err := readKeysDir(keysDir)
+	if err != nil {
+		return "", fmt.Errorf("vault is initialized but its keys are not in %s: %w", keysDir, err)
+	}
+	rootToken := string(data[devRootTokenFile])
+	keys, _ := kubernetes.UnsealKeysFromSecret(data)
+
+	if status.Sealed {
+		fmt.Fprintf(stdout, "Unsealing Vault\n")
+		if err := vaultClient.UnsealWithKeysFromDir(ctx, keys); err != nil {
+			return "", err
+		}
+	}
+
+	return rootToken, nil
+}
+
+// waitForDevVault polls the seal status of a Vault that is starting until it answers
+func waitForDevVault(ctx context.Context, vaultClient *vault.Client, timeout time.Duration) (*vault.Status, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		status, err := vaultClient.CheckStatus(ctx)
+		if err == nil {
+			return status, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("vault did not answer within %v: %w", timeout, err)
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// writeDevKeys keeps the keys and root token of a new Vault in keysDir, readable by the user only
+func writeDevKeys(keysDir string, initResp *vault.InitResponse) error {
+	if err := os.MkdirAll(keysDir, 0o700); err != nil {
+		return err
+	}
+
+	files := map[string]string{devRootTokenFile: initResp.RootToken}
+	for i, key := range initResp.Keys {
+		files[fmt.Sprintf("key%d", i+1)] = key
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(keysDir, name), []byte(content+"\n"), 0o600); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/getgrowly/vault-utils/pkg/keyprovider"
+	"github.com/getgrowly/vault-utils/pkg/kubernetes"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+)
+
+func runGenerateRoot(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("generate-root", flag.ContinueOnError)
+	address := fs.String("address", "", "talk to this Vault address directly instead of an unsealed pod found through Kubernetes, the active one if any")
+	port := fs.String("port", "8200", "port of the Vault listener on the pods")
+	providerSpec := fs.String("key-provider", os.Getenv("KEY_PROVIDER"), "key provider the keys are kept with, as for the controller (default $KEY_PROVIDER, or the "+vault.UnsealKeysSecret+" or "+vault.RecoveryKeysSecret+" Secret)")
+	kube := registerKubeFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	client, err := kube.client()
+	if err != nil {
+		return err
+	}
+
+	var store keyprovider.Provider
+	if *providerSpec != "" {
+		if store, err = keyprovider.Parse(*providerSpec); err != nil {
+			return err
+		}
+	}
+
+	vaultClient, err := vaultClientFor(ctx, client, kube, *address, *port)
+	if err != nil {
+		return err
+	}
+	defer vaultClient.Close()
+
+	return generateRoot(ctx, vaultClient, client, store, kube.namespace, stdout)
+}
+
+// generateRoot generates a new root token from the keys kept in store, or in the Secret matching
+// the seal of Vault when store is nil, and prints it
+func generateRoot(ctx context.Context, vaultClient *vault.Client, client *kubernetes.Client, store keyprovider.Provider, namespace string, stdout io.Writer) error {
+	status, err := vaultClient.CheckStatus(ctx)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !status.Initialized:
+		return fmt.Errorf("vault is not initialized")
+	case status.Sealed:
+		return fmt.Errorf("vault is sealed, unseal it before generating a root token")
+	}
+
+	// An auto-unseal Vault authorizes the generation with its recovery keys
+	var keys []string
+	source := fmt.Sprintf("secret %s", vault.UnsealKeysSecret)
+	if status.AutoUnseal() {
+		source = fmt.Sprintf("secret %s", vault.RecoveryKeysSecret)
+	}
+	if store != nil {
+		source = store.String()
+		storeCtx, cancel := context.WithTimeout(ctx, keyprovider.DefaultTimeout)
+		keys, err = store.Keys(storeCtx, namespace)
+		cancel()
+	} else if status.AutoUnseal() {
+		keys, err = secretKeys(client, namespace, vault.RecoveryKeysSecret)
+	} else {
+		keys, err = secretKeys(client, namespace, vault.UnsealKeysSecret)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read keys from %s: %w", source, err)
+	}
+	if len(keys) < status.Threshold {
+		return fmt.Errorf("%d keys are needed to generate a root token, %s holds %d", status.Threshold, source, len(keys))
+	}
+
+	// Hold the action lock so no other actor submits keys at the same time
+	lock, err := client.AcquireActionLock(namespace, kubernetes.ActionGenerateRoot, cliIdentity())
+	if err != nil {
+		return fmt.Errorf("not generating a root token: %w", err)
+	}
+	defer func() {
+		if err := lock.Release(); err != nil {
+			fmt.Fprintf(stdout, "warning: %v\n", err)
+		}
+	}()
+
+	token, err := vaultClient.GenerateRoot(ctx, keys)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "Generated a root token with %d keys from %s\n", status.Threshold, source)
+	fmt.Fprintf(stdout, "Root token: %s\n", token)
+	fmt.Fprintln(stdout, "It never expires: revoke it once done with: vault token revoke -self")
+
+	return nil
+}
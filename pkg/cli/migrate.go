@@ -0,0 +1,259 @@
+package cli
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/kubernetes"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	sourceBankVaults = "bank-vaults"
+	sourceVaultInit  = "vault-init"
+)
+
+// migratedKeys is the key material read from another unseal automation
+type migratedKeys struct {
+	keys      []string
+	rootToken string
+}
+
+func runMigrate(_ context.Context, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	from := fs.String("from", "", "format of the source: bank-vaults or vault-init")
+	sourceSecret := fs.String("source-secret", "vault-unseal-keys", "bank-vaults: Secret holding the vault-unseal-N keys and vault-root token")
+	keysDir := fs.String("keys-dir", "", "bank-vaults: read the keys from files in this directory instead of the Secret")
+	initFile := fs.String("init-file", "", "vault-init: decrypted unseal-keys.json")
+	rootTokenFile := fs.String("root-token-file", "", "vault-init: decrypted root-token file, if the root token is not in -init-file")
+	threshold := fs.Int("threshold", 0, "number of keys needed to unseal, recorded on the Secret and used to verify the keys")
+	dryRun := fs.Bool("dry-run", false, "read and verify the keys without writing the Secrets")
+	force := fs.Bool("force", false, "replace the controller's Secrets if they already exist")
+	kube := registerKubeFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var k8sClient *kubernetes.Client
+	clientFor := func() (*kubernetes.Client, error) {
+		if k8sClient != nil {
+			return k8sClient, nil
+		}
+
+		var err error
+		k8sClient, err = kube.client()
+
+		return k8sClient, err
+	}
+
+	var migrated *migratedKeys
+	var source string
+	switch *from {
+	case sourceBankVaults:
+		data, err := bankVaultsData(*keysDir, kube.namespace, *sourceSecret, clientFor)
+		if err != nil {
+			return err
+		}
+		if migrated, err = fromBankVaults(data); err != nil {
+			return err
+		}
+		source = fmt.Sprintf("bank-vaults secret %s/%s", kube.namespace, *sourceSecret)
+		if *keysDir != "" {
+			source = "bank-vaults directory " + *keysDir
+		}
+	case sourceVaultInit:
+		if *initFile == "" {
+			return fmt.Errorf("-init-file is required for -from %s", sourceVaultInit)
+		}
+
+		initJSON, err := os.ReadFile(*initFile)
+		if err != nil {
+			return fmt.Errorf("failed to read init file: %w", err)
+		}
+		var rootToken []byte
+		if *rootTokenFile != "" {
+			if rootToken, err = os.ReadFile(*rootTokenFile); err != nil {
+				return fmt.Errorf("failed to read root token file: %w", err)
+			}
+		}
+		if migrated, err = fromVaultInit(initJSON, rootToken); err != nil {
+			return err
+		}
+		source = "vault-init file " + *initFile
+	default:
+		return fmt.Errorf("-from must be %s or %s", sourceBankVaults, sourceVaultInit)
+	}
+
+	fmt.Fprintf(stdout, "Verifying %d unseal keys from %s\n", len(migrated.keys), source)
+	if err := verifyKeyShares(migrated.keys, *threshold, stdout); err != nil {
+		return fmt.Errorf("not migrating: %w", err)
+	}
+	if migrated.rootToken == "" {
+		fmt.Fprintln(stdout, "warning: no root token found, only the unseal keys are migrated")
+	}
+
+	if *dryRun {
+		fmt.Fprintln(stdout, "Dry run, no Secrets written")
+
+		return nil
+	}
+
+	client, err := clientFor()
+	if err != nil {
+		return err
+	}
+
+	// Hold the action lock so the controller cannot initialize, and no other run migrate, while
+	// the keys are being replaced
+	lock, err := client.AcquireActionLock(kube.namespace, kubernetes.ActionMigrate, cliIdentity())
+	if err != nil {
+		return fmt.Errorf("not migrating: %w", err)
+	}
+	defer func() {
+		if err := lock.Release(); err != nil {
+			fmt.Fprintf(stdout, "warning: %v\n", err)
+		}
+	}()
+
+	return writeMigrated(client, kube.namespace, migrated, *from, *threshold, *force, stdout)
+}
+
+// bankVaultsData reads the bank-vaults key material from a directory of files or a Secret
+func bankVaultsData(keysDir, namespace, name string, clientFor func() (*kubernetes.Client, error)) (map[string][]byte, error) {
+	if keysDir != "" {
+		return readKeysDir(keysDir)
+	}
+
+	client, err := clientFor()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := client.GetSecret(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return secret.Data, nil
+}
+
+// fromBankVaults converts the bank-vaults layout, vault-unseal-<N> keys numbered from 0 and a
+// vault-root token
+func fromBankVaults(data map[string][]byte) (*migratedKeys, error) {
+	var numbers []int
+	for name := range data {
+		suffix, ok := strings.CutPrefix(name, "vault-unseal-")
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(suffix)
+		if err != nil || n < 0 || strconv.Itoa(n) != suffix {
+			return nil, fmt.Errorf("unexpected bank-vaults key %q", name)
+		}
+		numbers = append(numbers, n)
+	}
+	if len(numbers) == 0 {
+		return nil, fmt.Errorf("no vault-unseal-<N> keys found, is this a bank-vaults Secret?")
+	}
+	sort.Ints(numbers)
+
+	migrated := &migratedKeys{rootToken: strings.TrimSpace(string(data["vault-root"]))}
+	for _, n := range numbers {
+		migrated.keys = append(migrated.keys, strings.TrimSpace(string(data[fmt.Sprintf("vault-unseal-%d", n)])))
+	}
+
+	return migrated, nil
+}
+
+// fromVaultInit converts the decrypted unseal-keys.json of vault-init, which is Vault's init
+// response. The hex keys are used, or the base64 ones converted to hex when only those exist.
+func fromVaultInit(initJSON, rootToken []byte) (*migratedKeys, error) {
+	var resp struct {
+		Keys       []string `json:"keys"`
+		KeysBase64 []string `json:"keys_base64"`
+		RootToken  string   `json:"root_token"`
+	}
+	if err := json.Unmarshal(initJSON, &resp); err != nil {
+		return nil, fmt.Errorf("failed to parse init file, is it decrypted? %w", err)
+	}
+
+	migrated := &migratedKeys{keys: resp.Keys, rootToken: resp.RootToken}
+	if len(migrated.keys) == 0 {
+		for i, key := range resp.KeysBase64 {
+			raw, err := base64.StdEncoding.DecodeString(key)
+			if err != nil {
+				return nil, fmt.Errorf("key %d is not base64 encoded: %w", i+1, err)
+			}
+			migrated.keys = append(migrated.keys, hex.EncodeToString(raw))
+		}
+	}
+	if len(migrated.keys) == 0 {
+		return nil, fmt.Errorf("no keys found in init file")
+	}
+	if token := strings.TrimSpace(string(rootToken)); token != "" {
+		migrated.rootToken = token
+	}
+
+	return migrated, nil
+}
+
+// writeMigrated stores the keys and root token in the Secrets the controller reads
+func writeMigrated(client *kubernetes.Client, namespace string, migrated *migratedKeys, from string, threshold int, force bool, stdout io.Writer) error {
+	annotations := func() map[string]string {
+		return map[string]string{
+			vault.MigratedFromAnnotation: from,
+			vault.MigratedAtAnnotation:   time.Now().UTC().Format(time.RFC3339),
+		}
+	}
+
+	unsealKeys := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: vault.UnsealKeysSecret, Namespace: namespace, Annotations: annotations()},
+		Data:       make(map[string][]byte, len(migrated.keys)),
+	}
+	for i, key := range migrated.keys {
+		unsealKeys.Data[fmt.Sprintf("key%d", i+1)] = []byte(key)
+	}
+	if threshold > 0 {
+		unsealKeys.Annotations[vault.ThresholdAnnotation] = strconv.Itoa(threshold)
+		unsealKeys.Annotations[vault.SharesAnnotation] = strconv.Itoa(len(migrated.keys))
+	}
+
+	secrets := []*corev1.Secret{unsealKeys}
+	if migrated.rootToken != "" {
+		secrets = append(secrets, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: vault.RootTokenSecret, Namespace: namespace, Annotations: annotations()},
+			Data:       map[string][]byte{"token": []byte(migrated.rootToken)},
+		})
+	}
+
+	// Check every target first, so a refusal leaves nothing half written
+	for _, secret := range secrets {
+		found, err := client.SecretExists(namespace, secret.Name)
+		if err != nil {
+			return err
+		}
+		if found && !force {
+			return fmt.Errorf("secret %s/%s already exists, pass -force to replace it", namespace, secret.Name)
+		}
+	}
+
+	for _, secret := range secrets {
+		if err := client.CreateOrUpdateSecret(secret); err != nil {
+			return fmt.Errorf("failed to write secret %s/%s: %w", namespace, secret.Name, err)
+		}
+		fmt.Fprintf(stdout, "Wrote secret %s/%s\n", namespace, secret.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/keyprovider"
+	"github.com/getgrowly/vault-utils/pkg/kubernetes"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func runRekeyRecovery(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("rekey-recovery", flag.ContinueOnError)
+	address := fs.String("address", "", "talk to this Vault address directly instead of an unsealed pod found through Kubernetes, the active one if any")
+	port := fs.String("port", "8200", "port of the Vault listener on the pods")
+	shares := fs.Int("shares", 0, "number of new recovery keys (default the current number)")
+	threshold := fs.Int("threshold", 0, "number of recovery keys needed to authorize an operation (default the current threshold)")
+	providerSpec := fs.String("key-provider", os.Getenv("KEY_PROVIDER"), "key provider the recovery keys are kept with, as for the controller (default $KEY_PROVIDER, or the "+vault.RecoveryKeysSecret+" Secret)")
+	token := fs.String("token", os.Getenv("VAULT_TOKEN"), "Vault token to send with the rekey requests (default $VAULT_TOKEN)")
+	kube := registerKubeFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	client, err := kube.client()
+	if err != nil {
+		return err
+	}
+
+	var store keyprovider.Provider = &recoveryKeysSecret{client: client}
+	if *providerSpec != "" {
+		if store, err = keyprovider.Parse(*providerSpec); err != nil {
+			return err
+		}
+	}
+
+	vaultClient, err := vaultClientFor(ctx, client, kube, *address, *port)
+	if err != nil {
+		return err
+	}
+	defer vaultClient.Close()
+
+	return rekeyRecovery(ctx, vaultClient, client, store, kube.namespace, *token, *shares, *threshold, stdout)
+}
+
+// rekeyRecovery replaces the recovery keys kept in store with new ones under the action lock
+func rekeyRecovery(ctx context.Context, vaultClient *vault.Client, client *kubernetes.Client, store keyprovider.Provider, namespace, token string, shares, threshold int, stdout io.Writer) error {
+	status, err := vaultClient.CheckStatus(ctx)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !status.Initialized:
+		return fmt.Errorf("vault is not initialized")
+	case !status.RecoverySeal:
+		return fmt.Errorf("vault uses a %s seal, recovery keys only exist with auto-unseal", status.Type)
+	case status.Sealed:
+		return fmt.Errorf("vault is sealed")
+	}
+
+	if shares == 0 {
+		shares = status.Shares
+	}
+	if threshold == 0 {
+		threshold = status.Threshold
+	}
+	if threshold < 1 || threshold > shares {
+		return fmt.Errorf("threshold must be between 1 and the %d shares", shares)
+	}
+
+	storeCtx, cancel := context.WithTimeout(ctx, keyprovider.DefaultTimeout)
+	keys, err := store.Keys(storeCtx, namespace)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("failed to read recovery keys from %s: %w", store, err)
+	}
+	if len(keys) < status.Threshold {
+		return fmt.Errorf("%d recovery keys are needed to rekey, %s holds %d", status.Threshold, store, len(keys))
+	}
+
+	// Hold the action lock so no other actor replaces keys at the same time
+	lock, err := client.AcquireActionLock(namespace, kubernetes.ActionRekey, cliIdentity())
+	if err != nil {
+		return fmt.Errorf("not rekeying: %w", err)
+	}
+	defer func() {
+		if err := lock.Release(); err != nil {
+			fmt.Fprintf(stdout, "warning: %v\n", err)
+		}
+	}()
+
+	newKeys, err := vaultClient.RekeyRecoveryKeys(ctx, token, keys, shares, threshold)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Rekeyed recovery keys: %d shares, threshold %d\n", shares, threshold)
+
+	// Vault already replaced the keys, so storing the new ones must not be cut short
+	storeCtx, cancel = context.WithTimeout(context.WithoutCancel(ctx), keyprovider.DefaultTimeout)
+	defer cancel()
+	if err := store.Store(storeCtx, namespace, newKeys); err != nil {
+		// The new keys exist nowhere else, so they are printed rather than lost
+		fmt.Fprintln(stdout, "warning: the new recovery keys could not be stored, keep them safe now:")
+		for i, key := range newKeys {
+			fmt.Fprintf(stdout, "  key%d: %s\n", i+1, key)
+		}
+
+		return fmt.Errorf("failed to store recovery keys with %s: %w", store, err)
+	}
+	fmt.Fprintf(stdout, "Stored %d recovery keys with %s\n", len(newKeys), store)
+
+	return nil
+}
+
+// recoveryKeysSecret keeps the recovery keys in the recovery keys Secret, laid out like the
+// unseal keys Secret
+type recoveryKeysSecret struct {
+	client *kubernetes.Client
+}
+
+func (s *recoveryKeysSecret) Keys(_ context.Context, namespace string) ([]string, error) {
+	return secretKeys(s.client, namespace, vault.RecoveryKeysSecret)
+}
+
+// secretKeys returns the keys held by the Secret name, laid out like the unseal keys Secret
+func secretKeys(client *kubernetes.Client, namespace, name string) ([]string, error) {
+	secret, err := client.GetSecret(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, missing := kubernetes.UnsealKeysFromSecret(secret.Data)
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("secret %s/%s is missing %v", namespace, name, missing)
+	}
+
+	return keys, nil
+}
+
+func (s *recoveryKeysSecret) Store(_ context.Context, namespace string, keys []string) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      vault.RecoveryKeysSecret,
+			Namespace: namespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/component":     "vault-secrets",
+				"vault.hashicorp.com/secret-type": "recovery-keys",
+			},
+			Annotations: map[string]string{
+				vault.RekeyedAtAnnotation: time.Now().UTC().Format(time.RFC3339),
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: make(map[string][]byte, len(keys)),
+	}
+	for i, key := range keys {
+		secret.Data[fmt.Sprintf("key%d", i+1)] = []byte(key)
+	}
+
+	return s.client.CreateOrUpdateSecret(secret)
+}
+
+func (s *recoveryKeysSecret) String() string {
+	return "secret " + vault.RecoveryKeysSecret
+}
@@ -0,0 +1,111 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/backup"
+	"github.com/getgrowly/vault-utils/pkg/kubernetes"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+)
+
+// restoreProgressInterval is how often the progress of a restore is printed
+const restoreProgressInterval = 10 * time.Second
+
+func runRestoreSnapshot(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	address := fs.String("address", "", "talk to this Vault address directly instead of an unsealed pod found through Kubernetes, the active one if any")
+	port := fs.String("port", "8200", "port of the Vault listener on the pods")
+	key := fs.String("key", "", "object key of the snapshot in the bucket, such as vault/20240101T000000Z.snap")
+	confirm := fs.String("confirm", "", "the key again, to confirm that all data in Vault is to be replaced")
+	force := fs.Bool("force", false, "restore a snapshot taken from another cluster, whose unseal keys Vault needs from then on")
+	bucket := registerBucketFlags(fs)
+	kmsKey := fs.String("kms-key", os.Getenv("BACKUP_ENCRYPTION_KMS_KEY_ID"), "KMS key an encrypted snapshot was encrypted with (default $BACKUP_ENCRYPTION_KMS_KEY_ID)")
+	token := fs.String("token", os.Getenv("VAULT_TOKEN"), "Vault token allowed to restore snapshots (default $VAULT_TOKEN, or the stored root token)")
+	kube := registerKubeFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *key == "" || bucket.bucket == "" {
+		return fmt.Errorf("-key and -bucket are required")
+	}
+	if *confirm != *key {
+		return fmt.Errorf("a restore replaces all data in Vault, pass -confirm %s to go ahead", *key)
+	}
+
+	store, err := bucket.store()
+	if err != nil {
+		return err
+	}
+	var keys backup.DataKeys
+	if *kmsKey != "" {
+		keys = &backup.KMS{KeyID: *kmsKey, Region: store.Region, Credentials: store.Credentials}
+	}
+
+	client, err := kube.client()
+	if err != nil {
+		return err
+	}
+
+	vaultClient, err := vaultClientFor(ctx, client, kube, *address, *port)
+	if err != nil {
+		return err
+	}
+	defer vaultClient.Close()
+
+	return restoreSnapshot(ctx, vaultClient, client, store, keys, kube.namespace, *key, *token, *force, stdout)
+}
+
+// restoreSnapshot downloads the snapshot stored under key, decrypts it with keys when it was
+// encrypted, and restores it under the action lock, with token or else the root token stored in
+// namespace
+func restoreSnapshot(ctx context.Context, vaultClient *vault.Client, client *kubernetes.Client, store backup.Store, keys backup.DataKeys, namespace, key, token string, force bool, stdout io.Writer) error {
+	if token == "" {
+		var err error
+		if token, err = storedRootToken(client, namespace); err != nil {
+			return err
+		}
+	}
+
+	// Hold the action lock so the controller cannot initialize, and no other actor rekey or
+	// restore, while the data is replaced
+	lock, err := client.AcquireActionLock(namespace, kubernetes.ActionRestore, cliIdentity())
+	if err != nil {
+		return fmt.Errorf("not restoring: %w", err)
+	}
+	defer func() {
+		if err := lock.Release(); err != nil {
+			fmt.Fprintf(stdout, "warning: %v\n", err)
+		}
+	}()
+
+	// The snapshot is streamed from the store to Vault, and downloaded again when a standby
+	// redirects the request
+	var progress *backup.ProgressReader
+	open := func() (io.Reader, error) {
+		snapshot, err := backup.OpenSnapshot(ctx, store, keys, key)
+		if err != nil {
+			return nil, err
+		}
+		progress = backup.NewProgressReader(snapshot, restoreProgressInterval, func(transferred int64) {
+			fmt.Fprintf(stdout, "Restoring: %s transferred\n", backup.FormatSize(transferred))
+		})
+
+		return progress, nil
+	}
+	if err := vaultClient.RestoreRaftSnapshot(ctx, token, open, force); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "Restored the raft snapshot %s/%s of %s\n", store, key, backup.FormatSize(progress.Transferred()))
+	if force {
+		fmt.Fprintf(stdout, "Vault now needs the unseal keys of the cluster the snapshot was taken from\n")
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/getgrowly/vault-utils/pkg/kubernetes"
+	"github.com/getgrowly/vault-utils/pkg/sealedsecrets"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+	"sigs.k8s.io/yaml"
+)
+
+// sealedSecretsCertPath is where the sealed-secrets controller serves its certificate
+const sealedSecretsCertPath = "/v1/cert.pem"
+
+func runSealKeys(_ context.Context, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("seal-keys", flag.ContinueOnError)
+	certFile := fs.String("cert", "", "seal to this certificate instead of fetching it from the sealed-secrets controller, for offline sealing")
+	controllerName := fs.String("controller-name", "sealed-secrets-controller", "name of the sealed-secrets controller Service")
+	controllerNamespace := fs.String("controller-namespace", "kube-system", "namespace of the sealed-secrets controller")
+	scopeName := fs.String("scope", string(sealedsecrets.Strict), "where the SealedSecret can be unsealed: strict, namespace-wide or cluster-wide")
+	secretName := fs.String("secret", vault.UnsealKeysSecret, "Secret to seal, e.g. "+vault.RootTokenSecret+" for the root token")
+	output := fs.String("o", "", "write the SealedSecret to this file instead of stdout")
+	kube := registerKubeFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	scope, err := sealedsecrets.ParseScope(*scopeName)
+	if err != nil {
+		return err
+	}
+
+	client, err := kube.client()
+	if err != nil {
+		return err
+	}
+
+	var certPEM []byte
+	if *certFile != "" {
+		if certPEM, err = os.ReadFile(*certFile); err != nil {
+			return fmt.Errorf("failed to read certificate: %w", err)
+		}
+	} else {
+		if certPEM, err = client.ServiceProxyGet(*controllerNamespace, *controllerName, sealedSecretsCertPath); err != nil {
+			return fmt.Errorf("failed to fetch the sealed-secrets certificate, pass -cert to seal offline: %w", err)
+		}
+	}
+
+	manifest, err := sealSecret(client, kube.namespace, *secretName, certPEM, scope)
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		_, err = stdout.Write(manifest)
+
+		return err
+	}
+
+	if err := os.WriteFile(*output, manifest, 0o600); err != nil {
+		return fmt.Errorf("failed to write SealedSecret: %w", err)
+	}
+	fmt.Fprintf(stdout, "Wrote SealedSecret %s/%s to %s\n", kube.namespace, *secretName, *output)
+
+	return nil
+}
+
+// sealSecret reads a Secret and returns it as a SealedSecret manifest encrypted to certPEM
+func sealSecret(client *kubernetes.Client, namespace, name string, certPEM []byte, scope sealedsecrets.Scope) ([]byte, error) {
+	cert, err := sealedsecrets.ParseCertificate(certPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := client.GetSecret(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(secret.Data) == 0 {
+		return nil, fmt.Errorf("secret %s/%s holds no data, are the keys stored with a key provider?", namespace, name)
+	}
+
+	sealed, err := sealedsecrets.Seal(cert, secret, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := yaml.Marshal(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode SealedSecret: %w", err)
+	}
+
+	return manifest, nil
+}
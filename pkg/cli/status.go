@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/getgrowly/vault-utils/pkg/vault"
+)
+
+func runStatus(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	address := fs.String("address", "", "query this Vault address directly instead of the pods found through Kubernetes")
+	port := fs.String("port", "8200", "port of the Vault listener on the pods")
+	kube := registerKubeFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	table := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	defer table.Flush()
+
+	fmt.Fprintln(table, "TARGET\tINITIALIZED\tSEALED\tPROGRESS")
+
+	if *address != "" {
+		vaultClient, err := kube.addressClient(*address)
+		if err != nil {
+			return err
+		}
+		defer vaultClient.Close()
+
+		status, err := vaultClient.CheckStatus(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to query %s: %w", *address, err)
+		}
+		printStatus(table, *address, status)
+
+		return nil
+	}
+
+	k8sClient, err := kube.client()
+	if err != nil {
+		return err
+	}
+
+	pods, err := k8sClient.GetVaultPodNames(kube.namespace)
+	if err != nil {
+		return err
+	}
+
+	if len(pods) == 0 {
+		return fmt.Errorf("no Vault pods found in namespace %s", kube.namespace)
+	}
+
+	failed := 0
+	for _, pod := range pods {
+		vaultClient, err := kube.podClient(k8sClient, pod, *port)
+		if err != nil {
+			return err
+		}
+
+		status, err := vaultClient.CheckStatus(ctx)
+		vaultClient.Close()
+		if err != nil {
+			fmt.Fprintf(table, "%s\terror: %v\t\t\n", pod, err)
+			failed++
+			continue
+		}
+		printStatus(table, pod, status)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d pods could not be queried", failed, len(pods))
+	}
+
+	return nil
+}
+
+func printStatus(w io.Writer, target string, status *vault.Status) {
+	progress := "-"
+	if status.Sealed && status.Threshold > 0 {
+		progress = fmt.Sprintf("%d/%d", status.Progress, status.Threshold)
+	}
+
+	fmt.Fprintf(w, "%s\t%t\t%t\t%s\n", target, status.Initialized, status.Sealed, progress)
+}
@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/getgrowly/vault-utils/pkg/kubernetes"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+)
+
+func runStepDown(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("step-down", flag.ContinueOnError)
+	address := fs.String("address", "", "talk to this Vault address directly instead of an unsealed pod found through Kubernetes, the active one if any")
+	port := fs.String("port", "8200", "port of the Vault listener on the pods")
+	kube := registerKubeFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	client, err := kube.client()
+	if err != nil {
+		return err
+	}
+
+	vaultClient, err := vaultClientFor(ctx, client, kube, *address, *port)
+	if err != nil {
+		return err
+	}
+	defer vaultClient.Close()
+
+	return stepDown(ctx, vaultClient, client, kube.namespace, stdout)
+}
+
+// stepDown has the active node of the Vault cluster in namespace give up leadership with the
+// root token stored there, so a standby takes over. A standby forwards the request to the
+// active node, so any pod will do.
+func stepDown(ctx context.Context, vaultClient *vault.Client, client *kubernetes.Client, namespace string, stdout io.Writer) error {
+	leader, err := vaultClient.Leader(ctx)
+	if err != nil {
+		return err
+	}
+	if !leader.HAEnabled {
+		return fmt.Errorf("vault does not run in HA mode, there is no standby to take over")
+	}
+
+	rootToken, err := storedRootToken(client, namespace)
+	if err != nil {
+		return err
+	}
+	if err := vaultClient.StepDown(ctx, rootToken); err != nil {
+		return err
+	}
+
+	if leader.LeaderAddress != "" {
+		fmt.Fprintf(stdout, "The active node %s stepped down, a standby takes over\n", leader.LeaderAddress)
+	} else {
+		fmt.Fprintf(stdout, "The active node stepped down, a standby takes over\n")
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/kubernetes"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+)
+
+// tokenOptions are the settings of the token issue-token mints
+type tokenOptions struct {
+	policies []string
+	ttl      time.Duration
+	numUses  int
+	// wrapTTL has the token response-wrapped for that long, when it is not 0
+	wrapTTL time.Duration
+}
+
+func runIssueToken(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("issue-token", flag.ContinueOnError)
+	policy := fs.String("policy", "", "comma-separated policies of the token, e.g. admin")
+	ttl := fs.Duration("ttl", time.Hour, "how long the token lives")
+	numUses := fs.Int("uses", 0, "number of requests the token can make, 0 for unlimited")
+	wrapTTL := fs.Duration("wrap-ttl", 0, "print a response-wrapping token valid this long instead of the token, to hand it over safely")
+	address := fs.String("address", "", "talk to this Vault address directly instead of an unsealed pod found through Kubernetes, the active one if any")
+	port := fs.String("port", "8200", "port of the Vault listener on the pods")
+	kube := registerKubeFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	opts := tokenOptions{ttl: *ttl, numUses: *numUses, wrapTTL: *wrapTTL}
+	for _, name := range strings.Split(*policy, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			opts.policies = append(opts.policies, name)
+		}
+	}
+
+	client, err := kube.client()
+	if err != nil {
+		return err
+	}
+
+	vaultClient, err := vaultClientFor(ctx, client, kube, *address, *port)
+	if err != nil {
+		return err
+	}
+	defer vaultClient.Close()
+
+	return issueToken(ctx, vaultClient, client, kube.namespace, opts, stdout)
+}
+
+// issueToken mints a token as a child of the root token stored in namespace and prints it, or
+// the token wrapping it
+func issueToken(ctx context.Context, vaultClient *vault.Client, client *kubernetes.Client, namespace string, opts tokenOptions, stdout io.Writer) error {
+	if len(opts.policies) == 0 {
+		return fmt.Errorf("-policy is required")
+	}
+	for _, policy := range opts.policies {
+		if policy == "root" {
+			return fmt.Errorf("tokens with the root policy are not issued, read the %s Secret if one is really needed", vault.RootTokenSecret)
+		}
+	}
+	if opts.ttl < time.Second {
+		return fmt.Errorf("-ttl must be at least 1s")
+	}
+	if opts.numUses < 0 {
+		return fmt.Errorf("-uses must not be negative")
+	}
+	if opts.wrapTTL < 0 || (opts.wrapTTL > 0 && opts.wrapTTL < time.Second) {
+		return fmt.Errorf("-wrap-ttl must be at least 1s")
+	}
+
+	rootToken, err := storedRootToken(client, namespace)
+	if err != nil {
+		return err
+	}
+
+	req := vault.TokenCreateRequest{
+		Policies:    opts.policies,
+		TTL:         fmt.Sprintf("%ds", int(opts.ttl/time.Second)),
+		NumUses:     opts.numUses,
+		DisplayName: "vault-utils-cli",
+		Meta:        map[string]string{"requested_by": cliIdentity()},
+	}
+
+	if opts.wrapTTL > 0 {
+		wrapped, err := vaultClient.CreateWrappedToken(ctx, rootToken, req, opts.wrapTTL)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(stdout, "Wrapping token: %s\n", wrapped.Token)
+		fmt.Fprintf(stdout, "Unwrap it once within %s with: vault unwrap %s\n", time.Duration(wrapped.TTL)*time.Second, wrapped.Token)
+
+		return nil
+	}
+
+	auth, err := vaultClient.CreateToken(ctx, rootToken, req)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "Token:    %s\n", auth.ClientToken)
+	fmt.Fprintf(stdout, "Accessor: %s\n", auth.Accessor)
+	fmt.Fprintf(stdout, "Policies: %s\n", strings.Join(auth.Policies, ","))
+	fmt.Fprintf(stdout, "TTL:      %s\n", time.Duration(auth.LeaseDuration)*time.Second)
+
+	return nil
+}
@@ -0,0 +1,167 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/getgrowly/vault-utils/pkg/kubernetes"
+	"github.com/getgrowly/vault-utils/pkg/shamir"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+)
+
+func runVerifyKeys(_ context.Context, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("verify-keys", flag.ContinueOnError)
+	keysDir := fs.String("keys-dir", "", "read the keys from files in this directory instead of the unseal keys Secret")
+	threshold := fs.Int("threshold", 0, "number of keys needed to unseal (default read from the Secret annotation)")
+	kube := registerKubeFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var keys []string
+	source := *keysDir
+
+	if *keysDir != "" {
+		data, err := readKeysDir(*keysDir)
+		if err != nil {
+			return err
+		}
+
+		keys, _ = kubernetes.UnsealKeysFromSecret(data)
+	} else {
+		k8sClient, err := kube.client()
+		if err != nil {
+			return err
+		}
+
+		secret, err := k8sClient.GetSecret(kube.namespace, vault.UnsealKeysSecret)
+		if err != nil {
+			return err
+		}
+
+		keys, _ = kubernetes.UnsealKeysFromSecret(secret.Data)
+		source = fmt.Sprintf("secret %s/%s", kube.namespace, vault.UnsealKeysSecret)
+
+		if *threshold == 0 {
+			if value, ok := secret.Annotations[vault.ThresholdAnnotation]; ok {
+				if *threshold, err = strconv.Atoi(value); err != nil {
+					return fmt.Errorf("invalid %s annotation %q", vault.ThresholdAnnotation, value)
+				}
+			}
+		}
+	}
+
+	fmt.Fprintf(stdout, "Verifying %d unseal keys from %s\n", len(keys), source)
+
+	return verifyKeyShares(keys, *threshold, stdout)
+}
+
+// readKeysDir reads every regular file of dir as one key, named after the file
+func readKeysDir(dir string) (map[string][]byte, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keys directory: %w", err)
+	}
+
+	data := make(map[string][]byte)
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key %s: %w", entry.Name(), err)
+		}
+		data[entry.Name()] = bytes.TrimSpace(content)
+	}
+
+	return data, nil
+}
+
+// verifyKeyShares checks that the keys decode from hex or base64, are distinct, meet the
+// threshold, and that different subsets of them reconstruct the same secret. It reports each
+// check on w and returns an error for the first check that fails.
+func verifyKeyShares(keys []string, threshold int, w io.Writer) error {
+	if threshold < 0 {
+		return fmt.Errorf("invalid threshold %d", threshold)
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no unseal keys found")
+	}
+
+	parts := make([][]byte, len(keys))
+	seen := make(map[string]int, len(keys))
+	for i, key := range keys {
+		// Base64 keys are compared in hex, so the same share in both encodings is a duplicate
+		key = vault.HexKey(key)
+		part, err := hex.DecodeString(key)
+		if err != nil {
+			return fmt.Errorf("key %d is neither hex nor base64 encoded: %w", i+1, err)
+		}
+
+		if previous, ok := seen[key]; ok {
+			return fmt.Errorf("key %d duplicates key %d", i+1, previous+1)
+		}
+		seen[key] = i
+
+		if len(part) != len(parts[0]) && i > 0 {
+			return fmt.Errorf("key %d has length %d, expected %d", i+1, len(part), len(parts[0]))
+		}
+		parts[i] = part
+	}
+
+	fmt.Fprintf(w, "ok: %d distinct keys of %d bytes\n", len(parts), len(parts[0]))
+
+	switch {
+	case threshold == 0:
+		fmt.Fprintln(w, "warning: threshold unknown, pass -threshold to check consistency")
+		return nil
+	case len(parts) < threshold:
+		return fmt.Errorf("only %d keys stored but %d are needed to unseal", len(parts), threshold)
+	case threshold == 1:
+		fmt.Fprintln(w, "ok: single key seal, nothing to cross-check")
+		return nil
+	case len(parts) == threshold:
+		fmt.Fprintf(w, "ok: exactly the threshold of %d keys stored, consistency cannot be cross-checked\n", threshold)
+		return nil
+	}
+
+	reference, err := shamir.Combine(parts[:threshold])
+	if err != nil {
+		return fmt.Errorf("failed to combine keys: %w", err)
+	}
+
+	// Swap each remaining key into the subset in turn: every threshold-sized subset of valid
+	// shares reconstructs the same secret, so a disagreement exposes a corrupted or foreign key
+	var inconsistent []int
+	for i := threshold; i < len(parts); i++ {
+		subset := append(append([][]byte{}, parts[1:threshold]...), parts[i])
+
+		combined, err := shamir.Combine(subset)
+		if err != nil {
+			return fmt.Errorf("failed to combine keys: %w", err)
+		}
+		if !bytes.Equal(combined, reference) {
+			inconsistent = append(inconsistent, i+1)
+		}
+	}
+
+	switch {
+	case len(inconsistent) == len(parts)-threshold:
+		return fmt.Errorf("keys are inconsistent: one of keys 1-%d does not belong with the others", threshold)
+	case len(inconsistent) > 0:
+		return fmt.Errorf("keys are inconsistent: keys %v do not belong with the others", inconsistent)
+	}
+
+	fmt.Fprintf(w, "ok: all keys are consistent with a %d-of-%d split\n", threshold, len(parts))
+
+	return nil
+}
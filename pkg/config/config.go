@@ -1,36 +1,937 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
 	"os"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/vault"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The clients VAULT_CLIENT_BACKEND picks from to send the requests to Vault
+const (
+	ClientBackendBuiltin  = "builtin"
+	ClientBackendOfficial = "official"
 )
 
 const (
 	defaultCheckInterval = 10 // seconds
+	// defaultActiveSealedInterval re-checks a cluster whose active node sealed quickly, while
+	// keeping a pass over every pod from running back to back
+	defaultActiveSealedInterval = 2 // seconds
+	// defaultClockSkewThreshold is well below the shortest token TTLs in common use
+	defaultClockSkewThreshold = 5 // seconds
+	defaultVaultDialTimeout   = 5 // seconds
+	// defaultVaultRequestTimeout is long enough for a raft write on a loaded cluster
+	defaultVaultRequestTimeout  = 10 // seconds
+	defaultVaultMaxIdleConns    = 4
+	defaultVaultIdleConnTimeout = 90 // seconds
+	// The retries of the defaults wait about 750ms in total, well within a request timeout
+	defaultVaultRetryMaxAttempts   = 3
+	defaultVaultRetryBaseDelay     = 250 // milliseconds
+	defaultVaultRetryJitterPercent = 20
+	defaultAdminTokenTTL           = 900
+	// An operator clicking through the admin API stays well within the default rate, a
+	// runaway script does not
+	defaultAdminRateLimit     = 60 // per minute
+	defaultAdminRateBurst     = 10
+	defaultAdminMaxConcurrent = 1
+	// The recovery keys of auto-unseal Vaults are split like the unseal keys of Shamir ones
+	defaultRecoveryShares    = 5
+	defaultRecoveryThreshold = 3
+	// defaultReadyCacheTTL is half the kubelet's default probe period, so default probes are
+	// answered as before while aggressive ones are absorbed
+	defaultReadyCacheTTL = 5 // seconds
+	// defaultGCInterval is the default TTL of Events in the API server, so orphaned Events are
+	// collected about as soon as they would have expired on their own
+	defaultGCInterval = 3600 // seconds
+	// defaultRaftDeadPeerGrace outlasts a pod rescheduled onto another node, which keeps its name
+	defaultRaftDeadPeerGrace = 1800 // seconds
+	// defaultReadyMaxStaleness leaves room for a few missed passes at the default check interval
+	defaultReadyMaxStaleness = 60 // seconds
+	// defaultReadyTimeout answers /ready before the kubelet's default probe timeout of 1 second
+	defaultReadyTimeout  = 900  // milliseconds
+	defaultStatusTimeout = 5000 // milliseconds
+	// defaultColdStartTimeout leaves a powered-on datacenter time to bring its nodes back
+	defaultColdStartTimeout = 600 // seconds
+	// defaultBackupTimeout leaves time to stream a snapshot of a few GiB to S3
+	defaultBackupTimeout = 900 // seconds
+	// Every request is logged unless sampling is asked for
+	defaultAccessLogSamplePercent = 100
 )
 
 // Config represents the application configuration
 type Config struct {
+	// Profile names the profile the environment defaults come from, none when it is empty
+	Profile string
+	// DiscoveryPreset names the preset the discovery defaults below come from
+	DiscoveryPreset string
+	// PodSelector is the label selector of the Vault server pods, or several separated by ;,
+	// which select the pods matching any of them
+	PodSelector string
+	// VaultTLS is whether Vault's listener serves TLS, so pods and workloads are given https://
+	// addresses
+	VaultTLS bool
 	// VaultNamespace is the Kubernetes namespace where Vault is running
 	VaultNamespace string
 	// VaultPort is the port number where Vault is listening
 	VaultPort string
+	// VaultService is the name of the Service in front of Vault, or a full hostname, used for
+	// the address handed to workloads
+	VaultService string
+	// VaultCACert is the path of the PEM encoded CA bundle Vault's listener is served with, or
+	// empty when Vault is served without TLS
+	VaultCACert string
+	// VaultCACertFrom references the CA bundle in a Secret or ConfigMap of the Vault namespace
+	// instead, as secret:<name>[/<key>] or configmap:<name>[/<key>], with the key ca.crt by
+	// default
+	VaultCACertFrom string
+	// VaultTLSServerName is the name verified in the certificate of Vault's listener instead of
+	// the address dialled, such as the Service name, since pods are dialled by IP
+	VaultTLSServerName string
+	// VaultClientCert and VaultClientKey are paths of the PEM client certificate and key
+	// presented to a Vault listener requiring client certificates, reloaded when they change
+	VaultClientCert string
+	VaultClientKey  string
+	// VaultClientCertFrom names a kubernetes.io/tls Secret in the Vault namespace holding the
+	// client certificate instead
+	VaultClientCertFrom string
+	// VaultExternalURL is an address in front of the Vault cluster, such as an Ingress or
+	// LoadBalancer, used instead of pod IPs when the controller runs outside the cluster network
+	VaultExternalURL string
 	// CheckInterval is the interval between Vault status checks
 	CheckInterval time.Duration
+	// ActiveSealedInterval is the interval between checks while the pod that was the active
+	// node is sealed and no other took over, CheckInterval when it is 0
+	ActiveSealedInterval time.Duration
+	// StepDownOnDrain makes the controller step down the active Vault node when its pod is
+	// being evicted or its node is cordoned
+	StepDownOnDrain bool
+	// RolloutCoordination enables pacing of Vault StatefulSet rolling updates
+	RolloutCoordination bool
+	// VaultStatefulSet is the name of the StatefulSet running Vault
+	VaultStatefulSet string
+	// InitAllowed permits the controller to initialize an uninitialized Vault cluster. It is off
+	// by default so a cluster that is merely slow to start is never initialized by accident.
+	InitAllowed bool
+	// InitWaitForPods holds init back until enough Vault pods are Running for raft members to
+	// have a cluster to join: replicas waits for every replica of VaultStatefulSet, quorum for a
+	// majority of them, and a number for that many pods. Init does not wait when it is empty.
+	InitWaitForPods string
+	// SecretShares and SecretThreshold are how many unseal keys a Shamir Vault is initialized
+	// with and how many of them it takes to unseal, Vault's defaults when they are 0
+	SecretShares    int
+	SecretThreshold int
+	// RecoveryShares and RecoveryThreshold are how many recovery keys an auto-unseal Vault is
+	// initialized with and how many of them it takes to authorize recovery operations
+	RecoveryShares    int
+	RecoveryThreshold int
+	// InitPGPKeys are files holding operators' PGP public keys. When set, Vault encrypts each key
+	// share it hands out at init to one of them, so only the encrypted shares are stored, and
+	// as many shares are generated as there are keys.
+	InitPGPKeys []string
+	// InitRootTokenPGPKey is a file holding the PGP public key the root token is encrypted to
+	// at init
+	InitRootTokenPGPKey string
+	// RevokeRootToken makes the controller revoke the root token it stored at init once the
+	// cluster is initialized and healthy, so no root token is kept around
+	RevokeRootToken bool
+	// RevokeRootTokenAdminPolicies are the policies of an orphan admin token created and stored
+	// before the root token is revoked. No admin token is created when it is empty.
+	RevokeRootTokenAdminPolicies []string
+	// KeyEncoding is how the keys handed out at init are stored, hex or base64. Keys in either
+	// encoding are accepted when unsealing.
+	KeyEncoding string
+	// VerifyClusterIdentity makes the controller check a sealed Vault's seal configuration and
+	// reported cluster identity against the recorded ones before sending it unseal keys
+	VerifyClusterIdentity bool
+	// NotifyWebhookURL receives a JSON POST for events that need operator attention, such as
+	// recovered panics. Notifications are disabled when it is empty.
+	NotifyWebhookURL string
+	// NotifyExec is a command run with each notification as JSON on its stdin, alongside or
+	// instead of the webhook
+	NotifyExec string
+	// NotifyShutdown sends the report logged when the controller stops as a notification too
+	NotifyShutdown bool
+	// ForeignUnsealerAction is what the controller does when another unseal automation appears
+	// to act on the cluster: "warn" keeps going and reports it, "pause" also stops init and
+	// unseal until the signs are gone
+	ForeignUnsealerAction string
+	// LogLevel is "info" or "debug". At debug the method, path, status and latency of every
+	// call to Vault and the Kubernetes API are logged.
+	LogLevel string
+	// HookPreInit, HookPostInit, HookPreUnseal and HookPostUnseal hold the specs of the action
+	// hooks run around init and unseal, separated by semicolons
+	HookPreInit    string
+	HookPostInit   string
+	HookPreUnseal  string
+	HookPostUnseal string
+	// KeyProvider is the spec of the provider the unseal keys are stored with instead of the
+	// unseal keys Secret, such as exec:/usr/local/bin/hsm-keys. The Secret is used when empty.
+	KeyProvider string
+	// SecretLabels and SecretAnnotations are comma separated key=value pairs added to the
+	// Secrets holding key material, for secret distribution tools that select Secrets by them
+	SecretLabels      string
+	SecretAnnotations string
+	// SecretKeysJSON adds the unseal keys and threshold to the unseal keys Secret as a single
+	// JSON document, for tools that extract one property
+	SecretKeysJSON bool
+	// WorkloadIdentityTokenFile is a workload identity token kept fresh on disk, such as a
+	// projected service account token or a SPIFFE JWT-SVID, handed to key providers and
+	// notification commands for identity federation
+	WorkloadIdentityTokenFile string
+	// StateFile is where the controller keeps its operational memory across restarts, encrypted
+	// with the key in StateKeyFile. Nothing is persisted when it is empty.
+	StateFile    string
+	StateKeyFile string
+	// KeyCacheFile is where a copy of the unseal keys is kept, encrypted with the key in
+	// KeyCacheKeyFile, for unsealing when the Secret or key provider cannot be read. No copy
+	// is kept when it is empty.
+	KeyCacheFile    string
+	KeyCacheKeyFile string
+	// RenderTemplatesDir holds templates of Secrets and ConfigMaps rendered for workloads once
+	// Vault is unsealed. Rendering is disabled when it is empty.
+	RenderTemplatesDir string
+	// CAConfigMap is the name of the ConfigMap Vault's CA chain is published to, as ca.crt,
+	// for workloads to mount. Publishing is disabled when it is empty.
+	CAConfigMap string
+	// CAConfigMapNamespaces lists the namespaces CAConfigMap is written to, the Vault namespace
+	// by default
+	CAConfigMapNamespaces []string
+	// NetworkPolicy is the name of a NetworkPolicy generated in the Vault namespace, admitting
+	// the controller pods to the Vault pods on VaultPort. No policy is generated when it is empty.
+	NetworkPolicy string
+	// ControllerNamespace and ControllerPodSelector select the controller's own pods for
+	// NetworkPolicy, in the Vault namespace by default
+	ControllerNamespace   string
+	ControllerPodSelector string
+	// MetricsCluster names the environment in the cluster label of every metric, next to the
+	// namespace label holding the Vault namespace
+	MetricsCluster string
+	// ClockSkewThreshold is how far the clock of a Vault pod may be from the controller's
+	// before it is reported. Skew is not checked when it is zero.
+	ClockSkewThreshold time.Duration
+	// GCInterval is how often the Events of Vault pods that no longer exist are deleted, never
+	// when it is zero
+	GCInterval time.Duration
+	// VaultDialTimeout bounds establishing a connection to a Vault pod
+	VaultDialTimeout time.Duration
+	// VaultRequestTimeout bounds each action on a single Vault pod, so a pod that accepts
+	// connections but never answers cannot hold up the rest of the pass. Init is not bounded.
+	VaultRequestTimeout time.Duration
+	// VaultMaxIdleConns is how many keep-alive connections are kept open to each Vault pod
+	VaultMaxIdleConns int
+	// VaultIdleConnTimeout is how long an unused keep-alive connection to a Vault pod is kept open
+	VaultIdleConnTimeout time.Duration
+	// VaultRetryMaxAttempts is how often a status check, unseal or init request failing with a
+	// transient error is sent at most, 0 or 1 to not retry. Init is only retried when it never
+	// reached Vault.
+	VaultRetryMaxAttempts int
+	// VaultRetryBaseDelay is the wait before the first retry, doubled for every further one
+	VaultRetryBaseDelay time.Duration
+	// VaultRetryJitterPercent is the share of every wait between retries that is randomized
+	VaultRetryJitterPercent int
+	// VaultClientBackend is which client sends the requests to Vault: ClientBackendBuiltin, the
+	// client of this module, or ClientBackendOfficial, the official Vault API client
+	VaultClientBackend string
+	// EventReceiver is whether /events is served, where external systems report that a Vault
+	// pod restarted or sealed to have it reconciled right away
+	EventReceiver bool
+	// EventReceiverTokenFile holds the bearer token posts to /events must carry
+	EventReceiverTokenFile string
+	// AuditLogListen is the address socket audit devices of Vault send their audit log to, and
+	// AuditLogFile the log of a file audit device to follow. Seals and step-downs found in
+	// either have the controller reconcile right away. Neither is read when empty.
+	AuditLogListen string
+	AuditLogFile   string
+	// RaftJoin has uninitialized members of a cluster using integrated storage join the raft
+	// cluster through an unsealed member, instead of waiting for retry_join
+	RaftJoin bool
+	// RaftAutopilot has the controller apply the autopilot configuration below to a cluster using
+	// integrated storage once it is healthy, with the stored root token. A setting left at zero
+	// keeps Vault's value.
+	RaftAutopilot          bool
+	AutopilotCleanupDead   bool
+	AutopilotMinQuorum     int
+	AutopilotStabilization time.Duration
+	AutopilotDeadThreshold time.Duration
+	// ColdStart has the controller bring up a cluster found with every pod sealed, as after a
+	// full power-on, step by step: wait for a quorum of pods, unseal them in order, verify a
+	// leader was elected and check the result. ColdStartTimeout bounds the whole procedure.
+	ColdStart        bool
+	ColdStartTimeout time.Duration
+	// CanaryPath is a secret read after every pass with the token in CanaryTokenFile, to verify
+	// Vault serves data and not only that it is unsealed. Nothing is read when it is empty.
+	CanaryPath      string
+	CanaryTokenFile string
+	// RaftTokenFile holds a token allowed to read sys/storage/raft/configuration, which the raft
+	// membership is read with after every pass when Vault uses integrated storage. The membership
+	// is not read when it is empty.
+	RaftTokenFile string
+	// RaftRemoveDeadPeers has the controller remove raft peers that no Vault pod has matched for
+	// RaftDeadPeerGrace, with the token in RaftTokenFile, which must then also be allowed to
+	// update sys/storage/raft/remove-peer
+	RaftRemoveDeadPeers bool
+	RaftDeadPeerGrace   time.Duration
+	// BackupInterval is how often a raft snapshot is taken from the active node with the token
+	// in BackupTokenFile and uploaded to BackupS3Bucket, under BackupS3Prefix. No snapshot is
+	// taken when it is 0. BackupTimeout bounds taking and uploading one snapshot.
+	BackupInterval  time.Duration
+	BackupTokenFile string
+	BackupTimeout   time.Duration
+	BackupS3Bucket  string
+	BackupS3Prefix  string
+	// BackupS3Region is the region of the bucket, and BackupS3Endpoint the S3 API to use
+	// instead of the region's, with the bucket in the path when BackupS3PathStyle is set
+	BackupS3Region    string
+	BackupS3Endpoint  string
+	BackupS3PathStyle bool
+	// BackupS3SSE is the server-side encryption of the snapshots, AES256 or aws:kms, the
+	// bucket's default when empty. BackupS3SSEKMSKeyID is the KMS key of aws:kms.
+	BackupS3SSE         string
+	BackupS3SSEKMSKeyID string
+	// BackupRetainCount is how many snapshots are kept after a backup, and BackupRetainAge for
+	// how long; older ones are deleted from the bucket. Either is ignored when 0, and the newest
+	// snapshot is always kept.
+	BackupRetainCount int
+	BackupRetainAge   time.Duration
+	// BackupEncryptionKMSKeyID is the KMS key wrapping the data keys snapshots are encrypted
+	// with before they are uploaded. Snapshots are not encrypted by the controller when it is
+	// empty.
+	BackupEncryptionKMSKeyID string
+	// SealMigration has the controller submit the stored keys with the migrate flag to pods
+	// waiting for their seal to be migrated between Shamir and auto-unseal. Without it they are
+	// left sealed until a migration is requested through the admin API.
+	SealMigration bool
+	// AdminAPI is whether /admin is served, where operators have privileged actions performed
+	// with the stored root token instead of being handed the token itself
+	AdminAPI bool
+	// AdminAPITokenFile holds the bearer token requests to /admin must carry
+	AdminAPITokenFile string
+	// AdminTokenTTL is the TTL of the tokens created through /admin, and the longest one that
+	// can be requested
+	AdminTokenTTL time.Duration
+	// AdminRateLimit is how many requests to /admin are accepted per minute, AdminRateBurst how
+	// many at once; 0 accepts any number
+	AdminRateLimit int
+	AdminRateBurst int
+	// AdminMaxConcurrent is how many admin actions may be in progress at once, 0 for any number
+	AdminMaxConcurrent int
+	// ReadyCacheTTL is how long a computed readiness result answers /ready, 0 to check every Vault
+	// pod on every probe
+	ReadyCacheTTL time.Duration
+	// ReadyFromReconcile is whether /ready answers from the pod states of the latest reconcile
+	// pass instead of checking every Vault pod itself
+	ReadyFromReconcile bool
+	// ReadyMaxStaleness is how long ago that pass may have checked the pods for /ready to trust
+	// it
+	ReadyMaxStaleness time.Duration
+	// ReadyTimeout bounds how long /ready takes to answer, and StatusTimeout /status; 0 leaves
+	// them unbounded
+	ReadyTimeout  time.Duration
+	StatusTimeout time.Duration
+	// AccessLogSamplePercent is the share of successful HTTP requests to the controller that are
+	// logged; failed ones always are
+	AccessLogSamplePercent int
+	// AccessLogProbes logs successful requests to /health, /ready and /metrics too
+	AccessLogProbes bool
 }
 
 // LoadConfig loads configuration from environment variables
 func LoadConfig() *Config {
+	// An unknown preset falls back to the default one and is reported by Validate
+	presetName := getEnvOrDefault("DISCOVERY_PRESET", DefaultPreset)
+	preset, _ := LookupPreset(presetName)
+	// So is an unknown profile, which leaves the defaults
+	profileName := strings.ToLower(os.Getenv("PROFILE"))
+	profile, _ := LookupProfile(profileName)
+
 	cfg := &Config{
-		VaultNamespace: getEnvOrDefault("VAULT_NAMESPACE", "vault"),
-		VaultPort:      getEnvOrDefault("VAULT_PORT", "8200"),
-		CheckInterval:  time.Duration(getEnvAsIntOrDefault("CHECK_INTERVAL", defaultCheckInterval)) * time.Second,
+		Profile:                   profileName,
+		DiscoveryPreset:           presetName,
+		PodSelector:               getEnvOrDefault("VAULT_POD_SELECTOR", preset.PodSelector),
+		VaultTLS:                  getEnvAsBoolOrDefault("VAULT_TLS", preset.TLS || os.Getenv("VAULT_CACERT") != "" || os.Getenv("VAULT_CACERT_FROM") != ""),
+		VaultNamespace:            getEnvOrDefault("VAULT_NAMESPACE", "vault"),
+		VaultPort:                 getEnvOrDefault("VAULT_PORT", preset.Port),
+		VaultService:              getEnvOrDefault("VAULT_SERVICE", preset.Service),
+		VaultCACert:               os.Getenv("VAULT_CACERT"),
+		VaultCACertFrom:           os.Getenv("VAULT_CACERT_FROM"),
+		VaultTLSServerName:        os.Getenv("VAULT_TLS_SERVER_NAME"),
+		VaultClientCert:           os.Getenv("VAULT_CLIENT_CERT"),
+		VaultClientKey:            os.Getenv("VAULT_CLIENT_KEY"),
+		VaultClientCertFrom:       os.Getenv("VAULT_CLIENT_CERT_FROM"),
+		VaultExternalURL:          os.Getenv("VAULT_EXTERNAL_URL"),
+		CheckInterval:             time.Duration(getEnvAsIntOrDefault("CHECK_INTERVAL", defaultCheckInterval)) * time.Second,
+		ActiveSealedInterval:      time.Duration(getEnvAsIntOrDefault("ACTIVE_SEALED_CHECK_INTERVAL", defaultActiveSealedInterval)) * time.Second,
+		StepDownOnDrain:           getEnvAsBoolOrDefault("STEP_DOWN_ON_DRAIN", true),
+		RolloutCoordination:       getEnvAsBoolOrDefault("ROLLOUT_COORDINATION", false),
+		VaultStatefulSet:          getEnvOrDefault("VAULT_STATEFULSET", preset.StatefulSet),
+		InitAllowed:               getEnvAsBoolOrDefault("INIT_ALLOWED", profile.InitAllowed),
+		InitWaitForPods:           strings.ToLower(os.Getenv("INIT_WAIT_FOR_PODS")),
+		SecretShares:              getEnvAsIntOrDefault("SECRET_SHARES", profile.SecretShares),
+		SecretThreshold:           getEnvAsIntOrDefault("SECRET_THRESHOLD", profile.SecretThreshold),
+		RevokeRootToken:           getEnvAsBoolOrDefault("REVOKE_ROOT_TOKEN", false),
+		RecoveryShares:            getEnvAsIntOrDefault("RECOVERY_SHARES", profile.RecoveryShares),
+		RecoveryThreshold:         getEnvAsIntOrDefault("RECOVERY_THRESHOLD", profile.RecoveryThreshold),
+		KeyEncoding:               strings.ToLower(getEnvOrDefault("KEY_ENCODING", vault.KeyEncodingHex)),
+		VerifyClusterIdentity:     getEnvAsBoolOrDefault("VERIFY_CLUSTER_IDENTITY", profile.VerifyClusterIdentity),
+		NotifyWebhookURL:          os.Getenv("NOTIFY_WEBHOOK_URL"),
+		NotifyExec:                os.Getenv("NOTIFY_EXEC"),
+		NotifyShutdown:            getEnvAsBoolOrDefault("NOTIFY_SHUTDOWN", false),
+		ForeignUnsealerAction:     strings.ToLower(getEnvOrDefault("FOREIGN_UNSEALER_ACTION", "warn")),
+		LogLevel:                  strings.ToLower(getEnvOrDefault("LOG_LEVEL", profile.LogLevel)),
+		HookPreInit:               os.Getenv("HOOK_PRE_INIT"),
+		HookPostInit:              os.Getenv("HOOK_POST_INIT"),
+		HookPreUnseal:             os.Getenv("HOOK_PRE_UNSEAL"),
+		HookPostUnseal:            os.Getenv("HOOK_POST_UNSEAL"),
+		KeyProvider:               os.Getenv("KEY_PROVIDER"),
+		SecretLabels:              os.Getenv("SECRET_LABELS"),
+		SecretAnnotations:         os.Getenv("SECRET_ANNOTATIONS"),
+		SecretKeysJSON:            getEnvAsBoolOrDefault("SECRET_KEYS_JSON", false),
+		WorkloadIdentityTokenFile: os.Getenv("WORKLOAD_IDENTITY_TOKEN_FILE"),
+		StateFile:                 os.Getenv("STATE_FILE"),
+		StateKeyFile:              os.Getenv("STATE_KEY_FILE"),
+		KeyCacheFile:              os.Getenv("KEY_CACHE_FILE"),
+		KeyCacheKeyFile:           os.Getenv("KEY_CACHE_KEY_FILE"),
+		RenderTemplatesDir:        os.Getenv("RENDER_TEMPLATES_DIR"),
+		CAConfigMap:               os.Getenv("CA_CONFIGMAP"),
+		NetworkPolicy:             os.Getenv("NETWORK_POLICY"),
+		ControllerPodSelector:     getEnvOrDefault("CONTROLLER_POD_SELECTOR", "app.kubernetes.io/name=vault-auto-unseal"),
+		MetricsCluster:            os.Getenv("METRICS_CLUSTER"),
+		ClockSkewThreshold:        time.Duration(getEnvAsIntOrDefault("CLOCK_SKEW_THRESHOLD", defaultClockSkewThreshold)) * time.Second,
+		GCInterval:                time.Duration(getEnvAsIntOrDefault("GC_INTERVAL", defaultGCInterval)) * time.Second,
+		VaultDialTimeout:          time.Duration(getEnvAsIntOrDefault("VAULT_DIAL_TIMEOUT", defaultVaultDialTimeout)) * time.Second,
+		VaultRequestTimeout:       time.Duration(getEnvAsIntOrDefault("VAULT_REQUEST_TIMEOUT", defaultVaultRequestTimeout)) * time.Second,
+		VaultMaxIdleConns:         getEnvAsIntOrDefault("VAULT_MAX_IDLE_CONNS", defaultVaultMaxIdleConns),
+		VaultIdleConnTimeout:      time.Duration(getEnvAsIntOrDefault("VAULT_IDLE_CONN_TIMEOUT", defaultVaultIdleConnTimeout)) * time.Second,
+		VaultRetryMaxAttempts:     getEnvAsIntOrDefault("VAULT_RETRY_MAX_ATTEMPTS", defaultVaultRetryMaxAttempts),
+		VaultRetryBaseDelay:       time.Duration(getEnvAsIntOrDefault("VAULT_RETRY_BASE_DELAY_MS", defaultVaultRetryBaseDelay)) * time.Millisecond,
+		VaultRetryJitterPercent:   getEnvAsIntOrDefault("VAULT_RETRY_JITTER_PERCENT", defaultVaultRetryJitterPercent),
+		VaultClientBackend:        strings.ToLower(getEnvOrDefault("VAULT_CLIENT_BACKEND", ClientBackendBuiltin)),
+		EventReceiver:             getEnvAsBoolOrDefault("EVENT_RECEIVER", false),
+		EventReceiverTokenFile:    os.Getenv("EVENT_RECEIVER_TOKEN_FILE"),
+		AuditLogListen:            os.Getenv("AUDIT_LOG_LISTEN"),
+		AuditLogFile:              os.Getenv("AUDIT_LOG_FILE"),
+		RaftJoin:                  getEnvAsBoolOrDefault("RAFT_JOIN", false),
+		RaftAutopilot:             getEnvAsBoolOrDefault("RAFT_AUTOPILOT", false),
+		AutopilotCleanupDead:      getEnvAsBoolOrDefault("AUTOPILOT_CLEANUP_DEAD_SERVERS", false),
+		AutopilotMinQuorum:        getEnvAsIntOrDefault("AUTOPILOT_MIN_QUORUM", 0),
+		AutopilotStabilization:    time.Duration(getEnvAsIntOrDefault("AUTOPILOT_SERVER_STABILIZATION_TIME", 0)) * time.Second,
+		AutopilotDeadThreshold:    time.Duration(getEnvAsIntOrDefault("AUTOPILOT_DEAD_SERVER_LAST_CONTACT_THRESHOLD", 0)) * time.Second,
+		ColdStart:                 getEnvAsBoolOrDefault("COLD_START", false),
+		ColdStartTimeout:          time.Duration(getEnvAsIntOrDefault("COLD_START_TIMEOUT", defaultColdStartTimeout)) * time.Second,
+		CanaryPath:                strings.Trim(os.Getenv("CANARY_PATH"), "/"),
+		CanaryTokenFile:           os.Getenv("CANARY_TOKEN_FILE"),
+		RaftTokenFile:             os.Getenv("RAFT_TOKEN_FILE"),
+		RaftRemoveDeadPeers:       getEnvAsBoolOrDefault("RAFT_REMOVE_DEAD_PEERS", false),
+		RaftDeadPeerGrace:         time.Duration(getEnvAsIntOrDefault("RAFT_DEAD_PEER_GRACE", defaultRaftDeadPeerGrace)) * time.Second,
+		SealMigration:             getEnvAsBoolOrDefault("SEAL_MIGRATION", false),
+		BackupInterval:            time.Duration(getEnvAsIntOrDefault("BACKUP_INTERVAL", 0)) * time.Second,
+		BackupTokenFile:           os.Getenv("BACKUP_TOKEN_FILE"),
+		BackupTimeout:             time.Duration(getEnvAsIntOrDefault("BACKUP_TIMEOUT", defaultBackupTimeout)) * time.Second,
+		BackupS3Bucket:            os.Getenv("BACKUP_S3_BUCKET"),
+		BackupS3Prefix:            os.Getenv("BACKUP_S3_PREFIX"),
+		BackupS3Region:            getEnvOrDefault("BACKUP_S3_REGION", os.Getenv("AWS_REGION")),
+		BackupS3Endpoint:          os.Getenv("BACKUP_S3_ENDPOINT"),
+		BackupS3PathStyle:         getEnvAsBoolOrDefault("BACKUP_S3_PATH_STYLE", false),
+		BackupS3SSE:               os.Getenv("BACKUP_S3_SSE"),
+		BackupS3SSEKMSKeyID:       os.Getenv("BACKUP_S3_SSE_KMS_KEY_ID"),
+		BackupRetainCount:         getEnvAsIntOrDefault("BACKUP_RETAIN_COUNT", 0),
+		BackupRetainAge:           time.Duration(getEnvAsIntOrDefault("BACKUP_RETAIN_AGE", 0)) * time.Second,
+		BackupEncryptionKMSKeyID:  os.Getenv("BACKUP_ENCRYPTION_KMS_KEY_ID"),
+		AdminAPI:                  getEnvAsBoolOrDefault("ADMIN_API", false),
+		AdminAPITokenFile:         os.Getenv("ADMIN_API_TOKEN_FILE"),
+		AdminTokenTTL:             time.Duration(getEnvAsIntOrDefault("ADMIN_TOKEN_TTL", defaultAdminTokenTTL)) * time.Second,
+		AdminRateLimit:            getEnvAsIntOrDefault("ADMIN_RATE_LIMIT", defaultAdminRateLimit),
+		AdminRateBurst:            getEnvAsIntOrDefault("ADMIN_RATE_BURST", defaultAdminRateBurst),
+		AdminMaxConcurrent:        getEnvAsIntOrDefault("ADMIN_MAX_CONCURRENT", defaultAdminMaxConcurrent),
+		ReadyCacheTTL:             time.Duration(getEnvAsIntOrDefault("READY_CACHE_TTL", defaultReadyCacheTTL)) * time.Second,
+		ReadyFromReconcile:        getEnvAsBoolOrDefault("READY_FROM_RECONCILE", false),
+		ReadyMaxStaleness:         time.Duration(getEnvAsIntOrDefault("READY_MAX_STALENESS", defaultReadyMaxStaleness)) * time.Second,
+		ReadyTimeout:              time.Duration(getEnvAsIntOrDefault("READY_TIMEOUT_MS", defaultReadyTimeout)) * time.Millisecond,
+		StatusTimeout:             time.Duration(getEnvAsIntOrDefault("STATUS_TIMEOUT_MS", defaultStatusTimeout)) * time.Millisecond,
+		AccessLogSamplePercent:    getEnvAsIntOrDefault("ACCESS_LOG_SAMPLE_PERCENT", defaultAccessLogSamplePercent),
+		AccessLogProbes:           getEnvAsBoolOrDefault("ACCESS_LOG_PROBES", false),
 	}
 
+	cfg.CAConfigMapNamespaces = getEnvAsListOrDefault("CA_CONFIGMAP_NAMESPACES", []string{cfg.VaultNamespace})
+	cfg.ControllerNamespace = getEnvOrDefault("CONTROLLER_NAMESPACE", cfg.VaultNamespace)
+	cfg.InitPGPKeys = getEnvAsListOrDefault("INIT_PGP_KEYS", nil)
+	cfg.InitRootTokenPGPKey = os.Getenv("INIT_ROOT_TOKEN_PGP_KEY")
+	cfg.RevokeRootTokenAdminPolicies = getEnvAsListOrDefault("REVOKE_ROOT_TOKEN_ADMIN_POLICIES", nil)
+
 	return cfg
 }
 
+// Validate reports settings that cannot work together as an error, and settings that are
+// likely mistakes, such as a TLS preset without a CA bundle, as warnings
+func (c *Config) Validate() (warnings []string, err error) {
+	preset, err := LookupPreset(c.DiscoveryPreset)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := LookupProfile(c.Profile)
+	if err != nil {
+		return nil, err
+	}
+
+	if preset.External && c.VaultExternalURL == "" {
+		return nil, fmt.Errorf("discovery preset %s requires VAULT_EXTERNAL_URL", preset.Name)
+	}
+	if !preset.External && len(c.PodSelectors()) == 0 && c.VaultExternalURL == "" {
+		return nil, fmt.Errorf("no pod selector and no VAULT_EXTERNAL_URL, Vault cannot be found")
+	}
+	for _, selector := range c.PodSelectors() {
+		if _, err := metav1.ParseToLabelSelector(selector); err != nil {
+			return nil, fmt.Errorf("invalid VAULT_POD_SELECTOR %q: %w", selector, err)
+		}
+	}
+
+	switch c.ForeignUnsealerAction {
+	case "", "warn", "pause":
+	default:
+		return nil, fmt.Errorf("invalid FOREIGN_UNSEALER_ACTION %q, expected warn or pause", c.ForeignUnsealerAction)
+	}
+
+	switch c.VaultClientBackend {
+	case "", ClientBackendBuiltin:
+	case ClientBackendOfficial:
+		if c.VaultExternalURL != "" {
+			return nil, fmt.Errorf("VAULT_CLIENT_BACKEND=official follows standby redirects to the api_addr of the active node, it cannot be used with VAULT_EXTERNAL_URL")
+		}
+	default:
+		return nil, fmt.Errorf("invalid VAULT_CLIENT_BACKEND %q, expected builtin or official", c.VaultClientBackend)
+	}
+
+	if _, _, err := c.SecretMetadata(); err != nil {
+		return nil, err
+	}
+	if c.SecretKeysJSON && c.KeyProvider != "" {
+		warnings = append(warnings, "SECRET_KEYS_JSON has no effect with KEY_PROVIDER, the unseal keys Secret holds no keys")
+	}
+
+	if c.RaftJoin && c.VaultExternalURL != "" {
+		return nil, fmt.Errorf("RAFT_JOIN needs to reach each Vault pod, it cannot be used with VAULT_EXTERNAL_URL")
+	}
+	if c.RaftRemoveDeadPeers {
+		if c.RaftTokenFile == "" {
+			return nil, fmt.Errorf("RAFT_REMOVE_DEAD_PEERS requires RAFT_TOKEN_FILE, a token allowed to read the raft configuration and remove peers")
+		}
+		if c.VaultExternalURL != "" {
+			return nil, fmt.Errorf("RAFT_REMOVE_DEAD_PEERS needs to list the Vault pods, it cannot be used with VAULT_EXTERNAL_URL")
+		}
+		if c.RaftDeadPeerGrace <= 0 {
+			return nil, fmt.Errorf("invalid RAFT_DEAD_PEER_GRACE %v, expected more than 0", c.RaftDeadPeerGrace)
+		}
+		if c.RaftAutopilot && c.AutopilotCleanupDead {
+			warnings = append(warnings, "RAFT_REMOVE_DEAD_PEERS and AUTOPILOT_CLEANUP_DEAD_SERVERS both remove dead peers, whichever acts first wins")
+		}
+	}
+	if c.RaftAutopilot {
+		if c.AutopilotCleanupDead && c.AutopilotMinQuorum < 3 {
+			return nil, fmt.Errorf("AUTOPILOT_CLEANUP_DEAD_SERVERS requires AUTOPILOT_MIN_QUORUM of 3 or more, so dead servers are never removed below quorum")
+		}
+		if c.AutopilotMinQuorum < 0 || c.AutopilotStabilization < 0 || c.AutopilotDeadThreshold < 0 {
+			return nil, fmt.Errorf("invalid autopilot configuration, expected AUTOPILOT_MIN_QUORUM, AUTOPILOT_SERVER_STABILIZATION_TIME and AUTOPILOT_DEAD_SERVER_LAST_CONTACT_THRESHOLD of 0 or more")
+		}
+		if c.InitRootTokenPGPKey != "" {
+			return nil, fmt.Errorf("RAFT_AUTOPILOT cannot configure Vault with a root token encrypted to INIT_ROOT_TOKEN_PGP_KEY")
+		}
+	}
+	if c.BackupInterval < 0 {
+		return nil, fmt.Errorf("invalid BACKUP_INTERVAL %v, expected 0 or more", c.BackupInterval)
+	}
+	if c.BackupRetainCount < 0 || c.BackupRetainAge < 0 {
+		return nil, fmt.Errorf("invalid snapshot retention, expected BACKUP_RETAIN_COUNT and BACKUP_RETAIN_AGE of 0 or more")
+	}
+	if c.BackupEncryptionKMSKeyID != "" && c.BackupS3Bucket == "" {
+		return nil, fmt.Errorf("BACKUP_ENCRYPTION_KMS_KEY_ID requires BACKUP_S3_BUCKET")
+	}
+	if (c.BackupRetainCount > 0 || c.BackupRetainAge > 0) && c.BackupInterval == 0 {
+		warnings = append(warnings, "BACKUP_RETAIN_COUNT and BACKUP_RETAIN_AGE only prune snapshots after a backup, and BACKUP_INTERVAL is 0")
+	}
+	if c.BackupInterval > 0 {
+		if c.BackupTokenFile == "" {
+			return nil, fmt.Errorf("BACKUP_INTERVAL requires BACKUP_TOKEN_FILE, a token allowed to read sys/storage/raft/snapshot")
+		}
+		if c.BackupS3Bucket == "" {
+			return nil, fmt.Errorf("BACKUP_INTERVAL requires BACKUP_S3_BUCKET")
+		}
+		if c.BackupTimeout <= 0 {
+			return nil, fmt.Errorf("invalid BACKUP_TIMEOUT %v, expected more than 0", c.BackupTimeout)
+		}
+		switch c.BackupS3SSE {
+		case "", "AES256", "aws:kms":
+		default:
+			return nil, fmt.Errorf("invalid BACKUP_S3_SSE %q, expected AES256 or aws:kms", c.BackupS3SSE)
+		}
+		if c.BackupS3SSEKMSKeyID != "" && c.BackupS3SSE != "aws:kms" {
+			return nil, fmt.Errorf("BACKUP_S3_SSE_KMS_KEY_ID requires BACKUP_S3_SSE=aws:kms")
+		}
+		if c.BackupInterval < c.BackupTimeout {
+			warnings = append(warnings, fmt.Sprintf("BACKUP_INTERVAL %v is shorter than BACKUP_TIMEOUT %v, a slow snapshot delays the next one", c.BackupInterval, c.BackupTimeout))
+		}
+		if c.BackupRetainAge > 0 && c.BackupRetainAge < c.BackupInterval {
+			warnings = append(warnings, fmt.Sprintf("BACKUP_RETAIN_AGE %v is shorter than BACKUP_INTERVAL %v, only the latest snapshot is kept", c.BackupRetainAge, c.BackupInterval))
+		}
+	}
+	if c.ColdStart && c.ColdStartTimeout <= 0 {
+		return nil, fmt.Errorf("invalid COLD_START_TIMEOUT %v, expected more than 0", c.ColdStartTimeout)
+	}
+	if c.CanaryPath != "" && c.CanaryTokenFile == "" {
+		return nil, fmt.Errorf("CANARY_PATH requires CANARY_TOKEN_FILE, a token allowed to read nothing but the canary")
+	}
+
+	if c.NetworkPolicy != "" {
+		if c.VaultExternalURL != "" {
+			warnings = append(warnings, "NETWORK_POLICY is not generated when Vault is reached through VAULT_EXTERNAL_URL")
+		} else if _, err := metav1.ParseToLabelSelector(c.ControllerPodSelector); err != nil {
+			return nil, fmt.Errorf("invalid CONTROLLER_POD_SELECTOR %q: %w", c.ControllerPodSelector, err)
+		} else if len(c.PodSelectors()) > 1 {
+			return nil, fmt.Errorf("NETWORK_POLICY selects the Vault pods with a single selector, VAULT_POD_SELECTOR has %d", len(c.PodSelectors()))
+		}
+	}
+
+	if c.StateFile != "" && c.StateKeyFile == "" {
+		return nil, fmt.Errorf("STATE_FILE requires STATE_KEY_FILE, the state file is always encrypted")
+	}
+	if c.KeyCacheFile != "" && c.KeyCacheKeyFile == "" {
+		return nil, fmt.Errorf("KEY_CACHE_FILE requires KEY_CACHE_KEY_FILE, the key cache is always encrypted")
+	}
+	if c.KeyCacheFile != "" && c.KeyCacheFile == c.StateFile {
+		return nil, fmt.Errorf("KEY_CACHE_FILE and STATE_FILE must be different files")
+	}
+
+	if preset.TLS && !c.VaultTLS {
+		warnings = append(warnings, fmt.Sprintf("discovery preset %s expects Vault to serve TLS but VAULT_TLS is false", preset.Name))
+	}
+	if c.VaultCACert != "" && c.VaultCACertFrom != "" {
+		return nil, fmt.Errorf("VAULT_CACERT and VAULT_CACERT_FROM are both set, only one CA bundle can be used")
+	}
+	if c.VaultCACertFrom != "" {
+		if _, _, _, err := c.CACertSource(); err != nil {
+			return nil, err
+		}
+	}
+	if (c.VaultClientCert == "") != (c.VaultClientKey == "") {
+		return nil, fmt.Errorf("VAULT_CLIENT_CERT and VAULT_CLIENT_KEY must be set together")
+	}
+	if c.VaultClientCert != "" && c.VaultClientCertFrom != "" {
+		return nil, fmt.Errorf("VAULT_CLIENT_CERT and VAULT_CLIENT_CERT_FROM are both set, only one client certificate can be used")
+	}
+	if c.VaultClientCertConfigured() && !c.TLS() {
+		warnings = append(warnings, "a Vault client certificate is set but Vault does not serve TLS, it is not presented")
+	}
+	if c.VaultTLS && !c.VaultCAConfigured() {
+		warnings = append(warnings, "Vault serves TLS but neither VAULT_CACERT nor VAULT_CACERT_FROM is set, the system roots are trusted")
+	}
+	if c.VaultExternalURL != "" && c.VaultTLS && !strings.HasPrefix(c.VaultExternalURL, "https://") {
+		warnings = append(warnings, fmt.Sprintf("Vault serves TLS but VAULT_EXTERNAL_URL %s is not an https:// address", c.VaultExternalURL))
+	}
+
+	if c.EventReceiver && c.EventReceiverTokenFile == "" {
+		warnings = append(warnings, "EVENT_RECEIVER is set without EVENT_RECEIVER_TOKEN_FILE, anyone reaching the controller can trigger passes")
+	}
+	if c.EventReceiverTokenFile != "" && !c.EventReceiver {
+		warnings = append(warnings, "EVENT_RECEIVER_TOKEN_FILE has no effect unless EVENT_RECEIVER is set")
+	}
+	if c.AuditLogListen != "" {
+		_, port, err := net.SplitHostPort(c.AuditLogListen)
+		if err != nil || port == "" {
+			return nil, fmt.Errorf("invalid AUDIT_LOG_LISTEN %q, expected an address such as :9090", c.AuditLogListen)
+		}
+		if port == "8080" {
+			return nil, fmt.Errorf("invalid AUDIT_LOG_LISTEN %q, port 8080 serves the controller's HTTP endpoints", c.AuditLogListen)
+		}
+	}
+
+	if c.AdminAPI && c.AdminAPITokenFile == "" {
+		return nil, fmt.Errorf("ADMIN_API requires ADMIN_API_TOKEN_FILE, the admin API acts with the root token")
+	}
+	if c.AdminAPITokenFile != "" && !c.AdminAPI {
+		warnings = append(warnings, "ADMIN_API_TOKEN_FILE has no effect unless ADMIN_API is set")
+	}
+	if c.AdminAPI && c.AdminTokenTTL <= 0 {
+		return nil, fmt.Errorf("invalid ADMIN_TOKEN_TTL %v, expected 1 second or more", c.AdminTokenTTL)
+	}
+	if c.AdminRateLimit < 0 || c.AdminRateBurst < 0 || c.AdminMaxConcurrent < 0 {
+		return nil, fmt.Errorf("invalid ADMIN_RATE_LIMIT %d, ADMIN_RATE_BURST %d or ADMIN_MAX_CONCURRENT %d, expected 0 or more", c.AdminRateLimit, c.AdminRateBurst, c.AdminMaxConcurrent)
+	}
+
+	if c.InitAllowed && (c.RecoveryThreshold < 1 || c.RecoveryThreshold > c.RecoveryShares) {
+		return nil, fmt.Errorf("invalid RECOVERY_SHARES %d and RECOVERY_THRESHOLD %d, expected a threshold of 1 to the number of shares", c.RecoveryShares, c.RecoveryThreshold)
+	}
+	secretShares, secretThreshold := c.SecretSplit()
+	if c.InitAllowed && (secretThreshold < 1 || secretThreshold > secretShares) {
+		return nil, fmt.Errorf("invalid SECRET_SHARES %d and SECRET_THRESHOLD %d, expected a threshold of 1 to the number of shares", secretShares, secretThreshold)
+	}
+
+	if c.InitAllowed && min(secretThreshold, c.RecoveryThreshold) < profile.MinThreshold {
+		return nil, fmt.Errorf("profile %s initializes Vault with a threshold of %d keys or more, got SECRET_THRESHOLD %d and RECOVERY_THRESHOLD %d", profile.Name, profile.MinThreshold, secretThreshold, c.RecoveryThreshold)
+	}
+	if profile.RequireNotifications && c.NotifyWebhookURL == "" && c.NotifyExec == "" {
+		return nil, fmt.Errorf("profile %s requires NOTIFY_WEBHOOK_URL or NOTIFY_EXEC, so seals and failures reach operators", profile.Name)
+	}
+	if c.InitAllowed && secretThreshold == 1 && secretShares == 1 {
+		warnings = append(warnings, "Vault is initialized with a single unseal key, which is only fit for development")
+	}
+	switch c.InitWaitForPods {
+	case "", "replicas", "quorum":
+	default:
+		if n, err := strconv.Atoi(c.InitWaitForPods); err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid INIT_WAIT_FOR_PODS %q, expected replicas, quorum or a number of pods of 1 or more", c.InitWaitForPods)
+		}
+	}
+	if c.InitWaitForPods != "" && c.VaultExternalURL != "" {
+		return nil, fmt.Errorf("INIT_WAIT_FOR_PODS needs to list the Vault pods, it cannot be used with VAULT_EXTERNAL_URL")
+	}
+
+	switch c.KeyEncoding {
+	case "", vault.KeyEncodingHex, vault.KeyEncodingBase64:
+	default:
+		return nil, fmt.Errorf("invalid KEY_ENCODING %q, expected hex or base64", c.KeyEncoding)
+	}
+
+	if len(c.InitPGPKeys) > 0 {
+		if c.KeyProvider != "" {
+			return nil, fmt.Errorf("INIT_PGP_KEYS cannot be combined with KEY_PROVIDER, the encrypted keys are kept in the unseal keys Secret")
+		}
+		if needed := max(secretThreshold, c.RecoveryThreshold); len(c.InitPGPKeys) < needed {
+			return nil, fmt.Errorf("INIT_PGP_KEYS lists %d keys, expected at least %d, one per key share and no fewer than the threshold", len(c.InitPGPKeys), needed)
+		}
+		warnings = append(warnings, "INIT_PGP_KEYS keeps the unseal keys of a Shamir-sealed Vault encrypted, so the controller cannot unseal it and operators must submit the decrypted keys")
+	}
+	if c.InitRootTokenPGPKey != "" {
+		if c.AdminAPI {
+			return nil, fmt.Errorf("ADMIN_API cannot act with a root token encrypted to INIT_ROOT_TOKEN_PGP_KEY")
+		}
+		if c.StepDownOnDrain {
+			warnings = append(warnings, "STEP_DOWN_ON_DRAIN cannot step down the active node with a root token encrypted to INIT_ROOT_TOKEN_PGP_KEY")
+		}
+	}
+
+	if c.RevokeRootToken {
+		if c.AdminAPI {
+			return nil, fmt.Errorf("ADMIN_API cannot act with the root token REVOKE_ROOT_TOKEN revokes")
+		}
+		if c.InitRootTokenPGPKey != "" {
+			return nil, fmt.Errorf("REVOKE_ROOT_TOKEN cannot revoke a root token encrypted to INIT_ROOT_TOKEN_PGP_KEY")
+		}
+		if c.StepDownOnDrain {
+			warnings = append(warnings, "STEP_DOWN_ON_DRAIN cannot step down the active node once REVOKE_ROOT_TOKEN revoked the root token")
+		}
+	}
+	for _, policy := range c.RevokeRootTokenAdminPolicies {
+		if policy == "root" {
+			return nil, fmt.Errorf("REVOKE_ROOT_TOKEN_ADMIN_POLICIES cannot grant the root policy")
+		}
+	}
+	if len(c.RevokeRootTokenAdminPolicies) > 0 && !c.RevokeRootToken {
+		warnings = append(warnings, "REVOKE_ROOT_TOKEN_ADMIN_POLICIES has no effect unless REVOKE_ROOT_TOKEN is set")
+	}
+
+	if c.GCInterval < 0 {
+		return nil, fmt.Errorf("invalid GC_INTERVAL %v, expected 0 or more", c.GCInterval)
+	}
+	if c.ActiveSealedInterval < 0 {
+		return nil, fmt.Errorf("invalid ACTIVE_SEALED_CHECK_INTERVAL %v, expected 0 or more", c.ActiveSealedInterval)
+	}
+	if c.ReadyCacheTTL < 0 {
+		return nil, fmt.Errorf("invalid READY_CACHE_TTL %v, expected 0 or more", c.ReadyCacheTTL)
+	}
+	if c.ReadyFromReconcile && c.ReadyMaxStaleness <= c.CheckInterval {
+		return nil, fmt.Errorf("invalid READY_MAX_STALENESS %v, expected more than CHECK_INTERVAL %v so passes can keep up", c.ReadyMaxStaleness, c.CheckInterval)
+	}
+	if c.ReadyTimeout < 0 || c.StatusTimeout < 0 {
+		return nil, fmt.Errorf("invalid READY_TIMEOUT_MS %v or STATUS_TIMEOUT_MS %v, expected 0 or more", c.ReadyTimeout, c.StatusTimeout)
+	}
+
+	if c.AccessLogSamplePercent < 0 || c.AccessLogSamplePercent > 100 {
+		return nil, fmt.Errorf("invalid ACCESS_LOG_SAMPLE_PERCENT %d, expected 0 to 100", c.AccessLogSamplePercent)
+	}
+
+	if c.VaultRetryMaxAttempts < 0 {
+		return nil, fmt.Errorf("invalid VAULT_RETRY_MAX_ATTEMPTS %d, expected 1 or more", c.VaultRetryMaxAttempts)
+	}
+	if c.VaultRetryJitterPercent < 0 || c.VaultRetryJitterPercent > 100 {
+		return nil, fmt.Errorf("invalid VAULT_RETRY_JITTER_PERCENT %d, expected 0 to 100", c.VaultRetryJitterPercent)
+	}
+	if c.VaultRequestTimeout > 0 && c.VaultRetryMaxAttempts > 1 && c.retryWait() >= c.VaultRequestTimeout {
+		warnings = append(warnings, fmt.Sprintf("retries wait up to %v, longer than VAULT_REQUEST_TIMEOUT %v, so the last attempts are never sent", c.retryWait(), c.VaultRequestTimeout))
+	}
+
+	return warnings, nil
+}
+
+// retryWait returns how long all retries of a request wait in total at most
+func (c *Config) retryWait() time.Duration {
+	return c.VaultRetryBaseDelay * time.Duration(1<<(c.VaultRetryMaxAttempts-1)-1)
+}
+
+// PodSelectors returns the label selectors of the Vault server pods
+func (c *Config) PodSelectors() []string {
+	return SplitSelectors(c.PodSelector)
+}
+
+// SplitSelectors returns the label selectors of value, separated by ;. A comma separates the
+// requirements of one selector, so it cannot separate selectors.
+func SplitSelectors(value string) []string {
+	var selectors []string
+	for _, selector := range strings.Split(value, ";") {
+		if selector = strings.TrimSpace(selector); selector != "" {
+			selectors = append(selectors, selector)
+		}
+	}
+
+	return selectors
+}
+
+// SecretMetadata returns the labels and annotations added to the Secrets holding key material
+func (c *Config) SecretMetadata() (labels, annotations map[string]string, err error) {
+	if labels, err = parseKeyValues(c.SecretLabels); err != nil {
+		return nil, nil, fmt.Errorf("invalid SECRET_LABELS: %w", err)
+	}
+	if annotations, err = parseKeyValues(c.SecretAnnotations); err != nil {
+		return nil, nil, fmt.Errorf("invalid SECRET_ANNOTATIONS: %w", err)
+	}
+
+	return labels, annotations, nil
+}
+
+// parseKeyValues parses comma separated key=value pairs
+func parseKeyValues(value string) (map[string]string, error) {
+	pairs := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+
+		key, val, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("expected key=value, got %q", pair)
+		}
+		pairs[strings.TrimSpace(key)] = strings.TrimSpace(val)
+	}
+
+	return pairs, nil
+}
+
+// Hash returns a short fingerprint of the configuration, so the settings in effect when an action
+// was taken can be compared later without recording the settings themselves
+func (c *Config) Hash() string {
+	// Config only holds plain values, so marshalling cannot fail
+	data, _ := json.Marshal(c)
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// VaultAddr returns the address workloads reach Vault at: the Service in the Vault namespace,
+// or VaultService itself when it is a full hostname
+func (c *Config) VaultAddr() string {
+	scheme := "http"
+	if c.TLS() {
+		scheme = "https"
+	}
+
+	host := c.VaultService
+	if !strings.Contains(host, ".") {
+		host = fmt.Sprintf("%s.%s.svc", host, c.VaultNamespace)
+	}
+
+	return fmt.Sprintf("%s://%s:%s", scheme, host, c.VaultPort)
+}
+
+// TLS reports whether Vault's listener serves TLS
+func (c *Config) TLS() bool {
+	return c.VaultTLS || c.VaultCAConfigured()
+}
+
+// VaultCAConfigured reports whether a CA bundle for Vault's listener is configured, as a file or
+// as a reference
+func (c *Config) VaultCAConfigured() bool {
+	return c.VaultCACert != "" || c.VaultCACertFrom != ""
+}
+
+// VaultClientCertConfigured reports whether a client certificate for Vault's listener is
+// configured, as files or as a Secret
+func (c *Config) VaultClientCertConfigured() bool {
+	return c.VaultClientCert != "" || c.VaultClientCertFrom != ""
+}
+
+// CACertSource returns the kind, secret or configmap, the name and the key of the object
+// VaultCACertFrom references
+func (c *Config) CACertSource() (kind, name, key string, err error) {
+	kind, ref, ok := strings.Cut(c.VaultCACertFrom, ":")
+	if !ok || (kind != "secret" && kind != "configmap") {
+		return "", "", "", fmt.Errorf("invalid VAULT_CACERT_FROM %q, expected secret:<name>[/<key>] or configmap:<name>[/<key>]", c.VaultCACertFrom)
+	}
+
+	name, key, _ = strings.Cut(ref, "/")
+	if name == "" {
+		return "", "", "", fmt.Errorf("invalid VAULT_CACERT_FROM %q, missing the %s name", c.VaultCACertFrom, kind)
+	}
+	if key == "" {
+		key = "ca.crt"
+	}
+
+	return kind, name, key, nil
+}
+
+// AutopilotConfig returns the autopilot configuration RAFT_AUTOPILOT applies
+func (c *Config) AutopilotConfig() vault.AutopilotConfig {
+	autopilot := vault.AutopilotConfig{
+		CleanupDeadServers: c.AutopilotCleanupDead,
+		MinQuorum:          c.AutopilotMinQuorum,
+	}
+	if c.AutopilotStabilization > 0 {
+		autopilot.ServerStabilizationTime = c.AutopilotStabilization.String()
+	}
+	if c.AutopilotDeadThreshold > 0 {
+		autopilot.DeadServerLastContactThreshold = c.AutopilotDeadThreshold.String()
+	}
+
+	return autopilot
+}
+
+// InitPodsWanted returns how many Vault pods INIT_WAIT_FOR_PODS waits for to be Running, given
+// the replicas of the Vault StatefulSet, or 0 when init does not wait
+func (c *Config) InitPodsWanted(replicas int) int {
+	switch c.InitWaitForPods {
+	case "":
+		return 0
+	case "replicas":
+		return replicas
+	case "quorum":
+		return replicas/2 + 1
+	}
+	n, _ := strconv.Atoi(c.InitWaitForPods)
+
+	return n
+}
+
+// SecretSplit returns how many unseal keys a Shamir Vault is initialized with and how many of
+// them unseal it
+func (c *Config) SecretSplit() (shares, threshold int) {
+	if c.SecretShares == 0 && c.SecretThreshold == 0 {
+		return vault.DefaultSecretShares, vault.DefaultSecretThreshold
+	}
+
+	return c.SecretShares, c.SecretThreshold
+}
+
+// Debug reports whether debug logging is enabled
+func (c *Config) Debug() bool {
+	return c.LogLevel == "debug"
+}
+
 // getEnvOrDefault returns the value of an environment variable or a default value
 func getEnvOrDefault(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -50,3 +951,30 @@ func getEnvAsIntOrDefault(key string, defaultValue int) int {
 
 	return defaultValue
 }
+
+// getEnvAsListOrDefault returns the comma separated values of an environment variable or a
+// default value
+func getEnvAsListOrDefault(key string, defaultValue []string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	if len(values) == 0 {
+		return defaultValue
+	}
+
+	return values
+}
+
+// getEnvAsBoolOrDefault returns the value of an environment variable as a boolean or a default value
+func getEnvAsBoolOrDefault(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+
+	return defaultValue
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -18,15 +19,139 @@ func TestLoadConfig(t *testing.T) {
 	if cfg.CheckInterval != 10*time.Second {
 		t.Errorf("expected default check interval 10s, got %v", cfg.CheckInterval)
 	}
+	if cfg.ActiveSealedInterval != 2*time.Second {
+		t.Errorf("expected a sealed active node to be checked again every 2s by default, got %v", cfg.ActiveSealedInterval)
+	}
+	if cfg.ClockSkewThreshold != 5*time.Second {
+		t.Errorf("expected default clock skew threshold 5s, got %v", cfg.ClockSkewThreshold)
+	}
+	if cfg.GCInterval != time.Hour {
+		t.Errorf("expected orphaned Events to be collected hourly by default, got %v", cfg.GCInterval)
+	}
+	if cfg.VaultDialTimeout != 5*time.Second || cfg.VaultRequestTimeout != 10*time.Second {
+		t.Errorf("expected default Vault timeouts 5s and 10s, got %v and %v", cfg.VaultDialTimeout, cfg.VaultRequestTimeout)
+	}
+	if cfg.VaultMaxIdleConns != 4 || cfg.VaultIdleConnTimeout != 90*time.Second {
+		t.Errorf("expected default Vault connection pool of 4 for 90s, got %d for %v", cfg.VaultMaxIdleConns, cfg.VaultIdleConnTimeout)
+	}
+	if cfg.VaultRetryMaxAttempts != 3 || cfg.VaultRetryBaseDelay != 250*time.Millisecond || cfg.VaultRetryJitterPercent != 20 {
+		t.Errorf("expected default retries of 3 attempts from 250ms with 20%% jitter, got %d from %v with %d%%", cfg.VaultRetryMaxAttempts, cfg.VaultRetryBaseDelay, cfg.VaultRetryJitterPercent)
+	}
+	if cfg.VaultClientBackend != ClientBackendBuiltin {
+		t.Errorf("expected requests to Vault sent by the builtin client by default, got %q", cfg.VaultClientBackend)
+	}
+	if cfg.AdminAPI || cfg.AdminTokenTTL != 15*time.Minute {
+		t.Errorf("expected the admin API off with 15m tokens by default, got %t with %v", cfg.AdminAPI, cfg.AdminTokenTTL)
+	}
+	if cfg.RaftJoin {
+		t.Errorf("expected raft members to be left to retry_join by default")
+	}
+	if cfg.RaftAutopilot || cfg.AutopilotCleanupDead || cfg.AutopilotMinQuorum != 0 || cfg.AutopilotStabilization != 0 {
+		t.Errorf("expected autopilot left to Vault by default, got %+v", cfg.AutopilotConfig())
+	}
+	if cfg.RaftRemoveDeadPeers || cfg.RaftDeadPeerGrace != 30*time.Minute {
+		t.Errorf("expected dead raft peers kept with a 30m grace by default, got %t with %v", cfg.RaftRemoveDeadPeers, cfg.RaftDeadPeerGrace)
+	}
+	if cfg.BackupInterval != 0 || cfg.BackupTimeout != 15*time.Minute {
+		t.Errorf("expected raft snapshot backups off with a 15m timeout by default, got %v with %v", cfg.BackupInterval, cfg.BackupTimeout)
+	}
+	if cfg.ColdStart || cfg.ColdStartTimeout != 10*time.Minute {
+		t.Errorf("expected cold starts off with a 10m timeout by default, got %t with %v", cfg.ColdStart, cfg.ColdStartTimeout)
+	}
+	if cfg.SealMigration {
+		t.Errorf("expected seal migrations to wait for a request by default")
+	}
+	if cfg.AdminRateLimit != 60 || cfg.AdminRateBurst != 10 || cfg.AdminMaxConcurrent != 1 {
+		t.Errorf("expected admin requests limited to 60 a minute, 10 at once and 1 action in progress by default, got %d, %d and %d", cfg.AdminRateLimit, cfg.AdminRateBurst, cfg.AdminMaxConcurrent)
+	}
+	if cfg.RecoveryShares != 5 || cfg.RecoveryThreshold != 3 {
+		t.Errorf("expected 5 recovery shares with a threshold of 3 by default, got %d and %d", cfg.RecoveryShares, cfg.RecoveryThreshold)
+	}
+	if cfg.Profile != "" || cfg.SecretShares != 5 || cfg.SecretThreshold != 3 {
+		t.Errorf("expected no profile and 5 unseal keys with a threshold of 3 by default, got profile '%s' with %d and %d", cfg.Profile, cfg.SecretShares, cfg.SecretThreshold)
+	}
+	if cfg.RevokeRootToken || cfg.RevokeRootTokenAdminPolicies != nil {
+		t.Errorf("expected the root token to be kept by default, got revocation %t with admin policies %v", cfg.RevokeRootToken, cfg.RevokeRootTokenAdminPolicies)
+	}
+	if cfg.ReadyTimeout != 900*time.Millisecond || cfg.StatusTimeout != 5*time.Second {
+		t.Errorf("expected /ready to time out after 900ms and /status after 5s by default, got %v and %v", cfg.ReadyTimeout, cfg.StatusTimeout)
+	}
+	if cfg.AccessLogSamplePercent != 100 || cfg.AccessLogProbes {
+		t.Errorf("expected every request but probes to be logged by default, got %d%% with probes %t", cfg.AccessLogSamplePercent, cfg.AccessLogProbes)
+	}
+	if cfg.KeyEncoding != "hex" {
+		t.Errorf("expected keys to be stored hex encoded by default, got %q", cfg.KeyEncoding)
+	}
+	if cfg.MetricsCluster != "" {
+		t.Errorf("expected no cluster name for metrics by default, got %q", cfg.MetricsCluster)
+	}
+	if cfg.ReadyCacheTTL != 5*time.Second {
+		t.Errorf("expected /ready to be cached for 5s by default, got %v", cfg.ReadyCacheTTL)
+	}
+	if cfg.ReadyFromReconcile || cfg.ReadyMaxStaleness != time.Minute {
+		t.Errorf("expected /ready to check the pods itself by default, with a 1m staleness bound otherwise, got %t with %v", cfg.ReadyFromReconcile, cfg.ReadyMaxStaleness)
+	}
+	if !cfg.StepDownOnDrain {
+		t.Errorf("expected step down on drain to be enabled by default")
+	}
+	if cfg.RolloutCoordination {
+		t.Errorf("expected rollout coordination to be disabled by default")
+	}
+	if cfg.VaultStatefulSet != "vault" {
+		t.Errorf("expected default statefulset 'vault', got '%s'", cfg.VaultStatefulSet)
+	}
+	if cfg.InitAllowed {
+		t.Errorf("expected init to be disallowed by default")
+	}
+	if cfg.VerifyClusterIdentity {
+		t.Errorf("expected cluster identity verification to be disabled by default")
+	}
+	if cfg.NotifyWebhookURL != "" {
+		t.Errorf("expected notifications to be disabled by default, got '%s'", cfg.NotifyWebhookURL)
+	}
+	if cfg.LogLevel != "info" || cfg.Debug() {
+		t.Errorf("expected log level 'info' by default, got '%s'", cfg.LogLevel)
+	}
+	if cfg.DiscoveryPreset != DefaultPreset || cfg.PodSelector != "app.kubernetes.io/name=vault,component=server" || cfg.VaultTLS {
+		t.Errorf("expected the defaults of the %s preset, got preset '%s' selector '%s' TLS %t", DefaultPreset, cfg.DiscoveryPreset, cfg.PodSelector, cfg.VaultTLS)
+	}
+	if cfg.VaultExternalURL != "" {
+		t.Errorf("expected pod IPs to be used by default, got external URL '%s'", cfg.VaultExternalURL)
+	}
+	if len(cfg.CAConfigMapNamespaces) != 1 || cfg.CAConfigMapNamespaces[0] != "vault" {
+		t.Errorf("expected CA ConfigMap namespaces to default to the Vault namespace, got %v", cfg.CAConfigMapNamespaces)
+	}
 
 	// Test custom values
 	os.Setenv("VAULT_NAMESPACE", "custom-namespace")
 	os.Setenv("VAULT_PORT", "8201")
 	os.Setenv("CHECK_INTERVAL", "20")
+	os.Setenv("CLOCK_SKEW_THRESHOLD", "0")
+	os.Setenv("VAULT_REQUEST_TIMEOUT", "30")
+	os.Setenv("STEP_DOWN_ON_DRAIN", "false")
+	os.Setenv("ROLLOUT_COORDINATION", "true")
+	os.Setenv("VAULT_STATEFULSET", "vault-ha")
+	os.Setenv("INIT_ALLOWED", "true")
+	os.Setenv("VERIFY_CLUSTER_IDENTITY", "true")
+	os.Setenv("NOTIFY_WEBHOOK_URL", "https://hooks.example.com/vault")
+	os.Setenv("LOG_LEVEL", "DEBUG")
+	os.Setenv("HOOK_POST_UNSEAL", "annotate:configmap/cmdb")
+	os.Setenv("CA_CONFIGMAP_NAMESPACES", "apps, ,monitoring")
 	defer func() {
 		os.Unsetenv("VAULT_NAMESPACE")
 		os.Unsetenv("VAULT_PORT")
 		os.Unsetenv("CHECK_INTERVAL")
+		os.Unsetenv("CLOCK_SKEW_THRESHOLD")
+		os.Unsetenv("VAULT_REQUEST_TIMEOUT")
+		os.Unsetenv("STEP_DOWN_ON_DRAIN")
+		os.Unsetenv("ROLLOUT_COORDINATION")
+		os.Unsetenv("VAULT_STATEFULSET")
+		os.Unsetenv("INIT_ALLOWED")
+		os.Unsetenv("VERIFY_CLUSTER_IDENTITY")
+		os.Unsetenv("NOTIFY_WEBHOOK_URL")
+		os.Unsetenv("LOG_LEVEL")
+		os.Unsetenv("HOOK_POST_UNSEAL")
+		os.Unsetenv("CA_CONFIGMAP_NAMESPACES")
 	}()
 
 	cfg = LoadConfig()
@@ -39,6 +164,77 @@ func TestLoadConfig(t *testing.T) {
 	if cfg.CheckInterval != 20*time.Second {
 		t.Errorf("expected check interval 20s, got %v", cfg.CheckInterval)
 	}
+	if cfg.VaultRequestTimeout != 30*time.Second {
+		t.Errorf("expected Vault request timeout 30s, got %v", cfg.VaultRequestTimeout)
+	}
+	if cfg.ClockSkewThreshold != 0 {
+		t.Errorf("expected the clock skew check to be disabled, got %v", cfg.ClockSkewThreshold)
+	}
+	if cfg.StepDownOnDrain {
+		t.Errorf("expected step down on drain to be disabled")
+	}
+	if !cfg.RolloutCoordination {
+		t.Errorf("expected rollout coordination to be enabled")
+	}
+	if cfg.VaultStatefulSet != "vault-ha" {
+		t.Errorf("expected statefulset 'vault-ha', got '%s'", cfg.VaultStatefulSet)
+	}
+	if !cfg.InitAllowed {
+		t.Errorf("expected init to be allowed")
+	}
+	if !cfg.VerifyClusterIdentity {
+		t.Errorf("expected cluster identity verification to be enabled")
+	}
+	if cfg.NotifyWebhookURL != "https://hooks.example.com/vault" {
+		t.Errorf("expected notify webhook URL to be set, got '%s'", cfg.NotifyWebhookURL)
+	}
+	if !cfg.Debug() {
+		t.Errorf("expected debug logging to be enabled, got log level '%s'", cfg.LogLevel)
+	}
+	if cfg.HookPostUnseal != "annotate:configmap/cmdb" || cfg.HookPreUnseal != "" {
+		t.Errorf("expected only the post-unseal hook to be set, got pre '%s' post '%s'", cfg.HookPreUnseal, cfg.HookPostUnseal)
+	}
+	if strings.Join(cfg.CAConfigMapNamespaces, ",") != "apps,monitoring" {
+		t.Errorf("expected CA ConfigMap namespaces 'apps,monitoring', got %v", cfg.CAConfigMapNamespaces)
+	}
+
+	// A preset supplies defaults that explicit settings override
+	os.Setenv("DISCOVERY_PRESET", "bank-vaults")
+	defer os.Unsetenv("DISCOVERY_PRESET")
+	os.Unsetenv("VAULT_PORT")
+	cfg = LoadConfig()
+	if cfg.PodSelector != "app.kubernetes.io/name=vault,vault_cr=vault" || !cfg.VaultTLS || cfg.VaultPort != "8200" {
+		t.Errorf("expected the bank-vaults preset to apply, got selector '%s' TLS %t port '%s'", cfg.PodSelector, cfg.VaultTLS, cfg.VaultPort)
+	}
+	if cfg.VaultStatefulSet != "vault-ha" {
+		t.Errorf("expected VAULT_STATEFULSET to override the preset, got '%s'", cfg.VaultStatefulSet)
+	}
+	os.Setenv("VAULT_POD_SELECTOR", "app=vault,tier=server; app.kubernetes.io/name=vault ;")
+	defer os.Unsetenv("VAULT_POD_SELECTOR")
+	cfg = LoadConfig()
+	if selectors := cfg.PodSelectors(); strings.Join(selectors, "|") != "app=vault,tier=server|app.kubernetes.io/name=vault" {
+		t.Errorf("expected VAULT_POD_SELECTOR to override the preset with two selectors, got %q", selectors)
+	}
+	os.Unsetenv("VAULT_POD_SELECTOR")
+
+	// A profile supplies defaults that explicit settings override too
+	os.Setenv("PROFILE", "Dev")
+	defer os.Unsetenv("PROFILE")
+	os.Unsetenv("INIT_ALLOWED")
+	os.Setenv("RECOVERY_SHARES", "3")
+	defer os.Unsetenv("RECOVERY_SHARES")
+	cfg = LoadConfig()
+	if cfg.Profile != "dev" || !cfg.InitAllowed || cfg.SecretShares != 1 || cfg.SecretThreshold != 1 || cfg.RecoveryThreshold != 1 {
+		t.Errorf("expected the dev profile to apply, got profile '%s' init %t unseal keys %d/%d recovery threshold %d", cfg.Profile, cfg.InitAllowed, cfg.SecretThreshold, cfg.SecretShares, cfg.RecoveryThreshold)
+	}
+	if cfg.RecoveryShares != 3 || !cfg.VerifyClusterIdentity || !cfg.Debug() {
+		t.Errorf("expected explicit settings to override the profile, got %d recovery shares, identity verification %t and log level '%s'", cfg.RecoveryShares, cfg.VerifyClusterIdentity, cfg.LogLevel)
+	}
+	os.Setenv("PROFILE", "prod")
+	cfg = LoadConfig()
+	if cfg.SecretShares != 5 || cfg.SecretThreshold != 3 || cfg.InitAllowed {
+		t.Errorf("expected the prod profile to apply, got unseal keys %d/%d and init %t", cfg.SecretThreshold, cfg.SecretShares, cfg.InitAllowed)
+	}
 
 	// Test invalid check interval
 	os.Setenv("CHECK_INTERVAL", "invalid")
@@ -47,3 +243,112 @@ func TestLoadConfig(t *testing.T) {
 		t.Errorf("expected default check interval 10s for invalid input, got %v", cfg.CheckInterval)
 	}
 }
+
+func TestSecretSplit(t *testing.T) {
+	cfg := &Config{}
+	if shares, threshold := cfg.SecretSplit(); shares != 5 || threshold != 3 {
+		t.Errorf("expected Vault's 5 shares with a threshold of 3 when unset, got %d and %d", shares, threshold)
+	}
+	cfg = &Config{SecretShares: 1, SecretThreshold: 1}
+	if shares, threshold := cfg.SecretSplit(); shares != 1 || threshold != 1 {
+		t.Errorf("expected the configured split, got %d and %d", shares, threshold)
+	}
+}
+
+func TestInitPodsWanted(t *testing.T) {
+	tests := map[string]int{"": 0, "replicas": 5, "quorum": 3, "2": 2}
+	for wait, want := range tests {
+		cfg := &Config{InitWaitForPods: wait}
+		if got := cfg.InitPodsWanted(5); got != want {
+			t.Errorf("expected INIT_WAIT_FOR_PODS=%q to wait for %d of 5 replicas, got %d", wait, want, got)
+		}
+	}
+}
+
+func TestConfigHash(t *testing.T) {
+	cfg := &Config{VaultNamespace: "vault", VaultPort: "8200", CheckInterval: 10 * time.Second}
+	same := *cfg
+
+	if cfg.Hash() != same.Hash() {
+		t.Errorf("expected equal configs to hash the same")
+	}
+	if len(cfg.Hash()) != 16 {
+		t.Errorf("expected a 16 character hash, got %q", cfg.Hash())
+	}
+
+	same.InitAllowed = true
+	if cfg.Hash() == same.Hash() {
+		t.Errorf("expected a changed config to hash differently")
+	}
+}
+
+func TestVaultAddr(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want string
+	}{
+		{
+			name: "service without TLS",
+			cfg:  Config{VaultNamespace: "vault", VaultPort: "8200", VaultService: "vault"},
+			want: "http://vault.vault.svc:8200",
+		},
+		{
+			name: "service with TLS",
+			cfg:  Config{VaultNamespace: "secrets", VaultPort: "8200", VaultService: "vault-active", VaultCACert: "/vault/tls/ca.crt"},
+			want: "https://vault-active.secrets.svc:8200",
+		},
+		{
+			name: "full hostname",
+			cfg:  Config{VaultNamespace: "vault", VaultPort: "443", VaultService: "vault.example.com", VaultCACert: "/ca.crt"},
+			want: "https://vault.example.com:443",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.VaultAddr(); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCACertSource(t *testing.T) {
+	tests := []struct {
+		from        string
+		kind        string
+		name        string
+		key         string
+		expectError bool
+	}{
+		{from: "secret:vault-tls", kind: "secret", name: "vault-tls", key: "ca.crt"},
+		{from: "configmap:vault-ca/bundle.pem", kind: "configmap", name: "vault-ca", key: "bundle.pem"},
+		{from: "vault-tls", expectError: true},
+		{from: "file:/ca.crt", expectError: true},
+		{from: "secret:", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from, func(t *testing.T) {
+			cfg := Config{VaultCACertFrom: tt.from}
+			kind, name, key, err := cfg.CACertSource()
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if kind != tt.kind || name != tt.name || key != tt.key {
+				t.Errorf("expected %s %s %s, got %s %s %s", tt.kind, tt.name, tt.key, kind, name, key)
+			}
+			if !cfg.TLS() {
+				t.Errorf("expected a CA bundle reference to imply TLS")
+			}
+		})
+	}
+}
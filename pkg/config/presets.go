@@ -0,0 +1,83 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultPreset is the discovery preset used when DISCOVERY_PRESET is not set
+const DefaultPreset = "hashicorp-helm"
+
+// Preset bundles the discovery settings of a common way of deploying Vault, so switching
+// charts or operators means picking a name rather than re-deriving selectors and ports
+type Preset struct {
+	// Name selects the preset through DISCOVERY_PRESET
+	Name string
+	// Description says which deployments the preset matches
+	Description string
+	// PodSelector is the label selector of the Vault server pods
+	PodSelector string
+	// Port is the port of the Vault API listener
+	Port string
+	// TLS is whether the listener is expected to serve TLS
+	TLS bool
+	// StatefulSet and Service are the names of the Vault StatefulSet and Service
+	StatefulSet string
+	Service     string
+	// External means Vault is not discovered as pods but reached through VAULT_EXTERNAL_URL
+	External bool
+}
+
+var presets = []Preset{
+	{
+		Name:        "hashicorp-helm",
+		Description: "the official hashicorp/vault Helm chart",
+		PodSelector: "app.kubernetes.io/name=vault,component=server",
+		Port:        "8200",
+		StatefulSet: "vault",
+		Service:     "vault",
+	},
+	{
+		Name:        "bank-vaults",
+		Description: "a Vault custom resource named vault of the bank-vaults operator",
+		PodSelector: "app.kubernetes.io/name=vault,vault_cr=vault",
+		Port:        "8200",
+		TLS:         true,
+		StatefulSet: "vault",
+		Service:     "vault",
+	},
+	{
+		Name:        "external",
+		Description: "Vault outside the cluster or behind an Ingress or LoadBalancer",
+		Port:        "8200",
+		TLS:         true,
+		Service:     "vault",
+		External:    true,
+	},
+}
+
+// LookupPreset returns the preset of the given name, or the default preset and an error when
+// there is no such preset
+func LookupPreset(name string) (Preset, error) {
+	var fallback Preset
+	for _, preset := range presets {
+		if preset.Name == name {
+			return preset, nil
+		}
+		if preset.Name == DefaultPreset {
+			fallback = preset
+		}
+	}
+
+	return fallback, fmt.Errorf("unknown discovery preset %q, expected one of %s", name, strings.Join(PresetNames(), ", "))
+}
+
+// PresetNames returns the names of the built-in presets
+func PresetNames() []string {
+	names := make([]string, 0, len(presets))
+	for _, preset := range presets {
+		names = append(names, preset.Name)
+	}
+
+	return names
+}
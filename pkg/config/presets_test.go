@@ -0,0 +1,405 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLookupPreset(t *testing.T) {
+	preset, err := LookupPreset("bank-vaults")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if preset.PodSelector != "app.kubernetes.io/name=vault,vault_cr=vault" || !preset.TLS {
+		t.Errorf("unexpected bank-vaults preset %+v", preset)
+	}
+
+	preset, err = LookupPreset("vault-operator")
+	if err == nil || !strings.Contains(err.Error(), "hashicorp-helm, bank-vaults, external") {
+		t.Errorf("expected an error listing the presets, got %v", err)
+	}
+	if preset.Name != DefaultPreset {
+		t.Errorf("expected the default preset as fallback, got %q", preset.Name)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name             string
+		cfg              Config
+		expectedError    string
+		expectedWarnings []string
+	}{
+		{
+			name: "default preset",
+			cfg:  Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app.kubernetes.io/name=vault,component=server"},
+		},
+		{
+			name:          "unknown preset",
+			cfg:           Config{DiscoveryPreset: "vault-operator"},
+			expectedError: "unknown discovery preset",
+		},
+		{
+			name:          "external preset without address",
+			cfg:           Config{DiscoveryPreset: "external", VaultTLS: true},
+			expectedError: "requires VAULT_EXTERNAL_URL",
+		},
+		{
+			name:          "unknown foreign unsealer action",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", ForeignUnsealerAction: "fight"},
+			expectedError: "FOREIGN_UNSEALER_ACTION",
+		},
+		{
+			name:          "invalid secret labels",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", SecretLabels: "distribute"},
+			expectedError: "SECRET_LABELS",
+		},
+		{
+			name:          "invalid controller pod selector",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", NetworkPolicy: "vault-utils", ControllerPodSelector: "app in ("},
+			expectedError: "CONTROLLER_POD_SELECTOR",
+		},
+		{
+			name:          "two CA bundles",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", VaultCACert: "/ca.crt", VaultCACertFrom: "secret:vault-tls"},
+			expectedError: "both set",
+		},
+		{
+			name:          "invalid CA bundle reference",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", VaultCACertFrom: "vault-tls"},
+			expectedError: "VAULT_CACERT_FROM",
+		},
+		{
+			name:          "client certificate without a key",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", VaultTLS: true, VaultClientCert: "/tls/tls.crt"},
+			expectedError: "VAULT_CLIENT_KEY",
+		},
+		{
+			name:          "two client certificates",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", VaultTLS: true, VaultClientCert: "/tls/tls.crt", VaultClientKey: "/tls/tls.key", VaultClientCertFrom: "vault-client"},
+			expectedError: "both set",
+		},
+		{
+			name:             "client certificate without TLS",
+			cfg:              Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", VaultClientCertFrom: "vault-client"},
+			expectedWarnings: []string{"not presented"},
+		},
+		{
+			name:          "state file without a key",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", StateFile: "/var/lib/vault-utils/state"},
+			expectedError: "STATE_KEY_FILE",
+		},
+		{
+			name:          "key cache without a key",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", KeyCacheFile: "/var/lib/vault-utils/keys"},
+			expectedError: "KEY_CACHE_KEY_FILE",
+		},
+		{
+			name:          "canary without a token",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", CanaryPath: "secret/data/canary"},
+			expectedError: "CANARY_TOKEN_FILE",
+		},
+		{
+			name:          "no way to find Vault",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm"},
+			expectedError: "no pod selector",
+		},
+		{
+			name:          "invalid pod selector",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault;tier in ("},
+			expectedError: "invalid VAULT_POD_SELECTOR",
+		},
+		{
+			name: "several pod selectors",
+			cfg:  Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault;app.kubernetes.io/name=vault,component=server"},
+		},
+		{
+			name:          "network policy with several pod selectors",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault;app=openbao", NetworkPolicy: "vault-utils", ControllerPodSelector: "app=vault-utils"},
+			expectedError: "single selector",
+		},
+		{
+			name:             "TLS preset with TLS turned off",
+			cfg:              Config{DiscoveryPreset: "bank-vaults", PodSelector: "app.kubernetes.io/name=vault"},
+			expectedWarnings: []string{"expects Vault to serve TLS"},
+		},
+		{
+			name:             "TLS without CA bundle",
+			cfg:              Config{DiscoveryPreset: "external", VaultTLS: true, VaultExternalURL: "http://vault.example.com"},
+			expectedWarnings: []string{"neither VAULT_CACERT nor VAULT_CACERT_FROM is set", "not an https:// address"},
+		},
+		{
+			name:          "raft join through an external address",
+			cfg:           Config{DiscoveryPreset: "external", VaultExternalURL: "https://vault.example.com", RaftJoin: true},
+			expectedError: "RAFT_JOIN",
+		},
+		{
+			name:          "init waiting for an invalid number of pods",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", InitWaitForPods: "all"},
+			expectedError: "INIT_WAIT_FOR_PODS",
+		},
+		{
+			name:          "init waiting for pods through an external address",
+			cfg:           Config{DiscoveryPreset: "external", VaultExternalURL: "https://vault.example.com", InitWaitForPods: "quorum"},
+			expectedError: "INIT_WAIT_FOR_PODS",
+		},
+		{
+			name: "init waiting for a number of pods",
+			cfg:  Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", InitWaitForPods: "3"},
+		},
+		{
+			name:          "backups without a token",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", BackupInterval: time.Hour, BackupTimeout: time.Minute, BackupS3Bucket: "snapshots"},
+			expectedError: "BACKUP_TOKEN_FILE",
+		},
+		{
+			name:          "backups without a bucket",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", BackupInterval: time.Hour, BackupTimeout: time.Minute, BackupTokenFile: "/token"},
+			expectedError: "BACKUP_S3_BUCKET",
+		},
+		{
+			name:          "backups with unknown encryption",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", BackupInterval: time.Hour, BackupTimeout: time.Minute, BackupTokenFile: "/token", BackupS3Bucket: "snapshots", BackupS3SSE: "aes256"},
+			expectedError: "BACKUP_S3_SSE",
+		},
+		{
+			name:          "backups with a KMS key without KMS encryption",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", BackupInterval: time.Hour, BackupTimeout: time.Minute, BackupTokenFile: "/token", BackupS3Bucket: "snapshots", BackupS3SSEKMSKeyID: "alias/vault"},
+			expectedError: "BACKUP_S3_SSE_KMS_KEY_ID",
+		},
+		{
+			name:             "backups more often than they may take",
+			cfg:              Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", BackupInterval: time.Minute, BackupTimeout: time.Hour, BackupTokenFile: "/token", BackupS3Bucket: "snapshots", BackupS3SSE: "aws:kms"},
+			expectedWarnings: []string{"BACKUP_TIMEOUT"},
+		},
+		{
+			name:          "backups with a negative retention",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", BackupInterval: time.Hour, BackupTimeout: time.Minute, BackupTokenFile: "/token", BackupS3Bucket: "snapshots", BackupRetainCount: -1},
+			expectedError: "BACKUP_RETAIN_COUNT",
+		},
+		{
+			name:             "backups kept for less than their interval",
+			cfg:              Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", BackupInterval: time.Hour, BackupTimeout: time.Minute, BackupTokenFile: "/token", BackupS3Bucket: "snapshots", BackupRetainAge: time.Minute},
+			expectedWarnings: []string{"BACKUP_RETAIN_AGE"},
+		},
+		{
+			name:             "retention without backups",
+			cfg:              Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", BackupS3Bucket: "snapshots", BackupRetainCount: 7},
+			expectedWarnings: []string{"BACKUP_INTERVAL"},
+		},
+		{
+			name:          "snapshot encryption without a bucket",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", BackupEncryptionKMSKeyID: "alias/vault-snapshots"},
+			expectedError: "BACKUP_S3_BUCKET",
+		},
+		{
+			name:          "audit log address without a port",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", AuditLogListen: "0.0.0.0"},
+			expectedError: "AUDIT_LOG_LISTEN",
+		},
+		{
+			name:          "audit log on the HTTP port",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", AuditLogListen: ":8080"},
+			expectedError: "AUDIT_LOG_LISTEN",
+		},
+		{
+			name: "audit log socket and file",
+			cfg:  Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", AuditLogListen: ":9090", AuditLogFile: "/vault/audit/audit.log"},
+		},
+		{
+			name:          "dead raft peer removal without a token",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", RaftRemoveDeadPeers: true, RaftDeadPeerGrace: time.Minute},
+			expectedError: "RAFT_TOKEN_FILE",
+		},
+		{
+			name:          "dead raft peer removal without a grace period",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", RaftRemoveDeadPeers: true, RaftTokenFile: "/token"},
+			expectedError: "RAFT_DEAD_PEER_GRACE",
+		},
+		{
+			name:             "dead raft peers removed twice",
+			cfg:              Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", RaftRemoveDeadPeers: true, RaftTokenFile: "/token", RaftDeadPeerGrace: time.Minute, RaftAutopilot: true, AutopilotCleanupDead: true, AutopilotMinQuorum: 3},
+			expectedWarnings: []string{"AUTOPILOT_CLEANUP_DEAD_SERVERS"},
+		},
+		{
+			name:          "autopilot cleaning up dead servers without a quorum",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", RaftAutopilot: true, AutopilotCleanupDead: true, AutopilotMinQuorum: 1},
+			expectedError: "AUTOPILOT_MIN_QUORUM",
+		},
+		{
+			name:          "autopilot with an encrypted root token",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", RaftAutopilot: true, InitRootTokenPGPKey: "key"},
+			expectedError: "RAFT_AUTOPILOT",
+		},
+		{
+			name:          "cold start without a timeout",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", ColdStart: true},
+			expectedError: "COLD_START_TIMEOUT",
+		},
+		{
+			name:             "event receiver without a token",
+			cfg:              Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", EventReceiver: true},
+			expectedWarnings: []string{"without EVENT_RECEIVER_TOKEN_FILE"},
+		},
+		{
+			name:          "admin API without a token",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", AdminAPI: true, AdminTokenTTL: 15 * time.Minute},
+			expectedError: "ADMIN_API requires ADMIN_API_TOKEN_FILE",
+		},
+		{
+			name:          "recovery threshold above the shares",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", InitAllowed: true, RecoveryShares: 3, RecoveryThreshold: 4},
+			expectedError: "RECOVERY_THRESHOLD",
+		},
+		{
+			name:          "unseal threshold above the shares",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", InitAllowed: true, RecoveryShares: 5, RecoveryThreshold: 3, SecretShares: 2, SecretThreshold: 3},
+			expectedError: "SECRET_THRESHOLD",
+		},
+		{
+			name:          "unknown profile",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", Profile: "qa"},
+			expectedError: "unknown profile",
+		},
+		{
+			name:          "prod profile without notifications",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", Profile: "prod"},
+			expectedError: "requires NOTIFY_WEBHOOK_URL or NOTIFY_EXEC",
+		},
+		{
+			name:          "prod profile with a single-key threshold",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", Profile: "prod", NotifyExec: "/bin/notify", InitAllowed: true, RecoveryShares: 1, RecoveryThreshold: 1},
+			expectedError: "threshold of 2 keys or more",
+		},
+		{
+			name:             "dev profile",
+			cfg:              Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", Profile: "dev", InitAllowed: true, SecretShares: 1, SecretThreshold: 1, RecoveryShares: 1, RecoveryThreshold: 1},
+			expectedWarnings: []string{"single unseal key"},
+		},
+		{
+			name:          "fewer PGP keys than the threshold",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", InitPGPKeys: []string{"a.asc", "b.asc"}},
+			expectedError: "INIT_PGP_KEYS lists 2 keys",
+		},
+		{
+			name:             "PGP keys",
+			cfg:              Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", InitPGPKeys: []string{"a", "b", "c"}},
+			expectedWarnings: []string{"controller cannot unseal it"},
+		},
+		{
+			name:          "PGP keys with a key provider",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", InitPGPKeys: []string{"a", "b", "c"}, KeyProvider: "exec:/bin/keys"},
+			expectedError: "KEY_PROVIDER",
+		},
+		{
+			name:          "admin API with an encrypted root token",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", AdminAPI: true, AdminAPITokenFile: "/token", AdminTokenTTL: time.Minute, InitRootTokenPGPKey: "root"},
+			expectedError: "INIT_ROOT_TOKEN_PGP_KEY",
+		},
+		{
+			name:          "root token revocation with the admin API",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", AdminAPI: true, AdminAPITokenFile: "/token", AdminTokenTTL: time.Minute, RevokeRootToken: true},
+			expectedError: "REVOKE_ROOT_TOKEN",
+		},
+		{
+			name:             "root token revocation with step down on drain",
+			cfg:              Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", RevokeRootToken: true, StepDownOnDrain: true},
+			expectedWarnings: []string{"STEP_DOWN_ON_DRAIN cannot step down"},
+		},
+		{
+			name:          "root policy for the admin token",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", RevokeRootToken: true, RevokeRootTokenAdminPolicies: []string{"admin", "root"}},
+			expectedError: "root policy",
+		},
+		{
+			name:          "negative GC interval",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", GCInterval: -time.Second},
+			expectedError: "GC_INTERVAL",
+		},
+		{
+			name:          "negative active sealed interval",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", ActiveSealedInterval: -time.Second},
+			expectedError: "ACTIVE_SEALED_CHECK_INTERVAL",
+		},
+		{
+			name:          "negative ready cache TTL",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", ReadyCacheTTL: -time.Second},
+			expectedError: "READY_CACHE_TTL",
+		},
+		{
+			name:          "ready staleness within the check interval",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", CheckInterval: time.Minute, ReadyFromReconcile: true, ReadyMaxStaleness: time.Minute},
+			expectedError: "READY_MAX_STALENESS",
+		},
+		{
+			name:          "unknown key encoding",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", KeyEncoding: "base32"},
+			expectedError: "KEY_ENCODING",
+		},
+		{
+			name:          "negative admin rate limit",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", AdminRateLimit: -1},
+			expectedError: "ADMIN_RATE_LIMIT",
+		},
+		{
+			name:          "negative ready timeout",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", ReadyTimeout: -time.Second},
+			expectedError: "READY_TIMEOUT_MS",
+		},
+		{
+			name:          "access log sample above 100 percent",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", AccessLogSamplePercent: 101},
+			expectedError: "ACCESS_LOG_SAMPLE_PERCENT",
+		},
+		{
+			name:          "jitter above 100 percent",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", VaultRetryJitterPercent: 150},
+			expectedError: "VAULT_RETRY_JITTER_PERCENT",
+		},
+		{
+			name: "official Vault API client",
+			cfg:  Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", VaultClientBackend: ClientBackendOfficial},
+		},
+		{
+			name:          "unknown Vault client",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", VaultClientBackend: "vault-go"},
+			expectedError: "VAULT_CLIENT_BACKEND",
+		},
+		{
+			name:          "official Vault API client through an external address",
+			cfg:           Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", VaultClientBackend: ClientBackendOfficial, VaultExternalURL: "https://vault.example.com"},
+			expectedError: "VAULT_EXTERNAL_URL",
+		},
+		{
+			name:             "retries outlasting the request timeout",
+			cfg:              Config{DiscoveryPreset: "hashicorp-helm", PodSelector: "app=vault", VaultRequestTimeout: 10 * time.Second, VaultRetryMaxAttempts: 5, VaultRetryBaseDelay: time.Second},
+			expectedWarnings: []string{"longer than VAULT_REQUEST_TIMEOUT"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warnings, err := tt.cfg.Validate()
+			if tt.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+					t.Fatalf("expected error containing %q, got %v", tt.expectedError, err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(warnings) != len(tt.expectedWarnings) {
+				t.Fatalf("expected %d warnings, got %v", len(tt.expectedWarnings), warnings)
+			}
+			for i, expected := range tt.expectedWarnings {
+				if !strings.Contains(warnings[i], expected) {
+					t.Errorf("expected warning containing %q, got %q", expected, warnings[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/getgrowly/vault-utils/pkg/vault"
+)
+
+// Profile bundles the defaults suited to an environment, so a development cluster needs no
+// page of settings and production does not inherit development shortcuts. A setting given
+// explicitly always wins over the profile.
+type Profile struct {
+	// Name selects the profile through PROFILE
+	Name string
+	// Description says which environments the profile is meant for
+	Description string
+	// InitAllowed, VerifyClusterIdentity and LogLevel are the defaults of INIT_ALLOWED,
+	// VERIFY_CLUSTER_IDENTITY and LOG_LEVEL
+	InitAllowed           bool
+	VerifyClusterIdentity bool
+	LogLevel              string
+	// SecretShares and SecretThreshold, RecoveryShares and RecoveryThreshold are the defaults
+	// of the split of the keys generated at init
+	SecretShares      int
+	SecretThreshold   int
+	RecoveryShares    int
+	RecoveryThreshold int
+	// RequireNotifications refuses to start without NOTIFY_WEBHOOK_URL or NOTIFY_EXEC
+	RequireNotifications bool
+	// MinThreshold refuses to initialize with a threshold below it, for unseal and recovery
+	// keys alike
+	MinThreshold int
+}
+
+// defaultProfile holds the defaults when PROFILE is not set
+var defaultProfile = Profile{
+	LogLevel:          "info",
+	SecretShares:      vault.DefaultSecretShares,
+	SecretThreshold:   vault.DefaultSecretThreshold,
+	RecoveryShares:    defaultRecoveryShares,
+	RecoveryThreshold: defaultRecoveryThreshold,
+}
+
+var profiles = []Profile{
+	{
+		Name:              "dev",
+		Description:       "a throwaway Vault on a laptop or CI cluster: initialized right away with a single key, debug logs",
+		InitAllowed:       true,
+		LogLevel:          "debug",
+		SecretShares:      1,
+		SecretThreshold:   1,
+		RecoveryShares:    1,
+		RecoveryThreshold: 1,
+	},
+	{
+		Name:                  "staging",
+		Description:           "a shared cluster close to production: never initialized by accident, keys only sent to the known cluster",
+		VerifyClusterIdentity: true,
+		LogLevel:              "info",
+		SecretShares:          vault.DefaultSecretShares,
+		SecretThreshold:       vault.DefaultSecretThreshold,
+		RecoveryShares:        defaultRecoveryShares,
+		RecoveryThreshold:     defaultRecoveryThreshold,
+	},
+	{
+		Name:                  "prod",
+		Description:           "production: like staging, and refuses to run without notifications or with a single-key threshold",
+		VerifyClusterIdentity: true,
+		LogLevel:              "info",
+		SecretShares:          vault.DefaultSecretShares,
+		SecretThreshold:       vault.DefaultSecretThreshold,
+		RecoveryShares:        defaultRecoveryShares,
+		RecoveryThreshold:     defaultRecoveryThreshold,
+		RequireNotifications:  true,
+		MinThreshold:          2,
+	},
+}
+
+// LookupProfile returns the profile of the given name, the defaults when name is empty, or the
+// defaults and an error when there is no such profile
+func LookupProfile(name string) (Profile, error) {
+	if name == "" {
+		return defaultProfile, nil
+	}
+	for _, profile := range profiles {
+		if profile.Name == name {
+			return profile, nil
+		}
+	}
+
+	return defaultProfile, fmt.Errorf("unknown profile %q, expected one of %s", name, strings.Join(ProfileNames(), ", "))
+}
+
+// ProfileNames returns the names of the built-in profiles
+func ProfileNames() []string {
+	names := make([]string, 0, len(profiles))
+	for _, profile := range profiles {
+		names = append(names, profile.Name)
+	}
+
+	return names
+}
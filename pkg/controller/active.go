@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/metrics"
+	"github.com/getgrowly/vault-utils/pkg/notify"
+	"github.com/getgrowly/vault-utils/pkg/reason"
+	"github.com/getgrowly/vault-utils/pkg/status"
+)
+
+var activeNodeSealed = metrics.NewGauge("vault_utils_active_node_sealed",
+	"1 while the pod that was the active Vault node is sealed and no other node took over, 0 otherwise.")
+
+// activeFirst returns pods with active moved to the front, so the node serving clients is
+// checked and reported before the standbys
+func activeFirst(pods []string, active string) []string {
+	i := slices.Index(pods, active)
+	if i <= 0 {
+		return pods
+	}
+
+	ordered := make([]string, 0, len(pods))
+	ordered = append(ordered, active)
+	ordered = append(ordered, pods[:i]...)
+
+	return append(ordered, pods[i+1:]...)
+}
+
+// reportActiveSealed reports that pod, the active node in the previous pass, is sealed. Unlike a
+// sealed standby this leaves Vault down, so passes run every ACTIVE_SEALED_CHECK_INTERVAL
+// until it is unsealed or another node takes over. A single pod has no standby to tell it
+// apart from, and is reported as sealed like any other.
+func (c *Controller) reportActiveSealed(pod string) {
+	log.Printf("Active Vault node %s was unsealed and is sealed again", pod)
+	c.status.Update(pod, func(p *status.Pod) {
+		p.Reason = reason.ActiveSealed
+	})
+	c.notify(notify.EventActiveSealed, reason.ActiveSealed, fmt.Sprintf("pod %s in namespace %s was the active node and is sealed again", pod, c.cfg.VaultNamespace))
+
+	c.activeSealed = pod
+	activeNodeSealed.Set(1)
+}
+
+// settleActiveSealed ends the faster checks once Vault has an active node again. A pod that
+// restarted comes back under a new IP, so the sealed one going away is not enough.
+func (c *Controller) settleActiveSealed() {
+	active := c.status.Snapshot().Active
+	if c.activeSealed == "" || active == "" {
+		return
+	}
+
+	if active == c.activeSealed {
+		log.Printf("Active Vault node %s is unsealed again", active)
+	} else {
+		log.Printf("Vault node %s took over from the sealed active node %s", active, c.activeSealed)
+	}
+
+	c.activeSealed = ""
+	activeNodeSealed.Set(0)
+}
+
+// checkInterval returns how long to wait for the next pass: ACTIVE_SEALED_CHECK_INTERVAL while
+// the active node is sealed, CHECK_INTERVAL otherwise
+func (c *Controller) checkInterval() time.Duration {
+	if c.activeSealed != "" && c.cfg.ActiveSealedInterval > 0 {
+		return min(c.cfg.ActiveSealedInterval, c.cfg.CheckInterval)
+	}
+
+	return c.cfg.CheckInterval
+}
@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/notify"
+	"github.com/getgrowly/vault-utils/pkg/reason"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestActiveFirst(t *testing.T) {
+	pods := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+
+	assert.Equal(t, []string{"10.0.0.2", "10.0.0.1", "10.0.0.3"}, activeFirst(pods, "10.0.0.2"))
+	assert.Equal(t, pods, activeFirst(pods, "10.0.0.1"))
+	assert.Equal(t, pods, activeFirst(pods, ""), "without an active node the order is kept")
+	assert.Equal(t, pods, activeFirst(pods, "10.0.0.9"))
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, pods, "the pods are not reordered in place")
+}
+
+func TestReconcileReportsSealedActiveNode(t *testing.T) {
+	fakes := vaulttest.NewCluster(3, 1, 1)
+	for i, fakeVault := range fakes {
+		defer fakeVault.Close()
+		fakeVault.SetHA(i != 1)
+	}
+
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, fakes[0].Keys())
+	cfg := testConfig()
+	cfg.ActiveSealedInterval = 2 * time.Second
+	c := newTestController(t, clientset, cfg, fakes)
+	notifier := &recordingNotifier{}
+	c.notifier = notifier
+
+	c.Reconcile(context.Background())
+	assert.Equal(t, "10.0.0.2", c.Status().Snapshot().Active)
+	assert.Equal(t, cfg.CheckInterval, c.checkInterval())
+
+	// Without keys the sealed pods stay sealed, leaving no node to take over
+	assert.NoError(t, clientset.CoreV1().Secrets("vault").Delete(context.Background(), vault.UnsealKeysSecret, metav1.DeleteOptions{}))
+	for _, fakeVault := range fakes {
+		fakeVault.Seal()
+	}
+	notifier.events = nil
+	c.Reconcile(context.Background())
+
+	var sealed []notify.Event
+	for _, event := range notifier.events {
+		if event.Type == notify.EventActiveSealed || event.Type == notify.EventSealed {
+			sealed = append(sealed, event)
+		}
+	}
+	if assert.Len(t, sealed, 3) {
+		assert.Equal(t, notify.EventActiveSealed, sealed[0].Type, "the active node is reported first")
+		assert.Equal(t, reason.ActiveSealed, sealed[0].Reason)
+		assert.Contains(t, sealed[0].Message, "10.0.0.2")
+		assert.Equal(t, notify.EventSealed, sealed[1].Type)
+	}
+	assert.Equal(t, "10.0.0.2", c.activeSealed)
+	assert.Equal(t, 2*time.Second, c.checkInterval())
+	assert.Equal(t, 1.0, activeNodeSealed.Value())
+
+	// Once unsealed again the checks go back to the usual interval
+	storeUnsealKeys(t, clientset, fakes[0].Keys())
+	c.Reconcile(context.Background())
+	assert.Equal(t, "10.0.0.2", c.Status().Snapshot().Active)
+	assert.Empty(t, c.activeSealed)
+	assert.Equal(t, cfg.CheckInterval, c.checkInterval())
+	assert.Equal(t, 0.0, activeNodeSealed.Value())
+}
@@ -0,0 +1,203 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/metrics"
+	"github.com/getgrowly/vault-utils/pkg/notify"
+	"github.com/getgrowly/vault-utils/pkg/reason"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+)
+
+// Actions performed through the admin API
+const (
+	AdminCreateToken  = "create_token"
+	AdminEnableEngine = "enable_engine"
+	AdminRekey        = "rekey"
+	AdminMigrateSeal  = "migrate_seal"
+	AdminStepDown     = "step_down"
+	AdminRestore      = "restore_snapshot"
+)
+
+var adminActions = metrics.NewCounter("vault_utils_admin_actions_total",
+	"Privileged actions requested through the admin API, by action and result.", "action", "result")
+
+// AdminAudit is the audit record of an action requested through the admin API. It is logged
+// and sent as the details of an admin_action notification. It never holds a token, only its
+// accessor.
+type AdminAudit struct {
+	Action    string `json:"action"`
+	Requester string `json:"requester"`
+	// Target is what the action applies to: the policies of a token, the path of an engine, the
+	// keys rekeyed, the pods whose seal is migrated, the node stepped down, or the snapshot
+	// restored
+	Target string `json:"target"`
+	// Accessor identifies the token created, so it can be looked up or revoked
+	Accessor string `json:"accessor,omitempty"`
+	Result   string `json:"result"`
+	Error    string `json:"error,omitempty"`
+}
+
+// CreateAdminToken creates a token for requester as a child of the stored root token, so the
+// root token itself never leaves the controller. Tokens are single-use unless more uses are
+// requested, and live at most ADMIN_TOKEN_TTL.
+func (c *Controller) CreateAdminToken(ctx context.Context, requester string, req vault.TokenCreateRequest) (*vault.TokenAuth, error) {
+	ttl := c.cfg.AdminTokenTTL
+	if req.TTL != "" {
+		requested, err := time.ParseDuration(req.TTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TTL %q: %v", req.TTL, err)
+		}
+		if requested < ttl {
+			ttl = requested
+		}
+	}
+	// Vault counts TTLs in seconds and reads 0 as its default TTL, so a fraction of a second is
+	// rounded up rather than dropped
+	req.TTL = fmt.Sprintf("%ds", int((ttl+time.Second-1)/time.Second))
+	if req.NumUses == 0 {
+		req.NumUses = 1
+	}
+	req.DisplayName = "vault-utils-admin"
+	req.Meta = map[string]string{"requested_by": requester, "issued_by": c.identity}
+
+	audit := AdminAudit{Action: AdminCreateToken, Requester: requester, Target: "policies " + strings.Join(req.Policies, ",")}
+
+	var auth *vault.TokenAuth
+	err := c.asRoot(ctx, func(client *vault.Client, rootToken string) error {
+		var err error
+		auth, err = client.CreateToken(ctx, rootToken, req)
+
+		return err
+	})
+	if err == nil {
+		audit.Accessor = auth.Accessor
+	}
+	c.auditAdmin(audit, err)
+
+	return auth, err
+}
+
+// EnableSecretsEngine mounts a secrets engine at path for requester with the stored root token
+func (c *Controller) EnableSecretsEngine(ctx context.Context, requester, path string, mount vault.MountRequest) error {
+	err := c.asRoot(ctx, func(client *vault.Client, rootToken string) error {
+		return client.EnableSecretsEngine(ctx, rootToken, path, mount)
+	})
+	c.auditAdmin(AdminAudit{Action: AdminEnableEngine, Requester: requester, Target: mount.Type + " at " + path}, err)
+
+	return err
+}
+
+// StepDownActive has the active node give up leadership for requester, so a standby takes over,
+// and returns the pod that was active
+func (c *Controller) StepDownActive(ctx context.Context, requester string) (string, error) {
+	pod, err := c.stepDownActive(ctx)
+	target := "the active node"
+	if pod != "" {
+		target += " " + pod
+	}
+	c.auditAdmin(AdminAudit{Action: AdminStepDown, Requester: requester, Target: target}, err)
+
+	return pod, err
+}
+
+func (c *Controller) stepDownActive(ctx context.Context) (string, error) {
+	rootToken, err := c.rootToken()
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.requestTimeout())
+	defer cancel()
+
+	pod, err := c.active.Refresh(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// Unlike other operations this is not retried on the new active node when leadership moves,
+	// which would step that one down as well
+	client := c.vaultClient(pod)
+	leader, err := client.Leader(ctx)
+	if err != nil {
+		return pod, err
+	}
+	if !leader.HAEnabled {
+		return pod, fmt.Errorf("vault does not run in HA mode, there is no standby to take over")
+	}
+	if err := client.StepDown(ctx, rootToken); err != nil {
+		return pod, err
+	}
+	log.Printf("Stepped down active Vault node %s on request", pod)
+
+	return pod, nil
+}
+
+// asRoot runs op against the active node with the stored root token
+func (c *Controller) asRoot(ctx context.Context, op func(client *vault.Client, rootToken string) error) error {
+	rootToken, err := c.rootToken()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.requestTimeout())
+	defer cancel()
+
+	return c.active.Do(ctx, func(client *vault.Client) error {
+		return op(client, rootToken)
+	})
+}
+
+// rootToken reads the stored root token, refusing one that is encrypted to an operator's PGP key
+func (c *Controller) rootToken() (string, error) {
+	secret, err := c.k8sClient.GetSecret(c.cfg.VaultNamespace, vault.RootTokenSecret)
+	if err != nil {
+		return "", fmt.Errorf("failed to get root token: %v", err)
+	}
+	if secret.Annotations[vault.PGPEncryptedAnnotation] == "true" {
+		return "", fmt.Errorf("secret %s holds a root token encrypted to an operator's PGP key", vault.RootTokenSecret)
+	}
+	if revokedAt := secret.Annotations[vault.RootTokenRevokedAtAnnotation]; revokedAt != "" {
+		return "", fmt.Errorf("the root token in secret %s was revoked at %s", vault.RootTokenSecret, revokedAt)
+	}
+	rootToken := string(secret.Data["token"])
+	if rootToken == "" {
+		return "", fmt.Errorf("secret %s holds no root token", vault.RootTokenSecret)
+	}
+
+	return rootToken, nil
+}
+
+// auditAdmin logs an action requested through the admin API, counts it and notifies operators
+func (c *Controller) auditAdmin(audit AdminAudit, err error) {
+	audit.Result = "success"
+	if err != nil {
+		audit.Result = "failure"
+		audit.Error = err.Error()
+	}
+	adminActions.Inc(audit.Action, audit.Result)
+
+	message := fmt.Sprintf("%s requested %s on %s in namespace %s: %s", audit.Requester, audit.Action, audit.Target, c.cfg.VaultNamespace, audit.Result)
+	if audit.Accessor != "" {
+		message += fmt.Sprintf(" (accessor %s)", audit.Accessor)
+	}
+	if err != nil {
+		message += fmt.Sprintf(": %v", err)
+	}
+	log.Printf("Audit: %s", message)
+
+	event := notify.Event{
+		Type:      notify.EventAdminAction,
+		Reason:    reason.AdminAction,
+		Component: "controller",
+		Message:   message,
+		Details:   audit,
+	}
+	if err := c.notifier.Notify(event); err != nil {
+		log.Printf("Error sending %s notification: %v", notify.EventAdminAction, err)
+	}
+}
@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/notify"
+	"github.com/getgrowly/vault-utils/pkg/reason"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAdminActions(t *testing.T) {
+	fakeVault := vaulttest.NewServer()
+	defer fakeVault.Close()
+
+	cfg := testConfig()
+	cfg.AdminTokenTTL = 15 * time.Minute
+	c := newTestController(t, fake.NewSimpleClientset(), cfg, []*vaulttest.Server{fakeVault})
+	notifier := &recordingNotifier{}
+	c.notifier = notifier
+
+	// The first pass initializes and unseals Vault and stores the root token
+	c.Reconcile(context.Background())
+	notifier.events = nil
+
+	auth, err := c.CreateAdminToken(context.Background(), "alice", vault.TokenCreateRequest{Policies: []string{"admin"}, TTL: "2h"})
+	if err != nil {
+		t.Fatalf("failed to create admin token: %v", err)
+	}
+	token, ok := fakeVault.Tokens()[auth.Accessor]
+	if !ok {
+		t.Fatalf("expected the token to be created in Vault")
+	}
+	if token.TTL != "900s" || token.NumUses != 1 || token.Meta["requested_by"] != "alice" {
+		t.Errorf("expected a single-use token capped at 15m for alice, got %+v", token)
+	}
+
+	// A fraction of a second is rounded up, since Vault reads a TTL of 0 as its default TTL
+	auth, err = c.CreateAdminToken(context.Background(), "alice", vault.TokenCreateRequest{Policies: []string{"admin"}, TTL: "500ms"})
+	if err != nil {
+		t.Fatalf("failed to create admin token: %v", err)
+	}
+	if token := fakeVault.Tokens()[auth.Accessor]; token.TTL != "1s" {
+		t.Errorf("expected a sub-second TTL to be rounded up to 1s, got %q", token.TTL)
+	}
+
+	if err := c.EnableSecretsEngine(context.Background(), "alice", "kv", vault.MountRequest{Type: "kv-v2"}); err != nil {
+		t.Fatalf("failed to enable secrets engine: %v", err)
+	}
+	if err := c.EnableSecretsEngine(context.Background(), "bob", "kv", vault.MountRequest{Type: "kv-v2"}); err == nil {
+		t.Errorf("expected a path in use to be refused")
+	}
+	if mounts := fakeVault.Mounts(); mounts["kv/"] != "kv-v2" {
+		t.Errorf("expected kv-v2 to be mounted at kv/, got %v", mounts)
+	}
+
+	// Every action is audited, refused ones too, and no notification holds the token
+	var results []string
+	for _, event := range notifier.events {
+		if event.Type != notify.EventAdminAction || event.Reason != reason.AdminAction {
+			t.Errorf("unexpected notification %+v", event)
+		}
+		if strings.Contains(event.Message, auth.ClientToken) {
+			t.Errorf("expected the token to stay out of the audit trail, got %q", event.Message)
+		}
+		audit := event.Details.(AdminAudit)
+		results = append(results, audit.Requester+" "+audit.Action+" "+audit.Result)
+	}
+	expected := []string{"alice create_token success", "alice create_token success", "alice enable_engine success", "bob enable_engine failure"}
+	if strings.Join(results, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected audit records %v, got %v", expected, results)
+	}
+}
+
+func TestStepDownActive(t *testing.T) {
+	fakeVault := vaulttest.NewServer()
+	defer fakeVault.Close()
+
+	c := newTestController(t, fake.NewSimpleClientset(), testConfig(), []*vaulttest.Server{fakeVault})
+	c.Reconcile(context.Background())
+
+	// Without HA there is no standby to take over
+	if _, err := c.StepDownActive(context.Background(), "alice"); err == nil {
+		t.Errorf("expected an error without HA")
+	}
+	if fakeVault.StepDowns() != 0 {
+		t.Errorf("expected Vault not to be asked to step down without HA")
+	}
+
+	fakeVault.SetHA(false)
+	pod, err := c.StepDownActive(context.Background(), "alice")
+	if err != nil {
+		t.Fatalf("failed to step down: %v", err)
+	}
+	if pod != "10.0.0.1" || fakeVault.StepDowns() != 1 {
+		t.Errorf("expected pod 10.0.0.1 to step down once, got %q stepped down %d times", pod, fakeVault.StepDowns())
+	}
+}
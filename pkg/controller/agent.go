@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/getgrowly/vault-utils/pkg/reason"
+	"github.com/getgrowly/vault-utils/pkg/status"
+)
+
+// detectAgent checks, once per pod, whether the Vault port of the pod is served by a Vault Agent
+// or Proxy, as when the agent injector was let loose on the Vault pods or the selector matches
+// agent pods. An agent forwards the Vault API to whichever server it points at, so init and
+// unseal through it would act on another pod. When the pod declares a server port of its own,
+// the pod is reached there from then on; otherwise it is left alone and detectAgent returns true.
+func (c *Controller) detectAgent(ctx context.Context, pod string) bool {
+	if c.external != nil {
+		return false
+	}
+	if agent, probed := c.agentPods[pod]; probed {
+		if agent {
+			c.recordAgent(pod)
+		}
+
+		return agent
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, c.requestTimeout())
+	agent, err := c.vaultClient(pod).ServedByAgent(probeCtx)
+	cancel()
+	if err != nil {
+		// The status check that follows reports an unreachable pod, and the probe is retried
+		// on the next pass
+		return false
+	}
+	if !agent {
+		c.agentPods[pod] = false
+
+		return false
+	}
+
+	serverPort, err := c.k8sClient.VaultServerPort(c.cfg.VaultNamespace, pod, c.cfg.VaultPort)
+	if err != nil {
+		log.Printf("Error looking up the Vault server port of pod %s: %v", pod, err)
+	}
+	if serverPort != "" {
+		c.agentPods[pod] = false
+		c.serverPortsMu.Lock()
+		c.serverPorts[pod] = serverPort
+		c.serverPortsMu.Unlock()
+		log.Printf("Port %s of pod %s is served by a Vault Agent or Proxy, using the server port %s instead", c.cfg.VaultPort, pod, serverPort)
+
+		return false
+	}
+
+	c.agentPods[pod] = true
+	log.Printf("Warning: port %s of pod %s is served by a Vault Agent or Proxy and the pod declares no other Vault port, leaving it alone", c.cfg.VaultPort, pod)
+	c.recordAgent(pod)
+
+	return true
+}
+
+// recordAgent records for /status that the pod is left alone because it is served by an agent
+func (c *Controller) recordAgent(pod string) {
+	c.status.Update(pod, func(p *status.Pod) {
+		*p = status.Pod{Pod: pod, Reachable: true, Reason: reason.VaultAgent,
+			Error: fmt.Sprintf("port %s is served by a Vault Agent or Proxy rather than the Vault server", c.cfg.VaultPort)}
+	})
+}
+
+// forgetAgents drops what was learned about pods no longer listed, whose IPs may be reused by
+// other pods
+func (c *Controller) forgetAgents(pods []string) {
+	listed := make(map[string]bool, len(pods))
+	for _, pod := range pods {
+		listed[pod] = true
+	}
+
+	c.serverPortsMu.Lock()
+	defer c.serverPortsMu.Unlock()
+
+	for pod := range c.agentPods {
+		if !listed[pod] {
+			delete(c.agentPods, pod)
+			delete(c.serverPorts, pod)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/getgrowly/vault-utils/pkg/reason"
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReconcileLeavesAgentAlone(t *testing.T) {
+	agent := vaulttest.NewServer()
+	defer agent.Close()
+	agent.SetAgent()
+
+	c := newTestController(t, fake.NewSimpleClientset(), testConfig(), []*vaulttest.Server{agent})
+
+	c.Reconcile(context.Background())
+	c.Reconcile(context.Background())
+
+	if agent.Initialized() {
+		t.Fatalf("expected no init through a Vault Agent")
+	}
+	snapshot := c.status.Snapshot()
+	if len(snapshot.Pods) != 1 || snapshot.Pods[0].Reason != reason.VaultAgent {
+		t.Errorf("expected the pod to be reported as served by an agent, got %+v", snapshot.Pods)
+	}
+	// Probed once on the first pass, on /agent/v1/metrics only
+	if agent.Requests() != 1 {
+		t.Errorf("expected the agent to be probed once and not queried otherwise, got %d requests", agent.Requests())
+	}
+}
+
+func TestReconcileRoutesPastAgentToServerPort(t *testing.T) {
+	agent := vaulttest.NewServer()
+	defer agent.Close()
+	agent.SetAgent()
+	server := vaulttest.NewServer()
+	defer server.Close()
+
+	clientset := fake.NewSimpleClientset()
+	c := newTestController(t, clientset, testConfig(), []*vaulttest.Server{agent})
+	c.vaultAddress = func(podIP string) string {
+		if c.serverPorts[podIP] == "8300" {
+			return server.URL
+		}
+
+		return agent.URL
+	}
+
+	pod, err := clientset.CoreV1().Pods("vault").Get(context.Background(), "vault-0", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod.Spec.Containers = []corev1.Container{
+		{Name: "vault-agent"},
+		{Name: "vault", Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8300}}},
+	}
+	if _, err := clientset.CoreV1().Pods("vault").Update(context.Background(), pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Reconcile(context.Background())
+
+	if agent.Initialized() || !server.Initialized() {
+		t.Fatalf("expected the server behind the agent to be initialized through its own port")
+	}
+}
@@ -0,0 +1,49 @@
+package controller
+
+import (
+	"context"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/auditlog"
+)
+
+// auditLogPollInterval is how often AUDIT_LOG_FILE is checked for new entries
+const auditLogPollInterval = time.Second
+
+// SetAuditLogListener has the audit log of socket audit devices received on listener
+func (c *Controller) SetAuditLogListener(listener net.Listener) {
+	c.auditListener = listener
+}
+
+// followAuditLogs starts reading the audit log sent to the listener and written to
+// AUDIT_LOG_FILE, when configured, until ctx is cancelled. The sender of a socket audit device
+// is named by its address, and a file is taken to be the log of the pod the controller runs in,
+// as a sidecar.
+func (c *Controller) followAuditLogs(ctx context.Context) {
+	if c.auditListener != nil {
+		go auditlog.Serve(ctx, c.auditListener, c.reportAuditEvent)
+	}
+	if c.cfg.AuditLogFile != "" {
+		pod, err := os.Hostname()
+		if err != nil {
+			pod = c.cfg.AuditLogFile
+		}
+		go auditlog.Tail(ctx, c.cfg.AuditLogFile, auditLogPollInterval, func(event auditlog.Event) {
+			c.reportAuditEvent(pod, event)
+		})
+	}
+}
+
+// reportAuditEvent has the next pass start right away for a seal or step-down found in the audit
+// log of pod, as ReportEvent does
+func (c *Controller) reportAuditEvent(pod string, event auditlog.Event) {
+	kind := EventSealed
+	if event.Kind == auditlog.SteppedDown {
+		kind = EventSteppedDown
+	}
+	log.Printf("Audit log of %s records a request to %s at %s", pod, event.Path, event.Time.Format(time.RFC3339))
+	c.ReportEvent(pod, kind)
+}
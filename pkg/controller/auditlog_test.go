@@ -0,0 +1,37 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestFollowAuditLogs(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	c := newTestController(t, fake.NewSimpleClientset(), testConfig(), nil)
+	c.SetAuditLogListener(listener)
+	steppedDown := eventsReceived.Value(EventSteppedDown)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.followAuditLogs(ctx)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+	fmt.Fprintln(conn, `{"time":"2024-01-01T00:00:00Z","type":"response","request":{"operation":"update","path":"sys/step-down"}}`)
+
+	select {
+	case pod := <-c.events:
+		assert.Equal(t, "127.0.0.1", pod)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected a step-down in the audit log to request a pass")
+	}
+	assert.Equal(t, steppedDown+1, eventsReceived.Value(EventSteppedDown))
+}
@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/getgrowly/vault-utils/pkg/status"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+)
+
+// configureAutopilot applies the autopilot configuration of RAFT_AUTOPILOT to a healthy cluster
+// using integrated storage, with the root token stored at init. The configuration applied is
+// recorded on the root token Secret, so it is applied once, and again only when it changes.
+// REVOKE_ROOT_TOKEN waits for it to be settled.
+func (c *Controller) configureAutopilot(ctx context.Context, statuses map[string]*vault.Status) {
+	if !c.cfg.RaftAutopilot || c.autopilotSettled || c.phase != status.PhaseHealthy {
+		return
+	}
+
+	raft := false
+	for _, status := range statuses {
+		if status.Raft() {
+			raft = true
+
+			break
+		}
+	}
+	if !raft {
+		log.Printf("Not configuring autopilot: the Vault cluster does not use integrated storage")
+		c.autopilotSettled = true
+
+		return
+	}
+
+	exists, err := c.k8sClient.SecretExists(c.cfg.VaultNamespace, vault.RootTokenSecret)
+	if err != nil {
+		log.Printf("Error looking for the root token to configure autopilot: %v", err)
+
+		return
+	}
+	if !exists {
+		log.Printf("Not configuring autopilot: secret %s does not exist", vault.RootTokenSecret)
+		c.autopilotSettled = true
+
+		return
+	}
+	secret, err := c.k8sClient.GetSecret(c.cfg.VaultNamespace, vault.RootTokenSecret)
+	if err != nil {
+		log.Printf("Error reading the root token to configure autopilot: %v", err)
+
+		return
+	}
+
+	autopilot := c.cfg.AutopilotConfig()
+	desired, err := json.Marshal(autopilot)
+	if err != nil {
+		log.Printf("Error encoding the autopilot configuration: %v", err)
+
+		return
+	}
+	if secret.Annotations[vault.AutopilotConfigAnnotation] == string(desired) {
+		c.autopilotSettled = true
+
+		return
+	}
+
+	rootToken, err := c.rootToken()
+	if err != nil {
+		log.Printf("Not configuring autopilot: %v", err)
+		c.autopilotSettled = true
+
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.requestTimeout())
+	defer cancel()
+	err = c.active.Do(ctx, func(client *vault.Client) error {
+		return client.SetAutopilotConfiguration(ctx, rootToken, autopilot)
+	})
+	if err != nil {
+		log.Printf("Error configuring autopilot: %v", err)
+
+		return
+	}
+
+	secret = secret.DeepCopy()
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	secret.Annotations[vault.AutopilotConfigAnnotation] = string(desired)
+	if err := c.k8sClient.UpdateSecret(secret); err != nil {
+		log.Printf("Error recording the autopilot configuration on secret %s: %v", vault.RootTokenSecret, err)
+
+		return
+	}
+	c.autopilotSettled = true
+
+	log.Printf("Audit: configured autopilot namespace=%s configured-by=%s config=%s", c.cfg.VaultNamespace, c.identity, desired)
+}
@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/vault"
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestConfigureAutopilot(t *testing.T) {
+	fakes := vaulttest.NewRaftCluster(1)
+	defer fakes[0].Close()
+
+	cfg := testConfig()
+	cfg.RaftAutopilot = true
+	cfg.AutopilotCleanupDead = true
+	cfg.AutopilotMinQuorum = 3
+	cfg.AutopilotStabilization = 10 * time.Second
+	cfg.RevokeRootToken = true
+	clientset := fake.NewSimpleClientset()
+	c := newTestController(t, clientset, cfg, fakes)
+
+	// The pass initializing the cluster configures autopilot before revoking the root token
+	c.Reconcile(context.Background())
+	assert.Equal(t, map[string]interface{}{
+		"cleanup_dead_servers":      true,
+		"min_quorum":                float64(3),
+		"server_stabilization_time": "10s",
+	}, fakes[0].Autopilot())
+	assert.True(t, fakes[0].RootTokenRevoked())
+
+	secret, err := c.k8sClient.GetSecret("vault", vault.RootTokenSecret)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"cleanup_dead_servers":true,"min_quorum":3,"server_stabilization_time":"10s"}`,
+		secret.Annotations[vault.AutopilotConfigAnnotation])
+
+	// After a restart the configuration is found recorded and nothing is applied
+	c.autopilotSettled = false
+	clientset.ClearActions()
+	c.configureAutopilot(context.Background(), map[string]*vault.Status{"10.0.0.1": {StorageType: vault.StorageTypeRaft}})
+	assert.True(t, c.autopilotSettled)
+	for _, action := range clientset.Actions() {
+		assert.NotEqual(t, "update", action.GetVerb())
+	}
+}
+
+func TestConfigureAutopilotSkipsOtherStorage(t *testing.T) {
+	fakeVault := vaulttest.NewServer()
+	defer fakeVault.Close()
+
+	cfg := testConfig()
+	cfg.RaftAutopilot = true
+	cfg.RevokeRootToken = true
+	c := newTestController(t, fake.NewSimpleClientset(), cfg, []*vaulttest.Server{fakeVault})
+
+	// Without integrated storage there is nothing to configure, and revocation goes ahead
+	c.Reconcile(context.Background())
+	assert.True(t, c.autopilotSettled)
+	assert.Nil(t, fakeVault.Autopilot())
+	assert.True(t, fakeVault.RootTokenRevoked())
+}
@@ -0,0 +1,343 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/backup"
+	"github.com/getgrowly/vault-utils/pkg/metrics"
+	"github.com/getgrowly/vault-utils/pkg/recovery"
+	"github.com/getgrowly/vault-utils/pkg/status"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+)
+
+// backupWarnings is the source of the /status warnings about raft snapshot backups
+const backupWarnings = "backup"
+
+// backupRetryDelay is how soon a failed backup is tried again, unless BACKUP_INTERVAL is shorter
+const backupRetryDelay = 5 * time.Minute
+
+// catalogRetryDelay is how soon /status/backups reads the backup catalog again after reading it
+// failed, so requests to it cannot have the bucket listed on every one
+const catalogRetryDelay = time.Minute
+
+// transferProgressInterval is how often the progress of a snapshot backup or restore is logged
+const transferProgressInterval = 30 * time.Second
+
+var (
+	backupsTotal = metrics.NewCounter("vault_utils_backups_total",
+		"Raft snapshot backups attempted, by success or failure.", "result")
+	backupLastSuccess = metrics.NewGauge("vault_utils_backup_last_success_timestamp_seconds",
+		"Unix time of the latest raft snapshot backup uploaded.")
+	backupSize = metrics.NewGauge("vault_utils_backup_size_bytes",
+		"Size of the latest raft snapshot backup uploaded.")
+	backupsPruned = metrics.NewCounter("vault_utils_backups_pruned_total",
+		"Raft snapshot backups deleted by the retention.")
+	snapshotTransferred = metrics.NewGauge("vault_utils_snapshot_transferred_bytes",
+		"Bytes of the raft snapshot transferred by the backup or restore in progress, or the latest one.", "operation")
+)
+
+// SetBackupStore has raft snapshots uploaded to store every BACKUP_INTERVAL
+func (c *Controller) SetBackupStore(store backup.Store) {
+	c.backupStore = store
+}
+
+// SetBackupEncryption has raft snapshots encrypted with data keys from keys before they are
+// uploaded, and decrypted with them to restore one
+func (c *Controller) SetBackupEncryption(keys backup.DataKeys) {
+	c.backupKeys = keys
+}
+
+// runBackups takes a raft snapshot every BACKUP_INTERVAL, the first as soon as the cluster is
+// unsealed, until ctx is cancelled. It runs beside the reconcile loop, since streaming a large
+// snapshot would hold up passes.
+func (c *Controller) runBackups(ctx context.Context) {
+	next := time.Now()
+	skipped := ""
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+
+		snapshot := c.status.Snapshot()
+		waiting := ""
+		switch {
+		case snapshot.Phase != status.PhaseHealthy && snapshot.Phase != status.PhaseDegraded:
+			waiting = fmt.Sprintf("the cluster is %s", snapshot.Phase)
+		case !usesRaft(snapshot.Pods):
+			waiting = "the cluster does not use integrated storage"
+		}
+		if waiting != "" {
+			if waiting != skipped {
+				log.Printf("Not backing up a raft snapshot yet: %s", waiting)
+				skipped = waiting
+			}
+			next = time.Now().Add(c.cfg.CheckInterval)
+
+			continue
+		}
+		skipped = ""
+
+		if c.backupSafely(ctx) {
+			next = time.Now().Add(c.cfg.BackupInterval)
+		} else {
+			next = time.Now().Add(min(c.cfg.BackupInterval, backupRetryDelay))
+		}
+	}
+}
+
+// usesRaft reports whether any unsealed pod uses integrated storage
+func usesRaft(pods []status.Pod) bool {
+	for _, pod := range pods {
+		if pod.State() == status.Unsealed && pod.StorageType == vault.StorageTypeRaft {
+			return true
+		}
+	}
+
+	return false
+}
+
+// backupSafely runs backupRaft and recovers a panic in it, so backups go on with the next one
+func (c *Controller) backupSafely(ctx context.Context) (ok bool) {
+	defer func() {
+		recovery.Handle("backup", recover(), c.notifier)
+	}()
+
+	return c.backupRaft(ctx).OK
+}
+
+// backupRaft takes a raft snapshot from the active node and uploads it, recording the outcome
+// in /status, the metrics and the warnings
+func (c *Controller) backupRaft(ctx context.Context) status.Backup {
+	start := time.Now()
+	result := status.Backup{TakenAt: start.UTC(), LastSuccessAt: c.lastBackup}
+
+	key, err := c.uploadSnapshot(ctx, start, &result)
+	result.Duration = time.Since(start).Round(time.Millisecond).String()
+	result.OK = err == nil
+	if err != nil {
+		result.Error = err.Error()
+		backupsTotal.Inc("failure")
+		log.Printf("Error backing up a raft snapshot: %v", err)
+		c.status.SetWarnings(backupWarnings, []string{fmt.Sprintf("the latest raft snapshot backup failed: %v", err)})
+	} else {
+		c.lastBackup = result.TakenAt
+		result.LastSuccessAt = result.TakenAt
+		backupsTotal.Inc("success")
+		backupLastSuccess.Set(float64(start.Unix()))
+		backupSize.Set(float64(result.Size))
+		log.Printf("Backed up a raft snapshot of %d bytes from pod %s to %s in %s", result.Size, result.Pod, result.Location, result.Duration)
+
+		var warnings []string
+		result.Pruned, err = c.pruneSnapshots(ctx)
+		if err != nil {
+			log.Printf("Error pruning old raft snapshots: %v", err)
+			warnings = append(warnings, fmt.Sprintf("pruning old raft snapshots failed: %v", err))
+		}
+		if err := c.recordSnapshot(ctx, key, result); err != nil {
+			log.Printf("Error recording the raft snapshot in the backup catalog: %v", err)
+			warnings = append(warnings, fmt.Sprintf("recording the raft snapshot in the backup catalog failed: %v", err))
+		}
+		c.status.SetWarnings(backupWarnings, warnings)
+		c.refreshCatalog(ctx)
+	}
+	c.status.SetBackup(result)
+
+	return result
+}
+
+// uploadSnapshot streams a raft snapshot from the active node to the store, under
+// BACKUP_S3_PREFIX/<namespace>/<time>.snap, without writing it to disk, so the controller runs
+// on a read-only root filesystem. An encrypted snapshot is encrypted as it streams, and its key
+// ends with .snap.enc. It returns the key.
+func (c *Controller) uploadSnapshot(ctx context.Context, start time.Time, result *status.Backup) (string, error) {
+	token, err := os.ReadFile(c.cfg.BackupTokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the backup token: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.BackupTimeout)
+	defer cancel()
+
+	suffix := backup.SnapshotSuffix
+	if c.backupKeys != nil {
+		suffix = backup.EncryptedSuffix
+		result.Encrypted = true
+	}
+	key := path.Join(c.cfg.BackupS3Prefix, c.cfg.VaultNamespace, start.UTC().Format("20060102T150405Z")+suffix)
+	result.Location = fmt.Sprintf("%s/%s", c.backupStore, key)
+
+	err = c.active.Do(ctx, func(client *vault.Client) error {
+		// Recorded in the catalog, which does without it when the node does not answer
+		result.VaultVersion = ""
+		if status, err := client.CheckStatus(ctx); err == nil {
+			result.VaultVersion = status.Version
+		}
+
+		// A retry against a new active node starts the upload over
+		reader, writer := io.Pipe()
+		uploaded := make(chan error, 1)
+		go func() {
+			// The size uploaded, which encryption makes larger than the snapshot
+			progress := transferProgress(reader, "backup", result.Location)
+			hash := sha256.New()
+			size, err := c.backupStore.Put(ctx, key, io.TeeReader(progress, hash))
+			snapshotTransferred.Set(float64(progress.Transferred()), "backup")
+			result.Size = size
+			result.SHA256 = hex.EncodeToString(hash.Sum(nil))
+			// Stops the snapshot when the upload fails
+			reader.CloseWithError(err)
+			uploaded <- err
+		}()
+
+		err := c.streamSnapshot(ctx, client, strings.TrimSpace(string(token)), writer)
+		// Fails the upload when the snapshot failed, and ends it otherwise
+		writer.CloseWithError(err)
+		uploadErr := <-uploaded
+		// A snapshot stopped by a failed upload fails with the upload's error
+		if uploadErr != nil && (err == nil || errors.Is(err, uploadErr)) {
+			return uploadErr
+		}
+
+		return err
+	})
+	result.Pod = c.active.Active()
+
+	return key, err
+}
+
+// streamSnapshot writes a raft snapshot taken by client to w, encrypted when backups are
+func (c *Controller) streamSnapshot(ctx context.Context, client *vault.Client, token string, w io.Writer) error {
+	if c.backupKeys == nil {
+		_, err := client.RaftSnapshot(ctx, token, w)
+
+		return err
+	}
+	// A retry encrypts with a new data key, so no nonce is used twice with one
+	encrypted, err := backup.Encrypt(ctx, c.backupKeys, w)
+	if err != nil {
+		return err
+	}
+	if _, err := client.RaftSnapshot(ctx, token, encrypted); err != nil {
+		return err
+	}
+
+	return encrypted.Close()
+}
+
+// transferProgress returns a reader of r logging how much of the snapshot at location was
+// transferred every transferProgressInterval, and exporting it for operation backup or restore
+func transferProgress(r io.Reader, operation, location string) *backup.ProgressReader {
+	snapshotTransferred.Set(0, operation)
+
+	return backup.NewProgressReader(r, transferProgressInterval, func(transferred int64) {
+		snapshotTransferred.Set(float64(transferred), operation)
+		log.Printf("Raft snapshot %s of %s in progress, %s transferred", operation, location, backup.FormatSize(transferred))
+	})
+}
+
+// pruneSnapshots deletes the snapshots of the namespace BACKUP_RETAIN_COUNT and
+// BACKUP_RETAIN_AGE no longer keep, and returns how many. It runs after a successful backup, so
+// the snapshot just uploaded is the newest, which is always kept.
+func (c *Controller) pruneSnapshots(ctx context.Context) (int, error) {
+	retention := backup.Retention{Count: c.cfg.BackupRetainCount, MaxAge: c.cfg.BackupRetainAge}
+	if !retention.Enabled() {
+		return 0, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.BackupTimeout)
+	defer cancel()
+
+	pruned, err := backup.Prune(ctx, c.backupStore, c.backupPrefix(), retention, time.Now())
+	if len(pruned) > 0 {
+		backupsPruned.Add(float64(len(pruned)))
+		log.Printf("Pruned %d raft snapshots from %s: %s", len(pruned), c.backupStore, strings.Join(pruned, ", "))
+	}
+
+	return len(pruned), err
+}
+
+// recordSnapshot adds the snapshot a successful backup uploaded to the catalog of the namespace,
+// after pruning, so the catalog drops the snapshots pruned too
+func (c *Controller) recordSnapshot(ctx context.Context, key string, result status.Backup) error {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.BackupTimeout)
+	defer cancel()
+
+	return backup.RecordSnapshot(ctx, c.backupStore, c.backupPrefix(), backup.Entry{
+		Key:          key,
+		TakenAt:      result.TakenAt,
+		Size:         result.Size,
+		Duration:     result.Duration,
+		Pod:          result.Pod,
+		VaultVersion: result.VaultVersion,
+		Encrypted:    result.Encrypted,
+		SHA256:       result.SHA256,
+	})
+}
+
+// BackupCatalog returns the raft snapshots of the namespace in the backup store, newest first,
+// with what the catalog recorded of them. The catalog is read at startup and after every backup
+// and kept in memory, so snapshots added or deleted by hand show after the next backup. Should
+// no read have succeeded yet, it is read again at most every catalogRetryDelay.
+func (c *Controller) BackupCatalog(ctx context.Context) ([]backup.Entry, error) {
+	if c.backupStore == nil {
+		return nil, fmt.Errorf("no backup store is configured, set BACKUP_S3_BUCKET")
+	}
+
+	c.catalogMu.Lock()
+	defer c.catalogMu.Unlock()
+
+	if c.catalogReadAt.IsZero() || c.catalogErr != nil && time.Since(c.catalogReadAt) >= catalogRetryDelay {
+		c.readCatalog(ctx)
+	}
+	if c.catalog == nil && c.catalogErr != nil {
+		return nil, c.catalogErr
+	}
+
+	return slices.Clone(c.catalog), nil
+}
+
+// refreshCatalog reads the backup catalog BackupCatalog serves, keeping the one read before
+// when reading it fails
+func (c *Controller) refreshCatalog(ctx context.Context) {
+	c.catalogMu.Lock()
+	defer c.catalogMu.Unlock()
+
+	c.readCatalog(ctx)
+}
+
+// readCatalog reads the backup catalog, with catalogMu held
+func (c *Controller) readCatalog(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.BackupTimeout)
+	defer cancel()
+
+	entries, err := backup.ReadCatalog(ctx, c.backupStore, c.backupPrefix())
+	c.catalogReadAt = time.Now()
+	c.catalogErr = err
+	if err != nil {
+		log.Printf("Error reading the backup catalog: %v", err)
+
+		return
+	}
+	if entries == nil {
+		entries = []backup.Entry{}
+	}
+	c.catalog = entries
+}
+
+// backupPrefix is the prefix of the snapshots of the namespace, BACKUP_S3_PREFIX/<namespace>/
+func (c *Controller) backupPrefix() string {
+	return path.Join(c.cfg.BackupS3Prefix, c.cfg.VaultNamespace) + "/"
+}
@@ -0,0 +1,358 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/backup"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// recordingStore is a backup store keeping uploads in memory, modified at the times in modified
+// or else now. It counts the listings, which fail with listErr when it is set.
+type recordingStore struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	modified map[string]time.Time
+	err      error
+	listErr  error
+	lists    int
+}
+
+func (s *recordingStore) Put(_ context.Context, key string, body io.Reader) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return 0, s.err
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return int64(len(data)), err
+	}
+	if s.objects == nil {
+		s.objects = make(map[string][]byte)
+	}
+	s.objects[key] = data
+
+	return int64(len(data)), nil
+}
+
+func (s *recordingStore) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("no object %s", key)
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *recordingStore) List(_ context.Context, prefix string) ([]backup.Object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lists++
+	if s.listErr != nil {
+		return nil, s.listErr
+	}
+	var objects []backup.Object
+	for key, data := range s.objects {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		modified, ok := s.modified[key]
+		if !ok {
+			modified = time.Now()
+		}
+		objects = append(objects, backup.Object{Key: key, Size: int64(len(data)), LastModified: modified})
+	}
+
+	return objects, nil
+}
+
+func (s *recordingStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.objects, key)
+
+	return nil
+}
+
+func (s *recordingStore) String() string {
+	return "memory://backups"
+}
+
+// uploads returns how many snapshots the store holds, leaving out the catalog
+func (s *recordingStore) uploads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	uploads := 0
+	for key := range s.objects {
+		if path.Base(key) != backup.CatalogName {
+			uploads++
+		}
+	}
+
+	return uploads
+}
+
+// newBackupController returns a controller of an unsealed raft cluster of one pod, with backups
+// uploaded to store
+func newBackupController(t *testing.T, store *recordingStore) (*Controller, string) {
+	t.Helper()
+
+	cluster := vaulttest.NewRaftCluster(1)
+	t.Cleanup(cluster[0].Close)
+	resp, err := vault.NewClient(cluster[0].URL).Initialize(context.Background())
+	assert.NoError(t, err)
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, os.WriteFile(tokenFile, []byte(resp.RootToken+"\n"), 0o600))
+
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, resp.Keys)
+	cfg := testConfig()
+	cfg.BackupInterval = time.Hour
+	cfg.BackupTimeout = time.Minute
+	cfg.BackupTokenFile = tokenFile
+	cfg.BackupS3Prefix = "snapshots/"
+	c := newTestController(t, clientset, cfg, cluster)
+	c.SetBackupStore(store)
+	c.Reconcile(context.Background())
+
+	return c, tokenFile
+}
+
+func TestBackupRaft(t *testing.T) {
+	store := &recordingStore{}
+	c, tokenFile := newBackupController(t, store)
+	successes := backupsTotal.Value("success")
+
+	result := c.backupRaft(context.Background())
+	assert.True(t, result.OK, result.Error)
+	assert.Equal(t, "10.0.0.1", result.Pod)
+	assert.Equal(t, int64(len(vaulttest.Snapshot)), result.Size)
+	key := strings.TrimPrefix(result.Location, "memory://backups/")
+	assert.Regexp(t, `^snapshots/vault/\d{8}T\d{6}Z\.snap$`, key)
+	assert.Equal(t, vaulttest.Snapshot, store.objects[key])
+	assert.Equal(t, 1, store.uploads())
+	assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256(vaulttest.Snapshot)), result.SHA256)
+	assert.Equal(t, successes+1, backupsTotal.Value("success"))
+	assert.Equal(t, float64(len(vaulttest.Snapshot)), backupSize.Value())
+	snapshot := c.status.Snapshot()
+	if assert.NotNil(t, snapshot.Backup) {
+		assert.Equal(t, result.TakenAt, snapshot.Backup.LastSuccessAt)
+	}
+
+	// The snapshot is recorded in the catalog
+	entries, err := c.BackupCatalog(context.Background())
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, key, entries[0].Key)
+		assert.Equal(t, result.SHA256, entries[0].SHA256)
+		assert.Equal(t, result.Size, entries[0].Size)
+		assert.Equal(t, "10.0.0.1", entries[0].Pod)
+		assert.NotEmpty(t, entries[0].VaultVersion)
+		assert.Equal(t, result.VaultVersion, entries[0].VaultVersion)
+	}
+
+	// A failed backup is reported, keeping when the last one succeeded
+	rootToken, err := os.ReadFile(tokenFile)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(tokenFile, []byte("stale-token"), 0o600))
+	failed := c.backupRaft(context.Background())
+	assert.False(t, failed.OK)
+	assert.Contains(t, failed.Error, "403")
+	assert.Equal(t, result.TakenAt, failed.LastSuccessAt)
+	assert.Equal(t, 1, store.uploads(), "a failed snapshot fails its upload")
+	snapshot = c.status.Snapshot()
+	if assert.Len(t, snapshot.Warnings, 1) {
+		assert.Contains(t, snapshot.Warnings[0], "raft snapshot backup failed")
+	}
+
+	// An upload failure is reported too
+	assert.NoError(t, os.WriteFile(tokenFile, rootToken, 0o600))
+	store.err = fmt.Errorf("bucket unavailable")
+	assert.Equal(t, "bucket unavailable", c.backupRaft(context.Background()).Error)
+	assert.Equal(t, 1, store.uploads())
+}
+
+func TestRunBackups(t *testing.T) {
+	store := &recordingStore{}
+	c, _ := newBackupController(t, store)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.runBackups(ctx)
+		close(done)
+	}()
+
+	// The first backup is taken as soon as the cluster is unsealed
+	assert.Eventually(t, func() bool { return store.uploads() == 1 }, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+	assert.Equal(t, 1, store.uploads(), "the next backup waits for BACKUP_INTERVAL")
+}
+
+// testKeys hands out the same data key, wrapped as it is
+type testKeys struct{}
+
+func (testKeys) GenerateDataKey(context.Context) ([]byte, []byte, error) {
+	key := bytes.Repeat([]byte{42}, 32)
+
+	return key, key, nil
+}
+
+func (testKeys) Unwrap(_ context.Context, wrapped []byte) ([]byte, error) {
+	return wrapped, nil
+}
+
+func (testKeys) String() string {
+	return "test keys"
+}
+
+func TestBackupRaftEncryptedAndPruned(t *testing.T) {
+	day := 24 * time.Hour
+	store := &recordingStore{
+		objects: map[string][]byte{
+			"snapshots/vault/20240101T000000Z.snap":   []byte("oldest"),
+			"snapshots/vault/20240102T000000Z.snap":   []byte("older"),
+			"snapshots/vault/20240103T000000Z.snap":   []byte("latest"),
+			"snapshots/staging/20240101T000000Z.snap": []byte("another namespace"),
+		},
+		modified: map[string]time.Time{
+			"snapshots/vault/20240101T000000Z.snap":   time.Now().Add(-3 * day),
+			"snapshots/vault/20240102T000000Z.snap":   time.Now().Add(-2 * day),
+			"snapshots/vault/20240103T000000Z.snap":   time.Now().Add(-day),
+			"snapshots/staging/20240101T000000Z.snap": time.Now().Add(-3 * day),
+		},
+	}
+	c, _ := newBackupController(t, store)
+	c.cfg.BackupRetainCount = 2
+	c.SetBackupEncryption(testKeys{})
+	pruned := backupsPruned.Value()
+
+	result := c.backupRaft(context.Background())
+	assert.True(t, result.OK, result.Error)
+	assert.True(t, result.Encrypted)
+	assert.Regexp(t, `^memory://backups/snapshots/vault/\d{8}T\d{6}Z\.snap\.enc$`, result.Location)
+	assert.Equal(t, 2, result.Pruned)
+	assert.Equal(t, pruned+2, backupsPruned.Value())
+
+	key := strings.TrimPrefix(result.Location, "memory://backups/")
+	uploaded := store.objects[key]
+	assert.Equal(t, int64(len(uploaded)), result.Size)
+	assert.NotContains(t, string(uploaded), string(vaulttest.Snapshot))
+	assert.Equal(t, float64(result.Size), snapshotTransferred.Value("backup"))
+	snapshot, err := backup.OpenSnapshot(context.Background(), store, testKeys{}, key)
+	if !assert.NoError(t, err) {
+		return
+	}
+	decrypted, err := io.ReadAll(snapshot)
+	snapshot.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, vaulttest.Snapshot, decrypted)
+
+	// The snapshot just taken and the newest before it are kept, other namespaces left alone
+	var keys []string
+	for key := range store.objects {
+		keys = append(keys, key)
+	}
+	assert.ElementsMatch(t, []string{key, "snapshots/vault/20240103T000000Z.snap", "snapshots/vault/catalog.json", "snapshots/staging/20240101T000000Z.snap"}, keys)
+
+	// The catalog drops the pruned snapshots, and lists the one taken before it existed with
+	// what the store knows
+	entries, err := c.BackupCatalog(context.Background())
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, key, entries[0].Key)
+		assert.True(t, entries[0].Encrypted)
+		assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256(uploaded)), entries[0].SHA256)
+		assert.Equal(t, backup.Entry{Key: "snapshots/vault/20240103T000000Z.snap", TakenAt: store.modified["snapshots/vault/20240103T000000Z.snap"].UTC(), Size: 6}, entries[1])
+	}
+}
+
+func TestBackupCatalogServedFromMemory(t *testing.T) {
+	store := &recordingStore{}
+	c, _ := newBackupController(t, store)
+
+	result := c.backupRaft(context.Background())
+	if !assert.True(t, result.OK, result.Error) {
+		return
+	}
+
+	// The catalog read after the backup answers every request without listing the bucket
+	store.mu.Lock()
+	lists := store.lists
+	store.mu.Unlock()
+	for i := 0; i < 3; i++ {
+		entries, err := c.BackupCatalog(context.Background())
+		assert.NoError(t, err)
+		assert.Len(t, entries, 1)
+	}
+	store.mu.Lock()
+	assert.Equal(t, lists, store.lists)
+	store.mu.Unlock()
+
+	// A snapshot added by hand shows once the next backup reads the catalog again
+	_, err := store.Put(context.Background(), "snapshots/vault/20240101T000000Z.snap", bytes.NewReader([]byte("manual")))
+	assert.NoError(t, err)
+	entries, err := c.BackupCatalog(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	c.refreshCatalog(context.Background())
+	entries, err = c.BackupCatalog(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	// A failed read keeps the catalog read before
+	store.mu.Lock()
+	store.listErr = fmt.Errorf("bucket unavailable")
+	store.mu.Unlock()
+	c.refreshCatalog(context.Background())
+	entries, err = c.BackupCatalog(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestBackupCatalogReadFailure(t *testing.T) {
+	store := &recordingStore{listErr: fmt.Errorf("bucket unavailable")}
+	c, _ := newBackupController(t, store)
+
+	// Without a catalog read yet, requests read it, but not again before catalogRetryDelay
+	for i := 0; i < 3; i++ {
+		_, err := c.BackupCatalog(context.Background())
+		assert.ErrorContains(t, err, "bucket unavailable")
+	}
+	store.mu.Lock()
+	assert.Equal(t, 1, store.lists)
+	store.listErr = nil
+	store.mu.Unlock()
+
+	c.catalogMu.Lock()
+	c.catalogReadAt = c.catalogReadAt.Add(-catalogRetryDelay)
+	c.catalogMu.Unlock()
+	entries, err := c.BackupCatalog(context.Background())
+	assert.NoError(t, err)
+	assert.NotNil(t, entries)
+	assert.Empty(t, entries)
+}
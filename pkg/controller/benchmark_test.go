@@ -0,0 +1,187 @@
+package controller
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var (
+	loadSimPods     = flag.Int("loadsim.pods", 0, "number of fake Vault endpoints for TestLoadSimulation (0 disables it)")
+	loadSimDuration = flag.Duration("loadsim.duration", 30*time.Second, "how long TestLoadSimulation runs")
+	loadSimReseal   = flag.Float64("loadsim.reseal", 0.1, "fraction of pods resealed before every pass of TestLoadSimulation")
+)
+
+// fleet is a controller wired to a simulated fleet of Vault pods
+type fleet struct {
+	controller *Controller
+	clientset  *fake.Clientset
+	fakes      []*vaulttest.Server
+}
+
+func newFleet(t testing.TB, pods int) *fleet {
+	t.Helper()
+
+	fakes := vaulttest.NewCluster(pods, 5, 3)
+	t.Cleanup(func() {
+		for _, fakeVault := range fakes {
+			fakeVault.Close()
+		}
+	})
+
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, fakes[0].Keys())
+
+	return &fleet{
+		controller: newTestController(t, clientset, testConfig(), fakes),
+		clientset:  clientset,
+		fakes:      fakes,
+	}
+}
+
+func (f *fleet) kubernetesCalls() int {
+	return len(f.clientset.Actions())
+}
+
+func (f *fleet) vaultCalls() int {
+	total := 0
+	for _, fakeVault := range f.fakes {
+		total += fakeVault.Requests()
+	}
+
+	return total
+}
+
+func (f *fleet) sealed() int {
+	sealed := 0
+	for _, fakeVault := range f.fakes {
+		if fakeVault.Sealed() {
+			sealed++
+		}
+	}
+
+	return sealed
+}
+
+// silenceLogs discards the controller's per-pod log lines for the duration of a test
+func silenceLogs(t testing.TB) {
+	t.Helper()
+
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+	})
+}
+
+// BenchmarkReconcile measures a reconcile pass over fleets of 10, 100 and 500 pods, both in
+// steady state and when every pod has to be unsealed. Besides time and allocations it reports
+// the number of Kubernetes and Vault API calls per pass.
+func BenchmarkReconcile(b *testing.B) {
+	silenceLogs(b)
+
+	for _, pods := range []int{10, 100, 500} {
+		for _, sealed := range []bool{false, true} {
+			name := fmt.Sprintf("pods=%d/unsealed", pods)
+			if sealed {
+				name = fmt.Sprintf("pods=%d/sealed", pods)
+			}
+
+			b.Run(name, func(b *testing.B) {
+				f := newFleet(b, pods)
+				f.controller.Reconcile(context.Background())
+
+				kubernetesCalls := f.kubernetesCalls()
+				vaultCalls := f.vaultCalls()
+
+				b.ReportAllocs()
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					if sealed {
+						b.StopTimer()
+						for _, fakeVault := range f.fakes {
+							fakeVault.Seal()
+						}
+						b.StartTimer()
+					}
+
+					f.controller.Reconcile(context.Background())
+				}
+
+				b.StopTimer()
+				b.ReportMetric(float64(f.kubernetesCalls()-kubernetesCalls)/float64(b.N), "k8s-calls/op")
+				b.ReportMetric(float64(f.vaultCalls()-vaultCalls)/float64(b.N), "vault-calls/op")
+			})
+		}
+	}
+}
+
+// TestLoadSimulation runs the controller in a loop against a simulated fleet, resealing a
+// random fraction of the pods before every pass. It is skipped unless -loadsim.pods is set:
+//
+//	go test ./pkg/controller -run TestLoadSimulation -loadsim.pods=500 -loadsim.duration=1m -v
+func TestLoadSimulation(t *testing.T) {
+	if *loadSimPods <= 0 {
+		t.Skip("load simulation disabled, set -loadsim.pods to enable it")
+	}
+
+	silenceLogs(t)
+
+	f := newFleet(t, *loadSimPods)
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	var (
+		passes   int
+		resealed int
+		slowest  time.Duration
+		total    time.Duration
+		before   runtime.MemStats
+		after    runtime.MemStats
+	)
+
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	deadline := time.Now().Add(*loadSimDuration)
+	for time.Now().Before(deadline) {
+		for _, fakeVault := range f.fakes {
+			if random.Float64() < *loadSimReseal {
+				fakeVault.Seal()
+				resealed++
+			}
+		}
+
+		start := time.Now()
+		f.controller.Reconcile(context.Background())
+		elapsed := time.Since(start)
+
+		passes++
+		total += elapsed
+		if elapsed > slowest {
+			slowest = elapsed
+		}
+	}
+
+	runtime.ReadMemStats(&after)
+
+	if passes == 0 {
+		t.Fatal("no reconcile pass completed within the simulation duration")
+	}
+
+	t.Logf("pods=%d passes=%d resealed=%d still-sealed=%d", *loadSimPods, passes, resealed, f.sealed())
+	t.Logf("reconcile: mean=%v max=%v", total/time.Duration(passes), slowest)
+	t.Logf("api calls per pass: k8s=%.1f vault=%.1f",
+		float64(f.kubernetesCalls())/float64(passes), float64(f.vaultCalls())/float64(passes))
+	t.Logf("memory: allocated=%dKiB per pass, heap in use=%dKiB",
+		(after.TotalAlloc-before.TotalAlloc)/uint64(passes)/1024, after.HeapInuse/1024)
+}
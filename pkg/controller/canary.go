@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/metrics"
+	"github.com/getgrowly/vault-utils/pkg/status"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+)
+
+// canaryWarnings is the source of the /status warnings about the canary secret
+const canaryWarnings = "canary"
+
+var canaryOK = metrics.NewGauge("vault_utils_canary_ok",
+	"1 when the canary secret was read in the latest pass, 0 when it could not be.")
+
+// checkCanary reads the canary secret from the active node with its scoped token, when
+// CANARY_PATH is set. An unsealed Vault whose storage backend stopped serving data still reports
+// itself unsealed, so this is the check that it is actually usable. The token file is read every
+// time, so a token renewed on disk is picked up.
+func (c *Controller) checkCanary(ctx context.Context) {
+	if c.cfg.CanaryPath == "" {
+		return
+	}
+
+	canary := status.Canary{Path: c.cfg.CanaryPath, Pod: c.status.Snapshot().Active}
+	start := time.Now()
+	err := c.readCanary(ctx, canary.Pod)
+	canary.Duration = time.Since(start).Round(time.Microsecond).String()
+	canary.CheckedAt = time.Now().UTC()
+	canary.OK = err == nil
+	if err != nil {
+		canary.Error = err.Error()
+	}
+	c.status.SetCanary(canary)
+
+	if canary.OK {
+		canaryOK.Set(1)
+		if c.canaryFailure != "" {
+			log.Printf("Canary secret %s can be read again", c.cfg.CanaryPath)
+			c.status.SetWarnings(canaryWarnings, nil)
+		}
+		c.canaryFailure = ""
+
+		return
+	}
+
+	canaryOK.Set(0)
+	if canary.Error != c.canaryFailure {
+		log.Printf("Warning: could not read the canary secret %s: %v", c.cfg.CanaryPath, err)
+	}
+	c.canaryFailure = canary.Error
+	c.status.SetWarnings(canaryWarnings, []string{fmt.Sprintf("the canary secret %s could not be read: %v", c.cfg.CanaryPath, err)})
+}
+
+// readCanary reads the canary secret from the active node and checks it holds data
+func (c *Controller) readCanary(ctx context.Context, active string) error {
+	if active == "" {
+		return fmt.Errorf("no active node to read it from")
+	}
+
+	token, err := os.ReadFile(c.cfg.CanaryTokenFile)
+	if err != nil {
+		return fmt.Errorf("failed to read the canary token: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.requestTimeout())
+	defer cancel()
+
+	var data map[string]interface{}
+	err = c.active.Do(ctx, func(client *vault.Client) error {
+		var err error
+		data, err = client.ReadSecret(ctx, strings.TrimSpace(string(token)), c.cfg.CanaryPath)
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return fmt.Errorf("vault answered with no data")
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package controller
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCanary(t *testing.T) {
+	fakeVault := vaulttest.NewInitializedServer(3, 2)
+	defer fakeVault.Close()
+	fakeVault.SetSecret("secret/data/canary", "canary-token", map[string]interface{}{"data": map[string]interface{}{"ok": "yes"}})
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, os.WriteFile(tokenFile, []byte("canary-token\n"), 0o600))
+
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, fakeVault.Keys())
+	cfg := testConfig()
+	cfg.CanaryPath = "secret/data/canary"
+	cfg.CanaryTokenFile = tokenFile
+	c := newTestController(t, clientset, cfg, []*vaulttest.Server{fakeVault})
+
+	c.Reconcile(context.Background())
+	snapshot := c.status.Snapshot()
+	if assert.NotNil(t, snapshot.Canary) {
+		assert.True(t, snapshot.Canary.OK, snapshot.Canary.Error)
+		assert.Equal(t, "10.0.0.1", snapshot.Canary.Pod)
+		assert.NotEmpty(t, snapshot.Canary.Duration)
+	}
+	assert.Empty(t, snapshot.Warnings)
+
+	// A token the canary is not readable with is reported, and a renewed token picked up
+	assert.NoError(t, os.WriteFile(tokenFile, []byte("stale-token"), 0o600))
+	c.Reconcile(context.Background())
+	snapshot = c.status.Snapshot()
+	assert.False(t, snapshot.Canary.OK)
+	assert.Contains(t, snapshot.Canary.Error, "403")
+	assert.Len(t, snapshot.Warnings, 1)
+
+	assert.NoError(t, os.WriteFile(tokenFile, []byte("canary-token"), 0o600))
+	c.Reconcile(context.Background())
+	snapshot = c.status.Snapshot()
+	assert.True(t, snapshot.Canary.OK)
+	assert.Empty(t, snapshot.Warnings)
+}
+
+func TestCanaryNotConfigured(t *testing.T) {
+	fakeVault := vaulttest.NewInitializedServer(3, 2)
+	defer fakeVault.Close()
+
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, fakeVault.Keys())
+	c := newTestController(t, clientset, testConfig(), []*vaulttest.Server{fakeVault})
+
+	c.Reconcile(context.Background())
+	assert.Nil(t, c.status.Snapshot().Canary)
+}
@@ -0,0 +1,84 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/metrics"
+	"github.com/getgrowly/vault-utils/pkg/status"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+)
+
+// clockSkewWarnings is the source of the /status warnings about clock skew
+const clockSkewWarnings = "clock-skew"
+
+var clockSkew = metrics.NewGauge("vault_utils_vault_clock_skew_seconds",
+	"How far the clock of each Vault pod was ahead of the controller's in the latest reconcile pass, negative when behind, by pod IP.", "pod", "seal_type", "vault_version")
+
+// checkClockSkew compares the clock of every reachable pod with the controller's and warns about
+// pods off by more than ClockSkewThreshold. Skew breaks the TTL logic of tokens and leases, and
+// makes timestamps such as those of backups disagree.
+func (c *Controller) checkClockSkew(ctx context.Context, statuses map[string]*vault.Status) {
+	if c.cfg.ClockSkewThreshold <= 0 {
+		return
+	}
+
+	pods := make([]string, 0, len(statuses))
+	for pod := range statuses {
+		pods = append(pods, pod)
+	}
+	sort.Strings(pods)
+
+	measured := make([]string, 0, len(pods))
+	var warnings, skewed []string
+	for _, pod := range pods {
+		if ctx.Err() != nil {
+			return
+		}
+
+		skewCtx, cancel := context.WithTimeout(ctx, c.requestTimeout())
+		skew, err := c.vaultClient(pod).ClockSkew(skewCtx)
+		cancel()
+		if err != nil {
+			log.Printf("Error checking the clock of Vault pod %s: %v", pod, err)
+
+			continue
+		}
+
+		measured = append(measured, pod)
+		clockSkew.Replace("pod", skew.Seconds(), c.podLabels(pod, statuses[pod])...)
+		c.status.Update(pod, func(p *status.Pod) {
+			p.ClockSkewSeconds = skew.Round(time.Millisecond).Seconds()
+		})
+
+		if skew > c.cfg.ClockSkewThreshold || skew < -c.cfg.ClockSkewThreshold {
+			direction, offset := "ahead of", skew
+			if skew < 0 {
+				direction, offset = "behind", -skew
+			}
+			warnings = append(warnings, fmt.Sprintf("clock of pod %s is %s %s the controller's, more than the %s allowed",
+				pod, offset.Round(time.Second), direction, c.cfg.ClockSkewThreshold))
+			skewed = append(skewed, pod)
+		}
+	}
+	clockSkew.Retain("pod", measured)
+
+	c.status.SetWarnings(clockSkewWarnings, warnings)
+
+	summary := strings.Join(skewed, ", ")
+	if summary == c.skewedPods {
+		return
+	}
+	c.skewedPods = summary
+
+	if summary == "" {
+		log.Printf("Clocks of the Vault pods agree with the controller's again")
+
+		return
+	}
+	log.Printf("Warning: %s", strings.Join(warnings, "; "))
+}
@@ -0,0 +1,49 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReconcileReportsClockSkew(t *testing.T) {
+	fakes := vaulttest.NewCluster(2, 1, 1)
+	for _, fakeVault := range fakes {
+		defer fakeVault.Close()
+	}
+	fakes[1].SetClockSkew(-2 * time.Minute)
+
+	cfg := testConfig()
+	cfg.ClockSkewThreshold = 5 * time.Second
+	c := newTestController(t, fake.NewSimpleClientset(), cfg, fakes)
+
+	c.Reconcile(context.Background())
+
+	snapshot := c.status.Snapshot()
+	if len(snapshot.Warnings) != 1 || !strings.Contains(snapshot.Warnings[0], "pod 10.0.0.2 is 2m0s behind") {
+		t.Fatalf("expected a warning about the skewed pod, got %v", snapshot.Warnings)
+	}
+	if skew := clockSkew.Value("10.0.0.2", snapshot.Pods[1].SealType, snapshot.Pods[1].Version); skew > -119 || skew < -121 {
+		t.Errorf("expected a skew of about -120s to be exported, got %v", skew)
+	}
+	if skew := snapshot.Pods[1].ClockSkewSeconds; skew > -119 || skew < -121 {
+		t.Errorf("expected a skew of about -120s in /status, got %v", skew)
+	}
+	if c.skewedPods != "10.0.0.2" {
+		t.Errorf("expected the skewed pod to be remembered, got %q", c.skewedPods)
+	}
+
+	fakes[1].SetClockSkew(0)
+	c.Reconcile(context.Background())
+
+	if warnings := c.status.Snapshot().Warnings; len(warnings) != 0 {
+		t.Errorf("expected the warning to clear once the clocks agree, got %v", warnings)
+	}
+	if c.skewedPods != "" {
+		t.Errorf("expected no skewed pods, got %q", c.skewedPods)
+	}
+}
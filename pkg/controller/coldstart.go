@@ -0,0 +1,218 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/notify"
+	"github.com/getgrowly/vault-utils/pkg/reason"
+	"github.com/getgrowly/vault-utils/pkg/status"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+)
+
+// Steps of a cold start, in order
+const (
+	coldStartQuorum = "quorum"
+	coldStartUnseal = "unseal"
+	coldStartLeader = "leader"
+	coldStartChecks = "checks"
+)
+
+// coldStart is the progress of bringing up a cluster found with every pod sealed, such as after
+// a datacenter lost power. Unlike a steady-state pass, which unseals whatever answers, it waits
+// for a quorum of pods, unseals them in ordinal order, and only hands the cluster back once a
+// leader was elected and the members agree on the cluster they form.
+type coldStart struct {
+	step      string
+	detail    string
+	startedAt time.Time
+	stepSince time.Time
+}
+
+// coldStartHolds starts a cold start when COLD_START is set and every checked pod of an
+// initialized cluster is sealed, and reports whether the pods are to be left sealed for now
+// because a quorum of them does not answer yet
+func (c *Controller) coldStartHolds(pods []string, statuses map[string]*vault.Status, phase status.Phase) bool {
+	if !c.cfg.ColdStart {
+		return false
+	}
+
+	if c.coldStart == nil {
+		if phase != status.PhaseUnsealing || unsealedCount(statuses) > 0 {
+			return false
+		}
+
+		now := time.Now().UTC()
+		c.coldStart = &coldStart{startedAt: now}
+		log.Printf("Cold start: every checked pod of the Vault cluster in namespace %s is sealed, bringing it up step by step", c.cfg.VaultNamespace)
+		c.notify(notify.EventColdStart, reason.ColdStartStarted, fmt.Sprintf("every checked pod of the Vault cluster in namespace %s is sealed, cold start begins", c.cfg.VaultNamespace))
+		c.setColdStartStep(coldStartQuorum, "")
+	}
+	if c.coldStart.step != coldStartQuorum {
+		return false
+	}
+
+	quorum := len(pods)/2 + 1
+	if len(statuses) >= quorum {
+		c.setColdStartStep(coldStartUnseal, fmt.Sprintf("%d of %d pods answer", len(statuses), len(pods)))
+
+		return false
+	}
+	if time.Since(c.coldStart.startedAt) >= c.cfg.ColdStartTimeout {
+		log.Printf("Warning: cold start: only %d of %d pods answer after %v, unsealing them without a quorum", len(statuses), len(pods), c.cfg.ColdStartTimeout)
+		c.setColdStartStep(coldStartUnseal, fmt.Sprintf("no quorum, %d of %d pods answer", len(statuses), len(pods)))
+
+		return false
+	}
+
+	c.setColdStartStep(coldStartQuorum, fmt.Sprintf("%d of %d pods answer, %d needed", len(statuses), len(pods), quorum))
+	for _, pod := range pods {
+		if _, ok := statuses[pod]; ok {
+			c.recordReason(pod, reason.ColdStartWaiting)
+		}
+	}
+
+	return true
+}
+
+// coldStartOrder returns the order pods are unsealed in: the order they are listed in, except
+// during a cold start, which unseals them in ordinal order so the first pod of a StatefulSet,
+// usually the last leader, comes up first
+func (c *Controller) coldStartOrder(pods []string) []string {
+	if c.coldStart == nil {
+		return pods
+	}
+
+	ordered := append([]string(nil), pods...)
+	sort.Slice(ordered, func(i, j int) bool {
+		if len(ordered[i]) != len(ordered[j]) {
+			return len(ordered[i]) < len(ordered[j])
+		}
+
+		return ordered[i] < ordered[j]
+	})
+
+	return ordered
+}
+
+// advanceColdStart moves the cold start in progress past the steps the pass completed: every
+// checked pod unsealed, a leader elected, then the post-start checks. It gives up once
+// COLD_START_TIMEOUT ran out, leaving the cluster to the steady-state passes.
+func (c *Controller) advanceColdStart(statuses map[string]*vault.Status, unreachable int) {
+	if c.coldStart == nil || c.coldStart.step == coldStartQuorum {
+		return
+	}
+
+	if c.coldStart.step == coldStartUnseal {
+		if sealed := len(statuses) - unsealedCount(statuses); sealed > 0 {
+			c.setColdStartStep(coldStartUnseal, fmt.Sprintf("%d of %d checked pods still sealed", sealed, len(statuses)))
+		} else {
+			c.setColdStartStep(coldStartLeader, "")
+		}
+	}
+
+	if c.coldStart.step == coldStartLeader {
+		if active := c.status.Snapshot().Active; active == "" {
+			c.setColdStartStep(coldStartLeader, "no active node found yet")
+		} else {
+			c.setColdStartStep(coldStartChecks, "active node is "+active)
+		}
+	}
+
+	if c.coldStart.step == coldStartChecks {
+		failures := coldStartChecksFailed(statuses, unreachable)
+		if len(failures) == 0 {
+			c.endColdStart(reason.ColdStartCompleted, fmt.Sprintf("cold start of the Vault cluster in namespace %s completed in %v",
+				c.cfg.VaultNamespace, time.Since(c.coldStart.startedAt).Round(time.Second)))
+
+			return
+		}
+		c.setColdStartStep(coldStartChecks, strings.Join(failures, "; "))
+	}
+
+	if time.Since(c.coldStart.startedAt) >= c.cfg.ColdStartTimeout {
+		c.endColdStart(reason.ColdStartIncomplete, fmt.Sprintf("cold start of the Vault cluster in namespace %s did not complete within %v, stopped at step %s: %s",
+			c.cfg.VaultNamespace, c.cfg.ColdStartTimeout, c.coldStart.step, c.coldStart.detail))
+	}
+}
+
+// coldStartChecksFailed returns what keeps a cluster just brought up from being considered
+// started: pods not answering or still sealed, and members reporting different clusters
+func coldStartChecksFailed(statuses map[string]*vault.Status, unreachable int) []string {
+	var failures []string
+	if unreachable > 0 {
+		failures = append(failures, fmt.Sprintf("%d pods do not answer", unreachable))
+	}
+
+	clusters := make(map[string]bool)
+	sealed := 0
+	for _, vaultStatus := range statuses {
+		if vaultStatus.Sealed {
+			sealed++
+
+			continue
+		}
+		if vaultStatus.ClusterID != "" {
+			clusters[vaultStatus.ClusterID] = true
+		}
+	}
+	if sealed > 0 {
+		failures = append(failures, fmt.Sprintf("%d pods are sealed", sealed))
+	}
+	if len(clusters) > 1 {
+		failures = append(failures, fmt.Sprintf("unsealed pods report %d different cluster IDs", len(clusters)))
+	}
+
+	return failures
+}
+
+// setColdStartStep records the step the cold start is at and what it waits for, logging changes
+func (c *Controller) setColdStartStep(step, detail string) {
+	if step != c.coldStart.step || detail != c.coldStart.detail {
+		if detail != "" {
+			log.Printf("Cold start: step %s: %s", step, detail)
+		} else {
+			log.Printf("Cold start: step %s", step)
+		}
+	}
+	if step != c.coldStart.step {
+		c.coldStart.stepSince = time.Now().UTC()
+	}
+	c.coldStart.step, c.coldStart.detail = step, detail
+
+	c.status.SetColdStart(&status.ColdStart{
+		Step:      step,
+		Detail:    detail,
+		StartedAt: c.coldStart.startedAt,
+		StepSince: c.coldStart.stepSince,
+	})
+}
+
+// endColdStart reports the outcome of the cold start and hands the cluster back to the
+// steady-state passes
+func (c *Controller) endColdStart(code reason.Code, message string) {
+	if code == reason.ColdStartCompleted {
+		log.Printf("Cold start: %s", message)
+	} else {
+		log.Printf("Warning: %s", message)
+	}
+	c.notify(notify.EventColdStart, code, message)
+
+	c.coldStart = nil
+	c.status.SetColdStart(nil)
+}
+
+// unsealedCount returns how many of the checked pods are initialized and unsealed
+func unsealedCount(statuses map[string]*vault.Status) int {
+	unsealed := 0
+	for _, vaultStatus := range statuses {
+		if vaultStatus.Initialized && !vaultStatus.Sealed {
+			unsealed++
+		}
+	}
+
+	return unsealed
+}
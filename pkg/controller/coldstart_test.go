@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/notify"
+	"github.com/getgrowly/vault-utils/pkg/reason"
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// coldStartReasons returns the reasons of the cold start notifications sent
+func coldStartReasons(notifier *recordingNotifier) []reason.Code {
+	var reasons []reason.Code
+	for _, event := range notifier.events {
+		if event.Type == notify.EventColdStart {
+			reasons = append(reasons, event.Reason)
+		}
+	}
+
+	return reasons
+}
+
+func TestColdStartCompletes(t *testing.T) {
+	fakes := vaulttest.NewCluster(3, 5, 3)
+	for _, fakeVault := range fakes {
+		defer fakeVault.Close()
+	}
+
+	cfg := testConfig()
+	cfg.ColdStart = true
+	cfg.ColdStartTimeout = time.Minute
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, fakes[0].Keys())
+	c := newTestController(t, clientset, cfg, fakes)
+	notifier := &recordingNotifier{}
+	c.notifier = notifier
+
+	c.Reconcile(context.Background())
+
+	for i, fakeVault := range fakes {
+		assert.False(t, fakeVault.Sealed(), "pod %d", i)
+	}
+	assert.Nil(t, c.coldStart)
+	assert.Nil(t, c.status.Snapshot().ColdStart)
+	assert.Equal(t, []reason.Code{reason.ColdStartStarted, reason.ColdStartCompleted}, coldStartReasons(notifier))
+
+	// A pod sealed again while the others stay unsealed is no cold start
+	fakes[1].Seal()
+	c.Reconcile(context.Background())
+	assert.False(t, fakes[1].Sealed())
+	assert.Len(t, coldStartReasons(notifier), 2)
+}
+
+func TestColdStartWaitsForQuorum(t *testing.T) {
+	fakes := vaulttest.NewCluster(3, 5, 3)
+	defer fakes[0].Close()
+	fakes[1].Close()
+	fakes[2].Close()
+
+	cfg := testConfig()
+	cfg.ColdStart = true
+	cfg.ColdStartTimeout = time.Minute
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, fakes[0].Keys())
+	c := newTestController(t, clientset, cfg, fakes)
+	notifier := &recordingNotifier{}
+	c.notifier = notifier
+
+	// One pod of three answers, so it is left sealed
+	c.Reconcile(context.Background())
+	assert.True(t, fakes[0].Sealed())
+	snapshot := c.status.Snapshot()
+	if assert.NotNil(t, snapshot.ColdStart) {
+		assert.Equal(t, coldStartQuorum, snapshot.ColdStart.Step)
+		assert.Equal(t, "1 of 3 pods answer, 2 needed", snapshot.ColdStart.Detail)
+	}
+	assert.Equal(t, reason.ColdStartWaiting, snapshot.Pods[0].Reason)
+
+	// Once the timeout ran out, the pod is unsealed without a quorum, and the cold start ends
+	// incomplete since the other pods still do not answer
+	c.coldStart.startedAt = c.coldStart.startedAt.Add(-time.Minute)
+	c.Reconcile(context.Background())
+	assert.False(t, fakes[0].Sealed())
+	assert.Nil(t, c.coldStart)
+	assert.Equal(t, []reason.Code{reason.ColdStartStarted, reason.ColdStartIncomplete}, coldStartReasons(notifier))
+}
+
+func TestColdStartOrder(t *testing.T) {
+	c := &Controller{coldStart: &coldStart{}}
+	assert.Equal(t, []string{"vault-0", "vault-2", "vault-10"}, c.coldStartOrder([]string{"vault-10", "vault-2", "vault-0"}))
+
+	c.coldStart = nil
+	assert.Equal(t, []string{"vault-10", "vault-2"}, c.coldStartOrder([]string{"vault-10", "vault-2"}))
+}
@@ -0,0 +1,1646 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/backup"
+	"github.com/getgrowly/vault-utils/pkg/config"
+	"github.com/getgrowly/vault-utils/pkg/hooks"
+	"github.com/getgrowly/vault-utils/pkg/keyprovider"
+	"github.com/getgrowly/vault-utils/pkg/kubernetes"
+	"github.com/getgrowly/vault-utils/pkg/metrics"
+	"github.com/getgrowly/vault-utils/pkg/notify"
+	"github.com/getgrowly/vault-utils/pkg/reason"
+	"github.com/getgrowly/vault-utils/pkg/recovery"
+	"github.com/getgrowly/vault-utils/pkg/render"
+	"github.com/getgrowly/vault-utils/pkg/rollout"
+	"github.com/getgrowly/vault-utils/pkg/state"
+	"github.com/getgrowly/vault-utils/pkg/status"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+	"github.com/getgrowly/vault-utils/pkg/vaultapi"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// diagnoseTimeout bounds the connectivity diagnosis of a single unreachable pod
+	diagnoseTimeout = 15 * time.Second
+	// vaultTimeout bounds each action on a single Vault pod when VAULT_REQUEST_TIMEOUT is not set
+	vaultTimeout = 10 * time.Second
+	// unreachableReason is the reason of the Event recorded on a pod that cannot be reached
+	unreachableReason = "VaultUnreachable"
+	// caBundleLabel marks the ConfigMaps holding Vault's CA bundle
+	caBundleLabel = "vault-utils.growly.io/ca-bundle"
+)
+
+var (
+	checkDuration = metrics.NewHistogram("vault_utils_vault_check_duration_seconds",
+		"Time taken by the Vault seal status check of each pod, by pod IP and result.", metrics.DefaultBuckets, "pod", "seal_type", "vault_version", "result")
+	podSealed = metrics.NewGauge("vault_utils_vault_sealed",
+		"1 for each reachable Vault pod that was sealed or uninitialized at the end of the latest reconcile pass, 0 when unsealed, by pod IP.", "pod", "seal_type", "vault_version")
+	podsTotal = metrics.NewGauge("vault_utils_vault_pods",
+		"Vault pods found in the latest reconcile pass.")
+	podsUnsealed = metrics.NewGauge("vault_utils_vault_pods_unsealed",
+		"Vault pods that were initialized and unsealed at the end of the latest reconcile pass.")
+	unsealedFraction = metrics.NewGauge("vault_utils_unsealed_fraction",
+		"Fraction of Vault pods that were unsealed at the end of the latest reconcile pass, 0 when there are none.")
+	replicasDesired = metrics.NewGauge("vault_utils_vault_replicas",
+		"spec.replicas of the Vault StatefulSet in the latest reconcile pass, 0 when unknown.")
+	membersUnsealedFraction = metrics.NewGauge("vault_utils_members_unsealed_fraction",
+		"Fraction of the expected Vault members that were unsealed at the end of the latest reconcile pass: of the StatefulSet's replicas when known, of the pods found otherwise.")
+)
+
+// Controller initializes and unseals the Vault pods of a namespace
+type Controller struct {
+	k8sClient   *kubernetes.Client
+	cfg         *config.Config
+	coordinator *rollout.Coordinator
+	notifier    notify.Notifier
+	// actionHooks run before and after init and unseal
+	actionHooks hooks.Hooks
+	// keyProvider stores the unseal keys instead of the unseal keys Secret, when configured
+	keyProvider keyprovider.Provider
+	// backupStore keeps the raft snapshots taken every BACKUP_INTERVAL, when configured, and
+	// lastBackup is when the latest one was uploaded
+	backupStore backup.Store
+	lastBackup  time.Time
+	// catalog holds the backup catalog /status/backups serves, so requests to it don't list the
+	// bucket, as of catalogReadAt; catalogErr is why the latest read of it failed. catalogMu
+	// guards them, and is held while the catalog is read.
+	catalogMu     sync.Mutex
+	catalog       []backup.Entry
+	catalogReadAt time.Time
+	catalogErr    error
+	// auditListener receives the audit log of socket audit devices, when configured
+	auditListener net.Listener
+	// backupKeys encrypts snapshots before they are uploaded, and decrypts them to restore one,
+	// when configured
+	backupKeys backup.DataKeys
+	// phase is the phase of the cluster in the reconcile state machine
+	phase status.Phase
+	// initPGPKeys and rootTokenPGPKey are the PGP public keys Vault encrypts the key shares and
+	// the root token to at init, when configured
+	initPGPKeys     []string
+	rootTokenPGPKey string
+	// rootTokenSettled is set once the stored root token was revoked, or found to be one the
+	// controller must not revoke, so REVOKE_ROOT_TOKEN stops looking at it
+	rootTokenSettled bool
+	// autopilotSettled is set once the autopilot configuration of RAFT_AUTOPILOT was applied, or
+	// found not to apply to the cluster
+	autopilotSettled bool
+	// renderer renders the Secrets and ConfigMaps consumed by workloads, when configured
+	renderer *render.Renderer
+	// rendered holds a hash of each rendered object as last applied, so unchanged objects are
+	// not rewritten on every pass
+	rendered map[string]string
+	// vaultCA is the CA bundle of Vault's listener as last loaded from VAULT_CACERT or
+	// VAULT_CACERT_FROM, and vaultCACerts holds its certificates
+	vaultCA      []byte
+	vaultCACerts []*x509.Certificate
+	// vaultTLSLoaded is set once the pod clients verify Vault's listener as configured
+	vaultTLSLoaded bool
+	// vaultCAError is the latest error loading the CA bundle, so it is only logged once
+	vaultCAError string
+	// clientCert is presented to Vault listeners requiring client certificates, when configured
+	clientCert *vault.ClientCertificate
+	// clientCertError is the latest error loading the client certificate, so it is only logged
+	// once
+	clientCertError string
+	// caBundle is the latest CA bundle fetched from Vault's listeners
+	caBundle string
+	// caPublished holds the CA bundle as last written to the CA ConfigMap of each namespace
+	caPublished map[string]string
+	// caError is the latest error fetching or publishing the CA bundle, so a persisting failure
+	// is only logged once
+	caError string
+	// identity names this controller instance in the provenance recorded at init
+	identity string
+	// startedAt is when the controller was created, for the shutdown report
+	startedAt time.Time
+	// steppedDown remembers the draining pods already stepped down so the step-down is only
+	// requested once per drain
+	steppedDown map[string]bool
+	// vaultAddress maps a pod IP to the address of its Vault listener
+	vaultAddress func(podIP string) string
+	// agentPods remembers, per pod probed, whether it is left alone because its Vault port is
+	// served by a Vault Agent or Proxy
+	agentPods map[string]bool
+	// serverPorts holds the server port of pods whose Vault port is served by an agent. It is
+	// guarded by serverPortsMu, since /ready looks up pod addresses too.
+	serverPorts   map[string]string
+	serverPortsMu sync.Mutex
+	// events receives the pods reported through ReportEvent, buffered so a report never waits
+	// for the pass in progress
+	events chan string
+	// vaultClients keeps one client per Vault pod across reconcile passes
+	vaultClients *vault.Pool
+	// external is the client for VaultExternalURL, which stands in for every pod when set
+	external *vault.Client
+	// active tracks the active node, which token-authenticated operations are sent to
+	active *vault.ActiveNode
+	// unsealKeys caches the unseal keys Secret for the duration of a reconcile pass
+	unsealKeys *unsealKeyCache
+	// status holds the latest state of every pod for /status
+	status *status.Store
+	// unsealNonces remembers the nonce of the unseal attempt the controller left in progress on
+	// each pod, so an attempt started by someone else can be told apart
+	unsealNonces map[string]string
+	// lastGC is when the Events of pods that no longer exist were last collected
+	lastGC time.Time
+	// foreignSigns is the latest summary of signs of another unseal automation, so they are
+	// only reported when they change
+	foreignSigns string
+	// seenClusterID and seenClusterName identify the cluster last seen unsealed
+	seenClusterID   string
+	seenClusterName string
+	// skewedPods lists the pods whose clock was last found off by more than the threshold, so
+	// the warning is only logged when it changes
+	skewedPods string
+	// drift holds the warnings about members that disagree as last logged, so they are only
+	// logged when they change
+	drift string
+	// keySecretsSynced is set once the Secrets holding key material have the configured labels,
+	// annotations and format
+	keySecretsSynced bool
+	// keySecretsUpgraded is set once the keys Secrets are in the current format
+	keySecretsUpgraded bool
+	// networkPolicyApplied is set once the generated NetworkPolicy has been applied
+	networkPolicyApplied bool
+	// identityTokenFile is the workload identity token handed to key providers, if any
+	identityTokenFile string
+	// identityWarning is the latest problem with the workload identity token, so it is only
+	// logged when it changes
+	identityWarning string
+	// stateFile keeps the controller's operational memory across restarts, when configured
+	stateFile *state.File
+	// savedState is the state as last written to stateFile, so unchanged state is not rewritten
+	savedState string
+	// keyCache keeps a copy of the unseal keys for when they cannot be read, when configured,
+	// and cachedKeys is what it holds
+	keyCache   *state.File
+	cachedKeys *cachedUnsealKeys
+	// coldStart is the cold start in progress, if any
+	coldStart *coldStart
+	// sealMigrationRequested is set while a seal migration requested through the admin API is
+	// in progress
+	sealMigrationRequested atomic.Bool
+	// canaryFailure is why the canary secret could not be read in the latest pass, so only
+	// changes are logged
+	canaryFailure string
+	// raftProblems are the raft membership problems of the latest pass, so only changes are
+	// logged
+	raftProblems string
+	// deadRaftPeers holds when each raft peer matching no Vault pod was first seen so, for
+	// RAFT_REMOVE_DEAD_PEERS
+	deadRaftPeers map[string]time.Time
+	// activeSealed is the pod that was the active node when it was found sealed, until it is
+	// unsealed or another node takes over
+	activeSealed string
+	// replicasError is the latest error reading the replicas of the Vault StatefulSet, so it is
+	// only logged when it changes
+	replicasError string
+	// unreachable remembers the failed diagnostic stage of each unreachable pod, so an Event is
+	// only recorded when the failure changes rather than on every pass
+	unreachable map[string]string
+}
+
+// unsealKeyCache holds the result of reading the unseal keys Secret, including a failed read,
+// so a pass over many sealed pods only reads the Secret once
+type unsealKeyCache struct {
+	keys []string
+	// threshold is the number of keys needed to unseal, or zero when it is not recorded
+	threshold int
+	// shares is the number of keys generated at init, or zero when it is not recorded
+	shares int
+	// clusterID and clusterName identify the cluster the keys belong to, or are empty until
+	// the cluster has been seen unsealed
+	clusterID   string
+	clusterName string
+	// secret is the Secret the keys were read from, kept so recording the cluster identity
+	// does not need another read
+	secret *corev1.Secret
+	// encrypted is set when the keys are encrypted to operators' PGP keys and cannot unseal
+	encrypted bool
+	err       error
+}
+
+// New creates a new controller that reports events needing operator attention to notifier
+func New(k8sClient *kubernetes.Client, cfg *config.Config, notifier notify.Notifier) *Controller {
+	c := &Controller{
+		k8sClient:    k8sClient,
+		cfg:          cfg,
+		notifier:     notifier,
+		identity:     identity(),
+		startedAt:    time.Now().UTC(),
+		steppedDown:  make(map[string]bool),
+		vaultClients: vault.NewPoolWithOptions(TransportOptions(cfg)),
+		active:       vault.NewActiveNode(),
+		status:       status.NewStore(),
+		unreachable:  make(map[string]string),
+		unsealNonces: make(map[string]string),
+		rendered:     make(map[string]string),
+		caPublished:  make(map[string]string),
+		agentPods:    make(map[string]bool),
+		serverPorts:  make(map[string]string),
+		events:       make(chan string, 1),
+		phase:        status.PhaseDiscovering,
+	}
+
+	c.status.SetNamespace(cfg.VaultNamespace)
+	c.status.SetPhase(c.phase)
+	c.vaultClients.SetRetryPolicy(RetryPolicy(cfg))
+	if cfg.VaultClientBackend == config.ClientBackendOfficial {
+		c.vaultClients.SetBackend(vaultapi.NewBackend)
+	}
+
+	if cfg.VaultClientCertConfigured() {
+		c.clientCert = vault.NewClientCertificate()
+	}
+
+	c.vaultAddress = func(podIP string) string {
+		scheme := "http"
+		if c.cfg.TLS() {
+			scheme = "https"
+		}
+
+		port := c.cfg.VaultPort
+		c.serverPortsMu.Lock()
+		if serverPort, ok := c.serverPorts[podIP]; ok {
+			port = serverPort
+		}
+		c.serverPortsMu.Unlock()
+
+		return fmt.Sprintf("%s://%s:%s", scheme, podIP, port)
+	}
+
+	if cfg.RolloutCoordination && cfg.VaultExternalURL == "" {
+		c.coordinator = rollout.NewCoordinator(k8sClient, cfg.VaultNamespace, cfg.VaultStatefulSet, c.podUnsealedAndJoined)
+	}
+
+	return c
+}
+
+// identity returns the name of this controller instance, which is the pod name when running in
+// Kubernetes
+func identity() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "vault-utils"
+	}
+
+	return "vault-utils/" + hostname
+}
+
+// SetExternalClient makes the controller reach Vault through a single address in front of the
+// cluster instead of through pod IPs. Steps that need to reach a specific pod, stepping down a
+// draining leader and rollout coordination, are skipped.
+func (c *Controller) SetExternalClient(client *vault.Client) {
+	c.external = client
+	c.coordinator = nil
+	c.vaultAddress = func(string) string {
+		return c.cfg.VaultExternalURL
+	}
+}
+
+// SetHooks sets the action hooks run before and after init and unseal
+func (c *Controller) SetHooks(h hooks.Hooks) {
+	c.actionHooks = h
+}
+
+// SetKeyProvider makes the controller store and read the unseal keys through p. The unseal keys
+// Secret is still written at init, without the keys, to record the seal configuration and
+// cluster identity.
+func (c *Controller) SetKeyProvider(p keyprovider.Provider) {
+	c.keyProvider = p
+}
+
+// SetInitPGPKeys has Vault encrypt the key shares it hands out at init to pgpKeys, one per
+// share, and the root token to rootTokenKey when it is not empty. The keys are the
+// base64-encoded PGP public keys, as read by vault.ReadPGPKey.
+func (c *Controller) SetInitPGPKeys(pgpKeys []string, rootTokenKey string) {
+	c.initPGPKeys = pgpKeys
+	c.rootTokenPGPKey = rootTokenKey
+}
+
+// SetRenderer sets the renderer of the Secrets and ConfigMaps consumed by workloads
+func (c *Controller) SetRenderer(r *render.Renderer) {
+	c.renderer = r
+}
+
+// Status returns the store holding the latest state of every Vault pod
+func (c *Controller) Status() *status.Store {
+	return c.status
+}
+
+// Run reconciles every check interval until the context is cancelled. Pod changes trigger an
+// immediate pass so replacement pods are unsealed as soon as they come up instead of waiting
+// for the next check interval.
+func (c *Controller) Run(ctx context.Context) {
+	podChanges := c.k8sClient.WatchVaultPods(ctx, c.cfg.VaultNamespace)
+	if c.cfg.VaultClientCert != "" && c.cfg.TLS() {
+		go c.watchClientCertificate(ctx)
+	}
+
+	c.checkEnvironment()
+	c.warmStart(ctx)
+	if c.backupStore != nil {
+		go c.refreshCatalog(ctx)
+	}
+	if c.backupStore != nil && c.cfg.BackupInterval > 0 {
+		go c.runBackups(ctx)
+	}
+	c.followAuditLogs(ctx)
+
+	for {
+		c.reconcileSafely(ctx)
+
+		select {
+		case <-ctx.Done():
+			c.reportShutdown()
+
+			return
+		case <-time.After(c.checkInterval()):
+		case <-podChanges:
+			log.Printf("Vault pod change detected, reconciling immediately")
+		case <-c.events:
+		}
+	}
+}
+
+// reconcileSafely runs Reconcile and recovers a panic in it, so the next pass starts over with
+// fresh state instead of the process exiting
+func (c *Controller) reconcileSafely(ctx context.Context) {
+	defer func() {
+		recovery.Handle("controller", recover(), c.notifier)
+	}()
+
+	c.Reconcile(ctx)
+}
+
+// Reconcile runs a single pass over all Vault pods, initializing and unsealing them as needed.
+// Cancelling ctx aborts the calls to Vault in flight and ends the pass before the next pod.
+func (c *Controller) Reconcile(ctx context.Context) {
+	c.unsealKeys = nil
+	defer c.saveState()
+
+	c.refreshVaultTLS()
+	c.applyNetworkPolicy()
+
+	if c.cfg.StepDownOnDrain && c.external == nil {
+		c.stepDownDrainingLeader(ctx)
+	}
+
+	if c.coordinator != nil {
+		defer func() {
+			if err := c.coordinator.Step(ctx); err != nil {
+				log.Printf("Error coordinating rollout: %v", err)
+			}
+		}()
+	}
+
+	pods, err := c.vaultPods()
+	if err != nil {
+		log.Printf("Error getting Vault pods: %v", err)
+
+		return
+	}
+
+	addresses := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		addresses = append(addresses, c.vaultAddress(pod))
+	}
+	c.vaultClients.Retain(addresses)
+	c.status.Retain(pods)
+	c.forgetUnreachable(pods)
+	c.forgetAgents(pods)
+	c.forgetUnsealNonces(pods)
+	checkDuration.Retain("pod", pods)
+	c.collectGarbage()
+	c.recordReplicas()
+
+	defer c.exportClusterState()
+
+	if len(pods) == 0 {
+		log.Printf("No Vault pods found")
+		c.status.MarkChecked()
+		c.transition(status.PhaseDiscovering)
+
+		return
+	}
+
+	// Check every pod before acting on any, so init can be refused when another member
+	// already holds cluster data. The active node goes first, since its seal takes Vault down.
+	previousActive := c.status.Snapshot().Active
+	pods = activeFirst(pods, previousActive)
+	statuses := make(map[string]*vault.Status, len(pods))
+	unreachable := 0
+	for _, pod := range pods {
+		if c.detectAgent(ctx, pod) {
+			continue
+		}
+
+		vaultStatus, err := c.checkStatus(ctx, pod)
+		if ctx.Err() != nil {
+			log.Printf("Reconcile pass interrupted: %v", ctx.Err())
+
+			return
+		}
+		if err != nil {
+			log.Printf("Error checking Vault status for pod %s: %v", pod, err)
+			c.reportUnreachable(ctx, pod, err)
+			unreachable++
+
+			continue
+		}
+		statuses[pod] = vaultStatus
+		delete(c.unreachable, pod)
+
+		resealed := false
+		c.status.Update(pod, func(p *status.Pod) {
+			resealed = p.Reachable && p.Initialized && !p.Sealed && vaultStatus.Sealed
+			*p = status.ReachablePod(pod, vaultStatus)
+		})
+		if resealed && pod == previousActive && len(pods) > 1 {
+			c.reportActiveSealed(pod)
+		} else if resealed {
+			log.Printf("Vault pod %s was unsealed and is sealed again", pod)
+			c.notify(notify.EventSealed, reason.Sealed, fmt.Sprintf("pod %s in namespace %s was unsealed and is sealed again", pod, c.cfg.VaultNamespace))
+		}
+	}
+
+	c.status.MarkChecked()
+
+	c.checkWorkloadIdentity()
+	c.checkClockSkew(ctx, statuses)
+	c.checkDrift(statuses)
+	paused := c.detectForeignUnsealer(statuses)
+
+	// The phase decides whether the pass acts on the pods at all, and is observed again once it
+	// did
+	phase := c.transition(observePhase(statuses, unreachable, paused))
+	if phase == status.PhaseMaintenance {
+		for _, pod := range pods {
+			if vaultStatus, ok := statuses[pod]; ok && (!vaultStatus.Initialized || vaultStatus.Sealed) {
+				log.Printf("Not acting on Vault pod %s: paused while another unseal automation appears to be active", pod)
+				c.recordReason(pod, reason.ForeignUnsealer)
+			}
+		}
+	}
+	if actsIn(phase) && !c.coldStartHolds(pods, statuses, phase) {
+		c.actOnPods(ctx, c.coldStartOrder(pods), statuses)
+		if ctx.Err() != nil {
+			return
+		}
+		c.transition(observePhase(statuses, unreachable, paused))
+	}
+	c.finishSealMigration(statuses)
+
+	c.trackActiveNode(ctx)
+	c.settleActiveSealed()
+	c.advanceColdStart(statuses, unreachable)
+	c.checkCanary(ctx)
+	c.checkRaftPeers(ctx, pods, statuses)
+	c.configureAutopilot(ctx, statuses)
+	c.revokeRootToken(ctx)
+	c.upgradeKeySecrets(statuses)
+	c.syncKeySecrets()
+	c.publishCA(ctx, statuses)
+	c.renderOutputs(statuses)
+}
+
+// actOnPods initializes and unseals the pods of a cluster in a phase that needs it, updating
+// statuses with the outcome
+func (c *Controller) actOnPods(ctx context.Context, pods []string, statuses map[string]*vault.Status) {
+	for _, pod := range pods {
+		if ctx.Err() != nil {
+			log.Printf("Reconcile pass interrupted: %v", ctx.Err())
+
+			return
+		}
+
+		vaultStatus, ok := statuses[pod]
+		if !ok {
+			continue
+		}
+
+		vaultClient := c.vaultClient(pod)
+
+		if !vaultStatus.Initialized {
+			if existing := c.existingClusterReason(pods, statuses); existing != "" && c.cfg.RaftJoin && vaultStatus.Raft() {
+				// A member of a cluster using integrated storage joins the cluster, then needs
+				// its unseal keys like any other member
+				if err := c.joinRaft(ctx, pod, vaultClient, pods, statuses); err != nil {
+					log.Printf("Not unsealing Vault for pod %s: %v", pod, err)
+					c.recordError(pod, reason.RaftJoinFailed, err)
+
+					continue
+				}
+				vaultStatus.Initialized = true
+			} else if existing != "" {
+				// A member joining an existing cluster, such as a raft peer using retry_join,
+				// needs the cluster's unseal keys rather than a fresh init
+				log.Printf("Not initializing Vault for pod %s: %s", pod, existing)
+			} else if unchecked := len(pods) - len(statuses); unchecked > 0 {
+				log.Printf("Not initializing Vault for pod %s: %d other pods could not be checked for existing data", pod, unchecked)
+				c.recordReason(pod, reason.InitDeferred)
+
+				continue
+			} else if !c.cfg.InitAllowed {
+				log.Printf("Not initializing Vault for pod %s: init is disabled, set INIT_ALLOWED=true to bootstrap this cluster", pod)
+				c.recordReason(pod, reason.InitNotAllowed)
+
+				continue
+			} else if err := c.initPodsReady(); err != nil {
+				log.Printf("Not initializing Vault for pod %s: %v", pod, err)
+				c.recordReason(pod, reason.InitWaitingForPods)
+
+				continue
+			} else if err := c.runHooks(hooks.PreInit, pod); err != nil {
+				log.Printf("Not initializing Vault for pod %s: %v", pod, err)
+				c.recordError(pod, reason.HookFailed, err)
+
+				continue
+			} else if err := c.initializeVault(ctx, pod, vaultClient, vaultStatus); err != nil {
+				log.Printf("Error initializing Vault for pod %s: %v", pod, err)
+				c.recordError(pod, reason.Of(err, reason.InitFailed), fmt.Errorf("error initializing Vault: %v", err))
+
+				continue
+			} else {
+				vaultStatus.Initialized = true
+				// An auto-unseal Vault unseals itself with its seal once initialized
+				if vaultStatus.AutoUnseal() {
+					vaultStatus.Sealed = false
+				}
+				c.runPostHooks(hooks.PostInit, pod)
+			}
+		}
+
+		if vaultStatus.Sealed && vaultStatus.Migration {
+			if !c.sealMigrationAllowed() {
+				log.Printf("Not unsealing Vault for pod %s: it waits for its seal to be migrated, set SEAL_MIGRATION=true or request the migration through the admin API", pod)
+				c.recordReason(pod, reason.SealMigrationPending)
+
+				continue
+			}
+			if err := c.runHooks(hooks.PreUnseal, pod); err != nil {
+				log.Printf("Not migrating the seal of Vault pod %s: %v", pod, err)
+				c.recordError(pod, reason.HookFailed, err)
+
+				continue
+			}
+
+			if err := c.migrateSeal(ctx, pod, vaultClient, vaultStatus); err != nil {
+				log.Printf("Error migrating the seal of Vault pod %s: %v", pod, err)
+				c.recordError(pod, reason.Of(err, reason.SealMigrationFailed), fmt.Errorf("error migrating the seal: %v", err))
+
+				continue
+			}
+
+			c.runPostHooks(hooks.PostUnseal, pod)
+			vaultStatus.Sealed = false
+			vaultStatus.Migration = false
+		}
+		if vaultStatus.Sealed && vaultStatus.AutoUnseal() {
+			// Unseal keys are meaningless to a Vault unsealed by its seal, and whatever keys it
+			// has are recovery keys. It unseals once its KMS, HSM or transit Vault answers.
+			log.Printf("Not unsealing Vault for pod %s: it is sealed by its %s seal, which unseals it itself", pod, vaultStatus.Type)
+			c.recordReason(pod, reason.AutoUnsealPending)
+
+			continue
+		}
+		if vaultStatus.Sealed {
+			if err := c.runHooks(hooks.PreUnseal, pod); err != nil {
+				log.Printf("Not unsealing Vault for pod %s: %v", pod, err)
+				c.recordError(pod, reason.HookFailed, err)
+
+				continue
+			}
+
+			if err := c.unsealVault(ctx, pod, vaultClient, vaultStatus); err != nil {
+				log.Printf("Error unsealing Vault for pod %s: %v", pod, err)
+				c.recordError(pod, reason.Of(err, reason.UnsealIncomplete), fmt.Errorf("error unsealing Vault: %v", err))
+
+				continue
+			}
+
+			c.notify(notify.EventUnsealed, reason.Unsealed, fmt.Sprintf("unsealed pod %s in namespace %s", pod, c.cfg.VaultNamespace))
+			c.runPostHooks(hooks.PostUnseal, pod)
+		}
+
+		vaultStatus.Sealed = false
+		c.status.Update(pod, func(p *status.Pod) {
+			p.Initialized = true
+			p.Sealed = false
+			p.UnsealProgress = 0
+		})
+	}
+}
+
+// trackActiveNode finds which unsealed pod is the active node, so token-authenticated
+// operations can be sent to it and /status can report it
+func (c *Controller) trackActiveNode(ctx context.Context) {
+	members := make(map[string]*vault.Client)
+	for _, pod := range c.status.Snapshot().Pods {
+		if pod.Reachable && pod.Initialized && !pod.Sealed {
+			members[pod.Pod] = c.vaultClient(pod.Pod)
+		}
+	}
+	c.active.SetMembers(members)
+
+	previous := c.status.Snapshot().Active
+	refreshCtx, cancel := context.WithTimeout(ctx, c.requestTimeout())
+	active, err := c.active.Refresh(refreshCtx)
+	cancel()
+	if err != nil && len(members) > 0 {
+		log.Printf("Error finding the active Vault node: %v", err)
+	}
+	c.status.SetActive(active)
+
+	if active != previous && active != "" {
+		log.Printf("Active Vault node is %s", active)
+	}
+}
+
+// publishCA fetches the CA chain served by every reachable pod and writes the union to the CA
+// ConfigMap of each configured namespace. Taking the union keeps both CAs published while a
+// rotation is rolled out pod by pod.
+func (c *Controller) publishCA(ctx context.Context, statuses map[string]*vault.Status) {
+	if c.cfg.CAConfigMap == "" || len(statuses) == 0 {
+		return
+	}
+
+	var roots *x509.CertPool
+	var certs []*x509.Certificate
+	if c.cfg.VaultCAConfigured() {
+		if !c.vaultTLSLoaded {
+			c.logCAError(fmt.Errorf("the Vault CA certificate is not loaded"))
+
+			return
+		}
+		certs = append(certs, c.vaultCACerts...)
+		roots = vault.NewCertPool(certs)
+	}
+
+	var errs []string
+	for pod := range statuses {
+		address, err := url.Parse(c.vaultAddress(pod))
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", pod, err))
+
+			continue
+		}
+
+		host := address.Host
+		if address.Port() == "" {
+			// An external address may rely on the default HTTPS port
+			host = net.JoinHostPort(address.Hostname(), "443")
+		}
+
+		fetchCtx, cancel := context.WithTimeout(ctx, diagnoseTimeout)
+		chain, err := vault.FetchCAChain(fetchCtx, host, roots)
+		cancel()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", pod, err))
+
+			continue
+		}
+		certs = append(certs, chain...)
+	}
+
+	if len(certs) == 0 {
+		sort.Strings(errs)
+		c.logCAError(fmt.Errorf("no CA certificate found: %s", strings.Join(errs, "; ")))
+
+		return
+	}
+
+	bundle := vault.EncodeCABundle(certs)
+	if bundle != c.caBundle {
+		log.Printf("Vault CA bundle changed, publishing %d certificates", strings.Count(bundle, "-----BEGIN CERTIFICATE-----"))
+		c.caBundle = bundle
+	}
+
+	failed := false
+	for _, namespace := range c.cfg.CAConfigMapNamespaces {
+		if c.caPublished[namespace] == bundle {
+			continue
+		}
+
+		err := c.k8sClient.CreateOrUpdateConfigMap(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      c.cfg.CAConfigMap,
+				Namespace: namespace,
+				Labels:    map[string]string{caBundleLabel: "true"},
+			},
+			Data: map[string]string{"ca.crt": bundle},
+		})
+		if err != nil {
+			c.logCAError(fmt.Errorf("failed to publish CA bundle to namespace %s: %v", namespace, err))
+			failed = true
+
+			continue
+		}
+
+		c.caPublished[namespace] = bundle
+		log.Printf("Published Vault CA bundle to ConfigMap %s/%s", namespace, c.cfg.CAConfigMap)
+	}
+	if !failed {
+		c.caError = ""
+	}
+}
+
+// logCAError logs an error of publishing the CA bundle unless it is the same as the last one
+func (c *Controller) logCAError(err error) {
+	if err.Error() == c.caError {
+		return
+	}
+
+	c.caError = err.Error()
+	log.Printf("Error publishing Vault CA bundle: %v", err)
+}
+
+// renderOutputs renders the consumption Secrets and ConfigMaps for workloads once Vault is
+// unsealed, and applies the ones whose content changed since they were last applied
+func (c *Controller) renderOutputs(statuses map[string]*vault.Status) {
+	if c.renderer == nil || !c.anyUnsealed() {
+		return
+	}
+
+	data := render.Data{Namespace: c.cfg.VaultNamespace, VaultAddr: c.cfg.VaultAddr()}
+	for _, vaultStatus := range statuses {
+		if vaultStatus.ClusterID != "" {
+			data.ClusterID, data.ClusterName = vaultStatus.ClusterID, vaultStatus.ClusterName
+
+			break
+		}
+	}
+	if data.ClusterID == "" && c.unsealKeys != nil {
+		data.ClusterID, data.ClusterName = c.unsealKeys.clusterID, c.unsealKeys.clusterName
+	}
+
+	if c.cfg.VaultCAConfigured() {
+		if !c.vaultTLSLoaded {
+			log.Printf("Error rendering templates: the Vault CA certificate is not loaded")
+
+			return
+		}
+		data.CACert = string(c.vaultCA)
+	} else {
+		data.CACert = c.caBundle
+	}
+
+	objects, err := c.renderer.Render(data)
+	if err != nil {
+		log.Printf("Error rendering templates: %v", err)
+
+		return
+	}
+
+	for _, object := range objects {
+		content, err := json.Marshal(object)
+		if err != nil {
+			log.Printf("Error encoding rendered %s: %v", object.Key(), err)
+
+			continue
+		}
+		sum := sha256.Sum256(content)
+		hash := hex.EncodeToString(sum[:])
+		if c.rendered[object.Key()] == hash {
+			continue
+		}
+
+		if object.Secret != nil {
+			err = c.k8sClient.CreateOrUpdateSecret(object.Secret)
+		} else {
+			err = c.k8sClient.CreateOrUpdateConfigMap(object.ConfigMap)
+		}
+		if err != nil {
+			log.Printf("Error applying rendered %s: %v", object.Key(), err)
+
+			continue
+		}
+
+		c.rendered[object.Key()] = hash
+		log.Printf("Applied rendered %s", object.Key())
+	}
+}
+
+// anyUnsealed reports whether at least one pod was unsealed at the end of the pass
+func (c *Controller) anyUnsealed() bool {
+	for _, pod := range c.status.Snapshot().Pods {
+		if pod.Reachable && pod.Initialized && !pod.Sealed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// runHooks runs the action hooks of phase for pod. A failing pre hook stops the action, so it
+// can act as a site-specific gate.
+func (c *Controller) runHooks(phase hooks.Phase, pod string) error {
+	return c.actionHooks.Run(hooks.Context{Phase: phase, Namespace: c.cfg.VaultNamespace, Pod: pod})
+}
+
+// runPostHooks runs the action hooks of phase for pod. The action already happened, so a
+// failure is only logged.
+func (c *Controller) runPostHooks(phase hooks.Phase, pod string) {
+	if err := c.runHooks(phase, pod); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
+
+// exportClusterState publishes how much of the cluster is unsealed, for monitors and
+// autoscalers that gate on Vault availability
+func (c *Controller) exportClusterState() {
+	snapshot := c.status.Snapshot()
+
+	unsealed := 0
+	reachable := make([]string, 0, len(snapshot.Pods))
+	for _, pod := range snapshot.Pods {
+		if pod.Reachable && pod.Initialized && !pod.Sealed {
+			unsealed++
+		}
+		if !pod.Reachable {
+			continue
+		}
+
+		reachable = append(reachable, pod.Pod)
+		sealed := 0.0
+		if !pod.Initialized || pod.Sealed {
+			sealed = 1
+		}
+		podSealed.Replace("pod", sealed, pod.Pod, pod.SealType, pod.Version)
+	}
+	podSealed.Retain("pod", reachable)
+
+	fraction := 0.0
+	if len(snapshot.Pods) > 0 {
+		fraction = float64(unsealed) / float64(len(snapshot.Pods))
+	}
+
+	podsTotal.Set(float64(len(snapshot.Pods)))
+	podsUnsealed.Set(float64(unsealed))
+	unsealedFraction.Set(fraction)
+
+	membersUnsealed, expected := snapshot.MemberCount()
+	membersFraction := 0.0
+	if expected > 0 {
+		membersFraction = min(float64(membersUnsealed)/float64(expected), 1)
+	}
+	replicasDesired.Set(float64(snapshot.Replicas))
+	membersUnsealedFraction.Set(membersFraction)
+}
+
+// podLabels returns the pod, seal_type and vault_version label values of the per-pod series,
+// described by vaultStatus when it was just read, or else by the latest status recorded
+func (c *Controller) podLabels(pod string, vaultStatus *vault.Status) []string {
+	if vaultStatus != nil {
+		return []string{pod, vaultStatus.Type, vaultStatus.Version}
+	}
+	recorded, _ := c.status.Pod(pod)
+
+	return []string{pod, recorded.SealType, recorded.Version}
+}
+
+// checkStatus queries the seal status of a pod and records how long it took
+func (c *Controller) checkStatus(ctx context.Context, pod string) (*vault.Status, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.requestTimeout())
+	defer cancel()
+
+	start := time.Now()
+	vaultStatus, err := c.vaultClient(pod).CheckStatus(ctx)
+
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	checkDuration.Observe(time.Since(start).Seconds(), append(c.podLabels(pod, vaultStatus), result)...)
+
+	return vaultStatus, err
+}
+
+// recordError records the error of the latest action on the pod and its reason code for /status
+func (c *Controller) recordError(pod string, code reason.Code, err error) {
+	c.status.Update(pod, func(p *status.Pod) {
+		p.Reason = code
+		p.Error = err.Error()
+	})
+}
+
+// recordReason records why the latest action on the pod was skipped for /status
+func (c *Controller) recordReason(pod string, code reason.Code) {
+	c.status.Update(pod, func(p *status.Pod) {
+		p.Reason = code
+	})
+}
+
+// reportUnreachable diagnoses why a pod could not be reached, records the diagnosis for
+// /status and, when the failing layer changes, records a Warning Event on the pod
+func (c *Controller) reportUnreachable(ctx context.Context, pod string, err error) {
+	if !vault.IsConnectionError(err) {
+		c.status.Update(pod, func(p *status.Pod) {
+			*p = status.Pod{Pod: pod, Reachable: true, Reason: reason.VaultStatusFailed, Error: err.Error()}
+		})
+
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, diagnoseTimeout)
+	defer cancel()
+
+	diagnosis := c.vaultClient(pod).Diagnose(ctx)
+	log.Printf("Connectivity diagnosis for pod %s: %s", pod, diagnosis.Summary())
+
+	c.status.Update(pod, func(p *status.Pod) {
+		*p = status.Pod{Pod: pod, Reason: reason.VaultUnreachable, Error: err.Error(), Diagnosis: diagnosis}
+	})
+
+	// There is no pod to record an Event on when Vault is reached through an external address
+	if c.unreachable[pod] == diagnosis.Failed || c.external != nil {
+		return
+	}
+	c.unreachable[pod] = diagnosis.Failed
+
+	annotations := map[string]string{reason.Annotation: string(reason.VaultUnreachable)}
+	if err := c.k8sClient.RecordPodEvent(c.cfg.VaultNamespace, pod, corev1.EventTypeWarning, unreachableReason, diagnosis.Summary(), annotations); err != nil {
+		log.Printf("Error recording event for pod %s: %v", pod, err)
+	}
+}
+
+// forgetUnreachable drops the diagnosis state of pods that no longer exist
+func (c *Controller) forgetUnreachable(pods []string) {
+	listed := make(map[string]bool, len(pods))
+	for _, pod := range pods {
+		listed[pod] = true
+	}
+
+	for pod := range c.unreachable {
+		if !listed[pod] {
+			delete(c.unreachable, pod)
+		}
+	}
+}
+
+// existingClusterReason explains why the cluster must already exist, or returns an empty
+// string when no member and no stored key material suggests it was ever initialized
+func (c *Controller) existingClusterReason(pods []string, statuses map[string]*vault.Status) string {
+	for _, pod := range pods {
+		if status, ok := statuses[pod]; ok && status.Initialized {
+			return fmt.Sprintf("pod %s reports an initialized cluster", pod)
+		}
+	}
+
+	exists, err := c.k8sClient.SecretExists(c.cfg.VaultNamespace, vault.UnsealKeysSecret)
+	if err != nil {
+		return fmt.Sprintf("could not check for existing unseal keys: %v", err)
+	}
+	if exists {
+		return fmt.Sprintf("secret %s already exists", vault.UnsealKeysSecret)
+	}
+
+	return ""
+}
+
+func (c *Controller) vaultClient(podIP string) *vault.Client {
+	if c.external != nil {
+		return c.external
+	}
+
+	return c.vaultClients.Get(c.vaultAddress(podIP))
+}
+
+// requestTimeout returns how long a single action on a Vault pod may take
+func (c *Controller) requestTimeout() time.Duration {
+	if c.cfg.VaultRequestTimeout <= 0 {
+		return vaultTimeout
+	}
+
+	return c.cfg.VaultRequestTimeout
+}
+
+// TransportOptions returns the connection settings of the clients to Vault from cfg
+func TransportOptions(cfg *config.Config) vault.TransportOptions {
+	return vault.TransportOptions{
+		DialTimeout:         cfg.VaultDialTimeout,
+		MaxIdleConnsPerHost: cfg.VaultMaxIdleConns,
+		IdleConnTimeout:     cfg.VaultIdleConnTimeout,
+	}
+}
+
+// RetryPolicy returns how the clients to Vault retry requests failing with a transient error
+// from cfg
+func RetryPolicy(cfg *config.Config) vault.RetryPolicy {
+	return vault.RetryPolicy{
+		MaxAttempts: cfg.VaultRetryMaxAttempts,
+		BaseDelay:   cfg.VaultRetryBaseDelay,
+		Jitter:      float64(cfg.VaultRetryJitterPercent) / 100,
+	}
+}
+
+// vaultPods returns the addresses of the Vault pods, or only the external address when Vault is
+// reached through one
+func (c *Controller) vaultPods() ([]string, error) {
+	if c.external != nil {
+		return []string{c.cfg.VaultExternalURL}, nil
+	}
+
+	return c.k8sClient.GetVaultPods(c.cfg.VaultNamespace)
+}
+
+// CheckReady returns an error unless every Vault pod is reachable, initialized and unsealed. The
+// pods are reached as the reconcile loop reaches them: in VAULT_NAMESPACE, on the configured
+// scheme, port and TLS settings, or through VAULT_EXTERNAL_URL. It is safe to call beside the
+// reconcile loop.
+func (c *Controller) CheckReady(ctx context.Context) error {
+	pods, err := c.vaultPods()
+	if err != nil {
+		return fmt.Errorf("failed to list the Vault pods: %v", err)
+	}
+
+	var errs []error
+	for _, pod := range pods {
+		vaultStatus, err := c.vaultClient(pod).CheckStatus(ctx)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("failed to check pod %s: %v", pod, err))
+		case !vaultStatus.Initialized:
+			errs = append(errs, fmt.Errorf("pod %s is not initialized", pod))
+		case vaultStatus.Sealed:
+			errs = append(errs, fmt.Errorf("pod %s is sealed", pod))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// initializeVault initializes the Vault of pod and stores its root token and keys. An
+// auto-unseal Vault, as vaultStatus tells, is given recovery keys, which are stored in their own
+// Secret or with the key provider; it unseals itself.
+func (c *Controller) initializeVault(ctx context.Context, pod string, vaultClient *vault.Client, vaultStatus *vault.Status) error {
+	lock, err := c.k8sClient.AcquireActionLock(c.cfg.VaultNamespace, kubernetes.ActionInit, c.identity)
+	if err != nil {
+		return reason.Errorf(reason.ActionLocked, "%v", err)
+	}
+	defer func() {
+		if err := lock.Release(); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}()
+	c.transition(status.PhaseInitializing)
+
+	// Init is neither bounded nor cancelled: giving up on a request Vault goes on to complete
+	// would lose the only copy of the keys. The pod answered its status check moments ago.
+	resp, err := vaultClient.InitializeWithRequest(context.WithoutCancel(ctx), c.initRequest(vaultStatus))
+	if errors.Is(err, vault.ErrAlreadyInitialized) {
+		// Someone else initialized Vault since its status was checked, and holds its keys
+		return reason.Errorf(reason.InitFailed, "Vault was initialized by another actor since its status was checked, its keys are not stored by the controller")
+	}
+	if err != nil {
+		return reason.Errorf(reason.InitFailed, "error initializing Vault: %v", err)
+	}
+
+	// Record who bootstrapped the cluster, when, and with which settings on both Secrets
+	initializedAt := time.Now().UTC().Format(time.RFC3339)
+	configHash := c.cfg.Hash()
+	provenance := func() map[string]string {
+		return map[string]string{
+			vault.InitializedByAnnotation: c.identity,
+			vault.InitializedAtAnnotation: initializedAt,
+			vault.ConfigHashAnnotation:    configHash,
+		}
+	}
+
+	rootTokenSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        vault.RootTokenSecret,
+			Namespace:   c.cfg.VaultNamespace,
+			Annotations: provenance(),
+		},
+		Data: map[string][]byte{
+			"token": []byte(resp.RootToken),
+		},
+	}
+	if c.rootTokenPGPKey != "" {
+		rootTokenSecret.Annotations[vault.PGPEncryptedAnnotation] = "true"
+	}
+
+	if _, err := c.formatKeySecret(rootTokenSecret); err != nil {
+		log.Printf("Warning: storing the root token without the configured labels and annotations: %v", err)
+	}
+
+	if err := c.k8sClient.CreateOrUpdateSecret(rootTokenSecret); err != nil {
+		return reason.Errorf(reason.InitStorageFailed, "error storing root token: %v", err)
+	}
+
+	encoding := c.keyEncoding()
+	keys, keysName, secretName := resp.EncodedKeys(encoding), "unseal keys", vault.UnsealKeysSecret
+	if vaultStatus.AutoUnseal() {
+		keys, keysName, secretName = resp.EncodedRecoveryKeys(encoding), "recovery keys", vault.RecoveryKeysSecret
+	}
+
+	keyData := make(map[string][]byte)
+	if c.keyProvider != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), keyprovider.DefaultTimeout)
+		err := c.keyProvider.Store(ctx, c.cfg.VaultNamespace, keys)
+		cancel()
+		if err != nil {
+			return reason.Errorf(reason.InitStorageFailed, "error storing %s with %s: %v", keysName, c.keyProvider, err)
+		}
+	} else {
+		for i, key := range keys {
+			keyData[fmt.Sprintf("key%d", i+1)] = []byte(key)
+		}
+	}
+
+	keysSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        secretName,
+			Namespace:   c.cfg.VaultNamespace,
+			Annotations: provenance(),
+		},
+		Data: keyData,
+	}
+	if len(c.initPGPKeys) > 0 {
+		keysSecret.Annotations[vault.PGPEncryptedAnnotation] = "true"
+	}
+	keysSecret.Annotations[vault.KeyEncodingAnnotation] = encoding
+	keysSecret.Annotations[vault.FormatAnnotation] = vault.SecretFormat
+
+	// Record the seal configuration next to the keys so unsealing knows how many keys it needs
+	threshold, shares := 0, 0
+	statusCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.requestTimeout())
+	status, err := vaultClient.CheckStatus(statusCtx)
+	cancel()
+	if err != nil {
+		log.Printf("Warning: could not read seal configuration after init, all keys will be applied when unsealing: %v", err)
+	} else if status.Threshold > 0 {
+		threshold, shares = status.Threshold, status.Shares
+		keysSecret.Annotations[vault.ThresholdAnnotation] = strconv.Itoa(status.Threshold)
+		keysSecret.Annotations[vault.SharesAnnotation] = strconv.Itoa(status.Shares)
+	}
+
+	if _, err := c.formatKeySecret(keysSecret); err != nil {
+		log.Printf("Warning: storing the %s without the configured labels, annotations and format: %v", keysName, err)
+	}
+
+	if err := c.k8sClient.CreateOrUpdateSecret(keysSecret); err != nil {
+		return reason.Errorf(reason.InitStorageFailed, "error storing %s: %v", keysName, err)
+	}
+
+	// The Secret now holds the new keys, so the rest of the pass can use them directly. Recovery
+	// keys never unseal anything.
+	if !vaultStatus.AutoUnseal() {
+		c.unsealKeys = &unsealKeyCache{keys: resp.Keys, threshold: threshold, shares: shares, encrypted: len(c.initPGPKeys) > 0}
+	}
+
+	log.Printf("Successfully initialized Vault and stored secrets")
+	log.Printf("Audit: initialized Vault pod=%s namespace=%s initialized-by=%s initialized-at=%s config-hash=%s",
+		pod, c.cfg.VaultNamespace, c.identity, initializedAt, configHash)
+
+	c.notify(notify.EventInitialized, reason.Initialized, fmt.Sprintf("initialized Vault in namespace %s via pod %s (initialized-by %s, config-hash %s)",
+		c.cfg.VaultNamespace, pod, c.identity, configHash))
+
+	return nil
+}
+
+// keyEncoding returns the encoding the keys handed out at init are stored in
+func (c *Controller) keyEncoding() string {
+	if c.cfg.KeyEncoding == vault.KeyEncodingBase64 {
+		return vault.KeyEncodingBase64
+	}
+
+	return vault.KeyEncodingHex
+}
+
+// initRequest returns the init request for a Vault with vaultStatus: recovery keys for an
+// auto-unseal Vault and unseal keys otherwise, encrypted to the PGP keys when there are any
+func (c *Controller) initRequest(vaultStatus *vault.Status) vault.InitRequest {
+	shares, threshold := c.cfg.SecretSplit()
+	req := vault.InitRequest{
+		SecretShares:    shares,
+		SecretThreshold: threshold,
+		RootTokenPGPKey: c.rootTokenPGPKey,
+	}
+	if len(c.initPGPKeys) > 0 {
+		req.SecretShares = len(c.initPGPKeys)
+		req.PGPKeys = c.initPGPKeys
+	}
+
+	if vaultStatus.AutoUnseal() {
+		req = vault.InitRequest{
+			RecoveryShares:    c.cfg.RecoveryShares,
+			RecoveryThreshold: c.cfg.RecoveryThreshold,
+			RootTokenPGPKey:   c.rootTokenPGPKey,
+		}
+		if len(c.initPGPKeys) > 0 {
+			req.RecoveryShares = len(c.initPGPKeys)
+			req.RecoveryPGPKeys = c.initPGPKeys
+		}
+	}
+
+	return req
+}
+
+// loadUnsealKeys returns the stored unseal keys, reading their Secret and key provider at most
+// once per pass and falling back to the key cache when they cannot be read
+func (c *Controller) loadUnsealKeys() ([]string, error) {
+	if c.unsealKeys != nil {
+		return c.unsealKeys.keys, c.unsealKeys.err
+	}
+
+	c.unsealKeys = &unsealKeyCache{}
+
+	load := c.loadSecretUnsealKeys
+	if c.keyProvider != nil {
+		load = c.loadProvidedUnsealKeys
+	}
+	keys, err := load()
+	if err != nil {
+		return c.loadCachedUnsealKeys(err)
+	}
+	c.cacheUnsealKeys()
+
+	return keys, nil
+}
+
+// loadSecretUnsealKeys reads the unseal keys from the unseal keys Secret
+func (c *Controller) loadSecretUnsealKeys() ([]string, error) {
+	unsealSecret, err := c.k8sClient.GetSecret(c.cfg.VaultNamespace, vault.UnsealKeysSecret)
+	if err != nil {
+		c.unsealKeys.err = reason.Errorf(reason.UnsealKeysUnavailable, "error getting unseal keys secret: %v", err)
+		return nil, c.unsealKeys.err
+	}
+
+	keys, missing := kubernetes.UnsealKeysFromSecret(unsealSecret.Data)
+	if len(missing) > 0 {
+		log.Printf("Warning: unseal keys secret has gaps in its numbering, missing %s", strings.Join(missing, ", "))
+	}
+
+	c.unsealKeys.keys = keys
+	c.readKeyAnnotations(unsealSecret)
+
+	return keys, nil
+}
+
+// loadProvidedUnsealKeys reads the unseal keys from the key provider. The unseal keys Secret is
+// optional then, for keys that were placed in the provider by hand, and only read for the seal
+// configuration and cluster identity recorded on it.
+func (c *Controller) loadProvidedUnsealKeys() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), keyprovider.DefaultTimeout)
+	keys, err := c.keyProvider.Keys(ctx, c.cfg.VaultNamespace)
+	cancel()
+	if err != nil {
+		c.unsealKeys.err = reason.Errorf(reason.UnsealKeysUnavailable, "error getting unseal keys from %s: %v", c.keyProvider, err)
+		return nil, c.unsealKeys.err
+	}
+	c.unsealKeys.keys = keys
+
+	exists, err := c.k8sClient.SecretExists(c.cfg.VaultNamespace, vault.UnsealKeysSecret)
+	if err != nil {
+		log.Printf("Warning: could not read the seal configuration recorded on the unseal keys secret, all keys will be applied: %v", err)
+
+		return keys, nil
+	}
+	if !exists {
+		return keys, nil
+	}
+
+	unsealSecret, err := c.k8sClient.GetSecret(c.cfg.VaultNamespace, vault.UnsealKeysSecret)
+	if err != nil {
+		log.Printf("Warning: could not read the seal configuration recorded on the unseal keys secret, all keys will be applied: %v", err)
+
+		return keys, nil
+	}
+	c.readKeyAnnotations(unsealSecret)
+
+	return keys, nil
+}
+
+// readKeyAnnotations caches the seal configuration and cluster identity recorded on the unseal
+// keys Secret
+func (c *Controller) readKeyAnnotations(unsealSecret *corev1.Secret) {
+	c.unsealKeys.secret = unsealSecret
+
+	if value, ok := unsealSecret.Annotations[vault.ThresholdAnnotation]; ok {
+		threshold, err := strconv.Atoi(value)
+		if err != nil || threshold < 1 {
+			log.Printf("Warning: ignoring invalid %s annotation %q, all keys will be applied", vault.ThresholdAnnotation, value)
+		} else {
+			c.unsealKeys.threshold = threshold
+		}
+	}
+
+	if value, ok := unsealSecret.Annotations[vault.SharesAnnotation]; ok {
+		if shares, err := strconv.Atoi(value); err == nil && shares > 0 {
+			c.unsealKeys.shares = shares
+		}
+	}
+
+	c.unsealKeys.clusterID = unsealSecret.Annotations[vault.ClusterIDAnnotation]
+	c.unsealKeys.clusterName = unsealSecret.Annotations[vault.ClusterNameAnnotation]
+	c.unsealKeys.encrypted = unsealSecret.Annotations[vault.PGPEncryptedAnnotation] == "true"
+}
+
+func (c *Controller) unsealVault(ctx context.Context, pod string, vaultClient *vault.Client, status *vault.Status) error {
+	keys, err := c.loadUnsealKeys()
+	if err != nil {
+		return err
+	}
+
+	if c.unsealKeys.encrypted {
+		return reason.Errorf(reason.UnsealKeysEncrypted, "the unseal keys are encrypted to operators' PGP keys, an operator must decrypt and submit them")
+	}
+	if len(keys) == 0 {
+		return reason.Errorf(reason.UnsealNoKeys, "no unseal keys found")
+	}
+
+	if c.cfg.VerifyClusterIdentity {
+		if err := c.verifyClusterIdentity(status); err != nil {
+			return reason.Errorf(reason.IdentityMismatch, "refusing to send unseal keys: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.requestTimeout())
+	defer cancel()
+
+	// Try unsealing with each key. Vault answers every key with its progress, so keys stop
+	// being submitted as soon as it opens, whatever the threshold. Keys stored base64 encoded
+	// are sent in hex like the others.
+	rejected := 0
+	for _, key := range keys {
+		unsealStatus, unsealErr := vaultClient.Unseal(ctx, vault.HexKey(key))
+		if unsealErr != nil {
+			// The remaining keys would fail the same way
+			switch {
+			case errors.Is(unsealErr, vault.ErrUnreachable):
+				return reason.Errorf(reason.VaultUnreachable, "error unsealing: %v", unsealErr)
+			case errors.Is(unsealErr, vault.ErrNotInitialized):
+				return reason.Errorf(reason.VaultStatusFailed, "error unsealing: %v", unsealErr)
+			}
+
+			log.Printf("Warning: Failed to unseal with key: %v", unsealErr)
+			if errors.Is(unsealErr, vault.ErrInvalidKey) {
+				rejected++
+			}
+			continue
+		}
+
+		if !unsealStatus.Sealed {
+			delete(c.unsealNonces, pod)
+			c.checkClusterIdentity(pod, unsealStatus)
+			return nil
+		}
+		c.recordUnsealProgress(pod, unsealStatus.UnsealProgress())
+	}
+
+	// Check final status
+	status, err = vaultClient.CheckStatus(ctx)
+	if err != nil {
+		return reason.Errorf(reason.VaultStatusFailed, "error checking final status: %v", err)
+	}
+	c.unsealNonces[pod] = status.Nonce
+
+	if status.Sealed && rejected > 0 {
+		return reason.Errorf(reason.UnsealInvalidKey, "vault is still sealed after attempting to unseal, %d of %d keys were rejected", rejected, len(keys))
+	}
+	if status.Sealed {
+		return reason.Errorf(reason.UnsealIncomplete, "vault is still sealed after attempting to unseal")
+	}
+
+	c.checkClusterIdentity(pod, status)
+
+	return nil
+}
+
+// recordUnsealProgress logs how many keys the unseal attempt on pod still needs and shows its
+// progress in /status
+func (c *Controller) recordUnsealProgress(pod string, progress *vault.UnsealProgress) {
+	log.Printf("Unsealing Vault pod %s: %d of %d keys applied, %d more needed", pod, progress.Progress, progress.Threshold, progress.Remaining())
+	c.status.Update(pod, func(p *status.Pod) {
+		p.UnsealProgress = progress.Progress
+		p.Threshold = progress.Threshold
+	})
+}
+
+// verifyClusterIdentity checks what a sealed Vault reveals about itself against what is
+// recorded for the cluster the keys belong to, so keys are not posted to an impostor on a
+// reused pod IP. A sealed Shamir Vault does not report its cluster ID, so the seal
+// configuration is compared as well.
+func (c *Controller) verifyClusterIdentity(status *vault.Status) error {
+	recorded := c.unsealKeys
+	if recorded.clusterID == "" {
+		log.Printf("Warning: no cluster identity recorded yet, it will be learned once Vault is unsealed")
+
+		return nil
+	}
+
+	if recorded.threshold > 0 && status.Threshold != recorded.threshold {
+		return fmt.Errorf("seal threshold %d does not match the recorded %d", status.Threshold, recorded.threshold)
+	}
+	if recorded.shares > 0 && status.Shares != recorded.shares {
+		return fmt.Errorf("seal shares %d do not match the recorded %d", status.Shares, recorded.shares)
+	}
+	if status.ClusterID != "" && status.ClusterID != recorded.clusterID {
+		return fmt.Errorf("cluster ID %s does not match the recorded %s", status.ClusterID, recorded.clusterID)
+	}
+	if status.ClusterName != "" && recorded.clusterName != "" && status.ClusterName != recorded.clusterName {
+		return fmt.Errorf("cluster name %s does not match the recorded %s", status.ClusterName, recorded.clusterName)
+	}
+
+	return nil
+}
+
+// checkClusterIdentity compares the identity an unsealed Vault reports with the recorded one.
+// The first identity seen is recorded on the unseal keys Secret; a different one afterwards
+// means the keys were sent to a server that is not part of the cluster, which operators must
+// hear about.
+func (c *Controller) checkClusterIdentity(pod string, status *vault.Status) {
+	if status.ClusterID == "" || c.unsealKeys == nil {
+		return
+	}
+
+	if c.unsealKeys.clusterID == "" && (c.seenClusterID == "" || c.seenClusterID == status.ClusterID) {
+		c.seenClusterID, c.seenClusterName = status.ClusterID, status.ClusterName
+
+		if err := c.recordClusterIdentity(status); err != nil {
+			log.Printf("Warning: could not record cluster identity: %v", err)
+
+			return
+		}
+
+		log.Printf("Recorded Vault cluster identity %s (%s)", status.ClusterID, status.ClusterName)
+
+		return
+	}
+
+	// Without an identity on the Secret, the one remembered in the state file is expected
+	expected := c.unsealKeys.clusterID
+	if expected == "" {
+		expected = c.seenClusterID
+	}
+
+	if status.ClusterID == expected {
+		c.seenClusterID, c.seenClusterName = status.ClusterID, status.ClusterName
+
+		return
+	}
+
+	log.Printf("Error: pod %s unsealed as cluster %s, but the unseal keys belong to cluster %s", pod, status.ClusterID, expected)
+
+	c.notify(notify.EventIdentityMismatch, reason.IdentityMismatch, fmt.Sprintf("pod %s in namespace %s reported cluster ID %s, expected %s; unseal keys may have been sent to an impostor",
+		pod, c.cfg.VaultNamespace, status.ClusterID, expected))
+}
+
+// notify sends an operator notification from the controller
+func (c *Controller) notify(eventType string, code reason.Code, message string) {
+	event := notify.Event{
+		Type:      eventType,
+		Reason:    code,
+		Component: "controller",
+		Message:   message,
+	}
+	if err := c.notifier.Notify(event); err != nil {
+		log.Printf("Error sending %s notification: %v", eventType, err)
+	}
+}
+
+// recordClusterIdentity stores the cluster identity on the unseal keys Secret
+func (c *Controller) recordClusterIdentity(status *vault.Status) error {
+	secret := c.unsealKeys.secret
+	if secret == nil {
+		var err error
+		if secret, err = c.k8sClient.GetSecret(c.cfg.VaultNamespace, vault.UnsealKeysSecret); err != nil {
+			return err
+		}
+	}
+
+	secret = secret.DeepCopy()
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	secret.Annotations[vault.ClusterIDAnnotation] = status.ClusterID
+	secret.Annotations[vault.ClusterNameAnnotation] = status.ClusterName
+
+	if err := c.k8sClient.UpdateSecret(secret); err != nil {
+		return err
+	}
+
+	c.unsealKeys.secret = nil
+	c.unsealKeys.clusterID = status.ClusterID
+	c.unsealKeys.clusterName = status.ClusterName
+
+	return nil
+}
+
+// stepDownDrainingLeader steps down the active Vault node when its pod is being evicted or its
+// node is cordoned, so a standby takes over before the pod goes away
+func (c *Controller) stepDownDrainingLeader(ctx context.Context) {
+	draining, err := c.k8sClient.GetDrainingVaultPods(c.cfg.VaultNamespace)
+	if err != nil {
+		log.Printf("Error getting draining Vault pods: %v", err)
+
+		return
+	}
+
+	stillDraining := make(map[string]bool)
+	for _, pod := range draining {
+		stillDraining[pod] = true
+		if c.steppedDown[pod] {
+			continue
+		}
+
+		if c.stepDown(ctx, pod) {
+			c.steppedDown[pod] = true
+		}
+	}
+
+	for pod := range c.steppedDown {
+		if !stillDraining[pod] {
+			delete(c.steppedDown, pod)
+		}
+	}
+}
+
+// stepDown steps down the Vault node of a draining pod if it is the active one, and reports
+// whether it was stepped down
+func (c *Controller) stepDown(ctx context.Context, pod string) bool {
+	ctx, cancel := context.WithTimeout(ctx, c.requestTimeout())
+	defer cancel()
+
+	vaultClient := c.vaultClient(pod)
+
+	leader, err := vaultClient.Leader(ctx)
+	if err != nil {
+		log.Printf("Error checking leadership for draining pod %s: %v", pod, err)
+
+		return false
+	}
+
+	if !leader.HAEnabled || !leader.IsSelf {
+		return false
+	}
+
+	rootToken, err := c.rootToken()
+	if err != nil {
+		log.Printf("Error getting root token to step down pod %s: %v", pod, err)
+
+		return false
+	}
+
+	if err := vaultClient.StepDown(ctx, rootToken); err != nil {
+		if errors.Is(err, vault.ErrSealed) {
+			// A sealed node is no longer active, there is nothing left to hand over
+			log.Printf("Draining Vault pod %s sealed before it was stepped down", pod)
+
+			return true
+		}
+		log.Printf("Error stepping down active Vault node %s: %v", pod, err)
+
+		return false
+	}
+
+	log.Printf("Stepped down active Vault node %s ahead of drain", pod)
+
+	return true
+}
+
+// podUnsealedAndJoined reports whether the Vault pod is unsealed and, in HA mode, knows its leader
+func (c *Controller) podUnsealedAndJoined(ctx context.Context, podIP string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.requestTimeout())
+	defer cancel()
+
+	vaultClient := c.vaultClient(podIP)
+
+	status, err := vaultClient.CheckStatus(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	if !status.Initialized || status.Sealed {
+		return false, nil
+	}
+
+	leader, err := vaultClient.Leader(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	return !leader.HAEnabled || leader.LeaderAddress != "", nil
+}
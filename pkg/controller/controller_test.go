@@ -0,0 +1,1191 @@
+package controller
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/config"
+	"github.com/getgrowly/vault-utils/pkg/hooks"
+	"github.com/getgrowly/vault-utils/pkg/kubernetes"
+	"github.com/getgrowly/vault-utils/pkg/notify"
+	"github.com/getgrowly/vault-utils/pkg/reason"
+	"github.com/getgrowly/vault-utils/pkg/recovery"
+	"github.com/getgrowly/vault-utils/pkg/render"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// testConfig returns the configuration used by the controller tests
+func testConfig() *config.Config {
+	return &config.Config{
+		VaultNamespace: "vault",
+		VaultPort:      "8200",
+		CheckInterval:  10 * time.Second,
+		InitAllowed:    true,
+	}
+}
+
+// newTestController creates a controller whose Vault pods are backed by the given fakes.
+// Pod i gets the IP 10.0.<i/250>.<i%250+1> and is routed to fakes[i].
+func newTestController(t testing.TB, clientset *fake.Clientset, cfg *config.Config, fakes []*vaulttest.Server) *Controller {
+	t.Helper()
+
+	addresses := make(map[string]string, len(fakes))
+	for i, fakeVault := range fakes {
+		podIP := fmt.Sprintf("10.0.%d.%d", i/250, i%250+1)
+		addresses[podIP] = fakeVault.URL
+
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("vault-%d", i),
+				Namespace: cfg.VaultNamespace,
+				Labels: map[string]string{
+					"app.kubernetes.io/name": "vault",
+					"component":              "server",
+				},
+			},
+			Status: corev1.PodStatus{PodIP: podIP},
+		}
+		if _, err := clientset.CoreV1().Pods(cfg.VaultNamespace).Create(context.Background(), pod, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("failed to create test pod: %v", err)
+		}
+	}
+
+	c := New(kubernetes.NewClientWithInterface(clientset), cfg, notify.Nop{})
+	c.vaultAddress = func(podIP string) string {
+		return addresses[podIP]
+	}
+
+	return c
+}
+
+// storeUnsealKeys creates the unseal keys Secret for the given key shares
+func storeUnsealKeys(t testing.TB, clientset *fake.Clientset, keys []string) {
+	t.Helper()
+
+	data := make(map[string][]byte, len(keys))
+	for i, key := range keys {
+		data[fmt.Sprintf("key%d", i+1)] = []byte(key)
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: vault.UnsealKeysSecret, Namespace: "vault"},
+		Data:       data,
+	}
+	if _, err := clientset.CoreV1().Secrets("vault").Create(context.Background(), secret, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create unseal keys secret: %v", err)
+	}
+}
+
+func TestReconcileInitializesAndUnseals(t *testing.T) {
+	fakeVault := vaulttest.NewServer()
+	defer fakeVault.Close()
+
+	clientset := fake.NewSimpleClientset()
+	c := newTestController(t, clientset, testConfig(), []*vaulttest.Server{fakeVault})
+
+	c.Reconcile(context.Background())
+
+	if fakeVault.Sealed() {
+		t.Errorf("expected vault to be unsealed after reconcile")
+	}
+
+	rootToken, err := clientset.CoreV1().Secrets("vault").Get(context.Background(), vault.RootTokenSecret, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected root token secret: %v", err)
+	}
+	if string(rootToken.Data["token"]) != fakeVault.RootToken() {
+		t.Errorf("expected stored root token to match the one returned at init")
+	}
+
+	unsealKeys, err := clientset.CoreV1().Secrets("vault").Get(context.Background(), vault.UnsealKeysSecret, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected unseal keys secret: %v", err)
+	}
+	if len(unsealKeys.Data) != len(fakeVault.Keys()) {
+		t.Errorf("expected %d stored keys, got %d", len(fakeVault.Keys()), len(unsealKeys.Data))
+	}
+	if unsealKeys.Annotations[vault.ThresholdAnnotation] != "3" || unsealKeys.Annotations[vault.SharesAnnotation] != "5" {
+		t.Errorf("expected threshold and shares annotations 3/5, got %v", unsealKeys.Annotations)
+	}
+
+	for _, secret := range []*corev1.Secret{rootToken, unsealKeys} {
+		if !strings.HasPrefix(secret.Annotations[vault.InitializedByAnnotation], "vault-utils/") {
+			t.Errorf("expected %s to record who initialized the cluster, got %v", secret.Name, secret.Annotations)
+		}
+		if _, err := time.Parse(time.RFC3339, secret.Annotations[vault.InitializedAtAnnotation]); err != nil {
+			t.Errorf("expected %s to record when the cluster was initialized: %v", secret.Name, err)
+		}
+		if secret.Annotations[vault.ConfigHashAnnotation] != c.cfg.Hash() {
+			t.Errorf("expected %s to record the config hash", secret.Name)
+		}
+	}
+	if submitted := fakeVault.Submitted(); submitted != 3 {
+		t.Errorf("expected only the threshold of 3 keys to be submitted, got %d", submitted)
+	}
+	if active := c.Status().Snapshot().Active; active != "10.0.0.1" {
+		t.Errorf("expected the unsealed pod to be reported as the active node, got %q", active)
+	}
+}
+
+func TestReconcileWithOfficialClient(t *testing.T) {
+	cluster := vaulttest.NewCluster(3, 5, 3)
+	for _, fakeVault := range cluster {
+		defer fakeVault.Close()
+	}
+
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, cluster[0].Keys())
+	cfg := testConfig()
+	cfg.VaultClientBackend = config.ClientBackendOfficial
+	c := newTestController(t, clientset, cfg, cluster)
+
+	c.Reconcile(context.Background())
+	for i, fakeVault := range cluster {
+		if fakeVault.Sealed() {
+			t.Errorf("expected pod %d to be unsealed through the official Vault API client", i)
+		}
+	}
+	if err := c.CheckReady(context.Background()); err != nil {
+		t.Errorf("expected every pod to be ready, got %v", err)
+	}
+}
+
+func TestReconcileInitializesAutoUnseal(t *testing.T) {
+	fakeVault := vaulttest.NewUninitializedAutoUnsealServer()
+	defer fakeVault.Close()
+
+	clientset := fake.NewSimpleClientset()
+	cfg := testConfig()
+	cfg.RecoveryShares, cfg.RecoveryThreshold = 3, 2
+	c := newTestController(t, clientset, cfg, []*vaulttest.Server{fakeVault})
+
+	c.Reconcile(context.Background())
+
+	if !fakeVault.Initialized() || fakeVault.Sealed() {
+		t.Fatalf("expected the auto-unseal Vault to be initialized and unsealed")
+	}
+
+	recoveryKeys, err := clientset.CoreV1().Secrets("vault").Get(context.Background(), vault.RecoveryKeysSecret, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected recovery keys secret: %v", err)
+	}
+	if len(recoveryKeys.Data) != 3 || string(recoveryKeys.Data["key1"]) != fakeVault.Keys()[0] {
+		t.Errorf("expected the 3 recovery keys to be stored, got %d", len(recoveryKeys.Data))
+	}
+	if recoveryKeys.Annotations[vault.ThresholdAnnotation] != "2" || recoveryKeys.Annotations[vault.InitializedByAnnotation] == "" {
+		t.Errorf("expected the recovery keys secret to record the threshold and provenance, got %v", recoveryKeys.Annotations)
+	}
+	if _, err := clientset.CoreV1().Secrets("vault").Get(context.Background(), vault.UnsealKeysSecret, metav1.GetOptions{}); err == nil {
+		t.Errorf("expected no unseal keys secret for an auto-unseal Vault")
+	}
+	if _, err := clientset.CoreV1().Secrets("vault").Get(context.Background(), vault.RootTokenSecret, metav1.GetOptions{}); err != nil {
+		t.Errorf("expected root token secret: %v", err)
+	}
+	if submitted := fakeVault.Submitted(); submitted != 0 {
+		t.Errorf("expected no unseal keys to be submitted, got %d", submitted)
+	}
+	if pod := c.Status().Snapshot().Pods[0]; pod.Error != "" {
+		t.Errorf("expected the pass to succeed, got %s", pod.Error)
+	}
+}
+
+func TestReconcileLeavesSealedAutoUnsealAlone(t *testing.T) {
+	fakeVault := vaulttest.NewAutoUnsealServer(5, 3)
+	defer fakeVault.Close()
+	fakeVault.Seal()
+
+	// Even with keys at hand, none is sent to a Vault its seal unseals
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, fakeVault.Keys())
+	c := newTestController(t, clientset, testConfig(), []*vaulttest.Server{fakeVault})
+
+	c.Reconcile(context.Background())
+
+	if submitted := fakeVault.Submitted(); submitted != 0 {
+		t.Errorf("expected no unseal keys to be submitted, got %d", submitted)
+	}
+	pod := c.Status().Snapshot().Pods[0]
+	if !pod.Sealed || pod.Reason != reason.AutoUnsealPending || pod.SealType != "awskms" {
+		t.Errorf("expected the pod to wait for its awskms seal, got %+v", pod)
+	}
+}
+
+func TestReconcileInitializesWithPGPKeys(t *testing.T) {
+	fakeVault := vaulttest.NewServer()
+	defer fakeVault.Close()
+
+	clientset := fake.NewSimpleClientset()
+	c := newTestController(t, clientset, testConfig(), []*vaulttest.Server{fakeVault})
+	pgpKeys := []string{"a2V5MQ==", "a2V5Mg==", "a2V5Mw==", "a2V5NA=="}
+	c.SetInitPGPKeys(pgpKeys, "cm9vdA==")
+
+	c.Reconcile(context.Background())
+
+	unsealKeys, err := clientset.CoreV1().Secrets("vault").Get(context.Background(), vault.UnsealKeysSecret, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected unseal keys secret: %v", err)
+	}
+	if len(unsealKeys.Data) != 4 || unsealKeys.Annotations[vault.PGPEncryptedAnnotation] != "true" {
+		t.Fatalf("expected 4 keys marked as encrypted, got %d with %v", len(unsealKeys.Data), unsealKeys.Annotations)
+	}
+	for i, key := range fakeVault.Keys() {
+		if stored := string(unsealKeys.Data[fmt.Sprintf("key%d", i+1)]); stored != vaulttest.PGPEncrypt(pgpKeys[i], key) {
+			t.Errorf("expected key%d to be stored encrypted, got %s", i+1, stored)
+		}
+	}
+
+	rootToken, err := clientset.CoreV1().Secrets("vault").Get(context.Background(), vault.RootTokenSecret, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected root token secret: %v", err)
+	}
+	if string(rootToken.Data["token"]) == fakeVault.RootToken() || rootToken.Annotations[vault.PGPEncryptedAnnotation] != "true" {
+		t.Errorf("expected the root token to be stored encrypted")
+	}
+	if _, err := c.rootToken(); err == nil {
+		t.Errorf("expected the encrypted root token to be refused")
+	}
+
+	// Encrypted keys cannot unseal, which is left to the operators
+	if !fakeVault.Sealed() || fakeVault.Submitted() != 0 {
+		t.Errorf("expected no key to be submitted")
+	}
+	if pod := c.Status().Snapshot().Pods[0]; pod.Reason != reason.UnsealKeysEncrypted {
+		t.Errorf("expected the %s reason, got %+v", reason.UnsealKeysEncrypted, pod)
+	}
+}
+
+// memoryProvider is a key provider keeping the keys of each namespace in memory
+type memoryProvider struct {
+	keys map[string][]string
+}
+
+func (p *memoryProvider) Keys(_ context.Context, namespace string) ([]string, error) {
+	keys, ok := p.keys[namespace]
+	if !ok {
+		return nil, fmt.Errorf("no keys for namespace %s", namespace)
+	}
+
+	return keys, nil
+}
+
+func (p *memoryProvider) Store(_ context.Context, namespace string, keys []string) error {
+	p.keys[namespace] = keys
+	return nil
+}
+
+func (p *memoryProvider) String() string {
+	return "memory"
+}
+
+func TestReconcileWithKeyProvider(t *testing.T) {
+	fakeVault := vaulttest.NewServer()
+	defer fakeVault.Close()
+
+	clientset := fake.NewSimpleClientset()
+	c := newTestController(t, clientset, testConfig(), []*vaulttest.Server{fakeVault})
+	provider := &memoryProvider{keys: make(map[string][]string)}
+	c.SetKeyProvider(provider)
+
+	c.Reconcile(context.Background())
+
+	if fakeVault.Sealed() {
+		t.Errorf("expected vault to be unsealed after reconcile")
+	}
+	if strings.Join(provider.keys["vault"], ",") != strings.Join(fakeVault.Keys(), ",") {
+		t.Errorf("expected the keys to be stored with the provider, got %v", provider.keys)
+	}
+
+	unsealKeys, err := clientset.CoreV1().Secrets("vault").Get(context.Background(), vault.UnsealKeysSecret, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the unseal keys secret to record the seal configuration: %v", err)
+	}
+	if len(unsealKeys.Data) != 0 {
+		t.Errorf("expected no keys in the secret, got %d", len(unsealKeys.Data))
+	}
+	if unsealKeys.Annotations[vault.ThresholdAnnotation] != "3" {
+		t.Errorf("expected the threshold annotation, got %v", unsealKeys.Annotations)
+	}
+
+	// Unsealing reads the keys back from the provider, with or without the Secret
+	for _, deleteSecret := range []bool{false, true} {
+		if deleteSecret {
+			if err := clientset.CoreV1().Secrets("vault").Delete(context.Background(), vault.UnsealKeysSecret, metav1.DeleteOptions{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		fakeVault.Seal()
+
+		c.Reconcile(context.Background())
+
+		if fakeVault.Sealed() {
+			t.Errorf("expected vault to be unsealed with the provided keys (secret deleted: %v)", deleteSecret)
+		}
+	}
+}
+
+func TestReconcileThroughExternalAddress(t *testing.T) {
+	fakeVault := vaulttest.NewServer()
+	defer fakeVault.Close()
+
+	cfg := testConfig()
+	cfg.VaultExternalURL = fakeVault.URL
+	clientset := fake.NewSimpleClientset()
+	c := New(kubernetes.NewClientWithInterface(clientset), cfg, notify.Nop{})
+	externalClient, err := vault.NewExternalClient(fakeVault.URL, nil, vault.TransportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetExternalClient(externalClient)
+
+	// No pods are listed: the controller may not see the pod network at all
+	c.Reconcile(context.Background())
+
+	if fakeVault.Sealed() {
+		t.Errorf("expected vault to be unsealed through the external address")
+	}
+	pods := c.Status().Snapshot().Pods
+	if len(pods) != 1 || pods[0].Pod != fakeVault.URL || pods[0].Sealed {
+		t.Errorf("expected the external address to be reported as unsealed, got %+v", pods)
+	}
+}
+
+func TestReconcileRequiresInitAllowed(t *testing.T) {
+	fakeVault := vaulttest.NewServer()
+	defer fakeVault.Close()
+
+	cfg := testConfig()
+	cfg.InitAllowed = false
+	clientset := fake.NewSimpleClientset()
+	c := newTestController(t, clientset, cfg, []*vaulttest.Server{fakeVault})
+
+	c.Reconcile(context.Background())
+
+	if fakeVault.Initialized() {
+		t.Errorf("expected vault not to be initialized without INIT_ALLOWED")
+	}
+	if _, err := clientset.CoreV1().Secrets("vault").Get(context.Background(), vault.UnsealKeysSecret, metav1.GetOptions{}); err == nil {
+		t.Errorf("expected no unseal keys secret to be created")
+	}
+}
+
+func TestReconcileInitializesOnlyOneMember(t *testing.T) {
+	fakes := []*vaulttest.Server{vaulttest.NewServer(), vaulttest.NewServer(), vaulttest.NewServer()}
+	for _, fakeVault := range fakes {
+		defer fakeVault.Close()
+	}
+
+	clientset := fake.NewSimpleClientset()
+	c := newTestController(t, clientset, testConfig(), fakes)
+
+	c.Reconcile(context.Background())
+
+	initialized := 0
+	for _, fakeVault := range fakes {
+		if fakeVault.Initialized() {
+			initialized++
+		}
+	}
+	if initialized != 1 {
+		t.Errorf("expected exactly one member to be initialized, got %d", initialized)
+	}
+}
+
+func TestReconcileRefusesInitOnExistingCluster(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, clientset *fake.Clientset, fakes []*vaulttest.Server)
+	}{
+		{
+			name: "initialized peer",
+			setup: func(t *testing.T, clientset *fake.Clientset, fakes []*vaulttest.Server) {
+				fakes[0].Close()
+				fakes[0] = vaulttest.NewInitializedServer(5, 3)
+			},
+		},
+		{
+			name: "stored unseal keys",
+			setup: func(t *testing.T, clientset *fake.Clientset, fakes []*vaulttest.Server) {
+				storeUnsealKeys(t, clientset, []string{"abcd"})
+			},
+		},
+		{
+			name: "unreachable peer",
+			setup: func(t *testing.T, clientset *fake.Clientset, fakes []*vaulttest.Server) {
+				fakes[0].Close()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakes := []*vaulttest.Server{vaulttest.NewServer(), vaulttest.NewServer()}
+			defer func() {
+				for _, fakeVault := range fakes {
+					fakeVault.Close()
+				}
+			}()
+
+			clientset := fake.NewSimpleClientset()
+			tt.setup(t, clientset, fakes)
+			c := newTestController(t, clientset, testConfig(), fakes)
+
+			c.Reconcile(context.Background())
+
+			if fakes[1].Initialized() {
+				t.Errorf("expected init to be refused")
+			}
+		})
+	}
+}
+
+func TestReconcileUnsealsCluster(t *testing.T) {
+	fakes := vaulttest.NewCluster(3, 5, 3)
+	for _, fakeVault := range fakes {
+		defer fakeVault.Close()
+	}
+
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, fakes[0].Keys())
+	c := newTestController(t, clientset, testConfig(), fakes)
+
+	c.Reconcile(context.Background())
+
+	for i, fakeVault := range fakes {
+		if fakeVault.Sealed() {
+			t.Errorf("expected vault-%d to be unsealed after reconcile", i)
+		}
+	}
+}
+
+func TestReconcileWithBase64Keys(t *testing.T) {
+	fakeVault := vaulttest.NewServer()
+	defer fakeVault.Close()
+
+	clientset := fake.NewSimpleClientset()
+	cfg := testConfig()
+	cfg.KeyEncoding = vault.KeyEncodingBase64
+	c := newTestController(t, clientset, cfg, []*vaulttest.Server{fakeVault})
+	c.Reconcile(context.Background())
+
+	secret, err := clientset.CoreV1().Secrets("vault").Get(context.Background(), vault.UnsealKeysSecret, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected unseal keys secret: %v", err)
+	}
+	stored := string(secret.Data["key1"])
+	if stored == fakeVault.Keys()[0] || vault.HexKey(stored) != fakeVault.Keys()[0] {
+		t.Errorf("expected the first key to be stored base64 encoded, got %q", stored)
+	}
+	if encoding := secret.Annotations[vault.KeyEncodingAnnotation]; encoding != vault.KeyEncodingBase64 {
+		t.Errorf("expected the key encoding to be recorded as base64, got %q", encoding)
+	}
+
+	// Without the keys cached at init, the next pass unseals with the stored base64 keys
+	fakeVault.Seal()
+	c.unsealKeys = nil
+	c.Reconcile(context.Background())
+	if fakeVault.Sealed() {
+		t.Errorf("expected Vault to be unsealed with the base64 keys")
+	}
+}
+
+// annotateUnsealKeys sets annotations on the stored unseal keys Secret
+func annotateUnsealKeys(t testing.TB, clientset *fake.Clientset, annotations map[string]string) {
+	t.Helper()
+
+	secret, err := clientset.CoreV1().Secrets("vault").Get(context.Background(), vault.UnsealKeysSecret, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get unseal keys secret: %v", err)
+	}
+
+	secret.Annotations = annotations
+	if _, err := clientset.CoreV1().Secrets("vault").Update(context.Background(), secret, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to annotate unseal keys secret: %v", err)
+	}
+}
+
+func TestReconcileHonorsThresholdAnnotation(t *testing.T) {
+	tests := []struct {
+		name              string
+		annotations       map[string]string
+		expectedSubmitted int
+	}{
+		{
+			name:              "threshold recorded",
+			annotations:       map[string]string{vault.ThresholdAnnotation: "3", vault.SharesAnnotation: "5"},
+			expectedSubmitted: 3,
+		},
+		{
+			name:              "no threshold recorded",
+			expectedSubmitted: 3,
+		},
+		{
+			name:              "invalid threshold recorded",
+			annotations:       map[string]string{vault.ThresholdAnnotation: "three"},
+			expectedSubmitted: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeVault := vaulttest.NewInitializedServer(5, 3)
+			defer fakeVault.Close()
+
+			clientset := fake.NewSimpleClientset()
+			storeUnsealKeys(t, clientset, fakeVault.Keys())
+			annotateUnsealKeys(t, clientset, tt.annotations)
+			c := newTestController(t, clientset, testConfig(), []*vaulttest.Server{fakeVault})
+
+			c.Reconcile(context.Background())
+
+			if fakeVault.Sealed() {
+				t.Errorf("expected vault to be unsealed")
+			}
+			if submitted := fakeVault.Submitted(); submitted != tt.expectedSubmitted {
+				t.Errorf("expected %d keys to be submitted, got %d", tt.expectedSubmitted, submitted)
+			}
+		})
+	}
+}
+
+func TestReconcileReportsSealDetails(t *testing.T) {
+	fakeVault := vaulttest.NewInitializedServer(5, 3)
+	defer fakeVault.Close()
+
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, fakeVault.Keys())
+	c := newTestController(t, clientset, testConfig(), []*vaulttest.Server{fakeVault})
+
+	// The first pass unseals the pod, the second sees it unsealed
+	c.Reconcile(context.Background())
+	c.Reconcile(context.Background())
+
+	pod := c.Status().Snapshot().Pods[0]
+	if pod.SealType != "shamir" || pod.Version != "1.15.0" {
+		t.Errorf("expected the seal type and version to be reported, got %+v", pod)
+	}
+	if pod.Threshold != 3 || pod.Shares != 5 {
+		t.Errorf("expected threshold and shares 3/5 to be reported, got %d/%d", pod.Threshold, pod.Shares)
+	}
+	if pod.ClusterName == "" {
+		t.Errorf("expected the cluster name of the unsealed pod to be reported")
+	}
+}
+
+func TestReconcileReportsUnsealProgress(t *testing.T) {
+	fakeVault := vaulttest.NewInitializedServer(5, 3)
+	defer fakeVault.Close()
+
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, fakeVault.Keys()[:2])
+	c := newTestController(t, clientset, testConfig(), []*vaulttest.Server{fakeVault})
+
+	c.Reconcile(context.Background())
+
+	snapshot := c.Status().Snapshot()
+	pod := snapshot.Pods[0]
+	if !pod.Sealed || pod.UnsealProgress != 2 || pod.Threshold != 3 {
+		t.Errorf("expected the pod to be reported sealed with 2 of 3 keys applied, got %+v", pod)
+	}
+	if snapshot.CheckedAt.IsZero() {
+		t.Errorf("expected the pass to record when it checked the pods")
+	}
+	if err := snapshot.Ready(time.Minute); err == nil || !strings.Contains(err.Error(), "sealed") {
+		t.Errorf("expected the sealed pod to keep the snapshot from being ready, got %v", err)
+	}
+}
+
+func TestReconcileMissingKeysLeavesVaultSealed(t *testing.T) {
+	fakeVault := vaulttest.NewInitializedServer(5, 3)
+	defer fakeVault.Close()
+
+	clientset := fake.NewSimpleClientset()
+	c := newTestController(t, clientset, testConfig(), []*vaulttest.Server{fakeVault})
+
+	c.Reconcile(context.Background())
+
+	if !fakeVault.Sealed() {
+		t.Errorf("expected vault to stay sealed without stored keys")
+	}
+}
+
+func TestReconcileRecoversFromPanic(t *testing.T) {
+	fakeVault := vaulttest.NewInitializedServer(1, 1)
+	defer fakeVault.Close()
+
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, fakeVault.Keys())
+	c := newTestController(t, clientset, testConfig(), []*vaulttest.Server{fakeVault})
+
+	route := c.vaultAddress
+	c.vaultAddress = func(string) string {
+		panic("malformed pod address")
+	}
+	before := recovery.Panics("controller")
+
+	c.reconcileSafely(context.Background())
+
+	if got := recovery.Panics("controller") - before; got != 1 {
+		t.Errorf("expected one recovered panic, got %v", got)
+	}
+
+	// The next pass starts over and succeeds
+	c.vaultAddress = route
+	c.reconcileSafely(context.Background())
+
+	if fakeVault.Sealed() {
+		t.Errorf("expected vault to be unsealed on the pass after the panic")
+	}
+}
+
+// recordingNotifier keeps the events sent to it
+type recordingNotifier struct {
+	events []notify.Event
+}
+
+func (n *recordingNotifier) Notify(event notify.Event) error {
+	n.events = append(n.events, event)
+	return nil
+}
+
+func TestReconcileRecordsClusterIdentity(t *testing.T) {
+	fakes := vaulttest.NewCluster(2, 5, 3)
+	for _, fakeVault := range fakes {
+		defer fakeVault.Close()
+	}
+
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, fakes[0].Keys())
+	c := newTestController(t, clientset, testConfig(), fakes)
+
+	c.Reconcile(context.Background())
+
+	secret, err := clientset.CoreV1().Secrets("vault").Get(context.Background(), vault.UnsealKeysSecret, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get unseal keys secret: %v", err)
+	}
+	if secret.Annotations[vault.ClusterIDAnnotation] != fakes[0].ClusterID() {
+		t.Errorf("expected cluster ID %s to be recorded, got %v", fakes[0].ClusterID(), secret.Annotations)
+	}
+	if secret.Annotations[vault.ClusterNameAnnotation] == "" {
+		t.Errorf("expected cluster name to be recorded")
+	}
+}
+
+func TestReconcileRefusesKeysToImpostor(t *testing.T) {
+	cluster := vaulttest.NewCluster(1, 5, 3)
+	defer cluster[0].Close()
+	impostor := vaulttest.NewInitializedServer(3, 2)
+	defer impostor.Close()
+
+	cfg := testConfig()
+	cfg.VerifyClusterIdentity = true
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, cluster[0].Keys())
+	annotateUnsealKeys(t, clientset, map[string]string{
+		vault.ThresholdAnnotation: "3",
+		vault.SharesAnnotation:    "5",
+		vault.ClusterIDAnnotation: cluster[0].ClusterID(),
+	})
+	c := newTestController(t, clientset, cfg, []*vaulttest.Server{cluster[0], impostor})
+
+	c.Reconcile(context.Background())
+
+	if cluster[0].Sealed() {
+		t.Errorf("expected the genuine member to be unsealed")
+	}
+	if submitted := impostor.Submitted(); submitted != 0 {
+		t.Errorf("expected no keys to be sent to the impostor, got %d", submitted)
+	}
+}
+
+func TestReconcileNotifiesIdentityMismatch(t *testing.T) {
+	fakes := vaulttest.NewCluster(1, 1, 1)
+	defer fakes[0].Close()
+
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, fakes[0].Keys())
+	annotateUnsealKeys(t, clientset, map[string]string{vault.ClusterIDAnnotation: "another-cluster"})
+	c := newTestController(t, clientset, testConfig(), fakes)
+	notifier := &recordingNotifier{}
+	c.notifier = notifier
+
+	c.Reconcile(context.Background())
+
+	if len(notifier.events) == 0 || notifier.events[0].Type != notify.EventIdentityMismatch || notifier.events[0].Reason != reason.IdentityMismatch {
+		t.Errorf("expected an identity mismatch notification, got %+v", notifier.events)
+	}
+}
+
+func TestReconcileNotifiesSealTransitions(t *testing.T) {
+	fakes := vaulttest.NewCluster(1, 5, 3)
+	defer fakes[0].Close()
+
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, fakes[0].Keys())
+	c := newTestController(t, clientset, testConfig(), fakes)
+	notifier := &recordingNotifier{}
+	c.notifier = notifier
+
+	c.Reconcile(context.Background())
+	c.Reconcile(context.Background())
+	fakes[0].Seal()
+	c.Reconcile(context.Background())
+
+	var got []string
+	for _, event := range notifier.events {
+		got = append(got, event.Type)
+	}
+	want := []string{notify.EventUnsealed, notify.EventSealed, notify.EventUnsealed}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("expected notifications %v, got %v", want, got)
+	}
+	if len(notifier.events) == 3 && notifier.events[1].Reason != reason.Sealed {
+		t.Errorf("expected reason %s for the seal notification, got %s", reason.Sealed, notifier.events[1].Reason)
+	}
+}
+
+// recordingHook records the phases and pods it runs for and fails with err
+type recordingHook struct {
+	ran []string
+	err error
+}
+
+func (h *recordingHook) Run(_ context.Context, hc hooks.Context) error {
+	h.ran = append(h.ran, string(hc.Phase)+" "+hc.Pod)
+	return h.err
+}
+
+func (h *recordingHook) String() string {
+	return "recording"
+}
+
+func TestReconcileRunsActionHooks(t *testing.T) {
+	fakeVault := vaulttest.NewServer()
+	defer fakeVault.Close()
+
+	clientset := fake.NewSimpleClientset()
+	c := newTestController(t, clientset, testConfig(), []*vaulttest.Server{fakeVault})
+	hook := &recordingHook{}
+	c.SetHooks(hooks.Hooks{
+		hooks.PreInit:    {hook},
+		hooks.PostInit:   {hook},
+		hooks.PreUnseal:  {hook},
+		hooks.PostUnseal: {hook},
+	})
+
+	c.Reconcile(context.Background())
+
+	want := []string{"pre-init 10.0.0.1", "post-init 10.0.0.1", "pre-unseal 10.0.0.1", "post-unseal 10.0.0.1"}
+	if strings.Join(hook.ran, ",") != strings.Join(want, ",") {
+		t.Errorf("expected hooks %v, got %v", want, hook.ran)
+	}
+	if fakeVault.Sealed() {
+		t.Errorf("expected vault to be unsealed")
+	}
+}
+
+func TestReconcileFailingPreHookStopsAction(t *testing.T) {
+	fakes := vaulttest.NewCluster(1, 5, 3)
+	defer fakes[0].Close()
+
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, fakes[0].Keys())
+	c := newTestController(t, clientset, testConfig(), fakes)
+	gate := &recordingHook{err: fmt.Errorf("change freeze")}
+	post := &recordingHook{}
+	c.SetHooks(hooks.Hooks{hooks.PreUnseal: {gate}, hooks.PostUnseal: {post}})
+
+	c.Reconcile(context.Background())
+
+	if !fakes[0].Sealed() {
+		t.Errorf("expected vault to stay sealed when the pre-unseal hook fails")
+	}
+	if len(post.ran) != 0 {
+		t.Errorf("expected no post-unseal hook, got %v", post.ran)
+	}
+	if snapshot := c.Status().Snapshot(); snapshot.Pods[0].Reason != reason.HookFailed {
+		t.Errorf("expected reason %s, got %+v", reason.HookFailed, snapshot.Pods[0])
+	}
+}
+
+func TestReconcileRendersConsumptionObjects(t *testing.T) {
+	fakes := vaulttest.NewCluster(1, 5, 3)
+	defer fakes[0].Close()
+
+	dir := t.TempDir()
+	template := "kind: ConfigMap\nmetadata:\n  name: vault-connection\ndata:\n  VAULT_ADDR: {{ .VaultAddr }}\n  cluster: {{ .ClusterID }}\n"
+	if err := os.WriteFile(filepath.Join(dir, "connection.yaml"), []byte(template), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	renderer, err := render.Load(dir)
+	if err != nil {
+		t.Fatalf("failed to load templates: %v", err)
+	}
+
+	cfg := testConfig()
+	cfg.VaultService = "vault"
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, fakes[0].Keys())
+	c := newTestController(t, clientset, cfg, fakes)
+	c.SetRenderer(renderer)
+
+	c.Reconcile(context.Background())
+	c.Reconcile(context.Background())
+
+	cm, err := clientset.CoreV1().ConfigMaps("vault").Get(context.Background(), "vault-connection", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the config map to be rendered: %v", err)
+	}
+	if cm.Data["VAULT_ADDR"] != "http://vault.vault.svc:8200" || cm.Data["cluster"] != fakes[0].ClusterID() {
+		t.Errorf("unexpected rendered data %v", cm.Data)
+	}
+
+	writes := 0
+	for _, action := range clientset.Actions() {
+		if action.GetResource().Resource == "configmaps" && (action.GetVerb() == "create" || action.GetVerb() == "update") {
+			writes++
+		}
+	}
+	if writes != 1 {
+		t.Errorf("expected the unchanged config map to be written once, got %d writes", writes)
+	}
+}
+
+func TestPublishCA(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	cfg := testConfig()
+	cfg.CAConfigMap = "vault-ca"
+	cfg.CAConfigMapNamespaces = []string{"vault", "apps"}
+	clientset := fake.NewSimpleClientset()
+	c := newTestController(t, clientset, cfg, nil)
+	c.vaultAddress = func(podIP string) string {
+		return server.URL
+	}
+
+	statuses := map[string]*vault.Status{"10.0.0.1": {Initialized: true}}
+	c.publishCA(context.Background(), statuses)
+	c.publishCA(context.Background(), statuses)
+
+	expected := vault.EncodeCABundle([]*x509.Certificate{server.Certificate()})
+	for _, namespace := range cfg.CAConfigMapNamespaces {
+		cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(context.Background(), "vault-ca", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("expected the CA config map in namespace %s: %v", namespace, err)
+		}
+		if cm.Data["ca.crt"] != expected {
+			t.Errorf("unexpected CA bundle in namespace %s: %q", namespace, cm.Data["ca.crt"])
+		}
+		if cm.Labels[caBundleLabel] != "true" {
+			t.Errorf("expected the CA bundle label in namespace %s, got %v", namespace, cm.Labels)
+		}
+	}
+
+	writes := 0
+	for _, action := range clientset.Actions() {
+		if action.GetResource().Resource == "configmaps" && (action.GetVerb() == "create" || action.GetVerb() == "update") {
+			writes++
+		}
+	}
+	if writes != 2 {
+		t.Errorf("expected the unchanged bundle to be written once per namespace, got %d writes", writes)
+	}
+}
+
+func TestReconcileDiagnosesUnreachablePod(t *testing.T) {
+	fakes := vaulttest.NewCluster(2, 1, 1)
+	defer fakes[0].Close()
+	fakes[1].Close()
+
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, fakes[0].Keys())
+	c := newTestController(t, clientset, testConfig(), fakes)
+	timedBefore := checkDuration.Count("10.0.0.2", "", "", "error")
+
+	c.Reconcile(context.Background())
+	c.Reconcile(context.Background())
+
+	snapshot := c.Status().Snapshot()
+	if len(snapshot.Pods) != 2 {
+		t.Fatalf("expected both pods in the status, got %+v", snapshot.Pods)
+	}
+	if !snapshot.Pods[0].Reachable || snapshot.Pods[0].Sealed || !snapshot.Pods[0].Initialized {
+		t.Errorf("expected the reachable pod to be reported unsealed, got %+v", snapshot.Pods[0])
+	}
+
+	unreachable := snapshot.Pods[1]
+	if unreachable.Reachable || unreachable.Diagnosis == nil || unreachable.Diagnosis.Failed != vault.StageTCP {
+		t.Fatalf("expected a tcp diagnosis for the unreachable pod, got %+v", unreachable)
+	}
+	if got := unsealedFraction.Value(); got != 0.5 {
+		t.Errorf("expected an unsealed fraction of 0.5, got %v", got)
+	}
+	if podsTotal.Value() != 2 || podsUnsealed.Value() != 1 {
+		t.Errorf("expected 1 of 2 pods unsealed, got %v of %v", podsUnsealed.Value(), podsTotal.Value())
+	}
+	reachable := snapshot.Pods[0]
+	if reachable.SealType == "" || reachable.Version == "" {
+		t.Errorf("expected the seal type and version of the reachable pod to be known, got %+v", reachable)
+	}
+	if podSealed.Value(reachable.Pod, reachable.SealType, reachable.Version) != 0 || checkDuration.Count(reachable.Pod, reachable.SealType, reachable.Version, "ok") == 0 {
+		t.Errorf("expected the series of the reachable pod to carry its seal type and version")
+	}
+	if timed := checkDuration.Count(unreachable.Pod, "", "", "error") - timedBefore; timed != 2 {
+		t.Errorf("expected both failed checks of the unreachable pod to be timed, got %d", timed)
+	}
+	if unreachable.Reason != reason.VaultUnreachable {
+		t.Errorf("expected reason %s for the unreachable pod, got %s", reason.VaultUnreachable, unreachable.Reason)
+	}
+
+	events, err := clientset.CoreV1().Events("vault").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list events: %v", err)
+	}
+	if len(events.Items) != 1 {
+		t.Fatalf("expected one event across both passes, got %d", len(events.Items))
+	}
+	if events.Items[0].InvolvedObject.Name != "vault-1" || events.Items[0].Reason != unreachableReason {
+		t.Errorf("unexpected event %+v", events.Items[0])
+	}
+	if code := events.Items[0].Annotations[reason.Annotation]; code != string(reason.VaultUnreachable) {
+		t.Errorf("expected the event to carry reason code %s, got %q", reason.VaultUnreachable, code)
+	}
+}
+
+func TestReconcileStopsWhenCancelled(t *testing.T) {
+	fakes := vaulttest.NewCluster(2, 1, 1)
+	defer fakes[0].Close()
+	defer fakes[1].Close()
+
+	// The first pod accepts the connection but never answers
+	release := make(chan struct{})
+	stuck := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer stuck.Close()
+	defer close(release)
+
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, fakes[0].Keys())
+	c := newTestController(t, clientset, testConfig(), fakes)
+	route := c.vaultAddress
+	c.vaultAddress = func(podIP string) string {
+		if podIP == "10.0.0.1" {
+			return stuck.URL
+		}
+
+		return route(podIP)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		c.Reconcile(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the pass to end when its context was cancelled")
+	}
+
+	// The interrupted pass neither acts on the other pods nor reports the stuck one unreachable
+	if !fakes[1].Sealed() {
+		t.Errorf("expected the pass to stop before unsealing the next pod")
+	}
+	events, err := clientset.CoreV1().Events("vault").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list events: %v", err)
+	}
+	if len(events.Items) != 0 {
+		t.Errorf("expected no events for an interrupted check, got %d", len(events.Items))
+	}
+}
+
+func TestReconcileRecordsReasonCodes(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, clientset *fake.Clientset, cfg *config.Config) *vaulttest.Server
+		want  reason.Code
+	}{
+		{
+			name: "init not allowed",
+			setup: func(t *testing.T, clientset *fake.Clientset, cfg *config.Config) *vaulttest.Server {
+				cfg.InitAllowed = false
+				return vaulttest.NewServer()
+			},
+			want: reason.InitNotAllowed,
+		},
+		{
+			name: "action lock held by a CLI run",
+			setup: func(t *testing.T, clientset *fake.Clientset, cfg *config.Config) *vaulttest.Server {
+				lock, err := kubernetes.NewClientWithInterface(clientset).AcquireActionLock(cfg.VaultNamespace, kubernetes.ActionMigrate, "vault-utils-cli/ops@laptop")
+				if err != nil {
+					t.Fatalf("failed to take action lock: %v", err)
+				}
+				t.Cleanup(func() { lock.Release() })
+				return vaulttest.NewServer()
+			},
+			want: reason.ActionLocked,
+		},
+		{
+			name: "unseal keys secret missing",
+			setup: func(t *testing.T, clientset *fake.Clientset, cfg *config.Config) *vaulttest.Server {
+				return vaulttest.NewInitializedServer(5, 3)
+			},
+			want: reason.UnsealKeysUnavailable,
+		},
+		{
+			name: "no unseal keys stored",
+			setup: func(t *testing.T, clientset *fake.Clientset, cfg *config.Config) *vaulttest.Server {
+				storeUnsealKeys(t, clientset, nil)
+				return vaulttest.NewInitializedServer(5, 3)
+			},
+			want: reason.UnsealNoKeys,
+		},
+		{
+			name: "keys of another cluster",
+			setup: func(t *testing.T, clientset *fake.Clientset, cfg *config.Config) *vaulttest.Server {
+				other := vaulttest.NewInitializedServer(5, 3)
+				defer other.Close()
+				storeUnsealKeys(t, clientset, other.Keys())
+				return vaulttest.NewInitializedServer(5, 3)
+			},
+			want: reason.UnsealInvalidKey,
+		},
+		{
+			name: "fewer keys than the threshold",
+			setup: func(t *testing.T, clientset *fake.Clientset, cfg *config.Config) *vaulttest.Server {
+				fakeVault := vaulttest.NewInitializedServer(5, 3)
+				storeUnsealKeys(t, clientset, fakeVault.Keys()[:2])
+				return fakeVault
+			},
+			want: reason.UnsealIncomplete,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			clientset := fake.NewSimpleClientset()
+			fakeVault := tt.setup(t, clientset, cfg)
+			defer fakeVault.Close()
+			c := newTestController(t, clientset, cfg, []*vaulttest.Server{fakeVault})
+
+			c.Reconcile(context.Background())
+
+			snapshot := c.Status().Snapshot()
+			if len(snapshot.Pods) != 1 {
+				t.Fatalf("expected one pod in the status, got %+v", snapshot.Pods)
+			}
+			if snapshot.Pods[0].Reason != tt.want {
+				t.Errorf("expected reason %s, got %s (error %q)", tt.want, snapshot.Pods[0].Reason, snapshot.Pods[0].Error)
+			}
+		})
+	}
+}
+
+func TestVaultAddress(t *testing.T) {
+	c := New(kubernetes.NewClientWithInterface(fake.NewSimpleClientset()), testConfig(), notify.Nop{})
+
+	address, err := url.Parse(c.vaultAddress("10.0.0.1"))
+	if err != nil {
+		t.Fatalf("failed to parse address: %v", err)
+	}
+	if address.Host != "10.0.0.1:8200" {
+		t.Errorf("expected host 10.0.0.1:8200, got %s", address.Host)
+	}
+}
+
+func TestReconcileReusesVaultClients(t *testing.T) {
+	fakes := vaulttest.NewCluster(2, 1, 1)
+	for _, fakeVault := range fakes {
+		defer fakeVault.Close()
+	}
+
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, fakes[0].Keys())
+	c := newTestController(t, clientset, testConfig(), fakes)
+
+	c.Reconcile(context.Background())
+	first := c.vaultClient("10.0.0.1")
+	c.Reconcile(context.Background())
+
+	if c.vaultClient("10.0.0.1") != first {
+		t.Errorf("expected the client of a pod to be reused across passes")
+	}
+
+	if err := clientset.CoreV1().Pods("vault").Delete(context.Background(), "vault-1", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("failed to delete test pod: %v", err)
+	}
+
+	c.Reconcile(context.Background())
+
+	if c.vaultClients.Len() != 1 {
+		t.Errorf("expected the client of the deleted pod to be dropped, got %d clients", c.vaultClients.Len())
+	}
+}
+
+func TestReconcileReadsUnsealKeysOncePerPass(t *testing.T) {
+	fakes := vaulttest.NewCluster(3, 5, 3)
+	for _, fakeVault := range fakes {
+		defer fakeVault.Close()
+	}
+
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, fakes[0].Keys())
+	// The format of the keys Secrets is checked once per start, before the reads counted here
+	secret, _ := clientset.CoreV1().Secrets("vault").Get(context.Background(), vault.UnsealKeysSecret, metav1.GetOptions{})
+	secret.Annotations = map[string]string{vault.FormatAnnotation: vault.SecretFormat}
+	if _, err := clientset.CoreV1().Secrets("vault").Update(context.Background(), secret, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update unseal keys secret: %v", err)
+	}
+	c := newTestController(t, clientset, testConfig(), fakes)
+	c.upgradeKeySecrets(nil)
+	clientset.ClearActions()
+
+	countSecretReads := func() int {
+		reads := 0
+		for _, action := range clientset.Actions() {
+			if action.GetVerb() == "get" && action.GetResource().Resource == "secrets" {
+				reads++
+			}
+		}
+		return reads
+	}
+
+	c.Reconcile(context.Background())
+
+	if reads := countSecretReads(); reads != 1 {
+		t.Errorf("expected the unseal keys secret to be read once for 3 sealed pods, got %d reads", reads)
+	}
+
+	for _, fakeVault := range fakes {
+		fakeVault.Seal()
+	}
+	c.Reconcile(context.Background())
+
+	if reads := countSecretReads(); reads != 2 {
+		t.Errorf("expected the unseal keys secret to be read again in the next pass, got %d reads in total", reads)
+	}
+}
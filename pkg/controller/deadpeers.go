@@ -0,0 +1,115 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/metrics"
+	"github.com/getgrowly/vault-utils/pkg/notify"
+	"github.com/getgrowly/vault-utils/pkg/reason"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+)
+
+var raftPeersRemoved = metrics.NewCounter("vault_utils_raft_peers_removed_total",
+	"Raft peers removed by the controller because no Vault pod matched them for RAFT_DEAD_PEER_GRACE.")
+
+// removeDeadRaftPeers removes the raft peers that no Vault pod has matched for
+// RAFT_DEAD_PEER_GRACE, such as those of pods lost with their node or removed by a scale-down,
+// which still count toward quorum. It returns the peers left. The leader is never removed, and
+// nothing is removed while no Vault pod is listed, which is more likely a problem with the
+// listing than a cluster that is gone.
+func (c *Controller) removeDeadRaftPeers(ctx context.Context, pods []string, servers []vault.RaftServer) []vault.RaftServer {
+	names, err := c.k8sClient.GetVaultPodNames(c.cfg.VaultNamespace)
+	if err != nil {
+		log.Printf("Error listing Vault pods to look for dead raft peers: %v", err)
+
+		return servers
+	}
+	if len(names) == 0 {
+		return servers
+	}
+
+	live := make(map[string]bool, len(names)+len(pods))
+	for _, name := range names {
+		live[name] = true
+	}
+	for _, pod := range pods {
+		live[pod] = true
+	}
+
+	now := time.Now()
+	dead := make(map[string]time.Time)
+	var remaining []vault.RaftServer
+	for _, server := range servers {
+		if server.Leader || raftPeerLive(server, live) {
+			remaining = append(remaining, server)
+
+			continue
+		}
+
+		since, ok := c.deadRaftPeers[server.NodeID]
+		if !ok {
+			since = now
+			log.Printf("Raft peer %s at %s matches no Vault pod, removing it after %v", server.NodeID, server.Address, c.cfg.RaftDeadPeerGrace)
+		}
+		if now.Sub(since) < c.cfg.RaftDeadPeerGrace {
+			dead[server.NodeID] = since
+			remaining = append(remaining, server)
+
+			continue
+		}
+
+		if err := c.removeRaftPeer(ctx, server.NodeID); err != nil {
+			log.Printf("Error removing dead raft peer %s: %v", server.NodeID, err)
+			dead[server.NodeID] = since
+			remaining = append(remaining, server)
+
+			continue
+		}
+		raftPeersRemoved.Inc()
+		log.Printf("Audit: removed dead raft peer node-id=%s address=%s namespace=%s removed-by=%s dead-since=%s",
+			server.NodeID, server.Address, c.cfg.VaultNamespace, c.identity, since.UTC().Format(time.RFC3339))
+		c.notify(notify.EventRaftPeerRemoved, reason.RaftPeerRemoved,
+			fmt.Sprintf("raft peer %s at %s of the Vault cluster in namespace %s was removed, no Vault pod matched it since %s",
+				server.NodeID, server.Address, c.cfg.VaultNamespace, since.UTC().Format(time.RFC3339)))
+	}
+	c.deadRaftPeers = dead
+
+	return remaining
+}
+
+// raftPeerLive reports whether a raft peer belongs to a listed Vault pod: its node ID is the
+// pod name, as the common charts set it, or its address is the pod IP or a DNS name starting
+// with the pod name, such as vault-0.vault-internal
+func raftPeerLive(server vault.RaftServer, live map[string]bool) bool {
+	if live[server.NodeID] {
+		return true
+	}
+
+	host := server.Address
+	if h, _, err := net.SplitHostPort(server.Address); err == nil {
+		host = h
+	}
+	name, _, _ := strings.Cut(host, ".")
+
+	return live[host] || live[name]
+}
+
+// removeRaftPeer removes a peer from the raft cluster through the active node
+func (c *Controller) removeRaftPeer(ctx context.Context, nodeID string) error {
+	token, err := c.raftToken()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.requestTimeout())
+	defer cancel()
+
+	return c.active.Do(ctx, func(client *vault.Client) error {
+		return client.RemoveRaftPeer(ctx, token, nodeID)
+	})
+}
@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/notify"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRemoveDeadRaftPeers(t *testing.T) {
+	cluster := vaulttest.NewRaftCluster(1)
+	defer cluster[0].Close()
+	resp, err := vault.NewClient(cluster[0].URL).Initialize(context.Background())
+	assert.NoError(t, err)
+	cluster[0].SetRaftPeers([]vaulttest.RaftPeer{
+		{NodeID: "vault-0", Address: "vault-0.vault-internal:8201", Leader: true, Voter: true},
+		{NodeID: "node-a", Address: "10.0.0.1:8201", Voter: true},
+		{NodeID: "vault-1", Address: "vault-1.vault-internal:8201", Voter: true},
+	})
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, os.WriteFile(tokenFile, []byte(resp.RootToken), 0o600))
+
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, resp.Keys)
+	cfg := testConfig()
+	cfg.RaftTokenFile = tokenFile
+	cfg.RaftRemoveDeadPeers = true
+	cfg.RaftDeadPeerGrace = time.Hour
+	c := newTestController(t, clientset, cfg, cluster)
+	notifier := &recordingNotifier{}
+	c.notifier = notifier
+	removed := raftPeersRemoved.Value()
+
+	// A peer matching no pod is only removed once the grace period is over
+	c.Reconcile(context.Background())
+	assert.Len(t, c.status.Snapshot().Raft.Peers, 3)
+	assert.Contains(t, c.deadRaftPeers, "vault-1")
+	assert.NotContains(t, c.deadRaftPeers, "node-a", "a peer at a pod IP belongs to that pod")
+
+	c.deadRaftPeers["vault-1"] = time.Now().Add(-2 * time.Hour)
+	c.Reconcile(context.Background())
+	snapshot := c.status.Snapshot()
+	if assert.Len(t, snapshot.Raft.Peers, 2) {
+		assert.Equal(t, "vault-0", snapshot.Raft.Peers[0].NodeID)
+		assert.Equal(t, "node-a", snapshot.Raft.Peers[1].NodeID)
+	}
+	assert.Empty(t, c.deadRaftPeers)
+	assert.Equal(t, removed+1, raftPeersRemoved.Value())
+	var events []string
+	for _, event := range notifier.events {
+		if event.Type == notify.EventRaftPeerRemoved {
+			events = append(events, event.Message)
+		}
+	}
+	if assert.Len(t, events, 1) {
+		assert.Contains(t, events[0], "raft peer vault-1")
+	}
+
+	// The peer is gone from Vault too, so the next pass removes nothing
+	c.Reconcile(context.Background())
+	assert.Len(t, c.status.Snapshot().Raft.Peers, 2)
+	assert.Equal(t, removed+1, raftPeersRemoved.Value())
+}
+
+func TestRaftPeerLive(t *testing.T) {
+	live := map[string]bool{"vault-0": true, "10.0.0.1": true}
+	tests := []struct {
+		name   string
+		server vault.RaftServer
+		want   bool
+	}{
+		{name: "node ID is the pod name", server: vault.RaftServer{NodeID: "vault-0", Address: "elsewhere:8201"}, want: true},
+		{name: "address is the pod DNS name", server: vault.RaftServer{NodeID: "a1b2", Address: "vault-0.vault-internal:8201"}, want: true},
+		{name: "address is the pod IP", server: vault.RaftServer{NodeID: "a1b2", Address: "10.0.0.1:8201"}, want: true},
+		{name: "address without port", server: vault.RaftServer{NodeID: "a1b2", Address: "vault-0"}, want: true},
+		{name: "no matching pod", server: vault.RaftServer{NodeID: "vault-1", Address: "vault-1.vault-internal:8201"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, raftPeerLive(tt.server, live))
+		})
+	}
+}
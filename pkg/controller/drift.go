@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/getgrowly/vault-utils/pkg/metrics"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+)
+
+// driftWarnings is the source of the /status warnings about members that disagree
+const driftWarnings = "drift"
+
+// driftKinds are what the members of a cluster are expected to agree on, in the order they are
+// reported
+var driftKinds = []string{"version", "seal_type", "seal_config", "storage_type"}
+
+var clusterDrift = metrics.NewGauge("vault_utils_cluster_drift",
+	"1 when the reachable Vault pods disagree on the given property in the latest reconcile pass, such as during an upgrade or after a partial seal migration, 0 when they agree.", "kind")
+
+// driftValue returns what vaultStatus reports for kind, or an empty string when it reports
+// nothing comparable
+func driftValue(kind string, vaultStatus *vault.Status) string {
+	switch kind {
+	case "version":
+		return vaultStatus.Version
+	case "seal_type":
+		return vaultStatus.Type
+	case "seal_config":
+		// An uninitialized member has no seal configuration yet
+		if !vaultStatus.Initialized || vaultStatus.Shares == 0 {
+			return ""
+		}
+
+		return fmt.Sprintf("%d of %d", vaultStatus.Threshold, vaultStatus.Shares)
+	case "storage_type":
+		return vaultStatus.StorageType
+	}
+
+	return ""
+}
+
+// checkDrift compares the Vault version and the seal and storage configuration of the reachable
+// pods and warns when they disagree. Members on different versions or seals are a common cause
+// of subtle HA problems, expected only while an upgrade or seal migration rolls out.
+func (c *Controller) checkDrift(statuses map[string]*vault.Status) {
+	pods := make([]string, 0, len(statuses))
+	for pod := range statuses {
+		pods = append(pods, pod)
+	}
+	sort.Strings(pods)
+
+	var warnings []string
+	for _, kind := range driftKinds {
+		byValue := make(map[string][]string)
+		for _, pod := range pods {
+			if value := driftValue(kind, statuses[pod]); value != "" {
+				byValue[value] = append(byValue[value], pod)
+			}
+		}
+
+		if len(byValue) < 2 {
+			clusterDrift.Set(0, kind)
+
+			continue
+		}
+		clusterDrift.Set(1, kind)
+
+		values := make([]string, 0, len(byValue))
+		for value := range byValue {
+			values = append(values, value)
+		}
+		sort.Strings(values)
+		groups := make([]string, 0, len(values))
+		for _, value := range values {
+			groups = append(groups, fmt.Sprintf("%s on %s", value, strings.Join(byValue[value], ", ")))
+		}
+		warnings = append(warnings, fmt.Sprintf("Vault pods disagree on %s: %s", strings.ReplaceAll(kind, "_", " "), strings.Join(groups, "; ")))
+	}
+
+	c.status.SetWarnings(driftWarnings, warnings)
+
+	summary := strings.Join(warnings, "\n")
+	if summary == c.drift {
+		return
+	}
+	c.drift = summary
+
+	if summary == "" {
+		log.Printf("Vault pods agree on their version and seal and storage configuration again")
+
+		return
+	}
+	for _, warning := range warnings {
+		log.Printf("Warning: %s", warning)
+	}
+}
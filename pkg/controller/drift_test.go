@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReconcileReportsDrift(t *testing.T) {
+	fakes := vaulttest.NewCluster(3, 1, 1)
+	for _, fakeVault := range fakes {
+		defer fakeVault.Close()
+	}
+	fakes[2].SetVersion("1.16.1")
+
+	c := newTestController(t, fake.NewSimpleClientset(), testConfig(), fakes)
+
+	c.Reconcile(context.Background())
+
+	assert.Equal(t, []string{"Vault pods disagree on version: 1.15.0 on 10.0.0.1, 10.0.0.2; 1.16.1 on 10.0.0.3"}, c.status.Snapshot().Warnings)
+	assert.Equal(t, float64(1), clusterDrift.Value("version"))
+	assert.Equal(t, float64(0), clusterDrift.Value("seal_type"))
+	assert.Equal(t, float64(0), clusterDrift.Value("seal_config"))
+
+	fakes[2].SetVersion("")
+	c.Reconcile(context.Background())
+
+	assert.Empty(t, c.status.Snapshot().Warnings, "the warning should clear once the versions agree")
+	assert.Equal(t, float64(0), clusterDrift.Value("version"))
+	assert.Empty(t, c.drift)
+}
+
+func TestReconcileReportsSealDrift(t *testing.T) {
+	fakes := []*vaulttest.Server{vaulttest.NewInitializedServer(5, 3), vaulttest.NewAutoUnsealServer(5, 2)}
+	for _, fakeVault := range fakes {
+		defer fakeVault.Close()
+	}
+
+	c := newTestController(t, fake.NewSimpleClientset(), testConfig(), fakes)
+
+	c.Reconcile(context.Background())
+
+	assert.Equal(t, []string{
+		"Vault pods disagree on seal type: awskms on 10.0.0.2; shamir on 10.0.0.1",
+		"Vault pods disagree on seal config: 2 of 5 on 10.0.0.2; 3 of 5 on 10.0.0.1",
+	}, c.status.Snapshot().Warnings)
+	assert.Equal(t, float64(1), clusterDrift.Value("seal_type"))
+	assert.Equal(t, float64(1), clusterDrift.Value("seal_config"))
+}
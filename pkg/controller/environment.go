@@ -0,0 +1,40 @@
+package controller
+
+import (
+	"log"
+
+	"github.com/getgrowly/vault-utils/pkg/environment"
+	"github.com/getgrowly/vault-utils/pkg/metrics"
+)
+
+// environmentWarnings is the source of the /status warnings about the runtime environment
+const environmentWarnings = "environment"
+
+var environmentExposure = metrics.NewGauge("vault_utils_environment_exposure",
+	"Whether a runtime condition could write the key material held in memory to disk, by check.", "check")
+
+// checkEnvironment reports, once at startup, conditions of the process and node under which the
+// unseal keys and root token the controller holds in memory could end up on disk, such as
+// enabled core dumps or swap. They depend on how the pod is deployed, so they only warn.
+func (c *Controller) checkEnvironment() {
+	c.reportEnvironment(environment.Check())
+}
+
+func (c *Controller) reportEnvironment(findings []environment.Finding) {
+	found := make(map[string]bool, len(findings))
+	warnings := make([]string, 0, len(findings))
+	for _, finding := range findings {
+		found[finding.Check] = true
+		warnings = append(warnings, finding.Message)
+		log.Printf("Warning: %s", finding.Message)
+	}
+	for _, check := range environment.Checks {
+		value := 0.0
+		if found[check] {
+			value = 1
+		}
+		environmentExposure.Set(value, check)
+	}
+
+	c.status.SetWarnings(environmentWarnings, warnings)
+}
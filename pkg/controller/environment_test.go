@@ -0,0 +1,22 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/getgrowly/vault-utils/pkg/environment"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReportEnvironment(t *testing.T) {
+	c := newTestController(t, fake.NewSimpleClientset(), testConfig(), nil)
+
+	c.reportEnvironment([]environment.Finding{{Check: environment.CoreDumps, Message: "core dumps are enabled"}})
+	assert.Equal(t, []string{"core dumps are enabled"}, c.status.Snapshot().Warnings)
+	assert.Equal(t, 1.0, environmentExposure.Value(environment.CoreDumps))
+	assert.Equal(t, 0.0, environmentExposure.Value(environment.Swap))
+
+	c.reportEnvironment(nil)
+	assert.Empty(t, c.status.Snapshot().Warnings)
+	assert.Equal(t, 0.0, environmentExposure.Value(environment.CoreDumps))
+}
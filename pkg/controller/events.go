@@ -0,0 +1,40 @@
+package controller
+
+import (
+	"log"
+
+	"github.com/getgrowly/vault-utils/pkg/metrics"
+)
+
+// Events external systems report through the event receiver, or found in the audit log. Others
+// are counted as "other".
+const (
+	EventRestarted   = "restarted"
+	EventSealed      = "sealed"
+	EventSteppedDown = "stepped_down"
+)
+
+var eventsReceived = metrics.NewCounter("vault_utils_events_received_total",
+	"Events about Vault pods reported through the event receiver or found in the audit log, by event.", "event")
+
+// ReportEvent has the next pass start right away instead of after the check interval, because
+// an external system, such as Vault's audit or telemetry pipeline, reported that pod restarted
+// or sealed. It returns false when a pass was already requested and not started yet, events
+// arriving in a burst are folded into a single pass.
+func (c *Controller) ReportEvent(pod, event string) bool {
+	switch event {
+	case EventRestarted, EventSealed, EventSteppedDown:
+		eventsReceived.Inc(event)
+	default:
+		eventsReceived.Inc("other")
+	}
+
+	select {
+	case c.events <- pod:
+		log.Printf("Vault pod %s reported %s, reconciling immediately", pod, event)
+
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,28 @@
+package controller
+
+import (
+	"testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReportEventFoldsBursts(t *testing.T) {
+	c := newTestController(t, fake.NewSimpleClientset(), testConfig(), nil)
+	sealed := eventsReceived.Value(EventSealed)
+	other := eventsReceived.Value("other")
+
+	if !c.ReportEvent("vault-0", EventSealed) {
+		t.Fatalf("expected the first event to request a pass")
+	}
+	if c.ReportEvent("vault-1", "oom-killed") {
+		t.Errorf("expected an event arriving before the pass started to be folded into it")
+	}
+	if eventsReceived.Value(EventSealed) != sealed+1 || eventsReceived.Value("other") != other+1 {
+		t.Errorf("expected both events to be counted")
+	}
+
+	<-c.events
+	if !c.ReportEvent("vault-0", EventRestarted) {
+		t.Errorf("expected an event after the pass started to request another one")
+	}
+}
@@ -0,0 +1,60 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/getgrowly/vault-utils/pkg/notify"
+	"github.com/getgrowly/vault-utils/pkg/reason"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+)
+
+// foreignUnsealerWarnings is the source of the /status warnings about other unseal automation
+const foreignUnsealerWarnings = "foreign-unsealer"
+
+// detectForeignUnsealer looks for signs of another unseal automation acting on the cluster,
+// such as bank-vaults or vault-init left running during a migration, and reports them when they
+// change. Two automations submitting keys interleave their unseal attempts and reset each
+// other's progress. It returns whether init and unseal should pause for this pass.
+func (c *Controller) detectForeignUnsealer(statuses map[string]*vault.Status) bool {
+	var signs []string
+	if c.external == nil {
+		found, err := c.k8sClient.ForeignUnsealerSigns(c.cfg.VaultNamespace)
+		if err != nil {
+			log.Printf("Error looking for other unseal automation: %v", err)
+		}
+		signs = found
+	}
+
+	// An unseal attempt the controller did not start means someone else is submitting keys
+	pods := make([]string, 0, len(statuses))
+	for pod := range statuses {
+		pods = append(pods, pod)
+	}
+	sort.Strings(pods)
+	for _, pod := range pods {
+		vaultStatus := statuses[pod]
+		if vaultStatus.Sealed && vaultStatus.Progress > 0 && vaultStatus.Nonce != c.unsealNonces[pod] {
+			signs = append(signs, fmt.Sprintf("pod %s has an unseal attempt in progress that the controller did not start (%d of %d keys, nonce %s)",
+				pod, vaultStatus.Progress, vaultStatus.Threshold, vaultStatus.Nonce))
+		}
+	}
+
+	c.status.SetWarnings(foreignUnsealerWarnings, signs)
+
+	summary := strings.Join(signs, "; ")
+	if summary != c.foreignSigns {
+		if summary != "" {
+			log.Printf("Warning: another unseal automation appears to act on this cluster, stop it before relying on this controller: %s", summary)
+			c.notify(notify.EventForeignUnsealer, reason.ForeignUnsealer,
+				fmt.Sprintf("another unseal automation appears to act on namespace %s: %s", c.cfg.VaultNamespace, summary))
+		} else {
+			log.Printf("Signs of another unseal automation are gone")
+		}
+		c.foreignSigns = summary
+	}
+
+	return summary != "" && c.cfg.ForeignUnsealerAction == "pause"
+}
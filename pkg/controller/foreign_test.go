@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/getgrowly/vault-utils/pkg/notify"
+	"github.com/getgrowly/vault-utils/pkg/reason"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReconcileDetectsForeignUnsealer(t *testing.T) {
+	tests := []struct {
+		name         string
+		action       string
+		expectSealed bool
+	}{
+		{name: "warn keeps unsealing", action: "warn", expectSealed: false},
+		{name: "pause leaves vault alone", action: "pause", expectSealed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakes := vaulttest.NewCluster(1, 5, 3)
+			defer fakes[0].Close()
+
+			cfg := testConfig()
+			cfg.ForeignUnsealerAction = tt.action
+			clientset := fake.NewSimpleClientset(&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "bank-vaults", Namespace: "vault"},
+				Data:       map[string][]byte{"vault-unseal-0": []byte(fakes[0].Keys()[0])},
+			})
+			storeUnsealKeys(t, clientset, fakes[0].Keys())
+			c := newTestController(t, clientset, cfg, fakes)
+			notifier := &recordingNotifier{}
+			c.notifier = notifier
+
+			c.Reconcile(context.Background())
+			c.Reconcile(context.Background())
+
+			if fakes[0].Sealed() != tt.expectSealed {
+				t.Errorf("expected sealed %v, got %v", tt.expectSealed, fakes[0].Sealed())
+			}
+
+			var reported int
+			for _, event := range notifier.events {
+				if event.Type == notify.EventForeignUnsealer {
+					reported++
+				}
+			}
+			if reported != 1 {
+				t.Errorf("expected the unchanged signs to be reported once, got %d reports", reported)
+			}
+
+			snapshot := c.Status().Snapshot()
+			if len(snapshot.Warnings) != 1 || !strings.Contains(snapshot.Warnings[0], "secret bank-vaults") {
+				t.Errorf("expected the sign in the status warnings, got %v", snapshot.Warnings)
+			}
+			if paused := snapshot.Pods[0].Reason == reason.ForeignUnsealer; paused != (tt.action == "pause") {
+				t.Errorf("unexpected reason %q for action %s", snapshot.Pods[0].Reason, tt.action)
+			}
+		})
+	}
+}
+
+func TestDetectForeignUnsealAttempt(t *testing.T) {
+	fakes := vaulttest.NewCluster(1, 5, 3)
+	defer fakes[0].Close()
+
+	clientset := fake.NewSimpleClientset()
+	c := newTestController(t, clientset, testConfig(), fakes)
+
+	// Someone else submits a key
+	if _, err := vault.NewClient(fakes[0].URL).UnsealWithKey(context.Background(), fakes[0].Keys()[0]); err != nil {
+		t.Fatalf("failed to submit a key: %v", err)
+	}
+	vaultStatus, err := vault.NewClient(fakes[0].URL).CheckStatus(context.Background())
+	if err != nil {
+		t.Fatalf("failed to check status: %v", err)
+	}
+
+	if c.detectForeignUnsealer(map[string]*vault.Status{"10.0.0.1": vaultStatus}) {
+		t.Errorf("expected the default action not to pause")
+	}
+	if !strings.Contains(c.foreignSigns, "unseal attempt in progress") {
+		t.Errorf("expected the foreign attempt to be reported, got %q", c.foreignSigns)
+	}
+
+	// An attempt the controller left in progress itself is not a sign
+	c.unsealNonces["10.0.0.1"] = vaultStatus.Nonce
+	c.detectForeignUnsealer(map[string]*vault.Status{"10.0.0.1": vaultStatus})
+	if c.foreignSigns != "" {
+		t.Errorf("expected the controller's own attempt not to be reported, got %q", c.foreignSigns)
+	}
+}
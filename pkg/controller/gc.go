@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"log"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/metrics"
+)
+
+var garbageCollected = metrics.NewCounter("vault_utils_garbage_collected_total",
+	"Artifacts of Vault pods that no longer exist removed by the controller, by event or unseal_nonce.", "kind")
+
+// forgetUnsealNonces drops the nonces of the unseal attempts left on pods that are no longer
+// listed, which would otherwise be kept, and saved to the state file, for good
+func (c *Controller) forgetUnsealNonces(pods []string) {
+	listed := make(map[string]bool, len(pods))
+	for _, pod := range pods {
+		listed[pod] = true
+	}
+
+	for pod := range c.unsealNonces {
+		if !listed[pod] {
+			delete(c.unsealNonces, pod)
+			garbageCollected.Inc("unseal_nonce")
+		}
+	}
+}
+
+// collectGarbage deletes the Events recorded on Vault pods that no longer exist, every
+// GC_INTERVAL. On clusters where pods are replaced often they would otherwise pile up until the
+// API server expires them, cluttering kubectl get events and the namespace's Event quota.
+func (c *Controller) collectGarbage() {
+	if c.cfg.GCInterval == 0 || c.external != nil || time.Since(c.lastGC) < c.cfg.GCInterval {
+		return
+	}
+	c.lastGC = time.Now()
+
+	deleted, err := c.k8sClient.DeleteOrphanedPodEvents(c.cfg.VaultNamespace)
+	if deleted > 0 {
+		garbageCollected.Add(float64(deleted), "event")
+		log.Printf("Deleted %d Events of Vault pods that no longer exist", deleted)
+	}
+	if err != nil {
+		log.Printf("Error deleting the Events of Vault pods that no longer exist: %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	fakeVault := vaulttest.NewInitializedServer(3, 2)
+	defer fakeVault.Close()
+
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, fakeVault.Keys())
+	cfg := testConfig()
+	cfg.GCInterval = time.Hour
+	c := newTestController(t, clientset, cfg, []*vaulttest.Server{fakeVault})
+
+	orphan := func(name string) {
+		t.Helper()
+		_, err := clientset.CoreV1().Events("vault").Create(context.Background(), &corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: name, Namespace: "vault"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Namespace: "vault", Name: "vault-9", UID: "gone"},
+			Source:         corev1.EventSource{Component: "vault-utils"},
+		}, metav1.CreateOptions{})
+		assert.NoError(t, err)
+	}
+	countEvents := func() int {
+		events, err := clientset.CoreV1().Events("vault").List(context.Background(), metav1.ListOptions{})
+		assert.NoError(t, err)
+
+		return len(events.Items)
+	}
+
+	orphan("vault-9.first")
+	c.unsealNonces["10.0.0.9"] = "nonce-of-a-gone-pod"
+	c.Reconcile(context.Background())
+	assert.Equal(t, 0, countEvents(), "the Events of a pod that no longer exists should be deleted")
+	assert.NotContains(t, c.unsealNonces, "10.0.0.9")
+
+	// Events are only collected once per interval
+	orphan("vault-9.second")
+	c.Reconcile(context.Background())
+	assert.Equal(t, 1, countEvents())
+
+	c.lastGC = time.Now().Add(-time.Hour)
+	c.Reconcile(context.Background())
+	assert.Equal(t, 0, countEvents())
+}
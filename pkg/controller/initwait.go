@@ -0,0 +1,33 @@
+package controller
+
+import (
+	"fmt"
+)
+
+// initPodsReady returns an error while fewer Vault pods are Running than INIT_WAIT_FOR_PODS
+// asks for. Initializing the first pod of a new HA cluster before the others are up leaves
+// raft members with no cluster to join until they are restarted.
+func (c *Controller) initPodsReady() error {
+	if c.cfg.InitWaitForPods == "" {
+		return nil
+	}
+
+	replicas := 0
+	if c.cfg.InitWaitForPods == "replicas" || c.cfg.InitWaitForPods == "quorum" {
+		var err error
+		if replicas, err = c.statefulSetReplicas(); err != nil {
+			return fmt.Errorf("the expected number of Vault pods is unknown: %v", err)
+		}
+	}
+	wanted := c.cfg.InitPodsWanted(replicas)
+
+	running, err := c.k8sClient.CountRunningVaultPods(c.cfg.VaultNamespace)
+	if err != nil {
+		return err
+	}
+	if running < wanted {
+		return fmt.Errorf("%d Vault pods are Running, INIT_WAIT_FOR_PODS=%s waits for %d", running, c.cfg.InitWaitForPods, wanted)
+	}
+
+	return nil
+}
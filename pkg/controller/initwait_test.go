@@ -0,0 +1,66 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/getgrowly/vault-utils/pkg/reason"
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestInitWaitsForPods(t *testing.T) {
+	fakes := vaulttest.NewRaftCluster(3)
+	for _, fakeVault := range fakes {
+		defer fakeVault.Close()
+	}
+
+	replicas := int32(3)
+	clientset := fake.NewSimpleClientset(&appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "vault", Namespace: "vault"},
+		Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+	})
+	cfg := testConfig()
+	cfg.VaultStatefulSet = "vault"
+	cfg.InitWaitForPods = "replicas"
+	c := newTestController(t, clientset, cfg, fakes)
+
+	setRunning := func(name string) {
+		t.Helper()
+		pod, err := clientset.CoreV1().Pods("vault").Get(context.Background(), name, metav1.GetOptions{})
+		assert.NoError(t, err)
+		pod.Status.Phase = corev1.PodRunning
+		_, err = clientset.CoreV1().Pods("vault").UpdateStatus(context.Background(), pod, metav1.UpdateOptions{})
+		assert.NoError(t, err)
+	}
+
+	// Two of three replicas Running is not enough
+	setRunning("vault-0")
+	setRunning("vault-1")
+	c.Reconcile(context.Background())
+	assert.False(t, fakes[0].Initialized())
+	assert.Equal(t, reason.InitWaitingForPods, c.status.Snapshot().Pods[0].Reason)
+
+	// A quorum of them is
+	cfg.InitWaitForPods = "quorum"
+	c.Reconcile(context.Background())
+	assert.True(t, fakes[0].Initialized())
+}
+
+func TestInitPodsReady(t *testing.T) {
+	cfg := testConfig()
+	cfg.InitWaitForPods = "2"
+	c := newTestController(t, fake.NewSimpleClientset(), cfg, nil)
+	assert.ErrorContains(t, c.initPodsReady(), "0 Vault pods are Running, INIT_WAIT_FOR_PODS=2 waits for 2")
+
+	// Waiting for replicas needs the StatefulSet
+	cfg.InitWaitForPods = "replicas"
+	assert.ErrorContains(t, c.initPodsReady(), "expected number of Vault pods is unknown")
+
+	cfg.InitWaitForPods = ""
+	assert.NoError(t, c.initPodsReady())
+}
@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"log"
+	"slices"
+
+	"github.com/getgrowly/vault-utils/pkg/state"
+)
+
+// cachedUnsealKeys is what the key cache holds
+type cachedUnsealKeys struct {
+	Keys        []string `json:"keys"`
+	Threshold   int      `json:"threshold,omitempty"`
+	Shares      int      `json:"shares,omitempty"`
+	ClusterID   string   `json:"cluster_id,omitempty"`
+	ClusterName string   `json:"cluster_name,omitempty"`
+}
+
+// SetKeyCache keeps a copy of the unseal keys in f, encrypted, and unseals with it when neither
+// the unseal keys Secret nor the key provider can be read, such as during a cold datacenter
+// start when the Kubernetes API or the key backend comes up after Vault
+func (c *Controller) SetKeyCache(f *state.File) error {
+	var cached cachedUnsealKeys
+	if err := f.Load(&cached); err != nil {
+		return err
+	}
+
+	c.keyCache = f
+	if len(cached.Keys) > 0 {
+		c.cachedKeys = &cached
+	}
+
+	return nil
+}
+
+// cacheUnsealKeys saves the unseal keys just loaded to the key cache when they changed. Keys
+// encrypted to operators' PGP keys cannot unseal and are not cached.
+func (c *Controller) cacheUnsealKeys() {
+	if c.keyCache == nil || c.unsealKeys.encrypted || len(c.unsealKeys.keys) == 0 {
+		return
+	}
+
+	current := cachedUnsealKeys{
+		Keys:        c.unsealKeys.keys,
+		Threshold:   c.unsealKeys.threshold,
+		Shares:      c.unsealKeys.shares,
+		ClusterID:   c.unsealKeys.clusterID,
+		ClusterName: c.unsealKeys.clusterName,
+	}
+	if c.cachedKeys != nil && equalCachedKeys(*c.cachedKeys, current) {
+		return
+	}
+
+	if err := c.keyCache.Save(current); err != nil {
+		log.Printf("Error saving the unseal keys to the key cache %s: %v", c.keyCache.Path(), err)
+
+		return
+	}
+	c.cachedKeys = &current
+}
+
+// loadCachedUnsealKeys falls back to the key cache after the unseal keys could not be read,
+// returning loadErr when nothing is cached
+func (c *Controller) loadCachedUnsealKeys(loadErr error) ([]string, error) {
+	if c.cachedKeys == nil {
+		return nil, loadErr
+	}
+
+	log.Printf("Warning: unsealing with the keys in the key cache %s: %v", c.keyCache.Path(), loadErr)
+	c.unsealKeys = &unsealKeyCache{
+		keys:        c.cachedKeys.Keys,
+		threshold:   c.cachedKeys.Threshold,
+		shares:      c.cachedKeys.Shares,
+		clusterID:   c.cachedKeys.ClusterID,
+		clusterName: c.cachedKeys.ClusterName,
+	}
+
+	return c.unsealKeys.keys, nil
+}
+
+// equalCachedKeys reports whether a and b hold the same keys and seal configuration
+func equalCachedKeys(a, b cachedUnsealKeys) bool {
+	return slices.Equal(a.Keys, b.Keys) && a.Threshold == b.Threshold && a.Shares == b.Shares &&
+		a.ClusterID == b.ClusterID && a.ClusterName == b.ClusterName
+}
@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/getgrowly/vault-utils/pkg/state"
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestKeyCacheUnsealsWithoutSecret(t *testing.T) {
+	fakeVault := vaulttest.NewInitializedServer(3, 2)
+	defer fakeVault.Close()
+
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyFile, []byte(strings.Repeat("k", 32)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	openCache := func() *state.File {
+		f, err := state.Open(filepath.Join(dir, "keys"), keyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return f
+	}
+
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, fakeVault.Keys())
+	first := newTestController(t, clientset, testConfig(), []*vaulttest.Server{fakeVault})
+	if err := first.SetKeyCache(openCache()); err != nil {
+		t.Fatalf("failed to load an empty key cache: %v", err)
+	}
+	first.Reconcile(context.Background())
+	if fakeVault.Sealed() {
+		t.Fatalf("expected Vault to be unsealed with the keys in the secret")
+	}
+
+	// Vault restarts sealed while the Kubernetes API cannot serve Secrets
+	fakeVault.Seal()
+	clientset.PrependReactor("get", "secrets", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("connection refused")
+	})
+
+	restarted := New(first.k8sClient, testConfig(), first.notifier)
+	restarted.vaultAddress = first.vaultAddress
+	if err := restarted.SetKeyCache(openCache()); err != nil {
+		t.Fatalf("failed to load the key cache: %v", err)
+	}
+	restarted.Reconcile(context.Background())
+	if fakeVault.Sealed() {
+		t.Errorf("expected Vault to be unsealed with the cached keys")
+	}
+}
+
+func TestKeyCacheNotUsedWhenEmpty(t *testing.T) {
+	fakeVault := vaulttest.NewInitializedServer(3, 2)
+	defer fakeVault.Close()
+
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyFile, []byte(strings.Repeat("k", 32)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := state.Open(filepath.Join(dir, "keys"), keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientset := fake.NewSimpleClientset()
+	c := newTestController(t, clientset, testConfig(), []*vaulttest.Server{fakeVault})
+	if err := c.SetKeyCache(cache); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.loadUnsealKeys(); err == nil {
+		t.Errorf("expected the missing secret to be reported when nothing is cached")
+	}
+	if _, err := os.Stat(cache.Path()); !os.IsNotExist(err) {
+		t.Errorf("expected nothing to be cached, got %v", err)
+	}
+}
@@ -0,0 +1,43 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+)
+
+// statefulSetReplicas returns spec.replicas of the Vault StatefulSet, which defaults to 1
+func (c *Controller) statefulSetReplicas() (int, error) {
+	statefulSet, err := c.k8sClient.GetStatefulSet(c.cfg.VaultNamespace, c.cfg.VaultStatefulSet)
+	if err != nil {
+		return 0, err
+	}
+	if statefulSet.Spec.Replicas == nil {
+		return 1, nil
+	}
+
+	return int(*statefulSet.Spec.Replicas), nil
+}
+
+// recordReplicas records the replicas of the Vault StatefulSet, so the unsealed members are
+// counted against how many the cluster should have rather than how many pods exist. They are
+// unknown with an external Vault, without VAULT_STATEFULSET or when it cannot be read.
+func (c *Controller) recordReplicas() {
+	if c.cfg.VaultExternalURL != "" || c.cfg.VaultStatefulSet == "" {
+		c.status.SetReplicas(0)
+
+		return
+	}
+
+	replicas, err := c.statefulSetReplicas()
+	if err != nil {
+		if message := fmt.Sprint(err); message != c.replicasError {
+			log.Printf("Warning: the replicas of StatefulSet %s are unknown, counting the Vault pods found instead: %v", c.cfg.VaultStatefulSet, err)
+			c.replicasError = message
+		}
+		c.status.SetReplicas(0)
+
+		return
+	}
+	c.replicasError = ""
+	c.status.SetReplicas(replicas)
+}
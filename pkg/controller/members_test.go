@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReconcileCountsMembersAgainstReplicas(t *testing.T) {
+	fakes := vaulttest.NewCluster(3, 1, 1)
+	for _, fakeVault := range fakes {
+		defer fakeVault.Close()
+	}
+
+	replicas := int32(5)
+	clientset := fake.NewSimpleClientset(&appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "vault", Namespace: "vault"},
+		Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+	})
+	storeUnsealKeys(t, clientset, fakes[0].Keys())
+	cfg := testConfig()
+	cfg.VaultStatefulSet = "vault"
+	c := newTestController(t, clientset, cfg, fakes)
+
+	c.Reconcile(context.Background())
+
+	snapshot := c.Status().Snapshot()
+	assert.Equal(t, 5, snapshot.Replicas)
+	assert.Equal(t, "3/5 members unsealed", snapshot.Members)
+	assert.Equal(t, 5.0, replicasDesired.Value())
+	assert.Equal(t, 0.6, membersUnsealedFraction.Value())
+	assert.Equal(t, 1.0, unsealedFraction.Value(), "the pods found are all unsealed")
+	assert.ErrorContains(t, snapshot.Ready(cfg.CheckInterval), "only 3/5 members unsealed")
+
+	// Without the StatefulSet the pods found are the members
+	assert.NoError(t, clientset.AppsV1().StatefulSets("vault").Delete(context.Background(), "vault", metav1.DeleteOptions{}))
+	c.Reconcile(context.Background())
+
+	snapshot = c.Status().Snapshot()
+	assert.Equal(t, 0, snapshot.Replicas)
+	assert.Equal(t, "3/3 members unsealed", snapshot.Members)
+	assert.Equal(t, 1.0, membersUnsealedFraction.Value())
+	assert.NotEmpty(t, c.replicasError)
+	assert.NoError(t, snapshot.Ready(cfg.CheckInterval))
+}
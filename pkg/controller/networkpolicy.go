@@ -0,0 +1,33 @@
+package controller
+
+import (
+	"log"
+
+	"github.com/getgrowly/vault-utils/pkg/kubernetes"
+)
+
+// applyNetworkPolicy generates the NetworkPolicy admitting the controller to the Vault pods,
+// once per controller start, so the policy always follows the discovery settings in effect
+func (c *Controller) applyNetworkPolicy() {
+	if c.networkPolicyApplied || c.cfg.NetworkPolicy == "" || c.external != nil {
+		return
+	}
+
+	policy, err := kubernetes.VaultNetworkPolicy(c.cfg.NetworkPolicy, c.cfg.VaultNamespace, c.cfg.PodSelector,
+		c.cfg.ControllerNamespace, c.cfg.ControllerPodSelector, c.cfg.VaultPort)
+	if err != nil {
+		log.Printf("Error generating NetworkPolicy %s: %v", c.cfg.NetworkPolicy, err)
+
+		return
+	}
+
+	if err := c.k8sClient.CreateOrUpdateNetworkPolicy(policy); err != nil {
+		log.Printf("Error applying NetworkPolicy %s: %v", c.cfg.NetworkPolicy, err)
+
+		return
+	}
+
+	c.networkPolicyApplied = true
+	log.Printf("Applied NetworkPolicy %s/%s admitting %s in namespace %s to the Vault pods on port %s",
+		c.cfg.VaultNamespace, c.cfg.NetworkPolicy, c.cfg.ControllerPodSelector, c.cfg.ControllerNamespace, c.cfg.VaultPort)
+}
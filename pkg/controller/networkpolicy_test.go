@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestReconcileAppliesNetworkPolicy(t *testing.T) {
+	fakeVault := vaulttest.NewServer()
+	defer fakeVault.Close()
+
+	cfg := testConfig()
+	cfg.PodSelector = "app.kubernetes.io/name=vault,component=server"
+	cfg.NetworkPolicy = "vault-utils"
+	cfg.ControllerNamespace = "ops"
+	cfg.ControllerPodSelector = "app.kubernetes.io/name=vault-auto-unseal"
+	clientset := fake.NewSimpleClientset()
+
+	// The first attempt fails and is retried on the next pass
+	failures := 1
+	clientset.PrependReactor("create", "networkpolicies", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if failures > 0 {
+			failures--
+
+			return true, nil, fmt.Errorf("forbidden")
+		}
+
+		return false, nil, nil
+	})
+	c := newTestController(t, clientset, cfg, []*vaulttest.Server{fakeVault})
+
+	c.Reconcile(context.Background())
+	if c.networkPolicyApplied {
+		t.Fatalf("expected a failed apply to be retried")
+	}
+
+	c.Reconcile(context.Background())
+	c.Reconcile(context.Background())
+
+	policy, err := clientset.NetworkingV1().NetworkPolicies("vault").Get(context.Background(), "vault-utils", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the networkpolicy: %v", err)
+	}
+	if policy.Spec.Ingress[0].Ports[0].Port.String() != "8200" || policy.Spec.Ingress[0].From[0].NamespaceSelector == nil {
+		t.Errorf("unexpected networkpolicy %+v", policy.Spec)
+	}
+
+	writes := 0
+	for _, action := range clientset.Actions() {
+		if action.GetResource().Resource == "networkpolicies" && action.GetVerb() != "get" {
+			writes++
+		}
+	}
+	if writes != 2 {
+		t.Errorf("expected the networkpolicy to be written once after the failure, got %d writes", writes)
+	}
+}
@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"log"
+
+	"github.com/getgrowly/vault-utils/pkg/metrics"
+	"github.com/getgrowly/vault-utils/pkg/status"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+)
+
+var clusterPhase = metrics.NewGauge("vault_utils_cluster_phase",
+	"1 for the phase the Vault cluster is in, 0 for the others.", "phase")
+
+// phaseTransitions are the transitions of the cluster state machine. Phases are observed rather
+// than chosen, so a transition missing here still happens, but is reported as unexpected: it
+// means the cluster changed in a way the controller did not cause or foresee, such as a cluster
+// that was unsealed reporting itself uninitialized.
+var phaseTransitions = map[status.Phase][]status.Phase{
+	status.PhaseDiscovering:   {status.PhaseUninitialized, status.PhaseUnsealing, status.PhaseHealthy, status.PhaseDegraded, status.PhaseMaintenance},
+	status.PhaseUninitialized: {status.PhaseDiscovering, status.PhaseInitializing, status.PhaseUnsealing, status.PhaseHealthy, status.PhaseDegraded, status.PhaseMaintenance},
+	status.PhaseInitializing:  {status.PhaseDiscovering, status.PhaseUninitialized, status.PhaseUnsealing, status.PhaseHealthy, status.PhaseDegraded, status.PhaseMaintenance},
+	status.PhaseUnsealing:     {status.PhaseDiscovering, status.PhaseHealthy, status.PhaseDegraded, status.PhaseMaintenance},
+	status.PhaseHealthy:       {status.PhaseDiscovering, status.PhaseUnsealing, status.PhaseDegraded, status.PhaseMaintenance},
+	status.PhaseDegraded:      {status.PhaseDiscovering, status.PhaseUnsealing, status.PhaseHealthy, status.PhaseMaintenance},
+	status.PhaseMaintenance:   {status.PhaseDiscovering, status.PhaseUninitialized, status.PhaseUnsealing, status.PhaseHealthy, status.PhaseDegraded},
+}
+
+// observePhase returns the phase of a cluster whose checked pods report statuses, with
+// unreachable pods that could not be checked. paused is whether actions on the cluster are held
+// off.
+func observePhase(statuses map[string]*vault.Status, unreachable int, paused bool) status.Phase {
+	if len(statuses) == 0 {
+		return status.PhaseDiscovering
+	}
+	if paused {
+		return status.PhaseMaintenance
+	}
+
+	initialized, unsealed := 0, 0
+	for _, vaultStatus := range statuses {
+		if vaultStatus.Initialized {
+			initialized++
+			if !vaultStatus.Sealed {
+				unsealed++
+			}
+		}
+	}
+
+	switch {
+	case initialized == 0:
+		return status.PhaseUninitialized
+	case unsealed < len(statuses):
+		return status.PhaseUnsealing
+	case unreachable > 0:
+		return status.PhaseDegraded
+	default:
+		return status.PhaseHealthy
+	}
+}
+
+// actsIn reports whether a pass initializes or unseals pods when the cluster is in phase
+func actsIn(phase status.Phase) bool {
+	return phase == status.PhaseUninitialized || phase == status.PhaseUnsealing
+}
+
+// expectedTransition reports whether the state machine foresees the cluster moving from one
+// phase to the other
+func expectedTransition(from, to status.Phase) bool {
+	for _, next := range phaseTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// transition moves the cluster to phase, recording it for /status and the metrics, and returns
+// it. Changes are logged, and transitions the state machine does not expect logged as warnings.
+func (c *Controller) transition(phase status.Phase) status.Phase {
+	if phase == c.phase {
+		return phase
+	}
+
+	if expectedTransition(c.phase, phase) {
+		log.Printf("Vault cluster in namespace %s is now %s, was %s", c.cfg.VaultNamespace, phase, c.phase)
+	} else {
+		log.Printf("Warning: unexpected transition of the Vault cluster in namespace %s from %s to %s", c.cfg.VaultNamespace, c.phase, phase)
+	}
+
+	c.phase = phase
+	c.status.SetPhase(phase)
+	for _, p := range status.Phases {
+		value := 0.0
+		if p == phase {
+			value = 1
+		}
+		clusterPhase.Set(value, string(p))
+	}
+
+	return phase
+}
@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/getgrowly/vault-utils/pkg/status"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestObservePhase(t *testing.T) {
+	unsealed := &vault.Status{Initialized: true}
+	sealed := &vault.Status{Initialized: true, Sealed: true}
+	uninitialized := &vault.Status{Sealed: true}
+
+	tests := []struct {
+		name        string
+		statuses    map[string]*vault.Status
+		unreachable int
+		paused      bool
+		expected    status.Phase
+	}{
+		{name: "nothing checked", statuses: map[string]*vault.Status{}, unreachable: 2, expected: status.PhaseDiscovering},
+		{name: "paused", statuses: map[string]*vault.Status{"a": sealed}, paused: true, expected: status.PhaseMaintenance},
+		{name: "new cluster", statuses: map[string]*vault.Status{"a": uninitialized, "b": uninitialized}, expected: status.PhaseUninitialized},
+		{name: "joining member", statuses: map[string]*vault.Status{"a": unsealed, "b": uninitialized}, expected: status.PhaseUnsealing},
+		{name: "sealed pod", statuses: map[string]*vault.Status{"a": unsealed, "b": sealed}, unreachable: 1, expected: status.PhaseUnsealing},
+		{name: "unreachable pod", statuses: map[string]*vault.Status{"a": unsealed}, unreachable: 1, expected: status.PhaseDegraded},
+		{name: "all unsealed", statuses: map[string]*vault.Status{"a": unsealed, "b": unsealed}, expected: status.PhaseHealthy},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if phase := observePhase(tt.statuses, tt.unreachable, tt.paused); phase != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, phase)
+			}
+		})
+	}
+}
+
+func TestReconcilePhases(t *testing.T) {
+	fakeVault := vaulttest.NewServer()
+	defer fakeVault.Close()
+
+	clientset := fake.NewSimpleClientset()
+	c := newTestController(t, clientset, testConfig(), []*vaulttest.Server{fakeVault})
+	if phase := c.Status().Snapshot().Phase; phase != status.PhaseDiscovering {
+		t.Errorf("expected a new controller to be discovering, got %s", phase)
+	}
+
+	// An uninitialized cluster goes through init and unseal within one pass
+	c.Reconcile(context.Background())
+	snapshot := c.Status().Snapshot()
+	if snapshot.Phase != status.PhaseHealthy || snapshot.PhaseSince.IsZero() {
+		t.Errorf("expected the cluster to be healthy, got %s since %v", snapshot.Phase, snapshot.PhaseSince)
+	}
+	if value := clusterPhase.Value(string(status.PhaseHealthy)); value != 1 {
+		t.Errorf("expected the healthy phase to be exported, got %v", value)
+	}
+	if value := clusterPhase.Value(string(status.PhaseInitializing)); value != 0 {
+		t.Errorf("expected only the current phase to be exported, got %v for initializing", value)
+	}
+
+	// A healthy cluster is left alone
+	submitted := fakeVault.Submitted()
+	c.Reconcile(context.Background())
+	if fakeVault.Submitted() != submitted {
+		t.Errorf("expected no keys to be submitted to a healthy cluster")
+	}
+
+	// A resealed pod moves the cluster back to unsealing, which the same pass resolves
+	fakeVault.Seal()
+	c.Reconcile(context.Background())
+	if phase := c.Status().Snapshot().Phase; phase != status.PhaseHealthy || fakeVault.Sealed() {
+		t.Errorf("expected the resealed pod to be unsealed again, got %s", phase)
+	}
+}
+
+func TestTransitionUnexpected(t *testing.T) {
+	if !expectedTransition(status.PhaseHealthy, status.PhaseUnsealing) || !expectedTransition(status.PhaseUninitialized, status.PhaseInitializing) {
+		t.Errorf("expected a resealed pod and an init to be foreseen")
+	}
+	if expectedTransition(status.PhaseHealthy, status.PhaseUninitialized) || expectedTransition(status.PhaseUnsealing, status.PhaseInitializing) {
+		t.Errorf("expected a cluster losing its data and a second init not to be foreseen")
+	}
+
+	c := newTestController(t, fake.NewSimpleClientset(), testConfig(), nil)
+	c.transition(status.PhaseHealthy)
+
+	// A cluster that was unsealed reporting itself uninitialized is not a transition the machine
+	// foresees, but it is what was observed
+	if phase := c.transition(status.PhaseUninitialized); phase != status.PhaseUninitialized || c.Status().Snapshot().Phase != status.PhaseUninitialized {
+		t.Errorf("expected the observed phase to be recorded, got %s", phase)
+	}
+}
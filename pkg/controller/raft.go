@@ -0,0 +1,182 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/metrics"
+	"github.com/getgrowly/vault-utils/pkg/status"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+)
+
+// joinRaft has an uninitialized member of a cluster using integrated storage join the raft
+// cluster through an unsealed member, the active node when known. The member then reports
+// itself initialized and is unsealed with the cluster's keys like any other.
+func (c *Controller) joinRaft(ctx context.Context, pod string, vaultClient *vault.Client, pods []string, statuses map[string]*vault.Status) error {
+	unsealed := func(member string) bool {
+		status, ok := statuses[member]
+
+		return ok && member != pod && status.Initialized && !status.Sealed
+	}
+
+	leader := c.status.Snapshot().Active
+	if !unsealed(leader) {
+		leader = ""
+		for _, member := range pods {
+			if unsealed(member) {
+				leader = member
+
+				break
+			}
+		}
+	}
+	if leader == "" {
+		return fmt.Errorf("no unsealed member to join yet")
+	}
+
+	req := vault.RaftJoinRequest{LeaderAPIAddr: c.vaultAddress(leader)}
+	if c.cfg.TLS() && c.vaultTLSLoaded {
+		req.LeaderCACert = string(c.vaultCA)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.requestTimeout())
+	defer cancel()
+	if err := vaultClient.RaftJoin(ctx, req); err != nil {
+		return fmt.Errorf("error joining the raft cluster through pod %s: %v", leader, err)
+	}
+	log.Printf("Vault pod %s joined the raft cluster through pod %s", pod, leader)
+
+	return nil
+}
+
+// raftWarnings is the source of the /status warnings about raft membership
+const raftWarnings = "raft"
+
+var (
+	raftPeers = metrics.NewGauge("vault_utils_raft_peers",
+		"Number of members of the raft cluster in the latest pass, by voter or non_voter.", "role")
+	raftPeer = metrics.NewGauge("vault_utils_raft_peer",
+		"1 for each member of the raft cluster in the latest pass, with its role: leader, voter or non_voter.", "node_id", "role")
+)
+
+// checkRaftPeers reads the raft membership from the active node with the token in
+// RAFT_TOKEN_FILE, when Vault uses integrated storage, and warns about missing and stale peers.
+// A pod that never joined, or a peer left behind by a pod that was replaced, goes unnoticed
+// while the cluster keeps quorum, until one more loss takes it down.
+func (c *Controller) checkRaftPeers(ctx context.Context, pods []string, statuses map[string]*vault.Status) {
+	if c.cfg.RaftTokenFile == "" {
+		return
+	}
+
+	members := 0
+	for _, vaultStatus := range statuses {
+		if vaultStatus.Raft() && vaultStatus.Initialized {
+			members++
+		}
+	}
+	if members == 0 {
+		return
+	}
+
+	raft := status.Raft{Pod: c.status.Snapshot().Active}
+	servers, err := c.readRaftConfiguration(ctx, raft.Pod)
+	raft.CheckedAt = time.Now().UTC()
+	if err != nil {
+		raft.Error = err.Error()
+		c.status.SetRaft(raft)
+		c.reportRaftProblems([]string{fmt.Sprintf("the raft membership could not be read: %v", err)})
+
+		return
+	}
+	if c.cfg.RaftRemoveDeadPeers {
+		servers = c.removeDeadRaftPeers(ctx, pods, servers)
+	}
+
+	voters, leaders := 0, 0
+	nodeIDs := make([]string, 0, len(servers))
+	for _, server := range servers {
+		role := "non_voter"
+		if server.Voter {
+			role = "voter"
+			voters++
+		}
+		if server.Leader {
+			role = "leader"
+			leaders++
+		}
+		raftPeer.Replace("node_id", 1, server.NodeID, role)
+		nodeIDs = append(nodeIDs, server.NodeID)
+		raft.Peers = append(raft.Peers, status.RaftPeer{NodeID: server.NodeID, Address: server.Address, Leader: server.Leader, Voter: server.Voter})
+	}
+	raftPeer.Retain("node_id", nodeIDs)
+	raftPeers.Set(float64(voters), "voter")
+	raftPeers.Set(float64(len(servers)-voters), "non_voter")
+	c.status.SetRaft(raft)
+
+	var problems []string
+	if leaders == 0 {
+		problems = append(problems, "the raft configuration lists no leader")
+	}
+	if len(servers) < members {
+		problems = append(problems, fmt.Sprintf("%d initialized Vault pods use integrated storage but raft lists %d peers, some never joined or were removed", members, len(servers)))
+	}
+	if len(servers) > len(pods) {
+		problems = append(problems, fmt.Sprintf("raft lists %d peers for %d Vault pods, peers of replaced pods are stale and still count toward quorum", len(servers), len(pods)))
+	}
+	c.reportRaftProblems(problems)
+}
+
+// readRaftConfiguration reads the raft membership from the active node
+func (c *Controller) readRaftConfiguration(ctx context.Context, active string) ([]vault.RaftServer, error) {
+	if active == "" {
+		return nil, fmt.Errorf("no active node to read it from")
+	}
+
+	token, err := c.raftToken()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.requestTimeout())
+	defer cancel()
+
+	var servers []vault.RaftServer
+	err = c.active.Do(ctx, func(client *vault.Client) error {
+		var err error
+		servers, err = client.RaftConfiguration(ctx, token)
+
+		return err
+	})
+
+	return servers, err
+}
+
+// raftToken reads the token in RAFT_TOKEN_FILE, which may have been rotated since the last pass
+func (c *Controller) raftToken() (string, error) {
+	token, err := os.ReadFile(c.cfg.RaftTokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the raft token: %v", err)
+	}
+
+	return strings.TrimSpace(string(token)), nil
+}
+
+// reportRaftProblems sets the raft warnings, logging them when they changed
+func (c *Controller) reportRaftProblems(problems []string) {
+	c.status.SetWarnings(raftWarnings, problems)
+
+	joined := strings.Join(problems, "; ")
+	if joined == c.raftProblems {
+		return
+	}
+	if joined == "" {
+		log.Printf("Raft membership is healthy again")
+	} else {
+		log.Printf("Warning: %s", joined)
+	}
+	c.raftProblems = joined
+}
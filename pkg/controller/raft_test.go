@@ -0,0 +1,134 @@
+package controller
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/getgrowly/vault-utils/pkg/reason"
+	"github.com/getgrowly/vault-utils/pkg/status"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReconcileJoinsRaftMembers(t *testing.T) {
+	fakes := vaulttest.NewRaftCluster(3)
+	for _, fakeVault := range fakes {
+		defer fakeVault.Close()
+	}
+
+	cfg := testConfig()
+	cfg.RaftJoin = true
+	c := newTestController(t, fake.NewSimpleClientset(), cfg, fakes)
+
+	c.Reconcile(context.Background())
+
+	assert.Empty(t, fakes[0].JoinedTo(), "the first member is initialized")
+	for i, fakeVault := range fakes {
+		assert.False(t, fakeVault.Sealed(), "member %d should be unsealed", i)
+		assert.Equal(t, fakes[0].ClusterID(), fakeVault.ClusterID(), "member %d should share the cluster", i)
+		if i > 0 {
+			assert.Equal(t, fakes[0].URL, fakeVault.JoinedTo(), "member %d should join through the first", i)
+		}
+	}
+}
+
+func TestReconcileWaitsForRaftJoinWithoutRaftJoin(t *testing.T) {
+	fakes := vaulttest.NewRaftCluster(2)
+	for _, fakeVault := range fakes {
+		defer fakeVault.Close()
+	}
+
+	c := newTestController(t, fake.NewSimpleClientset(), testConfig(), fakes)
+
+	c.Reconcile(context.Background())
+
+	assert.False(t, fakes[0].Sealed())
+	assert.False(t, fakes[1].Initialized(), "a member is left to retry_join unless RAFT_JOIN is set")
+}
+
+func TestReconcileReportsRaftJoinWithoutUnsealedMember(t *testing.T) {
+	fakes := vaulttest.NewRaftCluster(2)
+	for _, fakeVault := range fakes {
+		defer fakeVault.Close()
+	}
+
+	cfg := testConfig()
+	cfg.RaftJoin = true
+	cfg.InitAllowed = false
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, []string{"abcd"})
+	c := newTestController(t, clientset, cfg, fakes)
+
+	c.Reconcile(context.Background())
+
+	for i, pod := range c.Status().Snapshot().Pods {
+		assert.Equal(t, reason.RaftJoinFailed, pod.Reason, "member %d", i)
+	}
+	assert.False(t, fakes[1].Initialized())
+}
+
+func TestRaftPeers(t *testing.T) {
+	cluster := vaulttest.NewRaftCluster(1)
+	defer cluster[0].Close()
+	resp, err := vault.NewClient(cluster[0].URL).Initialize(context.Background())
+	assert.NoError(t, err)
+	cluster[0].SetRaftPeers([]vaulttest.RaftPeer{{NodeID: "vault-0", Address: "vault-0.vault-internal:8201", Leader: true, Voter: true}})
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, os.WriteFile(tokenFile, []byte(resp.RootToken+"\n"), 0o600))
+
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, resp.Keys)
+	cfg := testConfig()
+	cfg.RaftTokenFile = tokenFile
+	c := newTestController(t, clientset, cfg, cluster)
+
+	c.Reconcile(context.Background())
+	snapshot := c.status.Snapshot()
+	if assert.NotNil(t, snapshot.Raft) {
+		assert.Empty(t, snapshot.Raft.Error)
+		assert.Equal(t, "10.0.0.1", snapshot.Raft.Pod)
+		assert.Equal(t, []status.RaftPeer{{NodeID: "vault-0", Address: "vault-0.vault-internal:8201", Leader: true, Voter: true}}, snapshot.Raft.Peers)
+	}
+	assert.Empty(t, snapshot.Warnings)
+	assert.Equal(t, float64(1), raftPeer.Value("vault-0", "leader"))
+	assert.Equal(t, float64(1), raftPeers.Value("voter"))
+
+	// A peer left behind by a replaced pod is stale, and a leader that moved is reported again
+	cluster[0].SetRaftPeers([]vaulttest.RaftPeer{
+		{NodeID: "vault-0", Address: "vault-0.vault-internal:8201", Voter: true},
+		{NodeID: "vault-old", Address: "vault-old.vault-internal:8201"},
+	})
+	c.Reconcile(context.Background())
+	snapshot = c.status.Snapshot()
+	assert.Len(t, snapshot.Raft.Peers, 2)
+	if assert.Len(t, snapshot.Warnings, 2) {
+		assert.Contains(t, snapshot.Warnings[0], "no leader")
+		assert.Contains(t, snapshot.Warnings[1], "stale")
+	}
+	assert.Equal(t, float64(1), raftPeer.Value("vault-0", "voter"))
+	assert.Equal(t, float64(0), raftPeer.Value("vault-0", "leader"), "the series of the former role should be dropped")
+	assert.Equal(t, float64(1), raftPeers.Value("non_voter"))
+
+	// No peers at all means the pods are missing from the membership
+	cluster[0].SetRaftPeers(nil)
+	c.Reconcile(context.Background())
+	snapshot = c.status.Snapshot()
+	if assert.Len(t, snapshot.Warnings, 2) {
+		assert.Contains(t, snapshot.Warnings[1], "never joined")
+	}
+	assert.Equal(t, float64(0), raftPeer.Value("vault-old", "non_voter"))
+
+	// A token that cannot read the membership is reported
+	assert.NoError(t, os.WriteFile(tokenFile, []byte("stale-token"), 0o600))
+	c.Reconcile(context.Background())
+	snapshot = c.status.Snapshot()
+	assert.Contains(t, snapshot.Raft.Error, "403")
+	if assert.Len(t, snapshot.Warnings, 1) {
+		assert.Contains(t, snapshot.Warnings[0], "could not be read")
+	}
+}
@@ -0,0 +1,165 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/getgrowly/vault-utils/pkg/kubernetes"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+)
+
+// RekeyUnsealKeys replaces the unseal keys of the cluster for requester with shares new keys, of
+// which threshold are needed to unseal, keeping the current split when they are 0. The new keys
+// are kept in a pending Secret until Vault verified them, and only then replace the keys in the
+// unseal keys Secret, in a single update. Until verification succeeds, Vault and the unseal
+// keys Secret both keep the current keys.
+func (c *Controller) RekeyUnsealKeys(ctx context.Context, requester string, shares, threshold int) error {
+	err := c.rekeyUnsealKeys(ctx, shares, threshold)
+	c.auditAdmin(AdminAudit{Action: AdminRekey, Requester: requester, Target: "unseal keys"}, err)
+
+	return err
+}
+
+func (c *Controller) rekeyUnsealKeys(ctx context.Context, shares, threshold int) error {
+	if c.keyProvider != nil {
+		return fmt.Errorf("unseal keys kept with %s are not rekeyed, only those in secret %s", c.keyProvider, vault.UnsealKeysSecret)
+	}
+
+	secret, err := c.k8sClient.GetSecret(c.cfg.VaultNamespace, vault.UnsealKeysSecret)
+	if err != nil {
+		return err
+	}
+	if secret.Annotations[vault.PGPEncryptedAnnotation] == "true" {
+		return fmt.Errorf("secret %s holds unseal keys encrypted to operators' PGP keys", vault.UnsealKeysSecret)
+	}
+	keys, missing := kubernetes.UnsealKeysFromSecret(secret.Data)
+	if len(missing) > 0 {
+		return fmt.Errorf("secret %s is missing %v", vault.UnsealKeysSecret, missing)
+	}
+
+	// Hold the action lock so no other actor replaces keys at the same time
+	lock, err := c.k8sClient.AcquireActionLock(c.cfg.VaultNamespace, kubernetes.ActionRekey, c.identity)
+	if err != nil {
+		return fmt.Errorf("not rekeying: %v", err)
+	}
+	defer func() {
+		if err := lock.Release(); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}()
+
+	encoding := secret.Annotations[vault.KeyEncodingAnnotation]
+	if encoding == "" {
+		encoding = c.keyEncoding()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.requestTimeout())
+	defer cancel()
+
+	var newKeys []string
+	err = c.active.Do(ctx, func(client *vault.Client) error {
+		status, err := client.CheckStatus(ctx)
+		if err != nil {
+			return err
+		}
+		if status.AutoUnseal() {
+			return fmt.Errorf("vault uses a %s seal, it has recovery keys rather than unseal keys", status.Type)
+		}
+		if shares == 0 {
+			shares = status.Shares
+		}
+		if threshold == 0 {
+			threshold = status.Threshold
+		}
+		if threshold < 1 || threshold > shares {
+			return fmt.Errorf("threshold must be between 1 and the %d shares", shares)
+		}
+
+		rekey, err := client.RekeyUnsealKeys(ctx, "", keys, shares, threshold)
+		if err != nil {
+			return err
+		}
+		newKeys = rekey.EncodedKeys(encoding)
+
+		// The new keys exist nowhere else until Vault switched to them, so they are stored first
+		if err := c.storePendingUnsealKeys(newKeys); err != nil {
+			client.CancelRekey(ctx, "")
+			return err
+		}
+		if err := client.VerifyRekey(ctx, "", rekey.VerificationNonce, newKeys); err != nil {
+			// Vault may have switched to the new keys when its answer was lost, so they are kept
+			if vault.IsConnectionError(err) {
+				return err
+			}
+			if err := c.k8sClient.DeleteSecret(c.cfg.VaultNamespace, vault.PendingUnsealKeysSecret); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		if exists, existsErr := c.k8sClient.SecretExists(c.cfg.VaultNamespace, vault.PendingUnsealKeysSecret); existsErr == nil && exists {
+			return fmt.Errorf("%v, the new unseal keys are kept in secret %s in case Vault switched to them", err, vault.PendingUnsealKeysSecret)
+		}
+
+		return err
+	}
+
+	secret = secret.DeepCopy()
+	secret.Data = make(map[string][]byte, len(newKeys))
+	for i, key := range newKeys {
+		secret.Data[fmt.Sprintf("key%d", i+1)] = []byte(key)
+	}
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	secret.Annotations[vault.ThresholdAnnotation] = strconv.Itoa(threshold)
+	secret.Annotations[vault.SharesAnnotation] = strconv.Itoa(shares)
+	secret.Annotations[vault.KeyEncodingAnnotation] = encoding
+	secret.Annotations[vault.FormatAnnotation] = vault.SecretFormat
+	secret.Annotations[vault.RekeyedAtAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	if _, err := c.formatKeySecret(secret); err != nil {
+		log.Printf("Warning: storing the new unseal keys without the configured labels, annotations and format: %v", err)
+	}
+
+	// The update carries the resource version read above, so keys written meanwhile are not lost
+	if err := c.k8sClient.UpdateSecret(secret); err != nil {
+		return fmt.Errorf("vault now uses the new unseal keys, which are kept in secret %s but could not replace those in secret %s: %v",
+			vault.PendingUnsealKeysSecret, vault.UnsealKeysSecret, err)
+	}
+	if err := c.k8sClient.DeleteSecret(c.cfg.VaultNamespace, vault.PendingUnsealKeysSecret); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+
+	return nil
+}
+
+// storePendingUnsealKeys keeps the new keys of a rekey awaiting verification in the pending
+// unseal keys Secret, replacing any left behind by an earlier rekey
+func (c *Controller) storePendingUnsealKeys(keys []string) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      vault.PendingUnsealKeysSecret,
+			Namespace: c.cfg.VaultNamespace,
+			Annotations: map[string]string{
+				vault.RekeyedAtAnnotation: time.Now().UTC().Format(time.RFC3339),
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: make(map[string][]byte, len(keys)),
+	}
+	for i, key := range keys {
+		secret.Data[fmt.Sprintf("key%d", i+1)] = []byte(key)
+	}
+
+	return c.k8sClient.CreateOrUpdateSecret(secret)
+}
@@ -0,0 +1,178 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/getgrowly/vault-utils/pkg/kubernetes"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/yaml"
+)
+
+func TestRekeyUnsealKeys(t *testing.T) {
+	fakeVault := vaulttest.NewServer()
+	defer fakeVault.Close()
+
+	c := newTestController(t, fake.NewSimpleClientset(), testConfig(), []*vaulttest.Server{fakeVault})
+	notifier := &recordingNotifier{}
+	c.notifier = notifier
+
+	// The first pass initializes and unseals Vault and stores the unseal keys
+	c.Reconcile(context.Background())
+	oldKeys := fakeVault.Keys()
+
+	if err := c.RekeyUnsealKeys(context.Background(), "carol", 3, 2); err != nil {
+		t.Fatalf("failed to rekey: %v", err)
+	}
+
+	newKeys := fakeVault.Keys()
+	if len(newKeys) != 3 || reflect.DeepEqual(newKeys, oldKeys) {
+		t.Fatalf("expected Vault to use 3 new keys, got %v", newKeys)
+	}
+	secret, err := c.k8sClient.GetSecret("vault", vault.UnsealKeysSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := kubernetes.UnsealKeysFromSecret(secret.Data)
+	for i, key := range stored {
+		stored[i] = vault.HexKey(key)
+	}
+	if !reflect.DeepEqual(stored, newKeys) {
+		t.Errorf("expected the secret to hold the new keys %v, got %v", newKeys, stored)
+	}
+	if secret.Annotations[vault.ThresholdAnnotation] != "2" || secret.Annotations[vault.SharesAnnotation] != "3" || secret.Annotations[vault.RekeyedAtAnnotation] == "" {
+		t.Errorf("expected the new split and rekey time to be recorded, got %v", secret.Annotations)
+	}
+	if exists, err := c.k8sClient.SecretExists("vault", vault.PendingUnsealKeysSecret); err != nil || exists {
+		t.Errorf("expected the pending keys to be removed, got exists=%t err=%v", exists, err)
+	}
+	if len(notifier.events) == 0 || notifier.events[len(notifier.events)-1].Details.(AdminAudit).Result != "success" {
+		t.Errorf("expected the rekey to be audited, got %+v", notifier.events)
+	}
+
+	// The next pass unseals with the new keys
+	fakeVault.Seal()
+	c.Reconcile(context.Background())
+	if fakeVault.Sealed() {
+		t.Errorf("expected Vault to be unsealed with the new keys")
+	}
+}
+
+// manifestRole returns the Role of the RBAC manifest shipped in k8s/rbac.yaml
+func manifestRole(t *testing.T) rbacv1.Role {
+	t.Helper()
+
+	manifest, err := os.ReadFile(filepath.Join("..", "..", "k8s", "rbac.yaml"))
+	if err != nil {
+		t.Fatalf("failed to read the RBAC manifest: %v", err)
+	}
+	for _, document := range strings.Split(string(manifest), "\n---") {
+		var role rbacv1.Role
+		if err := yaml.Unmarshal([]byte(document), &role); err != nil {
+			t.Fatalf("failed to decode the RBAC manifest: %v", err)
+		}
+		if role.Kind == "Role" {
+			return role
+		}
+	}
+	t.Fatalf("no Role in the RBAC manifest")
+
+	return rbacv1.Role{}
+}
+
+// allows reports whether role lets verb act on the Secret named name. Create, list and watch
+// cannot be limited to names, so rules with resourceNames do not allow them.
+func allows(role rbacv1.Role, verb, name string) bool {
+	for _, rule := range role.Rules {
+		if !slices.Contains(rule.Resources, "secrets") || !(slices.Contains(rule.Verbs, verb) || slices.Contains(rule.Verbs, "*")) {
+			continue
+		}
+		if len(rule.ResourceNames) == 0 || (name != "" && verb != "create" && slices.Contains(rule.ResourceNames, name)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestRekeyUnsealKeysIsAllowedByRBACManifest(t *testing.T) {
+	fakeVault := vaulttest.NewServer()
+	defer fakeVault.Close()
+
+	clientset := fake.NewSimpleClientset()
+	c := newTestController(t, clientset, testConfig(), []*vaulttest.Server{fakeVault})
+	c.Reconcile(context.Background())
+
+	// A pending Secret left behind by an earlier rekey is replaced
+	clientset.ClearActions()
+	assert.NoError(t, c.storePendingUnsealKeys([]string{"old"}))
+	assert.NoError(t, c.RekeyUnsealKeys(context.Background(), "carol", 3, 2))
+
+	role := manifestRole(t)
+	checked := 0
+	for _, action := range clientset.Actions() {
+		if action.GetResource().Resource != "secrets" {
+			continue
+		}
+		name := ""
+		switch action := action.(type) {
+		case k8stesting.GetAction:
+			name = action.GetName()
+		case k8stesting.DeleteAction:
+			name = action.GetName()
+		case k8stesting.CreateAction:
+			if object, err := meta.Accessor(action.GetObject()); err == nil {
+				name = object.GetName()
+			}
+		}
+		assert.True(t, allows(role, action.GetVerb(), name), "k8s/rbac.yaml does not allow %s on secret %q", action.GetVerb(), name)
+		checked++
+	}
+	assert.NotZero(t, checked)
+}
+
+func TestRekeyUnsealKeysKeepsCurrentKeysOnFailure(t *testing.T) {
+	fakeVault := vaulttest.NewServer()
+	defer fakeVault.Close()
+
+	clientset := fake.NewSimpleClientset()
+	c := newTestController(t, clientset, testConfig(), []*vaulttest.Server{fakeVault})
+	c.Reconcile(context.Background())
+	oldKeys := fakeVault.Keys()
+	before, err := c.k8sClient.GetSecret("vault", vault.UnsealKeysSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The new keys cannot be stored before verification
+	clientset.PrependReactor("create", "secrets", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("forbidden")
+	})
+	if err := c.RekeyUnsealKeys(context.Background(), "carol", 3, 2); err == nil {
+		t.Fatalf("expected the rekey to fail")
+	}
+
+	if !reflect.DeepEqual(fakeVault.Keys(), oldKeys) || fakeVault.Rekeying() {
+		t.Errorf("expected the rekey to be cancelled with the current keys in use")
+	}
+	after, err := c.k8sClient.GetSecret("vault", vault.UnsealKeysSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(after.Data, before.Data) {
+		t.Errorf("expected the unseal keys secret to be left alone")
+	}
+}
@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/getgrowly/vault-utils/pkg/backup"
+	"github.com/getgrowly/vault-utils/pkg/kubernetes"
+	"github.com/getgrowly/vault-utils/pkg/reason"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+)
+
+// RestoreSnapshot downloads the raft snapshot stored under key in the backup store and restores
+// it on the active node for requester, with the stored root token. force restores a snapshot of
+// another cluster, whose unseal keys the cluster needs from then on.
+func (c *Controller) RestoreSnapshot(ctx context.Context, requester, key string, force bool) error {
+	target := "snapshot " + key
+	if c.backupStore != nil {
+		target = fmt.Sprintf("snapshot %s/%s", c.backupStore, key)
+	}
+	if force {
+		target += " (forced)"
+	}
+
+	err := c.restoreSnapshot(ctx, key, force)
+	c.auditAdmin(AdminAudit{Action: AdminRestore, Requester: requester, Target: target}, err)
+
+	// The restored data may come with other keys or a sealed cluster, which the next pass
+	// reports
+	select {
+	case c.events <- "":
+	default:
+	}
+
+	return err
+}
+
+func (c *Controller) restoreSnapshot(ctx context.Context, key string, force bool) error {
+	if c.backupStore == nil {
+		return fmt.Errorf("no backup store is configured, set BACKUP_S3_BUCKET")
+	}
+	rootToken, err := c.rootToken()
+	if err != nil {
+		return err
+	}
+
+	// Nothing else may init, rekey or restore while the data is replaced
+	lock, err := c.k8sClient.AcquireActionLock(c.cfg.VaultNamespace, kubernetes.ActionRestore, c.identity)
+	if err != nil {
+		return reason.Errorf(reason.ActionLocked, "%v", err)
+	}
+	defer func() {
+		if err := lock.Release(); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.BackupTimeout)
+	defer cancel()
+
+	// The snapshot is streamed from the store to Vault, and downloaded again when a standby
+	// redirects the request
+	location := fmt.Sprintf("%s/%s", c.backupStore, key)
+	var progress *backup.ProgressReader
+	open := func() (io.Reader, error) {
+		snapshot, err := backup.OpenSnapshot(ctx, c.backupStore, c.backupKeys, key)
+		if err != nil {
+			return nil, err
+		}
+		progress = transferProgress(snapshot, "restore", location)
+
+		return progress, nil
+	}
+
+	err = c.active.Do(ctx, func(client *vault.Client) error {
+		return client.RestoreRaftSnapshot(ctx, rootToken, open, force)
+	})
+	if progress != nil {
+		snapshotTransferred.Set(float64(progress.Transferred()), "restore")
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Restored the raft snapshot %s of %s on pod %s", location, backup.FormatSize(progress.Transferred()), c.active.Active())
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/backup"
+	"github.com/getgrowly/vault-utils/pkg/kubernetes"
+	"github.com/getgrowly/vault-utils/pkg/notify"
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRestoreSnapshot(t *testing.T) {
+	cluster := vaulttest.NewRaftCluster(1)
+	defer cluster[0].Close()
+
+	clientset := fake.NewSimpleClientset()
+	cfg := testConfig()
+	cfg.BackupTimeout = time.Minute
+	c := newTestController(t, clientset, cfg, cluster)
+	notifier := &recordingNotifier{}
+	c.notifier = notifier
+
+	// Without a backup store there is nothing to restore from
+	c.Reconcile(context.Background())
+	assert.ErrorContains(t, c.RestoreSnapshot(context.Background(), "alice", "vault/20240101T000000Z.snap", false), "BACKUP_S3_BUCKET")
+
+	other := []byte("snapshot of another cluster")
+	store := &recordingStore{objects: map[string][]byte{
+		"vault/20240101T000000Z.snap":   vaulttest.Snapshot,
+		"staging/20240101T000000Z.snap": other,
+	}}
+	c.SetBackupStore(store)
+	restores := adminActions.Value(AdminRestore, "success")
+
+	assert.NoError(t, c.RestoreSnapshot(context.Background(), "alice", "vault/20240101T000000Z.snap", false))
+	restored, forced := cluster[0].Restored()
+	assert.Equal(t, vaulttest.Snapshot, restored)
+	assert.False(t, forced)
+	assert.Equal(t, float64(len(vaulttest.Snapshot)), snapshotTransferred.Value("restore"))
+	assert.Equal(t, restores+1, adminActions.Value(AdminRestore, "success"))
+	if assert.NotEmpty(t, notifier.events) {
+		event := notifier.events[len(notifier.events)-1]
+		assert.Equal(t, notify.EventAdminAction, event.Type)
+		assert.Contains(t, event.Message, "alice requested restore_snapshot on snapshot memory://backups/vault/20240101T000000Z.snap")
+	}
+
+	// A snapshot of another cluster needs force
+	assert.ErrorContains(t, c.RestoreSnapshot(context.Background(), "alice", "staging/20240101T000000Z.snap", false), "snapshot-force")
+	assert.NoError(t, c.RestoreSnapshot(context.Background(), "alice", "staging/20240101T000000Z.snap", true))
+	restored, forced = cluster[0].Restored()
+	assert.Equal(t, other, restored)
+	assert.True(t, forced)
+
+	assert.ErrorContains(t, c.RestoreSnapshot(context.Background(), "alice", "vault/missing.snap", false), "no object")
+
+	// An encrypted snapshot is decrypted first, with the keys it was encrypted with
+	var encrypted bytes.Buffer
+	w, err := backup.Encrypt(context.Background(), testKeys{}, &encrypted)
+	assert.NoError(t, err)
+	w.Write(vaulttest.Snapshot)
+	assert.NoError(t, w.Close())
+	store.objects["vault/20240102T000000Z.snap.enc"] = encrypted.Bytes()
+	assert.ErrorContains(t, c.RestoreSnapshot(context.Background(), "alice", "vault/20240102T000000Z.snap.enc", false), "BACKUP_ENCRYPTION_KMS_KEY_ID")
+	c.SetBackupEncryption(testKeys{})
+	assert.NoError(t, c.RestoreSnapshot(context.Background(), "alice", "vault/20240102T000000Z.snap.enc", false))
+	restored, _ = cluster[0].Restored()
+	assert.Equal(t, vaulttest.Snapshot, restored)
+
+	// Nothing is restored while another actor holds the action lock
+	lock, err := c.k8sClient.AcquireActionLock("vault", kubernetes.ActionRekey, "vault-utils-cli/bob@laptop")
+	assert.NoError(t, err)
+	defer lock.Release()
+	assert.ErrorContains(t, c.RestoreSnapshot(context.Background(), "alice", "vault/20240101T000000Z.snap", false), "action lock held")
+}
@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/getgrowly/vault-utils/pkg/notify"
+	"github.com/getgrowly/vault-utils/pkg/reason"
+	"github.com/getgrowly/vault-utils/pkg/status"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+)
+
+// revokeRootToken revokes the root token stored at init once the cluster is healthy, when
+// REVOKE_ROOT_TOKEN is set. An orphan admin token with the configured policies is created and
+// stored first, so it outlives the root token. Revocation is recorded on the root token Secret,
+// which is emptied, so it is done once. It waits for RAFT_AUTOPILOT, which needs the root token.
+func (c *Controller) revokeRootToken(ctx context.Context) {
+	if !c.cfg.RevokeRootToken || c.rootTokenSettled || c.phase != status.PhaseHealthy {
+		return
+	}
+	if c.cfg.RaftAutopilot && !c.autopilotSettled {
+		return
+	}
+
+	exists, err := c.k8sClient.SecretExists(c.cfg.VaultNamespace, vault.RootTokenSecret)
+	if err != nil {
+		log.Printf("Error looking for the root token to revoke: %v", err)
+
+		return
+	}
+	if !exists {
+		return
+	}
+	secret, err := c.k8sClient.GetSecret(c.cfg.VaultNamespace, vault.RootTokenSecret)
+	if err != nil {
+		log.Printf("Error reading the root token to revoke: %v", err)
+
+		return
+	}
+
+	rootToken := string(secret.Data["token"])
+	switch {
+	case secret.Annotations[vault.RootTokenRevokedAtAnnotation] != "":
+		c.rootTokenSettled = true
+
+		return
+	case secret.Annotations[vault.InitializedByAnnotation] == "":
+		// Only a token this controller stored at init is known not to be needed elsewhere
+		log.Printf("Not revoking the root token in secret %s: it was not stored at init by vault-utils", vault.RootTokenSecret)
+		c.rootTokenSettled = true
+
+		return
+	case secret.Annotations[vault.PGPEncryptedAnnotation] == "true" || rootToken == "":
+		log.Printf("Not revoking the root token in secret %s: it holds no usable root token", vault.RootTokenSecret)
+		c.rootTokenSettled = true
+
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.requestTimeout())
+	defer cancel()
+
+	accessor := ""
+	if len(c.cfg.RevokeRootTokenAdminPolicies) > 0 {
+		if accessor, err = c.storeAdminToken(ctx, rootToken); err != nil {
+			log.Printf("Error creating the admin token, not revoking the root token yet: %v", err)
+
+			return
+		}
+	}
+
+	err = c.active.Do(ctx, func(client *vault.Client) error {
+		return client.RevokeSelf(ctx, rootToken)
+	})
+	var respErr *vault.ResponseError
+	if errors.As(err, &respErr) && respErr.StatusCode == http.StatusForbidden {
+		// Vault no longer knows the token, an earlier pass revoked it without recording it
+		err = nil
+	}
+	if err != nil {
+		log.Printf("Error revoking the root token: %v", err)
+
+		return
+	}
+
+	revokedAt := time.Now().UTC().Format(time.RFC3339)
+	secret = secret.DeepCopy()
+	secret.Data = map[string][]byte{}
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	secret.Annotations[vault.RootTokenRevokedAtAnnotation] = revokedAt
+	if err := c.k8sClient.UpdateSecret(secret); err != nil {
+		log.Printf("Error recording the revoked root token on secret %s: %v", vault.RootTokenSecret, err)
+
+		return
+	}
+	c.rootTokenSettled = true
+
+	log.Printf("Audit: revoked the root token namespace=%s revoked-by=%s revoked-at=%s admin-token-accessor=%s",
+		c.cfg.VaultNamespace, c.identity, revokedAt, accessor)
+	message := fmt.Sprintf("the root token of the Vault cluster in namespace %s was revoked", c.cfg.VaultNamespace)
+	if accessor != "" {
+		message += fmt.Sprintf(", an admin token with policies %s is stored in secret %s", strings.Join(c.cfg.RevokeRootTokenAdminPolicies, ","), vault.AdminTokenSecret)
+	}
+	c.notify(notify.EventRootTokenRevoked, reason.RootTokenRevoked, message)
+}
+
+// storeAdminToken creates the orphan admin token kept in place of the root token and stores it,
+// unless an earlier pass already did. It returns the accessor of the token.
+func (c *Controller) storeAdminToken(ctx context.Context, rootToken string) (string, error) {
+	exists, err := c.k8sClient.SecretExists(c.cfg.VaultNamespace, vault.AdminTokenSecret)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		secret, err := c.k8sClient.GetSecret(c.cfg.VaultNamespace, vault.AdminTokenSecret)
+		if err != nil {
+			return "", err
+		}
+
+		return string(secret.Data["accessor"]), nil
+	}
+
+	var auth *vault.TokenAuth
+	err = c.active.Do(ctx, func(client *vault.Client) error {
+		var err error
+		auth, err = client.CreateToken(ctx, rootToken, vault.TokenCreateRequest{
+			Policies:    c.cfg.RevokeRootTokenAdminPolicies,
+			DisplayName: "vault-utils-admin",
+			Meta:        map[string]string{"issued_by": c.identity},
+			NoParent:    true,
+		})
+
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      vault.AdminTokenSecret,
+			Namespace: c.cfg.VaultNamespace,
+		},
+		Data: map[string][]byte{
+			"token":    []byte(auth.ClientToken),
+			"accessor": []byte(auth.Accessor),
+		},
+	}
+	if _, err := c.formatKeySecret(secret); err != nil {
+		log.Printf("Warning: storing the admin token without the configured labels and annotations: %v", err)
+	}
+	if err := c.k8sClient.CreateSecret(secret); err != nil {
+		return "", fmt.Errorf("error storing admin token with accessor %s: %v", auth.Accessor, err)
+	}
+
+	return auth.Accessor, nil
+}
@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/getgrowly/vault-utils/pkg/notify"
+	"github.com/getgrowly/vault-utils/pkg/reason"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRevokeRootToken(t *testing.T) {
+	fakeVault := vaulttest.NewServer()
+	defer fakeVault.Close()
+
+	cfg := testConfig()
+	cfg.RevokeRootToken = true
+	cfg.RevokeRootTokenAdminPolicies = []string{"admin"}
+	c := newTestController(t, fake.NewSimpleClientset(), cfg, []*vaulttest.Server{fakeVault})
+	notifier := &recordingNotifier{}
+	c.notifier = notifier
+
+	// The pass initializing and unsealing Vault revokes the root token once the cluster is healthy
+	c.Reconcile(context.Background())
+	assert.True(t, fakeVault.RootTokenRevoked())
+
+	rootSecret, err := c.k8sClient.GetSecret("vault", vault.RootTokenSecret)
+	assert.NoError(t, err)
+	assert.Empty(t, rootSecret.Data)
+	assert.NotEmpty(t, rootSecret.Annotations[vault.RootTokenRevokedAtAnnotation])
+
+	adminSecret, err := c.k8sClient.GetSecret("vault", vault.AdminTokenSecret)
+	assert.NoError(t, err)
+	token, ok := fakeVault.Tokens()[string(adminSecret.Data["accessor"])]
+	assert.True(t, ok, "expected the stored admin token to be created in Vault")
+	assert.True(t, token.NoParent)
+	assert.Equal(t, []string{"admin"}, token.Policies)
+
+	revoked := func() int {
+		count := 0
+		for _, event := range notifier.events {
+			if event.Type == notify.EventRootTokenRevoked {
+				assert.Equal(t, reason.RootTokenRevoked, event.Reason)
+				count++
+			}
+		}
+
+		return count
+	}
+	assert.Equal(t, 1, revoked())
+
+	// Privileged actions refuse the revoked token
+	_, err = c.CreateAdminToken(context.Background(), "alice", vault.TokenCreateRequest{Policies: []string{"admin"}})
+	assert.ErrorContains(t, err, "was revoked")
+
+	// Revocation is done once
+	c.Reconcile(context.Background())
+	assert.Equal(t, 1, revoked())
+	assert.Len(t, fakeVault.Tokens(), 1)
+}
+
+func TestRevokeRootTokenKeepsForeignToken(t *testing.T) {
+	fakeVault := vaulttest.NewInitializedServer(5, 3)
+	defer fakeVault.Close()
+
+	clientset := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: vault.RootTokenSecret, Namespace: "vault"},
+		Data:       map[string][]byte{"token": []byte(fakeVault.RootToken())},
+	})
+	storeUnsealKeys(t, clientset, fakeVault.Keys())
+
+	cfg := testConfig()
+	cfg.RevokeRootToken = true
+	c := newTestController(t, clientset, cfg, []*vaulttest.Server{fakeVault})
+
+	// A root token stored by someone else may be needed elsewhere, so it is left alone
+	c.Reconcile(context.Background())
+	assert.False(t, fakeVault.RootTokenRevoked())
+	assert.True(t, c.rootTokenSettled)
+}
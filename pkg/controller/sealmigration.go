@@ -0,0 +1,206 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/getgrowly/vault-utils/pkg/kubernetes"
+	"github.com/getgrowly/vault-utils/pkg/notify"
+	"github.com/getgrowly/vault-utils/pkg/reason"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+)
+
+// RequestSealMigration has the controller migrate the seal of the pods waiting for it for
+// requester, as SEAL_MIGRATION does, until a pass finds none waiting anymore. A pass starts
+// right away.
+func (c *Controller) RequestSealMigration(requester string) {
+	c.sealMigrationRequested.Store(true)
+	c.auditAdmin(AdminAudit{Action: AdminMigrateSeal, Requester: requester, Target: "pods waiting for a seal migration"}, nil)
+
+	select {
+	case c.events <- "":
+	default:
+	}
+}
+
+// sealMigrationAllowed reports whether keys may be submitted with the migrate flag
+func (c *Controller) sealMigrationAllowed() bool {
+	return c.cfg.SealMigration || c.sealMigrationRequested.Load()
+}
+
+// finishSealMigration withdraws a seal migration requested through the admin API once no checked
+// pod waits for one
+func (c *Controller) finishSealMigration(statuses map[string]*vault.Status) {
+	if !c.sealMigrationRequested.Load() {
+		return
+	}
+	for _, vaultStatus := range statuses {
+		if vaultStatus.Sealed && vaultStatus.Migration {
+			return
+		}
+	}
+
+	c.sealMigrationRequested.Store(false)
+	log.Printf("No Vault pod waits for a seal migration anymore, the requested migration is over")
+}
+
+// migrateSeal submits the stored keys with the migrate flag to a pod waiting for its seal to be
+// migrated, until Vault migrated the seal and opened
+func (c *Controller) migrateSeal(ctx context.Context, pod string, vaultClient *vault.Client, status *vault.Status) error {
+	keys, source, err := c.sealMigrationKeys()
+	if err != nil {
+		return err
+	}
+
+	if c.cfg.VerifyClusterIdentity {
+		if err := c.verifyClusterIdentity(status); err != nil {
+			return reason.Errorf(reason.IdentityMismatch, "refusing to send keys: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.requestTimeout())
+	defer cancel()
+
+	log.Printf("Migrating the seal of Vault pod %s with the keys in secret %s", pod, source)
+	for _, key := range keys {
+		migrated, err := vaultClient.UnsealMigrate(ctx, vault.HexKey(key))
+		if errors.Is(err, vault.ErrUnreachable) {
+			return reason.Errorf(reason.VaultUnreachable, "error migrating the seal: %v", err)
+		}
+		if err != nil {
+			return reason.Errorf(reason.SealMigrationFailed, "error migrating the seal: %v", err)
+		}
+
+		if !migrated.Sealed {
+			delete(c.unsealNonces, pod)
+			c.checkClusterIdentity(pod, migrated)
+			c.storeMigratedKeys(source, migrated)
+			c.notify(notify.EventSealMigrated, reason.SealMigrated, fmt.Sprintf("migrated the seal of pod %s in namespace %s to %s", pod, c.cfg.VaultNamespace, migrated.Type))
+
+			return nil
+		}
+		c.recordUnsealProgress(pod, migrated.UnsealProgress())
+	}
+
+	return reason.Errorf(reason.SealMigrationFailed, "vault is still waiting for its seal to be migrated after %d keys were submitted", len(keys))
+}
+
+// sealMigrationKeys returns the keys a pod waiting for its seal to be migrated takes and the
+// Secret they are stored in: the unseal keys when it moves away from Shamir, otherwise the
+// recovery keys of the auto-unseal seal it moves away from
+func (c *Controller) sealMigrationKeys() ([]string, string, error) {
+	keys, err := c.loadUnsealKeys()
+	if err == nil && c.unsealKeys.encrypted {
+		return nil, "", reason.Errorf(reason.UnsealKeysEncrypted, "the unseal keys are encrypted to operators' PGP keys, an operator must decrypt and submit them")
+	}
+	if err == nil && len(keys) > 0 {
+		return keys, vault.UnsealKeysSecret, nil
+	}
+
+	exists, existsErr := c.k8sClient.SecretExists(c.cfg.VaultNamespace, vault.RecoveryKeysSecret)
+	if existsErr != nil {
+		return nil, "", reason.Errorf(reason.UnsealKeysUnavailable, "error looking for the recovery keys secret: %v", existsErr)
+	}
+	if !exists {
+		if err != nil {
+			return nil, "", err
+		}
+
+		return nil, "", reason.Errorf(reason.UnsealNoKeys, "no unseal or recovery keys found")
+	}
+
+	secret, err := c.k8sClient.GetSecret(c.cfg.VaultNamespace, vault.RecoveryKeysSecret)
+	if err != nil {
+		return nil, "", reason.Errorf(reason.UnsealKeysUnavailable, "error getting recovery keys secret: %v", err)
+	}
+	if secret.Annotations[vault.PGPEncryptedAnnotation] == "true" {
+		return nil, "", reason.Errorf(reason.UnsealKeysEncrypted, "the recovery keys are encrypted to operators' PGP keys, an operator must decrypt and submit them")
+	}
+	keys, missing := kubernetes.UnsealKeysFromSecret(secret.Data)
+	if len(missing) > 0 {
+		log.Printf("Warning: recovery keys secret has gaps in its numbering, missing %s", strings.Join(missing, ", "))
+	}
+	if len(keys) == 0 {
+		return nil, "", reason.Errorf(reason.UnsealNoKeys, "no recovery keys found")
+	}
+
+	return keys, vault.RecoveryKeysSecret, nil
+}
+
+// storeMigratedKeys copies the keys a seal migration used to the Secret of the new seal, since
+// Vault keeps them: unseal keys become the recovery keys of an auto-unseal seal, and recovery
+// keys the unseal keys of a Shamir seal. The Secret they came from is kept for the pods still to
+// be migrated. Keys kept with a key provider stay where they are.
+func (c *Controller) storeMigratedKeys(source string, migrated *vault.Status) {
+	target := vault.UnsealKeysSecret
+	if migrated.AutoUnseal() {
+		target = vault.RecoveryKeysSecret
+	}
+	if c.keyProvider != nil || source == target {
+		return
+	}
+
+	secret, err := c.k8sClient.GetSecret(c.cfg.VaultNamespace, source)
+	if err != nil {
+		log.Printf("Warning: could not copy the keys in secret %s to secret %s after the seal migration: %v", source, target, err)
+
+		return
+	}
+
+	exists, err := c.k8sClient.SecretExists(c.cfg.VaultNamespace, target)
+	if err != nil {
+		log.Printf("Warning: could not copy the keys in secret %s to secret %s after the seal migration: %v", source, target, err)
+
+		return
+	}
+	var existing *corev1.Secret
+	if exists {
+		if existing, err = c.k8sClient.GetSecret(c.cfg.VaultNamespace, target); err != nil {
+			log.Printf("Warning: could not copy the keys in secret %s to secret %s after the seal migration: %v", source, target, err)
+
+			return
+		}
+		// Pods migrated earlier already copied them
+		if maps.EqualFunc(existing.Data, secret.Data, bytes.Equal) {
+			return
+		}
+	}
+
+	copied := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        target,
+			Namespace:   c.cfg.VaultNamespace,
+			Labels:      maps.Clone(secret.Labels),
+			Annotations: maps.Clone(secret.Annotations),
+		},
+		Type: secret.Type,
+		Data: secret.Data,
+	}
+	if copied.Annotations == nil {
+		copied.Annotations = make(map[string]string)
+	}
+	copied.Annotations[vault.SealMigratedAtAnnotation] = time.Now().UTC().Format(time.RFC3339)
+
+	if existing != nil {
+		copied.ResourceVersion = existing.ResourceVersion
+		err = c.k8sClient.UpdateSecret(copied)
+	} else {
+		err = c.k8sClient.CreateSecret(copied)
+	}
+	if err != nil {
+		log.Printf("Warning: could not copy the keys in secret %s to secret %s after the seal migration: %v", source, target, err)
+
+		return
+	}
+	log.Printf("Copied the keys in secret %s to secret %s, which the new %s seal uses; delete secret %s once every pod was migrated",
+		source, target, migrated.Type, source)
+}
@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/getgrowly/vault-utils/pkg/notify"
+	"github.com/getgrowly/vault-utils/pkg/reason"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSealMigrationWaitsForRequest(t *testing.T) {
+	fakeVault := vaulttest.NewInitializedServer(3, 2)
+	defer fakeVault.Close()
+	fakeVault.StartSealMigration()
+
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, fakeVault.Keys())
+	c := newTestController(t, clientset, testConfig(), []*vaulttest.Server{fakeVault})
+	notifier := &recordingNotifier{}
+	c.notifier = notifier
+
+	// Keys are not submitted with the migrate flag unless asked to
+	c.Reconcile(context.Background())
+	assert.True(t, fakeVault.Sealed())
+	assert.Equal(t, reason.SealMigrationPending, c.status.Snapshot().Pods[0].Reason)
+
+	c.RequestSealMigration("alice")
+	c.Reconcile(context.Background())
+	assert.False(t, fakeVault.Sealed())
+	assert.True(t, fakeVault.RecoverySeal())
+	assert.False(t, c.sealMigrationRequested.Load(), "expected the request to end once no pod waits for a migration")
+
+	// The Shamir keys are the recovery keys of the new seal
+	recovery, err := c.k8sClient.GetSecret("vault", vault.RecoveryKeysSecret)
+	assert.NoError(t, err)
+	assert.Equal(t, fakeVault.Keys()[0], string(recovery.Data["key1"]))
+	assert.NotEmpty(t, recovery.Annotations[vault.SealMigratedAtAnnotation])
+
+	var migrated []reason.Code
+	for _, event := range notifier.events {
+		if event.Type == notify.EventSealMigrated {
+			migrated = append(migrated, event.Reason)
+		}
+	}
+	assert.Equal(t, []reason.Code{reason.SealMigrated}, migrated)
+}
+
+func TestSealMigrationToShamir(t *testing.T) {
+	fakeVault := vaulttest.NewAutoUnsealServer(3, 2)
+	defer fakeVault.Close()
+	fakeVault.StartSealMigration()
+
+	data := make(map[string][]byte)
+	for i, key := range fakeVault.Keys() {
+		data[fmt.Sprintf("key%d", i+1)] = []byte(key)
+	}
+	clientset := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: vault.RecoveryKeysSecret, Namespace: "vault"},
+		Data:       data,
+	})
+
+	cfg := testConfig()
+	cfg.SealMigration = true
+	c := newTestController(t, clientset, cfg, []*vaulttest.Server{fakeVault})
+
+	c.Reconcile(context.Background())
+	assert.False(t, fakeVault.Sealed())
+	assert.False(t, fakeVault.RecoverySeal())
+
+	// The recovery keys now unseal Vault, so the next restart is unsealed with them
+	unseal, err := c.k8sClient.GetSecret("vault", vault.UnsealKeysSecret)
+	assert.NoError(t, err)
+	assert.Equal(t, data, unseal.Data)
+
+	fakeVault.Seal()
+	c.Reconcile(context.Background())
+	assert.False(t, fakeVault.Sealed())
+}
@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/vault"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// upgradeKeySecrets brings the unseal and recovery keys Secrets written by earlier versions, or
+// by other tools through the migrate command, to the current format: their keys re-encoded in
+// KEY_ENCODING, and the encoding and seal configuration recorded as annotations. A Secret is
+// only upgraded once a pod with its kind of seal reports the seal configuration, so it is tried
+// again on every pass until then. Secrets in a format newer than this version knows are left
+// alone.
+func (c *Controller) upgradeKeySecrets(statuses map[string]*vault.Status) {
+	if c.keySecretsUpgraded {
+		return
+	}
+
+	upgraded := true
+	for _, name := range []string{vault.UnsealKeysSecret, vault.RecoveryKeysSecret} {
+		exists, err := c.k8sClient.SecretExists(c.cfg.VaultNamespace, name)
+		if err != nil {
+			log.Printf("Error checking secret %s: %v", name, err)
+
+			return
+		}
+		if !exists {
+			continue
+		}
+
+		secret, err := c.k8sClient.GetSecret(c.cfg.VaultNamespace, name)
+		if err != nil {
+			log.Printf("Error reading secret %s: %v", name, err)
+
+			return
+		}
+
+		format := secret.Annotations[vault.FormatAnnotation]
+		if format == vault.SecretFormat {
+			continue
+		}
+		if format != "" {
+			log.Printf("Warning: secret %s is in format %s, written by a newer vault-utils, leaving it as is", name, format)
+
+			continue
+		}
+
+		threshold, shares := sealConfiguration(statuses, name == vault.RecoveryKeysSecret)
+		if threshold == 0 && secret.Annotations[vault.ThresholdAnnotation] == "" {
+			upgraded = false
+
+			continue
+		}
+
+		secret = secret.DeepCopy()
+		from := c.upgradeKeySecret(secret, threshold, shares)
+		if _, err := c.formatKeySecret(secret); err != nil {
+			log.Printf("Warning: upgrading secret %s without the configured labels, annotations and format: %v", name, err)
+		}
+		// The update carries the resource version read above, so keys written meanwhile are not lost
+		if err := c.k8sClient.UpdateSecret(secret); err != nil {
+			log.Printf("Error upgrading secret %s to format %s: %v", name, vault.SecretFormat, err)
+			upgraded = false
+
+			continue
+		}
+		log.Printf("Audit: upgraded secret %s to format %s namespace=%s upgraded-by=%s %s",
+			name, vault.SecretFormat, c.cfg.VaultNamespace, c.identity, from)
+	}
+
+	c.keySecretsUpgraded = upgraded
+}
+
+// upgradeKeySecret rewrites secret in the current format and describes what changed. Keys
+// encrypted to PGP keys, or that are neither hex nor base64, keep their bytes.
+func (c *Controller) upgradeKeySecret(secret *corev1.Secret, threshold, shares int) string {
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	var changes []string
+
+	encoding := c.keyEncoding()
+	if secret.Annotations[vault.PGPEncryptedAnnotation] != "true" {
+		encoded := make(map[string][]byte, len(secret.Data))
+		reencoded := 0
+		for name, value := range secret.Data {
+			if !strings.HasPrefix(name, "key") {
+				continue
+			}
+			key, ok := vault.EncodeKey(string(value), encoding)
+			if !ok {
+				// A key that cannot be decoded is kept as is, and so are the others with it
+				encoded = nil
+
+				break
+			}
+			encoded[name] = []byte(key)
+			if key != string(value) {
+				reencoded++
+			}
+		}
+		if encoded != nil {
+			for name, value := range encoded {
+				secret.Data[name] = value
+			}
+			if reencoded > 0 {
+				changes = append(changes, "keys-reencoded="+strconv.Itoa(reencoded))
+			}
+			if secret.Annotations[vault.KeyEncodingAnnotation] != encoding {
+				secret.Annotations[vault.KeyEncodingAnnotation] = encoding
+				changes = append(changes, "key-encoding="+encoding)
+			}
+		}
+	}
+
+	if secret.Annotations[vault.ThresholdAnnotation] == "" {
+		secret.Annotations[vault.ThresholdAnnotation] = strconv.Itoa(threshold)
+		secret.Annotations[vault.SharesAnnotation] = strconv.Itoa(shares)
+		changes = append(changes, "threshold="+strconv.Itoa(threshold), "shares="+strconv.Itoa(shares))
+	}
+
+	secret.Annotations[vault.FormatAnnotation] = vault.SecretFormat
+	secret.Annotations[vault.FormatUpgradedAtAnnotation] = time.Now().UTC().Format(time.RFC3339)
+
+	return strings.Join(changes, " ")
+}
+
+// sealConfiguration returns the threshold and shares reported by the first initialized pod with
+// an auto-unseal seal when recovery is set, or a Shamir seal otherwise, or zeros when none does
+func sealConfiguration(statuses map[string]*vault.Status, recovery bool) (threshold, shares int) {
+	pods := make([]string, 0, len(statuses))
+	for pod := range statuses {
+		pods = append(pods, pod)
+	}
+	sort.Strings(pods)
+
+	for _, pod := range pods {
+		vaultStatus := statuses[pod]
+		if vaultStatus.Initialized && vaultStatus.AutoUnseal() == recovery && vaultStatus.Threshold > 0 {
+			return vaultStatus.Threshold, vaultStatus.Shares
+		}
+	}
+
+	return 0, 0
+}
@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	"github.com/getgrowly/vault-utils/pkg/vault"
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestUpgradeKeySecrets(t *testing.T) {
+	fakeVault := vaulttest.NewInitializedServer(5, 3)
+	defer fakeVault.Close()
+
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, fakeVault.Keys())
+	cfg := testConfig()
+	cfg.KeyEncoding = vault.KeyEncodingBase64
+	c := newTestController(t, clientset, cfg, []*vaulttest.Server{fakeVault})
+
+	c.Reconcile(context.Background())
+	assert.False(t, fakeVault.Sealed(), "the keys should unseal Vault in the pass that upgrades them")
+
+	secret, err := clientset.CoreV1().Secrets("vault").Get(context.Background(), vault.UnsealKeysSecret, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, vault.SecretFormat, secret.Annotations[vault.FormatAnnotation])
+	assert.NotEmpty(t, secret.Annotations[vault.FormatUpgradedAtAnnotation])
+	assert.Equal(t, vault.KeyEncodingBase64, secret.Annotations[vault.KeyEncodingAnnotation])
+	assert.Equal(t, "3", secret.Annotations[vault.ThresholdAnnotation])
+	assert.Equal(t, "5", secret.Annotations[vault.SharesAnnotation])
+	raw, _ := hex.DecodeString(fakeVault.Keys()[0])
+	assert.Equal(t, base64.StdEncoding.EncodeToString(raw), string(secret.Data["key1"]))
+	assert.True(t, c.keySecretsUpgraded)
+
+	// The upgraded keys still unseal Vault
+	fakeVault.Seal()
+	c.Reconcile(context.Background())
+	assert.False(t, fakeVault.Sealed())
+}
+
+func TestUpgradeKeySecretsWaitsForSealConfiguration(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, []string{"0a1b", "0c1d"})
+	c := newTestController(t, clientset, testConfig(), nil)
+
+	// No pod reported the seal configuration yet
+	c.upgradeKeySecrets(map[string]*vault.Status{"10.0.0.1": {Initialized: false}})
+	assert.False(t, c.keySecretsUpgraded)
+	secret, _ := clientset.CoreV1().Secrets("vault").Get(context.Background(), vault.UnsealKeysSecret, metav1.GetOptions{})
+	assert.Empty(t, secret.Annotations[vault.FormatAnnotation])
+
+	// An auto-unseal pod reports the configuration of the recovery keys, not the unseal keys
+	c.upgradeKeySecrets(map[string]*vault.Status{"10.0.0.1": {Initialized: true, Type: "awskms", Threshold: 3, Shares: 5}})
+	assert.False(t, c.keySecretsUpgraded)
+
+	c.upgradeKeySecrets(map[string]*vault.Status{"10.0.0.1": {Initialized: true, Type: vault.SealTypeShamir, Threshold: 2, Shares: 2}})
+	assert.True(t, c.keySecretsUpgraded)
+	secret, _ = clientset.CoreV1().Secrets("vault").Get(context.Background(), vault.UnsealKeysSecret, metav1.GetOptions{})
+	assert.Equal(t, vault.SecretFormat, secret.Annotations[vault.FormatAnnotation])
+	assert.Equal(t, "2", secret.Annotations[vault.ThresholdAnnotation])
+	assert.Equal(t, vault.KeyEncodingHex, secret.Annotations[vault.KeyEncodingAnnotation])
+	assert.Equal(t, "0a1b", string(secret.Data["key1"]))
+}
+
+func TestUpgradeKeySecretsKeepsUnknownFormats(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		data        map[string][]byte
+		expected    map[string][]byte
+	}{
+		{
+			name:        "newer format",
+			annotations: map[string]string{vault.FormatAnnotation: "v3"},
+			data:        map[string][]byte{"key1": []byte("0a1b")},
+			expected:    map[string][]byte{"key1": []byte("0a1b")},
+		},
+		{
+			name:        "PGP-encrypted keys",
+			annotations: map[string]string{vault.PGPEncryptedAnnotation: "true"},
+			data:        map[string][]byte{"key1": []byte("wcBMA0ZlbmNyeXB0ZWQ=")},
+			expected:    map[string][]byte{"key1": []byte("wcBMA0ZlbmNyeXB0ZWQ=")},
+		},
+		{
+			name:     "a key that is neither hex nor base64",
+			data:     map[string][]byte{"key1": []byte("0a1b"), "key2": []byte("not-a-key")},
+			expected: map[string][]byte{"key1": []byte("0a1b"), "key2": []byte("not-a-key")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: vault.UnsealKeysSecret, Namespace: "vault", Annotations: tt.annotations},
+				Data:       tt.data,
+			})
+			cfg := testConfig()
+			cfg.KeyEncoding = vault.KeyEncodingBase64
+			c := newTestController(t, clientset, cfg, nil)
+
+			c.upgradeKeySecrets(map[string]*vault.Status{"10.0.0.1": {Initialized: true, Type: vault.SealTypeShamir, Threshold: 1, Shares: 2}})
+			secret, _ := clientset.CoreV1().Secrets("vault").Get(context.Background(), vault.UnsealKeysSecret, metav1.GetOptions{})
+			assert.Equal(t, tt.expected, secret.Data)
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/getgrowly/vault-utils/pkg/kubernetes"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// unsealKeysJSONKey is the property of the unseal keys Secret holding every key as one JSON
+// document. It does not start with "key", so it is never mistaken for a key itself.
+const unsealKeysJSONKey = "unseal-keys.json"
+
+// unsealKeysJSON is the document stored under unsealKeysJSONKey
+type unsealKeysJSON struct {
+	Keys      []string `json:"keys"`
+	Threshold int      `json:"threshold,omitempty"`
+}
+
+// formatKeySecret adds the configured labels and annotations to a Secret holding key material,
+// and the JSON document to the unseal keys Secret, so secret distribution tools such as
+// external-secrets can pick them up. It reports whether the Secret changed.
+func (c *Controller) formatKeySecret(secret *corev1.Secret) (bool, error) {
+	labels, annotations, err := c.cfg.SecretMetadata()
+	if err != nil {
+		return false, err
+	}
+
+	changed := false
+	for key, value := range labels {
+		if current, ok := secret.Labels[key]; !ok || current != value {
+			if secret.Labels == nil {
+				secret.Labels = make(map[string]string)
+			}
+			secret.Labels[key] = value
+			changed = true
+		}
+	}
+	for key, value := range annotations {
+		if current, ok := secret.Annotations[key]; !ok || current != value {
+			if secret.Annotations == nil {
+				secret.Annotations = make(map[string]string)
+			}
+			secret.Annotations[key] = value
+			changed = true
+		}
+	}
+
+	if !c.cfg.SecretKeysJSON || secret.Name != vault.UnsealKeysSecret {
+		return changed, nil
+	}
+
+	keys, _ := kubernetes.UnsealKeysFromSecret(secret.Data)
+	if len(keys) == 0 {
+		return changed, nil
+	}
+
+	// An invalid threshold annotation is reported when unsealing and left out here
+	doc := unsealKeysJSON{Keys: keys}
+	if threshold, err := strconv.Atoi(secret.Annotations[vault.ThresholdAnnotation]); err == nil && threshold > 0 {
+		doc.Threshold = threshold
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return changed, fmt.Errorf("failed to encode unseal keys: %v", err)
+	}
+
+	if !bytes.Equal(secret.Data[unsealKeysJSONKey], data) {
+		secret.Data[unsealKeysJSONKey] = data
+		changed = true
+	}
+
+	return changed, nil
+}
+
+// syncKeySecrets formats the Secrets holding key material that already exist, once per
+// controller start, so a changed configuration reaches Secrets written before it
+func (c *Controller) syncKeySecrets() {
+	if c.keySecretsSynced || (c.cfg.SecretLabels == "" && c.cfg.SecretAnnotations == "" && !c.cfg.SecretKeysJSON) {
+		return
+	}
+
+	for _, name := range []string{vault.UnsealKeysSecret, vault.RootTokenSecret} {
+		exists, err := c.k8sClient.SecretExists(c.cfg.VaultNamespace, name)
+		if err != nil {
+			log.Printf("Error checking secret %s: %v", name, err)
+
+			return
+		}
+		if !exists {
+			continue
+		}
+
+		secret, err := c.k8sClient.GetSecret(c.cfg.VaultNamespace, name)
+		if err != nil {
+			log.Printf("Error reading secret %s: %v", name, err)
+
+			return
+		}
+
+		changed, err := c.formatKeySecret(secret)
+		if err != nil {
+			log.Printf("Error formatting secret %s: %v", name, err)
+
+			return
+		}
+		if !changed {
+			continue
+		}
+
+		if err := c.k8sClient.UpdateSecret(secret); err != nil {
+			log.Printf("Error updating secret %s: %v", name, err)
+
+			return
+		}
+		log.Printf("Updated the labels, annotations and format of secret %s", name)
+	}
+
+	c.keySecretsSynced = true
+}
@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/getgrowly/vault-utils/pkg/vault"
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReconcileFormatsKeySecrets(t *testing.T) {
+	fakeVault := vaulttest.NewServer()
+	defer fakeVault.Close()
+
+	cfg := testConfig()
+	cfg.SecretLabels = "app.kubernetes.io/part-of=vault-keys"
+	cfg.SecretAnnotations = "team=platform"
+	cfg.SecretKeysJSON = true
+	clientset := fake.NewSimpleClientset()
+	c := newTestController(t, clientset, cfg, []*vaulttest.Server{fakeVault})
+
+	c.Reconcile(context.Background())
+
+	unsealKeys, err := clientset.CoreV1().Secrets("vault").Get(context.Background(), vault.UnsealKeysSecret, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected unseal keys secret: %v", err)
+	}
+	var doc unsealKeysJSON
+	if err := json.Unmarshal(unsealKeys.Data[unsealKeysJSONKey], &doc); err != nil {
+		t.Fatalf("expected the keys as JSON: %v", err)
+	}
+	if len(doc.Keys) != len(fakeVault.Keys()) || doc.Threshold != 3 {
+		t.Errorf("unexpected keys document %+v", doc)
+	}
+
+	for _, name := range []string{vault.UnsealKeysSecret, vault.RootTokenSecret} {
+		secret, err := clientset.CoreV1().Secrets("vault").Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if secret.Labels["app.kubernetes.io/part-of"] != "vault-keys" || secret.Annotations["team"] != "platform" {
+			t.Errorf("expected %s to carry the configured metadata, got %v %v", name, secret.Labels, secret.Annotations)
+		}
+	}
+
+	// A changed configuration reaches the existing Secrets after a restart
+	cfg.SecretLabels = "distribute=true"
+	restarted := New(c.k8sClient, cfg, c.notifier)
+	restarted.vaultAddress = c.vaultAddress
+	restarted.Reconcile(context.Background())
+
+	rootToken, err := clientset.CoreV1().Secrets("vault").Get(context.Background(), vault.RootTokenSecret, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rootToken.Labels["distribute"] != "true" {
+		t.Errorf("expected the new label on the existing secret, got %v", rootToken.Labels)
+	}
+	if fakeVault.Sealed() {
+		t.Errorf("expected the keys to keep working next to the JSON document")
+	}
+}
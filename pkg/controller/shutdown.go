@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/notify"
+	"github.com/getgrowly/vault-utils/pkg/reason"
+	"github.com/getgrowly/vault-utils/pkg/status"
+)
+
+// pendingActions is what is still to be done for a pod in each state
+var pendingActions = map[string]string{
+	status.Unreachable:   "reach",
+	status.Uninitialized: "initialize",
+	status.Sealed:        "unseal",
+}
+
+// ShutdownReport is the state the controller last saw, recorded when it stops so the state
+// things were in when it was last alive can be found after the fact
+type ShutdownReport struct {
+	Namespace string    `json:"namespace"`
+	Identity  string    `json:"identity"`
+	StartedAt time.Time `json:"started_at"`
+	StoppedAt time.Time `json:"stopped_at"`
+	// Pods counts the Vault pods in each state: unsealed, sealed, uninitialized or unreachable
+	Pods map[string]int `json:"pods"`
+	// Active is the pod last found to be the active node, if any
+	Active string `json:"active,omitempty"`
+	// Pending lists what the controller still had to do, one entry per pod
+	Pending []string `json:"pending,omitempty"`
+	// Warnings are the /status warnings at the time
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// ShutdownReport summarizes the latest pass for the record
+func (c *Controller) ShutdownReport() ShutdownReport {
+	snapshot := c.status.Snapshot()
+
+	report := ShutdownReport{
+		Namespace: c.cfg.VaultNamespace,
+		Identity:  c.identity,
+		StartedAt: c.startedAt,
+		StoppedAt: time.Now().UTC(),
+		Pods:      make(map[string]int),
+		Active:    snapshot.Active,
+		Warnings:  snapshot.Warnings,
+	}
+
+	for _, pod := range snapshot.Pods {
+		state := pod.State()
+		report.Pods[state]++
+
+		pending := pendingActions[state]
+
+		if pending == "" {
+			continue
+		}
+		entry := fmt.Sprintf("%s pod %s", pending, pod.Pod)
+		if pod.Reason != "" {
+			entry += fmt.Sprintf(" (%s)", pod.Reason)
+		}
+		report.Pending = append(report.Pending, entry)
+	}
+
+	return report
+}
+
+// String summarizes the report in one line, such as "3 pods: 2 unsealed, 1 sealed"
+func (r ShutdownReport) String() string {
+	total := 0
+	states := make([]string, 0, len(r.Pods))
+	for state, count := range r.Pods {
+		total += count
+		states = append(states, fmt.Sprintf("%d %s", count, state))
+	}
+	sort.Strings(states)
+
+	summary := fmt.Sprintf("%d pods in namespace %s", total, r.Namespace)
+	if len(states) > 0 {
+		summary += ": " + strings.Join(states, ", ")
+	}
+	if len(r.Pending) > 0 {
+		summary += "; pending: " + strings.Join(r.Pending, ", ")
+	}
+
+	return summary
+}
+
+// reportShutdown logs the shutdown report and sends it as a notification when configured
+func (c *Controller) reportShutdown() {
+	report := c.ShutdownReport()
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		log.Printf("Error encoding shutdown report: %v", err)
+	} else {
+		log.Printf("Shutdown report: %s", data)
+	}
+
+	if !c.cfg.NotifyShutdown {
+		return
+	}
+
+	event := notify.Event{
+		Type:      notify.EventShutdown,
+		Reason:    reason.Shutdown,
+		Component: "controller",
+		Message:   fmt.Sprintf("controller %s stopped with %s", c.identity, report),
+		Details:   report,
+	}
+	if err := c.notifier.Notify(event); err != nil {
+		log.Printf("Error sending %s notification: %v", notify.EventShutdown, err)
+	}
+}
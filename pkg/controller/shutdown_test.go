@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/getgrowly/vault-utils/pkg/notify"
+	"github.com/getgrowly/vault-utils/pkg/reason"
+	"github.com/getgrowly/vault-utils/pkg/status"
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestShutdownReport(t *testing.T) {
+	fakes := vaulttest.NewCluster(3, 1, 1)
+	for _, fakeVault := range fakes {
+		defer fakeVault.Close()
+	}
+	fakes[2].Close()
+
+	cfg := testConfig()
+	cfg.NotifyShutdown = true
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, fakes[0].Keys())
+	c := newTestController(t, clientset, cfg, fakes)
+	notifier := &recordingNotifier{}
+	c.notifier = notifier
+
+	c.Reconcile(context.Background())
+	// The second pod is left sealed, as if its keys could not be read
+	c.status.Update("10.0.0.2", func(p *status.Pod) {
+		p.Sealed = true
+		p.Reason = reason.UnsealKeysUnavailable
+	})
+
+	report := c.ShutdownReport()
+	if report.Namespace != "vault" || report.Pods[status.Unsealed] != 1 || report.Pods[status.Sealed] != 1 || report.Pods[status.Unreachable] != 1 {
+		t.Errorf("unexpected pod counts %+v", report)
+	}
+	if len(report.Pending) != 2 || report.Pending[0] != "unseal pod 10.0.0.2 (UNSEAL_KEYS_UNAVAILABLE)" || !strings.HasPrefix(report.Pending[1], "reach pod 10.0.0.3") {
+		t.Errorf("unexpected pending actions %v", report.Pending)
+	}
+	if summary := report.String(); !strings.HasPrefix(summary, "3 pods in namespace vault: 1 sealed, 1 unreachable, 1 unsealed; pending: ") {
+		t.Errorf("unexpected summary %q", summary)
+	}
+
+	c.reportShutdown()
+	last := notifier.events[len(notifier.events)-1]
+	if last.Type != notify.EventShutdown || last.Reason != reason.Shutdown {
+		t.Fatalf("expected a shutdown notification, got %+v", last)
+	}
+	if details, ok := last.Details.(ShutdownReport); !ok || details.Pods[status.Sealed] != 1 {
+		t.Errorf("expected the report in the notification details, got %+v", last.Details)
+	}
+}
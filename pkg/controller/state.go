@@ -0,0 +1,84 @@
+package controller
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/getgrowly/vault-utils/pkg/state"
+)
+
+// persistedState is the operational memory kept in the state file
+type persistedState struct {
+	// ClusterID and ClusterName identify the cluster last seen unsealed
+	ClusterID   string `json:"cluster_id,omitempty"`
+	ClusterName string `json:"cluster_name,omitempty"`
+	// Unreachable holds the failed diagnostic stage of each unreachable pod already reported
+	Unreachable map[string]string `json:"unreachable,omitempty"`
+	// SteppedDown holds the draining pods already stepped down
+	SteppedDown map[string]bool `json:"stepped_down,omitempty"`
+	// UnsealNonces holds the unseal attempts the controller left in progress
+	UnsealNonces map[string]string `json:"unseal_nonces,omitempty"`
+	// ForeignSigns is the last reported summary of other unseal automation
+	ForeignSigns string `json:"foreign_signs,omitempty"`
+}
+
+// SetStateFile restores the operational memory saved in f and saves it there after every pass,
+// so a restarted controller neither reports known problems again nor forgets the cluster it saw
+func (c *Controller) SetStateFile(f *state.File) error {
+	var saved persistedState
+	if err := f.Load(&saved); err != nil {
+		return err
+	}
+
+	c.stateFile = f
+	c.seenClusterID, c.seenClusterName = saved.ClusterID, saved.ClusterName
+	c.foreignSigns = saved.ForeignSigns
+	for pod, stage := range saved.Unreachable {
+		c.unreachable[pod] = stage
+	}
+	for pod, done := range saved.SteppedDown {
+		c.steppedDown[pod] = done
+	}
+	for pod, nonce := range saved.UnsealNonces {
+		c.unsealNonces[pod] = nonce
+	}
+
+	if data, err := json.Marshal(saved); err == nil {
+		c.savedState = string(data)
+	}
+
+	return nil
+}
+
+// saveState writes the operational memory to the state file when it changed
+func (c *Controller) saveState() {
+	if c.stateFile == nil {
+		return
+	}
+
+	current := persistedState{
+		ClusterID:    c.seenClusterID,
+		ClusterName:  c.seenClusterName,
+		Unreachable:  c.unreachable,
+		SteppedDown:  c.steppedDown,
+		UnsealNonces: c.unsealNonces,
+		ForeignSigns: c.foreignSigns,
+	}
+
+	data, err := json.Marshal(current)
+	if err != nil {
+		log.Printf("Error encoding controller state: %v", err)
+
+		return
+	}
+	if string(data) == c.savedState {
+		return
+	}
+
+	if err := c.stateFile.Save(current); err != nil {
+		log.Printf("Error saving controller state to %s: %v", c.stateFile.Path(), err)
+
+		return
+	}
+	c.savedState = string(data)
+}
@@ -0,0 +1,61 @@
+package controller
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/getgrowly/vault-utils/pkg/state"
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestStateFileSurvivesRestart(t *testing.T) {
+	fakes := vaulttest.NewCluster(2, 1, 1)
+	defer fakes[0].Close()
+	fakes[1].Close()
+
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyFile, []byte(strings.Repeat("k", 32)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	openState := func() *state.File {
+		f, err := state.Open(filepath.Join(dir, "state"), keyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return f
+	}
+
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, fakes[0].Keys())
+	first := newTestController(t, clientset, testConfig(), fakes)
+	if err := first.SetStateFile(openState()); err != nil {
+		t.Fatalf("failed to load an empty state: %v", err)
+	}
+	first.Reconcile(context.Background())
+
+	// The restarted controller knows the cluster and has already reported the unreachable pod
+	restarted := New(first.k8sClient, testConfig(), first.notifier)
+	restarted.vaultAddress = first.vaultAddress
+	if err := restarted.SetStateFile(openState()); err != nil {
+		t.Fatalf("failed to load the saved state: %v", err)
+	}
+	if restarted.seenClusterID != fakes[0].ClusterID() {
+		t.Errorf("expected cluster %s to be remembered, got %q", fakes[0].ClusterID(), restarted.seenClusterID)
+	}
+	restarted.Reconcile(context.Background())
+
+	events, err := clientset.CoreV1().Events("vault").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events.Items) != 1 {
+		t.Errorf("expected the unreachable pod to be reported once across the restart, got %d events", len(events.Items))
+	}
+}
@@ -0,0 +1,173 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/config"
+	"github.com/getgrowly/vault-utils/pkg/kubernetes"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// clientCertWatchInterval is how often the client certificate files are checked for rotation
+const clientCertWatchInterval = 5 * time.Second
+
+// ReadVaultCA returns the CA bundle of Vault's listener from VAULT_CACERT, or from the Secret or
+// ConfigMap VAULT_CACERT_FROM references in the Vault namespace, or nil when neither is set
+func ReadVaultCA(k8sClient *kubernetes.Client, cfg *config.Config) ([]byte, error) {
+	if cfg.VaultCACert != "" {
+		caCert, err := os.ReadFile(cfg.VaultCACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Vault CA certificate: %v", err)
+		}
+
+		return caCert, nil
+	}
+
+	if cfg.VaultCACertFrom == "" {
+		return nil, nil
+	}
+
+	kind, name, key, err := cfg.CACertSource()
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := k8sClient.ReadObjectKey(cfg.VaultNamespace, kind, name, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Vault CA certificate: %v", err)
+	}
+
+	return caCert, nil
+}
+
+// refreshVaultTLS loads the CA bundle of Vault's listener and, when it changed, has the pod
+// clients verify against it from then on, so a rotated bundle is picked up without a restart
+func (c *Controller) refreshVaultTLS() {
+	if !c.cfg.TLS() {
+		return
+	}
+	c.refreshClientCertificate()
+
+	caCert, err := ReadVaultCA(c.k8sClient, c.cfg)
+	if err != nil {
+		c.logVaultCAError(err)
+
+		return
+	}
+	if c.vaultTLSLoaded && bytes.Equal(caCert, c.vaultCA) {
+		return
+	}
+
+	var roots *x509.CertPool
+	var certs []*x509.Certificate
+	if caCert != nil {
+		certs, err = vault.ParseCABundle(caCert)
+		if err != nil {
+			c.logVaultCAError(fmt.Errorf("failed to parse Vault CA certificate: %v", err))
+
+			return
+		}
+		roots = vault.NewCertPool(certs)
+	}
+
+	c.vaultClients.SetTLSConfig(vault.NewTLSConfig(roots, c.cfg.VaultTLSServerName, c.clientCert))
+	if c.vaultTLSLoaded {
+		log.Printf("Vault CA certificate changed, reconnecting to the Vault pods")
+	}
+	c.vaultCA, c.vaultCACerts, c.vaultTLSLoaded = caCert, certs, true
+	c.vaultCAError = ""
+}
+
+// logVaultCAError logs an error loading Vault's CA bundle unless it is the same as the last one
+func (c *Controller) logVaultCAError(err error) {
+	if err.Error() == c.vaultCAError {
+		return
+	}
+	c.vaultCAError = err.Error()
+	log.Printf("Error loading Vault CA certificate: %v", err)
+}
+
+// ClientCertificate returns the client certificate presented to Vault, or nil when none is
+// configured
+func (c *Controller) ClientCertificate() *vault.ClientCertificate {
+	return c.clientCert
+}
+
+// refreshClientCertificate loads the client certificate from the Secret VAULT_CLIENT_CERT_FROM
+// names on every pass, and from the VAULT_CLIENT_CERT files until they are first loaded, after
+// which watchClientCertificate reloads them
+func (c *Controller) refreshClientCertificate() {
+	var changed bool
+	var err error
+	switch {
+	case c.cfg.VaultClientCertFrom != "":
+		var secret *corev1.Secret
+		secret, err = c.k8sClient.GetSecret(c.cfg.VaultNamespace, c.cfg.VaultClientCertFrom)
+		if err == nil {
+			changed, err = c.clientCert.Set(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+		}
+	case c.cfg.VaultClientCert != "" && !c.clientCert.Loaded():
+		changed, err = c.clientCert.LoadFiles(c.cfg.VaultClientCert, c.cfg.VaultClientKey)
+	default:
+		return
+	}
+
+	if err != nil {
+		if err.Error() != c.clientCertError {
+			c.clientCertError = err.Error()
+			log.Printf("Error loading Vault client certificate: %v", err)
+		}
+
+		return
+	}
+	c.clientCertError = ""
+	if changed {
+		log.Printf("Loaded Vault client certificate from %s", c.clientCertSource())
+	}
+}
+
+// watchClientCertificate reloads the VAULT_CLIENT_CERT files every clientCertWatchInterval until
+// ctx is done, so a certificate cert-manager rotates in a mounted Secret is presented from the
+// next connection on
+func (c *Controller) watchClientCertificate(ctx context.Context) {
+	ticker := time.NewTicker(clientCertWatchInterval)
+	defer ticker.Stop()
+
+	var lastError string
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		changed, err := c.clientCert.LoadFiles(c.cfg.VaultClientCert, c.cfg.VaultClientKey)
+		if err != nil {
+			if err.Error() != lastError {
+				log.Printf("Error reloading Vault client certificate: %v", err)
+			}
+			lastError = err.Error()
+
+			continue
+		}
+		lastError = ""
+		if changed {
+			log.Printf("Reloaded rotated Vault client certificate from %s", c.clientCertSource())
+		}
+	}
+}
+
+// clientCertSource describes where the client certificate is loaded from
+func (c *Controller) clientCertSource() string {
+	if c.cfg.VaultClientCertFrom != "" {
+		return fmt.Sprintf("secret %s", c.cfg.VaultClientCertFrom)
+	}
+
+	return c.cfg.VaultClientCert
+}
@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReconcileVerifiesTLSWithCAFromSecret(t *testing.T) {
+	fakeVault := vaulttest.NewTLSServer()
+	defer fakeVault.Close()
+
+	cfg := testConfig()
+	cfg.VaultTLS = true
+	cfg.VaultCACertFrom = "secret:vault-tls"
+	cfg.VaultTLSServerName = "example.com"
+	clientset := fake.NewSimpleClientset()
+	c := newTestController(t, clientset, cfg, []*vaulttest.Server{fakeVault})
+
+	// Without the CA bundle the listener cannot be verified
+	c.Reconcile(context.Background())
+	if fakeVault.Initialized() || c.vaultTLSLoaded {
+		t.Fatalf("expected Vault not to be reached before the CA bundle is available")
+	}
+
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: fakeVault.Certificate().Raw})
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "vault-tls", Namespace: "vault"},
+		Data:       map[string][]byte{"ca.crt": caCert},
+	}
+	if _, err := clientset.CoreV1().Secrets("vault").Create(context.Background(), secret, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create CA secret: %v", err)
+	}
+
+	c.Reconcile(context.Background())
+	if !fakeVault.Initialized() {
+		t.Fatalf("expected Vault to be initialized over TLS once the CA bundle is available")
+	}
+	if string(c.vaultCA) != string(caCert) {
+		t.Errorf("expected the CA bundle to be kept for publishing and rendering")
+	}
+}
+
+func TestReconcileLoadsClientCertificateFromSecret(t *testing.T) {
+	fakeVault := vaulttest.NewTLSServer()
+	defer fakeVault.Close()
+
+	cfg := testConfig()
+	cfg.VaultTLS = true
+	cfg.VaultClientCertFrom = "vault-client"
+	clientset := fake.NewSimpleClientset()
+	c := newTestController(t, clientset, cfg, []*vaulttest.Server{fakeVault})
+
+	c.Reconcile(context.Background())
+	if c.ClientCertificate().Loaded() || c.clientCertError == "" {
+		t.Fatalf("expected a missing Secret to be reported")
+	}
+
+	// Any key pair will do, the fake does not ask for client certificates
+	pair := fakeVault.TLS.Certificates[0]
+	keyDER, err := x509.MarshalPKCS8PrivateKey(pair.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "vault-client", Namespace: "vault"},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: pair.Certificate[0]}),
+			corev1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+		},
+	}
+	if _, err := clientset.CoreV1().Secrets("vault").Create(context.Background(), secret, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create client certificate secret: %v", err)
+	}
+
+	c.Reconcile(context.Background())
+	if !c.ClientCertificate().Loaded() || c.clientCertError != "" {
+		t.Errorf("expected the client certificate to be loaded, got error %q", c.clientCertError)
+	}
+}
+
+func TestCheckReadyUsesVaultTLSAndNamespace(t *testing.T) {
+	fakeVault := vaulttest.NewTLSServer()
+	defer fakeVault.Close()
+
+	cfg := testConfig()
+	cfg.VaultNamespace = "staging"
+	cfg.VaultTLS = true
+	cfg.VaultCACertFrom = "secret:vault-tls"
+	cfg.VaultTLSServerName = "example.com"
+	clientset := fake.NewSimpleClientset()
+	c := newTestController(t, clientset, cfg, []*vaulttest.Server{fakeVault})
+
+	// The listener cannot be verified before the CA bundle is loaded
+	if err := c.CheckReady(context.Background()); err == nil {
+		t.Fatalf("expected not to be ready before the CA bundle is available")
+	}
+
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: fakeVault.Certificate().Raw})
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "vault-tls", Namespace: "staging"},
+		Data:       map[string][]byte{"ca.crt": caCert},
+	}
+	if _, err := clientset.CoreV1().Secrets("staging").Create(context.Background(), secret, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create CA secret: %v", err)
+	}
+
+	c.Reconcile(context.Background())
+	if !fakeVault.Initialized() || fakeVault.Sealed() {
+		t.Fatalf("expected Vault to be initialized and unsealed over TLS")
+	}
+	if err := c.CheckReady(context.Background()); err != nil {
+		t.Errorf("expected the pods in the Vault namespace to be ready over TLS, got %v", err)
+	}
+
+	fakeVault.Seal()
+	if err := c.CheckReady(context.Background()); err == nil {
+		t.Errorf("expected a sealed pod not to be ready")
+	}
+}
@@ -0,0 +1,152 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/getgrowly/vault-utils/pkg/kubernetes"
+	"github.com/getgrowly/vault-utils/pkg/status"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+)
+
+// warmStart reads what the cluster already looks like before the first pass acts on it: the
+// stored key material, the action lock and the state of every pod, and logs what the pass is
+// going to do about it. A controller restarted in the middle of an incident thereby shows that
+// it picks up where it, or another actor, left off instead of starting over.
+func (c *Controller) warmStart(ctx context.Context) {
+	for _, step := range c.warmStartPlan(ctx) {
+		log.Printf("Warm start: %s", step)
+	}
+}
+
+// warmStartPlan returns the existing state and what the first pass will do with it, one line per
+// finding. It takes no action: pods are only asked for their seal status, which is recorded for
+// /status.
+func (c *Controller) warmStartPlan(ctx context.Context) []string {
+	var plan []string
+
+	holder, err := c.k8sClient.ActionLockHolder(c.cfg.VaultNamespace)
+	switch {
+	case err != nil:
+		plan = append(plan, fmt.Sprintf("could not read the action lock: %v", err))
+	case holder != nil:
+		plan = append(plan, fmt.Sprintf("%s holds the action lock for %s until %s, actions needing it wait for it", holder.Holder, holder.Action, holder.Until.Format("15:04:05")))
+	}
+
+	plan = append(plan, c.describeStoredKeys()...)
+
+	pods, err := c.vaultPods()
+	if err != nil {
+		return append(plan, fmt.Sprintf("could not list the Vault pods: %v", err))
+	}
+	if len(pods) == 0 {
+		return append(plan, "no Vault pods found, nothing to do")
+	}
+
+	statuses := make(map[string]*vault.Status, len(pods))
+	for _, pod := range pods {
+		vaultStatus, err := c.checkStatus(ctx, pod)
+		if err != nil {
+			c.status.Update(pod, func(p *status.Pod) {
+				*p = status.Pod{Pod: pod, Error: err.Error()}
+			})
+			plan = append(plan, fmt.Sprintf("pod %s is unreachable (%v), its status is checked again", pod, err))
+
+			continue
+		}
+		statuses[pod] = vaultStatus
+		c.status.Update(pod, func(p *status.Pod) {
+			*p = status.ReachablePod(pod, vaultStatus)
+		})
+	}
+
+	phase := c.transition(observePhase(statuses, len(pods)-len(statuses), false))
+	plan = append(plan, fmt.Sprintf("the cluster is %s", phase))
+
+	existing := c.existingClusterReason(pods, statuses)
+	for _, pod := range pods {
+		vaultStatus, ok := statuses[pod]
+		if !ok {
+			continue
+		}
+		plan = append(plan, fmt.Sprintf("pod %s %s", pod, c.plannedAction(pod, vaultStatus, holder, existing, len(pods)-len(statuses))))
+	}
+
+	return plan
+}
+
+// describeStoredKeys reports the root token and key material already stored for the cluster
+func (c *Controller) describeStoredKeys() []string {
+	var found []string
+
+	if exists, err := c.k8sClient.SecretExists(c.cfg.VaultNamespace, vault.RootTokenSecret); err != nil {
+		found = append(found, fmt.Sprintf("could not read the root token: %v", err))
+	} else if exists {
+		found = append(found, fmt.Sprintf("root token stored in Secret %s", vault.RootTokenSecret))
+	}
+
+	if c.keyProvider != nil {
+		found = append(found, fmt.Sprintf("unseal keys are kept with %s", c.keyProvider))
+	}
+	for _, name := range []string{vault.UnsealKeysSecret, vault.RecoveryKeysSecret} {
+		exists, err := c.k8sClient.SecretExists(c.cfg.VaultNamespace, name)
+		if err != nil {
+			found = append(found, fmt.Sprintf("could not read Secret %s: %v", name, err))
+
+			continue
+		}
+		if !exists {
+			continue
+		}
+
+		secret, err := c.k8sClient.GetSecret(c.cfg.VaultNamespace, name)
+		if err != nil {
+			found = append(found, fmt.Sprintf("could not read Secret %s: %v", name, err))
+
+			continue
+		}
+		keys, _ := kubernetes.UnsealKeysFromSecret(secret.Data)
+		described := fmt.Sprintf("%d keys stored in Secret %s", len(keys), name)
+		if threshold := secret.Annotations[vault.ThresholdAnnotation]; threshold != "" {
+			described += fmt.Sprintf(" with a threshold of %s", threshold)
+		}
+		if secret.Annotations[vault.PGPEncryptedAnnotation] == "true" {
+			described += ", encrypted to operators' PGP keys"
+		}
+		if initializedBy := secret.Annotations[vault.InitializedByAnnotation]; initializedBy != "" {
+			described += fmt.Sprintf(", initialized by %s at %s", initializedBy, secret.Annotations[vault.InitializedAtAnnotation])
+		}
+		found = append(found, described)
+	}
+
+	return found
+}
+
+// plannedAction describes the state of pod and what the first pass will do about it. existing is
+// why the cluster is known to exist already, if it is, and unchecked the number of pods whose
+// status could not be checked.
+func (c *Controller) plannedAction(pod string, vaultStatus *vault.Status, holder *kubernetes.LockHeldError, existing string, unchecked int) string {
+	switch {
+	case !vaultStatus.Initialized && holder != nil && holder.Action == kubernetes.ActionInit:
+		return fmt.Sprintf("is uninitialized while %s is initializing the cluster, init waits for it", holder.Holder)
+	case !vaultStatus.Initialized && existing != "":
+		return fmt.Sprintf("is uninitialized but the cluster exists (%s), it is unsealed with the stored keys as a joining member", existing)
+	case !vaultStatus.Initialized && unchecked > 0:
+		return fmt.Sprintf("is uninitialized, init is deferred until the %d other pods can be checked", unchecked)
+	case !vaultStatus.Initialized && !c.cfg.InitAllowed:
+		return "is uninitialized and left alone, INIT_ALLOWED is off"
+	case !vaultStatus.Initialized:
+		return "is uninitialized and will be initialized"
+	case !vaultStatus.Sealed:
+		return "is unsealed, nothing to do"
+	case vaultStatus.AutoUnseal():
+		return fmt.Sprintf("is sealed and waits for its %s seal to unseal it", vaultStatus.Type)
+	case vaultStatus.Progress > 0 && vaultStatus.Nonce != "" && vaultStatus.Nonce == c.unsealNonces[pod]:
+		return fmt.Sprintf("is sealed with the unseal attempt this controller left in progress, %d of %d keys applied, which is continued", vaultStatus.Progress, vaultStatus.Threshold)
+	case vaultStatus.Progress > 0:
+		return fmt.Sprintf("is sealed with an unseal attempt of another actor in progress, %d of %d keys applied", vaultStatus.Progress, vaultStatus.Threshold)
+	default:
+		return "is sealed and will be unsealed with the stored keys"
+	}
+}
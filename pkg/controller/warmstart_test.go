@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/getgrowly/vault-utils/pkg/kubernetes"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWarmStartPlan(t *testing.T) {
+	fakes := vaulttest.NewCluster(3, 5, 3)
+	for _, fakeVault := range fakes {
+		defer fakeVault.Close()
+	}
+
+	clientset := fake.NewSimpleClientset()
+	storeUnsealKeys(t, clientset, fakes[0].Keys())
+	c := newTestController(t, clientset, testConfig(), fakes)
+
+	// vault-0 is unsealed, vault-1 has an unseal attempt this controller started before its
+	// restart, and vault-2 one started by someone else
+	ctx := context.Background()
+	if err := vault.NewClient(fakes[0].URL).UnsealWithKeysFromDir(ctx, fakes[0].Keys()[:3]); err != nil {
+		t.Fatal(err)
+	}
+	for _, fakeVault := range fakes[1:] {
+		if _, err := vault.NewClient(fakeVault.URL).UnsealWithKey(ctx, fakeVault.Keys()[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ownAttempt, err := vault.NewClient(fakes[1].URL).CheckStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.unsealNonces["10.0.0.2"] = ownAttempt.Nonce
+
+	lock, err := kubernetes.NewClientWithInterface(clientset).AcquireActionLock("vault", kubernetes.ActionRekey, "vault-utils-cli/alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Release()
+
+	plan := strings.Join(c.warmStartPlan(ctx), "\n")
+	for _, expected := range []string{
+		"vault-utils-cli/alice holds the action lock for rekey",
+		"5 keys stored in Secret " + vault.UnsealKeysSecret,
+		"pod 10.0.0.1 is unsealed, nothing to do",
+		"pod 10.0.0.2 is sealed with the unseal attempt this controller left in progress, 1 of 3 keys applied",
+		"pod 10.0.0.3 is sealed with an unseal attempt of another actor in progress",
+	} {
+		if !strings.Contains(plan, expected) {
+			t.Errorf("expected the plan to contain %q, got:\n%s", expected, plan)
+		}
+	}
+
+	// Nothing was acted on, but the pod states are known before the first pass
+	for _, fakeVault := range fakes[1:] {
+		if !fakeVault.Sealed() || fakeVault.Progress() != 1 {
+			t.Errorf("expected the warm start to leave the unseal attempts alone")
+		}
+	}
+	if pods := c.Status().Snapshot().Pods; len(pods) != 3 || !pods[1].Sealed || pods[1].UnsealProgress != 1 {
+		t.Errorf("expected the pod states to be recorded, got %+v", pods)
+	}
+}
+
+func TestWarmStartPlanUninitialized(t *testing.T) {
+	fakeVault := vaulttest.NewServer()
+	defer fakeVault.Close()
+
+	clientset := fake.NewSimpleClientset()
+	cfg := testConfig()
+	cfg.InitAllowed = false
+	c := newTestController(t, clientset, cfg, []*vaulttest.Server{fakeVault})
+
+	plan := strings.Join(c.warmStartPlan(context.Background()), "\n")
+	if !strings.Contains(plan, "is uninitialized and left alone, INIT_ALLOWED is off") {
+		t.Errorf("unexpected plan:\n%s", plan)
+	}
+	if fakeVault.Initialized() {
+		t.Errorf("expected the warm start not to initialize Vault")
+	}
+}
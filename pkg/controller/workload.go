@@ -0,0 +1,41 @@
+package controller
+
+import (
+	"log"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/workload"
+)
+
+// workloadIdentityWarnings is the source of the /status warnings about the identity token
+const workloadIdentityWarnings = "workload-identity"
+
+// SetIdentityToken makes the controller watch the workload identity token at path, which key
+// providers use to authenticate
+func (c *Controller) SetIdentityToken(path string) {
+	c.identityTokenFile = path
+}
+
+// checkWorkloadIdentity warns when the workload identity token cannot be read or has expired,
+// since key providers relying on it will fail to fetch keys until it is refreshed
+func (c *Controller) checkWorkloadIdentity() {
+	if c.identityTokenFile == "" {
+		return
+	}
+
+	warning := workload.Check(c.identityTokenFile, time.Now())
+	if warning == c.identityWarning {
+		return
+	}
+	c.identityWarning = warning
+
+	if warning == "" {
+		c.status.SetWarnings(workloadIdentityWarnings, nil)
+		log.Printf("Workload identity token %s is usable again", c.identityTokenFile)
+
+		return
+	}
+
+	c.status.SetWarnings(workloadIdentityWarnings, []string{warning})
+	log.Printf("Warning: %s", warning)
+}
@@ -0,0 +1,39 @@
+package controller
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCheckWorkloadIdentity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	writeToken := func(expiry time.Time) {
+		claims := fmt.Sprintf(`{"sub":"system:serviceaccount:vault:vault-utils","aud":"kms","exp":%d}`, expiry.Unix())
+		token := "e30." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".c2ln"
+		if err := os.WriteFile(path, []byte(token), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := newTestController(t, fake.NewSimpleClientset(), testConfig(), nil)
+	c.SetIdentityToken(path)
+
+	writeToken(time.Now().Add(-time.Minute))
+	c.checkWorkloadIdentity()
+	if warnings := c.Status().Snapshot().Warnings; len(warnings) != 1 || !strings.Contains(warnings[0], "expired") {
+		t.Errorf("expected an expired token warning, got %v", warnings)
+	}
+
+	writeToken(time.Now().Add(time.Hour))
+	c.checkWorkloadIdentity()
+	if warnings := c.Status().Snapshot().Warnings; len(warnings) != 0 {
+		t.Errorf("expected the warning to clear once the token is refreshed, got %v", warnings)
+	}
+}
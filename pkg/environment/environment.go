@@ -0,0 +1,25 @@
+// Package environment inspects the conditions the controller runs under that decide whether the
+// key material it holds in memory can end up on disk
+package environment
+
+// Checks run by Check
+const (
+	CoreDumps = "core_dumps"
+	Swap      = "swap"
+	Seccomp   = "seccomp"
+)
+
+// Checks lists every check, whether or not it found anything
+var Checks = []string{CoreDumps, Swap, Seccomp}
+
+// Finding is a condition a check found
+type Finding struct {
+	Check   string
+	Message string
+}
+
+// Check inspects the process and the node it runs on. Checks the platform does not support, or
+// that cannot read what they need, find nothing.
+func Check() []Finding {
+	return check(defaultSystem())
+}
@@ -0,0 +1,137 @@
+package environment
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// system is where the checks read the state of the process and node from, so tests can fake it
+type system struct {
+	// proc and cgroup are the mount points of procfs and of the cgroup v2 hierarchy
+	proc   string
+	cgroup string
+	// coreLimit returns the soft limit on the size of core dumps, and dumpable whether the
+	// process may dump core at all
+	coreLimit func() (uint64, error)
+	dumpable  func() bool
+}
+
+func defaultSystem() system {
+	return system{
+		proc:   "/proc",
+		cgroup: "/sys/fs/cgroup",
+		coreLimit: func() (uint64, error) {
+			var limit syscall.Rlimit
+			err := syscall.Getrlimit(syscall.RLIMIT_CORE, &limit)
+
+			return limit.Cur, err
+		},
+		dumpable: func() bool {
+			// PR_GET_DUMPABLE
+			dumpable, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, 3, 0, 0)
+
+			return errno != 0 || dumpable != 0
+		},
+	}
+}
+
+func check(sys system) []Finding {
+	var findings []Finding
+	for _, finding := range []*Finding{checkCoreDumps(sys), checkSwap(sys), checkSeccomp(sys)} {
+		if finding != nil {
+			findings = append(findings, *finding)
+		}
+	}
+
+	return findings
+}
+
+// checkCoreDumps finds core dumps enabled, which would write the memory of a crashing controller,
+// keys included, to the node or to whatever core_pattern pipes it to
+func checkCoreDumps(sys system) *Finding {
+	limit, err := sys.coreLimit()
+	if err != nil || limit == 0 || !sys.dumpable() {
+		return nil
+	}
+
+	size := "unlimited"
+	if limit != ^uint64(0) {
+		size = fmt.Sprintf("%d bytes", limit)
+	}
+	destination := "to the working directory"
+	if pattern, err := os.ReadFile(filepath.Join(sys.proc, "sys/kernel/core_pattern")); err == nil {
+		pattern := strings.TrimSpace(string(pattern))
+		if strings.HasPrefix(pattern, "|") {
+			destination = "to " + strings.Fields(strings.TrimPrefix(pattern, "|"))[0]
+		} else if pattern != "" {
+			destination = "as " + pattern
+		}
+	}
+
+	return &Finding{
+		Check: CoreDumps,
+		Message: fmt.Sprintf("core dumps are enabled (limit %s), a crash would write the key material in memory %s; set the core file size limit to 0",
+			size, destination),
+	}
+}
+
+// checkSwap finds swap the controller's memory can be paged out to. A cgroup whose swap limit is
+// 0, as Kubernetes sets by default, cannot swap even when the node has swap.
+func checkSwap(sys system) *Finding {
+	total, err := swapTotal(filepath.Join(sys.proc, "meminfo"))
+	if err != nil || total == 0 {
+		return nil
+	}
+	if limit, err := os.ReadFile(filepath.Join(sys.cgroup, "memory.swap.max")); err == nil && strings.TrimSpace(string(limit)) == "0" {
+		return nil
+	}
+
+	return &Finding{
+		Check:   Swap,
+		Message: fmt.Sprintf("the node has %d kB of swap the controller may use, key material in memory could be paged to disk; disable swap or limit the pod's swap to 0", total),
+	}
+}
+
+// swapTotal returns SwapTotal of meminfo in kB
+func swapTotal(meminfo string) (uint64, error) {
+	data, err := os.ReadFile(meminfo)
+	if err != nil {
+		return 0, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "SwapTotal:" {
+			return strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+
+	return 0, fmt.Errorf("no SwapTotal in %s", meminfo)
+}
+
+// checkSeccomp finds the process running without a seccomp filter, which leaves every system
+// call, such as those reading another process's memory, to the other defenses
+func checkSeccomp(sys system) *Finding {
+	data, err := os.ReadFile(filepath.Join(sys.proc, "self/status"))
+	if err != nil {
+		return nil
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		mode, found := strings.CutPrefix(scanner.Text(), "Seccomp:")
+		if found && strings.TrimSpace(mode) == "0" {
+			return &Finding{
+				Check:   Seccomp,
+				Message: "no seccomp profile applies to the controller; set the pod's securityContext.seccompProfile.type to RuntimeDefault",
+			}
+		}
+	}
+
+	return nil
+}
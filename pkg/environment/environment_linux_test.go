@@ -0,0 +1,71 @@
+package environment
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSystem returns a system reading the files given, relative to a temporary root
+func fakeSystem(t *testing.T, coreLimit uint64, files map[string]string) system {
+	t.Helper()
+
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed to create %s: %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+
+	return system{
+		proc:      filepath.Join(root, "proc"),
+		cgroup:    filepath.Join(root, "cgroup"),
+		coreLimit: func() (uint64, error) { return coreLimit, nil },
+		dumpable:  func() bool { return true },
+	}
+}
+
+func TestCheck(t *testing.T) {
+	hardened := map[string]string{
+		"proc/meminfo":                 "MemTotal:       16318508 kB\nSwapTotal:             0 kB\n",
+		"proc/self/status":             "Name:\tvault-utils\nSeccomp:\t2\nSeccomp_filters:\t1\n",
+		"proc/sys/kernel/core_pattern": "core\n",
+	}
+	if findings := check(fakeSystem(t, 0, hardened)); len(findings) != 0 {
+		t.Errorf("expected nothing found, got %+v", findings)
+	}
+
+	exposed := map[string]string{
+		"proc/meminfo":                 "MemTotal:       16318508 kB\nSwapTotal:       2097148 kB\n",
+		"proc/self/status":             "Name:\tvault-utils\nSeccomp:\t0\n",
+		"proc/sys/kernel/core_pattern": "|/usr/lib/systemd/systemd-coredump %P %u %g\n",
+		"cgroup/memory.swap.max":       "max\n",
+	}
+	findings := check(fakeSystem(t, ^uint64(0), exposed))
+	var checks []string
+	for _, finding := range findings {
+		checks = append(checks, finding.Check)
+	}
+	if strings.Join(checks, ",") != "core_dumps,swap,seccomp" {
+		t.Fatalf("expected every check to find something, got %+v", findings)
+	}
+	if !strings.Contains(findings[0].Message, "limit unlimited") || !strings.Contains(findings[0].Message, "to /usr/lib/systemd/systemd-coredump") {
+		t.Errorf("expected the core limit and where dumps go, got %s", findings[0].Message)
+	}
+	if !strings.Contains(findings[1].Message, "2097148 kB") {
+		t.Errorf("expected the size of the swap, got %s", findings[1].Message)
+	}
+
+	// A pod whose swap is limited to 0 cannot use the node's swap
+	exposed["cgroup/memory.swap.max"] = "0\n"
+	for _, finding := range check(fakeSystem(t, 1024, exposed)) {
+		if finding.Check == Swap {
+			t.Errorf("expected swap limited to 0 to be ignored, got %s", finding.Message)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package environment
+
+// system is empty where no check is supported
+type system struct{}
+
+func defaultSystem() system {
+	return system{}
+}
+
+func check(system) []Finding {
+	return nil
+}
@@ -0,0 +1,201 @@
+// Package hooks runs site-specific actions before and after the controller initializes or
+// unseals Vault, such as notifying a CMDB or toggling a feature flag, without forking the
+// controller. A hook is configured as a spec string:
+//
+//	exec:/path/to/command arg...   run a command, without a shell
+//	https://example.com/path       POST the hook context as JSON (http:// works too)
+//	annotate:<kind>/<name>         annotate a ConfigMap, Secret or StatefulSet in the Vault namespace
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const defaultTimeout = 30 * time.Second
+
+// Phase is the point of an action at which hooks run
+type Phase string
+
+// Phases
+const (
+	PreInit    Phase = "pre-init"
+	PostInit   Phase = "post-init"
+	PreUnseal  Phase = "pre-unseal"
+	PostUnseal Phase = "post-unseal"
+)
+
+// Context describes the action a hook runs for
+type Context struct {
+	Phase     Phase     `json:"phase"`
+	Namespace string    `json:"namespace"`
+	Pod       string    `json:"pod"`
+	Time      time.Time `json:"time"`
+}
+
+// Hook is a single configured action
+type Hook interface {
+	Run(ctx context.Context, hc Context) error
+	String() string
+}
+
+// Annotator annotates Kubernetes objects
+type Annotator interface {
+	AnnotateObject(namespace, kind, name string, annotations map[string]string) error
+}
+
+// Hooks holds the hooks configured for each phase
+type Hooks map[Phase][]Hook
+
+// Parse builds the hooks of every phase from a map of phase to specs, where several specs for
+// one phase are separated by semicolons and run in order
+func Parse(specs map[Phase]string, annotator Annotator) (Hooks, error) {
+	h := make(Hooks)
+	for phase, value := range specs {
+		for _, spec := range strings.Split(value, ";") {
+			spec = strings.TrimSpace(spec)
+			if spec == "" {
+				continue
+			}
+
+			hook, err := parseSpec(spec, annotator)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s hook %q: %w", phase, spec, err)
+			}
+			h[phase] = append(h[phase], hook)
+		}
+	}
+
+	return h, nil
+}
+
+func parseSpec(spec string, annotator Annotator) (Hook, error) {
+	switch {
+	case strings.HasPrefix(spec, "exec:"):
+		args := strings.Fields(strings.TrimPrefix(spec, "exec:"))
+		if len(args) == 0 {
+			return nil, fmt.Errorf("missing command")
+		}
+
+		return &Exec{Args: args}, nil
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		return &Webhook{URL: spec, httpClient: &http.Client{}}, nil
+	case strings.HasPrefix(spec, "annotate:"):
+		kind, name, ok := strings.Cut(strings.TrimPrefix(spec, "annotate:"), "/")
+		if !ok || kind == "" || name == "" {
+			return nil, fmt.Errorf("expected annotate:<kind>/<name>")
+		}
+
+		return &Annotate{Kind: kind, Name: name, annotator: annotator}, nil
+	}
+
+	return nil, fmt.Errorf("unknown hook type, expected exec:, http(s):// or annotate:")
+}
+
+// Run runs the hooks of hc.Phase in order and stops at the first failure. Each hook gets its
+// own timeout.
+func (h Hooks) Run(hc Context) error {
+	if hc.Time.IsZero() {
+		hc.Time = time.Now().UTC()
+	}
+
+	for _, hook := range h[hc.Phase] {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+		err := hook.Run(ctx, hc)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("%s hook %s failed: %w", hc.Phase, hook, err)
+		}
+	}
+
+	return nil
+}
+
+// Exec runs a command with the hook context in its environment as VAULT_UTILS_HOOK_PHASE,
+// VAULT_UTILS_NAMESPACE and VAULT_UTILS_POD
+type Exec struct {
+	Args []string
+}
+
+// Run implements Hook
+func (e *Exec) Run(ctx context.Context, hc Context) error {
+	cmd := exec.CommandContext(ctx, e.Args[0], e.Args[1:]...)
+	cmd.Env = append(os.Environ(),
+		"VAULT_UTILS_HOOK_PHASE="+string(hc.Phase),
+		"VAULT_UTILS_NAMESPACE="+hc.Namespace,
+		"VAULT_UTILS_POD="+hc.Pod,
+	)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+func (e *Exec) String() string {
+	return "exec:" + strings.Join(e.Args, " ")
+}
+
+// Webhook posts the hook context as JSON and expects a 2xx response
+type Webhook struct {
+	URL        string
+	httpClient *http.Client
+}
+
+// Run implements Hook
+func (w *Webhook) Run(ctx context.Context, hc Context) error {
+	body, err := json.Marshal(hc)
+	if err != nil {
+		return fmt.Errorf("failed to marshal hook context: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call hook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("hook returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (w *Webhook) String() string {
+	return w.URL
+}
+
+// Annotate records the time of the phase on an object in the Vault namespace, as the
+// vault-utils.growly.io/last-<phase> annotation, so other controllers can react to it
+type Annotate struct {
+	Kind      string
+	Name      string
+	annotator Annotator
+}
+
+// Run implements Hook
+func (a *Annotate) Run(_ context.Context, hc Context) error {
+	return a.annotator.AnnotateObject(hc.Namespace, a.Kind, a.Name, map[string]string{
+		"vault-utils.growly.io/last-" + string(hc.Phase): hc.Time.Format(time.RFC3339),
+	})
+}
+
+func (a *Annotate) String() string {
+	return "annotate:" + a.Kind + "/" + a.Name
+}
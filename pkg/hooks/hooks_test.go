@@ -0,0 +1,192 @@
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+type recordingAnnotator struct {
+	namespace, kind, name string
+	annotations           map[string]string
+}
+
+func (a *recordingAnnotator) AnnotateObject(namespace, kind, name string, annotations map[string]string) error {
+	a.namespace, a.kind, a.name, a.annotations = namespace, kind, name, annotations
+	return nil
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    []string
+		wantErr bool
+	}{
+		{name: "empty", spec: "", want: nil},
+		{name: "exec", spec: "exec:/usr/local/bin/cmdb-notify --env prod", want: []string{"exec:/usr/local/bin/cmdb-notify --env prod"}},
+		{name: "webhook", spec: "https://flags.example.com/vault", want: []string{"https://flags.example.com/vault"}},
+		{name: "annotate", spec: "annotate:configmap/cmdb", want: []string{"annotate:configmap/cmdb"}},
+		{
+			name: "several in order",
+			spec: "annotate:statefulset/vault; http://cmdb.local/hook ;",
+			want: []string{"annotate:statefulset/vault", "http://cmdb.local/hook"},
+		},
+		{name: "missing command", spec: "exec:", wantErr: true},
+		{name: "missing name", spec: "annotate:configmap", wantErr: true},
+		{name: "unknown type", spec: "ftp://example.com", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := Parse(map[Phase]string{PostUnseal: tt.spec}, &recordingAnnotator{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %t, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			var got []string
+			for _, hook := range h[PostUnseal] {
+				got = append(got, hook.String())
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("expected hooks %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+type fakeHook struct {
+	name string
+	err  error
+	ran  *[]string
+}
+
+func (f *fakeHook) Run(_ context.Context, hc Context) error {
+	*f.ran = append(*f.ran, f.name)
+	return f.err
+}
+
+func (f *fakeHook) String() string {
+	return f.name
+}
+
+func TestRunStopsAtFirstFailure(t *testing.T) {
+	var ran []string
+	h := Hooks{
+		PreUnseal: {
+			&fakeHook{name: "gate", ran: &ran},
+			&fakeHook{name: "flag", err: errors.New("flag service down"), ran: &ran},
+			&fakeHook{name: "never", ran: &ran},
+		},
+	}
+
+	err := h.Run(Context{Phase: PreUnseal, Namespace: "vault", Pod: "10.0.0.1"})
+	if err == nil || !strings.Contains(err.Error(), "pre-unseal hook flag failed: flag service down") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if strings.Join(ran, ",") != "gate,flag" {
+		t.Errorf("expected the hooks after the failure not to run, got %v", ran)
+	}
+
+	if err := h.Run(Context{Phase: PostUnseal}); err != nil {
+		t.Errorf("expected no error for a phase without hooks, got %v", err)
+	}
+	if err := Hooks(nil).Run(Context{Phase: PreInit}); err != nil {
+		t.Errorf("expected no error without hooks, got %v", err)
+	}
+}
+
+func TestExec(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script")
+	}
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	script := filepath.Join(dir, "hook.sh")
+	content := "#!/bin/sh\necho \"$VAULT_UTILS_HOOK_PHASE $VAULT_UTILS_NAMESPACE $VAULT_UTILS_POD $1\" > " + out + "\n"
+	if err := os.WriteFile(script, []byte(content), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := Parse(map[Phase]string{PostInit: "exec:" + script + " extra"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Run(Context{Phase: PostInit, Namespace: "vault", Pod: "10.0.0.1"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(got)) != "post-init vault 10.0.0.1 extra" {
+		t.Errorf("unexpected hook environment %q", got)
+	}
+
+	failing, _ := Parse(map[Phase]string{PostInit: "exec:/bin/sh -c false"}, nil)
+	if err := failing.Run(Context{Phase: PostInit}); err == nil {
+		t.Errorf("expected an error for a failing command")
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var received Context
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("failed to decode hook context: %v", err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	h, err := Parse(map[Phase]string{PreInit: server.URL}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.Run(Context{Phase: PreInit, Namespace: "vault", Pod: "10.0.0.1"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if received.Phase != PreInit || received.Pod != "10.0.0.1" || received.Time.IsZero() {
+		t.Errorf("unexpected hook context %+v", received)
+	}
+
+	status = http.StatusConflict
+	if err := h.Run(Context{Phase: PreInit}); err == nil {
+		t.Errorf("expected an error for a non-2xx response")
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	annotator := &recordingAnnotator{}
+	h, err := Parse(map[Phase]string{PostUnseal: "annotate:configmap/cmdb"}, annotator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := h.Run(Context{Phase: PostUnseal, Namespace: "vault", Time: at}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if annotator.namespace != "vault" || annotator.kind != "configmap" || annotator.name != "cmdb" {
+		t.Errorf("unexpected object %s %s/%s", annotator.namespace, annotator.kind, annotator.name)
+	}
+	if annotator.annotations["vault-utils.growly.io/last-post-unseal"] != "2024-03-01T12:00:00Z" {
+		t.Errorf("unexpected annotations %v", annotator.annotations)
+	}
+}
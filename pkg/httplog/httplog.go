@@ -0,0 +1,79 @@
+// Package httplog logs the metadata of outgoing HTTP requests when debug logging is enabled, so
+// slow or failing calls to Vault and the Kubernetes API can be traced without a packet capture.
+// Only the method, path, status and latency are logged; bodies, headers and query strings are
+// never written because they can carry unseal keys and tokens.
+package httplog
+
+import (
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+var enabled atomic.Bool
+
+// SetEnabled turns request logging on or off for every wrapped transport
+func SetEnabled(on bool) {
+	enabled.Store(on)
+}
+
+// Enabled reports whether request logging is on
+func Enabled() bool {
+	return enabled.Load()
+}
+
+// Transport is an http.RoundTripper that logs each request it forwards to Base
+type Transport struct {
+	// Component names the integration in the log line, e.g. "vault" or "kubernetes"
+	Component string
+	// Base performs the request; http.DefaultTransport is used when it is nil
+	Base http.RoundTripper
+}
+
+// Wrap returns rt wrapped in a Transport logging under the given component
+func Wrap(component string, rt http.RoundTripper) http.RoundTripper {
+	return &Transport{Component: component, Base: rt}
+}
+
+// Wrapper returns a function wrapping a transport for the given component, in the shape
+// expected by rest.Config.Wrap
+func Wrapper(component string) func(http.RoundTripper) http.RoundTripper {
+	return func(rt http.RoundTripper) http.RoundTripper {
+		return Wrap(component, rt)
+	}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	if !Enabled() {
+		return base.RoundTrip(req)
+	}
+
+	start := time.Now()
+	resp, err := base.RoundTrip(req)
+	latency := time.Since(start).Round(time.Microsecond)
+
+	if err != nil {
+		log.Printf("Debug: %s %s %s%s failed after %v: %v", t.Component, req.Method, req.URL.Host, req.URL.Path, latency, err)
+		return resp, err
+	}
+
+	log.Printf("Debug: %s %s %s%s -> %d in %v", t.Component, req.Method, req.URL.Host, req.URL.Path, resp.StatusCode, latency)
+
+	return resp, nil
+}
+
+// CloseIdleConnections forwards to the base transport, so http.Client.CloseIdleConnections
+// keeps working through the wrapper
+func (t *Transport) CloseIdleConnections() {
+	type closer interface{ CloseIdleConnections() }
+	if c, ok := t.Base.(closer); ok {
+		c.CloseIdleConnections()
+	}
+}
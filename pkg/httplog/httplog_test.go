@@ -0,0 +1,96 @@
+package httplog
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	return &buf
+}
+
+func TestTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer server.Close()
+
+	refused := roundTripFunc(func(*http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	})
+
+	tests := []struct {
+		name     string
+		enabled  bool
+		base     http.RoundTripper
+		wantLog  []string
+		wantNone []string
+	}{
+		{
+			name:     "disabled",
+			enabled:  false,
+			base:     http.DefaultTransport,
+			wantNone: []string{"Debug:"},
+		},
+		{
+			name:     "logs status and latency",
+			enabled:  true,
+			base:     http.DefaultTransport,
+			wantLog:  []string{"Debug: vault GET", "/v1/sys/seal-status -> 418 in"},
+			wantNone: []string{"token=", "s.secret"},
+		},
+		{
+			name:     "logs failures",
+			enabled:  true,
+			base:     refused,
+			wantLog:  []string{"Debug: vault GET", "/v1/sys/seal-status failed after", "connection refused"},
+			wantNone: []string{"token="},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetEnabled(tt.enabled)
+			defer SetEnabled(false)
+			buf := captureLog(t)
+
+			req, err := http.NewRequest(http.MethodGet, server.URL+"/v1/sys/seal-status?token=s.secret", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("X-Vault-Token", "s.secret")
+
+			resp, err := Wrap("vault", tt.base).RoundTrip(req)
+			if err == nil {
+				resp.Body.Close()
+			}
+
+			for _, want := range tt.wantLog {
+				if !strings.Contains(buf.String(), want) {
+					t.Errorf("expected log to contain %q, got %q", want, buf.String())
+				}
+			}
+			for _, unwanted := range tt.wantNone {
+				if strings.Contains(buf.String(), unwanted) {
+					t.Errorf("expected log not to contain %q, got %q", unwanted, buf.String())
+				}
+			}
+		})
+	}
+}
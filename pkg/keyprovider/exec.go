@@ -0,0 +1,66 @@
+package keyprovider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Exec runs a command for every operation, speaking JSON over its stdin and stdout. Its stderr
+// is included in errors, so it must never print key material there.
+type Exec struct {
+	Args []string
+}
+
+// Keys implements Provider
+func (e *Exec) Keys(ctx context.Context, namespace string) ([]string, error) {
+	resp, err := e.run(ctx, Request{Operation: OperationKeys, Namespace: namespace})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Keys, nil
+}
+
+// Store implements Provider
+func (e *Exec) Store(ctx context.Context, namespace string, keys []string) error {
+	_, err := e.run(ctx, Request{Operation: OperationStore, Namespace: namespace, Keys: keys})
+
+	return err
+}
+
+func (e *Exec) run(ctx context.Context, req Request) (*Response, error) {
+	input, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s request: %w", req.Operation, err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.Args[0], e.Args[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s failed: %w: %s", req.Operation, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var resp Response
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		// The output is not echoed, since it may hold keys
+		return nil, fmt.Errorf("%s returned invalid JSON: %w", req.Operation, err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("%s failed: %w", req.Operation, errors.New(resp.Error))
+	}
+
+	return &resp, nil
+}
+
+func (e *Exec) String() string {
+	return "exec:" + strings.Join(e.Args, " ")
+}
@@ -0,0 +1,89 @@
+// Package keyprovider lets unseal keys live somewhere other than the unseal keys Secret, such as
+// a proprietary KMS or HSM, without patching the controller. A provider is configured as a spec
+// string:
+//
+//	exec:/path/to/command arg...    run a command speaking the JSON protocol below, without a shell
+//	plugin:/path/to/provider.so arg...  load a Go plugin exporting NewProvider
+//
+// An exec provider is run once per operation. It reads a Request as JSON on stdin and writes a
+// Response as JSON on stdout; a non-zero exit status or a non-empty error fails the operation.
+//
+// A Go plugin is built with go build -buildmode=plugin against the same module versions as the
+// controller and exports
+//
+//	func NewProvider(args []string) (keyprovider.Provider, error)
+//
+// Plugins are only supported on Linux, macOS and FreeBSD, in binaries built with cgo.
+package keyprovider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout bounds a single provider operation
+const DefaultTimeout = 30 * time.Second
+
+// Provider stores and returns the unseal keys of a Vault cluster
+type Provider interface {
+	// Keys returns the unseal keys of the cluster in namespace
+	Keys(ctx context.Context, namespace string) ([]string, error)
+	// Store saves the unseal keys generated when the cluster in namespace was initialized,
+	// replacing any keys stored before
+	Store(ctx context.Context, namespace string, keys []string) error
+	String() string
+}
+
+// Operations of the exec protocol
+const (
+	OperationKeys  = "keys"
+	OperationStore = "store"
+)
+
+// Request is what an exec provider reads on stdin
+type Request struct {
+	// Operation is OperationKeys or OperationStore
+	Operation string `json:"operation"`
+	// Namespace is the namespace Vault runs in
+	Namespace string `json:"namespace"`
+	// Keys holds the keys to store, for OperationStore
+	Keys []string `json:"keys,omitempty"`
+}
+
+// Response is what an exec provider writes on stdout
+type Response struct {
+	// Keys holds the stored keys, for OperationKeys
+	Keys []string `json:"keys,omitempty"`
+	// Error fails the operation when it is not empty
+	Error string `json:"error,omitempty"`
+}
+
+// Parse builds the provider of a spec
+func Parse(spec string) (Provider, error) {
+	spec = strings.TrimSpace(spec)
+	switch {
+	case strings.HasPrefix(spec, "exec:"):
+		args := strings.Fields(strings.TrimPrefix(spec, "exec:"))
+		if len(args) == 0 {
+			return nil, fmt.Errorf("invalid key provider %q: missing command", spec)
+		}
+
+		return &Exec{Args: args}, nil
+	case strings.HasPrefix(spec, "plugin:"):
+		args := strings.Fields(strings.TrimPrefix(spec, "plugin:"))
+		if len(args) == 0 {
+			return nil, fmt.Errorf("invalid key provider %q: missing plugin path", spec)
+		}
+
+		provider, err := loadPlugin(args[0], args[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid key provider %q: %w", spec, err)
+		}
+
+		return provider, nil
+	}
+
+	return nil, fmt.Errorf("invalid key provider %q: unknown type, expected exec: or plugin:", spec)
+}
@@ -0,0 +1,103 @@
+package keyprovider
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    string
+		wantErr bool
+	}{
+		{name: "exec", spec: "exec:/usr/local/bin/hsm-keys --slot 1", want: "exec:/usr/local/bin/hsm-keys --slot 1"},
+		{name: "missing command", spec: "exec:", wantErr: true},
+		{name: "missing plugin", spec: "plugin:", wantErr: true},
+		{name: "unloadable plugin", spec: "plugin:/nonexistent/provider.so", wantErr: true},
+		{name: "unknown type", spec: "kms:projects/p/keys/k", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, err := Parse(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error for %q", tt.spec)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if provider.String() != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, provider)
+			}
+		})
+	}
+}
+
+func TestExec(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script")
+	}
+
+	// The script stores the request of a store and answers a keys request with the stored keys
+	dir := t.TempDir()
+	stored := filepath.Join(dir, "stored")
+	script := filepath.Join(dir, "provider.sh")
+	content := `#!/bin/sh
+request=$(cat)
+case "$request" in
+*'"operation":"store"'*) echo "$request" > ` + stored + `; echo '{}' ;;
+*'"namespace":"missing"'*) echo '{"error":"no keys for namespace missing"}' ;;
+*) echo '{"keys":["key-a","key-b"]}' ;;
+esac
+`
+	if err := os.WriteFile(script, []byte(content), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	provider, err := Parse("exec:" + script)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := provider.Store(context.Background(), "vault", []string{"key-a", "key-b"}); err != nil {
+		t.Fatalf("unexpected store error: %v", err)
+	}
+	request, err := os.ReadFile(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(request)) != `{"operation":"store","namespace":"vault","keys":["key-a","key-b"]}` {
+		t.Errorf("unexpected store request %s", request)
+	}
+
+	keys, err := provider.Keys(context.Background(), "vault")
+	if err != nil {
+		t.Fatalf("unexpected keys error: %v", err)
+	}
+	if strings.Join(keys, ",") != "key-a,key-b" {
+		t.Errorf("unexpected keys %v", keys)
+	}
+
+	if _, err := provider.Keys(context.Background(), "missing"); err == nil || !strings.Contains(err.Error(), "no keys for namespace missing") {
+		t.Errorf("expected the provider's error, got %v", err)
+	}
+
+	failing, _ := Parse("exec:/bin/sh -c false")
+	if _, err := failing.Keys(context.Background(), "vault"); err == nil {
+		t.Errorf("expected an error for a failing command")
+	}
+
+	garbled, _ := Parse("exec:/bin/echo not-json")
+	if _, err := garbled.Keys(context.Background(), "vault"); err == nil || strings.Contains(err.Error(), "not-json") {
+		t.Errorf("expected an error that does not echo the output, got %v", err)
+	}
+}
@@ -0,0 +1,34 @@
+package keyprovider
+
+import (
+	"fmt"
+	"plugin"
+)
+
+// pluginSymbol is the constructor a Go plugin exports
+const pluginSymbol = "NewProvider"
+
+// loadPlugin opens a Go plugin and builds its provider
+func loadPlugin(path string, args []string) (Provider, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open plugin: %w", err)
+	}
+
+	symbol, err := p.Lookup(pluginSymbol)
+	if err != nil {
+		return nil, fmt.Errorf("plugin does not export %s: %w", pluginSymbol, err)
+	}
+
+	newProvider, ok := symbol.(func([]string) (Provider, error))
+	if !ok {
+		return nil, fmt.Errorf("plugin exports %s as %T, expected func([]string) (keyprovider.Provider, error)", pluginSymbol, symbol)
+	}
+
+	provider, err := newProvider(args)
+	if err != nil {
+		return nil, fmt.Errorf("plugin failed to create its provider: %w", err)
+	}
+
+	return provider, nil
+}
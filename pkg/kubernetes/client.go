@@ -2,21 +2,42 @@ package kubernetes
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
-	"os"
-	"path/filepath"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 
+	"github.com/getgrowly/vault-utils/pkg/httplog"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
+const (
+	vaultPodSelector = "app.kubernetes.io/name=vault,component=server"
+	// maxKeyShares is the largest number of key shares Vault generates
+	maxKeyShares = 255
+	// eventComponent is the source recorded on the Events the controller creates
+	eventComponent = "vault-utils"
+)
+
 // Client represents a Kubernetes client for managing Kubernetes operations
 type Client struct {
 	clientset kubernetes.Interface
+	config    *rest.Config
+	// podSelectors select the Vault server pods, a pod matching any of them
+	podSelectors []string
 }
 
 // NewClient creates a new Kubernetes client using in-cluster configuration or local kubeconfig
@@ -25,44 +46,86 @@ func NewClient() (*Client, error) {
 	config, err := rest.InClusterConfig()
 	if err != nil {
 		// Fall back to kubeconfig
-		kubeconfig := os.Getenv("KUBECONFIG")
-		if kubeconfig == "" {
-			if home := os.Getenv("HOME"); home != "" {
-				kubeconfig = filepath.Join(home, ".kube", "config")
-			}
-		}
+		return NewClientFromKubeconfig("", "")
+	}
 
-		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get kubeconfig: %v", err)
-		}
+	return newClientForConfig(config)
+}
+
+// NewClientFromKubeconfig creates a new Kubernetes client from a kubeconfig file without
+// assuming it runs inside the cluster. An empty path uses $KUBECONFIG, which may list several
+// files, or the kubeconfig in the user's home directory; an empty context uses the current one.
+func NewClientFromKubeconfig(kubeconfig, kubeContext string) (*Client, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if kubeconfig != "" {
+		loadingRules.ExplicitPath = kubeconfig
+	}
+
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules,
+		&clientcmd.ConfigOverrides{CurrentContext: kubeContext}).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get kubeconfig: %v", err)
 	}
 
+	return newClientForConfig(config)
+}
+
+func newClientForConfig(config *rest.Config) (*Client, error) {
+	config.Wrap(httplog.Wrapper("kubernetes"))
+
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Kubernetes client: %v", err)
 	}
 
-	return &Client{clientset: clientset}, nil
+	return &Client{clientset: clientset, config: config, podSelectors: []string{vaultPodSelector}}, nil
 }
 
 // NewClientWithInterface creates a new Kubernetes client with a provided interface
 func NewClientWithInterface(clientset kubernetes.Interface) *Client {
-	return &Client{clientset: clientset}
+	return &Client{clientset: clientset, podSelectors: []string{vaultPodSelector}}
+}
+
+// SetPodSelectors sets the label selectors of the Vault server pods, which default to the
+// labels of the official Helm chart. A pod matching any of them is a Vault pod, so deployments
+// labelling their servers differently, such as during a migration between charts, are found
+// together.
+func (c *Client) SetPodSelectors(selectors ...string) {
+	c.podSelectors = selectors
+}
+
+// listVaultPods lists the pods of namespace matching any of the pod selectors, each once
+func (c *Client) listVaultPods(namespace string) ([]corev1.Pod, error) {
+	var pods []corev1.Pod
+	seen := make(map[string]bool)
+	for _, selector := range c.podSelectors {
+		list, err := c.clientset.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{
+			LabelSelector: selector,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list Vault pods: %v", err)
+		}
+		for _, pod := range list.Items {
+			if !seen[pod.Name] {
+				seen[pod.Name] = true
+				pods = append(pods, pod)
+			}
+		}
+	}
+
+	return pods, nil
 }
 
 // GetVaultPods returns a list of all Vault pods in the specified namespace
 func (c *Client) GetVaultPods(namespace string) ([]string, error) {
-	pods, err := c.clientset.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{
-		LabelSelector: "app.kubernetes.io/name=vault,component=server",
-	})
+	pods, err := c.listVaultPods(namespace)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list Vault pods: %v", err)
+		return nil, err
 	}
 
 	var podAddresses []string
 
-	for _, pod := range pods.Items {
+	for _, pod := range pods {
 		if pod.Status.PodIP != "" {
 			log.Printf("Found Vault pod %s with IP %s", pod.Name, pod.Status.PodIP)
 			podAddresses = append(podAddresses, pod.Status.PodIP)
@@ -72,6 +135,436 @@ func (c *Client) GetVaultPods(namespace string) ([]string, error) {
 	return podAddresses, nil
 }
 
+// GetVaultPodNames returns the names of all Vault pods in the specified namespace
+func (c *Client) GetVaultPodNames(namespace string) ([]string, error) {
+	pods, err := c.listVaultPods(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		names = append(names, pod.Name)
+	}
+
+	return names, nil
+}
+
+// CountRunningVaultPods returns how many Vault pods in the specified namespace are Running and
+// not terminating
+func (c *Client) CountRunningVaultPods(namespace string) (int, error) {
+	pods, err := c.listVaultPods(namespace)
+	if err != nil {
+		return 0, err
+	}
+
+	running := 0
+	for _, pod := range pods {
+		if pod.Status.Phase == corev1.PodRunning && pod.DeletionTimestamp == nil {
+			running++
+		}
+	}
+
+	return running, nil
+}
+
+// agentContainerPrefix starts the names of the containers the Vault Agent injector adds to a pod
+const agentContainerPrefix = "vault-agent"
+
+// serverPortNames are the names the common charts give the API port of the Vault server container
+var serverPortNames = []string{"http", "https", "api"}
+
+// VaultServerPort returns the API port of the Vault server container of the pod with the given
+// IP when it differs from port, skipping containers added by the Vault Agent injector. It
+// returns "" when the pod declares no other API port.
+func (c *Client) VaultServerPort(namespace, podIP, port string) (string, error) {
+	pods, err := c.listVaultPods(namespace)
+	if err != nil {
+		return "", err
+	}
+
+	for _, pod := range pods {
+		if pod.Status.PodIP != podIP {
+			continue
+		}
+
+		for _, container := range pod.Spec.Containers {
+			if strings.HasPrefix(container.Name, agentContainerPrefix) {
+				continue
+			}
+			for _, containerPort := range container.Ports {
+				number := strconv.Itoa(int(containerPort.ContainerPort))
+				if number == port || containerPort.Name == port {
+					continue
+				}
+				for _, name := range serverPortNames {
+					if containerPort.Name == name {
+						return number, nil
+					}
+				}
+			}
+		}
+
+		return "", nil
+	}
+
+	return "", fmt.Errorf("no Vault pod has IP %s", podIP)
+}
+
+// PodProxy returns a base URL and an authenticated HTTP client that reach the given pod port
+// through the API server proxy, so a pod can be queried from outside the cluster network
+func (c *Client) PodProxy(namespace, pod, scheme, port string) (string, *http.Client, error) {
+	if c.config == nil {
+		return "", nil, fmt.Errorf("pod proxy requires a client created from a REST config")
+	}
+
+	httpClient, err := rest.HTTPClientFor(c.config)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create API server client: %v", err)
+	}
+
+	baseURL := fmt.Sprintf("%s/api/v1/namespaces/%s/pods/%s/proxy", strings.TrimSuffix(c.config.Host, "/"),
+		url.PathEscape(namespace), url.PathEscape(fmt.Sprintf("%s:%s:%s", scheme, pod, port)))
+
+	return baseURL, httpClient, nil
+}
+
+// ServiceProxyGet fetches a path from a Service through the API server proxy, using the port
+// named http
+func (c *Client) ServiceProxyGet(namespace, service, path string) ([]byte, error) {
+	body, err := c.clientset.CoreV1().Services(namespace).ProxyGet("http", service, "", path, nil).DoRaw(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s from service %s/%s: %v", path, namespace, service, err)
+	}
+
+	return body, nil
+}
+
+// GetDrainingVaultPods returns the addresses of Vault pods that are being evicted or deleted,
+// or that run on a node which has been cordoned for maintenance
+func (c *Client) GetDrainingVaultPods(namespace string) ([]string, error) {
+	pods, err := c.listVaultPods(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	var podAddresses []string
+
+	for _, pod := range pods {
+		if pod.Status.PodIP == "" {
+			continue
+		}
+
+		draining := pod.DeletionTimestamp != nil
+		if !draining && pod.Spec.NodeName != "" {
+			node, err := c.clientset.CoreV1().Nodes().Get(context.Background(), pod.Spec.NodeName, metav1.GetOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("failed to get node %s: %v", pod.Spec.NodeName, err)
+			}
+			draining = node.Spec.Unschedulable
+		}
+
+		if draining {
+			log.Printf("Vault pod %s with IP %s is draining", pod.Name, pod.Status.PodIP)
+			podAddresses = append(podAddresses, pod.Status.PodIP)
+		}
+	}
+
+	return podAddresses, nil
+}
+
+// WatchVaultPods returns a channel that receives a signal whenever a Vault pod is added, gets an
+// address or starts terminating. Signals are coalesced, so a slow consumer only sees one pending change.
+func (c *Client) WatchVaultPods(ctx context.Context, namespace string) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+
+	// A watch takes a single selector, so each selector has an informer of its own
+	for _, selector := range c.podSelectors {
+		selector := selector
+		factory := informers.NewSharedInformerFactoryWithOptions(c.clientset, 0,
+			informers.WithNamespace(namespace),
+			informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+				opts.LabelSelector = selector
+			}),
+		)
+
+		informer := factory.Core().V1().Pods().Informer()
+		if _, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(interface{}) { notify() },
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				oldPod, ok := oldObj.(*corev1.Pod)
+				if !ok {
+					return
+				}
+				newPod, ok := newObj.(*corev1.Pod)
+				if !ok {
+					return
+				}
+				if oldPod.Status.PodIP != newPod.Status.PodIP ||
+					(oldPod.DeletionTimestamp == nil) != (newPod.DeletionTimestamp == nil) {
+					notify()
+				}
+			},
+		}); err != nil {
+			log.Printf("Error registering Vault pod watch: %v", err)
+			return changes
+		}
+
+		factory.Start(ctx.Done())
+	}
+
+	return changes
+}
+
+// GetPod retrieves a Kubernetes pod
+func (c *Client) GetPod(namespace, name string) (*corev1.Pod, error) {
+	pod, err := c.clientset.CoreV1().Pods(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pod %s: %v", name, err)
+	}
+
+	return pod, nil
+}
+
+// RecordPodEvent records a Kubernetes Event on the Vault pod with the given IP, so problems show
+// up in kubectl describe next to the pod they concern
+func (c *Client) RecordPodEvent(namespace, podIP, eventType, reason, message string, annotations map[string]string) error {
+	pods, err := c.listVaultPods(namespace)
+	if err != nil {
+		return err
+	}
+
+	for _, pod := range pods {
+		if pod.Status.PodIP != podIP {
+			continue
+		}
+
+		now := metav1.Now()
+		event := &corev1.Event{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: pod.Name + ".",
+				Namespace:    namespace,
+				Annotations:  annotations,
+			},
+			InvolvedObject: corev1.ObjectReference{
+				APIVersion: "v1",
+				Kind:       "Pod",
+				Namespace:  namespace,
+				Name:       pod.Name,
+				UID:        pod.UID,
+			},
+			Type:           eventType,
+			Reason:         reason,
+			Message:        message,
+			Source:         corev1.EventSource{Component: eventComponent},
+			FirstTimestamp: now,
+			LastTimestamp:  now,
+			Count:          1,
+		}
+
+		if _, err := c.clientset.CoreV1().Events(namespace).Create(context.Background(), event, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create event for pod %s: %v", pod.Name, err)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("no Vault pod with IP %s", podIP)
+}
+
+// DeleteOrphanedPodEvents deletes the Events the controller recorded on Vault pods that no longer
+// exist, including those of an earlier pod of the same name, and returns how many it deleted.
+// Events of other components are left to the API server's TTL.
+func (c *Client) DeleteOrphanedPodEvents(namespace string) (int, error) {
+	pods, err := c.listVaultPods(namespace)
+	if err != nil {
+		return 0, err
+	}
+	live := make(map[types.UID]bool, len(pods))
+	for _, pod := range pods {
+		live[pod.UID] = true
+	}
+
+	events, err := c.clientset.CoreV1().Events(namespace).List(context.Background(), metav1.ListOptions{
+		FieldSelector: "source=" + eventComponent,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list events: %v", err)
+	}
+
+	deleted := 0
+	for _, event := range events.Items {
+		if event.Source.Component != eventComponent || event.InvolvedObject.Kind != "Pod" || live[event.InvolvedObject.UID] {
+			continue
+		}
+		err := c.clientset.CoreV1().Events(namespace).Delete(context.Background(), event.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return deleted, fmt.Errorf("failed to delete event %s: %v", event.Name, err)
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+// foreignUnsealerImages are image names of other unseal automations, which run as sidecars of
+// the Vault pods
+var foreignUnsealerImages = []string{"bank-vaults", "vault-init", "vault-unsealer"}
+
+// ForeignUnsealerSigns looks for other unseal automation acting on the Vault pods of namespace:
+// unsealer sidecars in the pods and Secrets holding keys in the bank-vaults layout. It returns
+// a description of each sign found.
+func (c *Client) ForeignUnsealerSigns(namespace string) ([]string, error) {
+	pods, err := c.listVaultPods(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	var signs []string
+	for _, pod := range pods {
+		containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+		for _, container := range containers {
+			for _, image := range foreignUnsealerImages {
+				if container.Name == image || strings.Contains(container.Image, "/"+image+":") || strings.HasPrefix(container.Image, image+":") {
+					signs = append(signs, fmt.Sprintf("pod %s runs container %s (%s)", pod.Name, container.Name, container.Image))
+
+					break
+				}
+			}
+		}
+	}
+
+	secrets, err := c.clientset.CoreV1().Secrets(namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list secrets: %v", err)
+	}
+	for _, secret := range secrets.Items {
+		if _, ok := secret.Data["vault-unseal-0"]; ok {
+			signs = append(signs, fmt.Sprintf("secret %s holds bank-vaults unseal keys", secret.Name))
+		}
+	}
+
+	return signs, nil
+}
+
+// GetStatefulSet retrieves a Kubernetes StatefulSet
+func (c *Client) GetStatefulSet(namespace, name string) (*appsv1.StatefulSet, error) {
+	statefulSet, err := c.clientset.AppsV1().StatefulSets(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get statefulset %s: %v", name, err)
+	}
+
+	return statefulSet, nil
+}
+
+// UpdateStatefulSet updates an existing Kubernetes StatefulSet
+func (c *Client) UpdateStatefulSet(statefulSet *appsv1.StatefulSet) error {
+	_, err := c.clientset.AppsV1().StatefulSets(statefulSet.Namespace).Update(context.Background(), statefulSet, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to update statefulset %s: %v", statefulSet.Name, err)
+	}
+
+	return nil
+}
+
+// AnnotateObject merges annotations into the metadata of a ConfigMap, Secret or StatefulSet.
+// kind is matched case-insensitively.
+func (c *Client) AnnotateObject(namespace, kind, name string, annotations map[string]string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to build annotation patch: %v", err)
+	}
+
+	ctx := context.Background()
+	switch strings.ToLower(kind) {
+	case "configmap":
+		_, err = c.clientset.CoreV1().ConfigMaps(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	case "secret":
+		_, err = c.clientset.CoreV1().Secrets(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	case "statefulset":
+		_, err = c.clientset.AppsV1().StatefulSets(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	default:
+		return fmt.Errorf("cannot annotate objects of kind %s", kind)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to annotate %s %s: %v", kind, name, err)
+	}
+
+	return nil
+}
+
+// CreateOrUpdateConfigMap creates the ConfigMap, or replaces the data, labels and annotations
+// of the existing one
+func (c *Client) CreateOrUpdateConfigMap(configMap *corev1.ConfigMap) error {
+	configMaps := c.clientset.CoreV1().ConfigMaps(configMap.Namespace)
+
+	existing, err := configMaps.Get(context.Background(), configMap.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if _, err := configMaps.Create(context.Background(), configMap, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create configmap %s: %v", configMap.Name, err)
+		}
+
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get configmap %s: %v", configMap.Name, err)
+	}
+
+	updated := existing.DeepCopy()
+	updated.Labels = configMap.Labels
+	updated.Annotations = configMap.Annotations
+	updated.Data = configMap.Data
+	updated.BinaryData = configMap.BinaryData
+
+	if _, err := configMaps.Update(context.Background(), updated, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update configmap %s: %v", configMap.Name, err)
+	}
+
+	return nil
+}
+
+// ReadObjectKey returns the value of a key of a Secret or ConfigMap, where kind is "secret" or
+// "configmap". ConfigMap keys are looked up in data and then in binaryData.
+func (c *Client) ReadObjectKey(namespace, kind, name, key string) ([]byte, error) {
+	var value []byte
+	var found bool
+
+	switch strings.ToLower(kind) {
+	case "secret":
+		secret, err := c.GetSecret(namespace, name)
+		if err != nil {
+			return nil, err
+		}
+		value, found = secret.Data[key]
+	case "configmap":
+		configMap, err := c.clientset.CoreV1().ConfigMaps(namespace).Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get configmap %s: %v", name, err)
+		}
+		if data, ok := configMap.Data[key]; ok {
+			value, found = []byte(data), true
+		} else {
+			value, found = configMap.BinaryData[key]
+		}
+	default:
+		return nil, fmt.Errorf("cannot read objects of kind %s", kind)
+	}
+
+	if !found {
+		return nil, fmt.Errorf("%s %s has no key %s", strings.ToLower(kind), name, key)
+	}
+
+	return value, nil
+}
+
 // CreateSecret creates a new Kubernetes secret
 func (c *Client) CreateSecret(secret *corev1.Secret) error {
 	_, err := c.clientset.CoreV1().Secrets(secret.Namespace).Create(context.Background(), secret, metav1.CreateOptions{})
@@ -92,6 +585,74 @@ func (c *Client) GetSecret(namespace, name string) (*corev1.Secret, error) {
 	return secret, nil
 }
 
+// SecretExists reports whether the Secret exists, distinguishing a missing Secret from a failed read
+func (c *Client) SecretExists(namespace, name string) (bool, error) {
+	_, err := c.clientset.CoreV1().Secrets(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get secret %s: %v", name, err)
+	}
+
+	return true, nil
+}
+
+// UnsealKeysFromSecret returns the unseal keys stored in Secret data in a deterministic order:
+// entries named key<N> sorted by N, followed by any other key* entries sorted by name. It also
+// returns the names missing from the key<N> sequence so gaps can be reported instead of
+// silently skipping key material.
+func UnsealKeysFromSecret(data map[string][]byte) ([]string, []string) {
+	var numbered []int
+	var named []string
+
+	for name := range data {
+		if !strings.HasPrefix(name, "key") {
+			continue
+		}
+
+		// Only canonical numbers count as numbered, so key01 cannot shadow key1
+		suffix := strings.TrimPrefix(name, "key")
+		if n, err := strconv.Atoi(suffix); err == nil && n > 0 && strconv.Itoa(n) == suffix {
+			numbered = append(numbered, n)
+			continue
+		}
+
+		named = append(named, name)
+	}
+
+	sort.Ints(numbered)
+	sort.Strings(named)
+
+	keys := make([]string, 0, len(numbered)+len(named))
+	var missing []string
+
+	for i, n := range numbered {
+		previous := 0
+		if i > 0 {
+			previous = numbered[i-1]
+		}
+
+		// Vault never generates more than maxKeyShares keys, so a wider gap is reported as a
+		// range rather than enumerated
+		if n-previous-1 > maxKeyShares {
+			missing = append(missing, fmt.Sprintf("key%d-key%d", previous+1, n-1))
+		} else {
+			for gap := previous + 1; gap < n; gap++ {
+				missing = append(missing, fmt.Sprintf("key%d", gap))
+			}
+		}
+
+		keys = append(keys, string(data[fmt.Sprintf("key%d", n)]))
+	}
+
+	for _, name := range named {
+		keys = append(keys, string(data[name]))
+	}
+
+	return keys, missing
+}
+
 // CreateUnsealKeySecret creates a secret containing Vault unseal keys
 func (c *Client) CreateUnsealKeySecret(namespace string, keys []string) error {
 	unsealKeysData := make(map[string][]byte)
@@ -140,3 +701,60 @@ func (c *Client) UpdateSecret(secret *corev1.Secret) error {
 	_, err := c.clientset.CoreV1().Secrets(secret.Namespace).Update(context.Background(), secret, metav1.UpdateOptions{})
 	return err
 }
+
+// CreateOrUpdateSecret creates the Secret, or replaces the data of the existing one and sets its
+// labels and annotations on it. Labels and annotations others set, such as the ownership marks
+// of Helm or Argo CD, are kept. The type of an existing Secret is kept too, since Kubernetes does
+// not let it change.
+func (c *Client) CreateOrUpdateSecret(secret *corev1.Secret) error {
+	secrets := c.clientset.CoreV1().Secrets(secret.Namespace)
+
+	existing, err := secrets.Get(context.Background(), secret.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if _, err := secrets.Create(context.Background(), secret, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create secret %s: %v", secret.Name, err)
+		}
+
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get secret %s: %v", secret.Name, err)
+	}
+
+	updated := existing.DeepCopy()
+	updated.Labels = mergeMetadata(updated.Labels, secret.Labels)
+	updated.Annotations = mergeMetadata(updated.Annotations, secret.Annotations)
+	updated.Data = secret.Data
+	updated.StringData = secret.StringData
+
+	if _, err := secrets.Update(context.Background(), updated, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update secret %s: %v", secret.Name, err)
+	}
+
+	return nil
+}
+
+// mergeMetadata returns existing labels or annotations with desired set on them
+func mergeMetadata(existing, desired map[string]string) map[string]string {
+	if len(desired) == 0 {
+		return existing
+	}
+	if existing == nil {
+		existing = make(map[string]string, len(desired))
+	}
+	for key, value := range desired {
+		existing[key] = value
+	}
+
+	return existing
+}
+
+// DeleteSecret deletes a Kubernetes secret, succeeding when it does not exist
+func (c *Client) DeleteSecret(namespace, name string) error {
+	err := c.clientset.CoreV1().Secrets(namespace).Delete(context.Background(), name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete secret %s: %v", name, err)
+	}
+
+	return nil
+}
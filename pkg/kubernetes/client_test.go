@@ -2,10 +2,15 @@ package kubernetes
 
 import (
 	"context"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/fake"
 )
 
@@ -95,6 +100,26 @@ func TestGetVaultPods(t *testing.T) {
 			t.Errorf("unexpected pod IP: %s", podIP)
 		}
 	}
+
+	// A preset with other labels selects other pods
+	client.SetPodSelectors("app.kubernetes.io/name=vault,!component")
+	pods, err = client.GetVaultPods("vault")
+	if err != nil {
+		t.Fatalf("failed to get vault pods: %v", err)
+	}
+	if len(pods) != 1 || pods[0] != "10.0.0.3" {
+		t.Errorf("expected only the pod matching the custom selector, got %v", pods)
+	}
+
+	// Several selectors find the pods matching any of them, once each
+	client.SetPodSelectors("app.kubernetes.io/name=vault,!component", "component=server", "app.kubernetes.io/name=vault")
+	pods, err = client.GetVaultPods("vault")
+	if err != nil {
+		t.Fatalf("failed to get vault pods: %v", err)
+	}
+	if len(pods) != 3 {
+		t.Errorf("expected every pod once, got %v", pods)
+	}
 }
 
 func TestCreateAndGetSecret(t *testing.T) {
@@ -102,13 +127,23 @@ func TestCreateAndGetSecret(t *testing.T) {
 	clientset := fake.NewSimpleClientset()
 	client := NewClientWithInterface(clientset)
 
+	exists, err := client.SecretExists("vault", "vault-unseal-keys")
+	if err != nil || exists {
+		t.Fatalf("expected secret not to exist yet, got exists=%v err=%v", exists, err)
+	}
+
 	// Test creating unseal key secret
 	keys := []string{"key1", "key2", "key3"}
-	err := client.CreateUnsealKeySecret("vault", keys)
+	err = client.CreateUnsealKeySecret("vault", keys)
 	if err != nil {
 		t.Fatalf("failed to create unseal key secret: %v", err)
 	}
 
+	exists, err = client.SecretExists("vault", "vault-unseal-keys")
+	if err != nil || !exists {
+		t.Fatalf("expected secret to exist, got exists=%v err=%v", exists, err)
+	}
+
 	// Test getting the created secret
 	secret, err := client.GetSecret("vault", "vault-unseal-keys")
 	if err != nil {
@@ -140,4 +175,638 @@ func TestCreateAndGetSecret(t *testing.T) {
 	if string(secret.Data["token"]) != rootToken {
 		t.Errorf("expected root token to be %s, got %s", rootToken, string(secret.Data["token"]))
 	}
+
+	// Test deleting the root token secret, twice
+	for i := 0; i < 2; i++ {
+		if err := client.DeleteSecret("vault", "vault-root-token"); err != nil {
+			t.Fatalf("failed to delete root token secret: %v", err)
+		}
+	}
+	exists, err = client.SecretExists("vault", "vault-root-token")
+	if err != nil || exists {
+		t.Errorf("expected root token secret to be deleted, got exists=%v err=%v", exists, err)
+	}
+}
+
+func TestCountRunningVaultPods(t *testing.T) {
+	now := metav1.Now()
+	labels := map[string]string{"app.kubernetes.io/name": "vault", "component": "server"}
+	clientset := fake.NewSimpleClientset(
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "vault-0", Namespace: "vault", Labels: labels},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning, PodIP: "10.0.0.1"},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "vault-1", Namespace: "vault", Labels: labels},
+			Status:     corev1.PodStatus{Phase: corev1.PodPending},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "vault-2", Namespace: "vault", Labels: labels, DeletionTimestamp: &now, Finalizers: []string{"test"}},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning, PodIP: "10.0.0.3"},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "vault"},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning, PodIP: "10.0.0.4"},
+		},
+	)
+
+	running, err := NewClientWithInterface(clientset).CountRunningVaultPods("vault")
+	if err != nil {
+		t.Fatalf("failed to count running vault pods: %v", err)
+	}
+	if running != 1 {
+		t.Errorf("expected 1 running pod, got %d", running)
+	}
+}
+
+func TestGetDrainingVaultPods(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	now := metav1.Now()
+
+	objects := []struct {
+		node *corev1.Node
+		pod  *corev1.Pod
+	}{
+		{
+			node: &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}},
+			pod: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "vault-0",
+					Namespace: "vault",
+					Labels:    map[string]string{"app.kubernetes.io/name": "vault", "component": "server"},
+				},
+				Spec:   corev1.PodSpec{NodeName: "node-a"},
+				Status: corev1.PodStatus{PodIP: "10.0.0.1"},
+			},
+		},
+		{
+			node: &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "node-b"},
+				Spec:       corev1.NodeSpec{Unschedulable: true},
+			},
+			pod: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "vault-1",
+					Namespace: "vault",
+					Labels:    map[string]string{"app.kubernetes.io/name": "vault", "component": "server"},
+				},
+				Spec:   corev1.PodSpec{NodeName: "node-b"},
+				Status: corev1.PodStatus{PodIP: "10.0.0.2"},
+			},
+		},
+		{
+			node: &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-c"}},
+			pod: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "vault-2",
+					Namespace:         "vault",
+					Labels:            map[string]string{"app.kubernetes.io/name": "vault", "component": "server"},
+					DeletionTimestamp: &now,
+					Finalizers:        []string{"test"},
+				},
+				Spec:   corev1.PodSpec{NodeName: "node-c"},
+				Status: corev1.PodStatus{PodIP: "10.0.0.3"},
+			},
+		},
+	}
+
+	for _, obj := range objects {
+		if _, err := clientset.CoreV1().Nodes().Create(context.Background(), obj.node, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("failed to create test node: %v", err)
+		}
+		if _, err := clientset.CoreV1().Pods("vault").Create(context.Background(), obj.pod, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("failed to create test pod: %v", err)
+		}
+	}
+
+	client := NewClientWithInterface(clientset)
+
+	pods, err := client.GetDrainingVaultPods("vault")
+	if err != nil {
+		t.Fatalf("failed to get draining vault pods: %v", err)
+	}
+
+	expectedIPs := map[string]bool{
+		"10.0.0.2": true,
+		"10.0.0.3": true,
+	}
+
+	if len(pods) != len(expectedIPs) {
+		t.Errorf("expected %d draining pods, got %d", len(expectedIPs), len(pods))
+	}
+
+	for _, podIP := range pods {
+		if !expectedIPs[podIP] {
+			t.Errorf("unexpected draining pod IP: %s", podIP)
+		}
+	}
+}
+
+func TestWatchVaultPods(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	client := NewClientWithInterface(clientset)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := client.WatchVaultPods(ctx, "vault")
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "vault-0",
+			Namespace: "vault",
+			Labels:    map[string]string{"app.kubernetes.io/name": "vault", "component": "server"},
+		},
+		Status: corev1.PodStatus{PodIP: "10.0.0.1"},
+	}
+
+	if _, err := clientset.CoreV1().Pods("vault").Create(context.Background(), pod, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create test pod: %v", err)
+	}
+
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a change notification for the new pod")
+	}
+}
+
+func TestRecordPodEvent(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "vault-0",
+			Namespace: "vault",
+			UID:       "uid-0",
+			Labels: map[string]string{
+				"app.kubernetes.io/name": "vault",
+				"component":              "server",
+			},
+		},
+		Status: corev1.PodStatus{PodIP: "10.0.0.1"},
+	})
+	client := NewClientWithInterface(clientset)
+
+	if err := client.RecordPodEvent("vault", "10.0.0.1", corev1.EventTypeWarning, "VaultUnreachable", "tcp stage failed",
+		map[string]string{"vault-utils.growly.io/reason": "VAULT_UNREACHABLE"}); err != nil {
+		t.Fatalf("failed to record event: %v", err)
+	}
+
+	events, err := clientset.CoreV1().Events("vault").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list events: %v", err)
+	}
+	if len(events.Items) != 1 {
+		t.Fatalf("expected one event, got %d", len(events.Items))
+	}
+
+	event := events.Items[0]
+	if event.InvolvedObject.Name != "vault-0" || event.InvolvedObject.UID != "uid-0" {
+		t.Errorf("expected the event to reference pod vault-0, got %+v", event.InvolvedObject)
+	}
+	if event.Type != corev1.EventTypeWarning || event.Reason != "VaultUnreachable" || event.Message != "tcp stage failed" {
+		t.Errorf("unexpected event %s/%s: %s", event.Type, event.Reason, event.Message)
+	}
+	if event.Annotations["vault-utils.growly.io/reason"] != "VAULT_UNREACHABLE" {
+		t.Errorf("expected the reason annotation to be set, got %v", event.Annotations)
+	}
+
+	if err := client.RecordPodEvent("vault", "10.0.0.9", corev1.EventTypeWarning, "VaultUnreachable", "gone", nil); err == nil {
+		t.Errorf("expected an error for an unknown pod IP")
+	}
+}
+
+func TestDeleteOrphanedPodEvents(t *testing.T) {
+	event := func(name, pod string, uid types.UID, component string) *corev1.Event {
+		return &corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: name, Namespace: "vault"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Namespace: "vault", Name: pod, UID: uid},
+			Source:         corev1.EventSource{Component: component},
+		}
+	}
+	clientset := fake.NewSimpleClientset(
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "vault-0",
+				Namespace: "vault",
+				UID:       "uid-0",
+				Labels:    map[string]string{"app.kubernetes.io/name": "vault", "component": "server"},
+			},
+		},
+		event("vault-0.live", "vault-0", "uid-0", eventComponent),
+		event("vault-0.replaced", "vault-0", "uid-0-old", eventComponent),
+		event("vault-1.gone", "vault-1", "uid-1", eventComponent),
+		event("vault-1.kubelet", "vault-1", "uid-1", "kubelet"),
+	)
+	client := NewClientWithInterface(clientset)
+
+	deleted, err := client.DeleteOrphanedPodEvents("vault")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("expected 2 events deleted, got %d", deleted)
+	}
+
+	events, err := clientset.CoreV1().Events("vault").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list events: %v", err)
+	}
+	var names []string
+	for _, event := range events.Items {
+		names = append(names, event.Name)
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "vault-0.live,vault-1.kubelet" {
+		t.Errorf("expected the events of the live pod and of other components to be kept, got %v", names)
+	}
+}
+
+func TestAnnotateObject(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "cmdb",
+			Namespace:   "vault",
+			Annotations: map[string]string{"owner": "platform"},
+		},
+	})
+	client := NewClientWithInterface(clientset)
+
+	if err := client.AnnotateObject("vault", "ConfigMap", "cmdb", map[string]string{"vault-utils.growly.io/last-post-init": "now"}); err != nil {
+		t.Fatalf("failed to annotate config map: %v", err)
+	}
+
+	cm, err := clientset.CoreV1().ConfigMaps("vault").Get(context.Background(), "cmdb", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get config map: %v", err)
+	}
+	if cm.Annotations["vault-utils.growly.io/last-post-init"] != "now" || cm.Annotations["owner"] != "platform" {
+		t.Errorf("expected the annotation to be merged, got %v", cm.Annotations)
+	}
+
+	if err := client.AnnotateObject("vault", "Deployment", "app", nil); err == nil {
+		t.Errorf("expected an error for an unsupported kind")
+	}
+	if err := client.AnnotateObject("vault", "secret", "missing", nil); err == nil {
+		t.Errorf("expected an error for a missing object")
+	}
+}
+
+func TestForeignUnsealerSigns(t *testing.T) {
+	labels := map[string]string{"app.kubernetes.io/name": "vault", "component": "server"}
+	clientset := fake.NewSimpleClientset(
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "vault-0", Namespace: "vault", Labels: labels},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "vault", Image: "hashicorp/vault:1.15"},
+				{Name: "unsealer", Image: "ghcr.io/bank-vaults/bank-vaults:v1.20"},
+			}},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "vault-1", Namespace: "vault", Labels: labels},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "vault", Image: "hashicorp/vault:1.15"}}},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "vault-unseal-keys", Namespace: "vault"},
+			Data:       map[string][]byte{"vault-unseal-0": []byte("key"), "vault-root": []byte("token")},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "vault"},
+			Data:       map[string][]byte{"key1": []byte("key")},
+		},
+	)
+	client := NewClientWithInterface(clientset)
+
+	signs, err := client.ForeignUnsealerSigns("vault")
+	if err != nil {
+		t.Fatalf("failed to look for other unseal automation: %v", err)
+	}
+	if len(signs) != 2 || !strings.Contains(signs[0], "vault-0 runs container unsealer") || !strings.Contains(signs[1], "secret vault-unseal-keys") {
+		t.Errorf("expected the sidecar and the bank-vaults secret, got %v", signs)
+	}
+
+	signs, err = client.ForeignUnsealerSigns("apps")
+	if err != nil || len(signs) != 0 {
+		t.Errorf("expected no signs in another namespace, got %v, %v", signs, err)
+	}
+}
+
+func TestVaultServerPort(t *testing.T) {
+	labels := map[string]string{"app.kubernetes.io/name": "vault", "component": "server"}
+	clientset := fake.NewSimpleClientset(
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "vault-0", Namespace: "vault", Labels: labels},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "vault-agent", Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8100}}},
+				{Name: "vault", Ports: []corev1.ContainerPort{
+					{Name: "https", ContainerPort: 8300},
+					{Name: "https-internal", ContainerPort: 8201},
+				}},
+			}},
+			Status: corev1.PodStatus{PodIP: "10.0.0.1"},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "vault-1", Namespace: "vault", Labels: labels},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "vault", Ports: []corev1.ContainerPort{{Name: "https", ContainerPort: 8200}}},
+			}},
+			Status: corev1.PodStatus{PodIP: "10.0.0.2"},
+		},
+	)
+	client := NewClientWithInterface(clientset)
+	client.SetPodSelectors("app.kubernetes.io/name=vault,component=server")
+
+	tests := []struct {
+		name        string
+		podIP       string
+		expected    string
+		expectError bool
+	}{
+		{name: "server port behind the agent", podIP: "10.0.0.1", expected: "8300"},
+		{name: "no other port", podIP: "10.0.0.2", expected: ""},
+		{name: "unknown pod", podIP: "10.0.0.3", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port, err := client.VaultServerPort("vault", tt.podIP, "8200")
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected an error, got port %q", port)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if port != tt.expected {
+				t.Errorf("expected port %q, got %q", tt.expected, port)
+			}
+		})
+	}
+}
+
+func TestCreateOrUpdateConfigMap(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	client := NewClientWithInterface(clientset)
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "vault-connection", Namespace: "apps"},
+		Data:       map[string]string{"VAULT_ADDR": "http://vault.vault.svc:8200"},
+	}
+	if err := client.CreateOrUpdateConfigMap(configMap); err != nil {
+		t.Fatalf("failed to create config map: %v", err)
+	}
+
+	configMap = configMap.DeepCopy()
+	configMap.Data["VAULT_ADDR"] = "https://vault.vault.svc:8200"
+	if err := client.CreateOrUpdateConfigMap(configMap); err != nil {
+		t.Fatalf("failed to update config map: %v", err)
+	}
+
+	got, err := clientset.CoreV1().ConfigMaps("apps").Get(context.Background(), "vault-connection", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get config map: %v", err)
+	}
+	if got.Data["VAULT_ADDR"] != "https://vault.vault.svc:8200" {
+		t.Errorf("expected the config map to be updated, got %v", got.Data)
+	}
+}
+
+func TestCreateOrUpdateSecret(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	client := NewClientWithInterface(clientset)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "vault-root-token",
+			Namespace:   "vault",
+			Labels:      map[string]string{"team": "platform"},
+			Annotations: map[string]string{"note": "initialized"},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{"token": []byte("old-token")},
+	}
+	if err := client.CreateOrUpdateSecret(secret); err != nil {
+		t.Fatalf("failed to create secret: %v", err)
+	}
+
+	// Annotations set by others, such as Argo CD tracking the Secret, survive updates
+	existing, err := clientset.CoreV1().Secrets("vault").Get(context.Background(), "vault-root-token", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get secret: %v", err)
+	}
+	existing.Annotations["argocd.argoproj.io/tracking-id"] = "vault:/Secret:vault/vault-root-token"
+	if _, err := clientset.CoreV1().Secrets("vault").Update(context.Background(), existing, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to annotate secret: %v", err)
+	}
+
+	// The update needs no resource version, replaces the data and sets the annotations given
+	secret = &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "vault-root-token", Namespace: "vault", Annotations: map[string]string{"note": "re-initialized"}},
+		Data:       map[string][]byte{"token": []byte("new-token")},
+	}
+	if err := client.CreateOrUpdateSecret(secret); err != nil {
+		t.Fatalf("failed to update secret: %v", err)
+	}
+
+	got, err := clientset.CoreV1().Secrets("vault").Get(context.Background(), "vault-root-token", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get secret: %v", err)
+	}
+	if string(got.Data["token"]) != "new-token" || got.Annotations["note"] != "re-initialized" {
+		t.Errorf("expected the secret to be updated, got %+v", got)
+	}
+	if got.Labels["team"] != "platform" || got.Annotations["argocd.argoproj.io/tracking-id"] != "vault:/Secret:vault/vault-root-token" {
+		t.Errorf("expected the labels and annotations of others to be kept, got %v and %v", got.Labels, got.Annotations)
+	}
+	if got.Type != corev1.SecretTypeOpaque {
+		t.Errorf("expected the type of the secret to be kept, got %q", got.Type)
+	}
+}
+
+func TestReadObjectKey(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "vault-tls", Namespace: "vault"},
+			Data:       map[string][]byte{"ca.crt": []byte("secret-ca")},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "vault-ca", Namespace: "vault"},
+			Data:       map[string]string{"ca.crt": "configmap-ca"},
+			BinaryData: map[string][]byte{"ca.der": []byte("binary-ca")},
+		},
+	)
+	client := NewClientWithInterface(clientset)
+
+	tests := []struct {
+		name        string
+		kind        string
+		object      string
+		key         string
+		expected    string
+		expectError bool
+	}{
+		{name: "secret", kind: "secret", object: "vault-tls", key: "ca.crt", expected: "secret-ca"},
+		{name: "configmap data", kind: "configmap", object: "vault-ca", key: "ca.crt", expected: "configmap-ca"},
+		{name: "configmap binary data", kind: "configmap", object: "vault-ca", key: "ca.der", expected: "binary-ca"},
+		{name: "missing key", kind: "secret", object: "vault-tls", key: "tls.crt", expectError: true},
+		{name: "missing object", kind: "configmap", object: "missing", key: "ca.crt", expectError: true},
+		{name: "unknown kind", kind: "pod", object: "vault-0", key: "ca.crt", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := client.ReadObjectKey("vault", tt.kind, tt.object, tt.key)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected an error, got %q", value)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(value) != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, value)
+			}
+		})
+	}
+}
+
+func TestUnsealKeysFromSecret(t *testing.T) {
+	tests := []struct {
+		name            string
+		data            map[string][]byte
+		expectedKeys    []string
+		expectedMissing []string
+	}{
+		{
+			name: "contiguous numbering",
+			data: map[string][]byte{
+				"key2": []byte("b"),
+				"key1": []byte("a"),
+				"key3": []byte("c"),
+			},
+			expectedKeys: []string{"a", "b", "c"},
+		},
+		{
+			name: "gaps in numbering keep all keys",
+			data: map[string][]byte{
+				"key1": []byte("a"),
+				"key3": []byte("c"),
+				"key4": []byte("d"),
+			},
+			expectedKeys:    []string{"a", "c", "d"},
+			expectedMissing: []string{"key2"},
+		},
+		{
+			name: "numeric rather than lexical order",
+			data: map[string][]byte{
+				"key10": []byte("j"),
+				"key2":  []byte("b"),
+				"key1":  []byte("a"),
+			},
+			expectedKeys:    []string{"a", "b", "j"},
+			expectedMissing: []string{"key3", "key4", "key5", "key6", "key7", "key8", "key9"},
+		},
+		{
+			name: "other key names sorted after numbered keys",
+			data: map[string][]byte{
+				"key-backup": []byte("z"),
+				"key1":       []byte("a"),
+				"key-alpha":  []byte("y"),
+			},
+			expectedKeys: []string{"a", "y", "z"},
+		},
+		{
+			name: "zero padded names are not numbered",
+			data: map[string][]byte{
+				"key01": []byte("z"),
+				"key1":  []byte("a"),
+			},
+			expectedKeys: []string{"a", "z"},
+		},
+		{
+			name: "wide gaps reported as a range",
+			data: map[string][]byte{
+				"key1":          []byte("a"),
+				"key2000000000": []byte("b"),
+			},
+			expectedKeys:    []string{"a", "b"},
+			expectedMissing: []string{"key2-key1999999999"},
+		},
+		{
+			name: "non key entries ignored",
+			data: map[string][]byte{
+				"key1":      []byte("a"),
+				"threshold": []byte("3"),
+			},
+			expectedKeys: []string{"a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, missing := UnsealKeysFromSecret(tt.data)
+
+			if strings.Join(keys, ",") != strings.Join(tt.expectedKeys, ",") {
+				t.Errorf("expected keys %v, got %v", tt.expectedKeys, keys)
+			}
+			if strings.Join(missing, ",") != strings.Join(tt.expectedMissing, ",") {
+				t.Errorf("expected missing %v, got %v", tt.expectedMissing, missing)
+			}
+		})
+	}
+}
+
+func FuzzUnsealKeysFromSecret(f *testing.F) {
+	f.Add("key1\nkey2\nkey3", "abcd")
+	f.Add("key1\nkey3\nkey10", "abcd")
+	f.Add("key01\nkey1\nkey-backup\nthreshold", "")
+	f.Add("key9223372036854775807\nkey1", "abcd")
+	f.Add("key-1\nkey0\nkey+1", "abcd")
+
+	f.Fuzz(func(t *testing.T, names string, value string) {
+		data := make(map[string][]byte)
+		for i, name := range strings.Split(names, "\n") {
+			data[name] = []byte(value + strconv.Itoa(i))
+		}
+
+		keys, missing := UnsealKeysFromSecret(data)
+
+		entries := 0
+		for name := range data {
+			if strings.HasPrefix(name, "key") {
+				entries++
+			}
+		}
+		if len(keys) != entries {
+			t.Errorf("expected every key entry to be returned once, got %d of %d", len(keys), entries)
+		}
+
+		// Every returned key must come from the Secret, each entry exactly once
+		remaining := make(map[string]int)
+		for name, v := range data {
+			if strings.HasPrefix(name, "key") {
+				remaining[string(v)]++
+			}
+		}
+		for _, key := range keys {
+			if remaining[key] == 0 {
+				t.Fatalf("returned key %q not stored in the secret", key)
+			}
+			remaining[key]--
+		}
+
+		if len(missing) > (maxKeyShares+1)*len(data) {
+			t.Errorf("missing list grew to %d entries for %d stored entries", len(missing), len(data))
+		}
+		for _, name := range missing {
+			if _, ok := data[name]; ok {
+				t.Errorf("reported %s as missing although it is stored", name)
+			}
+		}
+	})
 }
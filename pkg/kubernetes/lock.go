@@ -0,0 +1,247 @@
+package kubernetes
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ActionLockName is the Lease in the Vault namespace that serializes destructive actions on
+	// the cluster, such as init, rekey and restore, across every actor using vault-utils: the
+	// controller, CLI runs and Jobs
+	ActionLockName = "vault-utils-action-lock"
+	// ActionLockDuration is how long the lock outlives its holder when it is not released, such
+	// as after a crash. The holder renews it every third of that while it holds it.
+	ActionLockDuration = 60 * time.Second
+	// actionAnnotation records on the lock which action its holder is taking
+	actionAnnotation = "vault-utils.growly.io/action"
+	// acquisitionSeparator separates the identity of the holder of the lock from the suffix
+	// telling its acquisitions apart
+	acquisitionSeparator = "#"
+)
+
+// Actions taken under the action lock
+const (
+	ActionInit    = "init"
+	ActionMigrate = "migrate"
+	ActionRekey   = "rekey"
+	ActionRestore = "restore"
+	// ActionGenerateRoot replaces no key material, but Vault runs one generation at a time
+	ActionGenerateRoot = "generate-root"
+)
+
+// LockHeldError means the action lock is held by another actor
+type LockHeldError struct {
+	// Holder is the identity of the actor holding the lock
+	Holder string
+	Action string
+	// Until is when the lock expires unless its holder renews or releases it
+	Until time.Time
+}
+
+// Error implements error
+func (e *LockHeldError) Error() string {
+	return fmt.Sprintf("action lock held by %s for %s until %s", e.Holder, e.Action, e.Until.UTC().Format(time.RFC3339))
+}
+
+// ActionLock is a held action lock. It is renewed in the background until released.
+type ActionLock struct {
+	client    *Client
+	namespace string
+	holder    string
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// AcquireActionLock takes the action lock of the Vault cluster in namespace for identity to take
+// action, such as init. It returns a *LockHeldError when the lock is held and has not expired.
+// Every acquisition holds the lock on its own, so two actions of the same identity, such as two
+// admin requests to one controller or two shells on one host, exclude each other too, and a
+// restarted controller takes its lock over only once it has expired.
+func (c *Client) AcquireActionLock(namespace, action, identity string) (*ActionLock, error) {
+	holder, err := acquisitionHolder(identity)
+	if err != nil {
+		return nil, err
+	}
+
+	leases := c.clientset.CoordinationV1().Leases(namespace)
+	now := metav1.NewMicroTime(time.Now())
+	duration := int32(ActionLockDuration / time.Second)
+
+	lease, err := leases.Get(context.Background(), ActionLockName, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        ActionLockName,
+				Namespace:   namespace,
+				Labels:      map[string]string{managedByLabel: eventComponent},
+				Annotations: map[string]string{actionAnnotation: action},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &holder,
+				LeaseDurationSeconds: &duration,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		if _, err := leases.Create(context.Background(), lease, metav1.CreateOptions{}); err != nil {
+			if apierrors.IsAlreadyExists(err) {
+				return nil, fmt.Errorf("action lock was taken by another actor at the same time")
+			}
+
+			return nil, fmt.Errorf("failed to create action lock: %v", err)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("failed to get action lock: %v", err)
+	default:
+		if held := heldAt(lease, now.Time); held != nil {
+			return nil, held
+		}
+
+		if lease.Annotations == nil {
+			lease.Annotations = make(map[string]string)
+		}
+		lease.Annotations[actionAnnotation] = action
+		lease.Spec.HolderIdentity = &holder
+		lease.Spec.LeaseDurationSeconds = &duration
+		lease.Spec.AcquireTime = &now
+		lease.Spec.RenewTime = &now
+		// The update carries the version read above, so of two actors taking an expired lock at
+		// the same time only one succeeds
+		if _, err := leases.Update(context.Background(), lease, metav1.UpdateOptions{}); err != nil {
+			if apierrors.IsConflict(err) {
+				return nil, fmt.Errorf("action lock was taken by another actor at the same time")
+			}
+
+			return nil, fmt.Errorf("failed to take action lock: %v", err)
+		}
+	}
+
+	lock := &ActionLock{
+		client:    c,
+		namespace: namespace,
+		holder:    holder,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go lock.renew()
+
+	return lock, nil
+}
+
+// ActionLockHolder returns who holds the action lock of the Vault cluster in namespace and for
+// which action, or nil when it is free or has expired
+func (c *Client) ActionLockHolder(namespace string) (*LockHeldError, error) {
+	lease, err := c.clientset.CoordinationV1().Leases(namespace).Get(context.Background(), ActionLockName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get action lock: %v", err)
+	}
+
+	return heldAt(lease, time.Now()), nil
+}
+
+// acquisitionHolder returns the holder of an acquisition of the lock by identity, which is
+// identity with a random suffix
+func acquisitionHolder(identity string) (string, error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("failed to generate action lock holder: %v", err)
+	}
+
+	return identity + acquisitionSeparator + hex.EncodeToString(suffix), nil
+}
+
+// heldAt returns the error reporting lease as held when it has a holder and has not expired at
+// now, or nil when it may be taken
+func heldAt(lease *coordinationv1.Lease, now time.Time) *LockHeldError {
+	spec := lease.Spec
+	if spec.HolderIdentity == nil || *spec.HolderIdentity == "" {
+		return nil
+	}
+	if spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+		return nil
+	}
+
+	until := spec.RenewTime.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second)
+	if !now.Before(until) {
+		return nil
+	}
+
+	identity, _, _ := strings.Cut(*spec.HolderIdentity, acquisitionSeparator)
+
+	return &LockHeldError{Holder: identity, Action: lease.Annotations[actionAnnotation], Until: until}
+}
+
+// renew keeps the lock from expiring until it is released
+func (l *ActionLock) renew() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(ActionLockDuration / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		leases := l.client.clientset.CoordinationV1().Leases(l.namespace)
+		lease, err := leases.Get(context.Background(), ActionLockName, metav1.GetOptions{})
+		if err != nil {
+			log.Printf("Error renewing action lock: %v", err)
+
+			continue
+		}
+		if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != l.holder {
+			log.Printf("Warning: action lock was taken over by another actor")
+
+			return
+		}
+
+		now := metav1.NewMicroTime(time.Now())
+		lease.Spec.RenewTime = &now
+		if _, err := leases.Update(context.Background(), lease, metav1.UpdateOptions{}); err != nil {
+			log.Printf("Error renewing action lock: %v", err)
+		}
+	}
+}
+
+// Release stops renewing the lock and deletes it, unless another actor took it over meanwhile
+func (l *ActionLock) Release() error {
+	close(l.stop)
+	<-l.done
+
+	leases := l.client.clientset.CoordinationV1().Leases(l.namespace)
+	lease, err := leases.Get(context.Background(), ActionLockName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to release action lock: %v", err)
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != l.holder {
+		return nil
+	}
+
+	// Deleting only the version read above leaves a lock taken over in between alone
+	preconditions := metav1.Preconditions{ResourceVersion: &lease.ResourceVersion}
+	if err := leases.Delete(context.Background(), ActionLockName, metav1.DeleteOptions{Preconditions: &preconditions}); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to release action lock: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestActionLock(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	client := NewClientWithInterface(clientset)
+
+	lock, err := client.AcquireActionLock("vault", ActionMigrate, "vault-utils-cli/ops@laptop")
+	if err != nil {
+		t.Fatalf("failed to take action lock: %v", err)
+	}
+
+	_, err = client.AcquireActionLock("vault", ActionInit, "vault-utils/vault-auto-unseal-0")
+	var held *LockHeldError
+	if !errors.As(err, &held) {
+		t.Fatalf("expected the lock to be held, got %v", err)
+	}
+	if held.Holder != "vault-utils-cli/ops@laptop" || held.Action != ActionMigrate {
+		t.Errorf("expected the holder and action to be reported, got %+v", held)
+	}
+
+	if err := lock.Release(); err != nil {
+		t.Fatalf("failed to release action lock: %v", err)
+	}
+	if _, err := clientset.CoordinationV1().Leases("vault").Get(context.Background(), ActionLockName, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the released lock to be deleted, got %v", err)
+	}
+
+	lock, err = client.AcquireActionLock("vault", ActionInit, "vault-utils/vault-auto-unseal-0")
+	if err != nil {
+		t.Fatalf("expected the released lock to be free, got %v", err)
+	}
+	defer lock.Release()
+}
+
+func TestActionLockSameIdentity(t *testing.T) {
+	client := NewClientWithInterface(fake.NewSimpleClientset())
+
+	// Two actions of one controller, or two shells on one host, do not share the lock
+	lock, err := client.AcquireActionLock("vault", ActionRekey, "vault-utils/vault-auto-unseal-0")
+	if err != nil {
+		t.Fatalf("failed to take action lock: %v", err)
+	}
+	_, err = client.AcquireActionLock("vault", ActionRestore, "vault-utils/vault-auto-unseal-0")
+	var held *LockHeldError
+	if !errors.As(err, &held) {
+		t.Fatalf("expected the lock to be held by the first action, got %v", err)
+	}
+	if held.Holder != "vault-utils/vault-auto-unseal-0" || held.Action != ActionRekey {
+		t.Errorf("expected the holder and action to be reported, got %+v", held)
+	}
+
+	if err := lock.Release(); err != nil {
+		t.Fatalf("failed to release action lock: %v", err)
+	}
+	lock, err = client.AcquireActionLock("vault", ActionRestore, "vault-utils/vault-auto-unseal-0")
+	if err != nil {
+		t.Fatalf("expected the released lock to be free, got %v", err)
+	}
+	defer lock.Release()
+}
+
+func TestActionLockExpires(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	client := NewClientWithInterface(clientset)
+
+	// A holder that crashed without releasing the lock
+	if _, err := client.AcquireActionLock("vault", ActionMigrate, "vault-utils-cli/ops@laptop"); err != nil {
+		t.Fatalf("failed to take action lock: %v", err)
+	}
+	lease, err := clientset.CoordinationV1().Leases("vault").Get(context.Background(), ActionLockName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get action lock: %v", err)
+	}
+	renewed := metav1.NewMicroTime(time.Now().Add(-2 * ActionLockDuration))
+	lease.Spec.RenewTime = &renewed
+	if _, err := clientset.CoordinationV1().Leases("vault").Update(context.Background(), lease, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to age action lock: %v", err)
+	}
+
+	lock, err := client.AcquireActionLock("vault", ActionInit, "vault-utils/vault-auto-unseal-0")
+	if err != nil {
+		t.Fatalf("expected an expired lock to be taken over, got %v", err)
+	}
+	defer lock.Release()
+
+	lease, err = clientset.CoordinationV1().Leases("vault").Get(context.Background(), ActionLockName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get action lock: %v", err)
+	}
+	if !strings.HasPrefix(*lease.Spec.HolderIdentity, "vault-utils/vault-auto-unseal-0#") || lease.Annotations[actionAnnotation] != ActionInit {
+		t.Errorf("expected the lock to name its new holder and action, got %s for %s", *lease.Spec.HolderIdentity, lease.Annotations[actionAnnotation])
+	}
+}
@@ -0,0 +1,88 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	// managedByLabel marks the objects the controller generates
+	managedByLabel = "app.kubernetes.io/managed-by"
+	// namespaceNameLabel is set on every namespace by Kubernetes, so a namespace can be selected
+	// by name
+	namespaceNameLabel = "kubernetes.io/metadata.name"
+)
+
+// VaultNetworkPolicy returns a NetworkPolicy admitting the controller pods, selected by
+// controllerSelector in controllerNamespace, to the Vault pods, selected by vaultSelector, on
+// port. It admits nothing else, so other traffic to Vault needs policies of its own.
+func VaultNetworkPolicy(name, namespace, vaultSelector, controllerNamespace, controllerSelector, port string) (*networkingv1.NetworkPolicy, error) {
+	vaultPods, err := metav1.ParseToLabelSelector(vaultSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Vault pod selector %q: %v", vaultSelector, err)
+	}
+	controllerPods, err := metav1.ParseToLabelSelector(controllerSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid controller pod selector %q: %v", controllerSelector, err)
+	}
+
+	peer := networkingv1.NetworkPolicyPeer{PodSelector: controllerPods}
+	if controllerNamespace != namespace {
+		peer.NamespaceSelector = &metav1.LabelSelector{
+			MatchLabels: map[string]string{namespaceNameLabel: controllerNamespace},
+		}
+	}
+
+	protocol := corev1.ProtocolTCP
+	vaultPort := intstr.Parse(port)
+
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{managedByLabel: eventComponent},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: *vaultPods,
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{{
+				From:  []networkingv1.NetworkPolicyPeer{peer},
+				Ports: []networkingv1.NetworkPolicyPort{{Protocol: &protocol, Port: &vaultPort}},
+			}},
+		},
+	}, nil
+}
+
+// CreateOrUpdateNetworkPolicy creates the NetworkPolicy, or replaces the spec and labels of
+// the existing one
+func (c *Client) CreateOrUpdateNetworkPolicy(policy *networkingv1.NetworkPolicy) error {
+	policies := c.clientset.NetworkingV1().NetworkPolicies(policy.Namespace)
+
+	existing, err := policies.Get(context.Background(), policy.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if _, err := policies.Create(context.Background(), policy, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create networkpolicy %s: %v", policy.Name, err)
+		}
+
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get networkpolicy %s: %v", policy.Name, err)
+	}
+
+	updated := existing.DeepCopy()
+	updated.Labels = policy.Labels
+	updated.Spec = policy.Spec
+
+	if _, err := policies.Update(context.Background(), updated, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update networkpolicy %s: %v", policy.Name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestVaultNetworkPolicy(t *testing.T) {
+	tests := []struct {
+		name                string
+		controllerNamespace string
+		port                string
+		expectNamespace     bool
+	}{
+		{name: "same namespace", controllerNamespace: "vault", port: "8200"},
+		{name: "other namespace", controllerNamespace: "ops", port: "8200", expectNamespace: true},
+		{name: "named port", controllerNamespace: "vault", port: "https"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := VaultNetworkPolicy("vault-utils", "vault", vaultPodSelector, tt.controllerNamespace, "app.kubernetes.io/name=vault-auto-unseal", tt.port)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if policy.Spec.PodSelector.MatchLabels["component"] != "server" {
+				t.Errorf("expected the Vault pods to be selected, got %v", policy.Spec.PodSelector)
+			}
+			if len(policy.Spec.Ingress) != 1 || len(policy.Spec.Ingress[0].From) != 1 || len(policy.Spec.Ingress[0].Ports) != 1 {
+				t.Fatalf("expected a single rule with a single peer and port, got %+v", policy.Spec.Ingress)
+			}
+
+			rule := policy.Spec.Ingress[0]
+			if rule.From[0].PodSelector.MatchLabels["app.kubernetes.io/name"] != "vault-auto-unseal" {
+				t.Errorf("expected the controller pods as peer, got %v", rule.From[0].PodSelector)
+			}
+			if (rule.From[0].NamespaceSelector != nil) != tt.expectNamespace {
+				t.Errorf("expected a namespace selector %v, got %v", tt.expectNamespace, rule.From[0].NamespaceSelector)
+			}
+			if tt.expectNamespace && rule.From[0].NamespaceSelector.MatchLabels[namespaceNameLabel] != tt.controllerNamespace {
+				t.Errorf("expected namespace %s to be selected, got %v", tt.controllerNamespace, rule.From[0].NamespaceSelector)
+			}
+			if rule.Ports[0].Port.String() != tt.port {
+				t.Errorf("expected port %s, got %s", tt.port, rule.Ports[0].Port.String())
+			}
+		})
+	}
+
+	if _, err := VaultNetworkPolicy("vault-utils", "vault", vaultPodSelector, "vault", "app in (", "8200"); err == nil {
+		t.Errorf("expected an invalid selector to be rejected")
+	}
+}
+
+func TestCreateOrUpdateNetworkPolicy(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	client := NewClientWithInterface(clientset)
+
+	policy, err := VaultNetworkPolicy("vault-utils", "vault", vaultPodSelector, "vault", "app=vault-utils", "8200")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CreateOrUpdateNetworkPolicy(policy); err != nil {
+		t.Fatalf("failed to create networkpolicy: %v", err)
+	}
+
+	policy, err = VaultNetworkPolicy("vault-utils", "vault", vaultPodSelector, "vault", "app=vault-utils", "8300")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CreateOrUpdateNetworkPolicy(policy); err != nil {
+		t.Fatalf("failed to update networkpolicy: %v", err)
+	}
+
+	stored, err := clientset.NetworkingV1().NetworkPolicies("vault").Get(context.Background(), "vault-utils", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the networkpolicy to exist: %v", err)
+	}
+	if stored.Spec.Ingress[0].Ports[0].Port.String() != "8300" {
+		t.Errorf("expected the port to be updated, got %s", stored.Spec.Ingress[0].Ports[0].Port.String())
+	}
+	if stored.Labels[managedByLabel] != eventComponent {
+		t.Errorf("expected the managed-by label, got %v", stored.Labels)
+	}
+}
@@ -0,0 +1,507 @@
+// Package metrics keeps the controller's counters, gauges and histograms and writes them in the Prometheus text
+// exposition format, so they can be scraped from /metrics without pulling in a client library.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default is the registry served on /metrics
+var Default = NewRegistry()
+
+// DefaultBuckets are the histogram bucket upper bounds, in seconds, suited to timing requests
+// that normally take milliseconds but can hang until a timeout
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds a set of metrics
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	// constLabels are added to every series of the registry, in this order
+	constLabels labelSet
+}
+
+// labelSet is a list of label names and their values
+type labelSet struct {
+	names  []string
+	values []string
+}
+
+// metric is a registered metric that can write itself in the text format, with constLabels
+// ahead of its own labels
+type metric interface {
+	metricName() string
+	write(b *strings.Builder, constLabels labelSet)
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// NewCounter registers a counter with the given label names on the default registry
+func NewCounter(name, help string, labelNames ...string) *Counter {
+	return Default.NewCounter(name, help, labelNames...)
+}
+
+// NewCounter registers a counter with the given label names
+func (r *Registry) NewCounter(name, help string, labelNames ...string) *Counter {
+	c := &Counter{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		values:     make(map[string]*sample),
+	}
+
+	r.register(c)
+
+	return c
+}
+
+// NewGauge registers a gauge with the given label names on the default registry
+func NewGauge(name, help string, labelNames ...string) *Gauge {
+	return Default.NewGauge(name, help, labelNames...)
+}
+
+// NewGauge registers a gauge with the given label names
+func (r *Registry) NewGauge(name, help string, labelNames ...string) *Gauge {
+	g := &Gauge{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		values:     make(map[string]*sample),
+	}
+
+	r.register(g)
+
+	return g
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds and label names on the
+// default registry
+func NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	return Default.NewHistogram(name, help, buckets, labelNames...)
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds and label names. The
+// buckets must be sorted in increasing order; the +Inf bucket is implied.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	h := &Histogram{
+		name:       name,
+		help:       help,
+		buckets:    append([]float64(nil), buckets...),
+		labelNames: labelNames,
+		values:     make(map[string]*histogramSample),
+	}
+
+	r.register(h)
+
+	return h
+}
+
+// SetConstLabels sets labels added to every series of the default registry
+func SetConstLabels(labels map[string]string) {
+	Default.SetConstLabels(labels)
+}
+
+// SetConstLabels sets labels added to every series of the registry, such as the cluster and
+// namespace the controller looks after, so alert rules and dashboards can tell environments
+// apart without relabeling. The labels are written in name order, ahead of each metric's own
+// labels, which must not use the same names.
+func (r *Registry) SetConstLabels(labels map[string]string) {
+	var set labelSet
+	for name := range labels {
+		set.names = append(set.names, name)
+	}
+	sort.Strings(set.names)
+	for _, name := range set.names {
+		set.values = append(set.values, labels[name])
+	}
+
+	r.mu.Lock()
+	r.constLabels = set
+	r.mu.Unlock()
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	r.metrics = append(r.metrics, m)
+	r.mu.Unlock()
+}
+
+// WriteTo writes every metric of the registry in the Prometheus text format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	registered := append([]metric(nil), r.metrics...)
+	constLabels := r.constLabels
+	r.mu.Unlock()
+
+	sort.Slice(registered, func(i, j int) bool { return registered[i].metricName() < registered[j].metricName() })
+
+	var b strings.Builder
+	for _, m := range registered {
+		m.write(&b, constLabels)
+	}
+
+	n, err := io.WriteString(w, b.String())
+
+	return int64(n), err
+}
+
+// Handler serves the registry in the Prometheus text format
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if _, err := r.WriteTo(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// Counter is a monotonically increasing value, tracked per combination of label values
+type Counter struct {
+	name       string
+	help       string
+	labelNames []string
+
+	mu     sync.Mutex
+	values map[string]*sample
+}
+
+type sample struct {
+	labelValues []string
+	value       float64
+}
+
+// Inc adds one to the counter for the given label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds delta to the counter for the given label values. Negative deltas are ignored since
+// counters only go up.
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		return
+	}
+	checkLabelValues(c.name, c.labelNames, labelValues)
+
+	key := strings.Join(labelValues, "\xff")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.values[key]
+	if !ok {
+		s = &sample{labelValues: append([]string(nil), labelValues...)}
+		c.values[key] = s
+	}
+	s.value += delta
+}
+
+// Value returns the current value of the counter for the given label values
+func (c *Counter) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if s, ok := c.values[strings.Join(labelValues, "\xff")]; ok {
+		return s.value
+	}
+
+	return 0
+}
+
+func (c *Counter) metricName() string {
+	return c.name
+}
+
+func (c *Counter) write(b *strings.Builder, constLabels labelSet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeSamples(b, c.name, c.help, "counter", constLabels, c.labelNames, c.values)
+}
+
+// Gauge is a value that can go up and down, tracked per combination of label values
+type Gauge struct {
+	name       string
+	help       string
+	labelNames []string
+
+	mu     sync.Mutex
+	values map[string]*sample
+}
+
+// Set sets the gauge for the given label values
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	checkLabelValues(g.name, g.labelNames, labelValues)
+
+	key := strings.Join(labelValues, "\xff")
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	s, ok := g.values[key]
+	if !ok {
+		s = &sample{labelValues: append([]string(nil), labelValues...)}
+		g.values[key] = s
+	}
+	s.value = value
+}
+
+// Replace sets the gauge for the given label values and drops the other series with the same
+// value for label, so a series whose other labels changed, such as the version of a pod, does
+// not linger next to the current one
+func (g *Gauge) Replace(label string, value float64, labelValues ...string) {
+	checkLabelValues(g.name, g.labelNames, labelValues)
+	index := labelIndex(g.name, g.labelNames, label)
+
+	key := strings.Join(labelValues, "\xff")
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for other, s := range g.values {
+		if other != key && s.labelValues[index] == labelValues[index] {
+			delete(g.values, other)
+		}
+	}
+
+	s, ok := g.values[key]
+	if !ok {
+		s = &sample{labelValues: append([]string(nil), labelValues...)}
+		g.values[key] = s
+	}
+	s.value = value
+}
+
+// Value returns the current value of the gauge for the given label values
+func (g *Gauge) Value(labelValues ...string) float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if s, ok := g.values[strings.Join(labelValues, "\xff")]; ok {
+		return s.value
+	}
+
+	return 0
+}
+
+// Retain drops every series whose value for the given label is not in values, so series of pods
+// that went away stop being exported
+func (g *Gauge) Retain(label string, values []string) {
+	index := labelIndex(g.name, g.labelNames, label)
+
+	keep := make(map[string]bool, len(values))
+	for _, value := range values {
+		keep[value] = true
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for key, s := range g.values {
+		if !keep[s.labelValues[index]] {
+			delete(g.values, key)
+		}
+	}
+}
+
+func (g *Gauge) metricName() string {
+	return g.name
+}
+
+func (g *Gauge) write(b *strings.Builder, constLabels labelSet) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	writeSamples(b, g.name, g.help, "gauge", constLabels, g.labelNames, g.values)
+}
+
+// writeSamples writes a metric holding one value per combination of label values
+func writeSamples(b *strings.Builder, name, help, kind string, constLabels labelSet, labelNames []string, values map[string]*sample) {
+	fmt.Fprintf(b, "# HELP %s %s\n", name, escapeHelp(help))
+	fmt.Fprintf(b, "# TYPE %s %s\n", name, kind)
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := values[key]
+		fmt.Fprintf(b, "%s%s %s\n", name, formatLabels(constLabels, labelNames, s.labelValues), formatValue(s.value))
+	}
+}
+
+// Histogram counts observations into buckets, tracked per combination of label values
+type Histogram struct {
+	name       string
+	help       string
+	buckets    []float64
+	labelNames []string
+
+	mu     sync.Mutex
+	values map[string]*histogramSample
+}
+
+type histogramSample struct {
+	labelValues []string
+	// counts holds the number of observations per bucket, not cumulated
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Observe records a single value for the given label values
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	checkLabelValues(h.name, h.labelNames, labelValues)
+
+	key := strings.Join(labelValues, "\xff")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.values[key]
+	if !ok {
+		s = &histogramSample{
+			labelValues: append([]string(nil), labelValues...),
+			counts:      make([]uint64, len(h.buckets)),
+		}
+		h.values[key] = s
+	}
+
+	for i, upper := range h.buckets {
+		if value <= upper {
+			s.counts[i]++
+			break
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+// Count returns the number of observations for the given label values
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if s, ok := h.values[strings.Join(labelValues, "\xff")]; ok {
+		return s.count
+	}
+
+	return 0
+}
+
+// Retain drops every series whose value for the given label is not in values, so series of pods
+// that went away stop being exported
+func (h *Histogram) Retain(label string, values []string) {
+	index := labelIndex(h.name, h.labelNames, label)
+
+	keep := make(map[string]bool, len(values))
+	for _, value := range values {
+		keep[value] = true
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for key, s := range h.values {
+		if !keep[s.labelValues[index]] {
+			delete(h.values, key)
+		}
+	}
+}
+
+func (h *Histogram) metricName() string {
+	return h.name
+}
+
+func (h *Histogram) write(b *strings.Builder, constLabels labelSet) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n", h.name, escapeHelp(h.help))
+	fmt.Fprintf(b, "# TYPE %s histogram\n", h.name)
+
+	keys := make([]string, 0, len(h.values))
+	for key := range h.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	bucketLabels := append(append([]string(nil), h.labelNames...), "le")
+	for _, key := range keys {
+		s := h.values[key]
+
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(b, "%s_bucket%s %d\n", h.name,
+				formatLabels(constLabels, bucketLabels, append(append([]string(nil), s.labelValues...), formatValue(upper))), cumulative)
+		}
+		fmt.Fprintf(b, "%s_bucket%s %d\n", h.name,
+			formatLabels(constLabels, bucketLabels, append(append([]string(nil), s.labelValues...), "+Inf")), s.count)
+
+		labels := formatLabels(constLabels, h.labelNames, s.labelValues)
+		fmt.Fprintf(b, "%s_sum%s %s\n", h.name, labels, formatValue(s.sum))
+		fmt.Fprintf(b, "%s_count%s %d\n", h.name, labels, s.count)
+	}
+}
+
+// labelIndex returns the position of label among the labels of metric name
+func labelIndex(name string, labelNames []string, label string) int {
+	for i, labelName := range labelNames {
+		if labelName == label {
+			return i
+		}
+	}
+
+	panic(fmt.Sprintf("metric %s has no label %s", name, label))
+}
+
+func checkLabelValues(name string, labelNames, labelValues []string) {
+	if len(labelValues) != len(labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", name, len(labelNames), len(labelValues)))
+	}
+}
+
+func formatLabels(constLabels labelSet, names, values []string) string {
+	if len(constLabels.names)+len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(constLabels.names)+len(names))
+	for i, name := range constLabels.names {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", name, escapeLabelValue(constLabels.values[i])))
+	}
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", name, escapeLabelValue(values[i])))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelValueEscaper.Replace(s)
+}
@@ -0,0 +1,208 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCounterExposition(t *testing.T) {
+	r := NewRegistry()
+	panics := r.NewCounter("test_panics_total", "Recovered panics.", "component")
+	passes := r.NewCounter("test_passes_total", "Reconcile passes.")
+
+	panics.Inc("server")
+	panics.Inc("controller")
+	panics.Add(2, "controller")
+	panics.Add(-1, "controller")
+	passes.Inc()
+	r.NewCounter("test_quoted_total", "Quoted.", "reason").Inc("say \"hi\"\n")
+
+	if got := panics.Value("controller"); got != 3 {
+		t.Errorf("expected controller panics to be 3, got %v", got)
+	}
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	expected := strings.Join([]string{
+		"# HELP test_panics_total Recovered panics.",
+		"# TYPE test_panics_total counter",
+		`test_panics_total{component="controller"} 3`,
+		`test_panics_total{component="server"} 1`,
+		"# HELP test_passes_total Reconcile passes.",
+		"# TYPE test_passes_total counter",
+		"test_passes_total 1",
+		"# HELP test_quoted_total Quoted.",
+		"# TYPE test_quoted_total counter",
+		`test_quoted_total{reason="say \"hi\"\n"} 1`,
+		"",
+	}, "\n")
+
+	if rec.Body.String() != expected {
+		t.Errorf("unexpected exposition:\n%s\nexpected:\n%s", rec.Body.String(), expected)
+	}
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+}
+
+func TestCounterLabelMismatch(t *testing.T) {
+	c := NewRegistry().NewCounter("test_total", "Test.", "a", "b")
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a panic for a wrong number of label values")
+		}
+	}()
+
+	c.Inc("only-one")
+}
+
+func TestHistogramExposition(t *testing.T) {
+	r := NewRegistry()
+	latency := r.NewHistogram("test_duration_seconds", "Request latency.", []float64{0.1, 1}, "pod")
+
+	latency.Observe(0.05, "10.0.0.1")
+	latency.Observe(0.5, "10.0.0.1")
+	latency.Observe(3, "10.0.0.1")
+	latency.Observe(0.1, "10.0.0.2")
+
+	if got := latency.Count("10.0.0.1"); got != 3 {
+		t.Errorf("expected 3 observations, got %d", got)
+	}
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	expected := strings.Join([]string{
+		"# HELP test_duration_seconds Request latency.",
+		"# TYPE test_duration_seconds histogram",
+		`test_duration_seconds_bucket{pod="10.0.0.1",le="0.1"} 1`,
+		`test_duration_seconds_bucket{pod="10.0.0.1",le="1"} 2`,
+		`test_duration_seconds_bucket{pod="10.0.0.1",le="+Inf"} 3`,
+		`test_duration_seconds_sum{pod="10.0.0.1"} 3.55`,
+		`test_duration_seconds_count{pod="10.0.0.1"} 3`,
+		`test_duration_seconds_bucket{pod="10.0.0.2",le="0.1"} 1`,
+		`test_duration_seconds_bucket{pod="10.0.0.2",le="1"} 1`,
+		`test_duration_seconds_bucket{pod="10.0.0.2",le="+Inf"} 1`,
+		`test_duration_seconds_sum{pod="10.0.0.2"} 0.1`,
+		`test_duration_seconds_count{pod="10.0.0.2"} 1`,
+		"",
+	}, "\n")
+
+	if rec.Body.String() != expected {
+		t.Errorf("unexpected exposition:\n%s\nexpected:\n%s", rec.Body.String(), expected)
+	}
+}
+
+func TestHistogramRetain(t *testing.T) {
+	h := NewRegistry().NewHistogram("test_duration_seconds", "Test.", DefaultBuckets, "pod", "result")
+
+	h.Observe(0.01, "10.0.0.1", "ok")
+	h.Observe(0.01, "10.0.0.2", "ok")
+	h.Observe(5, "10.0.0.2", "error")
+
+	h.Retain("pod", []string{"10.0.0.1"})
+
+	if h.Count("10.0.0.1", "ok") != 1 {
+		t.Errorf("expected the retained pod to keep its observations")
+	}
+	if h.Count("10.0.0.2", "ok") != 0 || h.Count("10.0.0.2", "error") != 0 {
+		t.Errorf("expected the series of the dropped pod to be removed")
+	}
+}
+
+func TestGaugeRetain(t *testing.T) {
+	g := NewRegistry().NewGauge("test_clock_skew_seconds", "Test.", "pod")
+
+	g.Set(2, "10.0.0.1")
+	g.Set(-3, "10.0.0.2")
+
+	g.Retain("pod", []string{"10.0.0.1"})
+
+	if g.Value("10.0.0.1") != 2 {
+		t.Errorf("expected the retained pod to keep its value")
+	}
+	var b strings.Builder
+	g.write(&b, labelSet{})
+	if strings.Contains(b.String(), "10.0.0.2") {
+		t.Errorf("expected the series of the dropped pod to be removed, got:\n%s", b.String())
+	}
+}
+
+func TestGaugeExposition(t *testing.T) {
+	r := NewRegistry()
+	fraction := r.NewGauge("test_unsealed_fraction", "Fraction of unsealed pods.", "namespace")
+
+	fraction.Set(1, "vault")
+	fraction.Set(0.5, "vault")
+	fraction.Set(0, "staging")
+
+	if got := fraction.Value("vault"); got != 0.5 {
+		t.Errorf("expected the last value to win, got %v", got)
+	}
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	expected := strings.Join([]string{
+		"# HELP test_unsealed_fraction Fraction of unsealed pods.",
+		"# TYPE test_unsealed_fraction gauge",
+		`test_unsealed_fraction{namespace="staging"} 0`,
+		`test_unsealed_fraction{namespace="vault"} 0.5`,
+		"",
+	}, "\n")
+
+	if rec.Body.String() != expected {
+		t.Errorf("unexpected exposition:\n%s\nexpected:\n%s", rec.Body.String(), expected)
+	}
+}
+
+func TestGaugeReplace(t *testing.T) {
+	g := NewRegistry().NewGauge("test_sealed", "Test.", "pod", "version")
+
+	g.Set(1, "10.0.0.1", "1.15.0")
+	g.Set(0, "10.0.0.2", "1.15.0")
+	g.Replace("pod", 0, "10.0.0.1", "1.16.0")
+
+	if g.Value("10.0.0.1", "1.16.0") != 0 || g.Value("10.0.0.2", "1.15.0") != 0 {
+		t.Errorf("expected the replaced and the other pod's series to be kept")
+	}
+	var b strings.Builder
+	g.write(&b, labelSet{})
+	if strings.Contains(b.String(), `pod="10.0.0.1",version="1.15.0"`) {
+		t.Errorf("expected the previous series of the pod to be removed, got:\n%s", b.String())
+	}
+}
+
+func TestConstLabels(t *testing.T) {
+	r := NewRegistry()
+	r.SetConstLabels(map[string]string{"namespace": "vault", "cluster": "prod"})
+	passes := r.NewCounter("test_passes_total", "Reconcile passes.")
+	latency := r.NewHistogram("test_duration_seconds", "Request latency.", []float64{1}, "pod")
+
+	passes.Inc()
+	latency.Observe(0.5, "10.0.0.1")
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	expected := strings.Join([]string{
+		"# HELP test_duration_seconds Request latency.",
+		"# TYPE test_duration_seconds histogram",
+		`test_duration_seconds_bucket{cluster="prod",namespace="vault",pod="10.0.0.1",le="1"} 1`,
+		`test_duration_seconds_bucket{cluster="prod",namespace="vault",pod="10.0.0.1",le="+Inf"} 1`,
+		`test_duration_seconds_sum{cluster="prod",namespace="vault",pod="10.0.0.1"} 0.5`,
+		`test_duration_seconds_count{cluster="prod",namespace="vault",pod="10.0.0.1"} 1`,
+		"# HELP test_passes_total Reconcile passes.",
+		"# TYPE test_passes_total counter",
+		`test_passes_total{cluster="prod",namespace="vault"} 1`,
+		"",
+	}, "\n")
+
+	if rec.Body.String() != expected {
+		t.Errorf("unexpected exposition:\n%s\nexpected:\n%s", rec.Body.String(), expected)
+	}
+}
@@ -0,0 +1,75 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const defaultExecTimeout = 30 * time.Second
+
+// Exec runs a command for every event with the event as JSON on its stdin, without a shell, so
+// alerts can be routed by a program in any language. A zero exit status means the event was
+// delivered; any other outcome is retried by a Queue.
+type Exec struct {
+	args    []string
+	timeout time.Duration
+}
+
+// NewExec creates a notifier running the command line, split on whitespace
+func NewExec(command string) (*Exec, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("missing command")
+	}
+
+	return &Exec{args: args, timeout: defaultExecTimeout}, nil
+}
+
+// Notify implements Notifier
+func (e *Exec) Notify(event Event) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, e.args[0], e.args[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("notification command failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+// Multi delivers every event to each of its notifiers and fails when any of them fails. Behind a
+// Queue a retry goes to all of them again, so the ones that succeeded may see the event twice.
+type Multi []Notifier
+
+// Notify implements Notifier
+func (m Multi) Notify(event Event) error {
+	var failed []string
+	for _, notifier := range m {
+		if err := notifier.Notify(event); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%s", strings.Join(failed, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,153 @@
+// Package notify sends operator notifications about events the controller cannot resolve on
+// its own
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/reason"
+)
+
+const defaultWebhookTimeout = 10 * time.Second
+
+// Event types
+const (
+	// EventPanic reports a recovered panic
+	EventPanic = "panic"
+	// EventInitialized reports that the controller initialized a Vault cluster
+	EventInitialized = "initialized"
+	// EventSealed reports that a Vault which was unsealed is sealed again, e.g. after a restart
+	// or a manual seal
+	EventSealed = "sealed"
+	// EventActiveSealed reports that the Vault which was the active node is sealed again, sent
+	// instead of EventSealed since it takes Vault down
+	EventActiveSealed = "active_sealed"
+	// EventUnsealed reports that the controller unsealed a Vault
+	EventUnsealed = "unsealed"
+	// EventIdentityMismatch reports a Vault that unsealed as a different cluster than the one
+	// its unseal keys belong to
+	EventIdentityMismatch = "identity_mismatch"
+	// EventForeignUnsealer reports signs of another unseal automation acting on the cluster
+	EventForeignUnsealer = "foreign_unsealer"
+	// EventShutdown reports the state the controller left things in when it stopped
+	EventShutdown = "shutdown"
+	// EventAdminAction reports a privileged action requested through the admin API
+	EventAdminAction = "admin_action"
+	// EventRootTokenRevoked reports that the controller revoked the root token stored at init
+	EventRootTokenRevoked = "root_token_revoked"
+	// EventColdStart reports the start and the outcome of a cold start
+	EventColdStart = "cold_start"
+	// EventSealMigrated reports that the controller migrated the seal of a Vault
+	EventSealMigrated = "seal_migrated"
+	// EventRaftPeerRemoved reports that the controller removed a raft peer no Vault pod matched
+	EventRaftPeerRemoved = "raft_peer_removed"
+)
+
+// Event is a single notification. Reason is the stable code of what happened, for automation
+// reacting to the event; Message is meant for people.
+type Event struct {
+	Type      string      `json:"type"`
+	Reason    reason.Code `json:"reason"`
+	Component string      `json:"component"`
+	Message   string      `json:"message"`
+	Time      time.Time   `json:"time"`
+	// Details holds structured data for the event type, if any
+	Details interface{} `json:"details,omitempty"`
+}
+
+// Notifier delivers events to operators
+type Notifier interface {
+	Notify(event Event) error
+}
+
+// New returns a queued notifier posting to url and running command, either of which may be
+// empty, which retries failed deliveries in the background. It returns a notifier that drops
+// every event when both are empty.
+func New(url, command string) (Notifier, error) {
+	var targets Multi
+	if url != "" {
+		targets = append(targets, NewWebhook(url))
+	}
+	if command != "" {
+		e, err := NewExec(command)
+		if err != nil {
+			return nil, fmt.Errorf("invalid notification command: %w", err)
+		}
+		targets = append(targets, e)
+	}
+
+	switch len(targets) {
+	case 0:
+		return Nop{}, nil
+	case 1:
+		return NewQueue(targets[0]), nil
+	}
+
+	return NewQueue(targets), nil
+}
+
+// Nop drops every event
+type Nop struct{}
+
+// Notify implements Notifier
+func (Nop) Notify(Event) error {
+	return nil
+}
+
+// Webhook posts events as JSON to a URL
+type Webhook struct {
+	url        string
+	httpClient *http.Client
+}
+
+// NewWebhook creates a notifier posting to url
+func NewWebhook(url string) *Webhook {
+	return &Webhook{
+		url:        url,
+		httpClient: &http.Client{Timeout: defaultWebhookTimeout},
+	}
+}
+
+// Notify implements Notifier
+func (w *Webhook) Notify(event Event) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	resp, err := w.httpClient.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to post event: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &StatusError{StatusCode: resp.StatusCode}
+	}
+
+	return nil
+}
+
+// StatusError is returned when the webhook answers with a non-2xx status
+type StatusError struct {
+	StatusCode int
+}
+
+// Error implements error
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("webhook returned status %d", e.StatusCode)
+}
+
+// Temporary reports whether retrying the delivery may succeed. Server errors and rate limiting
+// are temporary; any other status means the receiver rejected the event itself.
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
@@ -0,0 +1,127 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/getgrowly/vault-utils/pkg/reason"
+)
+
+func TestWebhookNotify(t *testing.T) {
+	var received Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("unexpected content type %q", ct)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("failed to decode event: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	err := NewWebhook(server.URL).Notify(Event{Type: EventPanic, Component: "controller", Message: "boom"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if received.Type != EventPanic || received.Component != "controller" || received.Message != "boom" {
+		t.Errorf("unexpected event received: %+v", received)
+	}
+	if received.Time.IsZero() {
+		t.Errorf("expected the event time to be set")
+	}
+}
+
+func TestWebhookNotifyError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	err := NewWebhook(server.URL).Notify(Event{Type: EventPanic})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || !statusErr.Temporary() {
+		t.Errorf("expected a temporary status error for a 502 response, got %v", err)
+	}
+}
+
+func TestNewWithoutURL(t *testing.T) {
+	if n, err := New("", ""); err != nil || n != (Nop{}) {
+		t.Errorf("expected a Nop notifier without a URL or command, got %v, %v", n, err)
+	}
+	if n, err := New("http://hooks.example.com", ""); err != nil || !isQueue(n) {
+		t.Errorf("expected a queued notifier with a URL, got %v, %v", n, err)
+	}
+	if n, err := New("http://hooks.example.com", "/usr/local/bin/route-alert"); err != nil || !isQueue(n) {
+		t.Errorf("expected a queued notifier with a URL and a command, got %v, %v", n, err)
+	}
+	if _, err := New("", "   "); err == nil {
+		t.Errorf("expected an error for a blank command")
+	}
+}
+
+func isQueue(n Notifier) bool {
+	queue, ok := n.(*Queue)
+	if ok {
+		queue.Close()
+	}
+
+	return ok
+}
+
+func TestExecNotify(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script")
+	}
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "event.json")
+	script := filepath.Join(dir, "route-alert.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\ncat > "+out+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := NewExec(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Notify(Event{Type: EventSealed, Reason: reason.Sealed, Message: "sealed"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var received Event
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &received); err != nil {
+		t.Fatalf("failed to decode event: %v", err)
+	}
+	if received.Type != EventSealed || received.Reason != reason.Sealed || received.Time.IsZero() {
+		t.Errorf("unexpected event received: %+v", received)
+	}
+
+	failing, _ := NewExec("/bin/sh -c false")
+	if err := failing.Notify(Event{Type: EventSealed}); err == nil {
+		t.Errorf("expected an error for a failing command")
+	}
+}
+
+func TestMulti(t *testing.T) {
+	failing := &flakyNotifier{failures: 1, err: errors.New("unreachable")}
+	working := &flakyNotifier{}
+
+	if err := (Multi{failing, working}).Notify(Event{Type: EventPanic}); err == nil || len(working.received) != 1 {
+		t.Errorf("expected a failure without skipping the other notifiers, got %d deliveries, %v", len(working.received), err)
+	}
+	if err := (Multi{failing, working}).Notify(Event{Type: EventPanic}); err != nil || len(failing.received) != 1 || len(working.received) != 2 {
+		t.Errorf("expected the event to reach every notifier, got %v", err)
+	}
+}
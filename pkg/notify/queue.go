@@ -0,0 +1,234 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	defaultQueueSize      = 100
+	defaultMaxAttempts    = 6
+	defaultInitialBackoff = time.Second
+	defaultMaxBackoff     = time.Minute
+	// maxDeadLetters bounds the dead letters kept for /status; older ones are only in the log
+	maxDeadLetters = 50
+)
+
+// DeadLetter is an event that could not be delivered
+type DeadLetter struct {
+	Event    Event     `json:"event"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// Stats describes the deliveries of a Queue
+type Stats struct {
+	Pending     int          `json:"pending"`
+	Delivered   int          `json:"delivered"`
+	DeadLetters []DeadLetter `json:"dead_letters"`
+}
+
+// Queue delivers events to another notifier in the background, in order, retrying failed
+// deliveries with exponential backoff. An event is only given up on after every attempt failed,
+// or at once when the receiver rejects it, and is then kept as a dead letter and logged in full
+// so it can be replayed by hand. Delivery is at least once: a receiver that processed an event
+// but failed to answer will see it again.
+type Queue struct {
+	next           Notifier
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+
+	events chan Event
+	done   chan struct{}
+	closed sync.Once
+	wg     sync.WaitGroup
+
+	mu          sync.Mutex
+	delivered   int
+	deadLetters []DeadLetter
+	// outstanding counts the events queued or being delivered, for Flush
+	outstanding int
+}
+
+// NewQueue creates a queue delivering to next and starts its delivery goroutine
+func NewQueue(next Notifier) *Queue {
+	return newQueue(next, defaultMaxAttempts, defaultInitialBackoff, defaultMaxBackoff)
+}
+
+func newQueue(next Notifier, maxAttempts int, initialBackoff, maxBackoff time.Duration) *Queue {
+	q := &Queue{
+		next:           next,
+		maxAttempts:    maxAttempts,
+		initialBackoff: initialBackoff,
+		maxBackoff:     maxBackoff,
+		events:         make(chan Event, defaultQueueSize),
+		done:           make(chan struct{}),
+	}
+
+	q.wg.Add(1)
+	go q.run()
+
+	return q
+}
+
+// Notify implements Notifier. The event is queued for delivery; it is dead-lettered at once
+// when the queue is full so the caller is never blocked by a slow receiver.
+func (q *Queue) Notify(event Event) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	q.mu.Lock()
+	q.outstanding++
+	q.mu.Unlock()
+
+	select {
+	case q.events <- event:
+	default:
+		q.finish()
+		q.deadLetter(event, 0, errors.New("notification queue is full"))
+	}
+
+	return nil
+}
+
+// Flush waits until every queued event has been delivered or given up on, or until ctx is done,
+// so events sent just before the process exits are not lost
+func (q *Queue) Flush(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		q.mu.Lock()
+		outstanding := q.outstanding
+		q.mu.Unlock()
+		if outstanding == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d notifications still pending: %w", outstanding, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// finish marks an outstanding event as delivered or given up on
+func (q *Queue) finish() {
+	q.mu.Lock()
+	q.outstanding--
+	q.mu.Unlock()
+}
+
+// Close stops delivery after the event in flight, if any. Events still queued are dead-lettered.
+func (q *Queue) Close() {
+	q.closed.Do(func() { close(q.done) })
+	q.wg.Wait()
+
+	for {
+		select {
+		case event := <-q.events:
+			q.finish()
+			q.deadLetter(event, 0, errors.New("notification queue closed"))
+		default:
+			return
+		}
+	}
+}
+
+// Stats returns the pending and delivered counts and the most recent dead letters
+func (q *Queue) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return Stats{
+		Pending:     len(q.events),
+		Delivered:   q.delivered,
+		DeadLetters: append([]DeadLetter{}, q.deadLetters...),
+	}
+}
+
+func (q *Queue) run() {
+	defer q.wg.Done()
+
+	for {
+		// Check for Close first, since a select picks randomly among ready cases
+		select {
+		case <-q.done:
+			return
+		default:
+		}
+
+		select {
+		case <-q.done:
+			return
+		case event := <-q.events:
+			q.deliver(event)
+			q.finish()
+		}
+	}
+}
+
+// deliver sends a single event, retrying temporary failures until the attempts run out
+func (q *Queue) deliver(event Event) {
+	backoff := q.initialBackoff
+
+	for attempt := 1; ; attempt++ {
+		err := q.next.Notify(event)
+		if err == nil {
+			q.mu.Lock()
+			q.delivered++
+			q.mu.Unlock()
+
+			return
+		}
+
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && !statusErr.Temporary() || attempt >= q.maxAttempts {
+			q.deadLetter(event, attempt, err)
+
+			return
+		}
+
+		log.Printf("Warning: delivering %s notification failed (attempt %d of %d), retrying in %v: %v",
+			event.Type, attempt, q.maxAttempts, backoff, err)
+
+		select {
+		case <-q.done:
+			q.deadLetter(event, attempt, err)
+
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > q.maxBackoff {
+			backoff = q.maxBackoff
+		}
+	}
+}
+
+func (q *Queue) deadLetter(event Event, attempts int, err error) {
+	log.Printf("Error: giving up on %s notification after %d attempts: %v; dead letter: type=%s reason=%s component=%s time=%s message=%q",
+		event.Type, attempts, err, event.Type, event.Reason, event.Component, event.Time.Format(time.RFC3339), event.Message)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.deadLetters = append(q.deadLetters, DeadLetter{
+		Event:    event,
+		Attempts: attempts,
+		Error:    err.Error(),
+		FailedAt: time.Now().UTC(),
+	})
+	if len(q.deadLetters) > maxDeadLetters {
+		q.deadLetters = q.deadLetters[len(q.deadLetters)-maxDeadLetters:]
+	}
+}
@@ -0,0 +1,225 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyNotifier fails the first failures deliveries with err
+type flakyNotifier struct {
+	mu       sync.Mutex
+	failures int
+	err      error
+	attempts int
+	received []Event
+}
+
+func (n *flakyNotifier) Notify(event Event) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.attempts++
+	if n.attempts <= n.failures {
+		return n.err
+	}
+	n.received = append(n.received, event)
+
+	return nil
+}
+
+func (n *flakyNotifier) Attempts() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.attempts
+}
+
+// waitFor polls cond until it holds or a second has passed
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestQueueDelivery(t *testing.T) {
+	tests := []struct {
+		name          string
+		failures      int
+		err           error
+		wantAttempts  int
+		wantDelivered int
+		wantDead      int
+	}{
+		{
+			name:          "delivered at once",
+			wantAttempts:  1,
+			wantDelivered: 1,
+		},
+		{
+			name:          "retried after transient failures",
+			failures:      2,
+			err:           errors.New("connection refused"),
+			wantAttempts:  3,
+			wantDelivered: 1,
+		},
+		{
+			name:          "retried after server errors",
+			failures:      1,
+			err:           &StatusError{StatusCode: 503},
+			wantAttempts:  2,
+			wantDelivered: 1,
+		},
+		{
+			name:         "dead-lettered after all attempts",
+			failures:     10,
+			err:          &StatusError{StatusCode: 502},
+			wantAttempts: 4,
+			wantDead:     1,
+		},
+		{
+			name:         "dead-lettered at once when rejected",
+			failures:     10,
+			err:          &StatusError{StatusCode: 400},
+			wantAttempts: 1,
+			wantDead:     1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := &flakyNotifier{failures: tt.failures, err: tt.err}
+			q := newQueue(next, 4, time.Millisecond, 2*time.Millisecond)
+			defer q.Close()
+
+			if err := q.Notify(Event{Type: EventInitialized, Message: "initialized"}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			waitFor(t, func() bool {
+				stats := q.Stats()
+				return stats.Delivered+len(stats.DeadLetters) == 1
+			})
+
+			stats := q.Stats()
+			if next.Attempts() != tt.wantAttempts {
+				t.Errorf("expected %d attempts, got %d", tt.wantAttempts, next.Attempts())
+			}
+			if stats.Delivered != tt.wantDelivered || len(stats.DeadLetters) != tt.wantDead {
+				t.Errorf("expected %d delivered and %d dead letters, got %+v", tt.wantDelivered, tt.wantDead, stats)
+			}
+			if tt.wantDead > 0 {
+				dead := stats.DeadLetters[0]
+				if dead.Event.Message != "initialized" || dead.Attempts != tt.wantAttempts || dead.Error != tt.err.Error() {
+					t.Errorf("unexpected dead letter %+v", dead)
+				}
+			}
+		})
+	}
+}
+
+func TestQueuePreservesOrder(t *testing.T) {
+	next := &flakyNotifier{failures: 1, err: errors.New("timeout")}
+	q := newQueue(next, 3, time.Millisecond, time.Millisecond)
+	defer q.Close()
+
+	for _, message := range []string{"first", "second", "third"} {
+		q.Notify(Event{Type: EventPanic, Message: message})
+	}
+
+	waitFor(t, func() bool { return q.Stats().Delivered == 3 })
+
+	next.mu.Lock()
+	defer next.mu.Unlock()
+	for i, message := range []string{"first", "second", "third"} {
+		if next.received[i].Message != message {
+			t.Errorf("expected event %d to be %q, got %q", i, message, next.received[i].Message)
+		}
+		if next.received[i].Time.IsZero() {
+			t.Errorf("expected the event time to be set when queued")
+		}
+	}
+}
+
+func TestQueueCloseDeadLettersPending(t *testing.T) {
+	block := make(chan struct{})
+	next := notifierFunc(func(Event) error {
+		<-block
+		return nil
+	})
+	q := newQueue(next, 1, time.Millisecond, time.Millisecond)
+
+	q.Notify(Event{Type: EventPanic, Message: "in flight"})
+	q.Notify(Event{Type: EventPanic, Message: "pending"})
+	waitFor(t, func() bool { return q.Stats().Pending == 1 })
+
+	// Close while the first event is in flight, then let it finish
+	closed := make(chan struct{})
+	go func() {
+		q.Close()
+		close(closed)
+	}()
+	waitFor(t, func() bool {
+		select {
+		case <-q.done:
+			return true
+		default:
+			return false
+		}
+	})
+	close(block)
+	<-closed
+
+	stats := q.Stats()
+	if stats.Delivered != 1 || len(stats.DeadLetters) != 1 || stats.DeadLetters[0].Event.Message != "pending" {
+		t.Errorf("expected the pending event to be dead-lettered on close, got %+v", stats)
+	}
+}
+
+type notifierFunc func(Event) error
+
+func (f notifierFunc) Notify(event Event) error {
+	return f(event)
+}
+
+func TestQueueFlush(t *testing.T) {
+	next := &flakyNotifier{failures: 2, err: errors.New("connection refused")}
+	q := newQueue(next, 4, 5*time.Millisecond, 10*time.Millisecond)
+	defer q.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := q.Notify(Event{Type: EventShutdown}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := q.Flush(ctx); err != nil {
+		t.Fatalf("unexpected flush error: %v", err)
+	}
+	if stats := q.Stats(); stats.Delivered != 3 || stats.Pending != 0 {
+		t.Errorf("expected every event delivered after a flush, got %+v", stats)
+	}
+
+	blocked := &flakyNotifier{failures: 100, err: errors.New("connection refused")}
+	slow := newQueue(blocked, 100, 10*time.Millisecond, 10*time.Millisecond)
+	defer slow.Close()
+	if err := slow.Notify(Event{Type: EventShutdown}); err != nil {
+		t.Fatal(err)
+	}
+
+	expired, cancelExpired := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelExpired()
+	if err := slow.Flush(expired); err == nil {
+		t.Errorf("expected a flush error while an event is still being retried")
+	}
+}
@@ -0,0 +1,149 @@
+// Package reason defines the stable, machine-readable codes attached to Events, notifications
+// and /status entries, so automation can react to what happened without parsing the English
+// messages next to them. Codes are part of the public interface: existing ones are never
+// renamed or reused for a different meaning.
+package reason
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Code is a stable reason code
+type Code string
+
+// Reason codes
+const (
+	// VaultUnreachable means the Vault listener of a pod could not be reached
+	VaultUnreachable Code = "VAULT_UNREACHABLE"
+	// VaultStatusFailed means Vault was reached but its status could not be read
+	VaultStatusFailed Code = "VAULT_STATUS_FAILED"
+	// VaultAgent means the Vault port of a pod is served by a Vault Agent or Proxy rather than
+	// the server, so the pod is left alone
+	VaultAgent Code = "VAULT_AGENT"
+
+	// InitNotAllowed means the cluster is uninitialized but INIT_ALLOWED is off
+	InitNotAllowed Code = "INIT_NOT_ALLOWED"
+	// InitDeferred means init was postponed because other pods could not be checked for
+	// existing cluster data
+	InitDeferred Code = "INIT_DEFERRED"
+	// InitWaitingForPods means init was postponed until INIT_WAIT_FOR_PODS Vault pods are
+	// Running
+	InitWaitingForPods Code = "INIT_WAITING_FOR_PODS"
+	// InitFailed means Vault rejected or failed the init request
+	InitFailed Code = "INIT_FAILED"
+	// InitStorageFailed means Vault was initialized but its root token or unseal keys could not
+	// be stored
+	InitStorageFailed Code = "INIT_STORAGE_FAILED"
+	// ActionLocked means another actor, such as a CLI run or a Job, held the cluster's action
+	// lock, so init was postponed
+	ActionLocked Code = "ACTION_LOCKED"
+	// Initialized means the controller initialized a Vault cluster
+	Initialized Code = "INITIALIZED"
+
+	// RaftJoinFailed means an uninitialized member of a cluster using integrated storage could
+	// not join the raft cluster, usually because no member is unsealed yet
+	RaftJoinFailed Code = "RAFT_JOIN_FAILED"
+	// UnsealKeysUnavailable means the unseal keys Secret could not be read
+	UnsealKeysUnavailable Code = "UNSEAL_KEYS_UNAVAILABLE"
+	// UnsealNoKeys means the unseal keys Secret holds no keys
+	UnsealNoKeys Code = "UNSEAL_NO_KEYS"
+	// UnsealKeysEncrypted means the stored unseal keys are encrypted to operators' PGP keys, so
+	// only an operator can unseal
+	UnsealKeysEncrypted Code = "UNSEAL_KEYS_ENCRYPTED"
+	// AutoUnsealPending means an auto-unseal Vault is sealed and waits for its seal, such as a
+	// KMS, to unseal it; unseal keys are never submitted to it
+	AutoUnsealPending Code = "AUTO_UNSEAL_PENDING"
+	// UnsealInvalidKey means Vault rejected an unseal key and stayed sealed
+	UnsealInvalidKey Code = "UNSEAL_INVALID_KEY"
+	// UnsealIncomplete means every key was accepted but Vault stayed sealed, usually because
+	// fewer keys are stored than the threshold
+	UnsealIncomplete Code = "UNSEAL_INCOMPLETE"
+
+	// Sealed means a Vault that was unsealed is sealed again
+	Sealed Code = "VAULT_SEALED"
+	// ActiveSealed means the pod that was the active node is sealed, which leaves Vault down
+	// until a standby takes over or the pod is unsealed
+	ActiveSealed Code = "ACTIVE_NODE_SEALED"
+	// Unsealed means the controller unsealed a Vault
+	Unsealed Code = "UNSEALED"
+
+	// HookFailed means a pre-init or pre-unseal hook failed, so the action was not taken
+	HookFailed Code = "HOOK_FAILED"
+
+	// IdentityMismatch means a Vault reported a different cluster identity than the one its
+	// unseal keys belong to
+	IdentityMismatch Code = "IDENTITY_MISMATCH"
+
+	// ForeignUnsealer means another unseal automation appears to act on the same cluster
+	ForeignUnsealer Code = "FOREIGN_UNSEALER"
+
+	// Panic means a panic was recovered
+	Panic Code = "PANIC"
+
+	// Shutdown means the controller stopped
+	Shutdown Code = "SHUTDOWN"
+
+	// AdminAction means a privileged action was performed, or refused by Vault, through the
+	// admin API
+	AdminAction Code = "ADMIN_ACTION"
+	// RootTokenRevoked means the controller revoked the root token stored at init, as
+	// REVOKE_ROOT_TOKEN asks
+	RootTokenRevoked Code = "ROOT_TOKEN_REVOKED"
+
+	// ColdStartWaiting means a cold start leaves the pod sealed until a quorum of pods answers
+	ColdStartWaiting Code = "COLD_START_WAITING"
+	// ColdStartStarted means every pod was found sealed and a cold start began
+	ColdStartStarted Code = "COLD_START_STARTED"
+	// ColdStartCompleted means a cold start passed its checks, and ColdStartIncomplete that it
+	// ran out of time before
+	ColdStartCompleted  Code = "COLD_START_COMPLETED"
+	ColdStartIncomplete Code = "COLD_START_INCOMPLETE"
+
+	// SealMigrationPending means the pod waits for its seal to be migrated, which neither
+	// SEAL_MIGRATION nor a request through the admin API allowed
+	SealMigrationPending Code = "SEAL_MIGRATION_PENDING"
+	// SealMigrationFailed means submitting the keys with the migrate flag failed
+	SealMigrationFailed Code = "SEAL_MIGRATION_FAILED"
+	// SealMigrated means the controller migrated the seal of a pod
+	SealMigrated Code = "SEAL_MIGRATED"
+
+	// RaftPeerRemoved means the controller removed a raft peer no Vault pod matched for
+	// RAFT_DEAD_PEER_GRACE, as RAFT_REMOVE_DEAD_PEERS asks
+	RaftPeerRemoved Code = "RAFT_PEER_REMOVED"
+)
+
+// Annotation carries the reason code on the Kubernetes Events the controller records, whose
+// own reason field follows the Kubernetes CamelCase convention
+const Annotation = "vault-utils.growly.io/reason"
+
+// Error is an error carrying a reason code
+type Error struct {
+	Code Code
+	Err  error
+}
+
+// Error implements error
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Errorf formats an error carrying the given code
+func Errorf(code Code, format string, args ...interface{}) error {
+	return &Error{Code: code, Err: fmt.Errorf(format, args...)}
+}
+
+// Of returns the code carried by err, or fallback when it carries none
+func Of(err error, fallback Code) Code {
+	var coded *Error
+	if errors.As(err, &coded) {
+		return coded.Code
+	}
+
+	return fallback
+}
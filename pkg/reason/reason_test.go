@@ -0,0 +1,61 @@
+package reason
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestOf(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		fallback Code
+		want     Code
+	}{
+		{
+			name:     "coded error",
+			err:      Errorf(UnsealNoKeys, "no unseal keys found in secret"),
+			fallback: UnsealIncomplete,
+			want:     UnsealNoKeys,
+		},
+		{
+			name:     "wrapped coded error",
+			err:      fmt.Errorf("error unsealing Vault: %w", Errorf(UnsealInvalidKey, "key rejected")),
+			fallback: UnsealIncomplete,
+			want:     UnsealInvalidKey,
+		},
+		{
+			name:     "plain error",
+			err:      errors.New("boom"),
+			fallback: InitFailed,
+			want:     InitFailed,
+		},
+		{
+			name:     "nil error",
+			err:      nil,
+			fallback: InitFailed,
+			want:     InitFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Of(tt.err, tt.fallback); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestErrorf(t *testing.T) {
+	inner := errors.New("connection refused")
+	err := Errorf(VaultUnreachable, "failed to check status: %w", inner)
+
+	if err.Error() != "failed to check status: connection refused" {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+	if !errors.Is(err, inner) {
+		t.Errorf("expected the underlying error to be preserved")
+	}
+}
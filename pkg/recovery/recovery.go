@@ -0,0 +1,67 @@
+// Package recovery turns panics into log entries, a metric and a notification so a single
+// malformed response cannot take the whole controller down
+package recovery
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/getgrowly/vault-utils/pkg/metrics"
+	"github.com/getgrowly/vault-utils/pkg/notify"
+	"github.com/getgrowly/vault-utils/pkg/reason"
+)
+
+var panicsTotal = metrics.NewCounter("vault_utils_panics_total",
+	"Panics recovered by the controller, by component.", "component")
+
+// Handle reports a value returned by recover. The stack trace is logged quoted on a single
+// line so container log collectors keep it in one entry.
+func Handle(component string, recovered interface{}, notifier notify.Notifier) {
+	if recovered == nil {
+		return
+	}
+
+	log.Printf("Recovered panic in %s: %v stack=%q", component, recovered, debug.Stack())
+	panicsTotal.Inc(component)
+
+	if notifier == nil {
+		return
+	}
+
+	event := notify.Event{
+		Type:      notify.EventPanic,
+		Reason:    reason.Panic,
+		Component: component,
+		Message:   fmt.Sprintf("recovered panic: %v", recovered),
+	}
+	if err := notifier.Notify(event); err != nil {
+		log.Printf("Error sending panic notification: %v", err)
+	}
+}
+
+// Middleware recovers panics in next, reports them with Handle and answers the request with
+// a 500 instead of dropping the connection
+func Middleware(component string, notifier notify.Notifier, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				// http.ErrAbortHandler is the sanctioned way to abort a response, let it through
+				if recovered == http.ErrAbortHandler {
+					panic(recovered)
+				}
+
+				Handle(component, recovered, notifier)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Panics returns the number of panics recovered in component
+func Panics(component string) float64 {
+	return panicsTotal.Value(component)
+}
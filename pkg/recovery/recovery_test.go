@@ -0,0 +1,72 @@
+package recovery
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getgrowly/vault-utils/pkg/notify"
+	"github.com/getgrowly/vault-utils/pkg/reason"
+)
+
+type recordingNotifier struct {
+	events []notify.Event
+}
+
+func (n *recordingNotifier) Notify(event notify.Event) error {
+	n.events = append(n.events, event)
+	return nil
+}
+
+func TestHandle(t *testing.T) {
+	notifier := &recordingNotifier{}
+	before := Panics("test-handle")
+
+	func() {
+		defer func() {
+			Handle("test-handle", recover(), notifier)
+		}()
+		panic("malformed response")
+	}()
+
+	if got := Panics("test-handle") - before; got != 1 {
+		t.Errorf("expected one panic to be counted, got %v", got)
+	}
+	if len(notifier.events) != 1 || notifier.events[0].Type != notify.EventPanic || notifier.events[0].Reason != reason.Panic {
+		t.Fatalf("expected one panic notification, got %+v", notifier.events)
+	}
+	if notifier.events[0].Message != "recovered panic: malformed response" {
+		t.Errorf("unexpected notification message %q", notifier.events[0].Message)
+	}
+
+	// Nothing is reported when there was no panic
+	Handle("test-handle", nil, notifier)
+	if len(notifier.events) != 1 {
+		t.Errorf("expected no notification without a panic")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	notifier := &recordingNotifier{}
+	before := Panics("test-middleware")
+
+	handler := Middleware("test-middleware", notifier, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var status *struct{ Sealed bool }
+		if status.Sealed {
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected status %d, got %d", http.StatusInternalServerError, rec.Code)
+	}
+	if got := Panics("test-middleware") - before; got != 1 {
+		t.Errorf("expected one panic to be counted, got %v", got)
+	}
+	if len(notifier.events) != 1 {
+		t.Errorf("expected one notification, got %d", len(notifier.events))
+	}
+}
@@ -0,0 +1,180 @@
+// Package render turns templates into the Secrets and ConfigMaps that workloads depending on
+// Vault consume, such as VAULT_ADDR and a CA bundle for applications or a vault-agent config.
+// Each template renders to one or more YAML or JSON manifests separated by "---".
+package render
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// Data is what templates can reference
+type Data struct {
+	// Namespace is the namespace Vault runs in
+	Namespace string
+	// VaultAddr is the in-cluster address of the Vault service
+	VaultAddr string
+	// CACert is the PEM encoded CA bundle for VaultAddr, empty when Vault is served without TLS
+	CACert string
+	// ClusterID and ClusterName identify the Vault cluster
+	ClusterID   string
+	ClusterName string
+}
+
+// Object is a rendered Secret or ConfigMap; exactly one of the two is set
+type Object struct {
+	Secret    *corev1.Secret
+	ConfigMap *corev1.ConfigMap
+}
+
+// Key identifies the object as kind/namespace/name
+func (o Object) Key() string {
+	if o.Secret != nil {
+		return "Secret/" + o.Secret.Namespace + "/" + o.Secret.Name
+	}
+
+	return "ConfigMap/" + o.ConfigMap.Namespace + "/" + o.ConfigMap.Name
+}
+
+var funcs = template.FuncMap{
+	"b64enc": func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"indent": func(spaces int, s string) string {
+		pad := strings.Repeat(" ", spaces)
+		return pad + strings.ReplaceAll(strings.TrimRight(s, "\n"), "\n", "\n"+pad)
+	},
+}
+
+// Renderer holds the parsed templates of a directory
+type Renderer struct {
+	templates []*template.Template
+}
+
+// Load parses every .yaml, .yml and .json file of dir as a template, so a broken template is
+// reported at startup rather than after the first unseal
+func Load(dir string) (*Renderer, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read templates directory: %w", err)
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		switch filepath.Ext(entry.Name()) {
+		case ".yaml", ".yml", ".json":
+			// Mounted ConfigMaps expose every key through a symlink, so regular files and
+			// symlinks are both accepted
+			if !entry.IsDir() {
+				names = append(names, entry.Name())
+			}
+		}
+	}
+	sort.Strings(names)
+
+	r := &Renderer{}
+	for _, name := range names {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template %s: %w", name, err)
+		}
+
+		tmpl, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse template %s: %w", name, err)
+		}
+		r.templates = append(r.templates, tmpl)
+	}
+
+	return r, nil
+}
+
+// Render executes every template with data and decodes the manifests it produces. Objects
+// without a namespace are placed in data.Namespace.
+func (r *Renderer) Render(data Data) ([]Object, error) {
+	var objects []Object
+	for _, tmpl := range r.templates {
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, data); err != nil {
+			return nil, fmt.Errorf("failed to render template %s: %w", tmpl.Name(), err)
+		}
+
+		rendered, err := decode(&out, data.Namespace)
+		if err != nil {
+			return nil, fmt.Errorf("template %s: %w", tmpl.Name(), err)
+		}
+		objects = append(objects, rendered...)
+	}
+
+	return objects, nil
+}
+
+// decode reads the YAML or JSON manifests of r
+func decode(r io.Reader, namespace string) ([]Object, error) {
+	decoder := yaml.NewYAMLOrJSONDecoder(r, 4096)
+
+	var objects []Object
+	for {
+		var raw map[string]interface{}
+		if err := decoder.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				return objects, nil
+			}
+
+			return nil, fmt.Errorf("failed to decode manifest: %w", err)
+		}
+		if len(raw) == 0 {
+			continue
+		}
+
+		manifest, err := json.Marshal(raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode manifest: %w", err)
+		}
+
+		var object Object
+		switch kind := raw["kind"]; kind {
+		case "Secret":
+			object.Secret = &corev1.Secret{}
+			err = json.Unmarshal(manifest, object.Secret)
+			if err == nil {
+				err = complete(&object.Secret.ObjectMeta.Name, &object.Secret.ObjectMeta.Namespace, namespace)
+			}
+		case "ConfigMap":
+			object.ConfigMap = &corev1.ConfigMap{}
+			err = json.Unmarshal(manifest, object.ConfigMap)
+			if err == nil {
+				err = complete(&object.ConfigMap.ObjectMeta.Name, &object.ConfigMap.ObjectMeta.Namespace, namespace)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported kind %v, only Secret and ConfigMap can be rendered", kind)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s manifest: %w", raw["kind"], err)
+		}
+
+		objects = append(objects, object)
+	}
+}
+
+// complete checks that a manifest is named and defaults its namespace
+func complete(name, namespace *string, defaultNamespace string) error {
+	if *name == "" {
+		return fmt.Errorf("missing metadata.name")
+	}
+	if *namespace == "" {
+		*namespace = defaultNamespace
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package render
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const connectionTemplate = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: vault-connection
+data:
+  VAULT_ADDR: {{ .VaultAddr }}
+  ca.crt: |
+{{ indent 4 .CACert }}
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: vault-agent-config
+  namespace: apps
+data:
+  cluster: {{ b64enc .ClusterName }}
+`
+
+func writeTemplates(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestRender(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"connection.yaml": connectionTemplate,
+		"README.md":       "not a template {{",
+	})
+
+	r, err := Load(dir)
+	if err != nil {
+		t.Fatalf("failed to load templates: %v", err)
+	}
+
+	objects, err := r.Render(Data{
+		Namespace:   "vault",
+		VaultAddr:   "https://vault.vault.svc:8200",
+		CACert:      "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n",
+		ClusterName: "vault-cluster-1",
+	})
+	if err != nil {
+		t.Fatalf("failed to render: %v", err)
+	}
+
+	if len(objects) != 2 {
+		t.Fatalf("expected two objects, got %d", len(objects))
+	}
+
+	cm := objects[0].ConfigMap
+	if objects[0].Key() != "ConfigMap/vault/vault-connection" {
+		t.Errorf("expected the config map to default to the Vault namespace, got %s", objects[0].Key())
+	}
+	if cm.Data["VAULT_ADDR"] != "https://vault.vault.svc:8200" {
+		t.Errorf("unexpected VAULT_ADDR %q", cm.Data["VAULT_ADDR"])
+	}
+	if !strings.HasPrefix(cm.Data["ca.crt"], "-----BEGIN CERTIFICATE-----\nMIIB\n") {
+		t.Errorf("unexpected CA bundle %q", cm.Data["ca.crt"])
+	}
+
+	if objects[1].Key() != "Secret/apps/vault-agent-config" {
+		t.Errorf("unexpected secret %s", objects[1].Key())
+	}
+	if string(objects[1].Secret.Data["cluster"]) != "vault-cluster-1" {
+		t.Errorf("unexpected secret data %q", objects[1].Secret.Data["cluster"])
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		tmpl    string
+		loadErr bool
+		wantErr string
+	}{
+		{
+			name:    "broken template",
+			tmpl:    "kind: ConfigMap\nmetadata:\n  name: {{ .VaultAddr",
+			loadErr: true,
+		},
+		{
+			name:    "unknown field",
+			tmpl:    "kind: ConfigMap\nmetadata:\n  name: {{ .Token }}\n",
+			wantErr: "Token",
+		},
+		{
+			name:    "unsupported kind",
+			tmpl:    "kind: Deployment\nmetadata:\n  name: app\n",
+			wantErr: "unsupported kind Deployment",
+		},
+		{
+			name:    "missing name",
+			tmpl:    "kind: ConfigMap\ndata:\n  a: b\n",
+			wantErr: "missing metadata.name",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := Load(writeTemplates(t, map[string]string{"out.yaml": tt.tmpl}))
+			if tt.loadErr {
+				if err == nil {
+					t.Errorf("expected a load error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to load templates: %v", err)
+			}
+
+			_, err = r.Render(Data{Namespace: "vault"})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
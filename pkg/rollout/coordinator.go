@@ -0,0 +1,136 @@
+package rollout
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/getgrowly/vault-utils/pkg/kubernetes"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+const (
+	// SafeAnnotation is set on the Vault StatefulSet to tell external tooling whether every
+	// member is unsealed and rejoined, i.e. whether the next pod may be restarted
+	SafeAnnotation = "vault-utils.growly.io/rollout-safe"
+
+	revisionLabel = "controller-revision-hash"
+)
+
+// PodReadyFunc reports whether the Vault pod at the given address is unsealed and has rejoined the cluster
+type PodReadyFunc func(ctx context.Context, podIP string) (bool, error)
+
+// Coordinator paces rolling updates of the Vault StatefulSet so that a pod is only restarted
+// once every other member has been unsealed and rejoined the cluster
+type Coordinator struct {
+	k8sClient *kubernetes.Client
+	namespace string
+	name      string
+	podReady  PodReadyFunc
+}
+
+// NewCoordinator creates a new rollout coordinator for the named StatefulSet
+func NewCoordinator(k8sClient *kubernetes.Client, namespace, name string, podReady PodReadyFunc) *Coordinator {
+	return &Coordinator{
+		k8sClient: k8sClient,
+		namespace: namespace,
+		name:      name,
+		podReady:  podReady,
+	}
+}
+
+// Step inspects the StatefulSet once. It records whether the rollout may continue in the
+// SafeAnnotation and, for RollingUpdate StatefulSets with a partition, lowers the partition
+// by one as soon as all updated pods are unsealed and rejoined.
+func (c *Coordinator) Step(ctx context.Context) error {
+	statefulSet, err := c.k8sClient.GetStatefulSet(c.namespace, c.name)
+	if err != nil {
+		return err
+	}
+
+	safe, updated := c.inspectPods(ctx, statefulSet)
+	changed := false
+
+	if statefulSet.Annotations[SafeAnnotation] != strconv.FormatBool(safe) {
+		if statefulSet.Annotations == nil {
+			statefulSet.Annotations = make(map[string]string)
+		}
+		statefulSet.Annotations[SafeAnnotation] = strconv.FormatBool(safe)
+		changed = true
+	}
+
+	partition := currentPartition(statefulSet)
+	if safe && partition > 0 && updated {
+		partition--
+		statefulSet.Spec.UpdateStrategy.RollingUpdate.Partition = &partition
+		changed = true
+		log.Printf("All Vault pods unsealed and rejoined, continuing rollout of %s with partition %d", c.name, partition)
+	}
+
+	if !changed {
+		return nil
+	}
+
+	return c.k8sClient.UpdateStatefulSet(statefulSet)
+}
+
+// inspectPods checks every member of the StatefulSet. It returns whether all of them are
+// unsealed and rejoined, and whether every pod at or above the partition already runs the
+// update revision.
+func (c *Coordinator) inspectPods(ctx context.Context, statefulSet *appsv1.StatefulSet) (bool, bool) {
+	replicas := int32(1)
+	if statefulSet.Spec.Replicas != nil {
+		replicas = *statefulSet.Spec.Replicas
+	}
+	partition := currentPartition(statefulSet)
+
+	safe := true
+	updated := true
+
+	for ordinal := int32(0); ordinal < replicas; ordinal++ {
+		podName := fmt.Sprintf("%s-%d", statefulSet.Name, ordinal)
+
+		pod, err := c.k8sClient.GetPod(c.namespace, podName)
+		if err != nil {
+			log.Printf("Rollout of %s waiting for pod %s: %v", c.name, podName, err)
+			return false, false
+		}
+
+		if ordinal >= partition && pod.Labels[revisionLabel] != statefulSet.Status.UpdateRevision {
+			updated = false
+		}
+
+		if pod.Status.PodIP == "" {
+			log.Printf("Rollout of %s waiting for pod %s to get an address", c.name, podName)
+			safe = false
+			continue
+		}
+
+		ready, err := c.podReady(ctx, pod.Status.PodIP)
+		if err != nil {
+			log.Printf("Rollout of %s waiting for pod %s: %v", c.name, podName, err)
+			safe = false
+			continue
+		}
+
+		if !ready {
+			log.Printf("Rollout of %s waiting for pod %s to be unsealed and rejoined", c.name, podName)
+			safe = false
+		}
+	}
+
+	return safe, updated
+}
+
+// currentPartition returns the RollingUpdate partition of the StatefulSet, or zero when it
+// does not use a partitioned rolling update
+func currentPartition(statefulSet *appsv1.StatefulSet) int32 {
+	rollingUpdate := statefulSet.Spec.UpdateStrategy.RollingUpdate
+	if statefulSet.Spec.UpdateStrategy.Type != appsv1.RollingUpdateStatefulSetStrategyType ||
+		rollingUpdate == nil || rollingUpdate.Partition == nil {
+		return 0
+	}
+
+	return *rollingUpdate.Partition
+}
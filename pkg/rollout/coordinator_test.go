@@ -0,0 +1,164 @@
+package rollout
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/getgrowly/vault-utils/pkg/kubernetes"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newStatefulSet(replicas, partition int32) *appsv1.StatefulSet {
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "vault",
+			Namespace: "vault",
+		},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas: &replicas,
+			UpdateStrategy: appsv1.StatefulSetUpdateStrategy{
+				Type: appsv1.RollingUpdateStatefulSetStrategyType,
+				RollingUpdate: &appsv1.RollingUpdateStatefulSetStrategy{
+					Partition: &partition,
+				},
+			},
+		},
+		Status: appsv1.StatefulSetStatus{
+			CurrentRevision: "old",
+			UpdateRevision:  "new",
+		},
+	}
+}
+
+func newPod(ordinal int, revision string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("vault-%d", ordinal),
+			Namespace: "vault",
+			Labels: map[string]string{
+				revisionLabel: revision,
+			},
+		},
+		Status: corev1.PodStatus{
+			PodIP: fmt.Sprintf("10.0.0.%d", ordinal+1),
+		},
+	}
+}
+
+func TestStep(t *testing.T) {
+	tests := []struct {
+		name              string
+		partition         int32
+		revisions         []string
+		sealed            map[string]bool
+		expectedPartition int32
+		expectedSafe      string
+	}{
+		{
+			name:              "updated pod unsealed - partition lowered",
+			partition:         2,
+			revisions:         []string{"old", "old", "new"},
+			expectedPartition: 1,
+			expectedSafe:      "true",
+		},
+		{
+			name:              "updated pod still sealed - partition kept",
+			partition:         2,
+			revisions:         []string{"old", "old", "new"},
+			sealed:            map[string]bool{"10.0.0.3": true},
+			expectedPartition: 2,
+			expectedSafe:      "false",
+		},
+		{
+			name:              "pod not yet restarted - partition kept",
+			partition:         2,
+			revisions:         []string{"old", "old", "old"},
+			expectedPartition: 2,
+			expectedSafe:      "true",
+		},
+		{
+			name:              "rollout complete - nothing to do",
+			partition:         0,
+			revisions:         []string{"new", "new", "new"},
+			expectedPartition: 0,
+			expectedSafe:      "true",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+
+			_, err := clientset.AppsV1().StatefulSets("vault").Create(context.Background(),
+				newStatefulSet(int32(len(tt.revisions)), tt.partition), metav1.CreateOptions{})
+			if err != nil {
+				t.Fatalf("failed to create test statefulset: %v", err)
+			}
+
+			for i, revision := range tt.revisions {
+				if _, err := clientset.CoreV1().Pods("vault").Create(context.Background(), newPod(i, revision), metav1.CreateOptions{}); err != nil {
+					t.Fatalf("failed to create test pod: %v", err)
+				}
+			}
+
+			coordinator := NewCoordinator(kubernetes.NewClientWithInterface(clientset), "vault", "vault",
+				func(_ context.Context, podIP string) (bool, error) {
+					return !tt.sealed[podIP], nil
+				})
+
+			if err := coordinator.Step(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			statefulSet, err := clientset.AppsV1().StatefulSets("vault").Get(context.Background(), "vault", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get statefulset: %v", err)
+			}
+
+			if partition := *statefulSet.Spec.UpdateStrategy.RollingUpdate.Partition; partition != tt.expectedPartition {
+				t.Errorf("expected partition %d, got %d", tt.expectedPartition, partition)
+			}
+			if safe := statefulSet.Annotations[SafeAnnotation]; safe != tt.expectedSafe {
+				t.Errorf("expected %s annotation %q, got %q", SafeAnnotation, tt.expectedSafe, safe)
+			}
+		})
+	}
+}
+
+func TestStepMissingPod(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+
+	_, err := clientset.AppsV1().StatefulSets("vault").Create(context.Background(), newStatefulSet(2, 1), metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("failed to create test statefulset: %v", err)
+	}
+
+	if _, err := clientset.CoreV1().Pods("vault").Create(context.Background(), newPod(0, "old"), metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create test pod: %v", err)
+	}
+
+	coordinator := NewCoordinator(kubernetes.NewClientWithInterface(clientset), "vault", "vault",
+		func(context.Context, string) (bool, error) {
+			return true, nil
+		})
+
+	if err := coordinator.Step(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	statefulSet, err := clientset.AppsV1().StatefulSets("vault").Get(context.Background(), "vault", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get statefulset: %v", err)
+	}
+
+	if partition := *statefulSet.Spec.UpdateStrategy.RollingUpdate.Partition; partition != 1 {
+		t.Errorf("expected partition to stay at 1 while a pod is missing, got %d", partition)
+	}
+	if safe := statefulSet.Annotations[SafeAnnotation]; safe != "false" {
+		t.Errorf("expected rollout to be marked unsafe, got %q", safe)
+	}
+}
@@ -0,0 +1,196 @@
+// Package sealedsecrets encrypts Secrets into Bitnami SealedSecrets, so key material can be
+// committed to a Git repository and only decrypted by the sealed-secrets controller holding the
+// matching private key. The encryption is the hybrid scheme of kubeseal: a random AES-256-GCM
+// session key per value, itself encrypted with RSA-OAEP to the controller's certificate.
+package sealedsecrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// APIVersion and Kind identify the SealedSecret resource
+	APIVersion = "bitnami.com/v1alpha1"
+	Kind       = "SealedSecret"
+
+	// NamespaceWideAnnotation and ClusterWideAnnotation mark the scope of a SealedSecret
+	NamespaceWideAnnotation = "sealedsecrets.bitnami.com/namespace-wide"
+	ClusterWideAnnotation   = "sealedsecrets.bitnami.com/cluster-wide"
+
+	sessionKeySize = 32
+)
+
+// Scope limits where a SealedSecret can be unsealed
+type Scope string
+
+// Scopes
+const (
+	// Strict binds the SealedSecret to the name and namespace of the Secret
+	Strict Scope = "strict"
+	// NamespaceWide allows renaming the Secret within its namespace
+	NamespaceWide Scope = "namespace-wide"
+	// ClusterWide allows unsealing under any name in any namespace
+	ClusterWide Scope = "cluster-wide"
+)
+
+// ParseScope validates a scope name
+func ParseScope(value string) (Scope, error) {
+	switch scope := Scope(value); scope {
+	case Strict, NamespaceWide, ClusterWide:
+		return scope, nil
+	}
+
+	return "", fmt.Errorf("unknown scope %q, expected %s, %s or %s", value, Strict, NamespaceWide, ClusterWide)
+}
+
+// label returns the label the session keys are encrypted with, which is what binds a value to
+// its scope
+func (s Scope) label(namespace, name string) []byte {
+	switch s {
+	case NamespaceWide:
+		return []byte(namespace)
+	case ClusterWide:
+		return nil
+	}
+
+	return []byte(namespace + "/" + name)
+}
+
+// SealedSecret is the subset of the SealedSecret resource written by Seal
+type SealedSecret struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              Spec `json:"spec"`
+}
+
+// Spec holds the encrypted values and the metadata of the Secret they unseal to
+type Spec struct {
+	EncryptedData map[string]string `json:"encryptedData"`
+	Template      Template          `json:"template"`
+}
+
+// Template is the metadata of the unsealed Secret
+type Template struct {
+	Metadata Metadata          `json:"metadata"`
+	Type     corev1.SecretType `json:"type,omitempty"`
+}
+
+// Metadata is the part of the Secret's metadata that is carried over
+type Metadata struct {
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ParseCertificate returns the certificate of a sealed-secrets controller, as served on its
+// /v1/cert.pem endpoint or printed by kubeseal --fetch-cert
+func ParseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no PEM encoded certificate found")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	if _, ok := cert.PublicKey.(*rsa.PublicKey); !ok {
+		return nil, fmt.Errorf("certificate has a %T public key, sealed-secrets uses RSA", cert.PublicKey)
+	}
+
+	return cert, nil
+}
+
+// Seal encrypts every value of secret to the public key of cert. Server-side metadata such as
+// the resource version is dropped, labels, annotations and the type are carried over.
+func Seal(cert *x509.Certificate, secret *corev1.Secret, scope Scope) (*SealedSecret, error) {
+	publicKey, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("certificate has a %T public key, sealed-secrets uses RSA", cert.PublicKey)
+	}
+
+	sealed := &SealedSecret{
+		TypeMeta:   metav1.TypeMeta{APIVersion: APIVersion, Kind: Kind},
+		ObjectMeta: metav1.ObjectMeta{Name: secret.Name, Namespace: secret.Namespace},
+		Spec: Spec{
+			EncryptedData: make(map[string]string, len(secret.Data)),
+			Template: Template{
+				Metadata: Metadata{
+					Name:        secret.Name,
+					Namespace:   secret.Namespace,
+					Labels:      secret.Labels,
+					Annotations: secret.Annotations,
+				},
+				Type: secret.Type,
+			},
+		},
+	}
+	switch scope {
+	case NamespaceWide:
+		sealed.Annotations = map[string]string{NamespaceWideAnnotation: "true"}
+	case ClusterWide:
+		sealed.Annotations = map[string]string{ClusterWideAnnotation: "true"}
+	}
+
+	keys := make([]string, 0, len(secret.Data))
+	for key := range secret.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	label := scope.label(secret.Namespace, secret.Name)
+	for _, key := range keys {
+		ciphertext, err := encrypt(rand.Reader, publicKey, secret.Data[key], label)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt %s: %w", key, err)
+		}
+		sealed.Spec.EncryptedData[key] = base64.StdEncoding.EncodeToString(ciphertext)
+	}
+
+	return sealed, nil
+}
+
+// encrypt seals plaintext with a fresh session key, laid out as the big endian length of the
+// RSA encrypted session key, the encrypted session key and the AES-GCM ciphertext. The nonce is
+// all zeros, which is safe because every session key is used once.
+func encrypt(rnd io.Reader, publicKey *rsa.PublicKey, plaintext, label []byte) ([]byte, error) {
+	sessionKey := make([]byte, sessionKeySize)
+	if _, err := io.ReadFull(rnd, sessionKey); err != nil {
+		return nil, fmt.Errorf("failed to generate session key: %w", err)
+	}
+
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rnd, publicKey, sessionKey, label)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt session key: %w", err)
+	}
+
+	ciphertext := make([]byte, 2, 2+len(encryptedKey)+len(plaintext)+aead.Overhead())
+	binary.BigEndian.PutUint16(ciphertext, uint16(len(encryptedKey)))
+	ciphertext = append(ciphertext, encryptedKey...)
+
+	return aead.Seal(ciphertext, make([]byte, aead.NonceSize()), plaintext, nil), nil
+}
@@ -0,0 +1,173 @@
+package sealedsecrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newCertificate(t *testing.T, key interface{}, public interface{}) []byte {
+	t.Helper()
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sealed-secret"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, public, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// decrypt reverses encrypt the way the sealed-secrets controller does
+func decrypt(t *testing.T, key *rsa.PrivateKey, value string, label []byte) ([]byte, error) {
+	t.Helper()
+
+	ciphertext, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		t.Fatalf("value is not base64 encoded: %v", err)
+	}
+
+	size := int(binary.BigEndian.Uint16(ciphertext))
+	sessionKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, key, ciphertext[2:2+size], label)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return aead.Open(nil, make([]byte, aead.NonceSize()), ciphertext[2+size:], nil)
+}
+
+func TestSeal(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := ParseCertificate(newCertificate(t, key, &key.PublicKey))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "vault-unseal-keys",
+			Namespace:       "vault",
+			ResourceVersion: "42",
+			Annotations:     map[string]string{"vault.growly.io/threshold": "3"},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{"key1": []byte("aa"), "key2": []byte("bb")},
+	}
+
+	tests := []struct {
+		scope      Scope
+		label      []byte
+		annotation string
+	}{
+		{scope: Strict, label: []byte("vault/vault-unseal-keys")},
+		{scope: NamespaceWide, label: []byte("vault"), annotation: NamespaceWideAnnotation},
+		{scope: ClusterWide, label: nil, annotation: ClusterWideAnnotation},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.scope), func(t *testing.T) {
+			sealed, err := Seal(cert, secret, tt.scope)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if sealed.APIVersion != APIVersion || sealed.Kind != Kind || sealed.Name != secret.Name || sealed.Namespace != secret.Namespace {
+				t.Errorf("unexpected object %s %s %s/%s", sealed.APIVersion, sealed.Kind, sealed.Namespace, sealed.Name)
+			}
+			if sealed.ResourceVersion != "" {
+				t.Errorf("expected the resource version to be dropped")
+			}
+			if tt.annotation != "" && sealed.Annotations[tt.annotation] != "true" {
+				t.Errorf("expected the %s annotation, got %v", tt.annotation, sealed.Annotations)
+			}
+			if sealed.Spec.Template.Type != corev1.SecretTypeOpaque || sealed.Spec.Template.Metadata.Annotations["vault.growly.io/threshold"] != "3" {
+				t.Errorf("expected the Secret's type and annotations in the template, got %+v", sealed.Spec.Template)
+			}
+
+			for name, value := range secret.Data {
+				plaintext, err := decrypt(t, key, sealed.Spec.EncryptedData[name], tt.label)
+				if err != nil {
+					t.Fatalf("failed to decrypt %s: %v", name, err)
+				}
+				if string(plaintext) != string(value) {
+					t.Errorf("expected %s to decrypt to %q, got %q", name, value, plaintext)
+				}
+			}
+
+			// A strict value cannot be unsealed under another name
+			if tt.scope == Strict {
+				if _, err := decrypt(t, key, sealed.Spec.EncryptedData["key1"], []byte("vault/other")); err == nil {
+					t.Errorf("expected a strict value to be bound to its name")
+				}
+			}
+		})
+	}
+}
+
+func TestParseCertificate(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: nil},
+		{name: "not a certificate", data: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("key")})},
+		{name: "invalid certificate", data: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("cert")})},
+		{name: "not RSA", data: newCertificate(t, ecKey, &ecKey.PublicKey)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseCertificate(tt.data); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestParseScope(t *testing.T) {
+	for _, value := range []string{"strict", "namespace-wide", "cluster-wide"} {
+		if scope, err := ParseScope(value); err != nil || string(scope) != value {
+			t.Errorf("expected %s to parse, got %v", value, err)
+		}
+	}
+	if _, err := ParseScope("global"); err == nil {
+		t.Errorf("expected an unknown scope to be rejected")
+	}
+}
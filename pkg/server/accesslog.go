@@ -0,0 +1,89 @@
+package server
+
+import (
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// probePaths are polled by kubelet and Prometheus, and left out of the access log unless
+// AccessLog.Probes is set or they fail
+var probePaths = map[string]bool{
+	"/health":  true,
+	"/ready":   true,
+	"/metrics": true,
+}
+
+// AccessLog configures which requests the server logs
+type AccessLog struct {
+	// SamplePercent is the share of successful requests logged, from 0 to 100. Requests
+	// answered with a 4xx or 5xx status are always logged.
+	SamplePercent int
+	// Probes logs successful requests to /health, /ready and /metrics too
+	Probes bool
+}
+
+// statusRecorder remembers the status and size of the response written through it
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+// WriteHeader implements http.ResponseWriter
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+
+	return n, err
+}
+
+// Unwrap returns the underlying writer, for http.ResponseController
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// logAccess wraps next so each request is logged as an Access: line with its method, path,
+// status, duration, response size and remote address. Query strings are never logged.
+func (s *Server) logAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if !s.shouldLogAccess(r.URL.Path, status) {
+			return
+		}
+
+		log.Printf("Access: method=%s path=%s status=%d duration=%v bytes=%d remote=%s",
+			r.Method, r.URL.Path, status, time.Since(start).Round(time.Microsecond), recorder.bytes, r.RemoteAddr)
+	})
+}
+
+// shouldLogAccess decides whether a request to path answered with status is logged
+func (s *Server) shouldLogAccess(path string, status int) bool {
+	if status >= http.StatusBadRequest {
+		return true
+	}
+	if probePaths[path] && !s.accessLog.Probes {
+		return false
+	}
+
+	return s.accessLog.SamplePercent >= 100 || rand.Intn(100) < s.accessLog.SamplePercent
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/getgrowly/vault-utils/pkg/notify"
+	"github.com/getgrowly/vault-utils/pkg/status"
+)
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	tests := []struct {
+		name      string
+		accessLog AccessLog
+		path      string
+		expected  string
+	}{
+		{
+			name:      "request",
+			accessLog: AccessLog{SamplePercent: 100},
+			path:      "/status?namespace=vault",
+			expected:  "Access: method=GET path=/status status=200 duration=",
+		},
+		{
+			name:      "probe",
+			accessLog: AccessLog{SamplePercent: 100},
+			path:      "/health",
+		},
+		{
+			name:      "probe with probes logged",
+			accessLog: AccessLog{SamplePercent: 100, Probes: true},
+			path:      "/health",
+			expected:  "path=/health status=200",
+		},
+		{
+			name:      "request left out of the sample",
+			accessLog: AccessLog{SamplePercent: 0},
+			path:      "/status",
+		},
+		{
+			name:      "error outside the sample",
+			accessLog: AccessLog{SamplePercent: 0},
+			path:      "/unknown",
+			expected:  "path=/unknown status=404",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := NewServer("8080", notify.Nop{}, status.NewStore())
+			srv.SetAccessLog(tt.accessLog)
+			buf.Reset()
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.RemoteAddr = "10.1.0.1:41000"
+			srv.handler().ServeHTTP(httptest.NewRecorder(), req)
+
+			logged := buf.String()
+			if tt.expected == "" {
+				if logged != "" {
+					t.Errorf("expected nothing to be logged, got %q", logged)
+				}
+				return
+			}
+			if !strings.Contains(logged, tt.expected) || !strings.Contains(logged, "remote=10.1.0.1:41000") {
+				t.Errorf("expected an access log line with %q, got %q", tt.expected, logged)
+			}
+			if strings.Contains(logged, "namespace=vault") {
+				t.Errorf("expected the query string to be left out, got %q", logged)
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/vault"
+)
+
+// Admin performs privileged actions with the stored root token on behalf of a requester, and
+// keeps an audit record of each
+type Admin interface {
+	CreateAdminToken(ctx context.Context, requester string, req vault.TokenCreateRequest) (*vault.TokenAuth, error)
+	EnableSecretsEngine(ctx context.Context, requester, path string, mount vault.MountRequest) error
+	RekeyUnsealKeys(ctx context.Context, requester string, shares, threshold int) error
+	RequestSealMigration(requester string)
+	StepDownActive(ctx context.Context, requester string) (string, error)
+	RestoreSnapshot(ctx context.Context, requester, key string, force bool) error
+}
+
+// maxAdminBody bounds the body of a request to /admin
+const maxAdminBody = 4096
+
+// adminTokenRequest is the body posted to /admin/token
+type adminTokenRequest struct {
+	// Requester names who the token is for, recorded in the audit trail
+	Requester string   `json:"requester"`
+	Policies  []string `json:"policies"`
+	// TTL is a duration such as 15m, capped at ADMIN_TOKEN_TTL
+	TTL string `json:"ttl"`
+	// NumUses is how many requests the token may make, 1 when it is 0
+	NumUses int `json:"num_uses"`
+}
+
+// adminTokenResponse is the answer to a post to /admin/token
+type adminTokenResponse struct {
+	Token    string   `json:"token"`
+	Accessor string   `json:"accessor"`
+	Policies []string `json:"policies"`
+	// TTL is the TTL of the token in seconds
+	TTL int `json:"ttl"`
+}
+
+// adminEngineRequest is the body posted to /admin/engines
+type adminEngineRequest struct {
+	Requester   string `json:"requester"`
+	Path        string `json:"path"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+}
+
+// adminRekeyRequest is the body posted to /admin/rekey
+type adminRekeyRequest struct {
+	Requester string `json:"requester"`
+	// Shares and Threshold are the new split of the unseal keys, the current one when they are 0
+	Shares    int `json:"shares"`
+	Threshold int `json:"threshold"`
+}
+
+// adminSealMigrationRequest is the body posted to /admin/seal-migration
+type adminSealMigrationRequest struct {
+	Requester string `json:"requester"`
+}
+
+// adminStepDownRequest is the body posted to /admin/step-down
+type adminStepDownRequest struct {
+	Requester string `json:"requester"`
+}
+
+// adminRestoreRequest is the body posted to /admin/restore
+type adminRestoreRequest struct {
+	Requester string `json:"requester"`
+	// Key is the object key of the snapshot in the backup store
+	Key string `json:"key"`
+	// Force restores a snapshot of another cluster
+	Force bool `json:"force"`
+	// Confirm must repeat Key, since a restore replaces every secret in Vault
+	Confirm string `json:"confirm"`
+}
+
+// adminStepDownResponse is the answer to a post to /admin/step-down
+type adminStepDownResponse struct {
+	// Pod is the pod that was the active node
+	Pod string `json:"pod"`
+}
+
+// handleAdminToken creates a short-lived token with the requested policies
+func (s *Server) handleAdminToken(w http.ResponseWriter, r *http.Request) {
+	var req adminTokenRequest
+	if !s.decodeAdminRequest(w, r, &req) {
+		return
+	}
+
+	if req.Requester == "" || len(req.Policies) == 0 {
+		http.Error(w, "Invalid request: requester and policies are required", http.StatusBadRequest)
+		return
+	}
+	for _, policy := range req.Policies {
+		if policy == "root" {
+			http.Error(w, "Invalid request: tokens with the root policy are not handed out", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.TTL != "" {
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 {
+			http.Error(w, fmt.Sprintf("Invalid request: invalid ttl %q", req.TTL), http.StatusBadRequest)
+			return
+		}
+		// Vault counts TTLs in seconds and reads 0 as its default TTL
+		if ttl < time.Second {
+			http.Error(w, "Invalid request: ttl must be at least 1s", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.NumUses < 0 {
+		http.Error(w, "Invalid request: num_uses must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	auth, err := s.admin.CreateAdminToken(r.Context(), req.Requester, vault.TokenCreateRequest{
+		Policies: req.Policies,
+		TTL:      req.TTL,
+		NumUses:  req.NumUses,
+	})
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	writeJSON(w, adminTokenResponse{Token: auth.ClientToken, Accessor: auth.Accessor, Policies: auth.Policies, TTL: auth.LeaseDuration})
+}
+
+// handleAdminEngine enables a secrets engine
+func (s *Server) handleAdminEngine(w http.ResponseWriter, r *http.Request) {
+	var req adminEngineRequest
+	if !s.decodeAdminRequest(w, r, &req) {
+		return
+	}
+
+	req.Path = strings.Trim(req.Path, "/")
+	if req.Requester == "" || req.Path == "" || req.Type == "" {
+		http.Error(w, "Invalid request: requester, path and type are required", http.StatusBadRequest)
+		return
+	}
+	if strings.HasPrefix(req.Path, "sys") || strings.Contains(req.Path, "..") {
+		http.Error(w, fmt.Sprintf("Invalid request: invalid path %q", req.Path), http.StatusBadRequest)
+		return
+	}
+
+	err := s.admin.EnableSecretsEngine(r.Context(), req.Requester, req.Path, vault.MountRequest{Type: req.Type, Description: req.Description})
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAdminRekey replaces the unseal keys
+func (s *Server) handleAdminRekey(w http.ResponseWriter, r *http.Request) {
+	var req adminRekeyRequest
+	if !s.decodeAdminRequest(w, r, &req) {
+		return
+	}
+
+	if req.Requester == "" {
+		http.Error(w, "Invalid request: requester is required", http.StatusBadRequest)
+		return
+	}
+	if req.Shares < 0 || req.Threshold < 0 || (req.Shares > 0 && req.Threshold > req.Shares) {
+		http.Error(w, "Invalid request: threshold must be between 1 and shares", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.admin.RekeyUnsealKeys(r.Context(), req.Requester, req.Shares, req.Threshold); err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAdminSealMigration has the pods waiting for their seal to be migrated migrated by the
+// next passes, which start right away
+func (s *Server) handleAdminSealMigration(w http.ResponseWriter, r *http.Request) {
+	var req adminSealMigrationRequest
+	if !s.decodeAdminRequest(w, r, &req) {
+		return
+	}
+
+	if req.Requester == "" {
+		http.Error(w, "Invalid request: requester is required", http.StatusBadRequest)
+		return
+	}
+
+	s.admin.RequestSealMigration(req.Requester)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleAdminStepDown has the active node hand leadership to a standby
+func (s *Server) handleAdminStepDown(w http.ResponseWriter, r *http.Request) {
+	var req adminStepDownRequest
+	if !s.decodeAdminRequest(w, r, &req) {
+		return
+	}
+
+	if req.Requester == "" {
+		http.Error(w, "Invalid request: requester is required", http.StatusBadRequest)
+		return
+	}
+
+	pod, err := s.admin.StepDownActive(r.Context(), req.Requester)
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	writeJSON(w, adminStepDownResponse{Pod: pod})
+}
+
+// handleAdminRestore restores a raft snapshot from the backup store
+func (s *Server) handleAdminRestore(w http.ResponseWriter, r *http.Request) {
+	var req adminRestoreRequest
+	if !s.decodeAdminRequest(w, r, &req) {
+		return
+	}
+
+	if req.Requester == "" || req.Key == "" {
+		http.Error(w, "Invalid request: requester and key are required", http.StatusBadRequest)
+		return
+	}
+	if strings.HasPrefix(req.Key, "/") || strings.Contains(req.Key, "..") {
+		http.Error(w, fmt.Sprintf("Invalid request: invalid key %q", req.Key), http.StatusBadRequest)
+		return
+	}
+	if req.Confirm != req.Key {
+		http.Error(w, "Invalid request: a restore replaces all data in Vault, set confirm to the key to go ahead", http.StatusBadRequest)
+		return
+	}
+
+	// The restore answers once the snapshot is in Vault, long after the write timeout that
+	// suits every other endpoint
+	if s.timeouts.Restore > 0 {
+		deadline := time.Now().Add(s.timeouts.Restore + restoreWriteMargin)
+		if err := http.NewResponseController(w).SetWriteDeadline(deadline); err != nil {
+			log.Printf("Warning: failed to extend the write deadline of the restore: %v", err)
+		}
+	}
+
+	if err := s.admin.RestoreSnapshot(r.Context(), req.Requester, req.Key, req.Force); err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeAdminRequest checks the method and bearer token of a request to /admin and decodes its
+// body into req. It answers the request and returns false when it cannot go on.
+func (s *Server) decodeAdminRequest(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	if !authorized(r, s.adminToken) {
+		log.Printf("Audit: rejected unauthorized request to %s from %s", r.URL.Path, r.RemoteAddr)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("Request body too large, at most %d bytes are accepted", maxAdminBody), http.StatusRequestEntityTooLarge)
+			return false
+		}
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return false
+	}
+
+	return true
+}
+
+// writeAdminError answers a failed admin action. Vault refusing the request, such as a path
+// already in use, is the caller's to fix; anything else is a failure to reach Vault.
+func writeAdminError(w http.ResponseWriter, err error) {
+	var respErr *vault.ResponseError
+	if errors.As(err, &respErr) && respErr.StatusCode == http.StatusBadRequest {
+		http.Error(w, fmt.Sprintf("Vault refused the request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	http.Error(w, fmt.Sprintf("Failed to perform the action: %v", err), http.StatusBadGateway)
+}
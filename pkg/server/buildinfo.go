@@ -0,0 +1,94 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// vcsRevision is set at link time for builds without VCS information, such as Docker builds
+// that leave .git out of the build context
+var vcsRevision string
+
+// BuildInfo describes how the running binary was built
+type BuildInfo struct {
+	GoVersion string            `json:"go_version"`
+	Path      string            `json:"path"`
+	Main      Module            `json:"main"`
+	Deps      []Module          `json:"deps"`
+	Settings  map[string]string `json:"settings"`
+}
+
+// Module is a Go module compiled into the binary
+type Module struct {
+	Path    string  `json:"path"`
+	Version string  `json:"version"`
+	Sum     string  `json:"sum,omitempty"`
+	Replace *Module `json:"replace,omitempty"`
+}
+
+// readBuildInfo returns the build provenance embedded by the Go toolchain, including the module
+// versions and checksums and the VCS revision the binary was built from
+func readBuildInfo() (*BuildInfo, bool) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return nil, false
+	}
+
+	buildInfo := &BuildInfo{
+		GoVersion: info.GoVersion,
+		Path:      info.Path,
+		Main:      toModule(&info.Main),
+		Deps:      make([]Module, 0, len(info.Deps)),
+		Settings:  make(map[string]string, len(info.Settings)),
+	}
+
+	for _, dep := range info.Deps {
+		buildInfo.Deps = append(buildInfo.Deps, toModule(dep))
+	}
+
+	for _, setting := range info.Settings {
+		buildInfo.Settings[setting.Key] = setting.Value
+	}
+
+	if _, ok := buildInfo.Settings["vcs.revision"]; !ok && vcsRevision != "" {
+		buildInfo.Settings["vcs.revision"] = vcsRevision
+	}
+
+	return buildInfo, true
+}
+
+func toModule(m *debug.Module) Module {
+	module := Module{
+		Path:    m.Path,
+		Version: m.Version,
+		Sum:     m.Sum,
+	}
+
+	if m.Replace != nil {
+		replace := toModule(m.Replace)
+		module.Replace = &replace
+	}
+
+	return module
+}
+
+// handleBuildInfo serves the build provenance of the running binary
+func (s *Server) handleBuildInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	buildInfo, ok := readBuildInfo()
+	if !ok {
+		http.Error(w, "Build information not available", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(buildInfo); err != nil {
+		log.Printf("Error encoding build information: %v", err)
+	}
+}
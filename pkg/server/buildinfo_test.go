@@ -0,0 +1,49 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+)
+
+func TestHandleBuildInfo(t *testing.T) {
+	srv := &Server{}
+
+	rec := httptest.NewRecorder()
+	srv.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/buildinfo", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	var info BuildInfo
+	if err := json.NewDecoder(rec.Body).Decode(&info); err != nil {
+		t.Fatalf("failed to decode build info: %v", err)
+	}
+
+	if info.GoVersion != runtime.Version() {
+		t.Errorf("expected go version %s, got %s", runtime.Version(), info.GoVersion)
+	}
+
+	rec = httptest.NewRecorder()
+	srv.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/debug/buildinfo", nil))
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d for POST, got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+}
+
+func TestReadBuildInfoDeps(t *testing.T) {
+	info, ok := readBuildInfo()
+	if !ok {
+		t.Skip("build information not available")
+	}
+
+	for _, dep := range info.Deps {
+		if dep.Path == "" || dep.Version == "" {
+			t.Errorf("expected every dependency to have a path and version, got %+v", dep)
+		}
+	}
+}
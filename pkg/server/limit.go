@@ -0,0 +1,90 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AdminLimits protects the admin API from clients sending more than the controller and Vault
+// should take
+type AdminLimits struct {
+	// RatePerMinute is how many requests are accepted per minute on average, and Burst how many
+	// at once. Requests are not rate limited when RatePerMinute is 0.
+	RatePerMinute int
+	Burst         int
+	// MaxConcurrent is how many admin actions may be in progress at once, any number when it
+	// is 0
+	MaxConcurrent int
+}
+
+// tokenBucket is a rate limiter holding up to burst tokens, refilled at rate tokens a second
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// newTokenBucket returns a full bucket allowing perMinute requests a minute and burst at once
+func newTokenBucket(perMinute, burst int) *tokenBucket {
+	return &tokenBucket{
+		rate:   float64(perMinute) / 60,
+		burst:  float64(max(burst, 1)),
+		tokens: float64(max(burst, 1)),
+		now:    time.Now,
+	}
+}
+
+// take takes a token, or returns how long until one is available
+func (b *tokenBucket) take() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	if !b.last.IsZero() {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// limitAdmin wraps an admin handler with the rate and concurrency limits. Rejected requests are
+// answered 429 with a Retry-After header, before the bearer token is checked, so guessing the
+// token is rate limited too.
+func (s *Server) limitAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.adminRate != nil {
+			if ok, wait := s.adminRate.take(); !ok {
+				log.Printf("Audit: rate limited request to %s from %s", r.URL.Path, r.RemoteAddr)
+				w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+		}
+
+		if s.adminSlots != nil {
+			select {
+			case s.adminSlots <- struct{}{}:
+				defer func() { <-s.adminSlots }()
+			default:
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "Too many requests: another admin action is in progress", http.StatusTooManyRequests)
+				return
+			}
+		}
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/notify"
+	"github.com/getgrowly/vault-utils/pkg/status"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+)
+
+// blockingAdmin holds every admin action until released
+type blockingAdmin struct {
+	recordingAdmin
+	started chan struct{}
+	release chan struct{}
+}
+
+func (a *blockingAdmin) EnableSecretsEngine(ctx context.Context, requester, path string, mount vault.MountRequest) error {
+	a.started <- struct{}{}
+	<-a.release
+
+	return a.recordingAdmin.EnableSecretsEngine(ctx, requester, path, mount)
+}
+
+func adminRequest(srv *Server, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	srv.handler().ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestAdminRateLimit(t *testing.T) {
+	srv := NewServer("8080", notify.Nop{}, status.NewStore())
+	srv.SetAdminAPI(&recordingAdmin{}, "s3cret")
+	srv.SetAdminLimits(AdminLimits{RatePerMinute: 60, Burst: 2})
+	now := time.Now()
+	srv.adminRate.now = func() time.Time { return now }
+
+	body := `{"requester": "alice", "policies": ["admin"]}`
+	for i := 0; i < 2; i++ {
+		if rec := adminRequest(srv, "/admin/token", body); rec.Code != http.StatusOK {
+			t.Fatalf("expected request %d within the burst to be accepted, got %d: %s", i+1, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := adminRequest(srv, "/admin/token", body)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the request past the burst to be rate limited, got %d", rec.Code)
+	}
+	if retryAfter := rec.Header().Get("Retry-After"); retryAfter != "1" {
+		t.Errorf("expected a retry after 1 second, got %q", retryAfter)
+	}
+
+	now = now.Add(time.Second)
+	if rec := adminRequest(srv, "/admin/token", body); rec.Code != http.StatusOK {
+		t.Errorf("expected a request to be accepted once a token is refilled, got %d", rec.Code)
+	}
+}
+
+func TestAdminMaxConcurrent(t *testing.T) {
+	admin := &blockingAdmin{started: make(chan struct{}), release: make(chan struct{})}
+	srv := NewServer("8080", notify.Nop{}, status.NewStore())
+	srv.SetAdminAPI(admin, "s3cret")
+	srv.SetAdminLimits(AdminLimits{MaxConcurrent: 1})
+
+	body := `{"requester": "bob", "path": "kv", "type": "kv-v2"}`
+	done := make(chan int)
+	go func() {
+		done <- adminRequest(srv, "/admin/engines", body).Code
+	}()
+	<-admin.started
+
+	if rec := adminRequest(srv, "/admin/engines", body); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected a second action to be refused while one is in progress, got %d", rec.Code)
+	}
+
+	close(admin.release)
+	if code := <-done; code != http.StatusNoContent {
+		t.Fatalf("expected the first action to succeed, got %d", code)
+	}
+	go func() { <-admin.started }()
+	if rec := adminRequest(srv, "/admin/engines", body); rec.Code != http.StatusNoContent {
+		t.Errorf("expected an action to be accepted once the previous one finished, got %d", rec.Code)
+	}
+}
@@ -1,13 +1,21 @@
 package server
 
 import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/getgrowly/vault-utils/pkg/kubernetes"
-	"github.com/getgrowly/vault-utils/pkg/vault"
+	"github.com/getgrowly/vault-utils/pkg/backup"
+	"github.com/getgrowly/vault-utils/pkg/metrics"
+	"github.com/getgrowly/vault-utils/pkg/notify"
+	"github.com/getgrowly/vault-utils/pkg/recovery"
+	"github.com/getgrowly/vault-utils/pkg/status"
 )
 
 const (
@@ -18,27 +26,125 @@ const (
 
 // Server represents the HTTP server for health and readiness checks
 type Server struct {
-	k8sClient *kubernetes.Client
-	port      string
+	port     string
+	notifier notify.Notifier
+	status   *status.Store
+	// checkReady checks the Vault pods for /ready, which answers 503 without it
+	checkReady func(ctx context.Context) error
+	// reportEvent is called with the events posted to /events, which is only served when it is
+	// set
+	reportEvent func(pod, event string) bool
+	// eventToken is the bearer token posting to /events requires, none is required when empty
+	eventToken string
+	// admin performs the privileged actions requested on /admin, which is only served when it
+	// is set
+	admin Admin
+	// adminToken is the bearer token requests to /admin require
+	adminToken string
+	// adminRate rate limits requests to /admin, and adminSlots holds a value for every admin
+	// action in progress; either is nil when not limited
+	adminRate  *tokenBucket
+	adminSlots chan struct{}
+	// backupCatalog lists the raft snapshots in the backup store for /status/backups, which is
+	// only served when it is set
+	backupCatalog func(ctx context.Context) ([]backup.Entry, error)
+	// readyCacheTTL is how long a computed readiness result answers /ready, 0 to compute it for
+	// every probe
+	readyCacheTTL time.Duration
+	// readyMu guards readyAt and readyOK, and is held while readiness is computed so concurrent
+	// probes wait for one result instead of each checking every pod
+	readyMu sync.Mutex
+	readyAt time.Time
+	readyOK bool
+	// readyMaxAge has /ready answer from the state of the latest reconcile pass, as long as it
+	// checked the pods at most that long ago, instead of checking the pods itself
+	readyMaxAge time.Duration
+	// accessLog decides which requests are logged
+	accessLog AccessLog
+	// timeouts bounds how long the endpoints take to answer
+	timeouts Timeouts
 }
 
-// NewServer creates a new HTTP server
-func NewServer(k8sClient *kubernetes.Client, port string) *Server {
+// NewServer creates a new HTTP server. Panics in handlers are reported to notifier, and
+// /status serves the pod states held by statusStore.
+func NewServer(port string, notifier notify.Notifier, statusStore *status.Store) *Server {
 	return &Server{
-		k8sClient: k8sClient,
 		port:      port,
+		notifier:  notifier,
+		status:    statusStore,
+		accessLog: AccessLog{SamplePercent: 100},
 	}
 }
 
+// SetReadinessCheck has /ready call check to learn whether every Vault pod is initialized and
+// unsealed, reaching them the way the controller does. It must be called before Start.
+func (s *Server) SetReadinessCheck(check func(ctx context.Context) error) {
+	s.checkReady = check
+}
+
+// SetEventReceiver serves /events, where external systems post that a Vault pod restarted or
+// sealed so reportEvent starts a pass right away. Posts must carry token as a bearer token when
+// it is not empty. It must be called before Start.
+func (s *Server) SetEventReceiver(reportEvent func(pod, event string) bool, token string) {
+	s.reportEvent = reportEvent
+	s.eventToken = token
+}
+
+// SetAdminAPI serves /admin, where operators have admin perform privileged actions with the
+// stored root token rather than handing out the token itself. Requests must carry token as a
+// bearer token, which must not be empty. It must be called before Start.
+func (s *Server) SetAdminAPI(admin Admin, token string) {
+	s.admin = admin
+	s.adminToken = token
+}
+
+// SetBackupCatalog serves /status/backups, listing the raft snapshots catalog returns. It must
+// be called before Start.
+func (s *Server) SetBackupCatalog(catalog func(ctx context.Context) ([]backup.Entry, error)) {
+	s.backupCatalog = catalog
+}
+
+// SetAdminLimits bounds the rate of requests to /admin and the number of admin actions in
+// progress at once. It must be called before Start.
+func (s *Server) SetAdminLimits(limits AdminLimits) {
+	s.adminRate, s.adminSlots = nil, nil
+	if limits.RatePerMinute > 0 {
+		s.adminRate = newTokenBucket(limits.RatePerMinute, limits.Burst)
+	}
+	if limits.MaxConcurrent > 0 {
+		s.adminSlots = make(chan struct{}, limits.MaxConcurrent)
+	}
+}
+
+// SetReadyCacheTTL has /ready answer with the readiness computed at most ttl ago, so frequent
+// probes don't each list the pods and check every Vault. It must be called before Start.
+func (s *Server) SetReadyCacheTTL(ttl time.Duration) {
+	s.readyCacheTTL = ttl
+}
+
+// SetReadyFromStatus has /ready answer from the state the reconcile loop keeps in the status
+// store rather than checking every Vault itself, so probes stay cheap however many pods there
+// are. The state is only trusted when a pass checked the pods at most maxAge ago. It must be
+// called before Start.
+func (s *Server) SetReadyFromStatus(maxAge time.Duration) {
+	s.readyMaxAge = maxAge
+}
+
+// SetTimeouts sets how long the endpoints may take to answer. It must be called before Start.
+func (s *Server) SetTimeouts(timeouts Timeouts) {
+	s.timeouts = timeouts
+}
+
+// SetAccessLog sets which requests are logged. It must be called before Start.
+func (s *Server) SetAccessLog(accessLog AccessLog) {
+	s.accessLog = accessLog
+}
+
 // Start starts the HTTP server
 func (s *Server) Start() error {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/health", s.handleHealth)
-	mux.HandleFunc("/ready", s.handleReady)
-
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%s", s.port),
-		Handler:      mux,
+		Handler:      s.handler(),
 		ReadTimeout:  defaultReadTimeout,
 		WriteTimeout: defaultWriteTimeout,
 		IdleTimeout:  defaultIdleTimeout,
@@ -48,6 +154,34 @@ func (s *Server) Start() error {
 	return srv.ListenAndServe()
 }
 
+// handler routes the endpoints of the server
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", s.handleHealth)
+	mux.Handle("/ready", withTimeout(s.timeouts.Ready, s.handleReady))
+	mux.Handle("/metrics", metrics.Default.Handler())
+	mux.HandleFunc("/debug/buildinfo", s.handleBuildInfo)
+	mux.Handle("/status", withTimeout(s.timeouts.Status, s.handleStatus))
+	mux.Handle("/status/summary", withTimeout(s.timeouts.Status, s.handleStatusSummary))
+	if s.backupCatalog != nil {
+		mux.Handle("/status/backups", withTimeout(s.timeouts.Status, s.handleStatusBackups))
+	}
+	if s.reportEvent != nil {
+		mux.HandleFunc("/events", s.handleEvent)
+	}
+	if s.admin != nil && s.adminToken != "" {
+		mux.HandleFunc("/admin/token", s.limitAdmin(s.handleAdminToken))
+		mux.HandleFunc("/admin/engines", s.limitAdmin(s.handleAdminEngine))
+		mux.HandleFunc("/admin/rekey", s.limitAdmin(s.handleAdminRekey))
+		mux.HandleFunc("/admin/seal-migration", s.limitAdmin(s.handleAdminSealMigration))
+		mux.HandleFunc("/admin/step-down", s.limitAdmin(s.handleAdminStepDown))
+		mux.HandleFunc("/admin/restore", s.limitAdmin(s.handleAdminRestore))
+	}
+
+	// The access log wraps the recovery so requests whose handler panicked are logged as 500s
+	return s.logAccess(recovery.Middleware("server", s.notifier, mux))
+}
+
 // handleHealth handles health check requests
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -55,48 +189,198 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("Health check request received from %s", r.RemoteAddr)
 	w.WriteHeader(http.StatusOK)
 }
 
-// handleReady handles readiness check requests
-func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+// statusResponse is the body of /status
+type statusResponse struct {
+	status.Snapshot
+	// Notifications reports notification deliveries, including the dead letters, when notifications
+	// are queued
+	Notifications *notify.Stats `json:"notifications,omitempty"`
+}
+
+// handleStatus serves the controller's latest view of every Vault pod and of its notifications
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp := statusResponse{Snapshot: s.snapshot(r)}
+	if queue, ok := s.notifier.(*notify.Queue); ok {
+		stats := queue.Stats()
+		resp.Notifications = &stats
+	}
+
+	writeJSON(w, resp)
+}
+
+// handleStatusSummary serves the number of Vault pods in each state, a payload small enough for
+// dashboards polling many controllers often
+func (s *Server) handleStatusSummary(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	log.Printf("Readiness check request received from %s", r.RemoteAddr)
+	writeJSON(w, s.snapshot(r).Summary())
+}
 
-	allReady := true
+// backupsResponse is the body of /status/backups
+type backupsResponse struct {
+	// Snapshots are newest first
+	Snapshots []backup.Entry `json:"snapshots"`
+}
 
-	pods, err := s.k8sClient.GetVaultPods("vault")
+// handleStatusBackups serves the raft snapshots in the backup store, with what the catalog
+// recorded of them
+func (s *Server) handleStatusBackups(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	entries, err := s.backupCatalog(r.Context())
 	if err != nil {
-		log.Printf("Error getting Vault pods: %v", err)
-		w.WriteHeader(http.StatusServiceUnavailable)
+		log.Printf("Error reading the backup catalog: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to read the backup catalog: %v", err), http.StatusBadGateway)
+		return
+	}
+	if entries == nil {
+		entries = []backup.Entry{}
+	}
+
+	writeJSON(w, backupsResponse{Snapshots: entries})
+}
+
+// snapshot returns the status snapshot, limited to the namespace the namespace query parameter
+// names when it is set
+func (s *Server) snapshot(r *http.Request) status.Snapshot {
+	snapshot := s.status.Snapshot()
+	if namespace := r.URL.Query().Get("namespace"); namespace != "" {
+		snapshot = snapshot.InNamespace(namespace)
+	}
+
+	return snapshot
+}
+
+// writeJSON writes body as a JSON response
+func writeJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Error encoding status: %v", err)
+	}
+}
+
+// eventRequest is the body posted to /events
+type eventRequest struct {
+	// Pod names the Vault pod the event is about
+	Pod string `json:"pod"`
+	// Event is what happened to it, such as restarted or sealed
+	Event string `json:"event"`
+}
+
+// eventResponse is the body of the answer to a post to /events
+type eventResponse struct {
+	// Queued is false when a pass was already requested by an earlier event
+	Queued bool `json:"queued"`
+}
+
+// handleEvent receives the report of an event about a Vault pod and has the controller reconcile
+func (s *Server) handleEvent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	for _, podIP := range pods {
-		vaultAddr := fmt.Sprintf("http://%s:8200", podIP)
-		vaultClient := vault.NewClient(vaultAddr)
+	if s.eventToken != "" && !authorized(r, s.eventToken) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
 
-		status, err := vaultClient.CheckStatus()
-		if err != nil {
-			log.Printf("Error checking Vault status for %s: %v", vaultAddr, err)
-			allReady = false
-			continue
-		}
+	var req eventRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid event: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.Pod == "" || req.Event == "" {
+		http.Error(w, "Invalid event: pod and event are required", http.StatusBadRequest)
+		return
+	}
 
-		if status.Sealed {
-			allReady = false
-		}
+	queued := s.reportEvent(req.Pod, req.Event)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(eventResponse{Queued: queued}); err != nil {
+		log.Printf("Error encoding event response: %v", err)
 	}
+}
 
-	if !allReady {
+// handleReady handles readiness check requests
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.ready(r.Context()) {
 		w.WriteHeader(http.StatusServiceUnavailable)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
+
+// ready reports whether every Vault pod is unsealed, from the reconcile loop's state or the
+// cached result when it is recent enough
+func (s *Server) ready(ctx context.Context) bool {
+	if s.readyMaxAge > 0 {
+		if err := s.status.Snapshot().Ready(s.readyMaxAge); err != nil {
+			log.Printf("Not ready: %v", err)
+			return false
+		}
+
+		return true
+	}
+	if s.readyCacheTTL <= 0 {
+		return s.podsReady(ctx)
+	}
+
+	s.readyMu.Lock()
+	defer s.readyMu.Unlock()
+
+	if !s.readyAt.IsZero() && time.Since(s.readyAt) < s.readyCacheTTL {
+		return s.readyOK
+	}
+
+	ready := s.podsReady(ctx)
+	if ctx.Err() != nil {
+		// A check cut short by the deadline of /ready says nothing about the pods
+		return false
+	}
+	s.readyOK, s.readyAt = ready, time.Now()
+
+	return s.readyOK
+}
+
+// podsReady reports whether every Vault pod is reachable, initialized and unsealed
+func (s *Server) podsReady(ctx context.Context) bool {
+	if s.checkReady == nil {
+		log.Printf("Not ready: no readiness check is configured")
+		return false
+	}
+	if err := s.checkReady(ctx); err != nil {
+		log.Printf("Not ready: %v", err)
+		return false
+	}
+
+	return true
+}
+
+// authorized reports whether r carries token as a bearer token
+func authorized(r *http.Request, token string) bool {
+	bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	return subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1
+}
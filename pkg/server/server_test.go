@@ -3,82 +3,26 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
-	"github.com/getgrowly/vault-utils/pkg/kubernetes"
+	"github.com/getgrowly/vault-utils/pkg/backup"
+	"github.com/getgrowly/vault-utils/pkg/notify"
+	"github.com/getgrowly/vault-utils/pkg/status"
 	"github.com/getgrowly/vault-utils/pkg/vault"
-	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestHealthCheckEndpoints(t *testing.T) {
-	// Create a fake Kubernetes clientset
-	clientset := fake.NewSimpleClientset()
-
-	// Create test pods
-	pod1 := &corev1.Pod{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      "vault-0",
-			Namespace: "vault",
-			Labels: map[string]string{
-				"app.kubernetes.io/name": "vault",
-				"component":              "server",
-			},
-		},
-		Status: corev1.PodStatus{
-			PodIP: "10.0.0.1",
-		},
-	}
-
-	pod2 := &corev1.Pod{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      "vault-1",
-			Namespace: "vault",
-			Labels: map[string]string{
-				"app.kubernetes.io/name": "vault",
-				"component":              "server",
-			},
-		},
-		Status: corev1.PodStatus{
-			PodIP: "10.0.0.2",
-		},
-	}
-
-	// Add pods to the fake clientset
-	_, err := clientset.CoreV1().Pods("vault").Create(context.Background(), pod1, metav1.CreateOptions{})
-	if err != nil {
-		t.Fatalf("failed to create test pod: %v", err)
-	}
-
-	_, err = clientset.CoreV1().Pods("vault").Create(context.Background(), pod2, metav1.CreateOptions{})
-	if err != nil {
-		t.Fatalf("failed to create test pod: %v", err)
-	}
-
-	// Create a test HTTP server to mock Vault responses
-	vaultServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path != "/v1/sys/health" {
-			t.Errorf("unexpected path: %s", r.URL.Path)
-			w.WriteHeader(http.StatusNotFound)
-			return
-		}
-		// Return a sealed status
-		w.WriteHeader(http.StatusServiceUnavailable)
-		if err := json.NewEncoder(w).Encode(vault.VaultStatus{
-			Sealed:      true,
-			Initialized: true,
-		}); err != nil {
-			t.Errorf("failed to encode response: %v", err)
-		}
-	}))
-	defer vaultServer.Close()
-
-	// Create Kubernetes client
-	k8sClient := kubernetes.NewClientWithInterface(clientset)
-	srv := NewServer(k8sClient, "8080")
+	srv := NewServer("8080", notify.Nop{}, status.NewStore())
+	srv.SetReadinessCheck(func(context.Context) error {
+		return errors.New("pod 10.0.0.1 is sealed")
+	})
 
 	tests := []struct {
 		name       string
@@ -95,6 +39,11 @@ func TestHealthCheckEndpoints(t *testing.T) {
 			endpoint:   "/ready",
 			expectCode: http.StatusServiceUnavailable, // Vault pods exist but are sealed
 		},
+		{
+			name:       "metrics endpoint",
+			endpoint:   "/metrics",
+			expectCode: http.StatusOK,
+		},
 	}
 
 	for _, tt := range tests {
@@ -107,6 +56,8 @@ func TestHealthCheckEndpoints(t *testing.T) {
 				srv.handleHealth(w, req)
 			case "/ready":
 				srv.handleReady(w, req)
+			default:
+				srv.handler().ServeHTTP(w, req)
 			}
 
 			if w.Code != tt.expectCode {
@@ -115,3 +66,524 @@ func TestHealthCheckEndpoints(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleReadyCache(t *testing.T) {
+	srv := NewServer("8080", notify.Nop{}, status.NewStore())
+	srv.SetReadyCacheTTL(time.Minute)
+	checks := 0
+	var checkErr error
+	srv.SetReadinessCheck(func(context.Context) error {
+		checks++
+
+		return checkErr
+	})
+
+	probe := func() int {
+		w := httptest.NewRecorder()
+		srv.handleReady(w, httptest.NewRequest("GET", "/ready", nil))
+
+		return w.Code
+	}
+
+	if code := probe(); code != http.StatusOK {
+		t.Fatalf("expected 200 with every pod unsealed, got %d", code)
+	}
+
+	// Checking fails from now on, which the cached result hides until it expires
+	checkErr = errors.New("API server unavailable")
+	for i := 0; i < 3; i++ {
+		if code := probe(); code != http.StatusOK {
+			t.Errorf("expected the cached 200, got %d", code)
+		}
+	}
+	if checks != 1 {
+		t.Errorf("expected the pods to be checked once, got %d", checks)
+	}
+
+	srv.readyAt = time.Now().Add(-time.Minute)
+	if code := probe(); code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 once the cached result expired, got %d", code)
+	}
+	if checks != 2 {
+		t.Errorf("expected the pods to be checked again, got %d checks", checks)
+	}
+}
+
+func TestHandleReadyFromStatus(t *testing.T) {
+	store := status.NewStore()
+	srv := NewServer("8080", notify.Nop{}, store)
+	srv.SetReadyFromStatus(time.Minute)
+	checks := 0
+	srv.SetReadinessCheck(func(context.Context) error {
+		checks++
+
+		return nil
+	})
+
+	probe := func() int {
+		w := httptest.NewRecorder()
+		srv.handleReady(w, httptest.NewRequest("GET", "/ready", nil))
+
+		return w.Code
+	}
+
+	if code := probe(); code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 before the first pass, got %d", code)
+	}
+
+	store.Update("vault-0", func(p *status.Pod) {
+		p.Reachable = true
+		p.Initialized = true
+	})
+	store.MarkChecked()
+	if code := probe(); code != http.StatusOK {
+		t.Errorf("expected 200 once a pass found the pod unsealed, got %d", code)
+	}
+
+	store.Update("vault-0", func(p *status.Pod) { p.Sealed = true })
+	if code := probe(); code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 once the pod is reported sealed, got %d", code)
+	}
+	if checks != 0 {
+		t.Errorf("expected the pods not to be checked, got %d checks", checks)
+	}
+}
+
+func TestHandleStatus(t *testing.T) {
+	store := status.NewStore()
+	store.Update("10.0.0.1", func(p *status.Pod) {
+		p.Reachable = true
+		p.Initialized = true
+		p.Sealed = true
+		p.Error = "error unsealing Vault: no unseal keys found in secret"
+	})
+	srv := NewServer("8080", notify.Nop{}, store)
+
+	rec := httptest.NewRecorder()
+	srv.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	var resp statusResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode status: %v", err)
+	}
+	if len(resp.Pods) != 1 || !resp.Pods[0].Sealed || resp.Pods[0].Error == "" {
+		t.Errorf("unexpected status %+v", resp)
+	}
+	if resp.Notifications != nil {
+		t.Errorf("expected no notification stats without a webhook, got %+v", resp.Notifications)
+	}
+}
+
+func TestHandleStatusSummary(t *testing.T) {
+	store := status.NewStore()
+	store.SetNamespace("vault")
+	store.Update("10.0.0.1", func(p *status.Pod) { *p = status.Pod{Pod: "10.0.0.1", Reachable: true, Initialized: true} })
+	store.Update("10.0.0.2", func(p *status.Pod) {
+		*p = status.Pod{Pod: "10.0.0.2", Reachable: true, Initialized: true, Sealed: true}
+	})
+	store.Update("10.0.0.3", func(p *status.Pod) { *p = status.Pod{Pod: "10.0.0.3"} })
+	store.SetWarnings("test", []string{"something needs attention"})
+	srv := NewServer("8080", notify.Nop{}, store)
+
+	tests := []struct {
+		name     string
+		target   string
+		expected status.Summary
+	}{
+		{
+			name:   "all pods",
+			target: "/status/summary",
+			expected: status.Summary{Namespace: "vault", Pods: 3, Members: "1/3 members unsealed", Warnings: 1, States: map[string]int{
+				status.Unsealed: 1, status.Sealed: 1, status.Uninitialized: 0, status.Unreachable: 1,
+			}},
+		},
+		{
+			name:   "namespace of the controller",
+			target: "/status/summary?namespace=vault",
+			expected: status.Summary{Namespace: "vault", Pods: 3, Members: "1/3 members unsealed", Warnings: 1, States: map[string]int{
+				status.Unsealed: 1, status.Sealed: 1, status.Uninitialized: 0, status.Unreachable: 1,
+			}},
+		},
+		{
+			name:   "other namespace",
+			target: "/status/summary?namespace=staging",
+			expected: status.Summary{Namespace: "staging", States: map[string]int{
+				status.Unsealed: 0, status.Sealed: 0, status.Uninitialized: 0, status.Unreachable: 0,
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			srv.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+			}
+
+			var summary status.Summary
+			if err := json.NewDecoder(rec.Body).Decode(&summary); err != nil {
+				t.Fatalf("failed to decode summary: %v", err)
+			}
+			summary.UpdatedAt = time.Time{}
+			if !reflect.DeepEqual(summary, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, summary)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	srv.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status?namespace=staging", nil))
+	var resp statusResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode status: %v", err)
+	}
+	if len(resp.Pods) != 0 || len(resp.Warnings) != 0 {
+		t.Errorf("expected no pods of another namespace, got %+v", resp.Snapshot)
+	}
+}
+
+func TestHandleStatusDeadLetters(t *testing.T) {
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer webhook.Close()
+
+	queue := notify.NewQueue(notify.NewWebhook(webhook.URL))
+	defer queue.Close()
+	queue.Notify(notify.Event{Type: notify.EventSealed, Message: "pod 10.0.0.1 is sealed again"})
+
+	srv := NewServer("8080", queue, status.NewStore())
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rec := httptest.NewRecorder()
+		srv.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+		var resp statusResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode status: %v", err)
+		}
+		if resp.Notifications == nil {
+			t.Fatalf("expected notification stats with a queued notifier")
+		}
+		if len(resp.Notifications.DeadLetters) == 1 {
+			if resp.Notifications.DeadLetters[0].Event.Type != notify.EventSealed {
+				t.Errorf("unexpected dead letter %+v", resp.Notifications.DeadLetters[0])
+			}
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the rejected event to be dead-lettered, got %+v", resp.Notifications)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHandleStatusBackups(t *testing.T) {
+	srv := NewServer("8080", notify.Nop{}, status.NewStore())
+	rec := httptest.NewRecorder()
+	srv.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status/backups", nil))
+	if rec.Code == http.StatusOK {
+		t.Errorf("expected /status/backups not to be served without a backup store")
+	}
+
+	taken := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	catalogErr := error(nil)
+	srv.SetBackupCatalog(func(context.Context) ([]backup.Entry, error) {
+		return []backup.Entry{{Key: "vault/20240101T000000Z.snap", TakenAt: taken, Size: 42, VaultVersion: "1.15.0", SHA256: "abc"}}, catalogErr
+	})
+	rec = httptest.NewRecorder()
+	srv.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status/backups", nil))
+	var resp backupsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode the backups: %v", err)
+	}
+	if rec.Code != http.StatusOK || len(resp.Snapshots) != 1 || resp.Snapshots[0].VaultVersion != "1.15.0" || !resp.Snapshots[0].TakenAt.Equal(taken) {
+		t.Errorf("expected the catalog, got %d: %+v", rec.Code, resp)
+	}
+
+	catalogErr = errors.New("bucket unavailable")
+	rec = httptest.NewRecorder()
+	srv.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status/backups", nil))
+	if rec.Code != http.StatusBadGateway || !strings.Contains(rec.Body.String(), "bucket unavailable") {
+		t.Errorf("expected 502 when the catalog cannot be read, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestHandleEvent(t *testing.T) {
+	var reported []string
+	srv := NewServer("8080", notify.Nop{}, status.NewStore())
+
+	rec := httptest.NewRecorder()
+	srv.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(`{"pod": "vault-0", "event": "sealed"}`)))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected /events not to be served unless enabled, got status %d", rec.Code)
+	}
+
+	srv.SetEventReceiver(func(pod, event string) bool {
+		reported = append(reported, pod+" "+event)
+
+		return len(reported) == 1
+	}, "s3cret")
+
+	tests := []struct {
+		name           string
+		method         string
+		token          string
+		body           string
+		expectedStatus int
+		expectedQueued bool
+	}{
+		{
+			name:           "queued",
+			method:         http.MethodPost,
+			token:          "s3cret",
+			body:           `{"pod": "vault-0", "event": "sealed"}`,
+			expectedStatus: http.StatusAccepted,
+			expectedQueued: true,
+		},
+		{
+			name:           "folded into the pass already requested",
+			method:         http.MethodPost,
+			token:          "s3cret",
+			body:           `{"pod": "vault-1", "event": "restarted"}`,
+			expectedStatus: http.StatusAccepted,
+		},
+		{
+			name:           "wrong token",
+			method:         http.MethodPost,
+			token:          "guess",
+			body:           `{"pod": "vault-0", "event": "sealed"}`,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "missing pod",
+			method:         http.MethodPost,
+			token:          "s3cret",
+			body:           `{"event": "sealed"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "get",
+			method:         http.MethodGet,
+			token:          "s3cret",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/events", strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rec := httptest.NewRecorder()
+			srv.handler().ServeHTTP(rec, req)
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+			if rec.Code != http.StatusAccepted {
+				return
+			}
+
+			var resp eventResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if resp.Queued != tt.expectedQueued {
+				t.Errorf("expected queued %t, got %t", tt.expectedQueued, resp.Queued)
+			}
+		})
+	}
+
+	if !reflect.DeepEqual(reported, []string{"vault-0 sealed", "vault-1 restarted"}) {
+		t.Errorf("expected only the accepted events to be reported, got %v", reported)
+	}
+}
+
+// recordingAdmin keeps the admin actions requested from it
+type recordingAdmin struct {
+	actions []string
+}
+
+func (a *recordingAdmin) CreateAdminToken(_ context.Context, requester string, req vault.TokenCreateRequest) (*vault.TokenAuth, error) {
+	a.actions = append(a.actions, requester+" token "+strings.Join(req.Policies, ","))
+
+	return &vault.TokenAuth{ClientToken: "hvs.child", Accessor: "accessor", Policies: req.Policies, LeaseDuration: 900}, nil
+}
+
+func (a *recordingAdmin) EnableSecretsEngine(_ context.Context, requester, path string, mount vault.MountRequest) error {
+	a.actions = append(a.actions, requester+" engine "+mount.Type+" at "+path)
+
+	return nil
+}
+
+func (a *recordingAdmin) RekeyUnsealKeys(_ context.Context, requester string, shares, threshold int) error {
+	a.actions = append(a.actions, fmt.Sprintf("%s rekey %d/%d", requester, threshold, shares))
+
+	return nil
+}
+
+func (a *recordingAdmin) RequestSealMigration(requester string) {
+	a.actions = append(a.actions, requester+" seal migration")
+}
+
+func (a *recordingAdmin) StepDownActive(_ context.Context, requester string) (string, error) {
+	a.actions = append(a.actions, requester+" step down")
+
+	return "10.0.0.1", nil
+}
+
+func (a *recordingAdmin) RestoreSnapshot(_ context.Context, requester, key string, force bool) error {
+	a.actions = append(a.actions, fmt.Sprintf("%s restore %s force=%t", requester, key, force))
+
+	return nil
+}
+
+func TestHandleAdmin(t *testing.T) {
+	admin := &recordingAdmin{}
+	srv := NewServer("8080", notify.Nop{}, status.NewStore())
+	srv.SetAdminAPI(admin, "s3cret")
+
+	tests := []struct {
+		name           string
+		path           string
+		token          string
+		body           string
+		expectedStatus int
+	}{
+		{
+			name:           "token",
+			path:           "/admin/token",
+			token:          "s3cret",
+			body:           `{"requester": "alice", "policies": ["admin"], "ttl": "10m"}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "token below a second",
+			path:           "/admin/token",
+			token:          "s3cret",
+			body:           `{"requester": "alice", "policies": ["admin"], "ttl": "500ms"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "root policy",
+			path:           "/admin/token",
+			token:          "s3cret",
+			body:           `{"requester": "alice", "policies": ["root"]}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "missing requester",
+			path:           "/admin/token",
+			token:          "s3cret",
+			body:           `{"policies": ["admin"]}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "wrong token",
+			path:           "/admin/token",
+			token:          "guess",
+			body:           `{"requester": "alice", "policies": ["admin"]}`,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "engine",
+			path:           "/admin/engines",
+			token:          "s3cret",
+			body:           `{"requester": "bob", "path": "/kv/", "type": "kv-v2"}`,
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "engine under sys",
+			path:           "/admin/engines",
+			token:          "s3cret",
+			body:           `{"requester": "bob", "path": "sys/x", "type": "kv-v2"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "rekey",
+			path:           "/admin/rekey",
+			token:          "s3cret",
+			body:           `{"requester": "carol", "shares": 5, "threshold": 3}`,
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "rekey threshold above shares",
+			path:           "/admin/rekey",
+			token:          "s3cret",
+			body:           `{"requester": "carol", "shares": 2, "threshold": 3}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "seal migration",
+			path:           "/admin/seal-migration",
+			token:          "s3cret",
+			body:           `{"requester": "dave"}`,
+			expectedStatus: http.StatusAccepted,
+		},
+		{
+			name:           "seal migration without requester",
+			path:           "/admin/seal-migration",
+			token:          "s3cret",
+			body:           `{}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "step down",
+			path:           "/admin/step-down",
+			token:          "s3cret",
+			body:           `{"requester": "erin"}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "restore",
+			path:           "/admin/restore",
+			token:          "s3cret",
+			body:           `{"requester": "frank", "key": "vault/20240101T000000Z.snap", "force": true, "confirm": "vault/20240101T000000Z.snap"}`,
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "restore without confirmation",
+			path:           "/admin/restore",
+			token:          "s3cret",
+			body:           `{"requester": "frank", "key": "vault/20240101T000000Z.snap"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "restore outside the bucket",
+			path:           "/admin/restore",
+			token:          "s3cret",
+			body:           `{"requester": "frank", "key": "../other.snap", "confirm": "../other.snap"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "oversized body",
+			path:           "/admin/token",
+			token:          "s3cret",
+			body:           `{"requester": "` + strings.Repeat("a", maxAdminBody) + `", "policies": ["admin"]}`,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rec := httptest.NewRecorder()
+			srv.handler().ServeHTTP(rec, req)
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	if !reflect.DeepEqual(admin.actions, []string{"alice token admin", "bob engine kv-v2 at kv", "carol rekey 3/5", "dave seal migration", "erin step down",
+		"frank restore vault/20240101T000000Z.snap force=true"}) {
+		t.Errorf("expected only the valid requests to reach the admin, got %v", admin.actions)
+	}
+}
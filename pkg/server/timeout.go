@@ -0,0 +1,32 @@
+package server
+
+import (
+	"net/http"
+	"time"
+)
+
+// Timeouts bounds how long the endpoints that may fan out to every Vault pod take to answer, so
+// a slow Vault cannot make a probe exceed the kubelet's timeout. An endpoint past its deadline
+// answers 503; 0 leaves it unbounded.
+type Timeouts struct {
+	// Ready bounds /ready, which should stay below the timeout of the probes pointed at it
+	Ready time.Duration
+	// Status bounds /status, /status/summary and /status/backups
+	Status time.Duration
+	// Restore is how long a restore posted to /admin/restore may take, which the response to
+	// it waits for past the write timeout of the server; 0 keeps the write timeout
+	Restore time.Duration
+}
+
+// restoreWriteMargin is added to Timeouts.Restore for taking the action lock and answering
+const restoreWriteMargin = time.Minute
+
+// withTimeout answers 503 when next has not answered within timeout, and cancels the context of
+// the request so the Vault requests it made give up too
+func withTimeout(timeout time.Duration, next http.HandlerFunc) http.Handler {
+	if timeout <= 0 {
+		return next
+	}
+
+	return http.TimeoutHandler(next, timeout, "Timed out")
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/notify"
+	"github.com/getgrowly/vault-utils/pkg/status"
+)
+
+func TestReadyTimeout(t *testing.T) {
+	srv := NewServer("8080", notify.Nop{}, status.NewStore())
+	// Checking the pods hangs like an overloaded API server, past the deadline of /ready
+	srv.SetReadinessCheck(func(context.Context) error {
+		time.Sleep(200 * time.Millisecond)
+
+		return nil
+	})
+	srv.SetReadyCacheTTL(time.Minute)
+	srv.SetTimeouts(Timeouts{Ready: 20 * time.Millisecond})
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	srv.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 once /ready timed out, got %d", rec.Code)
+	}
+	if elapsed := time.Since(start); elapsed >= 200*time.Millisecond {
+		t.Errorf("expected /ready to answer at its deadline, took %v", elapsed)
+	}
+
+	// The check cut short finishes in the background without caching a result
+	time.Sleep(300 * time.Millisecond)
+	srv.readyMu.Lock()
+	cached := !srv.readyAt.IsZero()
+	srv.readyMu.Unlock()
+	if cached {
+		t.Errorf("expected the check cut short not to be cached")
+	}
+}
+
+func TestStatusWithinTimeout(t *testing.T) {
+	srv := NewServer("8080", notify.Nop{}, status.NewStore())
+	srv.SetTimeouts(Timeouts{Status: time.Second})
+
+	rec := httptest.NewRecorder()
+	srv.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected /status to answer within its timeout, got %d", rec.Code)
+	}
+	if contentType := rec.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("expected the JSON content type to be kept, got %q", contentType)
+	}
+}
+
+// slowAdmin takes delay to restore a snapshot
+type slowAdmin struct {
+	recordingAdmin
+	delay time.Duration
+}
+
+func (a *slowAdmin) RestoreSnapshot(ctx context.Context, requester, key string, force bool) error {
+	time.Sleep(a.delay)
+
+	return a.recordingAdmin.RestoreSnapshot(ctx, requester, key, force)
+}
+
+func TestRestoreOutlastsWriteTimeout(t *testing.T) {
+	const body = `{"requester": "frank", "key": "vault/20240101T000000Z.snap", "confirm": "vault/20240101T000000Z.snap"}`
+
+	for _, tt := range []struct {
+		name     string
+		restore  time.Duration
+		answered bool
+	}{
+		{name: "write timeout kept", restore: 0, answered: false},
+		{name: "deadline extended for the restore", restore: time.Second, answered: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := NewServer("8080", notify.Nop{}, status.NewStore())
+			srv.SetAdminAPI(&slowAdmin{delay: 300 * time.Millisecond}, "s3cret")
+			srv.SetTimeouts(Timeouts{Restore: tt.restore})
+
+			ts := httptest.NewUnstartedServer(srv.handler())
+			ts.Config.WriteTimeout = 100 * time.Millisecond
+			ts.Start()
+			defer ts.Close()
+
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/admin/restore", strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", "Bearer s3cret")
+			resp, err := ts.Client().Do(req)
+			if !tt.answered {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatalf("expected the connection to be cut at the write timeout, got %d", resp.StatusCode)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected the restore to be answered past the write timeout, got %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusNoContent {
+				t.Errorf("expected 204, got %d", resp.StatusCode)
+			}
+		})
+	}
+}
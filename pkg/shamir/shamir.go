@@ -0,0 +1,157 @@
+// Package shamir implements Shamir's secret sharing over GF(2^8) in the same share format as
+// Vault: every share holds one polynomial evaluation per secret byte followed by a single
+// byte with its x coordinate. It lets the controller check stored key shares for consistency
+// without sending them to Vault.
+package shamir
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrTooFewParts is returned when fewer than two parts are combined
+	ErrTooFewParts = errors.New("at least two parts are required")
+	// ErrLengthMismatch is returned when parts do not all have the same length
+	ErrLengthMismatch = errors.New("all parts must be the same length")
+	// ErrDuplicatePart is returned when two parts share the same x coordinate
+	ErrDuplicatePart = errors.New("duplicate part detected")
+)
+
+// Split divides secret into the given number of parts, any threshold of which reconstruct it
+func Split(secret []byte, parts, threshold int) ([][]byte, error) {
+	switch {
+	case len(secret) == 0:
+		return nil, errors.New("cannot split an empty secret")
+	case parts < threshold:
+		return nil, errors.New("parts cannot be less than threshold")
+	case parts > 255:
+		return nil, errors.New("parts cannot exceed 255")
+	case threshold < 2:
+		return nil, errors.New("threshold must be at least 2")
+	}
+
+	out := make([][]byte, parts)
+	for i := range out {
+		out[i] = make([]byte, len(secret)+1)
+		out[i][len(secret)] = byte(i + 1)
+	}
+
+	coefficients := make([]byte, threshold-1)
+	for idx, value := range secret {
+		if _, err := rand.Read(coefficients); err != nil {
+			return nil, fmt.Errorf("failed to generate coefficients: %w", err)
+		}
+
+		for i := range out {
+			x := out[i][len(secret)]
+			out[i][idx] = evaluate(value, coefficients, x)
+		}
+	}
+
+	return out, nil
+}
+
+// Combine reconstructs the secret from a set of parts. Combining fewer parts than the
+// threshold, or parts that do not belong together, yields a wrong secret rather than an error.
+func Combine(parts [][]byte) ([]byte, error) {
+	if len(parts) < 2 {
+		return nil, ErrTooFewParts
+	}
+
+	length := len(parts[0])
+	if length < 2 {
+		return nil, errors.New("parts must be at least two bytes")
+	}
+
+	xs := make([]byte, len(parts))
+	seen := make(map[byte]bool, len(parts))
+	for i, part := range parts {
+		if len(part) != length {
+			return nil, ErrLengthMismatch
+		}
+
+		x := part[length-1]
+		if seen[x] {
+			return nil, ErrDuplicatePart
+		}
+		seen[x] = true
+		xs[i] = x
+	}
+
+	secret := make([]byte, length-1)
+	ys := make([]byte, len(parts))
+	for idx := range secret {
+		for i, part := range parts {
+			ys[i] = part[idx]
+		}
+		secret[idx] = interpolateAtZero(xs, ys)
+	}
+
+	return secret, nil
+}
+
+// evaluate computes the polynomial with the given intercept and higher coefficients at x
+func evaluate(intercept byte, coefficients []byte, x byte) byte {
+	result := byte(0)
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		result = add(mult(result, x), coefficients[i])
+	}
+
+	return add(mult(result, x), intercept)
+}
+
+// interpolateAtZero evaluates the Lagrange polynomial through the given points at x = 0
+func interpolateAtZero(xs, ys []byte) byte {
+	result := byte(0)
+	for i := range xs {
+		basis := byte(1)
+		for j := range xs {
+			if i == j {
+				continue
+			}
+			basis = mult(basis, div(xs[j], add(xs[i], xs[j])))
+		}
+		result = add(result, mult(ys[i], basis))
+	}
+
+	return result
+}
+
+// add adds two elements of GF(2^8)
+func add(a, b byte) byte {
+	return a ^ b
+}
+
+// mult multiplies two elements of GF(2^8) modulo x^8 + x^4 + x^3 + x + 1
+func mult(a, b byte) byte {
+	var result byte
+	for i := 7; i >= 0; i-- {
+		overflow := result >> 7
+		result <<= 1
+		if overflow == 1 {
+			result ^= 0x1b
+		}
+		if (b>>uint(i))&1 == 1 {
+			result ^= a
+		}
+	}
+
+	return result
+}
+
+// inverse returns the multiplicative inverse of a non-zero element, a^254 in GF(2^8)
+func inverse(a byte) byte {
+	result := byte(1)
+	for i := 0; i < 254; i++ {
+		result = mult(result, a)
+	}
+
+	return result
+}
+
+// div divides a by a non-zero b in GF(2^8)
+func div(a, b byte) byte {
+	return mult(a, inverse(b))
+}
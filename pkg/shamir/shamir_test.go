@@ -0,0 +1,118 @@
+package shamir
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMult(t *testing.T) {
+	// Known products in the AES field
+	tests := []struct {
+		a, b, expected byte
+	}{
+		{0x57, 0x83, 0xc1},
+		{0x57, 0x13, 0xfe},
+		{0x01, 0xab, 0xab},
+		{0x00, 0xab, 0x00},
+	}
+
+	for _, tt := range tests {
+		if got := mult(tt.a, tt.b); got != tt.expected {
+			t.Errorf("mult(%#x, %#x) = %#x, expected %#x", tt.a, tt.b, got, tt.expected)
+		}
+	}
+
+	for a := 1; a < 256; a++ {
+		if got := mult(byte(a), inverse(byte(a))); got != 1 {
+			t.Fatalf("expected %#x * inverse to be 1, got %#x", a, got)
+		}
+	}
+}
+
+func TestSplitCombine(t *testing.T) {
+	secret := []byte("vault master key material")
+
+	parts, err := Split(secret, 5, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	subsets := [][]int{{0, 1, 2}, {2, 3, 4}, {0, 2, 4}, {4, 1, 3}, {0, 1, 2, 3, 4}}
+	for _, subset := range subsets {
+		var selected [][]byte
+		for _, i := range subset {
+			selected = append(selected, parts[i])
+		}
+
+		combined, err := Combine(selected)
+		if err != nil {
+			t.Fatalf("unexpected error combining %v: %v", subset, err)
+		}
+		if !bytes.Equal(combined, secret) {
+			t.Errorf("subset %v reconstructed %q, expected %q", subset, combined, secret)
+		}
+	}
+
+	combined, err := Combine(parts[:2])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bytes.Equal(combined, secret) {
+		t.Errorf("expected fewer parts than the threshold not to reconstruct the secret")
+	}
+}
+
+func TestCombineErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		parts    [][]byte
+		expected error
+	}{
+		{
+			name:     "too few parts",
+			parts:    [][]byte{{1, 2}},
+			expected: ErrTooFewParts,
+		},
+		{
+			name:     "length mismatch",
+			parts:    [][]byte{{1, 2, 1}, {1, 2}},
+			expected: ErrLengthMismatch,
+		},
+		{
+			name:     "duplicate x coordinate",
+			parts:    [][]byte{{1, 2, 1}, {3, 4, 1}},
+			expected: ErrDuplicatePart,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Combine(tt.parts); err != tt.expected {
+				t.Errorf("expected error %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func FuzzCombine(f *testing.F) {
+	parts, err := Split([]byte("seed"), 2, 2)
+	if err != nil {
+		f.Fatalf("failed to split secret: %v", err)
+	}
+
+	f.Add(parts[0], parts[1])
+	f.Add([]byte{1, 1}, []byte{2, 1})
+	f.Add([]byte{1}, []byte{2})
+	f.Add([]byte{}, []byte{})
+
+	f.Fuzz(func(t *testing.T, a, b []byte) {
+		secret, err := Combine([][]byte{a, b})
+		if err != nil {
+			return
+		}
+
+		if len(secret) != len(a)-1 {
+			t.Errorf("expected a secret of %d bytes, got %d", len(a)-1, len(secret))
+		}
+	})
+}
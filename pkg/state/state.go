@@ -0,0 +1,139 @@
+// Package state keeps the controller's operational memory in an encrypted local file, so a
+// controller running outside Kubernetes, or one restarted, does not start from scratch: it
+// still knows which cluster it last saw and which problems it already reported. The file is
+// sealed with AES-256-GCM under a key derived from a key file the operator provides, and is
+// replaced atomically so a crash mid-write leaves the previous state intact.
+package state
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// version prefixes the file so its format can change later
+const version byte = 1
+
+// minKeySize is the least key material accepted in the key file
+const minKeySize = 32
+
+// File is an encrypted JSON state file
+type File struct {
+	path string
+	aead cipher.AEAD
+}
+
+// Open returns the state file at path, sealed with the key read from keyFile. The key file must
+// hold at least 32 bytes, such as the output of openssl rand -hex 32; the file at path need
+// not exist yet, but its directory must be writable, since saving replaces the file through a
+// temporary file beside it. That is checked here, so a read-only filesystem fails at startup
+// rather than on every save.
+func Open(path, keyFile string) (*File, error) {
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state key: %w", err)
+	}
+	if len(key) < minKeySize {
+		return nil, fmt.Errorf("state key %s holds %d bytes, at least %d are needed", keyFile, len(key), minKeySize)
+	}
+
+	probe, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return nil, fmt.Errorf("%s must be in a writable directory, such as a mounted volume: %w", path, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	sum := sha256.Sum256(key)
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	return &File{path: path, aead: aead}, nil
+}
+
+// Path returns the location of the state file
+func (f *File) Path() string {
+	return f.path
+}
+
+// Load decrypts the state file into v. A missing file leaves v untouched, since there is no
+// state before the first save.
+func (f *File) Load(v interface{}) error {
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	nonceSize := f.aead.NonceSize()
+	if len(data) < 1+nonceSize || data[0] != version {
+		return fmt.Errorf("state file %s is not a version %d state file", f.path, version)
+	}
+
+	plaintext, err := f.aead.Open(nil, data[1:1+nonceSize], data[1+nonceSize:], []byte{version})
+	if err != nil {
+		return fmt.Errorf("failed to decrypt state file %s, was the key changed? %w", f.path, err)
+	}
+
+	if err := json.Unmarshal(plaintext, v); err != nil {
+		return fmt.Errorf("failed to decode state file: %w", err)
+	}
+
+	return nil
+}
+
+// Save encrypts v as JSON and replaces the state file with it
+func (f *File) Save(v interface{}) error {
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	nonce := make([]byte, f.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	data := append([]byte{version}, nonce...)
+	data = f.aead.Seal(data, nonce, plaintext, []byte{version})
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return fmt.Errorf("failed to replace state file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package state
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+type testState struct {
+	ClusterID string            `json:"cluster_id"`
+	Stages    map[string]string `json:"stages"`
+}
+
+func writeKey(t *testing.T, dir, name string, size int) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, bytes.Repeat([]byte(name[:1]), size), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state")
+	key := writeKey(t, dir, "key", 32)
+
+	f, err := Open(path, key)
+	if err != nil {
+		t.Fatalf("failed to open state file: %v", err)
+	}
+
+	// A missing file is an empty state
+	loaded := testState{ClusterID: "unchanged"}
+	if err := f.Load(&loaded); err != nil || loaded.ClusterID != "unchanged" {
+		t.Fatalf("expected a missing file to leave the state alone, got %+v, %v", loaded, err)
+	}
+
+	saved := testState{ClusterID: "cluster-1", Stages: map[string]string{"10.0.0.1": "tcp"}}
+	if err := f.Save(saved); err != nil {
+		t.Fatalf("failed to save state: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("cluster-1")) {
+		t.Errorf("expected the state file to be encrypted")
+	}
+	if info, err := os.Stat(path); runtime.GOOS != "windows" && (err != nil || info.Mode().Perm()&0o077 != 0) {
+		t.Errorf("expected the state file to be private, got %v, %v", info.Mode(), err)
+	}
+
+	reopened, err := Open(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded = testState{}
+	if err := reopened.Load(&loaded); err != nil {
+		t.Fatalf("failed to load state: %v", err)
+	}
+	if loaded.ClusterID != "cluster-1" || loaded.Stages["10.0.0.1"] != "tcp" {
+		t.Errorf("unexpected state %+v", loaded)
+	}
+
+	other, err := Open(path, writeKey(t, dir, "other", 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Load(&loaded); err == nil {
+		t.Errorf("expected an error loading with another key")
+	}
+
+	if err := os.WriteFile(path, []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.Load(&loaded); err == nil {
+		t.Errorf("expected an error loading a corrupt file")
+	}
+}
+
+func TestOpenRejectsShortKey(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := Open(filepath.Join(dir, "state"), writeKey(t, dir, "short", 16)); err == nil {
+		t.Errorf("expected an error for a short key")
+	}
+	if _, err := Open(filepath.Join(dir, "state"), filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("expected an error for a missing key file")
+	}
+}
+
+func TestOpenRejectsUnwritableDirectory(t *testing.T) {
+	dir := t.TempDir()
+
+	keyFile := writeKey(t, dir, "key", 32)
+	_, err := Open(filepath.Join(dir, "missing", "state"), keyFile)
+	if err == nil || !strings.Contains(err.Error(), "writable directory") {
+		t.Errorf("expected an error for a directory the file cannot be replaced in, got %v", err)
+	}
+
+	// The check leaves nothing behind
+	if _, err := Open(filepath.Join(dir, "state"), keyFile); err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("expected only the key file in the directory, got %v", entries)
+	}
+}
@@ -0,0 +1,496 @@
+// Package status keeps the controller's latest view of every Vault pod so it can be served
+// on /status
+package status
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/reason"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+)
+
+// Pod states, as reported by Pod.State
+const (
+	Unsealed      = "unsealed"
+	Sealed        = "sealed"
+	Uninitialized = "uninitialized"
+	Unreachable   = "unreachable"
+)
+
+// Phase is the state of a whole Vault cluster in the controller's reconcile state machine
+type Phase string
+
+// Cluster phases, as reported by Snapshot.Phase
+const (
+	// PhaseDiscovering means no pod of the cluster has been checked yet
+	PhaseDiscovering Phase = "Discovering"
+	// PhaseUninitialized means no checked pod is initialized
+	PhaseUninitialized Phase = "Uninitialized"
+	// PhaseInitializing means the controller is initializing the cluster
+	PhaseInitializing Phase = "Initializing"
+	// PhaseUnsealing means some checked pods are sealed or waiting to join the cluster
+	PhaseUnsealing Phase = "Unsealing"
+	// PhaseHealthy means every pod is initialized and unsealed
+	PhaseHealthy Phase = "Healthy"
+	// PhaseDegraded means the checked pods are unsealed but others could not be checked
+	PhaseDegraded Phase = "Degraded"
+	// PhaseMaintenance means the controller holds off acting on the cluster, such as while another
+	// unseal automation is active
+	PhaseMaintenance Phase = "Maintenance"
+)
+
+// Phases lists every cluster phase in the order of the state machine
+var Phases = []Phase{PhaseDiscovering, PhaseUninitialized, PhaseInitializing, PhaseUnsealing, PhaseHealthy, PhaseDegraded, PhaseMaintenance}
+
+// Pod is the latest known state of one Vault pod
+type Pod struct {
+	Pod         string `json:"pod"`
+	Reachable   bool   `json:"reachable"`
+	Initialized bool   `json:"initialized"`
+	Sealed      bool   `json:"sealed"`
+	// Reason is the code of the last error or skipped action for the pod in the latest pass
+	Reason reason.Code `json:"reason,omitempty"`
+	// Error is the last error seen for the pod in the latest pass, if any
+	Error string `json:"error,omitempty"`
+	// SealType, Version and StorageType describe the pod's Vault as reported by its seal status
+	SealType    string `json:"seal_type,omitempty"`
+	Version     string `json:"version,omitempty"`
+	StorageType string `json:"storage_type,omitempty"`
+	// Threshold and Shares are the number of keys needed to unseal and generated at init, and
+	// UnsealProgress the number of keys applied in the unseal attempt in progress
+	Threshold      int `json:"threshold,omitempty"`
+	Shares         int `json:"shares,omitempty"`
+	UnsealProgress int `json:"unseal_progress,omitempty"`
+	// ClusterName is only reported once the pod is unsealed
+	ClusterName string `json:"cluster_name,omitempty"`
+	// Diagnosis explains why the pod could not be reached
+	Diagnosis *vault.Diagnosis `json:"diagnosis,omitempty"`
+	// ClockSkewSeconds is how far the pod's clock is ahead of the controller's, negative when
+	// it is behind
+	ClockSkewSeconds float64   `json:"clock_skew_seconds,omitempty"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// ReachablePod returns the state of a pod that answered its seal status check with vaultStatus
+func ReachablePod(pod string, vaultStatus *vault.Status) Pod {
+	return Pod{
+		Pod:            pod,
+		Reachable:      true,
+		Initialized:    vaultStatus.Initialized,
+		Sealed:         vaultStatus.Sealed,
+		SealType:       vaultStatus.Type,
+		Version:        vaultStatus.Version,
+		StorageType:    vaultStatus.StorageType,
+		Threshold:      vaultStatus.Threshold,
+		Shares:         vaultStatus.Shares,
+		UnsealProgress: vaultStatus.Progress,
+		ClusterName:    vaultStatus.ClusterName,
+	}
+}
+
+// State returns the state of the pod: unreachable, uninitialized, sealed or unsealed
+func (p Pod) State() string {
+	switch {
+	case !p.Reachable:
+		return Unreachable
+	case !p.Initialized:
+		return Uninitialized
+	case p.Sealed:
+		return Sealed
+	default:
+		return Unsealed
+	}
+}
+
+// Snapshot is a consistent copy of the store
+type Snapshot struct {
+	// Namespace is the Vault namespace the pods run in
+	Namespace string `json:"namespace,omitempty"`
+	Pods      []Pod  `json:"pods"`
+	// Active is the pod found to be the active node in the latest pass, if any
+	Active string `json:"active,omitempty"`
+	// Warnings are conditions that need operator attention but do not stop the controller
+	Warnings []string `json:"warnings,omitempty"`
+	// CheckedAt is when a pass last finished checking every pod, zero before the first one
+	CheckedAt time.Time `json:"checked_at,omitempty"`
+	// Phase is the phase of the cluster, and PhaseSince when it entered it
+	Phase      Phase     `json:"phase,omitempty"`
+	PhaseSince time.Time `json:"phase_since,omitempty"`
+	// ColdStart is the progress of the cold start in progress, if any
+	ColdStart *ColdStart `json:"cold_start,omitempty"`
+	// Canary is the outcome of the latest read of the canary secret, when one is configured
+	Canary *Canary `json:"canary,omitempty"`
+	// Raft is the raft membership read in the latest pass, when Vault uses integrated storage and
+	// a token to read it is configured
+	Raft *Raft `json:"raft,omitempty"`
+	// Backup is the outcome of the latest raft snapshot backup, when backups are configured
+	Backup *Backup `json:"backup,omitempty"`
+	// Replicas is spec.replicas of the Vault StatefulSet as of the latest pass, 0 when unknown
+	Replicas int `json:"replicas,omitempty"`
+	// Members tells how many of the expected members are unsealed, such as "3/5 members
+	// unsealed"
+	Members string `json:"members,omitempty"`
+}
+
+// Canary is the outcome of reading the canary secret, which shows whether Vault serves data
+type Canary struct {
+	Path string `json:"path"`
+	OK   bool   `json:"ok"`
+	// Pod is the pod the secret was read from, the active node
+	Pod       string    `json:"pod,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Duration  string    `json:"duration"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Raft is the membership of the raft cluster as the active node lists it
+type Raft struct {
+	Peers []RaftPeer `json:"peers"`
+	// Pod is the pod the membership was read from, the active node
+	Pod       string    `json:"pod,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Backup is the outcome of taking a raft snapshot and uploading it
+type Backup struct {
+	OK bool `json:"ok"`
+	// Location is where the snapshot was uploaded, such as s3://bucket/key
+	Location string `json:"location,omitempty"`
+	// Pod is the pod the snapshot was taken from, the active node
+	Pod string `json:"pod,omitempty"`
+	// VaultVersion is the version the pod ran
+	VaultVersion string `json:"vault_version,omitempty"`
+	Size         int64  `json:"size,omitempty"`
+	// SHA256 is the hex SHA-256 of the snapshot as uploaded
+	SHA256 string `json:"sha256,omitempty"`
+	// Encrypted is set when the snapshot was encrypted before it was uploaded
+	Encrypted bool `json:"encrypted,omitempty"`
+	// Pruned is how many older snapshots the retention deleted after the upload
+	Pruned   int       `json:"pruned,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Duration string    `json:"duration"`
+	TakenAt  time.Time `json:"taken_at"`
+	// LastSuccessAt is when the latest successful backup was taken, zero before the first one
+	LastSuccessAt time.Time `json:"last_success_at,omitempty"`
+}
+
+// RaftPeer is a member of the raft cluster
+type RaftPeer struct {
+	NodeID  string `json:"node_id"`
+	Address string `json:"address"`
+	Leader  bool   `json:"leader"`
+	// Voter is unset for a non-voter, which replicates without counting toward quorum
+	Voter bool `json:"voter"`
+}
+
+// ColdStart is the progress of bringing up a cluster found with every pod sealed
+type ColdStart struct {
+	// Step is the step in progress, Detail what it waits for
+	Step      string    `json:"step"`
+	Detail    string    `json:"detail,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	StepSince time.Time `json:"step_since"`
+}
+
+// Ready returns why the snapshot does not show every pod unsealed as of at most maxAge ago, or
+// nil when it does
+func (s Snapshot) Ready(maxAge time.Duration) error {
+	if s.CheckedAt.IsZero() {
+		return fmt.Errorf("no pass has checked the pods yet")
+	}
+	if age := time.Since(s.CheckedAt); age > maxAge {
+		return fmt.Errorf("pods were last checked %v ago, more than %v", age.Round(time.Second), maxAge)
+	}
+	unsealed, expected := s.MemberCount()
+	for _, pod := range s.Pods {
+		if state := pod.State(); state != Unsealed {
+			return fmt.Errorf("pod %s is %s, %s", pod.Pod, state, members(unsealed, expected))
+		}
+	}
+	// Replicas with no pod yet, or whose pod has no IP, are missing members too
+	if unsealed < expected {
+		return fmt.Errorf("only %s", members(unsealed, expected))
+	}
+
+	return nil
+}
+
+// MemberCount returns how many pods are unsealed, and how many members the cluster is expected
+// to have: the replicas of the StatefulSet when known, or else the pods found
+func (s Snapshot) MemberCount() (unsealed, expected int) {
+	for _, pod := range s.Pods {
+		if pod.State() == Unsealed {
+			unsealed++
+		}
+	}
+	expected = len(s.Pods)
+	if s.Replicas > 0 {
+		expected = s.Replicas
+	}
+
+	return unsealed, expected
+}
+
+// members describes how many of the expected members are unsealed
+func members(unsealed, expected int) string {
+	return fmt.Sprintf("%d/%d members unsealed", unsealed, expected)
+}
+
+// Summary is a compact view of a snapshot, for dashboards polling many controllers
+type Summary struct {
+	Namespace string `json:"namespace,omitempty"`
+	Pods      int    `json:"pods"`
+	// Replicas and Members are those of the snapshot
+	Replicas int    `json:"replicas,omitempty"`
+	Members  string `json:"members,omitempty"`
+	// States counts the pods in each state, including states no pod is in
+	States map[string]int `json:"states"`
+	Active string         `json:"active,omitempty"`
+	Phase  Phase          `json:"phase,omitempty"`
+	// Warnings counts the warnings
+	Warnings int `json:"warnings"`
+	// UpdatedAt is when a pod was last updated, zero when there are none
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+}
+
+// Summary counts the pods of the snapshot by state
+func (s Snapshot) Summary() Summary {
+	summary := Summary{
+		Namespace: s.Namespace,
+		Pods:      len(s.Pods),
+		Replicas:  s.Replicas,
+		Members:   s.Members,
+		States:    map[string]int{Unsealed: 0, Sealed: 0, Uninitialized: 0, Unreachable: 0},
+		Active:    s.Active,
+		Phase:     s.Phase,
+		Warnings:  len(s.Warnings),
+	}
+	for _, pod := range s.Pods {
+		summary.States[pod.State()]++
+		if pod.UpdatedAt.After(summary.UpdatedAt) {
+			summary.UpdatedAt = pod.UpdatedAt
+		}
+	}
+
+	return summary
+}
+
+// InNamespace returns the snapshot when its pods run in namespace, or an empty snapshot of
+// namespace otherwise
+func (s Snapshot) InNamespace(namespace string) Snapshot {
+	if s.Namespace == namespace {
+		return s
+	}
+
+	return Snapshot{Namespace: namespace, Pods: []Pod{}}
+}
+
+// Store holds the latest state of every Vault pod
+type Store struct {
+	mu         sync.RWMutex
+	namespace  string
+	pods       map[string]*Pod
+	active     string
+	checkedAt  time.Time
+	phase      Phase
+	phaseSince time.Time
+	coldStart  *ColdStart
+	canary     *Canary
+	raft       *Raft
+	backup     *Backup
+	replicas   int
+	// warnings holds the current warnings of each source
+	warnings map[string][]string
+}
+
+// NewStore creates an empty store
+func NewStore() *Store {
+	return &Store{pods: make(map[string]*Pod), warnings: make(map[string][]string)}
+}
+
+// SetNamespace records the Vault namespace the pods run in
+func (s *Store) SetNamespace(namespace string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.namespace = namespace
+}
+
+// Update applies fn to the state of pod, creating it if needed
+func (s *Store) Update(pod string, fn func(p *Pod)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.pods[pod]
+	if !ok {
+		p = &Pod{Pod: pod}
+		s.pods[pod] = p
+	}
+
+	fn(p)
+	p.UpdatedAt = time.Now().UTC()
+}
+
+// Pod returns a copy of the state of pod, and whether it is known
+func (s *Store) Pod(pod string) (Pod, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, ok := s.pods[pod]
+	if !ok {
+		return Pod{}, false
+	}
+
+	return *p, true
+}
+
+// SetActive records which pod is the active node, or that none is known when pod is empty
+func (s *Store) SetActive(pod string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.active = pod
+}
+
+// SetWarnings replaces the warnings of source, so each check owns and clears its own warnings
+func (s *Store) SetWarnings(source string, warnings []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(warnings) == 0 {
+		delete(s.warnings, source)
+
+		return
+	}
+	s.warnings[source] = append([]string(nil), warnings...)
+}
+
+// SetPhase records the phase of the cluster, and when it entered it if it changed
+func (s *Store) SetPhase(phase Phase) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if phase != s.phase {
+		s.phase = phase
+		s.phaseSince = time.Now().UTC()
+	}
+}
+
+// SetColdStart records the progress of a cold start, or that none is in progress when it is nil
+func (s *Store) SetColdStart(coldStart *ColdStart) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if coldStart != nil {
+		copied := *coldStart
+		coldStart = &copied
+	}
+	s.coldStart = coldStart
+}
+
+// SetCanary records the outcome of the latest read of the canary secret
+func (s *Store) SetCanary(canary Canary) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.canary = &canary
+}
+
+// SetRaft records the raft membership read in the latest pass
+func (s *Store) SetRaft(raft Raft) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	raft.Peers = append([]RaftPeer(nil), raft.Peers...)
+	s.raft = &raft
+}
+
+// SetBackup records the outcome of the latest raft snapshot backup
+func (s *Store) SetBackup(backup Backup) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.backup = &backup
+}
+
+// SetReplicas records spec.replicas of the Vault StatefulSet, or that it is unknown when
+// replicas is 0
+func (s *Store) SetReplicas(replicas int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.replicas = replicas
+}
+
+// MarkChecked records that a pass finished checking every pod
+func (s *Store) MarkChecked() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.checkedAt = time.Now().UTC()
+}
+
+// Retain drops every pod not in pods, so pods that went away stop being reported
+func (s *Store) Retain(pods []string) {
+	keep := make(map[string]bool, len(pods))
+	for _, pod := range pods {
+		keep[pod] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for pod := range s.pods {
+		if !keep[pod] {
+			delete(s.pods, pod)
+		}
+	}
+}
+
+// Snapshot returns a copy of the store, with pods sorted by name
+func (s *Store) Snapshot() Snapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snapshot := Snapshot{Namespace: s.namespace, Pods: make([]Pod, 0, len(s.pods)), Active: s.active, CheckedAt: s.checkedAt, Phase: s.phase, PhaseSince: s.phaseSince, Replicas: s.replicas}
+	if s.coldStart != nil {
+		coldStart := *s.coldStart
+		snapshot.ColdStart = &coldStart
+	}
+	if s.canary != nil {
+		canary := *s.canary
+		snapshot.Canary = &canary
+	}
+	if s.raft != nil {
+		raft := *s.raft
+		raft.Peers = append([]RaftPeer(nil), s.raft.Peers...)
+		snapshot.Raft = &raft
+	}
+	if s.backup != nil {
+		backup := *s.backup
+		snapshot.Backup = &backup
+	}
+	for _, p := range s.pods {
+		snapshot.Pods = append(snapshot.Pods, *p)
+	}
+
+	sort.Slice(snapshot.Pods, func(i, j int) bool { return snapshot.Pods[i].Pod < snapshot.Pods[j].Pod })
+	if unsealed, expected := snapshot.MemberCount(); expected > 0 {
+		snapshot.Members = members(unsealed, expected)
+	}
+
+	sources := make([]string, 0, len(s.warnings))
+	for source := range s.warnings {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		snapshot.Warnings = append(snapshot.Warnings, s.warnings[source]...)
+	}
+
+	return snapshot
+}
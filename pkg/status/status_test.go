@@ -0,0 +1,144 @@
+package status
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	s := NewStore()
+
+	s.Update("10.0.0.2", func(p *Pod) {
+		p.Reachable = true
+		p.Sealed = true
+	})
+	s.Update("10.0.0.1", func(p *Pod) {
+		p.Reachable = true
+	})
+	s.Update("10.0.0.2", func(p *Pod) {
+		p.Sealed = false
+	})
+
+	snapshot := s.Snapshot()
+	if len(snapshot.Pods) != 2 {
+		t.Fatalf("expected 2 pods, got %d", len(snapshot.Pods))
+	}
+	if snapshot.Pods[0].Pod != "10.0.0.1" || snapshot.Pods[1].Pod != "10.0.0.2" {
+		t.Errorf("expected pods sorted by name, got %v", snapshot.Pods)
+	}
+	if snapshot.Pods[1].Sealed || !snapshot.Pods[1].Reachable {
+		t.Errorf("expected updates to apply to the existing entry, got %+v", snapshot.Pods[1])
+	}
+	if snapshot.Pods[0].UpdatedAt.IsZero() {
+		t.Errorf("expected the update time to be set")
+	}
+
+	// Snapshots are copies
+	snapshot.Pods[0].Sealed = true
+	if s.Snapshot().Pods[0].Sealed {
+		t.Errorf("expected the snapshot not to alias the store")
+	}
+
+	s.Retain([]string{"10.0.0.2"})
+	if snapshot := s.Snapshot(); len(snapshot.Pods) != 1 || snapshot.Pods[0].Pod != "10.0.0.2" {
+		t.Errorf("expected only the retained pod, got %v", snapshot.Pods)
+	}
+
+	s.SetActive("10.0.0.2")
+	if active := s.Snapshot().Active; active != "10.0.0.2" {
+		t.Errorf("expected the active pod to be reported, got %q", active)
+	}
+
+	s.SetWarnings("b", []string{"second"})
+	s.SetWarnings("a", []string{"first"})
+	if warnings := s.Snapshot().Warnings; len(warnings) != 2 || warnings[0] != "first" || warnings[1] != "second" {
+		t.Errorf("expected the warnings of every source in order, got %v", warnings)
+	}
+	s.SetWarnings("a", nil)
+	if warnings := s.Snapshot().Warnings; len(warnings) != 1 || warnings[0] != "second" {
+		t.Errorf("expected the cleared source to be dropped, got %v", warnings)
+	}
+}
+
+func TestSnapshotMembers(t *testing.T) {
+	s := NewStore()
+	s.Update("10.0.0.1", func(p *Pod) {
+		p.Reachable = true
+		p.Initialized = true
+	})
+	s.Update("10.0.0.2", func(p *Pod) {
+		p.Reachable = true
+		p.Initialized = true
+		p.Sealed = true
+	})
+
+	if members := s.Snapshot().Members; members != "1/2 members unsealed" {
+		t.Errorf("expected the pods found to be the members without replicas, got %q", members)
+	}
+
+	s.SetReplicas(5)
+	snapshot := s.Snapshot()
+	if snapshot.Replicas != 5 || snapshot.Members != "1/5 members unsealed" {
+		t.Errorf("expected the members counted against the replicas, got %d replicas and %q", snapshot.Replicas, snapshot.Members)
+	}
+	if summary := snapshot.Summary(); summary.Replicas != 5 || summary.Members != snapshot.Members {
+		t.Errorf("expected the summary to carry the members, got %+v", summary)
+	}
+
+	if members := NewStore().Snapshot().Members; members != "" {
+		t.Errorf("expected no members without pods or replicas, got %q", members)
+	}
+}
+
+func TestPodState(t *testing.T) {
+	tests := []struct {
+		pod      Pod
+		expected string
+	}{
+		{pod: Pod{}, expected: Unreachable},
+		{pod: Pod{Reachable: true}, expected: Uninitialized},
+		{pod: Pod{Reachable: true, Initialized: true, Sealed: true}, expected: Sealed},
+		{pod: Pod{Reachable: true, Initialized: true}, expected: Unsealed},
+	}
+
+	for _, tt := range tests {
+		if state := tt.pod.State(); state != tt.expected {
+			t.Errorf("expected %s for %+v, got %s", tt.expected, tt.pod, state)
+		}
+	}
+}
+
+func TestSnapshotReady(t *testing.T) {
+	unsealed := Pod{Pod: "vault-0", Reachable: true, Initialized: true}
+	sealed := Pod{Pod: "vault-1", Reachable: true, Initialized: true, Sealed: true}
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		snapshot Snapshot
+		expected string
+	}{
+		{name: "never checked", snapshot: Snapshot{Pods: []Pod{unsealed}}, expected: "no pass"},
+		{name: "stale", snapshot: Snapshot{Pods: []Pod{unsealed}, CheckedAt: now.Add(-2 * time.Minute)}, expected: "last checked"},
+		{name: "sealed pod", snapshot: Snapshot{Pods: []Pod{unsealed, sealed}, CheckedAt: now}, expected: "vault-1 is sealed, 1/2 members unsealed"},
+		{name: "missing replicas", snapshot: Snapshot{Pods: []Pod{unsealed}, CheckedAt: now, Replicas: 3}, expected: "only 1/3 members unsealed"},
+		{name: "all unsealed", snapshot: Snapshot{Pods: []Pod{unsealed}, CheckedAt: now}},
+		{name: "no pods", snapshot: Snapshot{Pods: []Pod{}, CheckedAt: now}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.snapshot.Ready(time.Minute)
+			if tt.expected == "" {
+				if err != nil {
+					t.Errorf("expected ready, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("expected an error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrNoActiveNode is returned when none of the members reports being the active node
+var ErrNoActiveNode = errors.New("no active node found")
+
+// ActiveNode remembers which member of a cluster is the active node, so token-authenticated
+// operations such as key rotation, snapshots and bootstrap go straight to it rather than
+// through a standby
+type ActiveNode struct {
+	mu      sync.Mutex
+	members map[string]*Client
+	active  string
+}
+
+// NewActiveNode creates a tracker without members
+func NewActiveNode() *ActiveNode {
+	return &ActiveNode{members: make(map[string]*Client)}
+}
+
+// SetMembers replaces the unsealed members of the cluster, keyed by name. The active node is
+// forgotten when it is no longer a member.
+func (a *ActiveNode) SetMembers(members map[string]*Client) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.members = members
+	if _, ok := members[a.active]; !ok {
+		a.active = ""
+	}
+}
+
+// Active returns the name of the known active node, or an empty string when it is not known
+func (a *ActiveNode) Active() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.active
+}
+
+// Refresh confirms the known active node is still active, and asks every member through
+// sys/leader otherwise. It returns the name of the active node.
+func (a *ActiveNode) Refresh(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.active != "" && isActive(ctx, a.members[a.active]) {
+		return a.active, nil
+	}
+
+	return a.discover(ctx)
+}
+
+// Do runs op against the active node. When op fails because leadership moved mid-operation,
+// the new active node is discovered and op is retried once against it.
+func (a *ActiveNode) Do(ctx context.Context, op func(client *Client) error) error {
+	name, client, err := a.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = op(client)
+	if err == nil || (!IsConnectionError(err) && isActive(ctx, client)) {
+		return err
+	}
+
+	a.mu.Lock()
+	if a.active == name {
+		a.active = ""
+	}
+	a.mu.Unlock()
+
+	_, client, discoverErr := a.client(ctx)
+	if discoverErr != nil {
+		return fmt.Errorf("%w (active node %s lost: %v)", err, name, discoverErr)
+	}
+
+	return op(client)
+}
+
+// client returns the active node, discovering it when it is not known
+func (a *ActiveNode) client(ctx context.Context) (string, *Client, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.active == "" {
+		if _, err := a.discover(ctx); err != nil {
+			return "", nil, err
+		}
+	}
+
+	return a.active, a.members[a.active], nil
+}
+
+// discover asks every member whether it is the active node. A cluster without HA has no
+// standbys, and a single member, such as an external address, forwards to the active node
+// itself, so either is used as is. The caller holds the lock.
+func (a *ActiveNode) discover(ctx context.Context) (string, error) {
+	a.active = ""
+	if len(a.members) == 0 {
+		return "", ErrNoActiveNode
+	}
+
+	names := make([]string, 0, len(a.members))
+	for name := range a.members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []string
+	for _, name := range names {
+		leader, err := a.members[name].Leader(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+
+			continue
+		}
+		if !leader.HAEnabled || leader.IsSelf {
+			a.active = name
+
+			return name, nil
+		}
+	}
+
+	if len(names) == 1 && len(errs) == 0 {
+		a.active = names[0]
+
+		return names[0], nil
+	}
+	if len(errs) > 0 {
+		return "", fmt.Errorf("%w: %s", ErrNoActiveNode, strings.Join(errs, "; "))
+	}
+
+	return "", ErrNoActiveNode
+}
+
+// isActive reports whether client is the active node of its cluster
+func isActive(ctx context.Context, client *Client) bool {
+	if client == nil {
+		return false
+	}
+
+	leader, err := client.Leader(ctx)
+
+	return err == nil && (!leader.HAEnabled || leader.IsSelf)
+}
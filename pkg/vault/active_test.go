@@ -0,0 +1,124 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	"github.com/stretchr/testify/assert"
+)
+
+// newHACluster starts unsealed fake members, where only active reports being the active node
+func newHACluster(t *testing.T, names []string, active string) map[string]*vaulttest.Server {
+	t.Helper()
+
+	fakes := make(map[string]*vaulttest.Server, len(names))
+	for i, fake := range vaulttest.NewCluster(len(names), 1, 1) {
+		t.Cleanup(fake.Close)
+		_, err := NewClient(fake.URL).UnsealWithKey(context.Background(), fake.Keys()[0])
+		assert.NoError(t, err)
+		fake.SetHA(names[i] != active)
+		fakes[names[i]] = fake
+	}
+
+	return fakes
+}
+
+func members(fakes map[string]*vaulttest.Server) map[string]*Client {
+	clients := make(map[string]*Client, len(fakes))
+	for name, fake := range fakes {
+		clients[name] = NewClient(fake.URL)
+	}
+
+	return clients
+}
+
+func TestActiveNodeRefresh(t *testing.T) {
+	tests := []struct {
+		name           string
+		members        []string
+		active         string
+		expectedActive string
+		expectedError  error
+	}{
+		{
+			name:           "standby first",
+			members:        []string{"vault-0", "vault-1", "vault-2"},
+			active:         "vault-1",
+			expectedActive: "vault-1",
+		},
+		{
+			name:          "only standbys",
+			members:       []string{"vault-0", "vault-1"},
+			expectedError: ErrNoActiveNode,
+		},
+		{
+			name:           "single member forwards to the active node",
+			members:        []string{"https://vault.example.com"},
+			expectedActive: "https://vault.example.com",
+		},
+		{
+			name:          "no members",
+			expectedError: ErrNoActiveNode,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewActiveNode()
+			a.SetMembers(members(newHACluster(t, tt.members, tt.active)))
+
+			active, err := a.Refresh(context.Background())
+			if tt.expectedError != nil {
+				assert.True(t, errors.Is(err, tt.expectedError), "expected %v, got %v", tt.expectedError, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedActive, active)
+			assert.Equal(t, tt.expectedActive, a.Active())
+		})
+	}
+}
+
+func TestActiveNodeFollowsLeadershipChange(t *testing.T) {
+	fakes := newHACluster(t, []string{"vault-0", "vault-1"}, "vault-0")
+	a := NewActiveNode()
+	a.SetMembers(members(fakes))
+
+	active, err := a.Refresh(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "vault-0", active)
+
+	// Leadership moves while the operation runs, so the first attempt fails on the old node
+	var targets []string
+	err = a.Do(context.Background(), func(client *Client) error {
+		targets = append(targets, client.baseURL)
+		if len(targets) == 1 {
+			fakes["vault-0"].SetHA(true)
+			fakes["vault-1"].SetHA(false)
+
+			return errors.New("cannot perform this operation on a standby")
+		}
+
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{fakes["vault-0"].URL, fakes["vault-1"].URL}, targets)
+	assert.Equal(t, "vault-1", a.Active())
+
+	// An error of the operation itself is returned without a retry
+	calls := 0
+	err = a.Do(context.Background(), func(*Client) error {
+		calls++
+		return errors.New("permission denied")
+	})
+	assert.EqualError(t, err, "permission denied")
+	assert.Equal(t, 1, calls)
+
+	// The active node is forgotten once it is no longer a member
+	delete(fakes, "vault-1")
+	a.SetMembers(members(fakes))
+	assert.Equal(t, "", a.Active())
+}
@@ -0,0 +1,137 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CreateToken creates a token as a child of token. It is not retried, since a create whose
+// answer was lost still created a token.
+func (c *Client) CreateToken(ctx context.Context, token string, req TokenCreateRequest) (*TokenAuth, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	resp, err := c.do(ctx, http.MethodPost, "/v1/auth/token/create", token, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, unexpectedResponse(resp, nil)
+	}
+
+	var created struct {
+		Auth *TokenAuth `json:"auth"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if created.Auth == nil || created.Auth.ClientToken == "" {
+		return nil, fmt.Errorf("incomplete token response: no token returned")
+	}
+
+	return created.Auth, nil
+}
+
+// CreateWrappedToken creates a token like CreateToken, but has Vault response-wrap it: only a
+// single-use wrapping token valid for wrapTTL is returned, and the token itself can only be
+// read by unwrapping it, once. Wrapping needs the client's own HTTP client, so it fails for a
+// client with a Backend.
+func (c *Client) CreateWrappedToken(ctx context.Context, token string, req TokenCreateRequest, wrapTTL time.Duration) (*WrapInfo, error) {
+	if c.backend != nil {
+		return nil, fmt.Errorf("response wrapping is not supported with a custom backend")
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	header := http.Header{"X-Vault-Wrap-Ttl": []string{fmt.Sprintf("%ds", int(wrapTTL/time.Second))}}
+	resp, err := c.doHTTP(ctx, http.MethodPost, "/v1/auth/token/create", token, body, header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, unexpectedResponse(resp, nil)
+	}
+
+	var wrapped struct {
+		WrapInfo *WrapInfo `json:"wrap_info"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&wrapped); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if wrapped.WrapInfo == nil || wrapped.WrapInfo.Token == "" {
+		return nil, fmt.Errorf("incomplete token response: no wrapping token returned")
+	}
+
+	return wrapped.WrapInfo, nil
+}
+
+// EnableSecretsEngine mounts a secrets engine at path
+func (c *Client) EnableSecretsEngine(ctx context.Context, token, path string, req MountRequest) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	resp, err := c.do(ctx, http.MethodPost, "/v1/sys/mounts/"+strings.Trim(path, "/"), token, body)
+	if err != nil {
+		return fmt.Errorf("failed to enable secrets engine: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return unexpectedResponse(resp, nil)
+	}
+
+	return nil
+}
+
+// ReadSecret reads the secret at path, such as secret/data/app for a KV version 2 engine mounted
+// at secret/, and returns the data Vault answers with
+func (c *Client) ReadSecret(ctx context.Context, token, path string) (map[string]interface{}, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/v1/"+strings.Trim(path, "/"), token, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, unexpectedResponse(resp, nil)
+	}
+
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return secret.Data, nil
+}
+
+// RevokeSelf revokes token itself, along with the tokens created as its children
+func (c *Client) RevokeSelf(ctx context.Context, token string) error {
+	resp, err := c.do(ctx, http.MethodPost, "/v1/auth/token/revoke-self", token, nil)
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return unexpectedResponse(resp, nil)
+	}
+
+	return nil
+}
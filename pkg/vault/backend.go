@@ -0,0 +1,55 @@
+package vault
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+)
+
+// Backend sends the requests of a Client to Vault in place of its own HTTP client. It lets the
+// requests go through another Vault client, such as the official github.com/hashicorp/vault/api
+// one with its handling of namespaces and of the VAULT_* environment variables, whose
+// RawRequestWithContext maps onto Do directly. The operations, their retries and the decoding
+// of the responses stay the same whichever backend sends the requests.
+type Backend interface {
+	// Do sends a request to path, such as /v1/sys/seal-status, with body as its body when it is
+	// not nil and token as the Vault token when it is not empty. Bodies are JSON, except the raft
+	// snapshot RestoreRaftSnapshot sends through a backend that is no StreamBackend, which is
+	// binary; either is sent as it is. Redirects of standby nodes to the active one must be
+	// followed. A failure to reach Vault is returned as an error wrapping ErrUnreachable. The
+	// caller closes the response body.
+	Do(ctx context.Context, method, path, token string, body []byte) (*http.Response, error)
+}
+
+// StreamBackend is a Backend that also sends bodies as they are read, so RestoreRaftSnapshot
+// streams a raft snapshot through it instead of reading the snapshot into memory for Do
+type StreamBackend interface {
+	Backend
+	// DoStream sends a request like Do with the binary body open returns, which is closed once
+	// sent when it is an io.Closer. open is called again for every redirect followed, since a
+	// body cannot be sent twice.
+	DoStream(ctx context.Context, method, path, token string, open func() (io.Reader, error)) (*http.Response, error)
+}
+
+// NewBackendFunc creates the backend of a client of baseURL, which verifies an https:// address
+// with tlsConfig and tunes its connections with opts as the clients of this package do
+type NewBackendFunc func(baseURL string, tlsConfig *tls.Config, opts TransportOptions) (Backend, error)
+
+// NewClientWithBackend creates a Vault client whose requests are sent by backend. baseURL is the
+// address backend reaches Vault at, which connectivity diagnoses probe.
+func NewClientWithBackend(baseURL string, backend Backend) *Client {
+	return &Client{
+		baseURL: baseURL,
+		backend: backend,
+	}
+}
+
+// failedBackend fails every request with the error creating the backend failed with
+type failedBackend struct {
+	err error
+}
+
+func (b failedBackend) Do(context.Context, string, string, string, []byte) (*http.Response, error) {
+	return nil, b.err
+}
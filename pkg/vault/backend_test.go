@@ -0,0 +1,62 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingBackend sends requests with a plain HTTP client and records their paths
+type recordingBackend struct {
+	baseURL string
+	paths   []string
+}
+
+func (b *recordingBackend) Do(ctx context.Context, method, path, token string, body []byte) (*http.Response, error) {
+	b.paths = append(b.paths, method+" "+path)
+
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, b.baseURL+path, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+
+	return http.DefaultClient.Do(req)
+}
+
+func TestClientWithBackend(t *testing.T) {
+	fakeVault := vaulttest.NewServer()
+	defer fakeVault.Close()
+
+	backend := &recordingBackend{baseURL: fakeVault.URL}
+	client := NewClientWithBackend(fakeVault.URL, backend)
+	defer client.Close()
+
+	resp, err := client.Initialize(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, client.UnsealWithKeysFromDir(context.Background(), resp.Keys))
+
+	status, err := client.CheckStatus(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, status.Initialized)
+	assert.False(t, status.Sealed)
+
+	assert.Equal(t, "PUT /v1/sys/init", backend.paths[0])
+	assert.Equal(t, "GET /v1/sys/seal-status", backend.paths[len(backend.paths)-1])
+
+	// The HTTP stage of a diagnosis goes through the backend too
+	diagnosis := client.Diagnose(context.Background())
+	assert.Empty(t, diagnosis.Failed)
+	assert.Equal(t, "GET /v1/sys/health", backend.paths[len(backend.paths)-1])
+}
@@ -0,0 +1,132 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"sort"
+)
+
+// FetchCAChain connects to a Vault TLS listener at address (host:port) and returns the CA
+// certificates of the chain it serves, without the leaf unless it is self-signed. The handshake
+// does not verify the server, since the chain is what is being discovered; when roots is not nil
+// the served chain must verify against it instead, ignoring the hostname because Vault is usually
+// dialled by pod IP.
+func FetchCAChain(ctx context.Context, address string, roots *x509.CertPool) ([]*x509.Certificate, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q: %w", address, err)
+	}
+
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: defaultDialTimeout},
+		Config: &tls.Config{
+			ServerName:         host,
+			MinVersion:         tls.VersionTLS12,
+			InsecureSkipVerify: true, //nolint:gosec // the chain is verified below when roots are known
+		},
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	defer conn.Close()
+
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil, fmt.Errorf("unexpected connection type %T", conn)
+	}
+
+	served := tlsConn.ConnectionState().PeerCertificates
+	if len(served) == 0 {
+		return nil, fmt.Errorf("%s served no certificates", address)
+	}
+
+	if roots != nil {
+		intermediates := x509.NewCertPool()
+		for _, cert := range served[1:] {
+			intermediates.AddCert(cert)
+		}
+		if _, err := served[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates}); err != nil {
+			return nil, fmt.Errorf("chain served by %s does not verify against the configured CA: %w", address, err)
+		}
+	}
+
+	var chain []*x509.Certificate
+	for i, cert := range served {
+		if !cert.IsCA {
+			continue
+		}
+		if i == 0 && !bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+			continue
+		}
+		chain = append(chain, cert)
+	}
+
+	return chain, nil
+}
+
+// EncodeCABundle returns the certificates as a PEM bundle, deduplicated and in a stable order so
+// the same set of certificates always encodes to the same bundle
+func EncodeCABundle(certs []*x509.Certificate) string {
+	seen := make(map[string]bool, len(certs))
+	var unique []*x509.Certificate
+	for _, cert := range certs {
+		if seen[string(cert.Raw)] {
+			continue
+		}
+		seen[string(cert.Raw)] = true
+		unique = append(unique, cert)
+	}
+
+	sort.Slice(unique, func(i, j int) bool { return bytes.Compare(unique[i].Raw, unique[j].Raw) < 0 })
+
+	var bundle []byte
+	for _, cert := range unique {
+		bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+
+	return string(bundle)
+}
+
+// NewCertPool returns a pool trusting the given certificates
+func NewCertPool(certs []*x509.Certificate) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+
+	return pool
+}
+
+// ParseCABundle parses the certificates of a PEM bundle
+func ParseCABundle(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found")
+	}
+
+	return certs, nil
+}
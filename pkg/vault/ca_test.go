@@ -0,0 +1,134 @@
+package vault
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestCA returns a self-signed CA certificate
+func newTestCA(t *testing.T, name string) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+func TestFetchCAChain(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "https://")
+
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer plain.Close()
+
+	trusted := x509.NewCertPool()
+	trusted.AddCert(server.Certificate())
+	other := x509.NewCertPool()
+	other.AddCert(newTestCA(t, "other"))
+
+	tests := []struct {
+		name          string
+		address       string
+		roots         *x509.CertPool
+		expectedError string
+	}{
+		{
+			name:    "discovered without roots",
+			address: address,
+		},
+		{
+			name:    "verified against roots",
+			address: address,
+			roots:   trusted,
+		},
+		{
+			name:          "not signed by roots",
+			address:       address,
+			roots:         other,
+			expectedError: "does not verify",
+		},
+		{
+			name:          "listener without TLS",
+			address:       strings.TrimPrefix(plain.URL, "http://"),
+			expectedError: "failed to connect",
+		},
+		{
+			name:          "invalid address",
+			address:       "vault",
+			expectedError: "invalid address",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain, err := FetchCAChain(context.Background(), tt.address, tt.roots)
+			if tt.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+					t.Fatalf("expected error containing %q, got %v", tt.expectedError, err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(chain) != 1 || !chain[0].Equal(server.Certificate()) {
+				t.Errorf("expected the self-signed server certificate, got %d certificates", len(chain))
+			}
+		})
+	}
+}
+
+func TestEncodeCABundle(t *testing.T) {
+	first := newTestCA(t, "first")
+	second := newTestCA(t, "second")
+
+	bundle := EncodeCABundle([]*x509.Certificate{first, second, first})
+	if bundle != EncodeCABundle([]*x509.Certificate{second, first}) {
+		t.Errorf("expected the bundle not to depend on order or duplicates")
+	}
+
+	certs, err := ParseCABundle([]byte(bundle))
+	if err != nil {
+		t.Fatalf("failed to parse bundle: %v", err)
+	}
+	if len(certs) != 2 {
+		t.Errorf("expected 2 certificates, got %d", len(certs))
+	}
+
+	if _, err := ParseCABundle([]byte("not a certificate")); err == nil {
+		t.Errorf("expected an error for a bundle without certificates")
+	}
+}
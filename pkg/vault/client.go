@@ -2,40 +2,324 @@ package vault
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/httplog"
 )
 
 const (
-	defaultSecretShares    = 5
-	defaultSecretThreshold = 3
+	// DefaultSecretShares and DefaultSecretThreshold are the seal configuration Initialize
+	// gives a Shamir-sealed Vault
+	DefaultSecretShares    = 5
+	DefaultSecretThreshold = 3
+
+	defaultDialTimeout         = 5 * time.Second
+	defaultKeepAlive           = 30 * time.Second
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultMaxIdleConnsPerHost = 4
+	maxRedirects               = 10
 )
 
 // Client represents a Vault client for managing Vault operations
 type Client struct {
 	httpClient *http.Client
 	baseURL    string
+	// redirect rewrites the target of a standby redirect before it is followed, or is nil to
+	// follow redirects as given
+	redirect func(location *url.URL) *url.URL
+	// retry is how requests failing with a transient error are retried
+	retry RetryPolicy
+	// backend sends the requests instead of httpClient when set
+	backend Backend
 }
 
-// NewClient creates a new Vault client
+// NewClient creates a new Vault client with its own connection pool, so keep-alive
+// connections to the endpoint are reused for as long as the client is
 func NewClient(baseURL string) *Client {
+	return NewClientWithTLS(baseURL, nil)
+}
+
+// NewClientWithTLS creates a new Vault client like NewClient that verifies an https:// endpoint
+// with tlsConfig, such as one from NewTLSConfig. The system roots are used when it is nil.
+func NewClientWithTLS(baseURL string, tlsConfig *tls.Config) *Client {
+	return NewClientWithOptions(baseURL, tlsConfig, TransportOptions{})
+}
+
+// TransportOptions tune the connections of a client to its endpoint. Zero fields take the
+// defaults.
+type TransportOptions struct {
+	// DialTimeout bounds establishing a connection, so an unreachable pod fails fast
+	DialTimeout time.Duration
+	// MaxIdleConnsPerHost is how many keep-alive connections to the endpoint are kept open
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout is how long an unused keep-alive connection is kept open
+	IdleConnTimeout time.Duration
+}
+
+// NewClientWithOptions creates a new Vault client like NewClientWithTLS whose connections are
+// tuned by opts. Requests are bounded by their context rather than by the client, since init
+// must never be abandoned halfway.
+func NewClientWithOptions(baseURL string, tlsConfig *tls.Config, opts TransportOptions) *Client {
+	return &Client{
+		httpClient: NewHTTPClient(tlsConfig, opts),
+		baseURL:    baseURL,
+	}
+}
+
+// NewHTTPClient returns the HTTP client a Client reaches Vault with, for a Backend to send its
+// requests the same way. It verifies https:// addresses with tlsConfig, or the system roots when
+// it is nil, tunes its connections with opts and hands redirects back instead of following them.
+func NewHTTPClient(tlsConfig *tls.Config, opts TransportOptions) *http.Client {
+	transport := newTransport(opts)
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig.Clone()
+	}
+
+	return &http.Client{
+		Transport:     httplog.Wrap("vault", transport),
+		CheckRedirect: returnRedirect,
+	}
+}
+
+// NewTLSConfig returns the TLS configuration for Vault listeners served with a certificate from
+// roots. Pods are dialled by IP, which their certificates rarely name, so serverName, such as
+// the name of the Vault Service, is verified instead when it is set. clientCert, when not nil,
+// is presented to listeners requiring client certificates.
+func NewTLSConfig(roots *x509.CertPool, serverName string, clientCert *ClientCertificate) *tls.Config {
+	tlsConfig := &tls.Config{
+		RootCAs:    roots,
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if clientCert != nil {
+		tlsConfig.GetClientCertificate = clientCert.getClientCertificate
+	}
+
+	return tlsConfig
+}
+
+// NewClientWithHTTPClient creates a new Vault client that sends its requests through the given
+// HTTP client, e.g. one that reaches Vault through the Kubernetes API server proxy
+func NewClientWithHTTPClient(baseURL string, httpClient *http.Client) *Client {
 	return &Client{
-		httpClient: &http.Client{},
+		httpClient: httpClient,
 		baseURL:    baseURL,
 	}
 }
 
+// NewExternalClient creates a Vault client for an address in front of the cluster, such as an
+// Ingress or LoadBalancer, for a controller running outside the cluster network. A standby
+// answering through it redirects to the active node's api_addr, which is usually only reachable
+// inside the cluster, so redirects are sent back through baseURL instead. tlsConfig verifies the
+// address's certificate, the system roots are used when it is nil, and opts tune the connections.
+func NewExternalClient(baseURL string, tlsConfig *tls.Config, opts TransportOptions) (*Client, error) {
+	external, err := url.Parse(baseURL)
+	if err != nil || external.Host == "" {
+		return nil, fmt.Errorf("invalid external address %q", baseURL)
+	}
+
+	client := NewClientWithOptions(strings.TrimRight(baseURL, "/"), tlsConfig, opts)
+	client.redirect = func(location *url.URL) *url.URL {
+		through := *location
+		through.Scheme = external.Scheme
+		through.Host = external.Host
+
+		return &through
+	}
+
+	return client, nil
+}
+
+// returnRedirect hands redirects back to the caller, so doHTTP can follow them itself
+func returnRedirect(*http.Request, []*http.Request) error {
+	return http.ErrUseLastResponse
+}
+
+// do sends a request to path through the client's backend, or through its own HTTP client when
+// it has none
+func (c *Client) do(ctx context.Context, method, path, token string, body []byte) (*http.Response, error) {
+	if c.backend != nil {
+		return c.backend.Do(ctx, method, path, token, body)
+	}
+
+	return c.doHTTP(ctx, method, path, token, body, nil)
+}
+
+// doHTTP sends a request to path and follows the redirects of a standby to the active node.
+// Every hop is sent with the method, body, token and header of the original request, so writes
+// such as init, rekey and token operations succeed when a standby answers first. Cancelling ctx
+// aborts the request, including a response that is still being read.
+func (c *Client) doHTTP(ctx context.Context, method, path, token string, body []byte, header http.Header) (*http.Response, error) {
+	return c.follow(ctx, path, token, header, func(target string) (*http.Request, error) {
+		var reqBody io.Reader
+		if body != nil {
+			reqBody = bytes.NewReader(body)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, target, reqBody)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		return req, nil
+	})
+}
+
+// doStream sends a request to path like doHTTP, with the body open returns. open is called again
+// for every hop, so a large body, such as a raft snapshot, streams from where it is kept rather
+// than being held in memory. A failure to read the body is returned as it is, rather than as
+// Vault being unreachable.
+func (c *Client) doStream(ctx context.Context, method, path, token string, open func() (io.Reader, error), header http.Header) (*http.Response, error) {
+	var body *trackedReader
+	resp, err := c.follow(ctx, path, token, header, func(target string) (*http.Request, error) {
+		r, err := open()
+		if err != nil {
+			return nil, err
+		}
+		body = &trackedReader{r: r}
+
+		req, err := http.NewRequestWithContext(ctx, method, target, body)
+		if err != nil {
+			body.Close()
+
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+
+		return req, nil
+	})
+	if err != nil && body != nil && body.err != nil {
+		return nil, body.err
+	}
+
+	return resp, err
+}
+
+// follow sends the request newRequest creates for a target URL, with token and header, and
+// follows redirects by creating the request again for the location
+func (c *Client) follow(ctx context.Context, path, token string, header http.Header, newRequest func(target string) (*http.Request, error)) (*http.Response, error) {
+	target, err := url.Parse(c.baseURL + path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request URL: %w", err)
+	}
+
+	for hops := 0; ; hops++ {
+		req, err := newRequest(target.String())
+		if err != nil {
+			return nil, err
+		}
+		if token != "" {
+			req.Header.Set("X-Vault-Token", token)
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, &unreachableError{err: err}
+		}
+		if resp.StatusCode != http.StatusTemporaryRedirect && resp.StatusCode != http.StatusPermanentRedirect {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		if hops == maxRedirects {
+			return nil, fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+
+		location, err := resp.Location()
+		if err != nil {
+			return nil, fmt.Errorf("redirect from %s without a valid location: %w", target.Host, err)
+		}
+		if c.redirect != nil {
+			location = c.redirect(location)
+		}
+		target = location
+	}
+}
+
+// trackedReader is a request body remembering the error reading it failed with, and closing
+// what it reads from once sent
+type trackedReader struct {
+	r   io.Reader
+	err error
+}
+
+func (t *trackedReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if err != nil && err != io.EOF {
+		t.err = err
+	}
+
+	return n, err
+}
+
+func (t *trackedReader) Close() error {
+	if closer, ok := t.r.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// newTransport creates the HTTP transport used for a single Vault endpoint
+func newTransport(opts TransportOptions) *http.Transport {
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = defaultDialTimeout
+	}
+	if opts.MaxIdleConnsPerHost <= 0 {
+		opts.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+	if opts.IdleConnTimeout <= 0 {
+		opts.IdleConnTimeout = defaultIdleConnTimeout
+	}
+
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   opts.DialTimeout,
+			KeepAlive: defaultKeepAlive,
+		}).DialContext,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConnsPerHost: opts.MaxIdleConnsPerHost,
+		IdleConnTimeout:     opts.IdleConnTimeout,
+	}
+}
+
+// Close releases the idle connections held by the client
+func (c *Client) Close() {
+	if closer, ok := c.backend.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+	if c.httpClient == nil {
+		return
+	}
+	c.httpClient.CloseIdleConnections()
+}
+
 // CheckStatus queries the Vault health endpoint
-func (c *Client) CheckStatus() (*Status, error) {
-	resp, err := c.httpClient.Get(fmt.Sprintf("%s/v1/sys/seal-status", c.baseURL))
+func (c *Client) CheckStatus(ctx context.Context) (*Status, error) {
+	resp, err := c.doWithRetry(ctx, http.MethodGet, "/v1/sys/seal-status", "", nil, transientFailure)
 	if err != nil {
 		return nil, fmt.Errorf("failed to check status: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, unexpectedResponse(resp, nil)
 	}
 
 	var status Status
@@ -46,32 +330,33 @@ func (c *Client) CheckStatus() (*Status, error) {
 	return &status, nil
 }
 
-// Initialize initializes a new Vault instance
-func (c *Client) Initialize() (*InitResponse, error) {
-	req := InitRequest{
-		SecretShares:    defaultSecretShares,
-		SecretThreshold: defaultSecretThreshold,
-	}
+// Initialize initializes a new Shamir-sealed Vault instance with the default seal configuration
+func (c *Client) Initialize(ctx context.Context) (*InitResponse, error) {
+	return c.InitializeWithRequest(ctx, InitRequest{
+		SecretShares:    DefaultSecretShares,
+		SecretThreshold: DefaultSecretThreshold,
+	})
+}
 
+// InitializeWithRequest initializes Vault with the seal configuration of req. Initializing an
+// auto-unseal Vault needs RecoveryShares and RecoveryThreshold, and returns RecoveryKeys rather
+// than Keys.
+func (c *Client) InitializeWithRequest(ctx context.Context, req InitRequest) (*InitResponse, error) {
 	body, err := json.Marshal(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	httpReq, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/v1/sys/init", c.baseURL), bytes.NewReader(body))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-	httpReq.Header.Set("Content-Type", "application/json")
-
-	resp, err := c.httpClient.Do(httpReq)
+	// A failed init may still have generated keys that were never returned, so it is only
+	// retried when it never reached Vault
+	resp, err := c.doWithRetry(ctx, http.MethodPut, "/v1/sys/init", "", body, notSent)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, unexpectedResponse(resp, nil)
 	}
 
 	var initResp InitResponse
@@ -79,46 +364,363 @@ func (c *Client) Initialize() (*InitResponse, error) {
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
+	// Storing an empty key set would leave the cluster impossible to unseal or recover, so treat
+	// it as a failed init rather than a success
+	keys := initResp.Keys
+	if req.RecoveryShares > 0 {
+		keys = initResp.RecoveryKeys
+	}
+	if len(keys) == 0 || initResp.RootToken == "" {
+		return nil, fmt.Errorf("incomplete init response: %d keys, %d recovery keys, root token present: %t", len(initResp.Keys), len(initResp.RecoveryKeys), initResp.RootToken != "")
+	}
+
 	return &initResp, nil
 }
 
-// UnsealWithKey applies a single unseal key to the Vault
-func (c *Client) UnsealWithKey(key string) error {
-	req := map[string]string{"key": key}
+// Unseal applies a single unseal key to the Vault and returns the seal status it answers with,
+// whose Progress and Threshold tell how many more keys are needed. Sealed is false once Vault
+// opened.
+func (c *Client) Unseal(ctx context.Context, key string) (*Status, error) {
+	return c.unseal(ctx, key, false)
+}
+
+// UnsealMigrate applies a single key to a Vault waiting for its seal to be migrated, as reported
+// by Status.Migration: an unseal key when it moves from Shamir to auto-unseal, a recovery key
+// when it moves back. Vault migrates the seal once it has enough keys, and the keys then serve
+// the new seal, as recovery keys or unseal keys.
+func (c *Client) UnsealMigrate(ctx context.Context, key string) (*Status, error) {
+	return c.unseal(ctx, key, true)
+}
+
+func (c *Client) unseal(ctx context.Context, key string, migrate bool) (*Status, error) {
+	req := map[string]interface{}{"key": key}
+	if migrate {
+		req["migrate"] = true
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// Vault ignores a key it was already given in the current attempt, so unseal is safe to repeat
+	resp, err := c.doWithRetry(ctx, http.MethodPost, "/v1/sys/unseal", "", body, transientFailure)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unseal: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, unexpectedResponse(resp, ErrInvalidKey)
+	}
+
+	var status Status
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &status, nil
+}
+
+// UnsealWithKey applies a single unseal key to the Vault and returns how many keys the attempt
+// has and needs. Vault still being sealed is not an error, it just means more keys are needed.
+func (c *Client) UnsealWithKey(ctx context.Context, key string) (*UnsealProgress, error) {
+	status, err := c.Unseal(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return status.UnsealProgress(), nil
+}
+
+// Leader queries the Vault leader endpoint to find out whether this node is the active one
+func (c *Client) Leader(ctx context.Context) (*LeaderResponse, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/v1/sys/leader", "", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query leader: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, unexpectedResponse(resp, nil)
+	}
+
+	var leader LeaderResponse
+	if err := json.NewDecoder(resp.Body).Decode(&leader); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &leader, nil
+}
+
+// Health queries sys/health. Vault tells its states apart by status code, so the response of
+// every state is decoded rather than only 200.
+func (c *Client) Health(ctx context.Context) (*HealthResponse, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/v1/sys/health", "", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query health: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusTooManyRequests, 472, 473, http.StatusNotImplemented, http.StatusServiceUnavailable:
+	default:
+		return nil, unexpectedResponse(resp, nil)
+	}
+
+	var health HealthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &health, nil
+}
+
+// agentPaths are served by Vault Agent and Vault Proxy themselves and by no Vault server
+var agentPaths = []string{"/agent/v1/metrics", "/proxy/v1/metrics"}
+
+// ServedByAgent reports whether the endpoint is a Vault Agent or Vault Proxy rather than a Vault
+// server. Both forward the API to a server, so seal-status looks like a server's, but requests
+// may reach a different server than the pod's own. An agent answers on its own API, or marks
+// the responses of its cache with X-Cache, which a server never sets.
+func (c *Client) ServedByAgent(ctx context.Context) (bool, error) {
+	for _, path := range agentPaths {
+		resp, err := c.do(ctx, http.MethodGet, path, "", nil)
+		if err != nil {
+			return false, fmt.Errorf("failed to probe %s: %w", path, err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusOK || resp.Header.Get("X-Cache") != "" {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// ClockSkew returns how far Vault's clock is ahead of the local one, negative when it is behind.
+// Vault reports its time in whole seconds, so the result is accurate to about half a second.
+func (c *Client) ClockSkew(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	health, err := c.Health(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if health.ServerTimeUTC == 0 {
+		return 0, fmt.Errorf("health response has no server time")
+	}
+
+	// Vault read its clock somewhere in the round trip, most likely around the middle, and
+	// truncated it to the second
+	local := start.Add(time.Since(start) / 2)
+	server := time.Unix(health.ServerTimeUTC, 0).Add(time.Second / 2)
+
+	return server.Sub(local), nil
+}
+
+// StepDown forces the active node to give up leadership so a standby takes over
+func (c *Client) StepDown(ctx context.Context, token string) error {
+	resp, err := c.do(ctx, http.MethodPut, "/v1/sys/step-down", token, nil)
+	if err != nil {
+		return fmt.Errorf("failed to step down: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return unexpectedResponse(resp, nil)
+	}
+
+	return nil
+}
+
+// RaftJoin asks an uninitialized Vault using integrated storage to join the raft cluster of
+// another member. A Shamir-sealed Vault only completes the join once it is unsealed with the
+// cluster's keys.
+func (c *Client) RaftJoin(ctx context.Context, req RaftJoinRequest) error {
 	body, err := json.Marshal(req)
 	if err != nil {
 		return fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	resp, err := c.httpClient.Post(fmt.Sprintf("%s/v1/sys/unseal", c.baseURL), "application/json", bytes.NewReader(body))
+	resp, err := c.do(ctx, http.MethodPost, "/v1/sys/storage/raft/join", "", body)
 	if err != nil {
-		return fmt.Errorf("failed to unseal: %w", err)
+		return fmt.Errorf("failed to join raft cluster: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return unexpectedResponse(resp, nil)
 	}
 
-	var unsealResp UnsealResponse
-	if err := json.NewDecoder(resp.Body).Decode(&unsealResp); err != nil {
+	var joinResp struct {
+		Joined bool `json:"joined"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&joinResp); err != nil {
 		return fmt.Errorf("failed to decode response: %w", err)
 	}
+	if !joinResp.Joined {
+		return fmt.Errorf("vault did not join the raft cluster at %s", req.LeaderAPIAddr)
+	}
+
+	return nil
+}
+
+// RaftConfiguration lists the members of the raft cluster of a Vault using integrated storage.
+// Reading it takes a token allowed to read sys/storage/raft/configuration.
+func (c *Client) RaftConfiguration(ctx context.Context, token string) ([]RaftServer, error) {
+	resp, err := c.doWithRetry(ctx, http.MethodGet, "/v1/sys/storage/raft/configuration", token, nil, transientFailure)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read raft configuration: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, unexpectedResponse(resp, nil)
+	}
+
+	var configResp struct {
+		Data struct {
+			Config struct {
+				Servers []RaftServer `json:"servers"`
+			} `json:"config"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&configResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return configResp.Data.Config.Servers, nil
+}
+
+// RaftSnapshot streams a snapshot of the raft storage to w and returns its size. It takes a
+// token allowed to read sys/storage/raft/snapshot. A standby answers with a redirect to the
+// active node, which is followed.
+func (c *Client) RaftSnapshot(ctx context.Context, token string, w io.Writer) (int64, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/v1/sys/storage/raft/snapshot", token, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to take raft snapshot: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, unexpectedResponse(resp, nil)
+	}
+
+	n, err := io.Copy(w, resp.Body)
+	if err != nil {
+		return n, fmt.Errorf("failed to read raft snapshot: %w", err)
+	}
+
+	return n, nil
+}
+
+// RestoreRaftSnapshot replaces the data of the raft cluster with the snapshot open returns. It
+// takes a token allowed to update sys/storage/raft/snapshot, or sys/storage/raft/snapshot-force
+// with force, which a snapshot of another cluster needs. The snapshot is streamed to Vault, and
+// open is called again when a standby redirects the request, so a snapshot of several GiB is
+// never held in memory. So it is through a StreamBackend; any other Backend takes the snapshot
+// whole, read into memory. Restoring replaces the data, so it is not retried.
+func (c *Client) RestoreRaftSnapshot(ctx context.Context, token string, open func() (io.Reader, error), force bool) error {
+	path := "/v1/sys/storage/raft/snapshot"
+	if force {
+		path += "-force"
+	}
+
+	var resp *http.Response
+	var err error
+	if streamer, ok := c.backend.(StreamBackend); ok {
+		resp, err = streamer.DoStream(ctx, http.MethodPost, path, token, open)
+	} else if c.backend != nil {
+		var snapshot []byte
+		if snapshot, err = readAll(open); err != nil {
+			return fmt.Errorf("failed to read raft snapshot: %w", err)
+		}
+		resp, err = c.backend.Do(ctx, http.MethodPost, path, token, snapshot)
+	} else {
+		resp, err = c.doStream(ctx, http.MethodPost, path, token, open, http.Header{"Content-Type": {"application/octet-stream"}})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to restore raft snapshot: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return unexpectedResponse(resp, nil)
+	}
+
+	return nil
+}
+
+// readAll reads what open returns, and closes it
+func readAll(open func() (io.Reader, error)) ([]byte, error) {
+	r, err := open()
+	if err != nil {
+		return nil, err
+	}
+	if closer, ok := r.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	return io.ReadAll(r)
+}
+
+// RemoveRaftPeer removes a server from the raft cluster by node ID. It takes a token allowed to
+// update sys/storage/raft/remove-peer.
+func (c *Client) RemoveRaftPeer(ctx context.Context, token, nodeID string) error {
+	body, err := json.Marshal(map[string]string{"server_id": nodeID})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// Removing a peer that is already gone succeeds, so it is retried like a read
+	resp, err := c.doWithRetry(ctx, http.MethodPost, "/v1/sys/storage/raft/remove-peer", token, body, transientFailure)
+	if err != nil {
+		return fmt.Errorf("failed to remove raft peer %s: %w", nodeID, err)
+	}
+	defer resp.Body.Close()
 
-	// If the vault is still sealed, this is not an error - it just means we need more keys
-	if unsealResp.Sealed {
-		return nil
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return unexpectedResponse(resp, nil)
 	}
 
 	return nil
 }
 
-// UnsealWithKeysFromDir unseals Vault using keys from a directory
-func (c *Client) UnsealWithKeysFromDir(keys []string) error {
+// SetAutopilotConfiguration applies the autopilot configuration of a raft cluster. It takes a
+// token allowed to update sys/storage/raft/autopilot/configuration.
+func (c *Client) SetAutopilotConfiguration(ctx context.Context, token string, config AutopilotConfig) error {
+	body, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// Writing the same configuration twice is harmless, so it is retried like a read
+	resp, err := c.doWithRetry(ctx, http.MethodPost, "/v1/sys/storage/raft/autopilot/configuration", token, body, transientFailure)
+	if err != nil {
+		return fmt.Errorf("failed to configure autopilot: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return unexpectedResponse(resp, nil)
+	}
+
+	return nil
+}
+
+// UnsealWithKeysFromDir unseals Vault using keys from a directory. It stops as soon as Vault
+// opens, so any m-of-n split works, and fails when Vault is still sealed after the last key.
+func (c *Client) UnsealWithKeysFromDir(ctx context.Context, keys []string) error {
 	for _, key := range keys {
-		if err := c.UnsealWithKey(key); err != nil {
+		status, err := c.Unseal(ctx, key)
+		if err != nil {
 			return fmt.Errorf("failed to unseal with key: %w", err)
 		}
+		if !status.Sealed {
+			return nil
+		}
 	}
-	return nil
+
+	return fmt.Errorf("vault is still sealed after %d keys", len(keys))
 }
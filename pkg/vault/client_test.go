@@ -1,14 +1,20 @@
 package vault
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -27,6 +33,25 @@ func (t *mockTransport) RoundTrip(*http.Request) (*http.Response, error) {
 	return response, nil
 }
 
+func TestStatusAutoUnseal(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   Status
+		expected bool
+	}{
+		{name: "shamir", status: Status{Type: "shamir"}, expected: false},
+		{name: "awskms", status: Status{Type: "awskms", RecoverySeal: true}, expected: true},
+		{name: "transit without recovery seal reported", status: Status{Type: "transit"}, expected: true},
+		{name: "no seal type", status: Status{}, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.status.AutoUnseal())
+		})
+	}
+}
+
 func TestCheckStatus(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -48,6 +73,37 @@ func TestCheckStatus(t *testing.T) {
 				Sealed:      false,
 			},
 		},
+		{
+			name:       "success - full seal status",
+			statusCode: http.StatusOK,
+			responseBody: `{
+				"type": "shamir",
+				"initialized": true,
+				"sealed": true,
+				"t": 3,
+				"n": 5,
+				"progress": 1,
+				"nonce": "2c8a2d0a-0f5e-4c3a-9b8e-5e3c8f1f0f1a",
+				"version": "1.15.0",
+				"build_date": "2023-09-22T16:53:10Z",
+				"migration": false,
+				"recovery_seal": false,
+				"storage_type": "raft"
+			}`,
+			expectedError: false,
+			expectedStatus: &Status{
+				Type:        "shamir",
+				Initialized: true,
+				Sealed:      true,
+				Threshold:   3,
+				Shares:      5,
+				Progress:    1,
+				Nonce:       "2c8a2d0a-0f5e-4c3a-9b8e-5e3c8f1f0f1a",
+				Version:     "1.15.0",
+				BuildDate:   "2023-09-22T16:53:10Z",
+				StorageType: "raft",
+			},
+		},
 		{
 			name:          "error - API not found",
 			statusCode:    http.StatusNotFound,
@@ -80,7 +136,7 @@ func TestCheckStatus(t *testing.T) {
 			defer server.Close()
 
 			client := NewClient(server.URL)
-			status, err := client.CheckStatus()
+			status, err := client.CheckStatus(context.Background())
 
 			if tt.expectedError && err == nil {
 				t.Error("Expected error but got none")
@@ -153,11 +209,11 @@ func TestInitialize(t *testing.T) {
 					t.Errorf("Error decoding request body: %v", err)
 				}
 
-				if req.SecretShares != defaultSecretShares {
-					t.Errorf("Expected secret_shares=%d, got %d", defaultSecretShares, req.SecretShares)
+				if req.SecretShares != DefaultSecretShares {
+					t.Errorf("Expected secret_shares=%d, got %d", DefaultSecretShares, req.SecretShares)
 				}
-				if req.SecretThreshold != defaultSecretThreshold {
-					t.Errorf("Expected secret_threshold=%d, got %d", defaultSecretThreshold, req.SecretThreshold)
+				if req.SecretThreshold != DefaultSecretThreshold {
+					t.Errorf("Expected secret_threshold=%d, got %d", DefaultSecretThreshold, req.SecretThreshold)
 				}
 
 				w.WriteHeader(tt.statusCode)
@@ -166,7 +222,7 @@ func TestInitialize(t *testing.T) {
 			defer server.Close()
 
 			client := NewClient(server.URL)
-			resp, err := client.Initialize()
+			resp, err := client.Initialize(context.Background())
 
 			if tt.expectedError && err == nil {
 				t.Error("Expected error but got none")
@@ -193,19 +249,32 @@ func TestInitialize(t *testing.T) {
 
 func TestUnsealWithKey(t *testing.T) {
 	tests := []struct {
-		name            string
-		serverResponses []*http.Response
-		expectError     bool
+		name             string
+		serverResponses  []*http.Response
+		expectError      bool
+		expectedProgress *UnsealProgress
 	}{
 		{
 			name: "success",
 			serverResponses: []*http.Response{
 				{
 					StatusCode: http.StatusOK,
-					Body:       io.NopCloser(strings.NewReader(`{"sealed": false}`)),
+					Body:       io.NopCloser(strings.NewReader(`{"sealed": false, "t": 3, "n": 5}`)),
 				},
 			},
-			expectError: false,
+			expectError:      false,
+			expectedProgress: &UnsealProgress{Sealed: false, Threshold: 3},
+		},
+		{
+			name: "success - more keys needed",
+			serverResponses: []*http.Response{
+				{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader(`{"sealed": true, "t": 3, "n": 5, "progress": 1}`)),
+				},
+			},
+			expectError:      false,
+			expectedProgress: &UnsealProgress{Sealed: true, Progress: 1, Threshold: 3},
 		},
 		{
 			name: "error - server error",
@@ -231,17 +300,230 @@ func TestUnsealWithKey(t *testing.T) {
 				},
 			}
 
-			err := client.UnsealWithKey("test-key")
+			progress, err := client.UnsealWithKey(context.Background(), "test-key")
 			if tt.expectError {
 				assert.Error(t, err)
 				return
 			}
 
 			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedProgress, progress)
 		})
 	}
 }
 
+func TestUnsealWithFakeVault(t *testing.T) {
+	wrongShare := strings.Repeat("ab", 33)
+
+	tests := []struct {
+		name             string
+		shares           int
+		threshold        int
+		keys             func(shares []string) []string
+		expectError      bool
+		expectedSealed   bool
+		expectedProgress int
+	}{
+		{
+			name:      "threshold reached unseals",
+			shares:    5,
+			threshold: 3,
+			keys: func(shares []string) []string {
+				return shares[:3]
+			},
+			expectedSealed: false,
+		},
+		{
+			name:      "partial unseal tracks progress",
+			shares:    5,
+			threshold: 3,
+			keys: func(shares []string) []string {
+				return shares[:2]
+			},
+			expectedSealed:   true,
+			expectedProgress: 2,
+		},
+		{
+			name:      "duplicate share does not count",
+			shares:    5,
+			threshold: 3,
+			keys: func(shares []string) []string {
+				return []string{shares[0], shares[0], shares[1]}
+			},
+			expectedSealed:   true,
+			expectedProgress: 2,
+		},
+		{
+			name:      "single share configuration",
+			shares:    1,
+			threshold: 1,
+			keys: func(shares []string) []string {
+				return shares
+			},
+			expectedSealed: false,
+		},
+		{
+			name:      "wrong share resets the attempt at threshold",
+			shares:    5,
+			threshold: 3,
+			keys: func(shares []string) []string {
+				return []string{shares[0], wrongShare, shares[1]}
+			},
+			expectError:      true,
+			expectedSealed:   true,
+			expectedProgress: 0,
+		},
+		{
+			name:      "malformed key is rejected",
+			shares:    5,
+			threshold: 3,
+			keys: func([]string) []string {
+				return []string{"not-a-key"}
+			},
+			expectError:      true,
+			expectedSealed:   true,
+			expectedProgress: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := vaulttest.NewInitializedServer(tt.shares, tt.threshold)
+			defer fake.Close()
+
+			client := NewClient(fake.URL)
+
+			var err error
+			for _, key := range tt.keys(fake.Keys()) {
+				if _, err = client.UnsealWithKey(context.Background(), key); err != nil {
+					break
+				}
+			}
+
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedSealed, fake.Sealed())
+			assert.Equal(t, tt.expectedProgress, fake.Progress())
+		})
+	}
+}
+
+func TestUnsealMigrateWithFakeVault(t *testing.T) {
+	fake := vaulttest.NewInitializedServer(3, 2)
+	defer fake.Close()
+	fake.StartSealMigration()
+
+	client := NewClient(fake.URL)
+
+	status, err := client.CheckStatus(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, status.Migration)
+
+	// Vault refuses keys without the migrate flag while a migration is pending
+	_, err = client.Unseal(context.Background(), fake.Keys()[0])
+	assert.ErrorContains(t, err, "seal migration is pending")
+
+	for _, key := range fake.Keys()[:2] {
+		status, err = client.UnsealMigrate(context.Background(), key)
+		assert.NoError(t, err)
+	}
+	assert.False(t, status.Sealed)
+	assert.False(t, status.Migration)
+	assert.True(t, status.AutoUnseal(), "expected the Shamir keys to become recovery keys")
+
+	// The flag is refused once no migration is pending
+	fake.Seal()
+	_, err = client.UnsealMigrate(context.Background(), fake.Keys()[0])
+	assert.ErrorContains(t, err, "not in progress")
+}
+
+func TestInitializeWithRecoveryKeys(t *testing.T) {
+	fake := vaulttest.NewUninitializedAutoUnsealServer()
+	defer fake.Close()
+
+	client := NewClient(fake.URL)
+
+	// Shamir defaults do not fit an auto-unseal Vault
+	_, err := client.Initialize(context.Background())
+	assert.Error(t, err)
+
+	resp, err := client.InitializeWithRequest(context.Background(), InitRequest{RecoveryShares: 3, RecoveryThreshold: 2})
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Keys)
+	assert.Equal(t, fake.Keys(), resp.RecoveryKeys)
+	assert.Equal(t, fake.RootToken(), resp.RootToken)
+
+	status, err := client.CheckStatus(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, status.Initialized)
+	assert.False(t, status.Sealed)
+	assert.True(t, status.RecoverySeal)
+	assert.Equal(t, 2, status.Threshold)
+
+	// A Shamir Vault has no recovery keys
+	shamir := vaulttest.NewServer()
+	defer shamir.Close()
+	_, err = NewClient(shamir.URL).InitializeWithRequest(context.Background(), InitRequest{SecretShares: 5, SecretThreshold: 3, RecoveryShares: 3, RecoveryThreshold: 2})
+	assert.Error(t, err)
+	assert.False(t, shamir.Initialized())
+}
+
+func TestInitializeWithPGPKeys(t *testing.T) {
+	fake := vaulttest.NewServer()
+	defer fake.Close()
+
+	pgpKeys := []string{"a2V5MQ==", "a2V5Mg==", "a2V5Mw=="}
+	resp, err := NewClient(fake.URL).InitializeWithRequest(context.Background(), InitRequest{
+		SecretShares:    3,
+		SecretThreshold: 2,
+		PGPKeys:         pgpKeys,
+		RootTokenPGPKey: "cm9vdA==",
+	})
+	assert.NoError(t, err)
+	assert.Len(t, resp.Keys, 3)
+	for i, key := range fake.Keys() {
+		assert.Equal(t, vaulttest.PGPEncrypt(pgpKeys[i], key), resp.Keys[i])
+	}
+	assert.Equal(t, vaulttest.PGPEncrypt("cm9vdA==", fake.RootToken()), resp.RootToken)
+
+	// Vault needs one PGP key per share
+	other := vaulttest.NewServer()
+	defer other.Close()
+	_, err = NewClient(other.URL).InitializeWithRequest(context.Background(), InitRequest{SecretShares: 5, SecretThreshold: 3, PGPKeys: pgpKeys})
+	assert.Error(t, err)
+}
+
+func TestInitializeWithFakeVault(t *testing.T) {
+	fake := vaulttest.NewServer()
+	defer fake.Close()
+
+	client := NewClient(fake.URL)
+
+	resp, err := client.Initialize(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, resp.Keys, DefaultSecretShares)
+	assert.Equal(t, fake.RootToken(), resp.RootToken)
+
+	status, err := client.CheckStatus(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, status.Initialized)
+	assert.True(t, status.Sealed)
+
+	_, err = client.Initialize(context.Background())
+	assert.Error(t, err, "initializing twice should fail")
+
+	assert.NoError(t, client.UnsealWithKeysFromDir(context.Background(), resp.Keys[:DefaultSecretThreshold]))
+	assert.False(t, fake.Sealed())
+
+	fake.Seal()
+	status, err = client.CheckStatus(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, status.Sealed)
+}
+
 func TestUnsealWithKeysFromDir(t *testing.T) {
 	tests := []struct {
 		name            string
@@ -266,6 +548,35 @@ func TestUnsealWithKeysFromDir(t *testing.T) {
 			},
 			expectError: false,
 		},
+		{
+			// A further key would fail, since no more responses are available
+			name: "success - stops once unsealed",
+			serverResponses: []*http.Response{
+				{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader(`{"sealed": false, "t": 1, "n": 1}`)),
+				},
+			},
+			expectError: false,
+		},
+		{
+			name: "error - still sealed after every key",
+			serverResponses: []*http.Response{
+				{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader(`{"sealed": true, "t": 5, "progress": 1}`)),
+				},
+				{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader(`{"sealed": true, "t": 5, "progress": 2}`)),
+				},
+				{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader(`{"sealed": true, "t": 5, "progress": 3}`)),
+				},
+			},
+			expectError: true,
+		},
 		{
 			name: "error - server error",
 			serverResponses: []*http.Response{
@@ -290,7 +601,218 @@ func TestUnsealWithKeysFromDir(t *testing.T) {
 				},
 			}
 
-			err := client.UnsealWithKeysFromDir([]string{"key1", "key2", "key3"})
+			err := client.UnsealWithKeysFromDir(context.Background(), []string{"key1", "key2", "key3"})
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestLeader(t *testing.T) {
+	tests := []struct {
+		name          string
+		statusCode    int
+		responseBody  string
+		expectedError bool
+		expectedSelf  bool
+	}{
+		{
+			name:         "success - active node",
+			statusCode:   http.StatusOK,
+			responseBody: `{"ha_enabled": true, "is_self": true, "leader_address": "https://10.0.0.1:8200"}`,
+			expectedSelf: true,
+		},
+		{
+			name:         "success - standby node",
+			statusCode:   http.StatusOK,
+			responseBody: `{"ha_enabled": true, "is_self": false, "leader_address": "https://10.0.0.1:8200"}`,
+			expectedSelf: false,
+		},
+		{
+			name:          "error - server error",
+			statusCode:    http.StatusInternalServerError,
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v1/sys/leader" {
+					t.Errorf("Expected to request '/v1/sys/leader', got: %s", r.URL.Path)
+				}
+				w.WriteHeader(tt.statusCode)
+				fmt.Fprintln(w, tt.responseBody)
+			}))
+			defer server.Close()
+
+			leader, err := NewClient(server.URL).Leader(context.Background())
+			if tt.expectedError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedSelf, leader.IsSelf)
+		})
+	}
+}
+
+func TestServedByAgent(t *testing.T) {
+	tests := []struct {
+		name          string
+		handler       http.HandlerFunc
+		expectedAgent bool
+	}{
+		{
+			name:    "server",
+			handler: http.NotFound,
+		},
+		{
+			name: "agent",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/agent/v1/metrics" {
+					http.NotFound(w, r)
+					return
+				}
+				fmt.Fprintln(w, `{"Gauges": []}`)
+			},
+			expectedAgent: true,
+		},
+		{
+			name: "proxy",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/proxy/v1/metrics" {
+					http.NotFound(w, r)
+					return
+				}
+				fmt.Fprintln(w, `{"Gauges": []}`)
+			},
+			expectedAgent: true,
+		},
+		{
+			name: "cache",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Cache", "MISS")
+				http.NotFound(w, r)
+			},
+			expectedAgent: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.handler)
+			defer server.Close()
+
+			agent, err := NewClient(server.URL).ServedByAgent(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedAgent, agent)
+		})
+	}
+}
+
+func TestClockSkew(t *testing.T) {
+	now := time.Now().Unix()
+	tests := []struct {
+		name          string
+		statusCode    int
+		responseBody  string
+		expectedError bool
+		expectedSkew  time.Duration
+	}{
+		{
+			name:         "in sync",
+			statusCode:   http.StatusOK,
+			responseBody: fmt.Sprintf(`{"initialized": true, "sealed": false, "server_time_utc": %d}`, now),
+		},
+		{
+			name:         "sealed and ahead",
+			statusCode:   http.StatusServiceUnavailable,
+			responseBody: fmt.Sprintf(`{"initialized": true, "sealed": true, "server_time_utc": %d}`, now+60),
+			expectedSkew: time.Minute,
+		},
+		{
+			name:         "standby and behind",
+			statusCode:   http.StatusTooManyRequests,
+			responseBody: fmt.Sprintf(`{"initialized": true, "standby": true, "server_time_utc": %d}`, now-30),
+			expectedSkew: -30 * time.Second,
+		},
+		{
+			name:          "no server time",
+			statusCode:    http.StatusOK,
+			responseBody:  `{"initialized": true}`,
+			expectedError: true,
+		},
+		{
+			name:          "error - server error",
+			statusCode:    http.StatusInternalServerError,
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v1/sys/health" {
+					t.Errorf("Expected to request '/v1/sys/health', got: %s", r.URL.Path)
+				}
+				w.WriteHeader(tt.statusCode)
+				fmt.Fprintln(w, tt.responseBody)
+			}))
+			defer server.Close()
+
+			skew, err := NewClient(server.URL).ClockSkew(context.Background())
+			if tt.expectedError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			// Vault reports whole seconds
+			assert.InDelta(t, tt.expectedSkew.Seconds(), skew.Seconds(), 1.5)
+		})
+	}
+}
+
+func TestStepDown(t *testing.T) {
+	tests := []struct {
+		name        string
+		statusCode  int
+		expectError bool
+	}{
+		{
+			name:       "success",
+			statusCode: http.StatusNoContent,
+		},
+		{
+			name:        "error - permission denied",
+			statusCode:  http.StatusForbidden,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v1/sys/step-down" {
+					t.Errorf("Expected to request '/v1/sys/step-down', got: %s", r.URL.Path)
+				}
+				if r.Method != http.MethodPut {
+					t.Errorf("Expected PUT request, got: %s", r.Method)
+				}
+				if r.Header.Get("X-Vault-Token") != "root-token" {
+					t.Errorf("Expected token header to be set")
+				}
+				w.WriteHeader(tt.statusCode)
+			}))
+			defer server.Close()
+
+			err := NewClient(server.URL).StepDown(context.Background(), "root-token")
 			if tt.expectError {
 				assert.Error(t, err)
 				return
@@ -300,3 +822,412 @@ func TestUnsealWithKeysFromDir(t *testing.T) {
 		})
 	}
 }
+
+func TestRaftJoinWithFakeVault(t *testing.T) {
+	cluster := vaulttest.NewRaftCluster(2)
+	for _, fake := range cluster {
+		defer fake.Close()
+	}
+	leader, member := NewClient(cluster[0].URL), NewClient(cluster[1].URL)
+	ctx := context.Background()
+
+	status, err := member.CheckStatus(ctx)
+	assert.NoError(t, err)
+	assert.True(t, status.Raft())
+
+	err = member.RaftJoin(ctx, RaftJoinRequest{LeaderAPIAddr: cluster[0].URL})
+	assert.Error(t, err, "joining an uninitialized leader should fail")
+
+	resp, err := leader.Initialize(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, leader.UnsealWithKeysFromDir(ctx, resp.Keys))
+
+	assert.NoError(t, member.RaftJoin(ctx, RaftJoinRequest{LeaderAPIAddr: cluster[0].URL}))
+	assert.Equal(t, cluster[0].URL, cluster[1].JoinedTo())
+	status, err = member.CheckStatus(ctx)
+	assert.NoError(t, err)
+	assert.True(t, status.Initialized)
+	assert.True(t, status.Sealed, "a Shamir-sealed member joins sealed")
+
+	assert.NoError(t, member.UnsealWithKeysFromDir(ctx, resp.Keys))
+	assert.False(t, cluster[1].Sealed())
+
+	err = member.RaftJoin(ctx, RaftJoinRequest{LeaderAPIAddr: cluster[0].URL})
+	assert.Error(t, err, "joining twice should fail")
+}
+
+func TestRaftConfigurationWithFakeVault(t *testing.T) {
+	cluster := vaulttest.NewRaftCluster(1)
+	defer cluster[0].Close()
+	client := NewClient(cluster[0].URL)
+	ctx := context.Background()
+
+	resp, err := client.Initialize(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, client.UnsealWithKeysFromDir(ctx, resp.Keys))
+	cluster[0].SetRaftPeers([]vaulttest.RaftPeer{
+		{NodeID: "vault-0", Address: "vault-0.vault-internal:8201", Leader: true, Voter: true},
+		{NodeID: "vault-1", Address: "vault-1.vault-internal:8201"},
+	})
+
+	_, err = client.RaftConfiguration(ctx, "wrong-token")
+	assert.Error(t, err, "reading the configuration should need a token")
+
+	servers, err := client.RaftConfiguration(ctx, resp.RootToken)
+	assert.NoError(t, err)
+	assert.Equal(t, []RaftServer{
+		{NodeID: "vault-0", Address: "vault-0.vault-internal:8201", Leader: true, Voter: true, ProtocolVersion: "3"},
+		{NodeID: "vault-1", Address: "vault-1.vault-internal:8201", ProtocolVersion: "3"},
+	}, servers)
+}
+
+func TestRaftSnapshotWithFakeVault(t *testing.T) {
+	cluster := vaulttest.NewRaftCluster(1)
+	defer cluster[0].Close()
+	client := NewClient(cluster[0].URL)
+	ctx := context.Background()
+
+	resp, err := client.Initialize(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, client.UnsealWithKeysFromDir(ctx, resp.Keys))
+
+	var snapshot bytes.Buffer
+	_, err = client.RaftSnapshot(ctx, "wrong-token", &snapshot)
+	assert.Error(t, err, "taking a snapshot should need a token")
+
+	size, err := client.RaftSnapshot(ctx, resp.RootToken, &snapshot)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(vaulttest.Snapshot)), size)
+	assert.Equal(t, vaulttest.Snapshot, snapshot.Bytes())
+}
+
+// snapshotOf returns a RestoreRaftSnapshot source of data
+func snapshotOf(data []byte) func() (io.Reader, error) {
+	return func() (io.Reader, error) { return bytes.NewReader(data), nil }
+}
+
+func TestRestoreRaftSnapshotWithFakeVault(t *testing.T) {
+	cluster := vaulttest.NewRaftCluster(1)
+	defer cluster[0].Close()
+	client := NewClient(cluster[0].URL)
+	ctx := context.Background()
+
+	resp, err := client.Initialize(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, client.UnsealWithKeysFromDir(ctx, resp.Keys))
+
+	assert.Error(t, client.RestoreRaftSnapshot(ctx, "wrong-token", snapshotOf(vaulttest.Snapshot), false), "restoring a snapshot should need a token")
+
+	assert.NoError(t, client.RestoreRaftSnapshot(ctx, resp.RootToken, snapshotOf(vaulttest.Snapshot), false))
+	restored, forced := cluster[0].Restored()
+	assert.Equal(t, vaulttest.Snapshot, restored)
+	assert.False(t, forced)
+
+	other := []byte("snapshot of another cluster")
+	err = client.RestoreRaftSnapshot(ctx, resp.RootToken, snapshotOf(other), false)
+	assert.ErrorContains(t, err, "snapshot-force", "a snapshot of another cluster should need force")
+	assert.NoError(t, client.RestoreRaftSnapshot(ctx, resp.RootToken, snapshotOf(other), true))
+	restored, forced = cluster[0].Restored()
+	assert.Equal(t, other, restored)
+	assert.True(t, forced)
+}
+
+func TestRestoreRaftSnapshotStreams(t *testing.T) {
+	var received []byte
+	active := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer active.Close()
+	standby := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		http.Redirect(w, r, active.URL+r.URL.Path, http.StatusTemporaryRedirect)
+	}))
+	defer standby.Close()
+
+	// The snapshot is opened again for the active node, and closed every time
+	opened, closed := 0, 0
+	open := func() (io.Reader, error) {
+		opened++
+
+		return &closeCounter{Reader: strings.NewReader("raft snapshot"), closed: &closed}, nil
+	}
+	assert.NoError(t, NewClient(standby.URL).RestoreRaftSnapshot(context.Background(), "root", open, false))
+	assert.Equal(t, "raft snapshot", string(received))
+	assert.Equal(t, 2, opened)
+	assert.Equal(t, 2, closed)
+
+	// A snapshot failing to download is not Vault being unreachable
+	failing := func() (io.Reader, error) {
+		return io.MultiReader(strings.NewReader("raft"), iotest.ErrReader(errors.New("download interrupted"))), nil
+	}
+	err := NewClient(active.URL).RestoreRaftSnapshot(context.Background(), "root", failing, false)
+	assert.ErrorContains(t, err, "download interrupted")
+	assert.False(t, IsConnectionError(err))
+}
+
+// closeCounter counts how often it is closed
+type closeCounter struct {
+	io.Reader
+	closed *int
+}
+
+func (c *closeCounter) Close() error {
+	*c.closed++
+
+	return nil
+}
+
+func TestRemoveRaftPeerWithFakeVault(t *testing.T) {
+	cluster := vaulttest.NewRaftCluster(1)
+	defer cluster[0].Close()
+	client := NewClient(cluster[0].URL)
+	ctx := context.Background()
+
+	resp, err := client.Initialize(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, client.UnsealWithKeysFromDir(ctx, resp.Keys))
+	cluster[0].SetRaftPeers([]vaulttest.RaftPeer{
+		{NodeID: "vault-0", Address: "vault-0.vault-internal:8201", Leader: true, Voter: true},
+		{NodeID: "vault-1", Address: "vault-1.vault-internal:8201", Voter: true},
+	})
+
+	assert.Error(t, client.RemoveRaftPeer(ctx, "wrong-token", "vault-1"), "removing a peer should need a token")
+	assert.NoError(t, client.RemoveRaftPeer(ctx, resp.RootToken, "vault-1"))
+	assert.NoError(t, client.RemoveRaftPeer(ctx, resp.RootToken, "vault-1"), "removing a peer twice should succeed")
+
+	servers, err := client.RaftConfiguration(ctx, resp.RootToken)
+	assert.NoError(t, err)
+	if assert.Len(t, servers, 1) {
+		assert.Equal(t, "vault-0", servers[0].NodeID)
+	}
+}
+
+func TestSetAutopilotConfigurationWithFakeVault(t *testing.T) {
+	cluster := vaulttest.NewRaftCluster(1)
+	defer cluster[0].Close()
+	client := NewClient(cluster[0].URL)
+	ctx := context.Background()
+
+	resp, err := client.Initialize(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, client.UnsealWithKeysFromDir(ctx, resp.Keys))
+
+	config := AutopilotConfig{CleanupDeadServers: true, MinQuorum: 3, DeadServerLastContactThreshold: "24h0m0s"}
+	assert.Error(t, client.SetAutopilotConfiguration(ctx, "wrong-token", config), "configuring autopilot should need a token")
+	assert.Error(t, client.SetAutopilotConfiguration(ctx, resp.RootToken, AutopilotConfig{CleanupDeadServers: true}),
+		"cleaning up dead servers should need a quorum")
+
+	assert.NoError(t, client.SetAutopilotConfiguration(ctx, resp.RootToken, config))
+	assert.Equal(t, map[string]interface{}{
+		"cleanup_dead_servers":               true,
+		"min_quorum":                         float64(3),
+		"dead_server_last_contact_threshold": "24h0m0s",
+	}, cluster[0].Autopilot())
+}
+
+func TestReadSecretWithFakeVault(t *testing.T) {
+	fakeVault := vaulttest.NewInitializedServer(1, 1)
+	defer fakeVault.Close()
+	fakeVault.SetSecret("secret/data/canary", "canary-token", map[string]interface{}{"data": map[string]interface{}{"ok": "yes"}})
+	client := NewClient(fakeVault.URL)
+	ctx := context.Background()
+
+	_, err := client.ReadSecret(ctx, "canary-token", "secret/data/canary")
+	assert.Error(t, err, "a sealed Vault serves no secrets")
+
+	assert.NoError(t, client.UnsealWithKeysFromDir(ctx, fakeVault.Keys()))
+	data, err := client.ReadSecret(ctx, "canary-token", "/secret/data/canary")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"data": map[string]interface{}{"ok": "yes"}}, data)
+
+	_, err = client.ReadSecret(ctx, "other-token", "secret/data/canary")
+	var respErr *ResponseError
+	if assert.True(t, errors.As(err, &respErr)) {
+		assert.Equal(t, http.StatusForbidden, respErr.StatusCode)
+	}
+}
+
+func TestStandbyRedirects(t *testing.T) {
+	active := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/v1/sys/init":
+			if r.Method != http.MethodPut || !strings.Contains(string(body), "secret_shares") {
+				t.Errorf("Expected the init request to keep its method and body, got %s %q", r.Method, body)
+			}
+			w.Write([]byte(`{"keys":["key1"],"keys_base64":["a2V5MQ=="],"root_token":"root"}`))
+		case "/v1/sys/step-down":
+			if r.Method != http.MethodPut || r.Header.Get("X-Vault-Token") != "root-token" {
+				t.Errorf("Expected the step-down request to keep its method and token, got %s", r.Method)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer active.Close()
+
+	standby := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, active.URL+r.URL.Path, http.StatusTemporaryRedirect)
+	}))
+	defer standby.Close()
+
+	client := NewClient(standby.URL)
+
+	initResp, err := client.Initialize(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "root", initResp.RootToken)
+
+	assert.NoError(t, client.StepDown(context.Background(), "root-token"))
+
+	noLocation := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	}))
+	defer noLocation.Close()
+
+	assert.ErrorContains(t, NewClient(noLocation.URL).StepDown(context.Background(), "root-token"), "without a valid location")
+}
+
+func TestExternalClientRedirectsThroughExternalAddress(t *testing.T) {
+	// The first request lands on a standby, which redirects to the active node's internal
+	// api_addr; the retry through the external address lands on the active node
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("X-Vault-Token") != "root-token" {
+			t.Errorf("Expected token header to be kept across the redirect")
+		}
+		if requests == 1 {
+			http.Redirect(w, r, "http://10.255.0.1:8200/v1/sys/step-down", http.StatusTemporaryRedirect)
+			return
+		}
+		if r.URL.Path != "/v1/sys/step-down" || r.Method != http.MethodPut {
+			t.Errorf("Expected PUT /v1/sys/step-down, got %s %s", r.Method, r.URL.Path)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client, err := NewExternalClient(server.URL+"/", nil, TransportOptions{})
+	assert.NoError(t, err)
+	assert.NoError(t, client.StepDown(context.Background(), "root-token"))
+	assert.Equal(t, 2, requests)
+
+	_, err = NewExternalClient("vault.example.com", nil, TransportOptions{})
+	assert.Error(t, err)
+}
+
+func TestExternalClientStopsRedirectLoop(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://10.255.0.1:8200/v1/sys/leader", http.StatusTemporaryRedirect)
+	}))
+	defer server.Close()
+
+	client, err := NewExternalClient(server.URL, nil, TransportOptions{})
+	assert.NoError(t, err)
+
+	_, err = client.Leader(context.Background())
+	assert.ErrorContains(t, err, "stopped after 10 redirects")
+}
+
+func TestRequestsHonourContext(t *testing.T) {
+	// A pod that accepts the connection but never answers
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := NewClient(server.URL).CheckStatus(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = NewClient(server.URL).UnsealWithKey(cancelled, "abcd")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+// roundTripFunc serves fuzzed responses without a network round trip
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// fuzzClient returns a client whose every request is answered with the given status and body
+func fuzzClient(statusCode int, body []byte) *Client {
+	return NewClientWithHTTPClient("http://vault.invalid:8200", &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: statusCode,
+				Body:       io.NopCloser(strings.NewReader(string(body))),
+				Header:     make(http.Header),
+				Request:    req,
+			}, nil
+		}),
+	})
+}
+
+// addResponseSeeds seeds a fuzz target with well-formed and malformed Vault responses
+func addResponseSeeds(f *testing.F) {
+	f.Add(200, []byte(`{"initialized": true, "sealed": false, "t": 3, "n": 5, "progress": 0}`))
+	f.Add(200, []byte(`{"keys": ["abcd"], "keys_base64": ["q80="], "root_token": "s.root"}`))
+	f.Add(200, []byte(`{"ha_enabled": true, "is_self": false, "leader_address": "https://10.0.0.1:8200"}`))
+	f.Add(200, []byte(`{"sealed": "yes", "t": -1, "progress": 1e309}`))
+	f.Add(200, []byte(`{"keys": null, "root_token": 7}`))
+	f.Add(200, []byte(`[]`))
+	f.Add(200, []byte(``))
+	f.Add(503, []byte(`{"errors": ["Vault is sealed"]}`))
+}
+
+func FuzzCheckStatus(f *testing.F) {
+	addResponseSeeds(f)
+	f.Fuzz(func(t *testing.T, statusCode int, body []byte) {
+		status, err := fuzzClient(statusCode, body).CheckStatus(context.Background())
+		if err == nil && (status == nil || statusCode != http.StatusOK) {
+			t.Errorf("accepted status %+v with code %d", status, statusCode)
+		}
+	})
+}
+
+func FuzzInitialize(f *testing.F) {
+	addResponseSeeds(f)
+	f.Fuzz(func(t *testing.T, statusCode int, body []byte) {
+		resp, err := fuzzClient(statusCode, body).Initialize(context.Background())
+		if err != nil {
+			return
+		}
+
+		// An accepted response always carries key material to store
+		if resp == nil || len(resp.Keys) == 0 || resp.RootToken == "" {
+			t.Errorf("accepted incomplete init response %+v", resp)
+		}
+	})
+}
+
+func FuzzUnsealWithKey(f *testing.F) {
+	addResponseSeeds(f)
+	f.Fuzz(func(t *testing.T, statusCode int, body []byte) {
+		if _, err := fuzzClient(statusCode, body).UnsealWithKey(context.Background(), "abcd"); err == nil && statusCode != http.StatusOK {
+			t.Errorf("accepted unseal response with code %d", statusCode)
+		}
+	})
+}
+
+func FuzzLeader(f *testing.F) {
+	addResponseSeeds(f)
+	f.Fuzz(func(t *testing.T, statusCode int, body []byte) {
+		leader, err := fuzzClient(statusCode, body).Leader(context.Background())
+		if err == nil && leader == nil {
+			t.Errorf("accepted leader response without a result")
+		}
+	})
+}
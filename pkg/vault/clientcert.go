@@ -0,0 +1,80 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// ClientCertificate is the client certificate presented to Vault listeners that require one.
+// It can be replaced at any time, such as when cert-manager rotates it, and every connection
+// made afterwards presents the replacement without the clients being recreated.
+type ClientCertificate struct {
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	// certPEM and keyPEM are what cert was parsed from, so unchanged material is not parsed again
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// NewClientCertificate returns a ClientCertificate that presents no certificate until one is set
+func NewClientCertificate() *ClientCertificate {
+	return &ClientCertificate{}
+}
+
+// Set replaces the certificate with the PEM certificate chain and private key, and reports
+// whether they differ from the current ones. Invalid material leaves the current certificate
+// in place.
+func (c *ClientCertificate) Set(certPEM, keyPEM []byte) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cert != nil && bytes.Equal(certPEM, c.certPEM) && bytes.Equal(keyPEM, c.keyPEM) {
+		return false, nil
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return false, fmt.Errorf("invalid client certificate: %w", err)
+	}
+	c.cert, c.certPEM, c.keyPEM = &cert, certPEM, keyPEM
+
+	return true, nil
+}
+
+// LoadFiles replaces the certificate with the PEM files certFile and keyFile, like Set
+func (c *ClientCertificate) LoadFiles(certFile, keyFile string) (bool, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to read client certificate: %w", err)
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to read client key: %w", err)
+	}
+
+	return c.Set(certPEM, keyPEM)
+}
+
+// Loaded reports whether a certificate has been set
+func (c *ClientCertificate) Loaded() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.cert != nil
+}
+
+// getClientCertificate hands the current certificate to a TLS handshake. Without one an empty
+// certificate is sent, and a listener requiring client certificates rejects the handshake.
+func (c *ClientCertificate) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.cert == nil {
+		return &tls.Certificate{}, nil
+	}
+
+	return c.cert, nil
+}
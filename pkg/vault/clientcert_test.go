@@ -0,0 +1,128 @@
+package vault
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testIssuer signs client certificates for tests
+type testIssuer struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "client CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testIssuer{cert: cert, key: key}
+}
+
+// issue returns a PEM client certificate and key signed by the issuer
+func (i *testIssuer) issue(t *testing.T, name string) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, i.cert, &key.PublicKey, i.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestClientCertificate(t *testing.T) {
+	trusted := newTestIssuer(t)
+	untrusted := newTestIssuer(t)
+
+	var presented string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented = r.TLS.PeerCertificates[0].Subject.CommonName
+		_, _ = w.Write([]byte(`{"initialized": true, "sealed": false}`))
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(trusted.cert)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs, MinVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	clientCert := NewClientCertificate()
+	client := NewClientWithTLS(server.URL, NewTLSConfig(roots, "", clientCert))
+	defer client.Close()
+
+	_, err := client.CheckStatus(context.Background())
+	assert.Error(t, err, "expected the handshake to fail without a client certificate")
+
+	certPEM, keyPEM := trusted.issue(t, "vault-utils")
+	changed, err := clientCert.Set(certPEM, keyPEM)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	_, err = client.CheckStatus(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "vault-utils", presented)
+
+	changed, err = clientCert.Set(certPEM, keyPEM)
+	require.NoError(t, err)
+	assert.False(t, changed, "expected unchanged material to be ignored")
+
+	_, err = clientCert.Set([]byte("not a certificate"), keyPEM)
+	assert.Error(t, err)
+	assert.True(t, clientCert.Loaded(), "expected invalid material to keep the current certificate")
+
+	// A rotated certificate is presented on the next connection
+	certPEM, keyPEM = trusted.issue(t, "vault-utils-rotated")
+	_, err = clientCert.Set(certPEM, keyPEM)
+	require.NoError(t, err)
+	client.Close()
+	_, err = client.CheckStatus(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "vault-utils-rotated", presented)
+
+	certPEM, keyPEM = untrusted.issue(t, "intruder")
+	_, err = clientCert.Set(certPEM, keyPEM)
+	require.NoError(t, err)
+	client.Close()
+	_, err = client.CheckStatus(context.Background())
+	assert.Error(t, err, "expected a certificate from another CA to be rejected")
+}
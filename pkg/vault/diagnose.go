@@ -0,0 +1,168 @@
+package vault
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"time"
+)
+
+const diagnoseStageTimeout = 3 * time.Second
+
+// Diagnostic stages, in the order they are probed
+const (
+	StageDNS  = "dns"
+	StageTCP  = "tcp"
+	StageTLS  = "tls"
+	StageHTTP = "http"
+)
+
+// Diagnosis is the result of probing the path to a Vault listener one layer at a time
+type Diagnosis struct {
+	Stages []Stage `json:"stages"`
+	// Failed names the first stage that failed, or is empty when every stage passed
+	Failed string `json:"failed,omitempty"`
+	// Hint suggests the most likely cause of the failure
+	Hint string `json:"hint,omitempty"`
+}
+
+// Stage is the outcome of a single diagnostic stage
+type Stage struct {
+	Name     string `json:"name"`
+	OK       bool   `json:"ok"`
+	Detail   string `json:"detail"`
+	Duration string `json:"duration"`
+}
+
+// Summary describes the diagnosis in one line
+func (d *Diagnosis) Summary() string {
+	if d.Failed == "" {
+		return "all stages passed"
+	}
+
+	last := d.Stages[len(d.Stages)-1]
+
+	return fmt.Sprintf("%s stage failed: %s (%s)", d.Failed, last.Detail, d.Hint)
+}
+
+// IsConnectionError reports whether err is a failure to reach Vault at all, as opposed to
+// Vault answering with an error. It is errors.Is(err, ErrUnreachable).
+func IsConnectionError(err error) bool {
+	return errors.Is(err, ErrUnreachable)
+}
+
+// Diagnose probes DNS, TCP, TLS and HTTP in turn against the client's Vault address and stops
+// at the first stage that fails, so "is it NetworkPolicy or is Vault down?" has a direct answer
+func (c *Client) Diagnose(ctx context.Context) *Diagnosis {
+	d := &Diagnosis{}
+
+	u, err := url.Parse(c.baseURL)
+	if err != nil {
+		d.fail(StageDNS, 0, fmt.Sprintf("invalid address %q: %v", c.baseURL, err), "the controller is configured with a malformed Vault address")
+		return d
+	}
+
+	host, port := u.Hostname(), u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	// DNS
+	start := time.Now()
+	if net.ParseIP(host) != nil {
+		d.pass(StageDNS, time.Since(start), "literal IP address, no lookup needed")
+	} else {
+		lookupCtx, cancel := context.WithTimeout(ctx, diagnoseStageTimeout)
+		addrs, err := net.DefaultResolver.LookupHost(lookupCtx, host)
+		cancel()
+		if err != nil {
+			d.fail(StageDNS, time.Since(start), err.Error(),
+				"name does not resolve: check the service name, CoreDNS, and that egress to kube-dns on port 53 is allowed")
+			return d
+		}
+		d.pass(StageDNS, time.Since(start), fmt.Sprintf("resolved to %v", addrs))
+	}
+
+	// TCP
+	start = time.Now()
+	dialer := &net.Dialer{Timeout: diagnoseStageTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		d.fail(StageTCP, time.Since(start), err.Error(), tcpHint(err))
+		return d
+	}
+	d.pass(StageTCP, time.Since(start), "connected to "+conn.RemoteAddr().String())
+
+	// TLS
+	if u.Scheme == "https" {
+		start = time.Now()
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12})
+		handshakeCtx, cancel := context.WithTimeout(ctx, diagnoseStageTimeout)
+		err := tlsConn.HandshakeContext(handshakeCtx)
+		cancel()
+		if err != nil {
+			conn.Close()
+			d.fail(StageTLS, time.Since(start), err.Error(),
+				"TCP connects but the TLS handshake fails: check the server certificate, its SANs and the trusted CA")
+			return d
+		}
+		d.pass(StageTLS, time.Since(start), "handshake completed with "+tls.VersionName(tlsConn.ConnectionState().Version))
+	}
+	conn.Close()
+
+	// HTTP
+	start = time.Now()
+	httpCtx, cancel := context.WithTimeout(ctx, diagnoseStageTimeout)
+	defer cancel()
+
+	resp, err := c.do(httpCtx, http.MethodGet, "/v1/sys/health", "", nil)
+	if err != nil {
+		d.fail(StageHTTP, time.Since(start), err.Error(),
+			"the port accepts connections but HTTP fails: a proxy or service mesh sidecar may be intercepting traffic")
+		return d
+	}
+	resp.Body.Close()
+	d.pass(StageHTTP, time.Since(start), fmt.Sprintf("health endpoint answered with status %d", resp.StatusCode))
+
+	return d
+}
+
+func (d *Diagnosis) pass(stage string, duration time.Duration, detail string) {
+	d.Stages = append(d.Stages, Stage{Name: stage, OK: true, Detail: detail, Duration: duration.Round(time.Microsecond).String()})
+}
+
+func (d *Diagnosis) fail(stage string, duration time.Duration, detail, hint string) {
+	d.Stages = append(d.Stages, Stage{Name: stage, Detail: detail, Duration: duration.Round(time.Microsecond).String()})
+	d.Failed = stage
+	d.Hint = hint
+}
+
+// tcpHint tells a connection that is actively refused, where the pod is reachable but nothing
+// listens, from one that times out, which is how a NetworkPolicy dropping packets looks
+func tcpHint(err error) string {
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused: the pod is reachable but nothing listens on the port, Vault is probably not running"
+	case errors.Is(err, os.ErrDeadlineExceeded) || isTimeout(err):
+		return "connection timed out: packets are likely dropped by a NetworkPolicy or firewall"
+	case errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH):
+		return "no route to the pod: it may be gone or on an unreachable network"
+	default:
+		return "the TCP connection could not be established"
+	}
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,99 @@
+package vault
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDiagnose(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer healthy.Close()
+
+	untrusted := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer untrusted.Close()
+
+	stopped := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	stopped.Close()
+
+	tests := []struct {
+		name           string
+		baseURL        string
+		expectedFailed string
+		expectedStages []string
+		expectedHint   string
+	}{
+		{
+			name:           "reachable",
+			baseURL:        healthy.URL,
+			expectedStages: []string{StageDNS, StageTCP, StageHTTP},
+		},
+		{
+			name:           "nothing listening",
+			baseURL:        stopped.URL,
+			expectedFailed: StageTCP,
+			expectedStages: []string{StageDNS, StageTCP},
+			expectedHint:   "connection refused",
+		},
+		{
+			name:           "untrusted certificate",
+			baseURL:        untrusted.URL,
+			expectedFailed: StageTLS,
+			expectedStages: []string{StageDNS, StageTCP, StageTLS},
+			expectedHint:   "TLS handshake fails",
+		},
+		{
+			name:           "unresolvable name",
+			baseURL:        "http://vault.invalid:8200",
+			expectedFailed: StageDNS,
+			expectedStages: []string{StageDNS},
+			expectedHint:   "does not resolve",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewClient(tt.baseURL).Diagnose(context.Background())
+
+			if d.Failed != tt.expectedFailed {
+				t.Errorf("expected failed stage %q, got %q (%s)", tt.expectedFailed, d.Failed, d.Summary())
+			}
+
+			var stages []string
+			for _, stage := range d.Stages {
+				stages = append(stages, stage.Name)
+			}
+			if strings.Join(stages, ",") != strings.Join(tt.expectedStages, ",") {
+				t.Errorf("expected stages %v, got %v", tt.expectedStages, stages)
+			}
+
+			if !strings.Contains(d.Hint, tt.expectedHint) {
+				t.Errorf("expected hint containing %q, got %q", tt.expectedHint, d.Hint)
+			}
+		})
+	}
+}
+
+func TestIsConnectionError(t *testing.T) {
+	stopped := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	stopped.Close()
+
+	_, err := NewClient(stopped.URL).CheckStatus(context.Background())
+	if !IsConnectionError(err) {
+		t.Errorf("expected a refused connection to be a connection error: %v", err)
+	}
+
+	sealed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer sealed.Close()
+
+	_, err = NewClient(sealed.URL).CheckStatus(context.Background())
+	if err == nil || IsConnectionError(err) {
+		t.Errorf("expected an error status not to be a connection error: %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package vault
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Errors the operations of a Client can be told apart by with errors.Is
+var (
+	// ErrUnreachable means Vault could not be reached at all, as opposed to Vault answering
+	// with an error
+	ErrUnreachable = errors.New("vault is unreachable")
+	// ErrAlreadyInitialized means init was refused because Vault is already initialized
+	ErrAlreadyInitialized = errors.New("vault is already initialized")
+	// ErrNotInitialized means the request needs Vault to be initialized first
+	ErrNotInitialized = errors.New("vault is not initialized")
+	// ErrSealed means the request needs Vault to be unsealed first
+	ErrSealed = errors.New("vault is sealed")
+	// ErrInvalidKey means Vault rejected an unseal key
+	ErrInvalidKey = errors.New("unseal key rejected")
+)
+
+// maxErrorBody bounds how much of an error response is read for the errors Vault reports
+const maxErrorBody = 64 << 10
+
+// unreachableError marks a failure to reach Vault as ErrUnreachable and keeps its message and
+// cause, such as a *url.Error
+type unreachableError struct {
+	err error
+}
+
+// Error implements error
+func (e *unreachableError) Error() string {
+	return e.err.Error()
+}
+
+// Is reports the error as ErrUnreachable
+func (e *unreachableError) Is(target error) bool {
+	return target == ErrUnreachable
+}
+
+// Unwrap returns the cause
+func (e *unreachableError) Unwrap() error {
+	return e.err
+}
+
+// ResponseError is an answer of Vault with an unexpected status code
+type ResponseError struct {
+	StatusCode int
+	// Errors are the messages Vault reported in the body
+	Errors []string
+	// kind is the error of this package the response stands for, if any
+	kind error
+}
+
+// Error implements error
+func (e *ResponseError) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("unexpected status code: %d", e.StatusCode)
+	}
+
+	return fmt.Sprintf("unexpected status code: %d: %s", e.StatusCode, strings.Join(e.Errors, "; "))
+}
+
+// Unwrap returns the error of this package the response stands for, such as ErrSealed, or nil
+func (e *ResponseError) Unwrap() error {
+	return e.kind
+}
+
+// unexpectedResponse reads the errors Vault reported in resp and returns them as a
+// *ResponseError. A 400 that Vault's messages do not explain otherwise stands for badRequest,
+// when it is not nil, such as ErrInvalidKey for an unseal key.
+func unexpectedResponse(resp *http.Response, badRequest error) error {
+	respErr := &ResponseError{StatusCode: resp.StatusCode}
+
+	var body struct {
+		Errors []string `json:"errors"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxErrorBody)).Decode(&body); err == nil {
+		respErr.Errors = body.Errors
+	}
+
+	messages := strings.ToLower(strings.Join(respErr.Errors, " "))
+	switch {
+	case strings.Contains(messages, "already initialized"):
+		respErr.kind = ErrAlreadyInitialized
+	case strings.Contains(messages, "not initialized"):
+		respErr.kind = ErrNotInitialized
+	case resp.StatusCode == http.StatusServiceUnavailable && strings.Contains(messages, "sealed"):
+		respErr.kind = ErrSealed
+	case resp.StatusCode == http.StatusBadRequest:
+		respErr.kind = badRequest
+	}
+
+	return respErr
+}
@@ -0,0 +1,95 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTypedErrors(t *testing.T) {
+	stopped := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	stopped.Close()
+
+	tests := []struct {
+		name     string
+		call     func(ctx context.Context) error
+		expected error
+	}{
+		{
+			name: "init of an initialized Vault",
+			call: func(ctx context.Context) error {
+				fakeVault := vaulttest.NewInitializedServer(5, 3)
+				defer fakeVault.Close()
+				_, err := NewClient(fakeVault.URL).Initialize(ctx)
+				return err
+			},
+			expected: ErrAlreadyInitialized,
+		},
+		{
+			name: "unseal of an uninitialized Vault",
+			call: func(ctx context.Context) error {
+				fakeVault := vaulttest.NewServer()
+				defer fakeVault.Close()
+				_, err := NewClient(fakeVault.URL).UnsealWithKey(ctx, "a2V5")
+				return err
+			},
+			expected: ErrNotInitialized,
+		},
+		{
+			name: "malformed unseal key",
+			call: func(ctx context.Context) error {
+				fakeVault := vaulttest.NewInitializedServer(5, 3)
+				defer fakeVault.Close()
+				_, err := NewClient(fakeVault.URL).UnsealWithKey(ctx, "not a key")
+				return err
+			},
+			expected: ErrInvalidKey,
+		},
+		{
+			name: "leader of a sealed Vault",
+			call: func(ctx context.Context) error {
+				fakeVault := vaulttest.NewInitializedServer(5, 3)
+				defer fakeVault.Close()
+				_, err := NewClient(fakeVault.URL).Leader(ctx)
+				return err
+			},
+			expected: ErrSealed,
+		},
+		{
+			name: "refused connection",
+			call: func(ctx context.Context) error {
+				_, err := NewClient(stopped.URL).CheckStatus(ctx)
+				return err
+			},
+			expected: ErrUnreachable,
+		},
+	}
+
+	sentinels := []error{ErrUnreachable, ErrAlreadyInitialized, ErrNotInitialized, ErrSealed, ErrInvalidKey}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call(context.Background())
+			for _, sentinel := range sentinels {
+				assert.Equal(t, sentinel == tt.expected, errors.Is(err, sentinel), "errors.Is(%v, %v)", err, sentinel)
+			}
+		})
+	}
+}
+
+func TestResponseError(t *testing.T) {
+	fakeVault := vaulttest.NewInitializedServer(5, 3)
+	defer fakeVault.Close()
+
+	_, err := NewClient(fakeVault.URL).Initialize(context.Background())
+
+	var respErr *ResponseError
+	assert.True(t, errors.As(err, &respErr))
+	assert.Equal(t, http.StatusBadRequest, respErr.StatusCode)
+	assert.Equal(t, []string{"Vault is already initialized"}, respErr.Errors)
+	assert.Equal(t, "unexpected status code: 400: Vault is already initialized", err.Error())
+}
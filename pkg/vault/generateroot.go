@@ -0,0 +1,108 @@
+package vault
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// generateRootPath is the API of root token generation, authorized with unseal keys, or recovery
+// keys on an auto-unseal Vault, rather than with a token
+const generateRootPath = "/v1/sys/generate-root"
+
+// GenerateRoot creates a new root token from keys, the unseal keys of a Shamir Vault or the
+// recovery keys of an auto-unseal one. It starts an attempt with a one-time password generated
+// by Vault, submits keys until Vault has enough of them and decodes the token it answers with.
+// The attempt is cancelled when it cannot be completed.
+//
+// Requests are not retried: a submitted key may have been counted even when its answer was
+// lost.
+func (c *Client) GenerateRoot(ctx context.Context, keys []string) (string, error) {
+	attempt, err := c.generateRootRequest(ctx, http.MethodPut, "/attempt", []byte("{}"), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to start root token generation: %w", err)
+	}
+	if attempt.OTP == "" {
+		c.cancelGenerateRoot(ctx)
+		return "", fmt.Errorf("vault returned no one-time password, Vault 1.10 or later is needed")
+	}
+
+	for _, key := range keys {
+		body, err := json.Marshal(map[string]string{"key": HexKey(key), "nonce": attempt.Nonce})
+		if err != nil {
+			c.cancelGenerateRoot(ctx)
+			return "", fmt.Errorf("failed to marshal request: %w", err)
+		}
+
+		progress, err := c.generateRootRequest(ctx, http.MethodPut, "/update", body, ErrInvalidKey)
+		if err != nil {
+			c.cancelGenerateRoot(ctx)
+			return "", fmt.Errorf("failed to submit key: %w", err)
+		}
+
+		if progress.Complete {
+			return DecodeRootToken(progress.EncodedToken, attempt.OTP)
+		}
+		attempt.Progress, attempt.Required = progress.Progress, progress.Required
+	}
+
+	c.cancelGenerateRoot(ctx)
+
+	return "", fmt.Errorf("root token generation needs %d keys, only %d were accepted", attempt.Required, attempt.Progress)
+}
+
+// DecodeRootToken decodes the token a root token generation answers with, which Vault XORs with
+// the one-time password of the attempt and encodes in base64
+func DecodeRootToken(encoded, otp string) (string, error) {
+	raw, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode root token: %w", err)
+	}
+	if len(raw) != len(otp) {
+		return "", fmt.Errorf("failed to decode root token: %d bytes encoded with a %d byte one-time password", len(raw), len(otp))
+	}
+
+	token := make([]byte, len(raw))
+	for i := range raw {
+		token[i] = raw[i] ^ otp[i]
+	}
+
+	return string(token), nil
+}
+
+// generateRootRequest sends a request to the root token generation API and decodes its progress.
+// A 400 Vault does not explain otherwise stands for badRequest, when it is not nil.
+func (c *Client) generateRootRequest(ctx context.Context, method, path string, body []byte, badRequest error) (*GenerateRootResponse, error) {
+	resp, err := c.do(ctx, method, generateRootPath+path, "", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, unexpectedResponse(resp, badRequest)
+	}
+
+	var progress GenerateRootResponse
+	if err := json.NewDecoder(resp.Body).Decode(&progress); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &progress, nil
+}
+
+// cancelGenerateRoot throws away the attempt in progress. It is best effort: an attempt left
+// behind only makes the next one fail to start until it is cancelled.
+func (c *Client) cancelGenerateRoot(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cancelRekeyTimeout)
+	defer cancel()
+
+	resp, err := c.do(ctx, http.MethodDelete, generateRootPath+"/attempt", "", nil)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+}
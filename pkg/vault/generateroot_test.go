@@ -0,0 +1,85 @@
+package vault
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateRoot(t *testing.T) {
+	tests := []struct {
+		name   string
+		server func() *vaulttest.Server
+	}{
+		{
+			name: "unseal keys",
+			server: func() *vaulttest.Server {
+				s := vaulttest.NewInitializedServer(5, 3)
+				_ = NewClient(s.URL).UnsealWithKeysFromDir(context.Background(), s.Keys())
+				return s
+			},
+		},
+		{
+			name:   "recovery keys",
+			server: func() *vaulttest.Server { return vaulttest.NewAutoUnsealServer(5, 3) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeVault := tt.server()
+			defer fakeVault.Close()
+
+			client := NewClient(fakeVault.URL)
+			defer client.Close()
+
+			token, err := client.GenerateRoot(context.Background(), fakeVault.Keys())
+			assert.NoError(t, err)
+			assert.Equal(t, fakeVault.RootToken(), token)
+			assert.False(t, fakeVault.GeneratingRoot())
+		})
+	}
+}
+
+func TestGenerateRootFailures(t *testing.T) {
+	fakeVault := vaulttest.NewAutoUnsealServer(5, 3)
+	defer fakeVault.Close()
+
+	client := NewClient(fakeVault.URL)
+	defer client.Close()
+
+	_, err := client.GenerateRoot(context.Background(), fakeVault.Keys()[:2])
+	assert.EqualError(t, err, "root token generation needs 3 keys, only 2 were accepted")
+	assert.False(t, fakeVault.GeneratingRoot(), "a failed attempt is cancelled, so the next one can start")
+
+	wrong := []string{fakeVault.Keys()[0], fakeVault.Keys()[1], "abcdef"}
+	_, err = client.GenerateRoot(context.Background(), wrong)
+	assert.True(t, errors.Is(err, ErrInvalidKey), "expected the wrong key to be rejected, got %v", err)
+	assert.False(t, fakeVault.GeneratingRoot())
+}
+
+func TestDecodeRootToken(t *testing.T) {
+	otp := "0123456789abcdef0123456789ab"
+	token := "hvs.ABCDEFGHIJKLMNOPQRSTUVWX"
+	encoded := make([]byte, len(token))
+	for i := range encoded {
+		encoded[i] = token[i] ^ otp[i]
+	}
+
+	decoded, err := DecodeRootToken(base64.RawStdEncoding.EncodeToString(encoded), otp)
+	assert.NoError(t, err)
+	assert.Equal(t, token, decoded)
+
+	decoded, err = DecodeRootToken(base64.StdEncoding.EncodeToString(encoded), otp)
+	assert.NoError(t, err, "padded encodings are accepted too")
+	assert.Equal(t, token, decoded)
+
+	_, err = DecodeRootToken(base64.RawStdEncoding.EncodeToString(encoded), otp[:10])
+	assert.Error(t, err)
+	_, err = DecodeRootToken("not base64!", otp)
+	assert.Error(t, err)
+}
@@ -0,0 +1,70 @@
+package vault
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// Encodings of the key shares Vault hands out at init, both of which it accepts back
+const (
+	KeyEncodingHex    = "hex"
+	KeyEncodingBase64 = "base64"
+)
+
+// HexKey returns a key share given in hex or base64 in hex, the form Vault lists first and the
+// shamir package works with. A key that is neither, such as one encrypted to a PGP key, is
+// returned unchanged.
+func HexKey(key string) string {
+	if _, err := hex.DecodeString(key); err == nil {
+		return key
+	}
+	if raw, err := base64.StdEncoding.DecodeString(key); err == nil {
+		return hex.EncodeToString(raw)
+	}
+
+	return key
+}
+
+// EncodeKey returns a key share given in hex or base64 in encoding, and false when it is neither,
+// such as one encrypted to a PGP key
+func EncodeKey(key, encoding string) (string, bool) {
+	raw, err := hex.DecodeString(key)
+	if err != nil {
+		if raw, err = base64.StdEncoding.DecodeString(key); err != nil {
+			return key, false
+		}
+	}
+	if encoding == KeyEncodingBase64 {
+		return base64.StdEncoding.EncodeToString(raw), true
+	}
+
+	return hex.EncodeToString(raw), true
+}
+
+// EncodedKeys returns the unseal keys of the response in encoding, hex unless base64 is asked
+// for and Vault returned it
+func (r *InitResponse) EncodedKeys(encoding string) []string {
+	if encoding == KeyEncodingBase64 && len(r.KeysBase64) > 0 {
+		return r.KeysBase64
+	}
+
+	return r.Keys
+}
+
+// EncodedRecoveryKeys returns the recovery keys of the response in encoding, like EncodedKeys
+func (r *InitResponse) EncodedRecoveryKeys(encoding string) []string {
+	if encoding == KeyEncodingBase64 && len(r.RecoveryKeysBase64) > 0 {
+		return r.RecoveryKeysBase64
+	}
+
+	return r.RecoveryKeys
+}
+
+// EncodedKeys returns the new keys of a completed rekey in encoding, like the init response
+func (r *RekeyResponse) EncodedKeys(encoding string) []string {
+	if encoding == KeyEncodingBase64 && len(r.KeysBase64) > 0 {
+		return r.KeysBase64
+	}
+
+	return r.Keys
+}
@@ -0,0 +1,51 @@
+package vault
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHexKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		expected string
+	}{
+		{name: "hex", key: "0a1b2c", expected: "0a1b2c"},
+		{name: "base64", key: "ChssPQ==", expected: "0a1b2c3d"},
+		{name: "neither", key: "wcBMA-not-a-key", expected: "wcBMA-not-a-key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, HexKey(tt.key))
+		})
+	}
+}
+
+func TestEncodeKey(t *testing.T) {
+	key, ok := EncodeKey("0a1b2c3d", KeyEncodingBase64)
+	assert.True(t, ok)
+	assert.Equal(t, "ChssPQ==", key)
+
+	key, ok = EncodeKey("ChssPQ==", KeyEncodingHex)
+	assert.True(t, ok)
+	assert.Equal(t, "0a1b2c3d", key)
+
+	key, ok = EncodeKey("0a1b2c3d", KeyEncodingHex)
+	assert.True(t, ok)
+	assert.Equal(t, "0a1b2c3d", key)
+
+	_, ok = EncodeKey("wcBMA-not-a-key", KeyEncodingHex)
+	assert.False(t, ok)
+}
+
+func TestEncodedKeys(t *testing.T) {
+	resp := &InitResponse{Keys: []string{"0a1b"}, KeysBase64: []string{"Chs="}, RecoveryKeys: []string{"0c0d"}}
+
+	assert.Equal(t, []string{"0a1b"}, resp.EncodedKeys(KeyEncodingHex))
+	assert.Equal(t, []string{"Chs="}, resp.EncodedKeys(KeyEncodingBase64))
+	// Vault returning no base64 variant leaves the hex keys
+	assert.Equal(t, []string{"0c0d"}, resp.EncodedRecoveryKeys(KeyEncodingBase64))
+}
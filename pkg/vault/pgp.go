@@ -0,0 +1,31 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+)
+
+// ReadPGPKey reads the PGP public key in path in the form Vault takes in init requests, the
+// base64 encoding of the binary key. The file may hold the binary key, as written by
+// gpg --export, or its base64 encoding. ASCII-armored keys are refused.
+func ReadPGPKey(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read PGP key: %w", err)
+	}
+
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return "", fmt.Errorf("PGP key file %s is empty", path)
+	}
+	if bytes.HasPrefix(data, []byte("-----BEGIN PGP")) {
+		return "", fmt.Errorf("PGP key file %s is ASCII-armored, export it with gpg --export instead of gpg --export --armor", path)
+	}
+	if _, err := base64.StdEncoding.DecodeString(string(data)); err == nil {
+		return string(data), nil
+	}
+
+	return base64.StdEncoding.EncodeToString(data), nil
+}
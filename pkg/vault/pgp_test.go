@@ -0,0 +1,45 @@
+package vault
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadPGPKey(t *testing.T) {
+	binary := []byte{0x99, 0x01, 0x0d, 0x04, 0x65, 0xf1}
+	encoded := base64.StdEncoding.EncodeToString(binary)
+
+	tests := []struct {
+		name        string
+		content     string
+		expected    string
+		expectError bool
+	}{
+		{name: "binary export", content: string(binary), expected: encoded},
+		{name: "base64", content: encoded + "\n", expected: encoded},
+		{name: "ASCII-armored", content: "-----BEGIN PGP PUBLIC KEY BLOCK-----\n\nmQENBGXx\n-----END PGP PUBLIC KEY BLOCK-----\n", expectError: true},
+		{name: "empty", content: "\n", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "key")
+			assert.NoError(t, os.WriteFile(path, []byte(tt.content), 0o600))
+
+			key, err := ReadPGPKey(path)
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, key)
+		})
+	}
+
+	_, err := ReadPGPKey(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
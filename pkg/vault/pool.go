@@ -0,0 +1,130 @@
+package vault
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sync"
+)
+
+// Pool keeps one Client per Vault address so connections are reused across reconcile passes
+// instead of being re-established for every request
+type Pool struct {
+	mu      sync.Mutex
+	clients map[string]*Client
+	// tlsConfig verifies https:// addresses, the system roots are used when it is nil
+	tlsConfig *tls.Config
+	// opts tune the connections of every client in the pool
+	opts TransportOptions
+	// retry is the retry policy of every client in the pool
+	retry RetryPolicy
+	// newBackend creates the backends the clients send their requests through, or is nil for
+	// them to send the requests themselves
+	newBackend NewBackendFunc
+}
+
+// NewPool creates an empty client pool
+func NewPool() *Pool {
+	return NewPoolWithOptions(TransportOptions{})
+}
+
+// NewPoolWithOptions creates an empty client pool whose clients' connections are tuned by opts
+func NewPoolWithOptions(opts TransportOptions) *Pool {
+	return &Pool{
+		clients: make(map[string]*Client),
+		opts:    opts,
+	}
+}
+
+// Get returns the client for the given address, creating it on first use
+func (p *Pool) Get(baseURL string) *Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	client, ok := p.clients[baseURL]
+	if !ok {
+		client = p.newClient(baseURL)
+		client.SetRetryPolicy(p.retry)
+		p.clients[baseURL] = client
+	}
+
+	return client
+}
+
+// newClient creates the client for baseURL, through a backend when the pool has one. A backend
+// that cannot be created fails every request of the client with why.
+func (p *Pool) newClient(baseURL string) *Client {
+	if p.newBackend == nil {
+		return NewClientWithOptions(baseURL, p.tlsConfig, p.opts)
+	}
+
+	backend, err := p.newBackend(baseURL, p.tlsConfig, p.opts)
+	if err != nil {
+		backend = failedBackend{err: fmt.Errorf("failed to create the client of %s: %w", baseURL, err)}
+	}
+
+	return NewClientWithBackend(baseURL, backend)
+}
+
+// SetBackend has the clients of the pool send their requests through the backends newBackend
+// creates. Existing clients are closed and forgotten, so every client uses one from then on.
+func (p *Pool) SetBackend(newBackend NewBackendFunc) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.newBackend = newBackend
+	for baseURL, client := range p.clients {
+		client.Close()
+		delete(p.clients, baseURL)
+	}
+}
+
+// SetTLSConfig sets how https:// addresses are verified. Existing clients are closed and
+// forgotten, so a rotated CA bundle applies from the next request on.
+func (p *Pool) SetTLSConfig(tlsConfig *tls.Config) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.tlsConfig = tlsConfig
+	for baseURL, client := range p.clients {
+		client.Close()
+		delete(p.clients, baseURL)
+	}
+}
+
+// SetRetryPolicy sets how the clients in the pool retry requests failing with a transient error
+func (p *Pool) SetRetryPolicy(policy RetryPolicy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.retry = policy
+	for _, client := range p.clients {
+		client.SetRetryPolicy(policy)
+	}
+}
+
+// Retain closes and forgets the clients of all addresses not in baseURLs, so connections to
+// pods that went away are not kept open
+func (p *Pool) Retain(baseURLs []string) {
+	keep := make(map[string]bool, len(baseURLs))
+	for _, baseURL := range baseURLs {
+		keep[baseURL] = true
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for baseURL, client := range p.clients {
+		if !keep[baseURL] {
+			client.Close()
+			delete(p.clients, baseURL)
+		}
+	}
+}
+
+// Len returns the number of clients in the pool
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.clients)
+}
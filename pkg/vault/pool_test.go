@@ -0,0 +1,119 @@
+package vault
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/httplog"
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoolGet(t *testing.T) {
+	pool := NewPool()
+
+	first := pool.Get("http://10.0.0.1:8200")
+	second := pool.Get("http://10.0.0.2:8200")
+
+	assert.Same(t, first, pool.Get("http://10.0.0.1:8200"), "expected the client to be reused")
+	assert.NotSame(t, first, second)
+	assert.Equal(t, 2, pool.Len())
+
+	transport, ok := baseTransport(first).(*http.Transport)
+	assert.True(t, ok, "expected a dedicated transport per client")
+	assert.True(t, transport.ForceAttemptHTTP2)
+
+	secondTransport, ok := baseTransport(second).(*http.Transport)
+	assert.True(t, ok)
+	assert.NotSame(t, transport, secondTransport, "expected one connection pool per endpoint")
+}
+
+func TestPoolWithOptions(t *testing.T) {
+	pool := NewPoolWithOptions(TransportOptions{MaxIdleConnsPerHost: 8, IdleConnTimeout: 30 * time.Second})
+
+	transport, ok := baseTransport(pool.Get("http://10.0.0.1:8200")).(*http.Transport)
+	assert.True(t, ok)
+	assert.Equal(t, 8, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, 30*time.Second, transport.IdleConnTimeout)
+
+	// Unset options keep the defaults
+	transport, ok = baseTransport(NewPool().Get("http://10.0.0.1:8200")).(*http.Transport)
+	assert.True(t, ok)
+	assert.Equal(t, defaultMaxIdleConnsPerHost, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, defaultIdleConnTimeout, transport.IdleConnTimeout)
+}
+
+// baseTransport returns the transport below the request logging wrapper
+func baseTransport(c *Client) http.RoundTripper {
+	if logged, ok := c.httpClient.Transport.(*httplog.Transport); ok {
+		return logged.Base
+	}
+
+	return c.httpClient.Transport
+}
+
+func TestPoolRetain(t *testing.T) {
+	pool := NewPool()
+
+	kept := pool.Get("http://10.0.0.1:8200")
+	pool.Get("http://10.0.0.2:8200")
+
+	pool.Retain([]string{"http://10.0.0.1:8200"})
+
+	assert.Equal(t, 1, pool.Len())
+	assert.Same(t, kept, pool.Get("http://10.0.0.1:8200"))
+
+	pool.Retain(nil)
+	assert.Equal(t, 0, pool.Len())
+}
+
+func TestPoolSetTLSConfig(t *testing.T) {
+	pool := NewPool()
+
+	before := pool.Get("https://10.0.0.1:8200")
+	pool.SetTLSConfig(NewTLSConfig(nil, "vault.vault.svc", nil))
+
+	assert.Equal(t, 0, pool.Len(), "expected existing clients to be forgotten")
+	after := pool.Get("https://10.0.0.1:8200")
+	assert.NotSame(t, before, after)
+
+	transport, ok := baseTransport(after).(*http.Transport)
+	assert.True(t, ok)
+	assert.Equal(t, "vault.vault.svc", transport.TLSClientConfig.ServerName)
+}
+
+func TestPoolSetBackend(t *testing.T) {
+	fakeVault := vaulttest.NewServer()
+	defer fakeVault.Close()
+
+	pool := NewPool()
+	pool.SetTLSConfig(NewTLSConfig(nil, "vault.vault.svc", nil))
+	before := pool.Get(fakeVault.URL)
+
+	var serverName string
+	backend := &recordingBackend{baseURL: fakeVault.URL}
+	pool.SetBackend(func(baseURL string, tlsConfig *tls.Config, _ TransportOptions) (Backend, error) {
+		serverName = tlsConfig.ServerName
+		if baseURL != fakeVault.URL {
+			return nil, errors.New("no such Vault")
+		}
+
+		return backend, nil
+	})
+	assert.Equal(t, 0, pool.Len(), "expected existing clients to be forgotten")
+
+	client := pool.Get(fakeVault.URL)
+	assert.NotSame(t, before, client)
+	_, err := client.CheckStatus(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"GET /v1/sys/seal-status"}, backend.paths)
+	assert.Equal(t, "vault.vault.svc", serverName, "expected the backend to be given the TLS configuration of the pool")
+
+	// A backend that cannot be created fails the requests of its client
+	_, err = pool.Get("http://10.0.0.1:8200").CheckStatus(context.Background())
+	assert.ErrorContains(t, err, "no such Vault")
+}
@@ -0,0 +1,158 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// rekeyPath is the API of unseal key rekeys, which only exist on Shamir-sealed Vaults
+	rekeyPath = "/v1/sys/rekey"
+	// rekeyRecoveryKeyPath is the API of recovery key rekeys, which only exist on auto-unseal
+	// Vaults
+	rekeyRecoveryKeyPath = "/v1/sys/rekey-recovery-key"
+	// cancelRekeyTimeout bounds cancelling a rekey, which is also done after ctx was cancelled
+	cancelRekeyTimeout = 10 * time.Second
+)
+
+// RekeyRecoveryKeys replaces the recovery keys of an auto-unseal Vault with shares new keys, of
+// which threshold are needed to authorize operations such as generating a root token. It starts
+// a rekey, submits the current keys until Vault has enough of them and returns the new keys.
+// The rekey is cancelled when it cannot be completed, so the current keys stay valid.
+//
+// Rekey requests are not retried: a submitted key may have been counted even when its answer
+// was lost.
+func (c *Client) RekeyRecoveryKeys(ctx context.Context, token string, keys []string, shares, threshold int) ([]string, error) {
+	rekey, err := c.rekey(ctx, rekeyRecoveryKeyPath, "recovery key", token, keys, RekeyRequest{SecretShares: shares, SecretThreshold: threshold})
+	if err != nil {
+		return nil, err
+	}
+
+	return rekey.Keys, nil
+}
+
+// RekeyUnsealKeys replaces the unseal keys of a Shamir-sealed Vault with shares new keys, of
+// which threshold are needed to unseal. The rekey requires verification: the current keys keep
+// unsealing Vault until the new keys returned are submitted back with VerifyRekey, so they can
+// be stored safely first. The rekey is cancelled when it cannot be completed.
+func (c *Client) RekeyUnsealKeys(ctx context.Context, token string, keys []string, shares, threshold int) (*RekeyResponse, error) {
+	rekey, err := c.rekey(ctx, rekeyPath, "unseal key", token, keys, RekeyRequest{SecretShares: shares, SecretThreshold: threshold, RequireVerification: true})
+	if err != nil {
+		return nil, err
+	}
+	if !rekey.VerificationRequired || rekey.VerificationNonce == "" {
+		c.CancelRekey(ctx, token)
+		return nil, fmt.Errorf("vault completed the rekey without asking for verification")
+	}
+
+	return rekey, nil
+}
+
+// VerifyRekey finishes a rekey started by RekeyUnsealKeys by submitting the new keys with the
+// verification nonce, after which Vault only accepts the new keys. The rekey is cancelled when
+// they are not accepted, so the current keys stay valid.
+func (c *Client) VerifyRekey(ctx context.Context, token, nonce string, newKeys []string) error {
+	for _, key := range newKeys {
+		body, err := json.Marshal(map[string]string{"key": key, "nonce": nonce})
+		if err != nil {
+			c.CancelRekey(ctx, token)
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+
+		verify, err := c.rekeyRequest(ctx, http.MethodPut, rekeyPath+"/verify", token, body, ErrInvalidKey)
+		if err != nil {
+			c.CancelRekey(ctx, token)
+			return fmt.Errorf("failed to verify new unseal key: %w", err)
+		}
+		if verify.Complete {
+			return nil
+		}
+	}
+
+	c.CancelRekey(ctx, token)
+
+	return fmt.Errorf("rekey verification did not complete with the %d new keys", len(newKeys))
+}
+
+// CancelRekey throws away the unseal key rekey in progress, such as one whose new keys could not
+// be stored before verification. It is best effort, like every cancellation.
+func (c *Client) CancelRekey(ctx context.Context, token string) {
+	c.cancelRekey(ctx, rekeyPath, token)
+}
+
+// rekey runs a rekey on the API at base: it starts it with req and submits the current keys
+// until Vault has enough of them. kind names the keys in errors.
+func (c *Client) rekey(ctx context.Context, base, kind, token string, keys []string, req RekeyRequest) (*RekeyResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	rekey, err := c.rekeyRequest(ctx, http.MethodPut, base+"/init", token, body, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start %s rekey: %w", kind, err)
+	}
+
+	for _, key := range keys {
+		body, err := json.Marshal(map[string]string{"key": key, "nonce": rekey.Nonce})
+		if err != nil {
+			c.cancelRekey(ctx, base, token)
+			return nil, fmt.Errorf("failed to marshal request: %w", err)
+		}
+
+		rekey, err = c.rekeyRequest(ctx, http.MethodPut, base+"/update", token, body, ErrInvalidKey)
+		if err != nil {
+			c.cancelRekey(ctx, base, token)
+			return nil, fmt.Errorf("failed to submit %s: %w", kind, err)
+		}
+
+		if rekey.Complete {
+			if len(rekey.Keys) != req.SecretShares {
+				return nil, fmt.Errorf("incomplete rekey response: %d of %d keys", len(rekey.Keys), req.SecretShares)
+			}
+
+			return rekey, nil
+		}
+	}
+
+	c.cancelRekey(ctx, base, token)
+
+	return nil, fmt.Errorf("%s rekey needs %d keys, only %d were accepted", kind, rekey.Required, rekey.Progress)
+}
+
+// rekeyRequest sends a request to a rekey API and decodes its progress. A 400 Vault does not
+// explain otherwise stands for badRequest, when it is not nil.
+func (c *Client) rekeyRequest(ctx context.Context, method, path, token string, body []byte, badRequest error) (*RekeyResponse, error) {
+	resp, err := c.do(ctx, method, path, token, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, unexpectedResponse(resp, badRequest)
+	}
+
+	var rekey RekeyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&rekey); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &rekey, nil
+}
+
+// cancelRekey throws away the rekey in progress on the API at base. It is best effort: a rekey
+// left behind only makes the next one fail to start until it is cancelled.
+func (c *Client) cancelRekey(ctx context.Context, base, token string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cancelRekeyTimeout)
+	defer cancel()
+
+	resp, err := c.do(ctx, http.MethodDelete, base+"/init", token, nil)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,116 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRekeyRecoveryKeys(t *testing.T) {
+	fakeVault := vaulttest.NewAutoUnsealServer(5, 3)
+	defer fakeVault.Close()
+
+	client := NewClient(fakeVault.URL)
+	defer client.Close()
+
+	oldKeys := fakeVault.Keys()
+	newKeys, err := client.RekeyRecoveryKeys(context.Background(), "", oldKeys, 3, 2)
+	assert.NoError(t, err)
+	assert.Len(t, newKeys, 3)
+	assert.Equal(t, fakeVault.Keys(), newKeys)
+
+	status, err := client.CheckStatus(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, status.RecoverySeal)
+	assert.Equal(t, 2, status.Threshold)
+	assert.Equal(t, 3, status.Shares)
+
+	// The replaced keys no longer authorize a rekey
+	_, err = client.RekeyRecoveryKeys(context.Background(), "", oldKeys, 3, 2)
+	assert.True(t, errors.Is(err, ErrInvalidKey), "expected the old keys to be rejected, got %v", err)
+}
+
+func TestRekeyRecoveryKeysFailures(t *testing.T) {
+	tests := []struct {
+		name          string
+		server        func() *vaulttest.Server
+		keys          func(*vaulttest.Server) []string
+		expectedError string
+	}{
+		{
+			name:          "fewer keys than the threshold",
+			server:        func() *vaulttest.Server { return vaulttest.NewAutoUnsealServer(5, 3) },
+			keys:          func(s *vaulttest.Server) []string { return s.Keys()[:2] },
+			expectedError: "recovery key rekey needs 3 keys, only 2 were accepted",
+		},
+		{
+			name:          "Shamir seal",
+			server:        func() *vaulttest.Server { return vaulttest.NewInitializedServer(5, 3) },
+			keys:          func(s *vaulttest.Server) []string { return s.Keys() },
+			expectedError: "failed to start recovery key rekey: unexpected status code: 400: recovery rekeying not supported",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeVault := tt.server()
+			defer fakeVault.Close()
+
+			client := NewClient(fakeVault.URL)
+			defer client.Close()
+
+			_, err := client.RekeyRecoveryKeys(context.Background(), "", tt.keys(fakeVault), 5, 3)
+			assert.EqualError(t, err, tt.expectedError)
+
+			// A failed rekey is cancelled, so the next one can start
+			assert.False(t, fakeVault.Rekeying())
+		})
+	}
+}
+
+func TestRekeyUnsealKeys(t *testing.T) {
+	fakeVault := vaulttest.NewInitializedServer(5, 3)
+	defer fakeVault.Close()
+
+	client := NewClient(fakeVault.URL)
+	defer client.Close()
+
+	oldKeys := fakeVault.Keys()
+	rekey, err := client.RekeyUnsealKeys(context.Background(), "", oldKeys, 3, 2)
+	assert.NoError(t, err)
+	assert.Len(t, rekey.Keys, 3)
+	assert.Len(t, rekey.EncodedKeys(KeyEncodingBase64), 3)
+
+	// The current keys stay valid until the new ones are verified
+	assert.Equal(t, oldKeys, fakeVault.Keys())
+	assert.True(t, fakeVault.Rekeying())
+
+	assert.NoError(t, client.VerifyRekey(context.Background(), "", rekey.VerificationNonce, rekey.EncodedKeys(KeyEncodingBase64)))
+	assert.Equal(t, rekey.Keys, fakeVault.Keys())
+	assert.False(t, fakeVault.Rekeying())
+
+	status, err := client.CheckStatus(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, status.Threshold)
+	assert.Equal(t, 3, status.Shares)
+}
+
+func TestVerifyRekeyFailureKeepsCurrentKeys(t *testing.T) {
+	fakeVault := vaulttest.NewInitializedServer(5, 3)
+	defer fakeVault.Close()
+
+	client := NewClient(fakeVault.URL)
+	defer client.Close()
+
+	oldKeys := fakeVault.Keys()
+	rekey, err := client.RekeyUnsealKeys(context.Background(), "", oldKeys, 3, 2)
+	assert.NoError(t, err)
+
+	err = client.VerifyRekey(context.Background(), "", rekey.VerificationNonce, oldKeys[:2])
+	assert.True(t, errors.Is(err, ErrInvalidKey), "expected the wrong keys to be rejected, got %v", err)
+	assert.Equal(t, oldKeys, fakeVault.Keys())
+	assert.False(t, fakeVault.Rekeying(), "expected the failed rekey to be cancelled")
+}
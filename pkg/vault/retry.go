@@ -0,0 +1,87 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy controls how requests failing with a transient error, such as a refused
+// connection or a 5xx from a pod that is still starting, are retried before giving up. The
+// zero value sends every request once.
+type RetryPolicy struct {
+	// MaxAttempts is how often a request is sent at most, including the first attempt
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry, doubled for every further one
+	BaseDelay time.Duration
+	// Jitter is the fraction of every wait, from 0 to 1, that is randomized so requests to
+	// several pods failing at once are not retried in lockstep
+	Jitter float64
+}
+
+// delay returns the wait before the given retry, counting from 1
+func (p RetryPolicy) delay(retry int) time.Duration {
+	delay := p.BaseDelay << (retry - 1)
+	if p.Jitter > 0 {
+		delay -= time.Duration(p.Jitter * rand.Float64() * float64(delay))
+	}
+
+	return delay
+}
+
+// retryCondition reports whether a request that ended with resp or err may be sent again
+type retryCondition func(resp *http.Response, err error) bool
+
+// transientFailure is the retry condition of requests that are safe to repeat: connection
+// errors and 5xx responses
+func transientFailure(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	switch resp.StatusCode {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+// notSent is the retry condition of requests that must not be repeated once Vault may have acted
+// on them, such as init: only a connection that could not be established is retried
+func notSent(_ *http.Response, err error) bool {
+	var opErr *net.OpError
+
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// doWithRetry sends a request like do and sends it again, following the client's retry policy,
+// for as long as it fails in a way retry accepts and ctx is not done
+func (c *Client) doWithRetry(ctx context.Context, method, path, token string, body []byte, retry retryCondition) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := c.do(ctx, method, path, token, body)
+		if attempt >= c.retry.MaxAttempts || ctx.Err() != nil || !retry(resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(c.retry.delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// SetRetryPolicy sets how the client retries requests failing with a transient error
+func (c *Client) SetRetryPolicy(policy RetryPolicy) {
+	c.retry = policy
+}
@@ -0,0 +1,151 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryTransientFailures(t *testing.T) {
+	tests := []struct {
+		name             string
+		failures         int
+		failureStatus    int
+		call             func(*Client) error
+		expectedRequests int32
+		expectedError    bool
+	}{
+		{
+			name:             "status check retried after a 503",
+			failures:         1,
+			failureStatus:    http.StatusServiceUnavailable,
+			call:             func(c *Client) error { _, err := c.CheckStatus(context.Background()); return err },
+			expectedRequests: 2,
+		},
+		{
+			name:             "unseal retried after a 502",
+			failures:         2,
+			failureStatus:    http.StatusBadGateway,
+			call:             func(c *Client) error { _, err := c.UnsealWithKey(context.Background(), "key1"); return err },
+			expectedRequests: 3,
+		},
+		{
+			name:             "unseal gives up after the last attempt",
+			failures:         3,
+			failureStatus:    http.StatusInternalServerError,
+			call:             func(c *Client) error { _, err := c.UnsealWithKey(context.Background(), "key1"); return err },
+			expectedRequests: 3,
+			expectedError:    true,
+		},
+		{
+			name:             "client error not retried",
+			failures:         1,
+			failureStatus:    http.StatusBadRequest,
+			call:             func(c *Client) error { _, err := c.UnsealWithKey(context.Background(), "key1"); return err },
+			expectedRequests: 1,
+			expectedError:    true,
+		},
+		{
+			name:             "init not retried once it reached Vault",
+			failures:         1,
+			failureStatus:    http.StatusInternalServerError,
+			call:             func(c *Client) error { _, err := c.Initialize(context.Background()); return err },
+			expectedRequests: 1,
+			expectedError:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if int(atomic.AddInt32(&requests, 1)) <= tt.failures {
+					w.WriteHeader(tt.failureStatus)
+
+					return
+				}
+				switch r.URL.Path {
+				case "/v1/sys/seal-status":
+					fmt.Fprint(w, `{"initialized": true, "sealed": true}`)
+				case "/v1/sys/unseal":
+					fmt.Fprint(w, `{"sealed": false}`)
+				case "/v1/sys/init":
+					fmt.Fprint(w, `{"keys": ["key1"], "root_token": "root-token"}`)
+				}
+			}))
+			defer server.Close()
+
+			client := NewClient(server.URL)
+			client.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})
+
+			err := tt.call(client)
+			assert.Equal(t, tt.expectedError, err != nil, "unexpected error %v", err)
+			assert.Equal(t, tt.expectedRequests, atomic.LoadInt32(&requests))
+		})
+	}
+}
+
+func TestInitializeRetriesRefusedConnection(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"keys": ["key1"], "root_token": "root-token"}`)
+	}))
+	defer server.Close()
+
+	// The first connection is refused, as by a pod whose listener is not up yet
+	var dials int32
+	dialer := &net.Dialer{}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if atomic.AddInt32(&dials, 1) == 1 {
+				return nil, &net.OpError{Op: "dial", Net: network, Err: syscall.ECONNREFUSED}
+			}
+
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+	client := NewClientWithHTTPClient(server.URL, &http.Client{Transport: transport})
+	client.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})
+
+	resp, err := client.Initialize(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "root-token", resp.RootToken)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&dials))
+}
+
+func TestRetryStopsWithContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	client.SetRetryPolicy(RetryPolicy{MaxAttempts: 5, BaseDelay: time.Minute})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.CheckStatus(ctx)
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second, "expected the wait between retries to end with the context")
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond}
+	assert.Equal(t, 100*time.Millisecond, policy.delay(1))
+	assert.Equal(t, 400*time.Millisecond, policy.delay(3))
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		delay := policy.delay(2)
+		assert.True(t, delay > 100*time.Millisecond && delay <= 200*time.Millisecond, "delay %v outside the jitter range", delay)
+	}
+}
@@ -1,31 +1,301 @@
 package vault
 
+// SecretFormat is the current format of the keys Secrets: key<N> entries in the encoding named by
+// KeyEncodingAnnotation, with the seal configuration in ThresholdAnnotation and SharesAnnotation
+const SecretFormat = "v2"
+
 const (
 	RootTokenSecret  = "vault-root-token"
 	UnsealKeysSecret = "vault-unseal-keys"
+	// RecoveryKeysSecret holds the recovery keys of an auto-unseal Vault, when they are not kept
+	// with a key provider
+	RecoveryKeysSecret = "vault-recovery-keys"
+	// AdminTokenSecret holds the limited admin token created before the root token is revoked
+	AdminTokenSecret = "vault-admin-token"
+	// PendingUnsealKeysSecret holds the new unseal keys of a rekey until Vault verified them and
+	// they replaced the keys in the unseal keys Secret
+	PendingUnsealKeysSecret = "vault-unseal-keys-pending"
+
+	// ThresholdAnnotation records on the unseal keys Secret how many keys are needed to unseal
+	ThresholdAnnotation = "vault-utils.growly.io/threshold"
+	// SharesAnnotation records on the unseal keys Secret how many keys were generated at init
+	SharesAnnotation = "vault-utils.growly.io/shares"
+
+	// InitializedByAnnotation records on the Secrets created at init which controller instance
+	// initialized the cluster
+	InitializedByAnnotation = "vault-utils.growly.io/initialized-by"
+	// InitializedAtAnnotation records on the Secrets created at init when the cluster was
+	// initialized, in RFC 3339 format
+	InitializedAtAnnotation = "vault-utils.growly.io/initialized-at"
+	// ConfigHashAnnotation records on the Secrets created at init a hash of the controller
+	// configuration in effect
+	ConfigHashAnnotation = "vault-utils.growly.io/config-hash"
+
+	// MigratedFromAnnotation records on the Secrets written by the migrate command which unseal
+	// automation the key material was taken over from
+	MigratedFromAnnotation = "vault-utils.growly.io/migrated-from"
+	// MigratedAtAnnotation records on the Secrets written by the migrate command when the key
+	// material was taken over, in RFC 3339 format
+	MigratedAtAnnotation = "vault-utils.growly.io/migrated-at"
+
+	// RekeyedAtAnnotation records on the unseal and recovery keys Secrets when the keys were last
+	// rotated, in RFC 3339 format
+	RekeyedAtAnnotation = "vault-utils.growly.io/rekeyed-at"
+	// SealMigratedAtAnnotation records on the keys Secret written after a seal migration when
+	// the keys moved to it, in RFC 3339 format
+	SealMigratedAtAnnotation = "vault-utils.growly.io/seal-migrated-at"
+
+	// ClusterIDAnnotation records on the unseal keys Secret the ID of the Vault cluster the keys
+	// belong to, learned the first time it is seen unsealed
+	ClusterIDAnnotation = "vault-utils.growly.io/cluster-id"
+	// ClusterNameAnnotation records on the unseal keys Secret the name of the Vault cluster the
+	// keys belong to
+	ClusterNameAnnotation = "vault-utils.growly.io/cluster-name"
+
+	// PGPEncryptedAnnotation marks the Secrets created at init whose keys or root token Vault
+	// encrypted to operators' PGP keys, which only the operators can decrypt
+	PGPEncryptedAnnotation = "vault-utils.growly.io/pgp-encrypted"
+	// KeyEncodingAnnotation records on the Secrets created at init whether the keys are stored
+	// hex or base64 encoded
+	KeyEncodingAnnotation = "vault-utils.growly.io/key-encoding"
+	// FormatAnnotation records on the unseal and recovery keys Secrets the version of their
+	// layout, SecretFormat for those written by this version. Secrets without it predate it.
+	FormatAnnotation = "vault-utils.growly.io/format"
+	// FormatUpgradedAtAnnotation records on the keys Secrets when the controller upgraded them
+	// from an earlier format, in RFC 3339 format
+	FormatUpgradedAtAnnotation = "vault-utils.growly.io/format-upgraded-at"
+	// AutopilotConfigAnnotation records on the root token Secret the autopilot configuration the
+	// controller applied, as JSON, so it is applied again only when it changes
+	AutopilotConfigAnnotation = "vault-utils.growly.io/autopilot-config"
+
+	// RootTokenRevokedAtAnnotation records on the root token Secret when the controller revoked
+	// the root token, in RFC 3339 format
+	RootTokenRevokedAtAnnotation = "vault-utils.growly.io/root-token-revoked-at"
 )
 
-// Status represents the current status of a Vault instance
+// Status represents the current status of a Vault instance, as reported by sys/seal-status
 type Status struct {
-	Initialized bool `json:"initialized"`
-	Sealed      bool `json:"sealed"`
+	// Type is the seal type, such as shamir, or awskms for auto-unseal
+	Type        string `json:"type"`
+	Initialized bool   `json:"initialized"`
+	Sealed      bool   `json:"sealed"`
+	// Threshold and Shares are the number of keys needed to unseal and generated at init
+	Threshold int `json:"t"`
+	Shares    int `json:"n"`
+	// Progress is the number of keys applied in the unseal attempt in progress
+	Progress int `json:"progress"`
+	// Nonce identifies the unseal attempt in progress
+	Nonce   string `json:"nonce"`
+	Version string `json:"version"`
+	// BuildDate is when the Vault binary was built, reported by Vault 1.13 and later
+	BuildDate string `json:"build_date"`
+	// StorageType is the storage backend, such as raft
+	StorageType string `json:"storage_type"`
+	// Migration is set while the seal is being migrated to another type
+	Migration bool `json:"migration"`
+	// RecoverySeal is set when Threshold and Shares describe recovery keys, as with auto-unseal
+	RecoverySeal bool `json:"recovery_seal"`
+	// ClusterName and ClusterID are only reported once Vault is unsealed
+	ClusterName string `json:"cluster_name"`
+	ClusterID   string `json:"cluster_id"`
 }
 
-// InitRequest represents a request to initialize a new Vault instance
+// InitRequest represents a request to initialize a new Vault instance. An auto-unseal Vault
+// takes recovery shares and threshold instead of secret ones, which it ignores.
 type InitRequest struct {
-	SecretShares    int `json:"secret_shares"`
-	SecretThreshold int `json:"secret_threshold"`
+	SecretShares      int `json:"secret_shares"`
+	SecretThreshold   int `json:"secret_threshold"`
+	RecoveryShares    int `json:"recovery_shares,omitempty"`
+	RecoveryThreshold int `json:"recovery_threshold,omitempty"`
+	// PGPKeys and RecoveryPGPKeys have Vault encrypt each key share it hands out to the
+	// base64-encoded PGP public key in the same position, and RootTokenPGPKey the root token.
+	// There must be one key per share.
+	PGPKeys         []string `json:"pgp_keys,omitempty"`
+	RecoveryPGPKeys []string `json:"recovery_pgp_keys,omitempty"`
+	RootTokenPGPKey string   `json:"root_token_pgp_key,omitempty"`
 }
 
 // InitResponse represents the response from initializing a new Vault instance
 type InitResponse struct {
-	RootToken string   `json:"root_token"`
-	Keys      []string `json:"keys"`
+	RootToken string `json:"root_token"`
+	// Keys and KeysBase64 are the same key shares, hex and base64 encoded
+	Keys       []string `json:"keys"`
+	KeysBase64 []string `json:"keys_base64"`
+	// RecoveryKeys are handed out instead of Keys by an auto-unseal Vault
+	RecoveryKeys       []string `json:"recovery_keys"`
+	RecoveryKeysBase64 []string `json:"recovery_keys_base64"`
 }
 
-// UnsealResponse represents the response from unsealing a Vault instance
-type UnsealResponse struct {
-	Sealed bool `json:"sealed"`
+// UnsealProgress is how far an unseal attempt got
+type UnsealProgress struct {
+	Sealed bool
+	// Progress is the number of keys applied in the attempt, out of the Threshold needed
+	Progress  int
+	Threshold int
+}
+
+// Remaining returns how many more keys are needed to unseal, 0 once Vault is unsealed
+func (p *UnsealProgress) Remaining() int {
+	if !p.Sealed || p.Progress >= p.Threshold {
+		return 0
+	}
+
+	return p.Threshold - p.Progress
+}
+
+// UnsealProgress returns how far the unseal attempt in progress got
+func (s *Status) UnsealProgress() *UnsealProgress {
+	return &UnsealProgress{Sealed: s.Sealed, Progress: s.Progress, Threshold: s.Threshold}
+}
+
+// SealTypeShamir is the seal type of a Vault unsealed with key shares
+const SealTypeShamir = "shamir"
+
+// AutoUnseal reports whether Vault unseals itself through its seal, such as awskms, gcpckms or
+// transit, rather than with key shares. Unseal keys must never be submitted to such a Vault; its
+// key shares, if any, are recovery keys.
+func (s *Status) AutoUnseal() bool {
+	return s.RecoverySeal || (s.Type != "" && s.Type != SealTypeShamir)
+}
+
+// StorageTypeRaft is the storage type of a Vault using integrated storage
+const StorageTypeRaft = "raft"
+
+// Raft reports whether Vault uses integrated storage, whose members must join the cluster
+// rather than be initialized
+func (s *Status) Raft() bool {
+	return s.StorageType == StorageTypeRaft
+}
+
+// RaftJoinRequest asks an uninitialized Vault using integrated storage to join the cluster led
+// by the Vault at LeaderAPIAddr
+type RaftJoinRequest struct {
+	LeaderAPIAddr string `json:"leader_api_addr"`
+	// LeaderCACert is the PEM encoded CA bundle the leader's listener is verified with
+	LeaderCACert string `json:"leader_ca_cert,omitempty"`
+}
+
+// RaftServer is a member of the raft cluster of a Vault using integrated storage, as listed by
+// sys/storage/raft/configuration
+type RaftServer struct {
+	NodeID  string `json:"node_id"`
+	Address string `json:"address"`
+	// Leader is set for the active node
+	Leader bool `json:"leader"`
+	// Voter is set for a member that counts toward quorum, unset for a non-voter that only
+	// replicates
+	Voter           bool   `json:"voter"`
+	ProtocolVersion string `json:"protocol_version"`
+}
+
+// AutopilotConfig is the autopilot configuration of a raft cluster, which removes dead servers
+// and promotes new ones. Durations are given in Vault's format, such as 10s, and fields left
+// empty keep Vault's value, except CleanupDeadServers.
+type AutopilotConfig struct {
+	CleanupDeadServers             bool   `json:"cleanup_dead_servers"`
+	MinQuorum                      int    `json:"min_quorum,omitempty"`
+	ServerStabilizationTime        string `json:"server_stabilization_time,omitempty"`
+	DeadServerLastContactThreshold string `json:"dead_server_last_contact_threshold,omitempty"`
+}
+
+// RekeyRequest starts replacing the key shares of a Vault with a new split
+type RekeyRequest struct {
+	SecretShares    int `json:"secret_shares"`
+	SecretThreshold int `json:"secret_threshold"`
+	// RequireVerification keeps the current keys valid until the new ones are submitted back
+	RequireVerification bool `json:"require_verification,omitempty"`
+}
+
+// RekeyResponse represents the progress of a rekey, and the new key shares once it completed
+type RekeyResponse struct {
+	// Nonce identifies the rekey in progress and must accompany every key submitted to it
+	Nonce   string `json:"nonce"`
+	Started bool   `json:"started"`
+	// Progress is the number of current keys submitted, out of the Required ones
+	Progress int `json:"progress"`
+	Required int `json:"required"`
+	// Complete is set once enough current keys were submitted, and Keys then holds the new shares
+	Complete bool     `json:"complete"`
+	Keys     []string `json:"keys"`
+	// KeysBase64 holds the same new shares base64 encoded
+	KeysBase64 []string `json:"keys_base64"`
+	// VerificationRequired is set when the new shares only take effect once submitted back with
+	// VerificationNonce
+	VerificationRequired bool   `json:"verification_required"`
+	VerificationNonce    string `json:"verification_nonce"`
+}
+
+// GenerateRootResponse is the progress of a root token generation
+type GenerateRootResponse struct {
+	// Nonce identifies the attempt in progress and must accompany every key submitted to it
+	Nonce   string `json:"nonce"`
+	Started bool   `json:"started"`
+	// Progress is the number of keys submitted, out of the Required ones
+	Progress int `json:"progress"`
+	Required int `json:"required"`
+	// Complete is set once enough keys were submitted, and EncodedToken then holds the new root
+	// token encoded with the OTP
+	Complete     bool   `json:"complete"`
+	EncodedToken string `json:"encoded_token"`
+	// OTP is the one-time password Vault generated when the attempt was started, which decodes
+	// the token
+	OTP       string `json:"otp"`
+	OTPLength int    `json:"otp_length"`
+}
+
+// TokenCreateRequest represents a request to create a token as a child of the calling one
+type TokenCreateRequest struct {
+	Policies []string `json:"policies"`
+	// TTL is a duration such as 15m, the TTL of the calling token is used when it is empty
+	TTL string `json:"ttl,omitempty"`
+	// NumUses is how many requests the token may make, unlimited when it is 0
+	NumUses     int               `json:"num_uses,omitempty"`
+	DisplayName string            `json:"display_name,omitempty"`
+	Meta        map[string]string `json:"meta,omitempty"`
+	// NoParent creates an orphan token, which is not revoked with the token creating it
+	NoParent bool `json:"no_parent,omitempty"`
+}
+
+// TokenAuth represents the token Vault created
+type TokenAuth struct {
+	ClientToken string   `json:"client_token"`
+	Accessor    string   `json:"accessor"`
+	Policies    []string `json:"policies"`
+	// LeaseDuration is the TTL of the token in seconds
+	LeaseDuration int  `json:"lease_duration"`
+	Renewable     bool `json:"renewable"`
+}
+
+// WrapInfo represents a response-wrapped secret, which is read by unwrapping Token
+type WrapInfo struct {
+	Token    string `json:"token"`
+	Accessor string `json:"accessor"`
+	// TTL is how long the wrapping token can be unwrapped, in seconds
+	TTL int `json:"ttl"`
+}
+
+// MountRequest represents a request to enable a secrets engine
+type MountRequest struct {
+	// Type is the type of the engine, such as kv-v2 or pki
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+}
+
+// LeaderResponse represents the response from the Vault leader endpoint
+type LeaderResponse struct {
+	HAEnabled     bool   `json:"ha_enabled"`
+	IsSelf        bool   `json:"is_self"`
+	LeaderAddress string `json:"leader_address"`
+}
+
+// HealthResponse represents the response from the Vault health endpoint
+type HealthResponse struct {
+	Initialized bool   `json:"initialized"`
+	Sealed      bool   `json:"sealed"`
+	Standby     bool   `json:"standby"`
+	Version     string `json:"version"`
+	// ServerTimeUTC is Vault's clock when it answered, in whole seconds since the Unix epoch
+	ServerTimeUTC int64 `json:"server_time_utc"`
 }
 
 // VaultStatus represents the health status of a Vault instance.
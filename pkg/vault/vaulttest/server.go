@@ -0,0 +1,1631 @@
+// Package vaulttest provides an in-memory fake of the Vault seal API for tests.
+//
+// The fake simulates the bookkeeping of Shamir unsealing: it hands out key shares at
+// initialization, tracks which shares have been submitted for the current attempt along with
+// its nonce, only opens once the threshold is reached, and discards the attempt when the
+// combined shares turn out to be wrong or when a reset is requested. Its unseal keys are
+// replaced through the rekey API, which requires verification when asked to. An auto-unseal
+// fake instead holds recovery keys, which it replaces through the recovery key rekey API.
+// Either kind generates a new root token from its keys through the generate-root API.
+package vaulttest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	shareLength = 33
+	sealType    = "shamir"
+	// autoSealType is the seal type of an auto-unseal fake
+	autoSealType = "awskms"
+	version      = "1.15.0"
+)
+
+// Server is a fake Vault server backed by httptest.Server
+type Server struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	initialized bool
+	sealed      bool
+	shares      int
+	threshold   int
+	keys        []string
+	rootToken   string
+	rootRevoked bool
+	clusterName string
+	clusterID   string
+	nonce       string
+	parts       []string
+	submitted   int
+	requests    atomic.Int64
+	// haEnabled and standby are what sys/leader reports, and stepDowns counts the requests to
+	// step down
+	haEnabled bool
+	standby   bool
+	stepDowns int
+	// agent makes the fake answer like a Vault Agent in front of the server
+	agent bool
+	// clockSkew is how far the clock sys/health reports is ahead of the real one
+	clockSkew time.Duration
+	// recoverySeal makes the fake an auto-unseal Vault, whose keys are recovery keys
+	recoverySeal bool
+	// migration makes the sealed fake wait for its seal to be migrated to the other kind, Shamir
+	// or auto-unseal, with its keys submitted with the migrate flag
+	migration bool
+	// rekey is the unseal or recovery key rekey in progress, if any
+	rekey *rekey
+	// generateRoot is the root token generation in progress, if any
+	generateRoot *generateRoot
+	// mounts holds the type of the secrets engines enabled, by path
+	mounts map[string]string
+	// tokens holds the tokens created with the root token, by accessor
+	tokens map[string]Token
+	// secrets holds the secrets that can be read, by path
+	secrets map[string]secret
+	// version is what seal-status reports as the Vault version, the package default when empty
+	version string
+	// storageType is what seal-status reports as the storage backend, if anything
+	storageType string
+	// peers are the members of a raft cluster the fake can join, and joinedTo the leader
+	// address it joined through
+	peers    []*Server
+	joinedTo string
+	// raftPeers is what sys/storage/raft/configuration lists
+	raftPeers []RaftPeer
+	// autopilot is the autopilot configuration written to the fake, if any
+	autopilot map[string]interface{}
+	// restored is the latest snapshot restored, and restoreForced whether it was forced
+	restored      []byte
+	restoreForced bool
+}
+
+// RaftPeer is a member of the raft cluster the fake lists
+type RaftPeer struct {
+	NodeID  string
+	Address string
+	Leader  bool
+	Voter   bool
+}
+
+// Token is a token the fake created
+type Token struct {
+	Policies []string
+	TTL      string
+	NumUses  int
+	Meta     map[string]string
+	// Wrapped is set when the token was only handed out response-wrapped
+	Wrapped bool
+	// NoParent is set for an orphan token
+	NoParent bool
+}
+
+// secret is a secret the fake serves to the token allowed to read it
+type secret struct {
+	token string
+	data  map[string]interface{}
+}
+
+// rekey is an unseal or recovery key rekey in progress
+type rekey struct {
+	nonce     string
+	shares    int
+	threshold int
+	parts     []string
+	// verification is set when the new keys only take effect once submitted back. newKeys then
+	// holds them once enough current keys were given, and verifyNonce and verified track their
+	// verification.
+	verification bool
+	newKeys      []string
+	verifyNonce  string
+	verified     []string
+}
+
+// generateRoot is a root token generation in progress
+type generateRoot struct {
+	nonce string
+	otp   string
+	parts []string
+}
+
+// NewServer starts a fake Vault that has not been initialized yet
+func NewServer() *Server {
+	s := &Server{sealed: true}
+	s.Server = httptest.NewServer(s.handler())
+
+	return s
+}
+
+// NewTLSServer starts a fake Vault like NewServer that serves TLS with a certificate for
+// example.com and 127.0.0.1, whose CA is the certificate of the embedded httptest.Server
+func NewTLSServer() *Server {
+	s := &Server{sealed: true}
+	s.Server = httptest.NewTLSServer(s.handler())
+
+	return s
+}
+
+// NewInitializedServer starts a sealed fake Vault that has already been initialized with the
+// given number of shares and threshold
+func NewInitializedServer(shares, threshold int) *Server {
+	s := NewServer()
+	s.initialize(shares, threshold)
+
+	return s
+}
+
+// NewAutoUnsealServer starts an unsealed fake auto-unseal Vault, such as one sealed with a
+// cloud KMS, that has been initialized with the given number of recovery key shares and
+// threshold
+func NewAutoUnsealServer(shares, threshold int) *Server {
+	s := NewServer()
+	s.initialize(shares, threshold)
+	s.recoverySeal = true
+	s.sealed = false
+
+	return s
+}
+
+// NewUninitializedAutoUnsealServer starts an uninitialized fake auto-unseal Vault, which
+// init hands recovery keys and leaves unsealed
+func NewUninitializedAutoUnsealServer() *Server {
+	s := NewServer()
+	s.recoverySeal = true
+
+	return s
+}
+
+// PGPEncrypt returns the stand-in for secret encrypted to pgpKey that the fake hands out when
+// init is given PGP keys. It is not encryption, only distinct from secret and deterministic.
+func PGPEncrypt(pgpKey, secret string) string {
+	return base64.StdEncoding.EncodeToString([]byte("pgp:" + pgpKey + ":" + secret))
+}
+
+// NewCluster starts the given number of sealed fake Vault servers that share the same key
+// shares, like the members of one Vault cluster
+func NewCluster(replicas, shares, threshold int) []*Server {
+	servers := make([]*Server, replicas)
+	for i := range servers {
+		servers[i] = NewServer()
+		if i == 0 {
+			servers[i].initialize(shares, threshold)
+			continue
+		}
+
+		servers[i].initialized = true
+		servers[i].shares = shares
+		servers[i].threshold = threshold
+		servers[i].keys = servers[0].keys
+		servers[i].rootToken = servers[0].rootToken
+		servers[i].clusterName = servers[0].clusterName
+		servers[i].clusterID = servers[0].clusterID
+	}
+
+	return servers
+}
+
+// NewRaftCluster starts the given number of uninitialized fake Vault servers using integrated
+// storage. Once one is initialized and unsealed, the others can join it through
+// sys/storage/raft/join, after which they share its keys and are unsealed with them.
+func NewRaftCluster(replicas int) []*Server {
+	servers := make([]*Server, replicas)
+	for i := range servers {
+		servers[i] = NewServer()
+		servers[i].storageType = "raft"
+		servers[i].peers = servers
+	}
+
+	return servers
+}
+
+// Requests returns the number of HTTP requests the fake has served
+func (s *Server) Requests() int {
+	return int(s.requests.Load())
+}
+
+// Keys returns the hex encoded key shares handed out at initialization, or by the last recovery
+// key rekey
+func (s *Server) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.keys...)
+}
+
+// RootToken returns the root token handed out at initialization
+func (s *Server) RootToken() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.rootToken
+}
+
+// RootTokenRevoked reports whether the root token revoked itself
+func (s *Server) RootTokenRevoked() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.rootRevoked
+}
+
+// Initialized reports whether the fake Vault has been initialized
+func (s *Server) Initialized() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.initialized
+}
+
+// JoinedTo returns the leader address the fake joined a raft cluster through, if any
+func (s *Server) JoinedTo() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.joinedTo
+}
+
+// ClusterID returns the cluster ID generated at initialization
+func (s *Server) ClusterID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.clusterID
+}
+
+// Sealed reports whether the fake Vault is sealed
+func (s *Server) Sealed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sealed
+}
+
+// Progress returns the number of shares submitted in the current unseal attempt
+func (s *Server) Progress() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.parts)
+}
+
+// Submitted returns the total number of key shares submitted to the fake, including shares
+// submitted while it was already unsealed
+func (s *Server) Submitted() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.submitted
+}
+
+// Mounts returns the type of the secrets engines enabled, by path
+func (s *Server) Mounts() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	mounts := make(map[string]string, len(s.mounts))
+	for path, engineType := range s.mounts {
+		mounts[path] = engineType
+	}
+
+	return mounts
+}
+
+// Tokens returns the tokens created with the root token, by accessor
+func (s *Server) Tokens() map[string]Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens := make(map[string]Token, len(s.tokens))
+	for accessor, token := range s.tokens {
+		tokens[accessor] = token
+	}
+
+	return tokens
+}
+
+// Rekeying reports whether an unseal or recovery key rekey is in progress
+func (s *Server) Rekeying() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.rekey != nil
+}
+
+// GeneratingRoot reports whether a root token generation is in progress
+func (s *Server) GeneratingRoot() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.generateRoot != nil
+}
+
+// Seal seals the fake Vault again, as happens when a Vault pod restarts
+func (s *Server) Seal() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sealed = true
+	s.resetAttempt()
+}
+
+// StartSealMigration seals the fake Vault again with its seal to be migrated, as happens when a
+// Vault pod restarts with a seal stanza added or disabled
+func (s *Server) StartSealMigration() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sealed = true
+	s.migration = true
+	s.resetAttempt()
+}
+
+// RecoverySeal reports whether the fake is an auto-unseal Vault, whose keys are recovery keys
+func (s *Server) RecoverySeal() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.recoverySeal
+}
+
+// SetHA makes the fake report HA mode on sys/leader, as the active node or as a standby
+func (s *Server) SetHA(standby bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.haEnabled = true
+	s.standby = standby
+}
+
+// StepDowns returns how many times the fake was asked to step down with the root token
+func (s *Server) StepDowns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.stepDowns
+}
+
+// SetClockSkew makes the time sys/health reports run ahead of the real clock by skew, or behind
+// it when skew is negative
+func (s *Server) SetClockSkew(skew time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clockSkew = skew
+}
+
+// SetVersion sets the Vault version the fake reports, such as a member already upgraded
+func (s *Server) SetVersion(version string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.version = version
+}
+
+// SetSecret makes the fake serve data at path, such as secret/data/app, to token
+func (s *Server) SetSecret(path, token string, data map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.secrets == nil {
+		s.secrets = make(map[string]secret)
+	}
+	s.secrets[path] = secret{token: token, data: data}
+}
+
+// SetRaftPeers sets the members sys/storage/raft/configuration lists to the root token
+func (s *Server) SetRaftPeers(peers []RaftPeer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.raftPeers = append([]RaftPeer(nil), peers...)
+}
+
+// Autopilot returns the autopilot configuration last written to the fake, nil when none was
+func (s *Server) Autopilot() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.autopilot
+}
+
+// Restored returns the latest snapshot restored on the fake, nil when none was, and whether the
+// restore was forced
+func (s *Server) Restored() ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.restored, s.restoreForced
+}
+
+// SetAgent makes the fake also answer on the API of Vault Agent, as an agent forwarding the
+// Vault API to a server does
+func (s *Server) SetAgent() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.agent = true
+}
+
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/sys/seal-status", s.handleSealStatus)
+	mux.HandleFunc("/v1/sys/health", s.handleHealth)
+	mux.HandleFunc("/v1/sys/init", s.handleInit)
+	mux.HandleFunc("/v1/sys/unseal", s.handleUnseal)
+	mux.HandleFunc("/v1/sys/leader", s.handleLeader)
+	mux.HandleFunc("/v1/sys/step-down", s.handleStepDown)
+	mux.HandleFunc("/v1/sys/storage/raft/join", s.handleRaftJoin)
+	mux.HandleFunc("/v1/sys/storage/raft/configuration", s.handleRaftConfiguration)
+	mux.HandleFunc("/v1/sys/storage/raft/autopilot/configuration", s.handleAutopilotConfiguration)
+	mux.HandleFunc("/v1/sys/storage/raft/remove-peer", s.handleRaftRemovePeer)
+	mux.HandleFunc("/v1/sys/storage/raft/snapshot", s.handleRaftSnapshot)
+	mux.HandleFunc("/v1/sys/storage/raft/snapshot-force", s.handleRaftSnapshotForce)
+	mux.HandleFunc("/v1/auth/token/create", s.handleTokenCreate)
+	mux.HandleFunc("/v1/auth/token/revoke-self", s.handleRevokeSelf)
+	mux.HandleFunc("/v1/sys/mounts/", s.handleMount)
+	mux.HandleFunc("/v1/sys/rekey/init", s.handleRekeyInit)
+	mux.HandleFunc("/v1/sys/rekey/update", s.handleRekeyUpdate)
+	mux.HandleFunc("/v1/sys/rekey/verify", s.handleRekeyVerify)
+	mux.HandleFunc("/v1/sys/rekey-recovery-key/init", s.handleRekeyInit)
+	mux.HandleFunc("/v1/sys/rekey-recovery-key/update", s.handleRekeyUpdate)
+	mux.HandleFunc("/v1/sys/generate-root/attempt", s.handleGenerateRootAttempt)
+	mux.HandleFunc("/v1/sys/generate-root/update", s.handleGenerateRootUpdate)
+	mux.HandleFunc("/agent/v1/metrics", s.handleAgentMetrics)
+	mux.HandleFunc("/v1/", s.handleSecret)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// initialize must be called with s.mu held or before the server is shared
+func (s *Server) initialize(shares, threshold int) {
+	s.initialized = true
+	s.sealed = true
+	s.shares = shares
+	s.threshold = threshold
+	s.keys = make([]string, shares)
+	for i := range s.keys {
+		s.keys[i] = randomHex(shareLength)
+	}
+	s.rootToken = "hvs." + randomHex(12)
+	s.clusterID = randomHex(16)
+	s.clusterName = "vault-cluster-" + s.clusterID[:8]
+	s.resetAttempt()
+}
+
+// resetAttempt must be called with s.mu held
+func (s *Server) resetAttempt() {
+	s.nonce = ""
+	s.parts = nil
+}
+
+// reportedVersion must be called with s.mu held
+func (s *Server) reportedVersion() string {
+	if s.version == "" {
+		return version
+	}
+
+	return s.version
+}
+
+// sealStatus must be called with s.mu held. Like Vault, the cluster identity is only reported
+// once the barrier is unsealed.
+func (s *Server) sealStatus() map[string]interface{} {
+	status := map[string]interface{}{
+		"type":          sealType,
+		"initialized":   s.initialized,
+		"sealed":        s.sealed,
+		"t":             s.threshold,
+		"n":             s.shares,
+		"progress":      len(s.parts),
+		"nonce":         s.nonce,
+		"version":       s.reportedVersion(),
+		"recovery_seal": s.recoverySeal,
+		"migration":     s.migration,
+	}
+	if s.recoverySeal {
+		status["type"] = autoSealType
+	}
+	if s.storageType != "" {
+		status["storage_type"] = s.storageType
+	}
+
+	if !s.sealed {
+		status["cluster_name"] = s.clusterName
+		status["cluster_id"] = s.clusterID
+	}
+
+	return status
+}
+
+func (s *Server) handleSealStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrors(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, s.sealStatus())
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrors(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	code := http.StatusOK
+	switch {
+	case !s.initialized:
+		code = http.StatusNotImplemented
+	case s.sealed:
+		code = http.StatusServiceUnavailable
+	case s.standby:
+		code = http.StatusTooManyRequests
+	}
+
+	writeJSON(w, code, map[string]interface{}{
+		"initialized":     s.initialized,
+		"sealed":          s.sealed,
+		"standby":         s.standby && !s.sealed,
+		"version":         s.reportedVersion(),
+		"server_time_utc": time.Now().Add(s.clockSkew).Unix(),
+	})
+}
+
+func (s *Server) handleAgentMetrics(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.agent {
+		writeErrors(w, http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"Gauges": []interface{}{}})
+}
+
+func (s *Server) handleLeader(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrors(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sealed {
+		writeErrors(w, http.StatusServiceUnavailable, "Vault is sealed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"ha_enabled": s.haEnabled,
+		"is_self":    s.haEnabled && !s.standby,
+	})
+}
+
+func (s *Server) handleStepDown(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeErrors(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.authorize(w, r) {
+		return
+	}
+	s.stepDowns++
+	if s.haEnabled {
+		s.standby = true
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleInit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodPost {
+		writeErrors(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req struct {
+		SecretShares      int      `json:"secret_shares"`
+		SecretThreshold   int      `json:"secret_threshold"`
+		RecoveryShares    int      `json:"recovery_shares"`
+		RecoveryThreshold int      `json:"recovery_threshold"`
+		PGPKeys           []string `json:"pgp_keys"`
+		RecoveryPGPKeys   []string `json:"recovery_pgp_keys"`
+		RootTokenPGPKey   string   `json:"root_token_pgp_key"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrors(w, http.StatusBadRequest, "failed to parse JSON input: "+err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.initialized {
+		writeErrors(w, http.StatusBadRequest, "Vault is already initialized")
+		return
+	}
+
+	// Like Vault, an auto-unseal Vault ignores the secret shares and keeps its barrier key in
+	// the seal, while a Shamir Vault has no recovery keys
+	shares, threshold, pgpKeys := req.SecretShares, req.SecretThreshold, req.PGPKeys
+	if s.recoverySeal {
+		shares, threshold, pgpKeys = req.RecoveryShares, req.RecoveryThreshold, req.RecoveryPGPKeys
+	} else if req.RecoveryShares != 0 || req.RecoveryThreshold != 0 {
+		writeErrors(w, http.StatusBadRequest, "parameters recovery_shares,recovery_threshold not applicable to seal type shamir")
+		return
+	}
+
+	if shares < 1 || threshold < 1 || threshold > shares {
+		writeErrors(w, http.StatusBadRequest, "invalid seal configuration")
+		return
+	}
+
+	if len(pgpKeys) > 0 && len(pgpKeys) != shares {
+		writeErrors(w, http.StatusBadRequest, "incorrect number of PGP keys")
+		return
+	}
+
+	s.initialize(shares, threshold)
+
+	keys := append([]string(nil), s.keys...)
+	keysBase64 := make([]string, len(s.keys))
+	for i, key := range s.keys {
+		if len(pgpKeys) > 0 {
+			keys[i] = PGPEncrypt(pgpKeys[i], key)
+			keysBase64[i] = keys[i]
+			continue
+		}
+		raw, _ := hex.DecodeString(key)
+		keysBase64[i] = base64.StdEncoding.EncodeToString(raw)
+	}
+	rootToken := s.rootToken
+	if req.RootTokenPGPKey != "" {
+		rootToken = PGPEncrypt(req.RootTokenPGPKey, rootToken)
+	}
+
+	if s.recoverySeal {
+		s.sealed = false
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"keys":                 []string{},
+			"keys_base64":          []string{},
+			"recovery_keys":        keys,
+			"recovery_keys_base64": keysBase64,
+			"root_token":           rootToken,
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys":        keys,
+		"keys_base64": keysBase64,
+		"root_token":  rootToken,
+	})
+}
+
+func (s *Server) handleRaftJoin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodPost {
+		writeErrors(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req struct {
+		LeaderAPIAddr string `json:"leader_api_addr"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrors(w, http.StatusBadRequest, "failed to parse JSON input: "+err.Error())
+		return
+	}
+
+	// The leader is read before s.mu is taken, so two fakes never hold each other's lock
+	var leader *Server
+	for _, peer := range s.peers {
+		if peer != s && peer.URL == req.LeaderAPIAddr {
+			leader = peer
+		}
+	}
+	if leader == nil {
+		writeErrors(w, http.StatusInternalServerError, "failed to join raft cluster: no leader at "+req.LeaderAPIAddr)
+		return
+	}
+	leader.mu.Lock()
+	ready := leader.initialized && !leader.sealed
+	shares, threshold, keys, rootToken := leader.shares, leader.threshold, leader.keys, leader.rootToken
+	clusterName, clusterID := leader.clusterName, leader.clusterID
+	leader.mu.Unlock()
+	if !ready {
+		writeErrors(w, http.StatusInternalServerError, "failed to join raft cluster: leader is not unsealed")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.storageType != "raft" {
+		writeErrors(w, http.StatusBadRequest, "raft storage is not in use")
+		return
+	}
+	if s.initialized {
+		writeErrors(w, http.StatusBadRequest, "node is already initialized")
+		return
+	}
+
+	// Like Vault, a Shamir-sealed member joins sealed and completes the join once unsealed with
+	// the cluster's keys, while an auto-unseal member unseals itself
+	s.initialized = true
+	s.sealed = !s.recoverySeal
+	s.shares, s.threshold, s.keys, s.rootToken = shares, threshold, keys, rootToken
+	s.clusterName, s.clusterID = clusterName, clusterID
+	s.joinedTo = req.LeaderAPIAddr
+	s.resetAttempt()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"joined": true})
+}
+
+func (s *Server) handleRaftConfiguration(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrors(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.authorize(w, r) {
+		return
+	}
+	if s.storageType != "raft" {
+		writeErrors(w, http.StatusBadRequest, "raft storage is not in use")
+		return
+	}
+
+	servers := make([]map[string]interface{}, 0, len(s.raftPeers))
+	for _, peer := range s.raftPeers {
+		servers = append(servers, map[string]interface{}{
+			"node_id":          peer.NodeID,
+			"address":          peer.Address,
+			"leader":           peer.Leader,
+			"voter":            peer.Voter,
+			"protocol_version": "3",
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": map[string]interface{}{
+			"config": map[string]interface{}{"servers": servers, "index": 0},
+		},
+	})
+}
+
+// Snapshot is what sys/storage/raft/snapshot answers
+var Snapshot = []byte("vaulttest raft snapshot")
+
+func (s *Server) handleRaftSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost || r.Method == http.MethodPut {
+		s.restoreSnapshot(w, r, false)
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeErrors(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.authorize(w, r) {
+		return
+	}
+	if s.storageType != "raft" {
+		writeErrors(w, http.StatusBadRequest, "raft storage is not in use")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Write(Snapshot)
+}
+
+func (s *Server) handleRaftSnapshotForce(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		writeErrors(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	s.restoreSnapshot(w, r, true)
+}
+
+// restoreSnapshot restores the snapshot in the body. Only Snapshot counts as taken from this
+// cluster, anything else needs force like a snapshot of another cluster does.
+func (s *Server) restoreSnapshot(w http.ResponseWriter, r *http.Request, force bool) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeErrors(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.authorize(w, r) {
+		return
+	}
+	if s.storageType != "raft" {
+		writeErrors(w, http.StatusBadRequest, "raft storage is not in use")
+		return
+	}
+	if !force && !bytes.Equal(body, Snapshot) {
+		writeErrors(w, http.StatusBadRequest, "failed to restore snapshot: could not verify hash file; use the snapshot-force API to bypass")
+		return
+	}
+
+	s.restored = body
+	s.restoreForced = force
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleRaftRemovePeer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		writeErrors(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req struct {
+		ServerID string `json:"server_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrors(w, http.StatusBadRequest, "failed to parse JSON input: "+err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.authorize(w, r) {
+		return
+	}
+	if s.storageType != "raft" {
+		writeErrors(w, http.StatusBadRequest, "raft storage is not in use")
+		return
+	}
+	if req.ServerID == "" {
+		writeErrors(w, http.StatusBadRequest, "no server id provided")
+		return
+	}
+
+	// Like Vault, removing a server that is not a member succeeds
+	peers := s.raftPeers[:0]
+	for _, peer := range s.raftPeers {
+		if peer.NodeID != req.ServerID {
+			peers = append(peers, peer)
+		}
+	}
+	s.raftPeers = peers
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAutopilotConfiguration(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		writeErrors(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrors(w, http.StatusBadRequest, "failed to parse JSON input: "+err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.authorize(w, r) {
+		return
+	}
+	if s.storageType != "raft" {
+		writeErrors(w, http.StatusBadRequest, "raft storage is not in use")
+		return
+	}
+	// Like Vault, dead servers are only cleaned up above a quorum
+	if cleanup, _ := req["cleanup_dead_servers"].(bool); cleanup {
+		if quorum, _ := req["min_quorum"].(float64); quorum < 3 {
+			writeErrors(w, http.StatusBadRequest, "min_quorum must be set when cleanup_dead_servers is set and it should at least be 3")
+			return
+		}
+	}
+
+	s.autopilot = req
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleUnseal(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodPost {
+		writeErrors(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req struct {
+		Key     string `json:"key"`
+		Reset   bool   `json:"reset"`
+		Migrate bool   `json:"migrate"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrors(w, http.StatusBadRequest, "failed to parse JSON input: "+err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.initialized {
+		writeErrors(w, http.StatusBadRequest, "Vault is not initialized")
+		return
+	}
+
+	if req.Reset {
+		s.resetAttempt()
+		writeJSON(w, http.StatusOK, s.sealStatus())
+		return
+	}
+
+	s.submitted++
+
+	if !s.sealed {
+		writeJSON(w, http.StatusOK, s.sealStatus())
+		return
+	}
+	if s.migration != req.Migrate {
+		if s.migration {
+			writeErrors(w, http.StatusBadRequest, "migrate option not provided and seal migration is pending")
+		} else {
+			writeErrors(w, http.StatusBadRequest, "migrate option provided but seal migration not in progress")
+		}
+		return
+	}
+
+	key, ok := decodeShare(req.Key)
+	if !ok {
+		writeErrors(w, http.StatusBadRequest, "'key' must be a valid hex or base64 string")
+		return
+	}
+
+	// Submitting the same share twice within an attempt does not count towards the threshold
+	for _, part := range s.parts {
+		if part == key {
+			writeJSON(w, http.StatusOK, s.sealStatus())
+			return
+		}
+	}
+
+	if s.nonce == "" {
+		s.nonce = randomHex(16)
+	}
+	s.parts = append(s.parts, key)
+
+	if len(s.parts) < s.threshold {
+		writeJSON(w, http.StatusOK, s.sealStatus())
+		return
+	}
+
+	// With enough shares the attempt is over either way: the shares combine into the master
+	// key and Vault opens, or they do not and the attempt is thrown away
+	valid := true
+	for _, part := range s.parts {
+		if !s.isShare(part) {
+			valid = false
+			break
+		}
+	}
+	s.resetAttempt()
+
+	if !valid {
+		writeErrors(w, http.StatusBadRequest, "failed to decrypt keyring: cipher: message authentication failed")
+		return
+	}
+
+	s.sealed = false
+	if s.migration {
+		// The keys are kept, as recovery keys of the new auto-unseal seal or unseal keys of the
+		// new Shamir seal
+		s.migration = false
+		s.recoverySeal = !s.recoverySeal
+	}
+	writeJSON(w, http.StatusOK, s.sealStatus())
+}
+
+func (s *Server) handleRekeyInit(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.rekeySupported(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.rekeyStatus())
+	case http.MethodDelete:
+		s.rekey = nil
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPut, http.MethodPost:
+		var req struct {
+			SecretShares        int  `json:"secret_shares"`
+			SecretThreshold     int  `json:"secret_threshold"`
+			RequireVerification bool `json:"require_verification"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeErrors(w, http.StatusBadRequest, "failed to parse JSON input: "+err.Error())
+			return
+		}
+		if s.rekey != nil {
+			writeErrors(w, http.StatusBadRequest, "rekey already in progress")
+			return
+		}
+		if req.SecretShares < 1 || req.SecretThreshold < 1 || req.SecretThreshold > req.SecretShares {
+			writeErrors(w, http.StatusBadRequest, "invalid recovery configuration")
+			return
+		}
+
+		s.rekey = &rekey{nonce: randomHex(16), shares: req.SecretShares, threshold: req.SecretThreshold, verification: req.RequireVerification}
+		writeJSON(w, http.StatusOK, s.rekeyStatus())
+	default:
+		writeErrors(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *Server) handleRekeyUpdate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodPost {
+		writeErrors(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req struct {
+		Key   string `json:"key"`
+		Nonce string `json:"nonce"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrors(w, http.StatusBadRequest, "failed to parse JSON input: "+err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.rekeySupported(w, r) {
+		return
+	}
+	if s.rekey == nil {
+		writeErrors(w, http.StatusBadRequest, "no rekey in progress")
+		return
+	}
+	if s.rekey.newKeys != nil {
+		writeErrors(w, http.StatusBadRequest, "rekey is awaiting verification")
+		return
+	}
+	if req.Nonce != s.rekey.nonce {
+		writeErrors(w, http.StatusBadRequest, "incorrect nonce")
+		return
+	}
+	key, ok := decodeShare(req.Key)
+	if !ok {
+		writeErrors(w, http.StatusBadRequest, "'key' must be a valid hex or base64 string")
+		return
+	}
+
+	for _, part := range s.rekey.parts {
+		if part == key {
+			writeErrors(w, http.StatusBadRequest, "given key has already been provided during this generation operation")
+			return
+		}
+	}
+	s.rekey.parts = append(s.rekey.parts, key)
+
+	if len(s.rekey.parts) < s.threshold {
+		writeJSON(w, http.StatusOK, s.rekeyStatus())
+		return
+	}
+
+	// Like unsealing, the attempt is over once enough keys were given, whether they are right
+	for _, part := range s.rekey.parts {
+		if !s.isShare(part) {
+			s.rekey.parts = nil
+			writeErrors(w, http.StatusBadRequest, "recovery key verification failed: recovered key does not match")
+			return
+		}
+	}
+
+	nonce := s.rekey.nonce
+	newKeys := make([]string, s.rekey.shares)
+	keysBase64 := make([]string, len(newKeys))
+	for i := range newKeys {
+		newKeys[i] = randomHex(shareLength)
+		raw, _ := hex.DecodeString(newKeys[i])
+		keysBase64[i] = base64.StdEncoding.EncodeToString(raw)
+	}
+
+	// The current keys stay valid until the new ones are verified
+	if s.rekey.verification {
+		s.rekey.newKeys = newKeys
+		s.rekey.verifyNonce = randomHex(16)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"nonce":                 nonce,
+			"complete":              true,
+			"keys":                  newKeys,
+			"keys_base64":           keysBase64,
+			"verification_required": true,
+			"verification_nonce":    s.rekey.verifyNonce,
+		})
+		return
+	}
+
+	s.shares = s.rekey.shares
+	s.threshold = s.rekey.threshold
+	s.keys = newKeys
+	s.rekey = nil
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"nonce":       nonce,
+		"complete":    true,
+		"keys":        newKeys,
+		"keys_base64": keysBase64,
+	})
+}
+
+func (s *Server) handleRekeyVerify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodPost {
+		writeErrors(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req struct {
+		Key   string `json:"key"`
+		Nonce string `json:"nonce"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrors(w, http.StatusBadRequest, "failed to parse JSON input: "+err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.rekey == nil || s.rekey.newKeys == nil {
+		writeErrors(w, http.StatusBadRequest, "no rekey verification in progress")
+		return
+	}
+	if req.Nonce != s.rekey.verifyNonce {
+		writeErrors(w, http.StatusBadRequest, "incorrect nonce")
+		return
+	}
+	key, ok := decodeShare(req.Key)
+	if !ok {
+		writeErrors(w, http.StatusBadRequest, "'key' must be a valid hex or base64 string")
+		return
+	}
+
+	for _, part := range s.rekey.verified {
+		if part == key {
+			writeErrors(w, http.StatusBadRequest, "given key has already been provided during this verification operation")
+			return
+		}
+	}
+	s.rekey.verified = append(s.rekey.verified, key)
+
+	if len(s.rekey.verified) < s.rekey.threshold {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"nonce":    s.rekey.verifyNonce,
+			"started":  true,
+			"t":        s.rekey.threshold,
+			"n":        s.rekey.shares,
+			"progress": len(s.rekey.verified),
+		})
+		return
+	}
+
+	for _, part := range s.rekey.verified {
+		if !slices.Contains(s.rekey.newKeys, part) {
+			s.rekey.verified = nil
+			writeErrors(w, http.StatusBadRequest, "rekey verification failed: recovered key does not match")
+			return
+		}
+	}
+
+	nonce := s.rekey.verifyNonce
+	s.shares = s.rekey.shares
+	s.threshold = s.rekey.threshold
+	s.keys = s.rekey.newKeys
+	s.rekey = nil
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"nonce":    nonce,
+		"complete": true,
+	})
+}
+
+// rekeySupported answers requests to the rekey API that does not match the seal of the fake,
+// the unseal key one for an auto-unseal fake and the recovery key one otherwise. It must be
+// called with s.mu held.
+func (s *Server) rekeySupported(w http.ResponseWriter, r *http.Request) bool {
+	recovery := strings.HasPrefix(r.URL.Path, "/v1/sys/rekey-recovery-key/")
+	switch {
+	case recovery && !s.recoverySeal:
+		writeErrors(w, http.StatusBadRequest, "recovery rekeying not supported")
+		return false
+	case !recovery && s.recoverySeal:
+		writeErrors(w, http.StatusBadRequest, "rekeying of barrier not supported when a recovery seal is in use")
+		return false
+	}
+
+	return true
+}
+
+func (s *Server) handleGenerateRootAttempt(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sealed {
+		writeErrors(w, http.StatusServiceUnavailable, "Vault is sealed")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.generateRootStatus())
+	case http.MethodDelete:
+		s.generateRoot = nil
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPut, http.MethodPost:
+		if s.generateRoot != nil {
+			writeErrors(w, http.StatusBadRequest, "root generation already in progress")
+			return
+		}
+
+		// Like Vault 1.10 and later, the OTP is as long as a root token
+		s.generateRoot = &generateRoot{nonce: randomHex(16), otp: randomHex(14)}
+		status := s.generateRootStatus()
+		status["otp"] = s.generateRoot.otp
+		writeJSON(w, http.StatusOK, status)
+	default:
+		writeErrors(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *Server) handleGenerateRootUpdate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodPost {
+		writeErrors(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req struct {
+		Key   string `json:"key"`
+		Nonce string `json:"nonce"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrors(w, http.StatusBadRequest, "failed to parse JSON input: "+err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.generateRoot == nil {
+		writeErrors(w, http.StatusBadRequest, "no root generation in progress")
+		return
+	}
+	if req.Nonce != s.generateRoot.nonce {
+		writeErrors(w, http.StatusBadRequest, "incorrect nonce")
+		return
+	}
+	key, ok := decodeShare(req.Key)
+	if !ok {
+		writeErrors(w, http.StatusBadRequest, "'key' must be a valid hex or base64 string")
+		return
+	}
+
+	for _, part := range s.generateRoot.parts {
+		if part == key {
+			writeErrors(w, http.StatusBadRequest, "given key has already been provided during this generation operation")
+			return
+		}
+	}
+	s.generateRoot.parts = append(s.generateRoot.parts, key)
+
+	if len(s.generateRoot.parts) < s.threshold {
+		writeJSON(w, http.StatusOK, s.generateRootStatus())
+		return
+	}
+
+	// Like unsealing, the attempt is over once enough keys were given, whether they are right
+	for _, part := range s.generateRoot.parts {
+		if !s.isShare(part) {
+			s.generateRoot.parts = nil
+			writeErrors(w, http.StatusBadRequest, "root generation aborted: failed to verify key shares")
+			return
+		}
+	}
+
+	nonce, otp := s.generateRoot.nonce, s.generateRoot.otp
+	s.generateRoot = nil
+	s.rootToken = "hvs." + randomHex(12)
+	s.rootRevoked = false
+	encoded := make([]byte, len(s.rootToken))
+	for i := range encoded {
+		encoded[i] = s.rootToken[i] ^ otp[i]
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"nonce":         nonce,
+		"started":       true,
+		"progress":      s.threshold,
+		"required":      s.threshold,
+		"complete":      true,
+		"encoded_token": base64.RawStdEncoding.EncodeToString(encoded),
+	})
+}
+
+// generateRootStatus must be called with s.mu held
+func (s *Server) generateRootStatus() map[string]interface{} {
+	if s.generateRoot == nil {
+		return map[string]interface{}{"started": false, "required": s.threshold, "otp_length": 28}
+	}
+
+	return map[string]interface{}{
+		"nonce":      s.generateRoot.nonce,
+		"started":    true,
+		"progress":   len(s.generateRoot.parts),
+		"required":   s.threshold,
+		"otp_length": 28,
+	}
+}
+
+// authorize answers requests needing the root token, and reports whether the request may go
+// on. It must be called with s.mu held.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) bool {
+	if s.sealed {
+		writeErrors(w, http.StatusServiceUnavailable, "Vault is sealed")
+		return false
+	}
+	if token := r.Header.Get("X-Vault-Token"); token == "" || token != s.rootToken || s.rootRevoked {
+		writeErrors(w, http.StatusForbidden, "permission denied")
+		return false
+	}
+
+	return true
+}
+
+func (s *Server) handleTokenCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodPost {
+		writeErrors(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req struct {
+		Policies []string          `json:"policies"`
+		TTL      string            `json:"ttl"`
+		NumUses  int               `json:"num_uses"`
+		Meta     map[string]string `json:"meta"`
+		NoParent bool              `json:"no_parent"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrors(w, http.StatusBadRequest, "failed to parse JSON input: "+err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.authorize(w, r) {
+		return
+	}
+
+	leaseDuration := 0
+	if req.TTL != "" {
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil {
+			writeErrors(w, http.StatusBadRequest, "error parsing ttl: "+err.Error())
+			return
+		}
+		leaseDuration = int(ttl / time.Second)
+	}
+
+	// A wrapped response hides the token behind a single-use wrapping token
+	wrapTTL := 0
+	if header := r.Header.Get("X-Vault-Wrap-TTL"); header != "" {
+		ttl, err := time.ParseDuration(header)
+		if err != nil {
+			writeErrors(w, http.StatusBadRequest, "error parsing wrap ttl: "+err.Error())
+			return
+		}
+		wrapTTL = int(ttl / time.Second)
+	}
+
+	accessor := randomHex(12)
+	if s.tokens == nil {
+		s.tokens = make(map[string]Token)
+	}
+	s.tokens[accessor] = Token{Policies: req.Policies, TTL: req.TTL, NumUses: req.NumUses, Meta: req.Meta, Wrapped: wrapTTL > 0, NoParent: req.NoParent}
+
+	if wrapTTL > 0 {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"wrap_info": map[string]interface{}{
+				"token":         "hvs." + randomHex(12),
+				"accessor":      randomHex(12),
+				"ttl":           wrapTTL,
+				"creation_path": "auth/token/create",
+			},
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"auth": map[string]interface{}{
+			"client_token":   "hvs." + randomHex(12),
+			"accessor":       accessor,
+			"policies":       append([]string{"default"}, req.Policies...),
+			"lease_duration": leaseDuration,
+			"renewable":      true,
+		},
+	})
+}
+
+func (s *Server) handleSecret(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrors(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sealed {
+		writeErrors(w, http.StatusServiceUnavailable, "Vault is sealed")
+		return
+	}
+	stored, ok := s.secrets[strings.TrimPrefix(r.URL.Path, "/v1/")]
+	if !ok {
+		writeErrors(w, http.StatusNotFound)
+		return
+	}
+	if r.Header.Get("X-Vault-Token") != stored.token {
+		writeErrors(w, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": stored.data})
+}
+
+func (s *Server) handleRevokeSelf(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodPost {
+		writeErrors(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.authorize(w, r) {
+		return
+	}
+	s.rootRevoked = true
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleMount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodPost {
+		writeErrors(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req struct {
+		Type string `json:"type"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrors(w, http.StatusBadRequest, "failed to parse JSON input: "+err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.authorize(w, r) {
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/sys/mounts/") + "/"
+	if _, ok := s.mounts[path]; ok {
+		writeErrors(w, http.StatusBadRequest, "path is already in use at "+path)
+		return
+	}
+	if s.mounts == nil {
+		s.mounts = make(map[string]string)
+	}
+	s.mounts[path] = req.Type
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rekeyStatus must be called with s.mu held
+func (s *Server) rekeyStatus() map[string]interface{} {
+	if s.rekey == nil {
+		return map[string]interface{}{"started": false, "required": s.threshold}
+	}
+
+	return map[string]interface{}{
+		"nonce":    s.rekey.nonce,
+		"started":  true,
+		"t":        s.rekey.threshold,
+		"n":        s.rekey.shares,
+		"progress": len(s.rekey.parts),
+		"required": s.threshold,
+	}
+}
+
+// isShare must be called with s.mu held
+func (s *Server) isShare(key string) bool {
+	for _, share := range s.keys {
+		if share == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// decodeShare returns a key share given in hex or base64, like Vault accepts them, in hex
+func decodeShare(key string) (string, bool) {
+	raw, err := hex.DecodeString(key)
+	if err != nil {
+		if raw, err = base64.StdEncoding.DecodeString(key); err != nil {
+			return "", false
+		}
+	}
+	if len(raw) != shareLength {
+		return "", false
+	}
+
+	return hex.EncodeToString(raw), true
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(buf)
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeErrors(w http.ResponseWriter, code int, errs ...string) {
+	writeJSON(w, code, map[string][]string{"errors": errs})
+}
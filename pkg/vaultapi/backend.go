@@ -0,0 +1,147 @@
+// Package vaultapi sends the requests of a vault.Client through the official Vault API client,
+// github.com/hashicorp/vault/api, for VAULT_CLIENT_BACKEND=official
+package vaultapi
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/getgrowly/vault-utils/pkg/vault"
+	"github.com/hashicorp/vault/api"
+)
+
+// Backend is a vault.Backend sending requests through an official Vault API client. The
+// operations, their retries and the decoding of the responses stay those of vault.Client.
+type Backend struct {
+	client *api.Client
+	// httpClient is the HTTP client of client, which DoStream sends through as the API client
+	// does for raft snapshot restores
+	httpClient *http.Client
+}
+
+// NewBackend creates a backend reaching Vault at baseURL, verifying an https:// address with
+// tlsConfig and tuning its connections with opts as the clients of package vault do. It is a
+// vault.NewBackendFunc.
+//
+// The API client does not retry, since vault.Client retries what is safe to. Neither does it
+// send the token of VAULT_TOKEN, every request carries its own, nor a Vault Enterprise namespace
+// from VAULT_NAMESPACE, which names the Kubernetes namespace of Vault here, nor wrap responses
+// for VAULT_WRAP_TTL, which vault.Client could not decode.
+func NewBackend(baseURL string, tlsConfig *tls.Config, opts vault.TransportOptions) (vault.Backend, error) {
+	httpClient := vault.NewHTTPClient(tlsConfig, opts)
+	client, err := api.NewClient(&api.Config{
+		Address:    baseURL,
+		HttpClient: httpClient,
+		MaxRetries: 0,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the Vault API client: %w", err)
+	}
+	client.ClearToken()
+	client.ClearNamespace()
+	client.SetWrappingLookupFunc(func(string, string) string { return "" })
+
+	return &Backend{client: client, httpClient: httpClient}, nil
+}
+
+// Do implements vault.Backend. Answers with an error status are returned as responses, for
+// vault.Client to decode like its own.
+func (b *Backend) Do(ctx context.Context, method, path, token string, body []byte) (*http.Response, error) {
+	req := b.client.NewRequest(method, path)
+	req.ClientToken = token
+	req.BodyBytes = body
+
+	resp, err := b.client.RawRequestWithContext(ctx, req)
+	var respErr *api.ResponseError
+	switch {
+	case resp != nil && (err == nil || errors.As(err, &respErr)):
+		return resp.Response, nil
+	case resp != nil:
+		resp.Body.Close()
+
+		return nil, err
+	}
+
+	return nil, fmt.Errorf("%w: %v", vault.ErrUnreachable, err)
+}
+
+// DoStream implements vault.StreamBackend. The API client reads request bodies into memory to
+// retry them, so the body is sent through its HTTP client directly, with its headers, as it
+// does itself for raft snapshot restores, following one redirect as it does.
+func (b *Backend) DoStream(ctx context.Context, method, path, token string, open func() (io.Reader, error)) (*http.Response, error) {
+	target := b.client.NewRequest(method, path).URL
+	for redirects := 0; ; redirects++ {
+		r, err := open()
+		if err != nil {
+			return nil, err
+		}
+		body := &trackedBody{r: r}
+		req, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+		if err != nil {
+			body.Close()
+
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header = b.client.Headers()
+		req.Header.Set("Content-Type", "application/octet-stream")
+		if token != "" {
+			req.Header.Set(api.AuthHeaderName, token)
+		}
+
+		resp, err := b.httpClient.Do(req)
+		if err != nil && body.err != nil {
+			// Failing to read the body is not Vault being unreachable
+			return nil, body.err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", vault.ErrUnreachable, err)
+		}
+		if resp.StatusCode != http.StatusTemporaryRedirect && resp.StatusCode != http.StatusPermanentRedirect || redirects > 0 {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		location, err := resp.Location()
+		if err != nil {
+			return nil, fmt.Errorf("redirect from %s without a valid location: %w", target.Host, err)
+		}
+		if target.Scheme == "https" && location.Scheme != "https" {
+			return nil, fmt.Errorf("redirect from %s to %s would downgrade to %s", target.Host, location.Host, location.Scheme)
+		}
+		target = location
+	}
+}
+
+// trackedBody is a request body remembering the error reading it failed with, and closing what
+// it reads from once sent
+type trackedBody struct {
+	r   io.Reader
+	err error
+}
+
+func (t *trackedBody) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if err != nil && err != io.EOF {
+		t.err = err
+	}
+
+	return n, err
+}
+
+func (t *trackedBody) Close() error {
+	if closer, ok := t.r.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// CloseIdleConnections closes the keep-alive connections of the backend, which vault.Client
+// does when it is closed
+func (b *Backend) CloseIdleConnections() {
+	b.httpClient.CloseIdleConnections()
+}
@@ -0,0 +1,151 @@
+package vaultapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/getgrowly/vault-utils/pkg/vault"
+	"github.com/getgrowly/vault-utils/pkg/vault/vaulttest"
+	"github.com/stretchr/testify/assert"
+)
+
+// newClient returns a vault.Client of baseURL sending its requests through a Backend
+func newClient(t *testing.T, baseURL string) *vault.Client {
+	t.Helper()
+
+	backend, err := NewBackend(baseURL, nil, vault.TransportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return vault.NewClientWithBackend(baseURL, backend)
+}
+
+func TestBackend(t *testing.T) {
+	fakeVault := vaulttest.NewServer()
+	defer fakeVault.Close()
+
+	client := newClient(t, fakeVault.URL)
+	defer client.Close()
+	ctx := context.Background()
+
+	resp, err := client.Initialize(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, client.UnsealWithKeysFromDir(ctx, resp.Keys))
+
+	status, err := client.CheckStatus(ctx)
+	assert.NoError(t, err)
+	assert.True(t, status.Initialized)
+	assert.False(t, status.Sealed)
+
+	// Answers with an error status are decoded by the client like its own
+	_, err = client.Initialize(ctx)
+	assert.ErrorIs(t, err, vault.ErrAlreadyInitialized)
+}
+
+func TestBackendUnreachable(t *testing.T) {
+	fakeVault := vaulttest.NewServer()
+	fakeVault.Close()
+
+	client := newClient(t, fakeVault.URL)
+	_, err := client.CheckStatus(context.Background())
+	assert.ErrorIs(t, err, vault.ErrUnreachable)
+}
+
+func TestBackendVerifiesTLS(t *testing.T) {
+	fakeVault := vaulttest.NewTLSServer()
+	defer fakeVault.Close()
+
+	// The system roots do not trust the fake
+	_, err := newClient(t, fakeVault.URL).CheckStatus(context.Background())
+	assert.ErrorIs(t, err, vault.ErrUnreachable)
+
+	roots := vault.NewCertPool([]*x509.Certificate{fakeVault.Certificate()})
+	backend, err := NewBackend(fakeVault.URL, vault.NewTLSConfig(roots, "example.com", nil), vault.TransportOptions{})
+	assert.NoError(t, err)
+	status, err := vault.NewClientWithBackend(fakeVault.URL, backend).CheckStatus(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, status.Initialized)
+}
+
+func TestBackendIgnoresVaultEnvironment(t *testing.T) {
+	// VAULT_NAMESPACE names the Kubernetes namespace of Vault, not a Vault Enterprise namespace
+	t.Setenv("VAULT_NAMESPACE", "vault")
+	t.Setenv("VAULT_TOKEN", "hvs.from-environment")
+	t.Setenv("VAULT_WRAP_TTL", "5m")
+
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		w.Write([]byte(`{"initialized": true, "sealed": false}`))
+	}))
+	defer server.Close()
+
+	_, err := newClient(t, server.URL).CheckStatus(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, header.Get("X-Vault-Namespace"))
+	assert.Empty(t, header.Get("X-Vault-Token"))
+	assert.Empty(t, header.Get("X-Vault-Wrap-TTL"))
+}
+
+func TestBackendStreamsRaftSnapshot(t *testing.T) {
+	var received []byte
+	var contentType string
+	active := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+		contentType = r.Header.Get("Content-Type")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer active.Close()
+	standby := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		http.Redirect(w, r, active.URL+r.URL.Path, http.StatusTemporaryRedirect)
+	}))
+	defer standby.Close()
+
+	// The snapshot is opened again for the active node rather than held in memory
+	opened := 0
+	open := func() (io.Reader, error) {
+		opened++
+
+		return strings.NewReader("raft snapshot"), nil
+	}
+	assert.NoError(t, newClient(t, standby.URL).RestoreRaftSnapshot(context.Background(), "root", open, false))
+	assert.Equal(t, "raft snapshot", string(received))
+	assert.Equal(t, "application/octet-stream", contentType)
+	assert.Equal(t, 2, opened)
+
+	// A snapshot failing to download is not Vault being unreachable
+	failing := func() (io.Reader, error) {
+		return io.MultiReader(strings.NewReader("raft"), iotest.ErrReader(errors.New("download interrupted"))), nil
+	}
+	err := newClient(t, active.URL).RestoreRaftSnapshot(context.Background(), "root", failing, false)
+	assert.ErrorContains(t, err, "download interrupted")
+	assert.False(t, errors.Is(err, vault.ErrUnreachable))
+}
+
+func TestBackendRestoresRaftSnapshotWithFakeVault(t *testing.T) {
+	cluster := vaulttest.NewRaftCluster(1)
+	defer cluster[0].Close()
+	client := newClient(t, cluster[0].URL)
+	ctx := context.Background()
+
+	resp, err := client.Initialize(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, client.UnsealWithKeysFromDir(ctx, resp.Keys))
+
+	snapshot := func() (io.Reader, error) { return bytes.NewReader(vaulttest.Snapshot), nil }
+	assert.Error(t, client.RestoreRaftSnapshot(ctx, "wrong-token", snapshot, false))
+	assert.NoError(t, client.RestoreRaftSnapshot(ctx, resp.RootToken, snapshot, false))
+	restored, forced := cluster[0].Restored()
+	assert.Equal(t, vaulttest.Snapshot, restored)
+	assert.False(t, forced)
+}
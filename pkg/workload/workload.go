@@ -0,0 +1,125 @@
+// Package workload hands a workload identity token to the key providers and notification
+// commands, so they authenticate to cloud KMS, HSM gateways or Vault through identity federation
+// instead of long-lived credentials stored in the deployment. The token is a JWT kept fresh on
+// disk by something else: a projected Kubernetes service account token, which the kubelet
+// rotates, or a SPIFFE JWT-SVID written by spiffe-helper. The controller only points the
+// standard environment variables at it and watches that it stays valid.
+package workload
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// EnvVars are the variables pointed at the token file: the controller's own, and those the AWS
+// and Azure SDKs read a federated token from
+var EnvVars = []string{"VAULT_UTILS_IDENTITY_TOKEN_FILE", "AWS_WEB_IDENTITY_TOKEN_FILE", "AZURE_FEDERATED_TOKEN_FILE"}
+
+// Claims are the claims of a token that matter to operators
+type Claims struct {
+	Subject  string   `json:"sub"`
+	Audience audience `json:"aud"`
+	Expiry   int64    `json:"exp"`
+}
+
+// audience is the aud claim, which is either a string or a list of strings
+type audience []string
+
+// UnmarshalJSON implements json.Unmarshaler
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("aud is neither a string nor a list of strings")
+	}
+	*a = list
+
+	return nil
+}
+
+// ExpiresAt returns when the token expires, or the zero time when it does not say
+func (c *Claims) ExpiresAt() time.Time {
+	if c.Expiry == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(c.Expiry, 0).UTC()
+}
+
+// String describes the token without revealing it
+func (c *Claims) String() string {
+	description := fmt.Sprintf("subject %s, audience %s", c.Subject, strings.Join(c.Audience, ","))
+	if expiry := c.ExpiresAt(); !expiry.IsZero() {
+		description += ", expires " + expiry.Format(time.RFC3339)
+	}
+
+	return description
+}
+
+// ReadToken reads the JWT at path and decodes its claims. The signature is not verified; the
+// backends the token is presented to do that.
+func ReadToken(path string) (*Claims, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity token: %w", err)
+	}
+
+	parts := strings.Split(strings.TrimSpace(string(data)), ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("identity token %s is not a JWT", path)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("identity token %s has an invalid payload: %w", path, err)
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("identity token %s has invalid claims: %w", path, err)
+	}
+
+	return &claims, nil
+}
+
+// Check returns a warning when the token at path cannot be read or has expired, which means
+// whatever keeps it fresh has stopped, or an empty string when the token is usable
+func Check(path string, now time.Time) string {
+	claims, err := ReadToken(path)
+	if err != nil {
+		return err.Error()
+	}
+
+	if expiry := claims.ExpiresAt(); !expiry.IsZero() && !now.Before(expiry) {
+		return fmt.Sprintf("identity token %s expired at %s, check what refreshes it", path, expiry.Format(time.RFC3339))
+	}
+
+	return ""
+}
+
+// Export points every variable of EnvVars that is not set yet at path, so the commands and
+// plugins the controller runs inherit the token. It returns the variables it set.
+func Export(path string) ([]string, error) {
+	var exported []string
+	for _, name := range EnvVars {
+		if _, ok := os.LookupEnv(name); ok {
+			continue
+		}
+		if err := os.Setenv(name, path); err != nil {
+			return exported, fmt.Errorf("failed to set %s: %w", name, err)
+		}
+		exported = append(exported, name)
+	}
+
+	return exported, nil
+}
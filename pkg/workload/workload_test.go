@@ -0,0 +1,105 @@
+package workload
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeToken writes a JWT with the given claims; the signature is never checked
+func writeToken(t *testing.T, dir, claims string) string {
+	t.Helper()
+
+	encode := base64.RawURLEncoding.EncodeToString
+	path := filepath.Join(dir, "token")
+	token := encode([]byte(`{"alg":"RS256"}`)) + "." + encode([]byte(claims)) + ".c2ln\n"
+	if err := os.WriteFile(path, []byte(token), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestReadToken(t *testing.T) {
+	tests := []struct {
+		name         string
+		claims       string
+		wantAudience string
+		wantErr      bool
+	}{
+		{name: "single audience", claims: `{"sub":"system:serviceaccount:vault:vault-utils","aud":"sts.amazonaws.com","exp":1700000000}`, wantAudience: "sts.amazonaws.com"},
+		{name: "audience list", claims: `{"sub":"spiffe://example.org/vault-utils","aud":["kms","vault"],"exp":1700000000}`, wantAudience: "kms,vault"},
+		{name: "invalid audience", claims: `{"sub":"x","aud":7}`, wantErr: true},
+		{name: "not JSON", claims: `not json`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := ReadToken(writeToken(t, t.TempDir(), tt.claims))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := strings.Join(claims.Audience, ","); got != tt.wantAudience {
+				t.Errorf("expected audience %s, got %s", tt.wantAudience, got)
+			}
+			if !claims.ExpiresAt().Equal(time.Unix(1700000000, 0)) {
+				t.Errorf("unexpected expiry %v", claims.ExpiresAt())
+			}
+		})
+	}
+
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("opaque-token"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadToken(path); err == nil {
+		t.Errorf("expected an error for a token that is not a JWT")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	path := writeToken(t, t.TempDir(), `{"sub":"vault-utils","aud":"kms","exp":1700000000}`)
+
+	if warning := Check(path, time.Unix(1699999000, 0)); warning != "" {
+		t.Errorf("expected a valid token, got %q", warning)
+	}
+	if warning := Check(path, time.Unix(1700000000, 0)); !strings.Contains(warning, "expired") {
+		t.Errorf("expected an expiry warning, got %q", warning)
+	}
+	if warning := Check(filepath.Join(t.TempDir(), "missing"), time.Now()); warning == "" {
+		t.Errorf("expected a warning for a missing token")
+	}
+}
+
+func TestExport(t *testing.T) {
+	for _, name := range EnvVars {
+		t.Setenv(name, "")
+		if err := os.Unsetenv(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", "/var/run/secrets/eks.amazonaws.com/serviceaccount/token")
+
+	exported, err := Export("/var/run/secrets/tokens/vault-utils")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(exported, ",") != "VAULT_UTILS_IDENTITY_TOKEN_FILE,AZURE_FEDERATED_TOKEN_FILE" {
+		t.Errorf("expected only unset variables to be exported, got %v", exported)
+	}
+	if got := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"); got != "/var/run/secrets/eks.amazonaws.com/serviceaccount/token" {
+		t.Errorf("expected an existing variable to be kept, got %s", got)
+	}
+	if got := os.Getenv("AZURE_FEDERATED_TOKEN_FILE"); got != "/var/run/secrets/tokens/vault-utils" {
+		t.Errorf("expected the token path to be exported, got %s", got)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// shutdownSignals stop the controller or a running CLI command
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
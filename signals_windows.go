@@ -0,0 +1,9 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// shutdownSignals stop the controller or a running CLI command. Windows only delivers
+// os.Interrupt (Ctrl+C); there is no SIGTERM to listen for.
+var shutdownSignals = []os.Signal{os.Interrupt}
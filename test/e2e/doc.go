@@ -0,0 +1,4 @@
+// Package e2e contains end-to-end tests that run the controller against a real Vault
+// StatefulSet in a kind cluster. The tests are guarded by the e2e build tag and are
+// normally run through `make e2e`, which creates the cluster and deploys both components.
+package e2e
@@ -0,0 +1,321 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getgrowly/vault-utils/pkg/backup"
+	"github.com/getgrowly/vault-utils/pkg/vault"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	namespace    = "vault"
+	vaultPod     = "vault-0"
+	pollInterval = 2 * time.Second
+	waitTimeout  = 3 * time.Minute
+	// backupTimeout leaves the kubelet time to mount the backup token, and the controller time
+	// to take its next snapshot with it
+	backupTimeout = 5 * time.Minute
+	// controllerService and adminToken reach the controller's HTTP server, as set up by
+	// manifests/controller.yaml
+	controllerService = "vault-auto-unseal:http"
+	adminToken        = "e2e-admin-token"
+	// backupTokenSecret holds the token the controller takes snapshots with
+	backupTokenSecret = "vault-backup-token"
+)
+
+// newClientset builds a clientset for the kube context selected by E2E_KUBE_CONTEXT
+func newClientset(t *testing.T) kubernetes.Interface {
+	t.Helper()
+
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: os.Getenv("E2E_KUBE_CONTEXT")}
+
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).ClientConfig()
+	if err != nil {
+		t.Fatalf("failed to load kubeconfig: %v", err)
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		t.Fatalf("failed to create clientset: %v", err)
+	}
+
+	return clientset
+}
+
+// waitFor polls condition until it returns true or the timeout expires
+func waitFor(t *testing.T, description string, condition func() (bool, error)) {
+	t.Helper()
+
+	waitForWithin(t, waitTimeout, description, condition)
+}
+
+// waitForWithin polls condition until it returns true or timeout expires
+func waitForWithin(t *testing.T, timeout time.Duration, description string, condition func() (bool, error)) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	var lastErr error
+
+	for time.Now().Before(deadline) {
+		done, err := condition()
+		if done {
+			return
+		}
+		lastErr = err
+		time.Sleep(pollInterval)
+	}
+
+	t.Fatalf("timed out waiting for %s (last error: %v)", description, lastErr)
+}
+
+// vaultCommand returns the vault CLI inside the Vault pod run with args, and token when it is
+// not empty
+func vaultCommand(token string, args ...string) *exec.Cmd {
+	args = append([]string{"exec", "-n", namespace, vaultPod, "--", "env", "VAULT_TOKEN=" + token, "vault"}, args...)
+	if kubeContext := os.Getenv("E2E_KUBE_CONTEXT"); kubeContext != "" {
+		args = append([]string{"--context", kubeContext}, args...)
+	}
+
+	return exec.Command("kubectl", args...)
+}
+
+// vaultCLI runs the vault CLI inside the Vault pod with token and returns its trimmed output
+func vaultCLI(token string, args ...string) (string, error) {
+	output, err := vaultCommand(token, args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("vault %s failed: %v: %s", strings.Join(args, " "), err, output)
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+// vaultStatus reads the seal status of the Vault pod through the vault CLI inside the pod
+func vaultStatus() (*vault.Status, error) {
+	// vault status exits with code 2 while sealed, so only the output is authoritative
+	output, err := vaultCommand("", "status", "-format=json").Output()
+	if len(output) == 0 {
+		return nil, fmt.Errorf("no output from vault status: %v", err)
+	}
+
+	var status vault.Status
+	if err := json.Unmarshal(output, &status); err != nil {
+		return nil, fmt.Errorf("failed to decode vault status: %v", err)
+	}
+
+	return &status, nil
+}
+
+func waitForUnsealed(t *testing.T) {
+	t.Helper()
+
+	waitFor(t, "vault to be initialized and unsealed", func() (bool, error) {
+		status, err := vaultStatus()
+		if err != nil {
+			return false, err
+		}
+
+		return status.Initialized && !status.Sealed, nil
+	})
+}
+
+func TestInitializeAndUnseal(t *testing.T) {
+	clientset := newClientset(t)
+
+	waitForUnsealed(t)
+
+	for _, name := range []string{vault.RootTokenSecret, vault.UnsealKeysSecret} {
+		secret, err := clientset.CoreV1().Secrets(namespace).Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("expected secret %s to exist: %v", name, err)
+		}
+		if len(secret.Data) == 0 {
+			t.Errorf("expected secret %s to contain data", name)
+		}
+	}
+}
+
+func TestUnsealAfterRestart(t *testing.T) {
+	clientset := newClientset(t)
+
+	waitForUnsealed(t)
+	restartVault(t, clientset)
+	waitForUnsealed(t)
+}
+
+// restartVault deletes the Vault pod and waits for the StatefulSet to replace it
+func restartVault(t *testing.T, clientset kubernetes.Interface) {
+	t.Helper()
+
+	pod, err := clientset.CoreV1().Pods(namespace).Get(context.Background(), vaultPod, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get pod %s: %v", vaultPod, err)
+	}
+
+	if err := clientset.CoreV1().Pods(namespace).Delete(context.Background(), vaultPod, metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("failed to delete pod %s: %v", vaultPod, err)
+	}
+
+	waitFor(t, "vault pod to be replaced", func() (bool, error) {
+		replacement, err := clientset.CoreV1().Pods(namespace).Get(context.Background(), vaultPod, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+
+		return replacement.UID != pod.UID && replacement.Status.PodIP != "", nil
+	})
+}
+
+// controllerRequest sends a request to the controller's HTTP server through the API server's
+// service proxy, with the admin token, and returns the status code and body of the answer
+func controllerRequest(clientset kubernetes.Interface, method, path string, body interface{}) (int, []byte, error) {
+	req := clientset.CoreV1().RESTClient().Verb(method).
+		Namespace(namespace).Resource("services").Name(controllerService).SubResource("proxy").
+		Suffix(path).SetHeader("Authorization", "Bearer "+adminToken)
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, nil, err
+		}
+		req = req.SetHeader("Content-Type", "application/json").Body(data)
+	}
+
+	var code int
+	result := req.Do(context.Background()).StatusCode(&code)
+	data, err := result.Raw()
+	if code != 0 {
+		// Answers with an error status are returned as they are
+		return code, data, nil
+	}
+
+	return code, data, err
+}
+
+// unsealKeys returns the key shares stored in the unseal keys Secret
+func unsealKeys(t *testing.T, clientset kubernetes.Interface) *corev1.Secret {
+	t.Helper()
+
+	secret, err := clientset.CoreV1().Secrets(namespace).Get(context.Background(), vault.UnsealKeysSecret, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get secret %s: %v", vault.UnsealKeysSecret, err)
+	}
+
+	return secret
+}
+
+// rootToken returns the root token stored at init
+func rootToken(t *testing.T, clientset kubernetes.Interface) string {
+	t.Helper()
+
+	secret, err := clientset.CoreV1().Secrets(namespace).Get(context.Background(), vault.RootTokenSecret, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get secret %s: %v", vault.RootTokenSecret, err)
+	}
+
+	return string(secret.Data["token"])
+}
+
+func TestRekeyUnsealsAfterRestart(t *testing.T) {
+	clientset := newClientset(t)
+
+	waitForUnsealed(t)
+	before := unsealKeys(t, clientset)
+
+	rekey := map[string]interface{}{"requester": "e2e", "shares": 3, "threshold": 2}
+	code, body, err := controllerRequest(clientset, http.MethodPost, "/admin/rekey", rekey)
+	if err != nil || code != http.StatusNoContent {
+		t.Fatalf("expected the rekey to succeed, got %d %s (%v)", code, body, err)
+	}
+
+	after := unsealKeys(t, clientset)
+	if len(after.Data) != 3 || after.Annotations[vault.ThresholdAnnotation] != "2" {
+		t.Fatalf("expected 3 new key shares with a threshold of 2, got %d with %q", len(after.Data), after.Annotations[vault.ThresholdAnnotation])
+	}
+	for name, key := range after.Data {
+		for _, old := range before.Data {
+			if string(key) == string(old) {
+				t.Errorf("expected %s to be a new key share", name)
+			}
+		}
+	}
+
+	// Only the new keys can unseal the replacement pod
+	restartVault(t, clientset)
+	waitForUnsealed(t)
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	clientset := newClientset(t)
+
+	waitForUnsealed(t)
+	token := rootToken(t, clientset)
+
+	// The controller takes snapshots with the root token once it is mounted
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: backupTokenSecret, Namespace: namespace},
+		StringData: map[string]string{"token": token},
+	}
+	if _, err := clientset.CoreV1().Secrets(namespace).Create(context.Background(), secret, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		t.Fatalf("failed to create secret %s: %v", backupTokenSecret, err)
+	}
+
+	if _, err := vaultCLI(token, "secrets", "enable", "-path=e2e", "kv"); err != nil && !strings.Contains(err.Error(), "path is already in use") {
+		t.Fatal(err)
+	}
+	if _, err := vaultCLI(token, "kv", "put", "e2e/marker", "value=before"); err != nil {
+		t.Fatal(err)
+	}
+	written := time.Now()
+
+	var key string
+	waitForWithin(t, backupTimeout, "a raft snapshot taken after the marker was written", func() (bool, error) {
+		code, body, err := controllerRequest(clientset, http.MethodGet, "/status/backups", nil)
+		if err != nil || code != http.StatusOK {
+			return false, fmt.Errorf("got %d %s (%v)", code, body, err)
+		}
+		var backups struct {
+			Snapshots []backup.Entry `json:"snapshots"`
+		}
+		if err := json.Unmarshal(body, &backups); err != nil {
+			return false, err
+		}
+		if len(backups.Snapshots) == 0 || !backups.Snapshots[0].TakenAt.After(written) {
+			return false, fmt.Errorf("%d snapshots, none taken after %v", len(backups.Snapshots), written)
+		}
+		key = backups.Snapshots[0].Key
+
+		return true, nil
+	})
+
+	if _, err := vaultCLI(token, "kv", "put", "e2e/marker", "value=after"); err != nil {
+		t.Fatal(err)
+	}
+
+	restore := map[string]interface{}{"requester": "e2e", "key": key, "confirm": key}
+	code, body, err := controllerRequest(clientset, http.MethodPost, "/admin/restore", restore)
+	if err != nil || code != http.StatusNoContent {
+		t.Fatalf("expected the restore of %s to succeed, got %d %s (%v)", key, code, body, err)
+	}
+
+	waitForUnsealed(t)
+	waitFor(t, "the marker to be restored", func() (bool, error) {
+		value, err := vaultCLI(token, "kv", "get", "-field=value", "e2e/marker")
+
+		return value == "before", err
+	})
+}